@@ -0,0 +1,260 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec // S3 ETags of single part objects are MD5.
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xxxsen/common/database"
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+var ErrInvalidTarget = errors.New("invalid access log target")
+
+const (
+	defaultFlushInterval = 5 * time.Minute
+	defaultMaxRecords    = 10000
+	shutdownFlushTimeout = 30 * time.Second
+	maxTargetPrefixBytes = 512
+	logObjectTimeLayout  = "2006-01-02-15-04-05"
+)
+
+// Target is where access logs of a bucket are written.
+type Target struct {
+	Bucket string
+	Prefix string
+}
+
+type Options struct {
+	// Buckets are the configured S3 buckets that may receive logs.
+	Buckets []string
+	// FlushInterval is how often buffered records are written out.
+	FlushInterval time.Duration
+	// MaxRecords starts an early flush once this many records are buffered.
+	MaxRecords int
+}
+
+type Manager struct {
+	db      database.IDatabase
+	files   filemgr.IFileManager
+	options Options
+	now     func() time.Time
+	flushes chan struct{}
+
+	mu       sync.Mutex
+	targets  map[string]Target
+	pending  map[string][]string
+	buffered int
+}
+
+// New loads the stored targets so Record can decide without a query whether
+// a bucket is logged.
+func New(ctx context.Context, db database.IDatabase, files filemgr.IFileManager, options Options) (*Manager, error) {
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = defaultFlushInterval
+	}
+	if options.MaxRecords <= 0 {
+		options.MaxRecords = defaultMaxRecords
+	}
+	manager := &Manager{
+		db:      db,
+		files:   files,
+		options: options,
+		now:     time.Now,
+		flushes: make(chan struct{}, 1),
+		targets: make(map[string]Target),
+		pending: make(map[string][]string),
+	}
+	rows, err := db.QueryContext(ctx, `SELECT bucket, target_bucket, target_prefix FROM tg_s3_logging_tab`)
+	if err != nil {
+		return nil, fmt.Errorf("query access log targets: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var bucket string
+		var target Target
+		if err := rows.Scan(&bucket, &target.Bucket, &target.Prefix); err != nil {
+			return nil, fmt.Errorf("scan access log target: %w", err)
+		}
+		manager.targets[bucket] = target
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read access log targets: %w", err)
+	}
+	return manager, nil
+}
+
+// Target reports where logs of bucket are written.
+func (m *Manager) Target(bucket string) (Target, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	target, exists := m.targets[bucket]
+	return target, exists
+}
+
+// SetTarget enables logging of bucket into target, or disables it when target
+// is nil. Records already buffered for a disabled bucket are dropped.
+func (m *Manager) SetTarget(ctx context.Context, bucket string, target *Target) error {
+	if target == nil {
+		if _, err := m.db.ExecContext(ctx, `DELETE FROM tg_s3_logging_tab WHERE bucket = ?`, bucket); err != nil {
+			return fmt.Errorf("delete access log target: %w", err)
+		}
+		m.mu.Lock()
+		delete(m.targets, bucket)
+		m.buffered -= len(m.pending[bucket])
+		delete(m.pending, bucket)
+		m.mu.Unlock()
+		return nil
+	}
+	if err := m.validate(target); err != nil {
+		return err
+	}
+	now := m.now().UnixMilli()
+	if _, err := m.db.ExecContext(
+		ctx,
+		`INSERT INTO tg_s3_logging_tab (bucket, target_bucket, target_prefix, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(bucket) DO UPDATE SET
+target_bucket = excluded.target_bucket, target_prefix = excluded.target_prefix, updated_at = excluded.updated_at`,
+		bucket,
+		target.Bucket,
+		target.Prefix,
+		now,
+		now,
+	); err != nil {
+		return fmt.Errorf("save access log target: %w", err)
+	}
+	m.mu.Lock()
+	m.targets[bucket] = *target
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Manager) validate(target *Target) error {
+	if !slices.Contains(m.options.Buckets, target.Bucket) {
+		return fmt.Errorf("%w: target bucket %q is not configured", ErrInvalidTarget, target.Bucket)
+	}
+	prefix := target.Prefix
+	if len(prefix) > maxTargetPrefixBytes || strings.HasPrefix(prefix, "/") || strings.Contains(prefix, "//") ||
+		slices.Contains(strings.Split(prefix, "/"), "..") {
+		return fmt.Errorf("%w: target prefix %q is invalid", ErrInvalidTarget, prefix)
+	}
+	return nil
+}
+
+// Record buffers record when its bucket has logging enabled.
+func (m *Manager) Record(record *Record) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.targets[record.Bucket]; !exists {
+		return
+	}
+	m.pending[record.Bucket] = append(m.pending[record.Bucket], record.line())
+	m.buffered++
+	if m.buffered >= m.options.MaxRecords {
+		select {
+		case m.flushes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run flushes buffered records every FlushInterval, and once more when ctx
+// is cancelled.
+func (m *Manager) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.options.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushContext, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownFlushTimeout)
+			m.flush(flushContext)
+			cancel()
+			return fmt.Errorf("run access log worker: %w", ctx.Err())
+		case <-ticker.C:
+		case <-m.flushes:
+		}
+		m.flush(ctx)
+	}
+}
+
+// flush writes one log object per logged bucket. A failed write is logged
+// and its records are dropped, so a broken target cannot grow the buffer.
+func (m *Manager) flush(ctx context.Context) {
+	m.mu.Lock()
+	pending := m.pending
+	targets := make(map[string]Target, len(pending))
+	for bucket := range pending {
+		targets[bucket] = m.targets[bucket]
+	}
+	m.pending = make(map[string][]string)
+	m.buffered = 0
+	m.mu.Unlock()
+	buckets := make([]string, 0, len(pending))
+	for bucket := range pending {
+		buckets = append(buckets, bucket)
+	}
+	slices.Sort(buckets)
+	for _, bucket := range buckets {
+		lines := pending[bucket]
+		if err := m.write(ctx, targets[bucket], strings.Join(lines, "")); err != nil {
+			logutil.GetLogger(ctx).Warn(
+				"write S3 access log failed",
+				zap.String("bucket", bucket),
+				zap.Int("records", len(lines)),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+func (m *Manager) write(ctx context.Context, target Target, content string) error {
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return fmt.Errorf("generate access log key: %w", err)
+	}
+	key := target.Prefix + m.now().UTC().Format(logObjectTimeLayout) + "-" +
+		strings.ToUpper(hex.EncodeToString(suffix[:]))
+	data := []byte(content)
+	fileID, err := m.files.CreateFile(ctx, int64(len(data)), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("store access log %q: %w", key, err)
+	}
+	md5Sum := md5.Sum(data) //nolint:gosec // S3 ETags of single part objects are MD5.
+	sha256Sum := sha256.Sum256(data)
+	if _, err := m.files.PublishS3Object(
+		ctx,
+		path.Join("/"+target.Bucket, key),
+		fileID,
+		int64(len(data)),
+		&entity.S3ObjectMetadata{
+			ETag:           `"` + hex.EncodeToString(md5Sum[:]) + `"`,
+			ChecksumSHA256: base64.StdEncoding.EncodeToString(sha256Sum[:]),
+			ContentType:    "text/plain",
+			CacheControl:   "no-cache",
+			UserMetadata:   "{}",
+		},
+		nil,
+	); err != nil {
+		_ = m.files.DiscardUnpublishedFile(ctx, fileID)
+		return fmt.Errorf("publish access log %q: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package accesslog
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	bucketOwner    = "tgfile"
+	recordTimeForm = "02/Jan/2006:15:04:05 -0700"
+)
+
+// Record is one request in S3 server access log format. Empty strings and
+// negative sizes are written as "-".
+type Record struct {
+	Bucket           string
+	Time             time.Time
+	RemoteIP         string
+	Requester        string
+	RequestID        string
+	Operation        string
+	Key              string
+	RequestURI       string
+	Status           int
+	ErrorCode        string
+	BytesSent        int64
+	ObjectSize       int64
+	TotalTime        time.Duration
+	Referer          string
+	UserAgent        string
+	SignatureVersion string
+	CipherSuite      string
+	AuthType         string
+	Host             string
+	TLSVersion       string
+}
+
+// line formats the record as one log line, fields in the documented S3 order.
+func (r *Record) line() string {
+	fields := []string{
+		bucketOwner,
+		field(r.Bucket),
+		"[" + r.Time.UTC().Format(recordTimeForm) + "]",
+		field(r.RemoteIP),
+		field(r.Requester),
+		field(r.RequestID),
+		field(r.Operation),
+		field(encodeKey(r.Key)),
+		quoted(r.RequestURI),
+		strconv.Itoa(r.Status),
+		field(r.ErrorCode),
+		size(r.BytesSent),
+		size(r.ObjectSize),
+		strconv.FormatInt(r.TotalTime.Milliseconds(), 10),
+		"-",
+		quoted(r.Referer),
+		quoted(r.UserAgent),
+		"-",
+		"-",
+		field(r.SignatureVersion),
+		field(r.CipherSuite),
+		field(r.AuthType),
+		field(r.Host),
+		field(r.TLSVersion),
+		"-",
+		"-",
+	}
+	return strings.Join(fields, " ") + "\n"
+}
+
+func field(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+func quoted(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return `"` + strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f || r == '"' {
+			return '_'
+		}
+		return r
+	}, value) + `"`
+}
+
+func size(value int64) string {
+	if value < 0 {
+		return "-"
+	}
+	return strconv.FormatInt(value, 10)
+}
+
+func encodeKey(key string) string {
+	return strings.ReplaceAll(url.QueryEscape(key), "%2F", "/")
+}
@@ -0,0 +1,350 @@
+// Package archive streams a directory tree, or a list of files, as a ZIP or
+// TAR archive. The tree is listed and checked against the limits before the
+// first byte is written, so a request that is too large fails with a normal
+// error response; file content is then read one file at a time and never
+// buffered.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+const (
+	DefaultMaxEntries       = 10_000
+	DefaultMaxBytes   int64 = 10 * 1024 * 1024 * 1024
+)
+
+var (
+	ErrInvalidFormat  = errors.New("unsupported archive format")
+	ErrTooManyEntries = errors.New("archive exceeds the entry limit")
+	ErrTooLarge       = errors.New("archive exceeds the byte limit")
+)
+
+type Format string
+
+const (
+	FormatZIP Format = "zip"
+	FormatTAR Format = "tar"
+)
+
+// ParseFormat accepts "zip" and "tar"; an empty value selects ZIP.
+func ParseFormat(value string) (Format, error) {
+	switch Format(strings.ToLower(value)) {
+	case "", FormatZIP:
+		return FormatZIP, nil
+	case FormatTAR:
+		return FormatTAR, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidFormat, value)
+	}
+}
+
+func (f Format) ContentType() string {
+	if f == FormatTAR {
+		return "application/x-tar"
+	}
+	return "application/zip"
+}
+
+// Limits bound one archive. Directories count as entries; MaxBytes bounds
+// the sum of the file sizes.
+type Limits struct {
+	MaxEntries int
+	MaxBytes   int64
+}
+
+// WithDefaults replaces unset limits with DefaultMaxEntries and
+// DefaultMaxBytes.
+func (l Limits) WithDefaults() Limits {
+	if l.MaxEntries <= 0 {
+		l.MaxEntries = DefaultMaxEntries
+	}
+	if l.MaxBytes <= 0 {
+		l.MaxBytes = DefaultMaxBytes
+	}
+	return l
+}
+
+// Check reports whether a prepared list of entries fits the limits.
+func (l Limits) Check(entries []Entry) error {
+	l = l.WithDefaults()
+	if len(entries) > l.MaxEntries {
+		return ErrTooManyEntries
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+	if total > l.MaxBytes {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// Entry is one member of an archive. Name is slash separated and relative;
+// directory names end with a slash.
+type Entry struct {
+	Name   string
+	FileID uint64
+	Size   int64
+	Mtime  int64
+	IsDir  bool
+}
+
+type Walker interface {
+	WalkFileLink(ctx context.Context, prefix string, cb filemgr.WalkLinkFunc) error
+}
+
+type Opener interface {
+	OpenFile(ctx context.Context, fileid uint64) (io.ReadSeekCloser, error)
+}
+
+// IncludeFunc reports whether a link goes into the archive. A directory it
+// rejects is left out with its whole subtree.
+type IncludeFunc func(ctx context.Context, link string, item *entity.FileLinkMeta) (bool, error)
+
+type planner struct {
+	walker  Walker
+	limits  Limits
+	include IncludeFunc
+	entries []Entry
+	bytes   int64
+}
+
+// Plan lists the tree below the directory root depth first, in name order,
+// with every member placed below folder. A nil include keeps every link.
+func Plan(
+	ctx context.Context,
+	walker Walker,
+	root, folder string,
+	limits Limits,
+	include IncludeFunc,
+) ([]Entry, error) {
+	p := &planner{walker: walker, limits: limits.WithDefaults(), include: include}
+	if folder != "" {
+		if err := p.add(Entry{Name: folder + "/", IsDir: true}); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.walk(ctx, root, folder); err != nil {
+		return nil, err
+	}
+	return p.entries, nil
+}
+
+func (p *planner) walk(ctx context.Context, dir, folder string) error {
+	type child struct {
+		link string
+		item *entity.FileLinkMeta
+	}
+	children := make([]child, 0)
+	if err := p.walker.WalkFileLink(ctx, dir, func(
+		ctx context.Context,
+		link string,
+		item *entity.FileLinkMeta,
+	) (bool, error) {
+		if len(p.entries)+len(children) >= p.limits.MaxEntries {
+			return false, ErrTooManyEntries
+		}
+		if p.include != nil {
+			included, err := p.include(ctx, link, item)
+			if err != nil {
+				return false, err
+			}
+			if !included {
+				return true, nil
+			}
+		}
+		children = append(children, child{link: link, item: item})
+		return true, nil
+	}); err != nil {
+		return fmt.Errorf("list archive directory %q: %w", dir, err)
+	}
+	slices.SortFunc(children, func(left, right child) int {
+		return strings.Compare(left.item.FileName, right.item.FileName)
+	})
+	for _, child := range children {
+		name := path.Join(folder, child.item.FileName)
+		entry := Entry{Name: name, FileID: child.item.FileId, Size: child.item.FileSize, Mtime: child.item.Mtime}
+		if child.item.IsDir {
+			entry = Entry{Name: name + "/", Mtime: child.item.Mtime, IsDir: true}
+		}
+		if err := p.add(entry); err != nil {
+			return err
+		}
+		if !child.item.IsDir {
+			continue
+		}
+		if err := p.walk(ctx, child.link, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *planner) add(entry Entry) error {
+	if len(p.entries) >= p.limits.MaxEntries {
+		return ErrTooManyEntries
+	}
+	p.bytes += entry.Size
+	if p.bytes > p.limits.MaxBytes {
+		return ErrTooLarge
+	}
+	p.entries = append(p.entries, entry)
+	return nil
+}
+
+// Serve answers a GET or HEAD with the archive of entries as the attachment
+// filename. Once the status is sent a failure can no longer be reported, so
+// Serve then aborts the connection to keep the client from taking a
+// truncated archive for a complete one.
+func Serve(
+	w http.ResponseWriter,
+	r *http.Request,
+	filename string,
+	format Format,
+	opener Opener,
+	entries []Entry,
+) error {
+	header := w.Header()
+	header.Set("Content-Type", format.ContentType())
+	header.Set("Cache-Control", "private, no-store")
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	header.Set("Content-Disposition", disposition)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	if err := Write(r.Context(), w, format, opener, entries); err != nil {
+		if conn, _, hijackErr := http.NewResponseController(w).Hijack(); hijackErr == nil {
+			_ = conn.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+// Write streams entries as an archive. ZIP members are stored without
+// compression and switch to ZIP64 records when they need to; TAR members use
+// PAX records for long names and large files.
+func Write(ctx context.Context, w io.Writer, format Format, opener Opener, entries []Entry) error {
+	switch format {
+	case FormatZIP:
+		return writeZIP(ctx, w, opener, entries)
+	case FormatTAR:
+		return writeTAR(ctx, w, opener, entries)
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidFormat, format)
+	}
+}
+
+func writeZIP(ctx context.Context, w io.Writer, opener Opener, entries []Entry) error {
+	archive := zip.NewWriter(w)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.Name, Method: zip.Store, Modified: time.UnixMilli(entry.Mtime)}
+		if entry.IsDir {
+			header.SetMode(fs.ModeDir | 0o755)
+		} else {
+			header.SetMode(0o644)
+		}
+		member, err := archive.CreateHeader(header)
+		if err != nil {
+			return fmt.Errorf("write zip header %q: %w", entry.Name, err)
+		}
+		if err := copyEntry(ctx, member, opener, entry); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("finish zip archive: %w", err)
+	}
+	return nil
+}
+
+func writeTAR(ctx context.Context, w io.Writer, opener Opener, entries []Entry) error {
+	archive := tar.NewWriter(w)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     entry.Name,
+			Mode:     0o644,
+			Size:     entry.Size,
+			ModTime:  time.UnixMilli(entry.Mtime).Truncate(time.Second),
+			Format:   tar.FormatPAX,
+		}
+		if entry.IsDir {
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0o755
+			header.Size = 0
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return fmt.Errorf("write tar header %q: %w", entry.Name, err)
+		}
+		if err := copyEntry(ctx, archive, opener, entry); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("finish tar archive: %w", err)
+	}
+	return nil
+}
+
+func copyEntry(ctx context.Context, w io.Writer, opener Opener, entry Entry) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("archive canceled: %w", err)
+	}
+	if entry.IsDir || entry.Size == 0 {
+		return nil
+	}
+	stream, err := opener.OpenFile(ctx, entry.FileID)
+	if err != nil {
+		return fmt.Errorf("open archive member %q: %w", entry.Name, err)
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+	if _, err := io.CopyN(w, &contextReader{ctx: ctx, reader: stream}, entry.Size); err != nil {
+		return fmt.Errorf("copy archive member %q: %w", entry.Name, err)
+	}
+	return nil
+}
+
+// contextReader stops a copy as soon as the request is canceled, for
+// example because the client disconnected.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(buffer []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, fmt.Errorf("archive canceled: %w", err)
+	}
+	count, err := r.reader.Read(buffer)
+	if errors.Is(err, io.EOF) {
+		return count, io.EOF
+	}
+	if err != nil {
+		return count, fmt.Errorf("read archive member: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,190 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+type testTree struct {
+	children map[string][]*entity.FileLinkMeta
+	content  map[uint64][]byte
+}
+
+func newTestTree() *testTree {
+	tree := &testTree{
+		children: map[string][]*entity.FileLinkMeta{},
+		content:  map[uint64][]byte{},
+	}
+	tree.dir("/docs", "sub")
+	tree.dir("/docs", "empty")
+	tree.file("/docs", "b.txt", 1, "bravo")
+	tree.file("/docs", "a.txt", 2, "alpha")
+	tree.file("/docs/sub", "c.txt", 3, "charlie")
+	tree.file("/docs/sub", "zero.txt", 0, "")
+	return tree
+}
+
+func (t *testTree) dir(parent, name string) {
+	t.children[parent] = append(t.children[parent], &entity.FileLinkMeta{FileName: name, IsDir: true, Mtime: 1000})
+}
+
+func (t *testTree) file(parent, name string, fileID uint64, content string) {
+	t.children[parent] = append(t.children[parent], &entity.FileLinkMeta{
+		FileName: name, FileId: fileID, FileSize: int64(len(content)), Mtime: 1_700_000_000_000,
+	})
+	t.content[fileID] = []byte(content)
+}
+
+func (t *testTree) WalkFileLink(ctx context.Context, prefix string, cb filemgr.WalkLinkFunc) error {
+	for _, item := range t.children[prefix] {
+		next, err := cb(ctx, path.Join(prefix, item.FileName), item)
+		if err != nil {
+			return err
+		}
+		if !next {
+			break
+		}
+	}
+	return nil
+}
+
+func (t *testTree) OpenFile(_ context.Context, fileID uint64) (io.ReadSeekCloser, error) {
+	if fileID == 0 {
+		return nil, os.ErrNotExist
+	}
+	content, ok := t.content[fileID]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return nopSeekCloser{bytes.NewReader(content)}, nil
+}
+
+type nopSeekCloser struct {
+	*bytes.Reader
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
+
+func TestPlanListsTheTreeInNameOrder(t *testing.T) {
+	entries, err := Plan(t.Context(), newTestTree(), "/docs", "docs", Limits{}, nil)
+	require.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	require.Equal(t, []string{
+		"docs/", "docs/a.txt", "docs/b.txt", "docs/empty/", "docs/sub/", "docs/sub/c.txt", "docs/sub/zero.txt",
+	}, names)
+
+	entries, err = Plan(t.Context(), newTestTree(), "/docs", "", Limits{}, func(
+		_ context.Context,
+		link string,
+		_ *entity.FileLinkMeta,
+	) (bool, error) {
+		return link != "/docs/sub" && link != "/docs/b.txt", nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []Entry{
+		{Name: "a.txt", FileID: 2, Size: 5, Mtime: 1_700_000_000_000},
+		{Name: "empty/", Mtime: 1000, IsDir: true},
+	}, entries)
+}
+
+func TestPlanEnforcesLimits(t *testing.T) {
+	_, err := Plan(t.Context(), newTestTree(), "/docs", "docs", Limits{MaxEntries: 6}, nil)
+	require.ErrorIs(t, err, ErrTooManyEntries)
+	_, err = Plan(t.Context(), newTestTree(), "/docs", "docs", Limits{MaxEntries: 7}, nil)
+	require.NoError(t, err)
+	_, err = Plan(t.Context(), newTestTree(), "/docs", "docs", Limits{MaxBytes: 16}, nil)
+	require.ErrorIs(t, err, ErrTooLarge)
+	_, err = Plan(t.Context(), newTestTree(), "/docs", "docs", Limits{MaxBytes: 17}, nil)
+	require.NoError(t, err)
+
+	denied := errors.New("denied")
+	_, err = Plan(t.Context(), newTestTree(), "/docs", "docs", Limits{}, func(
+		context.Context, string, *entity.FileLinkMeta,
+	) (bool, error) {
+		return false, denied
+	})
+	require.ErrorIs(t, err, denied)
+}
+
+func TestWriteProducesReadableArchives(t *testing.T) {
+	tree := newTestTree()
+	entries, err := Plan(t.Context(), tree, "/docs", "docs", Limits{}, nil)
+	require.NoError(t, err)
+
+	var zipped bytes.Buffer
+	require.NoError(t, Write(t.Context(), &zipped, FormatZIP, tree, entries))
+	reader, err := zip.NewReader(bytes.NewReader(zipped.Bytes()), int64(zipped.Len()))
+	require.NoError(t, err)
+	require.Len(t, reader.File, len(entries))
+	zipContent := map[string]string{}
+	for _, member := range reader.File {
+		require.Equal(t, zip.Store, member.Method)
+		stream, err := member.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(stream)
+		require.NoError(t, err)
+		require.NoError(t, stream.Close())
+		zipContent[member.Name] = string(content)
+	}
+	require.Equal(t, "alpha", zipContent["docs/a.txt"])
+	require.Equal(t, "charlie", zipContent["docs/sub/c.txt"])
+	require.True(t, reader.File[3].FileInfo().IsDir())
+
+	var tarred bytes.Buffer
+	require.NoError(t, Write(t.Context(), &tarred, FormatTAR, tree, entries))
+	tarReader := tar.NewReader(&tarred)
+	tarContent := map[string]string{}
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(tarReader)
+		require.NoError(t, err)
+		tarContent[header.Name] = string(content)
+		if header.Name == "docs/b.txt" {
+			require.Equal(t, int64(1_700_000_000), header.ModTime.Unix())
+		}
+	}
+	require.Len(t, tarContent, len(entries))
+	require.Equal(t, "bravo", tarContent["docs/b.txt"])
+	require.Empty(t, tarContent["docs/sub/zero.txt"])
+}
+
+func TestWriteStopsWhenTheContextIsCanceled(t *testing.T) {
+	tree := newTestTree()
+	entries, err := Plan(t.Context(), tree, "/docs", "docs", Limits{}, nil)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	require.ErrorIs(t, Write(ctx, io.Discard, FormatZIP, tree, entries), context.Canceled)
+}
+
+func TestParseFormat(t *testing.T) {
+	for value, want := range map[string]Format{"": FormatZIP, "zip": FormatZIP, "TAR": FormatTAR} {
+		format, err := ParseFormat(value)
+		require.NoError(t, err)
+		require.Equal(t, want, format)
+	}
+	_, err := ParseFormat("rar")
+	require.ErrorIs(t, err, ErrInvalidFormat)
+	require.Equal(t, "application/x-tar", FormatTAR.ContentType())
+}
@@ -3,6 +3,7 @@ package authz
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 type Permission string
@@ -17,6 +18,7 @@ const (
 	AdminRead   Permission = "admin:read"
 	AdminWrite  Permission = "admin:write"
 	FileWrite   Permission = "file:write"
+	ShareWrite  Permission = "share:write"
 	AllRead     Permission = "all:read"
 	AllWrite    Permission = "all:write"
 )
@@ -48,6 +50,7 @@ var permissionClasses = map[Permission]permissionClass{
 	AdminRead:   classRead,
 	AdminWrite:  classWrite,
 	FileWrite:   classWrite,
+	ShareWrite:  classWrite,
 	AllRead:     classRead,
 	AllWrite:    classWrite,
 }
@@ -144,3 +147,32 @@ func (a *Authorizer) Level(
 	}
 	return LevelNone
 }
+
+// Scope narrows the grants of a principal, e.g. for temporary S3 credentials.
+// The zero value does not restrict anything.
+type Scope struct {
+	Bucket   string
+	Prefix   string
+	ReadOnly bool
+}
+
+func (s Scope) Permits(permission Permission) bool {
+	class, known := permissionClasses[permission]
+	if !known {
+		return false
+	}
+	return !s.ReadOnly || class == classRead
+}
+
+func (s Scope) Covers(bucket, key string) bool {
+	if s.Bucket != "" && s.Bucket != bucket {
+		return false
+	}
+	return strings.HasPrefix(key, s.Prefix)
+}
+
+// HasScoped grants permission only when both the parent principal and the
+// scope allow it.
+func (a *Authorizer) HasScoped(username string, scope Scope, permission Permission) bool {
+	return scope.Permits(permission) && a.Has(username, permission)
+}
@@ -15,6 +15,7 @@ func TestAuthorizerPermissionClosure(t *testing.T) {
 		"s3-writer":     {string(S3Write)},
 		"writer":        {string(AllWrite)},
 		"file-writer":   {string(FileWrite)},
+		"sharer":        {string(ShareWrite)},
 		"explicit":      {string(AdminRead)},
 		"no-permission": {},
 	})
@@ -32,6 +33,10 @@ func TestAuthorizerPermissionClosure(t *testing.T) {
 	require.True(t, authorizer.Has("writer", AllWrite))
 	require.True(t, authorizer.Has("file-writer", FileWrite))
 	require.False(t, authorizer.Has("file-writer", S3Read))
+	require.True(t, authorizer.Has("writer", ShareWrite))
+	require.True(t, authorizer.Has("sharer", ShareWrite))
+	require.False(t, authorizer.Has("sharer", FileWrite))
+	require.False(t, authorizer.Has("reader", ShareWrite))
 	require.False(t, authorizer.Has("unknown", S3Read))
 	require.False(t, authorizer.Has("reader", Permission("future:read")))
 }
@@ -148,3 +153,24 @@ func TestAuthorizerSupportsConcurrentReads(t *testing.T) {
 	}
 	workers.Wait()
 }
+
+func TestScopedPermissionIsIntersection(t *testing.T) {
+	t.Parallel()
+
+	authorizer, err := New(map[string][]string{
+		"writer": {string(S3Write)},
+		"reader": {string(S3Read)},
+	})
+	require.NoError(t, err)
+	readOnly := Scope{Bucket: "data", Prefix: "ci/", ReadOnly: true}
+	require.True(t, authorizer.HasScoped("writer", readOnly, S3Read))
+	require.False(t, authorizer.HasScoped("writer", readOnly, S3Write))
+	require.True(t, authorizer.HasScoped("writer", Scope{}, S3Write))
+	require.False(t, authorizer.HasScoped("reader", Scope{}, S3Write))
+	require.False(t, authorizer.HasScoped("writer", Scope{}, WebDAVRead))
+
+	require.True(t, readOnly.Covers("data", "ci/build.log"))
+	require.False(t, readOnly.Covers("data", "other/build.log"))
+	require.False(t, readOnly.Covers("other", "ci/build.log"))
+	require.True(t, Scope{}.Covers("any", "key"))
+}
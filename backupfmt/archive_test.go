@@ -200,6 +200,22 @@ func TestValidateManifestBoundaries(t *testing.T) {
 			ErrInvalidArchive,
 		)
 	})
+	t.Run("file key without mapping", func(t *testing.T) {
+		t.Parallel()
+		manifest := testManifest()
+		manifest.FileKeys = []FileKey{{
+			Digest: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+			Kind:   "token",
+			Link:   "/bucket/missing.txt",
+		}}
+		require.ErrorIs(
+			t,
+			ValidateManifest(&manifest, testLimits(), 20*1024*1024),
+			ErrInvalidArchive,
+		)
+		manifest.FileKeys[0].Link = "/bucket/hello.txt"
+		require.NoError(t, ValidateManifest(&manifest, testLimits(), 20*1024*1024))
+	})
 	t.Run("invalid mode", func(t *testing.T) {
 		t.Parallel()
 		manifest := testManifest()
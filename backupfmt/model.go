@@ -26,6 +26,7 @@ type Manifest struct {
 	Mappings         []Mapping        `json:"mappings"`
 	S3Objects        []S3Object       `json:"s3_objects"`
 	WebDAVProperties []WebDAVProperty `json:"webdav_properties"`
+	FileKeys         []FileKey        `json:"file_keys"`
 }
 
 type Source struct {
@@ -127,6 +128,19 @@ type WebDAVProperty struct {
 	Mtime        int64  `json:"mtime"`
 }
 
+// FileKey is a row of the direct upload key table. It carries the digest of
+// the key, never the key itself, so a restored key resolves to the same
+// mapping with the same owner and revocation state.
+type FileKey struct {
+	Digest         string `json:"digest"`
+	Kind           string `json:"kind"`
+	Link           string `json:"link"`
+	ReplacesDigest string `json:"replaces_digest"`
+	Owner          string `json:"owner"`
+	CreatedAt      int64  `json:"created_at"`
+	RevokedAt      int64  `json:"revoked_at"`
+}
+
 type Limits struct {
 	MaxArchiveBytes  int64
 	MaxExpandedBytes int64
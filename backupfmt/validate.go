@@ -112,7 +112,10 @@ func validateProtocolMetadata(
 	); err != nil {
 		return err
 	}
-	return validateWebDAVProperties(properties, mappings, directories, limits)
+	if err := validateWebDAVProperties(properties, mappings, directories, limits); err != nil {
+		return err
+	}
+	return validateFileKeys(manifest.FileKeys, mappings, limits)
 }
 
 func validateManifestSummary(manifest *Manifest, partCount, physicalBytes int64) error {
@@ -139,6 +142,9 @@ func validateCounts(manifest *Manifest, limits Limits) error {
 	if len(manifest.Directories) > limits.MaxMappingCount {
 		return limitExceeded("directory count")
 	}
+	if len(manifest.FileKeys) > limits.MaxMappingCount {
+		return limitExceeded("file key count")
+	}
 	return nil
 }
 
@@ -616,6 +622,42 @@ func validateWebDAVProperties(
 	return nil
 }
 
+func validateFileKeys(items []FileKey, mappings map[string]struct{}, limits Limits) error {
+	replaced := make(map[string]struct{}, len(items))
+	lastDigest := ""
+	for _, item := range items {
+		if !isHex(item.Digest, 32) {
+			return invalidArchive("file key digest is invalid")
+		}
+		if item.Digest <= lastDigest {
+			return invalidArchive("file keys are not in canonical order")
+		}
+		lastDigest = item.Digest
+		if item.Kind != "legacy" && item.Kind != "token" {
+			return invalidArchive("file key kind is invalid")
+		}
+		if _, exists := mappings[item.Link]; !exists {
+			return invalidArchive("file key link has no mapping")
+		}
+		if item.ReplacesDigest != "" {
+			if !isHex(item.ReplacesDigest, 32) {
+				return invalidArchive("replaced file key digest is invalid")
+			}
+			if _, exists := replaced[item.ReplacesDigest]; exists {
+				return invalidArchive("replaced file key digest is duplicated")
+			}
+			replaced[item.ReplacesDigest] = struct{}{}
+		}
+		if len(item.Owner) > limits.MaxPathBytes || containsControl(item.Owner) {
+			return invalidArchive("file key owner is invalid")
+		}
+		if item.CreatedAt < 0 || item.RevokedAt < 0 {
+			return invalidArchive("file key timestamps are invalid")
+		}
+	}
+	return nil
+}
+
 func validateXMLFragment(value string) error {
 	decoder := xml.NewDecoder(strings.NewReader("<wrapper>" + value + "</wrapper>"))
 	for {
@@ -1275,6 +1275,8 @@ func (m *Manager) publishImport(
 	job *Job,
 	manifest *backupfmt.Manifest,
 ) error {
+	// The principal that started the import owns what it publishes.
+	ctx = filemgr.ContextWithOwner(ctx, job.Owner)
 	if _, err := m.files.PublishBackupImport(ctx, job.JobID, manifest, job.Conflict); err != nil {
 		return fmt.Errorf("publish backup import: %w", err)
 	}
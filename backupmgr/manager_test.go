@@ -784,6 +784,65 @@ SELECT COUNT(*) FROM tg_s3_completed_part_tab WHERE file_id = ?`, restoredObject
 	require.Equal(t, allContent, restored)
 }
 
+func TestFileKeysRoundTripWithOwnerAndRevocation(t *testing.T) {
+	sourceDB, sourceFiles := newBackupTestStorage(t, 4)
+	content := []byte("keyed")
+	issue := func(owner, name string) string {
+		fileID, err := sourceFiles.CreateFile(t.Context(), int64(len(content)), bytes.NewReader(content))
+		require.NoError(t, err)
+		key, err := sourceFiles.CreateFileKey(t.Context(), owner, name, fileID, int64(len(content)))
+		require.NoError(t, err)
+		return key
+	}
+	kept := issue("uploader", "kept.txt")
+	revoked := issue("", "revoked.txt")
+	require.NoError(t, sourceFiles.RevokeFileKey(t.Context(), revoked))
+	keptLink, err := sourceFiles.ResolveFileKey(t.Context(), kept)
+	require.NoError(t, err)
+
+	sourceManager := newBackupTestManager(t, sourceDB, sourceFiles, filepath.Join(t.TempDir(), "source"))
+	exportJob, err := sourceManager.CreateExport(t.Context(), backupmgr.CreateExportRequest{
+		Owner: "operator", IdempotencyKey: "file-key-export", Scope: "/",
+	})
+	require.NoError(t, err)
+	_, err = sourceManager.ProcessUntilTerminal(t.Context(), exportJob.JobID)
+	require.NoError(t, err)
+	artifact, _, err := sourceManager.Artifact(t.Context(), exportJob.JobID)
+	require.NoError(t, err)
+	manifest, _, err := backupfmt.VerifyFile(t.Context(), artifact, backupfmt.DefaultLimits(), 4)
+	require.NoError(t, err)
+	require.Len(t, manifest.FileKeys, 2)
+	for _, key := range manifest.FileKeys {
+		require.Len(t, key.Digest, 64)
+		require.NotContains(t, key.Digest+key.Link, kept)
+	}
+
+	targetDB, targetFiles := newBackupTestStorage(t, 4)
+	targetManager := newBackupTestManager(t, targetDB, targetFiles, filepath.Join(t.TempDir(), "target"))
+	raw, err := os.ReadFile(artifact)
+	require.NoError(t, err)
+	importJob, err := targetManager.CreateImport(t.Context(), backupmgr.CreateImportRequest{
+		Owner: "operator", IdempotencyKey: "file-key-import", ConflictPolicy: "fail",
+		ContentLength: int64(len(raw)), ArtifactSHA256: fileSHA256(t, artifact),
+		Body: bytes.NewReader(raw),
+	})
+	require.NoError(t, err)
+	_, err = targetManager.ProcessUntilTerminal(t.Context(), importJob.JobID)
+	require.NoError(t, err)
+
+	link, err := targetFiles.ResolveFileKey(t.Context(), kept)
+	require.NoError(t, err)
+	require.Equal(t, keptLink, link)
+	restored, err := targetFiles.StatFileLink(t.Context(), link)
+	require.NoError(t, err)
+	require.Equal(t, "uploader", restored.Owner)
+	_, err = targetFiles.ResolveFileKey(t.Context(), revoked)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.ErrorIs(t, targetFiles.DeleteFileKey(t.Context(), kept, "someone-else"), filemgr.ErrFileKeyNotOwned)
+	require.Equal(t, "uploader", queryString(t, targetDB,
+		"SELECT owner FROM tg_file_key_tab WHERE link_path = ?", link))
+}
+
 func createBackupMultipartPart(
 	t *testing.T,
 	files filemgr.IFileManager,
@@ -27,6 +27,7 @@ func newAuditCommand(ctx context.Context) *cobra.Command {
 			report, err := maintenance.AuditWithOptions(ctx, auditConfig.DatabaseFile, maintenance.AuditOptions{
 				S3Buckets:      auditConfig.S3Buckets,
 				BackendKind:    auditConfig.BackendKind,
+				TierKinds:      auditConfig.TierKinds,
 				BackupWorkDir:  auditConfig.BackupWorkDir,
 				TelegramBotID:  auditConfig.TelegramBotID,
 				TelegramChatID: auditConfig.TelegramChatID,
@@ -21,6 +21,7 @@ import (
 	"github.com/xxxsen/tgfile/backupmgr"
 	"github.com/xxxsen/tgfile/config"
 	"github.com/xxxsen/tgfile/db"
+	"github.com/xxxsen/tgfile/filemgr"
 )
 
 func newBackupCommand(ctx context.Context) *cobra.Command {
@@ -169,41 +170,53 @@ func openBackupRuntime(
 	ctx context.Context,
 	configFile string,
 ) (*backupmgr.Manager, func(), error) {
+	serviceConfig, managerFiles, closeRuntime, err := openFileRuntime(ctx, configFile)
+	if err != nil {
+		return nil, func() {}, err
+	}
+	manager, err := backupmgr.New(
+		db.GetClient(),
+		managerFiles,
+		toBackupManagerOptions(serviceConfig, managerFiles.BackupMaxPartSize()),
+	)
+	if err != nil {
+		closeRuntime()
+		return nil, func() {}, fmt.Errorf("create backup manager: %w", err)
+	}
+	return manager, closeRuntime, nil
+}
+
+// openFileRuntime opens the database and file manager of configFile for a
+// one-shot command. The returned function closes both.
+func openFileRuntime(
+	ctx context.Context,
+	configFile string,
+) (*config.Config, filemgr.IFileManager, func(), error) {
 	serviceConfig, err := config.Parse(configFile)
 	if err != nil {
-		return nil, func() {}, fmt.Errorf("parse config: %w", err)
+		return nil, nil, func() {}, fmt.Errorf("parse config: %w", err)
 	}
 	if err := serviceConfig.Validate(); err != nil {
-		return nil, func() {}, fmt.Errorf("validate config: %w", err)
+		return nil, nil, func() {}, fmt.Errorf("validate config: %w", err)
 	}
 	if err := idgen.Init(1); err != nil {
-		return nil, func() {}, fmt.Errorf("init id generator: %w", err)
+		return nil, nil, func() {}, fmt.Errorf("init id generator: %w", err)
 	}
 	if err := db.InitDBContext(ctx, serviceConfig.DBFile); err != nil {
-		return nil, func() {}, fmt.Errorf("open backup database: %w", err)
+		return nil, nil, func() {}, fmt.Errorf("open database: %w", err)
 	}
-	closeRuntime := func() { _ = db.Close() }
 	managerFiles, ioCache, err := buildFileManager(ctx, serviceConfig)
 	if err != nil {
-		closeRuntime()
-		return nil, func() {}, err
+		_ = db.Close()
+		return nil, nil, func() {}, err
 	}
-	closeRuntime = func() {
+	closeRuntime := func() {
 		closeContext, cancel := context.WithTimeout(context.WithoutCancel(ctx), cacheShutdownTimeout)
 		defer cancel()
 		_ = ioCache.Close(closeContext)
 		_ = db.Close()
 	}
-	manager, err := backupmgr.New(
-		db.GetClient(),
-		managerFiles,
-		toBackupManagerOptions(serviceConfig, managerFiles.BackupMaxPartSize()),
-	)
-	if err != nil {
-		closeRuntime()
-		return nil, func() {}, fmt.Errorf("create backup manager: %w", err)
-	}
-	return manager, closeRuntime, nil
+	return serviceConfig, managerFiles, closeRuntime, nil
 }
 
 func cliIdempotencyKey(kind string) string {
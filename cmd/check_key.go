@@ -5,21 +5,21 @@ import (
 
 	"github.com/spf13/cobra"
 
-	filehandler "github.com/xxxsen/tgfile/server/handler/file"
+	"github.com/xxxsen/tgfile/filekey"
 )
 
 func newCheckKeyCommand() *cobra.Command {
 	var key string
 	command := &cobra.Command{
 		Use:   "check-key",
-		Short: "Validate a direct-download file key",
+		Short: "Validate a legacy or token direct-download file key and print its link",
 		Args:  noPositionalArgs,
 		RunE: func(command *cobra.Command, _ []string) error {
-			link, err := filehandler.ExtractLinkFromFileKey(key)
+			parsed, err := filekey.Parse(key)
 			if err != nil {
 				return commandError(fmt.Errorf("validate file key: %w", err))
 			}
-			fmt.Fprintln(command.OutOrStdout(), link)
+			fmt.Fprintln(command.OutOrStdout(), parsed.Link())
 			return nil
 		},
 	}
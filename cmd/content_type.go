@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newContentTypeCommand(ctx context.Context) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "content-type",
+		Short: "Manage the content types stored for mappings",
+		Args:  noPositionalArgs,
+		RunE: func(*cobra.Command, []string) error {
+			return usageError("a content-type subcommand is required")
+		},
+	}
+	command.AddCommand(newContentTypeBackfillCommand(ctx))
+	return command
+}
+
+// newContentTypeBackfillCommand stores content types on mappings written
+// before they were stored. Mappings that have one are left alone, so the
+// command can be rerun after an interruption.
+func newContentTypeBackfillCommand(ctx context.Context) *cobra.Command {
+	var configFile string
+	var extensionOnly bool
+	command := &cobra.Command{
+		Use:   "backfill",
+		Short: "Assign content types to mappings created before they were stored",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			_, manager, closeRuntime, err := openFileRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			result, err := manager.BackfillContentTypes(ctx, !extensionOnly)
+			if err != nil {
+				return fmt.Errorf("backfill content types: %w", err)
+			}
+			return writeCommandJSON(command, result)
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().BoolVar(&extensionOnly, "extension-only", false,
+		"derive types from file names without downloading the leading bytes of unsniffed files")
+	return command
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+type fileKeyMigrationOutput struct {
+	LegacyKey string `json:"legacy_key"`
+	Key       string `json:"key"`
+}
+
+type fileKeyRevokeOutput struct {
+	Revoked bool `json:"revoked"`
+}
+
+func newFileKeyCommand(ctx context.Context) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "file-keys",
+		Short: "Migrate or revoke direct-download file keys",
+		Args:  noPositionalArgs,
+		RunE: func(*cobra.Command, []string) error {
+			return usageError("a file-keys subcommand is required")
+		},
+	}
+	command.AddCommand(
+		newFileKeyMigrateCommand(ctx),
+		newFileKeyRevokeCommand(ctx),
+	)
+	return command
+}
+
+func newFileKeyMigrateCommand(ctx context.Context) *cobra.Command {
+	var configFile string
+	command := &cobra.Command{
+		Use:   "migrate",
+		Short: "Issue a token key for every legacy key and print the pairs",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			_, manager, closeRuntime, err := openFileRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			items := make([]fileKeyMigrationOutput, 0)
+			if _, err := manager.MigrateLegacyFileKeys(ctx, func(_ context.Context, legacyKey, key string) error {
+				items = append(items, fileKeyMigrationOutput{LegacyKey: legacyKey, Key: key})
+				return nil
+			}); err != nil {
+				// Keys issued before the failure are only known from items.
+				_ = writeCommandJSON(command, items)
+				return fmt.Errorf("migrate legacy file keys: %w", err)
+			}
+			return writeCommandJSON(command, items)
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	return command
+}
+
+func newFileKeyRevokeCommand(ctx context.Context) *cobra.Command {
+	var configFile, key string
+	command := &cobra.Command{
+		Use:   "revoke",
+		Short: "Stop --key from resolving; the file stays in place",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			if key == "" {
+				return usageError("--key is required")
+			}
+			_, manager, closeRuntime, err := openFileRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			if err := manager.RevokeFileKey(ctx, key); err != nil {
+				return fmt.Errorf("revoke file key: %w", err)
+			}
+			return writeCommandJSON(command, fileKeyRevokeOutput{Revoked: true})
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().StringVar(&key, "key", "", "legacy or token file key")
+	return command
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/config"
+	"github.com/xxxsen/tgfile/presign"
+)
+
+func newPresignCommand(ctx context.Context) *cobra.Command {
+	var configFile, user, endpoint, method, contentType, uploadID string
+	var partNumber int
+	var expires time.Duration
+	var overrides []string
+	request := &presign.Request{}
+	command := &cobra.Command{
+		Use:   "presign",
+		Short: "Generate a SigV4 presigned URL for an S3 object",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			if user == "" || request.Bucket == "" || request.Key == "" {
+				return usageError("presign requires --user, --bucket and --key")
+			}
+			request.Method = strings.ToUpper(method)
+			request.Expires = expires
+			request.ContentType = contentType
+			request.PartNumber = partNumber
+			request.UploadID = uploadID
+			parsed, err := parseResponseOverrides(overrides)
+			if err != nil {
+				return err
+			}
+			request.Overrides = parsed
+			if err := loadPresignCredentials(configFile, user, endpoint, request); err != nil {
+				return err
+			}
+			result, err := presign.Sign(ctx, request)
+			if err != nil {
+				if errors.Is(err, presign.ErrInvalidRequest) {
+					return usageError(err.Error())
+				}
+				return commandError(fmt.Errorf("presign request: %w", err))
+			}
+			return writeCommandJSON(command, result)
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().StringVar(&user, "user", "", "user_info principal whose credentials sign the URL")
+	command.Flags().StringVar(&endpoint, "endpoint", "", "service origin, defaults to the first external_origin")
+	command.Flags().StringVar(&request.Bucket, "bucket", "", "S3 bucket")
+	command.Flags().StringVar(&request.Key, "key", "", "object key")
+	command.Flags().StringVar(&method, "method", http.MethodGet, "GET or PUT")
+	command.Flags().DurationVar(&expires, "expires", presign.DefaultExpires, "URL lifetime, at most 168h")
+	command.Flags().IntVar(&partNumber, "part-number", 0, "presign UploadPart for this part number")
+	command.Flags().StringVar(&uploadID, "upload-id", "", "multipart upload id of UploadPart")
+	command.Flags().StringVar(&contentType, "content-type", "", "Content-Type a PUT must send")
+	command.Flags().StringArrayVar(
+		&overrides, "response", nil, "response-* override as name=value, for example response-content-type=text/plain",
+	)
+	return command
+}
+
+func parseResponseOverrides(values []string) (map[string]string, error) {
+	result := make(map[string]string, len(values))
+	for _, value := range values {
+		name, parameter, found := strings.Cut(value, "=")
+		name = strings.ToLower(name)
+		if !strings.HasPrefix(name, "response-") {
+			name = "response-" + name
+		}
+		if !found || parameter == "" {
+			return nil, usageError(fmt.Sprintf("response override %q must be name=value", value))
+		}
+		result[name] = parameter
+	}
+	return result, nil
+}
+
+// loadPresignCredentials signs with the principal's user_info secret, which
+// is also its S3 secret key, after checking the principal may perform the
+// presigned operation at all.
+func loadPresignCredentials(configFile, user, endpoint string, request *presign.Request) error {
+	serviceConfig, err := config.Parse(configFile)
+	if err != nil {
+		return fmt.Errorf("parse config: %w", err)
+	}
+	if err := serviceConfig.Validate(); err != nil {
+		return fmt.Errorf("validate config: %w", err)
+	}
+	if !serviceConfig.S3.Enable {
+		return usageError("presigned URLs require s3.enable")
+	}
+	if !slices.Contains(serviceConfig.S3.BucketNames(), request.Bucket) {
+		return usageError(fmt.Sprintf("bucket %q is not configured", request.Bucket))
+	}
+	authorizer, err := authz.New(serviceConfig.UserPermission)
+	if err != nil {
+		return fmt.Errorf("initialize authorization policy: %w", err)
+	}
+	permission := authz.S3Read
+	if request.Method == http.MethodPut {
+		permission = authz.S3Write
+	}
+	secret, exists := serviceConfig.UserInfo[user]
+	if !exists || !authorizer.Has(user, permission) {
+		return usageError(fmt.Sprintf("user %q has no %s permission", user, permission))
+	}
+	if endpoint == "" {
+		if len(serviceConfig.ExternalOrigins) == 0 {
+			return usageError("presign requires --endpoint when external_origin is not configured")
+		}
+		endpoint = serviceConfig.ExternalOrigins[0]
+	}
+	request.Endpoint = endpoint
+	request.AccessKey = user
+	request.SecretKey = secret
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/presign"
+)
+
+func TestPresignGeneratesSignedURL(t *testing.T) {
+	configFile := createSTSCLIConfig(t)
+	code, stdout, stderr := executeForTest(
+		t, "presign", "--config="+configFile, "--user=ci", "--endpoint=http://127.0.0.1:9901",
+		"--bucket=private-data", "--key=reports/a.txt", "--expires=10m",
+		"--response=content-type=text/plain", "--response=response-content-disposition=attachment",
+	)
+	require.Zero(t, code, stderr)
+	var result presign.Result
+	require.NoError(t, json.Unmarshal([]byte(stdout), &result))
+	require.Equal(t, "GET", result.Method)
+	require.True(t, strings.HasPrefix(result.URL, "http://127.0.0.1:9901/private-data/reports/a.txt?"), result.URL)
+	signed, err := url.Parse(result.URL)
+	require.NoError(t, err)
+	require.Equal(t, "600", signed.Query().Get("X-Amz-Expires"))
+	require.Equal(t, "text/plain", signed.Query().Get("response-content-type"))
+	require.Equal(t, "attachment", signed.Query().Get("response-content-disposition"))
+	require.True(t, strings.HasPrefix(signed.Query().Get("X-Amz-Credential"), "ci/"))
+
+	code, stdout, stderr = executeForTest(
+		t, "presign", "--config="+configFile, "--user=ci", "--endpoint=http://127.0.0.1:9901",
+		"--bucket=private-data", "--key=big.bin", "--method=put", "--part-number=2", "--upload-id=abc",
+		"--content-type=application/octet-stream",
+	)
+	require.Zero(t, code, stderr)
+	require.NoError(t, json.Unmarshal([]byte(stdout), &result))
+	require.Equal(t, "PUT", result.Method)
+	require.Contains(t, result.URL, "partNumber=2")
+	require.Equal(t, "application/octet-stream", result.Headers["Content-Type"])
+}
+
+func TestPresignRejectsInvalidRequests(t *testing.T) {
+	configFile := createSTSCLIConfig(t)
+	base := []string{"presign", "--config=" + configFile, "--endpoint=http://127.0.0.1:9901"}
+	for _, args := range [][]string{
+		{"--user=ci", "--bucket=private-data"},
+		{"--user=viewer", "--bucket=private-data", "--key=a"},
+		{"--user=ci", "--bucket=unknown", "--key=a"},
+		{"--user=ci", "--bucket=private-data", "--key=a", "--expires=200h"},
+		{"--user=ci", "--bucket=private-data", "--key=a", "--response=content-type"},
+		{"--user=ci", "--bucket=private-data", "--key=a", "--method=DELETE"},
+	} {
+		code, _, _ := executeForTest(t, append(append([]string(nil), base...), args...)...)
+		require.Equal(t, 2, code, args)
+	}
+	code, _, stderr := executeForTest(
+		t, "presign", "--config="+configFile, "--user=ci", "--bucket=private-data", "--key=a",
+	)
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, "--endpoint")
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xxxsen/tgfile/config"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+const cliWebDAVUserPlaceholder = "{user}"
+
+type quotaUsageOutput struct {
+	Principal    string `json:"principal"`
+	Bytes        int64  `json:"bytes"`
+	Objects      int64  `json:"objects"`
+	HardBytes    int64  `json:"hard_bytes"`
+	SoftBytes    int64  `json:"soft_bytes"`
+	HardObjects  int64  `json:"hard_objects"`
+	SoftObjects  int64  `json:"soft_objects"`
+	SoftExceeded bool   `json:"soft_exceeded"`
+	HardExceeded bool   `json:"hard_exceeded"`
+}
+
+type ownerBackfillOutput struct {
+	Path     string `json:"path"`
+	Owner    string `json:"owner,omitempty"`
+	Assigned int64  `json:"assigned"`
+}
+
+func newQuotaCommand(ctx context.Context) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "quota",
+		Short: "Report quota usage or assign owners to existing mappings",
+		Args:  noPositionalArgs,
+		RunE: func(*cobra.Command, []string) error {
+			return usageError("a quota subcommand is required")
+		},
+	}
+	command.AddCommand(
+		newQuotaUsageCommand(ctx),
+		newQuotaBackfillOwnerCommand(ctx),
+	)
+	return command
+}
+
+func newQuotaUsageCommand(ctx context.Context) *cobra.Command {
+	var configFile string
+	command := &cobra.Command{
+		Use:   "usage",
+		Short: "List the usage and limits of every principal",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			_, manager, closeRuntime, err := openFileRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			usages, err := manager.ListQuotaUsage(ctx)
+			if err != nil {
+				return fmt.Errorf("list quota usage: %w", err)
+			}
+			items := make([]quotaUsageOutput, 0, len(usages))
+			for _, usage := range usages {
+				items = append(items, quotaUsageOutput{
+					Principal:    usage.Principal,
+					Bytes:        usage.Bytes,
+					Objects:      usage.Objects,
+					HardBytes:    usage.Limits.HardBytes,
+					SoftBytes:    usage.Limits.SoftBytes,
+					HardObjects:  usage.Limits.HardObjects,
+					SoftObjects:  usage.Limits.SoftObjects,
+					SoftExceeded: usage.SoftExceeded(),
+					HardExceeded: usage.HardExceeded(),
+				})
+			}
+			return writeCommandJSON(command, items)
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	return command
+}
+
+// newQuotaBackfillOwnerCommand assigns owners to mappings written before
+// owners were recorded. Mappings that have an owner are left alone, so the
+// command can be rerun and combined.
+func newQuotaBackfillOwnerCommand(ctx context.Context) *cobra.Command {
+	var configFile, backfillPath, owner string
+	var fileKeys, webdavHomes bool
+	command := &cobra.Command{
+		Use:   "backfill-owner",
+		Short: "Assign owners to mappings created before owners were recorded",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			if (backfillPath == "") != (owner == "") {
+				return usageError("--path and --owner must be given together")
+			}
+			if backfillPath == "" && !fileKeys && !webdavHomes {
+				return usageError("backfill-owner requires --file-keys, --webdav-homes or --path with --owner")
+			}
+			serviceConfig, manager, closeRuntime, err := openFileRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			if owner != "" {
+				if _, exists := serviceConfig.UserInfo[owner]; !exists {
+					return usageError(fmt.Sprintf("unknown user %q", owner))
+				}
+			}
+			items := make([]ownerBackfillOutput, 0)
+			if fileKeys {
+				assigned, err := manager.BackfillFileKeyOwners(ctx)
+				if err != nil {
+					return fmt.Errorf("backfill direct upload owners: %w", err)
+				}
+				items = append(items, ownerBackfillOutput{Path: "/defaults", Assigned: assigned})
+			}
+			if webdavHomes {
+				homes, err := backfillWebDAVHomes(ctx, serviceConfig, manager)
+				if err != nil {
+					return err
+				}
+				items = append(items, homes...)
+			}
+			if backfillPath != "" {
+				assigned, err := manager.BackfillOwners(ctx, backfillPath, owner)
+				if err != nil {
+					return fmt.Errorf("backfill owners: %w", err)
+				}
+				items = append(items, ownerBackfillOutput{Path: backfillPath, Owner: owner, Assigned: assigned})
+			}
+			return writeCommandJSON(command, items)
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().BoolVar(&fileKeys, "file-keys", false, "give direct uploads the user their key was issued to")
+	command.Flags().BoolVar(&webdavHomes, "webdav-homes", false, "give every WebDAV home tree to its user")
+	command.Flags().StringVar(&backfillPath, "path", "", "absolute path of a tree to give to --owner")
+	command.Flags().StringVar(&owner, "owner", "", "user_info principal that owns --path")
+	return command
+}
+
+// backfillWebDAVHomes gives the home of every user to that user. Homes that
+// were never created are skipped.
+func backfillWebDAVHomes(
+	ctx context.Context,
+	serviceConfig *config.Config,
+	manager filemgr.IFileManager,
+) ([]ownerBackfillOutput, error) {
+	root := serviceConfig.Webdav.Home.Root
+	if root == "" {
+		return nil, usageError("--webdav-homes requires webdav.home.root")
+	}
+	users := make([]string, 0, len(serviceConfig.UserInfo))
+	for user := range serviceConfig.UserInfo {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	items := make([]ownerBackfillOutput, 0, len(users))
+	for _, user := range users {
+		home := strings.ReplaceAll(root, cliWebDAVUserPlaceholder, user)
+		assigned, err := manager.BackfillOwners(ctx, home, user)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("backfill WebDAV home of %q: %w", user, err)
+		}
+		items = append(items, ownerBackfillOutput{Path: home, Owner: user, Assigned: assigned})
+	}
+	return items, nil
+}
@@ -59,8 +59,17 @@ func newRootCommand(ctx context.Context) *cobra.Command {
 		newServeCommand(ctx),
 		newAuditCommand(ctx),
 		newCheckKeyCommand(),
+		newFileKeyCommand(ctx),
 		newCheckConfigCommand(ctx),
 		newBackupCommand(ctx),
+		newTrashCommand(ctx),
+		newSnapshotCommand(ctx),
+		newVersionCommand(ctx),
+		newSTSCommand(ctx),
+		newPresignCommand(ctx),
+		newShareCommand(ctx),
+		newQuotaCommand(ctx),
+		newContentTypeCommand(ctx),
 	)
 	return command
 }
@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
+	"github.com/xxxsen/tgfile/accesslog"
+	"github.com/xxxsen/tgfile/archive"
 	"github.com/xxxsen/tgfile/authz"
 	"github.com/xxxsen/tgfile/backupfmt"
 	"github.com/xxxsen/tgfile/backupmgr"
@@ -16,8 +19,15 @@ import (
 	_ "github.com/xxxsen/tgfile/blockio/register"
 	"github.com/xxxsen/tgfile/config"
 	"github.com/xxxsen/tgfile/db"
+	"github.com/xxxsen/tgfile/fetchmgr"
 	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/inventory"
+	"github.com/xxxsen/tgfile/lifecycle"
+	"github.com/xxxsen/tgfile/replication"
+	"github.com/xxxsen/tgfile/s3session"
 	"github.com/xxxsen/tgfile/server"
+	"github.com/xxxsen/tgfile/sharelink"
+	"github.com/xxxsen/tgfile/uploadsession"
 
 	"github.com/spf13/cobra"
 	"github.com/xxxsen/common/idgen"
@@ -30,13 +40,24 @@ var (
 	errUnexpectedServiceExit = errors.New("service component exited unexpectedly")
 )
 
-const cacheShutdownTimeout = 30 * time.Second
+const (
+	cacheShutdownTimeout = 30 * time.Second
+	thumbnailConcurrency = 2
+	// webdavUploadExpire is how long an idle resumable WebDAV PUT keeps the
+	// blocks it has uploaded.
+	webdavUploadExpire = 24 * time.Hour
+)
 
 type componentResult struct {
 	name string
 	err  error
 }
 
+type backgroundWorker struct {
+	name string
+	run  func(context.Context) error
+}
+
 func newServeCommand(ctx context.Context) *cobra.Command {
 	var configFile string
 	command := &cobra.Command{
@@ -120,12 +141,33 @@ func runHTTPServer(ctx context.Context, serviceConfig *config.Config, appLogger
 				return err
 			}
 		}
-		httpServer, buildErr := buildHTTPServer(serviceConfig, fileManager, backupManager)
+		managers, err := buildS3Managers(ctx, serviceConfig.S3, fileManager)
+		if err != nil {
+			return err
+		}
+		jobs, err := buildJobServices(serviceConfig, fileManager)
+		if err != nil {
+			return err
+		}
+		httpServer, buildErr := buildHTTPServer(serviceConfig, fileManager, backupManager, managers, jobs)
 		if buildErr != nil {
 			return buildErr
 		}
 		appLogger.Info("init server succ, start it...")
-		return runServerComponents(ctx, httpServer, fileManager, backupManager)
+		workers := append(managers.workers(), jobs.workers()...)
+		if fileManager.TrashEnabled() {
+			workers = append(workers, backgroundWorker{name: "trash worker", run: fileManager.RunTrashWorker})
+		}
+		if fileManager.SnapshotsEnabled() {
+			workers = append(workers, backgroundWorker{name: "snapshot worker", run: fileManager.RunSnapshotWorker})
+		}
+		if fileManager.VersionsEnabled() {
+			workers = append(workers, backgroundWorker{name: "version worker", run: fileManager.RunVersionWorker})
+		}
+		if fileManager.ThumbnailsEnabled() {
+			workers = append(workers, backgroundWorker{name: "thumbnail worker", run: fileManager.RunThumbnailWorker})
+		}
+		return runServerComponents(ctx, httpServer, fileManager, backupManager, workers)
 	}()
 	closeErr := func() error {
 		closeContext, cancel := context.WithTimeout(context.WithoutCancel(ctx), cacheShutdownTimeout)
@@ -145,12 +187,51 @@ func logServerFeatures(serviceConfig *config.Config, appLogger *zap.Logger) {
 		"-- s3 feature",
 		zap.Bool("enable", serviceConfig.S3.Enable),
 		zap.Strings("buckets", serviceConfig.S3.BucketNames()),
+		zap.Int("replication_rules", serviceConfig.S3.ReplicationRuleCount()),
+		zap.Int("lifecycle_rules", serviceConfig.S3.LifecycleRuleCount()),
+	)
+	appLogger.Info(
+		"-- storage classes",
+		zap.String("primary", serviceConfig.StorageClass),
+		zap.Strings("classes", serviceConfig.StorageClassNames()),
 	)
 	appLogger.Info(
 		"-- webdav feature",
 		zap.Bool("enable", serviceConfig.Webdav.Enable),
 		zap.String("root", serviceConfig.Webdav.Root),
 	)
+	appLogger.Info(
+		"-- trash feature",
+		zap.Bool("enable", serviceConfig.Trash.Enable),
+		zap.Int("retention_days", serviceConfig.Trash.RetentionDays),
+	)
+	appLogger.Info(
+		"-- snapshot feature",
+		zap.Bool("enable", serviceConfig.Snapshot.Enable),
+		zap.Int("retention_days", serviceConfig.Snapshot.RetentionDays),
+		zap.Int("schedules", len(serviceConfig.Snapshot.Schedules)),
+	)
+	appLogger.Info(
+		"-- version feature",
+		zap.Bool("enable", serviceConfig.Version.Enable),
+		zap.Int("keep_count", serviceConfig.Version.KeepCount),
+		zap.Int("keep_days", serviceConfig.Version.KeepDays),
+	)
+	appLogger.Info(
+		"-- thumbnail feature",
+		zap.Bool("enable", serviceConfig.Thumbnail.Enable),
+		zap.Int("max_edge", serviceConfig.Thumbnail.MaxEdge),
+	)
+	appLogger.Info(
+		"-- file key",
+		zap.Bool("legacy_enable", !serviceConfig.FileKey.DisableLegacy),
+	)
+	appLogger.Info(
+		"-- fetch feature",
+		zap.Bool("enable", serviceConfig.Fetch.Enable),
+		zap.Int64("max_size", serviceConfig.Fetch.MaxSize),
+		zap.Bool("allow_private_networks", serviceConfig.Fetch.AllowPrivateNetworks),
+	)
 	appLogger.Info(
 		"-- admin feature",
 		zap.Bool("enable", serviceConfig.Admin.Enable),
@@ -183,10 +264,225 @@ func buildBackupManager(
 	return manager, nil
 }
 
+func buildReplicationManager(
+	ctx context.Context,
+	input config.S3Config,
+	fileManager filemgr.IFileManager,
+) (*replication.Manager, error) {
+	options := replication.Options{
+		Targets: make(map[string]replication.Target, len(input.ReplicationTargets)+input.ReplicationRuleCount()),
+		Seed:    make([]replication.Rule, 0, input.ReplicationRuleCount()),
+	}
+	for _, target := range input.ReplicationTargets {
+		options.Targets[target.Name] = replication.Target{
+			Endpoint:  target.Endpoint,
+			Region:    target.Region,
+			AccessKey: target.AccessKey,
+			SecretKey: target.SecretKey,
+		}
+	}
+	for _, bucket := range input.Buckets {
+		for index, rule := range bucket.Replication {
+			name := config.LegacyReplicationTarget(bucket.Name, rule.ID)
+			options.Targets[name] = replication.Target{
+				Endpoint:  rule.Endpoint,
+				Region:    rule.Region,
+				AccessKey: rule.AccessKey,
+				SecretKey: rule.SecretKey,
+			}
+			options.Seed = append(options.Seed, replication.Rule{
+				ID:               rule.ID,
+				Bucket:           bucket.Name,
+				Priority:         index + 1,
+				Enabled:          true,
+				Prefix:           rule.Prefix,
+				Target:           name,
+				TargetBucket:     rule.Bucket,
+				ReplicateDeletes: rule.ReplicateDeletes,
+			})
+		}
+	}
+	manager, err := replication.New(ctx, db.GetClient(), fileManager, options)
+	if err != nil {
+		return nil, fmt.Errorf("init replication manager: %w", err)
+	}
+	return manager, nil
+}
+
+func buildLifecycleManager(input config.S3Config, fileManager filemgr.IFileManager) (*lifecycle.Manager, error) {
+	rules := make([]lifecycle.Rule, 0, input.LifecycleRuleCount())
+	for _, bucket := range input.Buckets {
+		for _, rule := range bucket.Lifecycle {
+			rules = append(rules, lifecycle.Rule{
+				ID:           rule.ID,
+				Bucket:       bucket.Name,
+				Prefix:       rule.Prefix,
+				Days:         rule.Days,
+				StorageClass: rule.StorageClass,
+			})
+		}
+	}
+	manager, err := lifecycle.New(fileManager, lifecycle.Options{Rules: rules})
+	if err != nil {
+		return nil, fmt.Errorf("init lifecycle manager: %w", err)
+	}
+	return manager, nil
+}
+
+// s3Managers holds the S3 background managers; each is nil when the feature
+// is not in use.
+type s3Managers struct {
+	replication *replication.Manager
+	lifecycle   *lifecycle.Manager
+	inventory   *inventory.Manager
+	accessLog   *accesslog.Manager
+}
+
+func buildS3Managers(
+	ctx context.Context,
+	input config.S3Config,
+	fileManager filemgr.IFileManager,
+) (*s3Managers, error) {
+	managers := &s3Managers{}
+	if !input.Enable {
+		return managers, nil
+	}
+	var err error
+	if managers.replication, err = buildReplicationManager(ctx, input, fileManager); err != nil {
+		return nil, err
+	}
+	if input.LifecycleRuleCount() != 0 {
+		if managers.lifecycle, err = buildLifecycleManager(input, fileManager); err != nil {
+			return nil, err
+		}
+	}
+	managers.inventory = inventory.New(db.GetClient(), fileManager, inventory.Options{Buckets: input.BucketNames()})
+	managers.accessLog, err = accesslog.New(ctx, db.GetClient(), fileManager, accesslog.Options{
+		Buckets: input.BucketNames(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("init access log manager: %w", err)
+	}
+	return managers, nil
+}
+
+func (m *s3Managers) workers() []backgroundWorker {
+	workers := make([]backgroundWorker, 0, 4)
+	if m.replication != nil {
+		workers = append(workers, backgroundWorker{name: "replication worker", run: m.replication.Run})
+	}
+	if m.lifecycle != nil {
+		workers = append(workers, backgroundWorker{name: "lifecycle worker", run: m.lifecycle.Run})
+	}
+	if m.inventory != nil {
+		workers = append(workers, backgroundWorker{name: "inventory worker", run: m.inventory.Run})
+	}
+	if m.accessLog != nil {
+		workers = append(workers, backgroundWorker{name: "access log worker", run: m.accessLog.Run})
+	}
+	return workers
+}
+
+// jobServices are the optional stores that run their own background work
+// for the HTTP API.
+type jobServices struct {
+	uploads       *uploadsession.Store
+	webdavUploads *uploadsession.Store
+	fetches       *fetchmgr.Manager
+}
+
+func buildJobServices(serviceConfig *config.Config, fileManager filemgr.IFileManager) (*jobServices, error) {
+	services := &jobServices{}
+	var err error
+	if serviceConfig.Tus.Enable {
+		if services.uploads, err = buildUploadSessions(serviceConfig.Tus, fileManager); err != nil {
+			return nil, err
+		}
+	}
+	if serviceConfig.Webdav.Enable {
+		if services.webdavUploads, err = buildWebDAVUploadSessions(serviceConfig.Webdav, fileManager); err != nil {
+			return nil, err
+		}
+	}
+	if serviceConfig.Fetch.Enable {
+		if services.fetches, err = buildFetchManager(serviceConfig, fileManager); err != nil {
+			return nil, err
+		}
+	}
+	return services, nil
+}
+
+func (s *jobServices) workers() []backgroundWorker {
+	workers := make([]backgroundWorker, 0, 3)
+	if s.uploads != nil {
+		workers = append(workers, backgroundWorker{name: "upload session worker", run: s.uploads.Run})
+	}
+	if s.webdavUploads != nil {
+		workers = append(workers, backgroundWorker{name: "WebDAV upload session worker", run: s.webdavUploads.Run})
+	}
+	if s.fetches != nil {
+		workers = append(workers, backgroundWorker{name: "fetch worker", run: s.fetches.Run})
+	}
+	return workers
+}
+
+func buildFetchManager(serviceConfig *config.Config, fileManager filemgr.IFileManager) (*fetchmgr.Manager, error) {
+	var buckets []string
+	if serviceConfig.S3.Enable {
+		buckets = serviceConfig.S3.BucketNames()
+	}
+	input := serviceConfig.Fetch
+	manager, err := fetchmgr.New(db.GetClient(), fileManager, fetchmgr.Options{
+		WorkDir:              input.WorkDir,
+		MaxSize:              input.MaxSize,
+		MaxRetries:           input.MaxRetries,
+		Workers:              input.Workers,
+		AllowPrivateNetworks: input.AllowPrivateNetworks,
+		S3Buckets:            buckets,
+		JobRetention:         time.Duration(input.JobRetentionDays) * 24 * time.Hour,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("init fetch manager: %w", err)
+	}
+	return manager, nil
+}
+
+func buildUploadSessions(input config.TusConfig, fileManager filemgr.IFileManager) (*uploadsession.Store, error) {
+	store, err := uploadsession.New(db.GetClient(), fileManager, uploadsession.Options{
+		Dir:     input.TempDir,
+		Expire:  time.Duration(input.ExpireHours) * time.Hour,
+		MaxSize: input.MaxUploadSize,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("init upload sessions: %w", err)
+	}
+	return store, nil
+}
+
+// buildWebDAVUploadSessions keeps resumable WebDAV PUTs below the WebDAV
+// upload directory, next to the spools of plain PUTs.
+func buildWebDAVUploadSessions(
+	input config.WebdavConfig,
+	fileManager filemgr.IFileManager,
+) (*uploadsession.Store, error) {
+	store, err := uploadsession.New(db.GetClient(), fileManager, uploadsession.Options{
+		Dir:     filepath.Join(input.UploadTempDir, "sessions"),
+		Expire:  webdavUploadExpire,
+		MaxSize: input.MaxUploadSize,
+		Origin:  uploadsession.OriginWebDAV,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("init WebDAV upload sessions: %w", err)
+	}
+	return store, nil
+}
+
 func buildHTTPServer(
 	serviceConfig *config.Config,
 	fileManager filemgr.IFileManager,
 	backupManager *backupmgr.Manager,
+	managers *s3Managers,
+	jobs *jobServices,
 ) (*server.Server, error) {
 	authorizer, err := authz.New(serviceConfig.UserPermission)
 	if err != nil {
@@ -202,8 +498,25 @@ func buildHTTPServer(
 			serviceConfig.ExternalOrigins,
 		)),
 		server.WithFileManager(fileManager),
+		server.WithS3Sessions(s3session.New(db.GetClient())),
+		server.WithShareLinks(sharelink.New(db.GetClient(), fileManager)),
+		server.WithUploadSessions(jobs.uploads),
+		server.WithWebDAVUploadSessions(jobs.webdavUploads),
+		server.WithFetch(jobs.fetches),
+		server.WithReplication(managers.replication),
+		server.WithLifecycle(managers.lifecycle),
+		server.WithInventory(managers.inventory),
+		server.WithAccessLog(managers.accessLog),
 		server.WithBackup(server.BackupOptions{Enabled: serviceConfig.Backup.Enable}, backupManager),
 		server.WithAdmin(toServerAdminOptions(serviceConfig.Admin, serviceConfig)),
+		server.WithFileUpload(server.FileUploadOptions{
+			MaxUploadSize: serviceConfig.FileUpload.MaxUploadSize,
+			TempDir:       serviceConfig.FileUpload.TempDir,
+		}),
+		server.WithArchive(archive.Limits{
+			MaxEntries: serviceConfig.Archive.MaxEntries,
+			MaxBytes:   serviceConfig.Archive.MaxBytes,
+		}),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("init server: %w", err)
@@ -216,6 +529,7 @@ func runServerComponents(
 	httpServer *server.Server,
 	fileManager filemgr.IFileManager,
 	backupManager *backupmgr.Manager,
+	workers []backgroundWorker,
 ) error {
 	runContext, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -223,6 +537,7 @@ func runServerComponents(
 	if backupManager != nil {
 		componentCount++
 	}
+	componentCount += len(workers)
 	componentDone := make(chan componentResult, componentCount)
 	go func() {
 		componentDone <- componentResult{
@@ -244,6 +559,11 @@ func runServerComponents(
 			componentDone <- componentResult{name: "backup worker", err: backupManager.Run(runContext)}
 		}()
 	}
+	for _, worker := range workers {
+		go func() {
+			componentDone <- componentResult{name: worker.name, err: worker.run(runContext)}
+		}()
+	}
 
 	first := <-componentDone
 	contextWasDone := ctx.Err() != nil
@@ -290,7 +610,7 @@ func toBackupManagerOptions(
 			MaxUserMetaBytes: backupfmt.DefaultLimits().MaxUserMetaBytes,
 		},
 		RequiredBuckets:   buckets,
-		SchemaVersion:     13,
+		SchemaVersion:     39,
 		MaxPartSize:       maxPartSize,
 		ArtifactRetention: time.Duration(serviceConfig.Backup.ArtifactRetentionHours) * time.Hour,
 		JobRetention:      time.Duration(serviceConfig.Backup.JobRetentionDays) * 24 * time.Hour,
@@ -316,6 +636,22 @@ func toServerWebDAVOptions(
 	input config.WebdavConfig,
 	externalOrigins []string,
 ) server.WebDAVOptions {
+	mounts := make([]server.WebDAVMountOptions, 0, len(input.Shares)+1)
+	if input.Home.Root != "" {
+		mounts = append(mounts, server.WebDAVMountOptions{
+			Name:       "home",
+			Root:       input.Home.Root,
+			QuotaBytes: input.Home.QuotaBytes,
+		})
+	}
+	for _, share := range input.Shares {
+		mounts = append(mounts, server.WebDAVMountOptions{
+			Name:       share.Name,
+			Root:       share.Root,
+			ReadOnly:   share.Access == "read",
+			QuotaBytes: share.QuotaBytes,
+		})
+	}
 	return server.WebDAVOptions{
 		Enabled:            input.Enable,
 		Root:               input.Root,
@@ -325,6 +661,8 @@ func toServerWebDAVOptions(
 		QuotaBytes:         input.QuotaBytes,
 		MaxMutationEntries: input.MaxMutationEntries,
 		SyncPageSize:       input.SyncPageSize,
+		Mounts:             mounts,
+		Groups:             input.Groups,
 	}
 }
 
@@ -341,6 +679,7 @@ func toServerS3Options(input config.S3Config) server.S3Options {
 		Buckets:              buckets,
 		MaxObjectSize:        input.MaxObjectSize,
 		MultipartExpireHours: input.MultipartExpireHours,
+		BaseDomain:           input.BaseDomain,
 	}
 }
 
@@ -380,6 +719,87 @@ func buildFileManager(
 	if err != nil {
 		return nil, nil, fmt.Errorf("create file io cache failed, err:%w", err)
 	}
-	fileManager := filemgr.NewFileManager(db.GetClient(), blockStorage, ioCache)
+	options, err := buildStorageTiers(serviceConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	options = append(options, buildFileFeatureOptions(serviceConfig)...)
+	fileManager := filemgr.NewFileManager(db.GetClient(), blockStorage, ioCache, options...)
 	return fileManager, ioCache, nil
 }
+
+func buildFileFeatureOptions(serviceConfig *config.Config) []filemgr.Option {
+	const day = 24 * time.Hour
+	options := make([]filemgr.Option, 0, 4)
+	if serviceConfig.Trash.Enable {
+		options = append(options, filemgr.WithTrash(time.Duration(serviceConfig.Trash.RetentionDays)*day))
+	}
+	if serviceConfig.Snapshot.Enable {
+		snapshots := filemgr.SnapshotOptions{
+			Retention: time.Duration(serviceConfig.Snapshot.RetentionDays) * day,
+		}
+		for _, schedule := range serviceConfig.Snapshot.Schedules {
+			snapshots.Schedules = append(snapshots.Schedules, filemgr.SnapshotSchedule{
+				Name:     schedule.Name,
+				Scope:    schedule.Scope,
+				Interval: time.Duration(schedule.IntervalHours) * time.Hour,
+			})
+		}
+		options = append(options, filemgr.WithSnapshots(snapshots))
+	}
+	if serviceConfig.Version.Enable {
+		options = append(options, filemgr.WithVersions(filemgr.VersionOptions{
+			KeepCount: serviceConfig.Version.KeepCount,
+			KeepFor:   time.Duration(serviceConfig.Version.KeepDays) * day,
+		}))
+	}
+	if serviceConfig.Thumbnail.Enable {
+		options = append(options, filemgr.WithThumbnails(filemgr.ThumbnailOptions{
+			MaxEdge:         serviceConfig.Thumbnail.MaxEdge,
+			Quality:         serviceConfig.Thumbnail.Quality,
+			MaxSourceBytes:  serviceConfig.Thumbnail.MaxSourceBytes,
+			MaxSourcePixels: serviceConfig.Thumbnail.MaxSourcePixels,
+			Concurrency:     thumbnailConcurrency,
+		}))
+	}
+	if serviceConfig.FileKey.DisableLegacy {
+		options = append(options, filemgr.WithoutLegacyFileKeys())
+	}
+	if serviceConfig.S3.Enable {
+		options = append(options, filemgr.WithChangeObserver(replication.EnqueueChange))
+	}
+	return append(options, filemgr.WithQuotas(toQuotaPolicy(serviceConfig.Quota)))
+}
+
+func toQuotaPolicy(input config.QuotaConfig) filemgr.QuotaPolicy {
+	policy := filemgr.QuotaPolicy{
+		Default:    toQuotaLimits(input.Default),
+		Principals: make(map[string]filemgr.QuotaLimits, len(input.Users)),
+	}
+	for user, limits := range input.Users {
+		policy.Principals[user] = toQuotaLimits(limits)
+	}
+	return policy
+}
+
+func toQuotaLimits(input config.QuotaLimitConfig) filemgr.QuotaLimits {
+	return filemgr.QuotaLimits{
+		HardBytes:   input.HardBytes,
+		SoftBytes:   input.SoftBytes,
+		HardObjects: input.HardObjects,
+		SoftObjects: input.SoftObjects,
+	}
+}
+
+func buildStorageTiers(serviceConfig *config.Config) ([]filemgr.Option, error) {
+	options := []filemgr.Option{filemgr.WithPrimaryStorageClass(serviceConfig.StorageClass)}
+	for _, tier := range serviceConfig.StorageClasses {
+		tierStorage, err := blockio.Create(tier.BotKind, tier.BotInfo)
+		if err != nil {
+			return nil, fmt.Errorf("init storage class %s failed, kind:%s, err:%w", tier.Name, tier.BotKind, err)
+		}
+		tierStorage = blockio.NewRotateIO(tierStorage, serviceConfig.RotateStream)
+		options = append(options, filemgr.WithStorageTier(tier.Name, tierStorage))
+	}
+	return options, nil
+}
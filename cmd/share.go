@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/config"
+	"github.com/xxxsen/tgfile/db"
+	"github.com/xxxsen/tgfile/sharelink"
+)
+
+func newShareCommand(ctx context.Context) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "share",
+		Short: "Create, list, or revoke public share links",
+		Args:  noPositionalArgs,
+		RunE: func(*cobra.Command, []string) error {
+			return usageError("a share subcommand is required")
+		},
+	}
+	command.AddCommand(
+		newShareCreateCommand(ctx),
+		newShareListCommand(ctx),
+		newShareRevokeCommand(ctx),
+	)
+	return command
+}
+
+func newShareCreateCommand(ctx context.Context) *cobra.Command {
+	var configFile, user, sharePath, password string
+	var expires time.Duration
+	var maxDownloads int64
+	var listing bool
+	command := &cobra.Command{
+		Use:   "create",
+		Short: "Share a file or directory; the printed token is the only copy",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			if user == "" || sharePath == "" {
+				return usageError("share create requires --user and --path")
+			}
+			serviceConfig, store, closeRuntime, err := openShareRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			authorizer, err := authz.New(serviceConfig.UserPermission)
+			if err != nil {
+				return fmt.Errorf("initialize authorization policy: %w", err)
+			}
+			if _, exists := serviceConfig.UserInfo[user]; !exists || !authorizer.Has(user, authz.ShareWrite) {
+				return usageError(fmt.Sprintf("user %q has no share permission", user))
+			}
+			created, err := store.Create(ctx, sharelink.CreateRequest{
+				Owner:        user,
+				Path:         sharePath,
+				Expires:      expires,
+				Password:     password,
+				MaxDownloads: maxDownloads,
+				Listing:      listing,
+			})
+			if err != nil {
+				return commandError(fmt.Errorf("create share link: %w", err))
+			}
+			return writeCommandJSON(command, created)
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().StringVar(&user, "user", "", "user_info principal that owns the link")
+	command.Flags().StringVar(&sharePath, "path", "", "absolute path of the shared file or directory")
+	command.Flags().DurationVar(&expires, "expires", 0, "link lifetime; 0 keeps the link until it is revoked")
+	command.Flags().StringVar(&password, "password", "", "password visitors must send as the Basic auth password")
+	command.Flags().Int64Var(&maxDownloads, "max-downloads", 0, "number of file downloads allowed; 0 is unlimited")
+	command.Flags().BoolVar(&listing, "listing", false, "serve a listing page for a shared directory")
+	return command
+}
+
+func newShareListCommand(ctx context.Context) *cobra.Command {
+	var configFile, user string
+	command := &cobra.Command{
+		Use:   "list",
+		Short: "List share links and their use",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			_, store, closeRuntime, err := openShareRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			links, err := store.List(ctx, user)
+			if err != nil {
+				return fmt.Errorf("list share links: %w", err)
+			}
+			return writeCommandJSON(command, links)
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().StringVar(&user, "user", "", "only list links of this principal")
+	return command
+}
+
+func newShareRevokeCommand(ctx context.Context) *cobra.Command {
+	var configFile, shareID string
+	command := &cobra.Command{
+		Use:   "revoke",
+		Short: "Revoke a share link immediately",
+		Args:  noPositionalArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if shareID == "" {
+				return usageError("share revoke requires --id")
+			}
+			_, store, closeRuntime, err := openShareRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			if err := store.Revoke(ctx, "", shareID); err != nil {
+				return commandError(fmt.Errorf("revoke share link: %w", err))
+			}
+			return nil
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().StringVar(&shareID, "id", "", "id of the share link to revoke")
+	return command
+}
+
+// openShareRuntime opens the file manager as well, since creating a link
+// checks that the shared path exists.
+func openShareRuntime(
+	ctx context.Context,
+	configFile string,
+) (*config.Config, *sharelink.Store, func(), error) {
+	serviceConfig, manager, closeRuntime, err := openFileRuntime(ctx, configFile)
+	if err != nil {
+		return nil, nil, closeRuntime, err
+	}
+	return serviceConfig, sharelink.New(db.GetClient(), manager), closeRuntime, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/sharelink"
+)
+
+func createShareCLIConfig(t *testing.T) string {
+	t.Helper()
+	directory := t.TempDir()
+	configFile := filepath.Join(directory, "config.json")
+	require.NoError(t, os.WriteFile(configFile, []byte(fmt.Sprintf(`{
+		"db_file":%q,
+		"bot_kind":"localfile",
+		"bot_config":{"dir":%q,"block_size":1048576},
+		"user_info":{"sharer":"sharer-secret","viewer":"viewer-secret"},
+		"user_permission":{"sharer":["share:write"],"viewer":["webdav:read"]}
+	}`, filepath.Join(directory, "data.db"), filepath.Join(directory, "blocks"))), 0o600))
+	return configFile
+}
+
+func TestShareCreateListAndRevoke(t *testing.T) {
+	configFile := createShareCLIConfig(t)
+	_, manager, closeRuntime, err := openFileRuntime(context.Background(), configFile)
+	require.NoError(t, err)
+	err = manager.CreateFileLink(context.Background(), "/docs", 0, 0, true)
+	closeRuntime()
+	require.NoError(t, err)
+	code, stdout, stderr := executeForTest(
+		t, "share", "create", "--config="+configFile, "--user=sharer",
+		"--path=/docs", "--listing", "--expires=1h", "--password=secret", "--max-downloads=3",
+	)
+	require.Zero(t, code, stderr)
+	var created sharelink.Created
+	require.NoError(t, json.Unmarshal([]byte(stdout), &created))
+	require.NotEmpty(t, created.Token)
+	require.Equal(t, sharelink.RoutePrefix+created.Token, created.URLPath)
+	require.True(t, created.HasPassword)
+	require.NotNil(t, created.ExpiresAt)
+
+	code, stdout, stderr = executeForTest(t, "share", "list", "--config="+configFile, "--user=sharer")
+	require.Zero(t, code, stderr)
+	require.Contains(t, stdout, created.ID)
+	require.NotContains(t, stdout, created.Token)
+	require.NotContains(t, stdout, "pbkdf2")
+
+	code, _, stderr = executeForTest(t, "share", "revoke", "--config="+configFile, "--id="+created.ID)
+	require.Zero(t, code, stderr)
+	code, _, _ = executeForTest(t, "share", "revoke", "--config="+configFile, "--id=TGSLMISSING")
+	require.Equal(t, 2, code)
+}
+
+func TestShareCreateRejectsInvalidRequests(t *testing.T) {
+	configFile := createShareCLIConfig(t)
+	for _, args := range [][]string{
+		{"--user=viewer", "--path=/"},
+		{"--user=missing", "--path=/"},
+		{"--user=sharer"},
+		{"--user=sharer", "--path=relative"},
+		{"--user=sharer", "--path=/missing"},
+		{"--user=sharer", "--path=/", "--max-downloads=-1"},
+	} {
+		code, _, _ := executeForTest(t, append([]string{"share", "create", "--config=" + configFile}, args...)...)
+		require.Equal(t, 2, code, args)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+type snapshotOutput struct {
+	Name         string `json:"name"`
+	Scope        string `json:"scope"`
+	Origin       string `json:"origin"`
+	ScheduleName string `json:"schedule_name,omitempty"`
+	Principal    string `json:"principal,omitempty"`
+	FileCount    int64  `json:"file_count"`
+	DirCount     int64  `json:"dir_count"`
+	TotalSize    int64  `json:"total_size"`
+	CreatedAt    int64  `json:"created_at"`
+	ExpiresAt    int64  `json:"expires_at,omitempty"`
+}
+
+type snapshotRestoreOutput struct {
+	Destination string `json:"destination"`
+	Files       int64  `json:"files"`
+	Directories int64  `json:"directories"`
+}
+
+func newSnapshotCommand(ctx context.Context) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Create, list, restore, or delete namespace snapshots",
+		Args:  noPositionalArgs,
+		RunE: func(*cobra.Command, []string) error {
+			return usageError("a snapshot subcommand is required")
+		},
+	}
+	command.AddCommand(
+		newSnapshotCreateCommand(ctx),
+		newSnapshotListCommand(ctx),
+		newSnapshotRestoreCommand(ctx),
+		newSnapshotDeleteCommand(ctx),
+	)
+	return command
+}
+
+func newSnapshotCreateCommand(ctx context.Context) *cobra.Command {
+	var configFile, name, scope string
+	var retentionDays int
+	command := &cobra.Command{
+		Use:   "create",
+		Short: "Snapshot the mappings under --scope as --name",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			if name == "" || scope == "" {
+				return usageError("--name and --scope are required")
+			}
+			if retentionDays < 0 {
+				return usageError("--retention-days must not be negative")
+			}
+			_, manager, closeRuntime, err := openFileRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			info, err := manager.CreateSnapshot(ctx, filemgr.SnapshotCreateRequest{
+				Name:      name,
+				Scope:     scope,
+				Origin:    filemgr.SnapshotOriginCLI,
+				Retention: time.Duration(retentionDays) * 24 * time.Hour,
+			})
+			if err != nil {
+				return fmt.Errorf("create snapshot: %w", err)
+			}
+			return writeCommandJSON(command, toSnapshotOutput(info))
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().StringVar(&name, "name", "", "snapshot name")
+	command.Flags().StringVar(&scope, "scope", "", "absolute directory to snapshot")
+	command.Flags().IntVar(&retentionDays, "retention-days", 0, "days to keep the snapshot, the configured default when 0")
+	return command
+}
+
+func newSnapshotListCommand(ctx context.Context) *cobra.Command {
+	var configFile string
+	command := &cobra.Command{
+		Use:   "list",
+		Short: "List snapshots, newest first",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			_, manager, closeRuntime, err := openFileRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			snapshots, err := manager.ListSnapshots(ctx)
+			if err != nil {
+				return fmt.Errorf("list snapshots: %w", err)
+			}
+			items := make([]snapshotOutput, 0, len(snapshots))
+			for _, info := range snapshots {
+				items = append(items, toSnapshotOutput(&info))
+			}
+			return writeCommandJSON(command, items)
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	return command
+}
+
+func newSnapshotRestoreCommand(ctx context.Context) *cobra.Command {
+	var configFile, name, entryPath, destination string
+	var overwrite bool
+	command := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore a snapshot, or the part of it at --path",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			if name == "" {
+				return usageError("--name is required")
+			}
+			_, manager, closeRuntime, err := openFileRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			result, err := manager.RestoreSnapshot(ctx, filemgr.SnapshotRestoreRequest{
+				Name:        name,
+				Path:        entryPath,
+				Destination: destination,
+				Overwrite:   overwrite,
+			})
+			if err != nil {
+				return fmt.Errorf("restore snapshot: %w", err)
+			}
+			return writeCommandJSON(command, snapshotRestoreOutput{
+				Destination: result.Destination,
+				Files:       result.Files,
+				Directories: result.Directories,
+			})
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().StringVar(&name, "name", "", "snapshot name")
+	command.Flags().StringVar(&entryPath, "path", "/", "path inside the snapshot")
+	command.Flags().StringVar(&destination, "destination", "", "absolute restore path, the original path when empty")
+	command.Flags().BoolVar(&overwrite, "overwrite", false, "replace existing files")
+	return command
+}
+
+func newSnapshotDeleteCommand(ctx context.Context) *cobra.Command {
+	var configFile, name string
+	command := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete a snapshot and release its files",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			if name == "" {
+				return usageError("--name is required")
+			}
+			_, manager, closeRuntime, err := openFileRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			info, err := manager.DeleteSnapshot(ctx, name)
+			if err != nil {
+				return fmt.Errorf("delete snapshot: %w", err)
+			}
+			return writeCommandJSON(command, toSnapshotOutput(info))
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().StringVar(&name, "name", "", "snapshot name")
+	return command
+}
+
+func toSnapshotOutput(info *filemgr.SnapshotInfo) snapshotOutput {
+	return snapshotOutput{
+		Name:         info.Name,
+		Scope:        info.Scope,
+		Origin:       info.Origin,
+		ScheduleName: info.ScheduleName,
+		Principal:    info.Principal,
+		FileCount:    info.FileCount,
+		DirCount:     info.DirCount,
+		TotalSize:    info.TotalSize,
+		CreatedAt:    info.CreatedAt,
+		ExpiresAt:    info.ExpiresAt,
+	}
+}
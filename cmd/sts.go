@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/config"
+	"github.com/xxxsen/tgfile/db"
+	"github.com/xxxsen/tgfile/s3session"
+)
+
+func newSTSCommand(ctx context.Context) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "sts",
+		Short: "Issue, list, or revoke temporary S3 credentials",
+		Args:  noPositionalArgs,
+		RunE: func(*cobra.Command, []string) error {
+			return usageError("an sts subcommand is required")
+		},
+	}
+	command.AddCommand(
+		newSTSIssueCommand(ctx),
+		newSTSListCommand(ctx),
+		newSTSRevokeCommand(ctx),
+	)
+	return command
+}
+
+func newSTSIssueCommand(ctx context.Context) *cobra.Command {
+	var configFile, user, bucket, prefix string
+	var readOnly bool
+	var duration time.Duration
+	command := &cobra.Command{
+		Use:   "issue",
+		Short: "Mint a temporary credential derived from a configured user",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			if user == "" {
+				return usageError("sts issue requires --user")
+			}
+			serviceConfig, store, closeRuntime, err := openSTSRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			authorizer, err := authz.New(serviceConfig.UserPermission)
+			if err != nil {
+				return fmt.Errorf("initialize authorization policy: %w", err)
+			}
+			if _, exists := serviceConfig.UserInfo[user]; !exists || !authorizer.Has(user, authz.S3Read) {
+				return usageError(fmt.Sprintf("user %q has no S3 permission", user))
+			}
+			if bucket != "" && !slices.Contains(serviceConfig.S3.BucketNames(), bucket) {
+				return usageError(fmt.Sprintf("bucket %q is not configured", bucket))
+			}
+			credential, err := store.Issue(ctx, s3session.IssueRequest{
+				Owner: user,
+				Scope: authz.Scope{
+					Bucket:   bucket,
+					Prefix:   prefix,
+					ReadOnly: readOnly || !authorizer.Has(user, authz.S3Write),
+				},
+				Duration: duration,
+			})
+			if err != nil {
+				return commandError(fmt.Errorf("issue session credential: %w", err))
+			}
+			return writeCommandJSON(command, credential)
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().StringVar(&user, "user", "", "user_info principal the credential is derived from")
+	command.Flags().StringVar(&bucket, "bucket", "", "restrict the credential to one bucket")
+	command.Flags().StringVar(&prefix, "prefix", "", "restrict the credential to keys with this prefix")
+	command.Flags().BoolVar(&readOnly, "read-only", false, "restrict the credential to read access")
+	command.Flags().DurationVar(&duration, "duration", s3session.DefaultDuration, "credential lifetime")
+	return command
+}
+
+func newSTSListCommand(ctx context.Context) *cobra.Command {
+	var configFile, user string
+	command := &cobra.Command{
+		Use:   "list",
+		Short: "List temporary credentials",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			_, store, closeRuntime, err := openSTSRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			sessions, err := store.List(ctx, user)
+			if err != nil {
+				return fmt.Errorf("list session credentials: %w", err)
+			}
+			return writeCommandJSON(command, sessions)
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().StringVar(&user, "user", "", "only list credentials of this principal")
+	return command
+}
+
+func newSTSRevokeCommand(ctx context.Context) *cobra.Command {
+	var configFile, accessKeyID string
+	command := &cobra.Command{
+		Use:   "revoke",
+		Short: "Revoke a temporary credential immediately",
+		Args:  noPositionalArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if accessKeyID == "" {
+				return usageError("sts revoke requires --access-key-id")
+			}
+			_, store, closeRuntime, err := openSTSRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			if err := store.Revoke(ctx, "", accessKeyID); err != nil {
+				return commandError(fmt.Errorf("revoke session credential: %w", err))
+			}
+			return nil
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().StringVar(&accessKeyID, "access-key-id", "", "temporary access key to revoke")
+	return command
+}
+
+func openSTSRuntime(
+	ctx context.Context,
+	configFile string,
+) (*config.Config, *s3session.Store, func(), error) {
+	serviceConfig, err := config.Parse(configFile)
+	if err != nil {
+		return nil, nil, func() {}, fmt.Errorf("parse config: %w", err)
+	}
+	if err := serviceConfig.Validate(); err != nil {
+		return nil, nil, func() {}, fmt.Errorf("validate config: %w", err)
+	}
+	if !serviceConfig.S3.Enable {
+		return nil, nil, func() {}, usageError("temporary credentials require s3.enable")
+	}
+	if err := db.InitDBContext(ctx, serviceConfig.DBFile); err != nil {
+		return nil, nil, func() {}, fmt.Errorf("open database: %w", err)
+	}
+	return serviceConfig, s3session.New(db.GetClient()), func() { _ = db.Close() }, nil
+}
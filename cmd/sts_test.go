@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/s3session"
+)
+
+func createSTSCLIConfig(t *testing.T) string {
+	t.Helper()
+	directory := t.TempDir()
+	configFile := filepath.Join(directory, "config.json")
+	require.NoError(t, os.WriteFile(configFile, []byte(fmt.Sprintf(`{
+		"db_file":%q,
+		"bot_kind":"telegram",
+		"bot_config":{"chatid":1,"token":"secret","upload_min_interval_ms":1000},
+		"user_info":{"ci":"ci-secret","viewer":"viewer-secret"},
+		"user_permission":{"ci":["s3:write"],"viewer":["webdav:read"]},
+		"s3":{
+			"enable":true,
+			"buckets":[{"name":"private-data","acl":"private"}],
+			"max_object_size":5368709120
+		}
+	}`, filepath.Join(directory, "data.db"))), 0o600))
+	return configFile
+}
+
+func TestSTSIssueListAndRevoke(t *testing.T) {
+	configFile := createSTSCLIConfig(t)
+	code, stdout, stderr := executeForTest(
+		t, "sts", "issue", "--config="+configFile, "--user=ci",
+		"--bucket=private-data", "--prefix=ci/", "--duration=30m",
+	)
+	require.Zero(t, code, stderr)
+	var credential s3session.Credential
+	require.NoError(t, json.Unmarshal([]byte(stdout), &credential))
+	require.NotEmpty(t, credential.SessionToken)
+	require.Equal(t, "ci/", credential.Prefix)
+	require.False(t, credential.ReadOnly)
+
+	code, stdout, stderr = executeForTest(t, "sts", "list", "--config="+configFile, "--user=ci")
+	require.Zero(t, code, stderr)
+	require.Contains(t, stdout, credential.AccessKeyID)
+	require.NotContains(t, stdout, credential.SessionToken)
+
+	code, _, stderr = executeForTest(
+		t, "sts", "revoke", "--config="+configFile, "--access-key-id="+credential.AccessKeyID,
+	)
+	require.Zero(t, code, stderr)
+	code, _, stderr = executeForTest(t, "sts", "revoke", "--config="+configFile, "--access-key-id=TGSAMISSING")
+	require.Equal(t, 2, code)
+	require.Contains(t, stderr, "does not exist")
+}
+
+func TestSTSIssueRejectsPrincipalWithoutS3Permission(t *testing.T) {
+	configFile := createSTSCLIConfig(t)
+	for _, args := range [][]string{
+		{"--user=viewer"},
+		{"--user=missing"},
+		{"--user=ci", "--bucket=unknown"},
+		{},
+	} {
+		code, _, _ := executeForTest(t, append([]string{"sts", "issue", "--config=" + configFile}, args...)...)
+		require.Equal(t, 2, code, args)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+const cliTrashListLimit = 1000
+
+type trashItemOutput struct {
+	TrashID      uint64 `json:"trash_id,string"`
+	OriginalPath string `json:"original_path"`
+	IsDir        bool   `json:"is_dir"`
+	Size         int64  `json:"size"`
+	EntryCount   int64  `json:"entry_count"`
+	Principal    string `json:"principal"`
+	Protocol     string `json:"protocol"`
+	DeletedAt    int64  `json:"deleted_at"`
+	ExpiresAt    int64  `json:"expires_at,omitempty"`
+}
+
+func newTrashCommand(ctx context.Context) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "trash",
+		Short: "List, restore, or purge recycle bin items",
+		Args:  noPositionalArgs,
+		RunE: func(*cobra.Command, []string) error {
+			return usageError("a trash subcommand is required")
+		},
+	}
+	command.AddCommand(
+		newTrashListCommand(ctx),
+		newTrashRestoreCommand(ctx),
+		newTrashPurgeCommand(ctx),
+	)
+	return command
+}
+
+func newTrashListCommand(ctx context.Context) *cobra.Command {
+	var configFile string
+	command := &cobra.Command{
+		Use:   "list",
+		Short: "List recycle bin items, newest first",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			_, manager, closeRuntime, err := openFileRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			items := make([]trashItemOutput, 0)
+			request := filemgr.TrashListRequest{Limit: cliTrashListLimit}
+			for {
+				page, err := manager.ListTrash(ctx, request)
+				if err != nil {
+					return fmt.Errorf("list trash: %w", err)
+				}
+				for _, item := range page.Items {
+					items = append(items, toTrashItemOutput(&item))
+				}
+				if page.NextCursor == 0 {
+					break
+				}
+				request.Cursor = page.NextCursor
+			}
+			return writeCommandJSON(command, items)
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	return command
+}
+
+func newTrashRestoreCommand(ctx context.Context) *cobra.Command {
+	var configFile, destination string
+	var trashID uint64
+	command := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore a recycle bin item to its original path or to --path",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			if trashID == 0 {
+				return usageError("--id is required")
+			}
+			_, manager, closeRuntime, err := openFileRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			item, err := manager.RestoreTrash(ctx, trashID, destination)
+			if err != nil {
+				return fmt.Errorf("restore trash: %w", err)
+			}
+			return writeCommandJSON(command, toTrashItemOutput(item))
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().Uint64Var(&trashID, "id", 0, "trash item id")
+	command.Flags().StringVar(&destination, "path", "", "absolute restore path, the original path when empty")
+	return command
+}
+
+func newTrashPurgeCommand(ctx context.Context) *cobra.Command {
+	var configFile string
+	var trashID uint64
+	command := &cobra.Command{
+		Use:   "purge",
+		Short: "Delete a recycle bin item for good",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			if trashID == 0 {
+				return usageError("--id is required")
+			}
+			_, manager, closeRuntime, err := openFileRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			item, err := manager.PurgeTrash(ctx, trashID)
+			if err != nil {
+				return fmt.Errorf("purge trash: %w", err)
+			}
+			return writeCommandJSON(command, toTrashItemOutput(item))
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().Uint64Var(&trashID, "id", 0, "trash item id")
+	return command
+}
+
+func toTrashItemOutput(item *filemgr.TrashItem) trashItemOutput {
+	return trashItemOutput{
+		TrashID:      item.TrashID,
+		OriginalPath: item.OriginalPath,
+		IsDir:        item.IsDir,
+		Size:         item.Size,
+		EntryCount:   item.EntryCount,
+		Principal:    item.Principal,
+		Protocol:     item.Protocol,
+		DeletedAt:    item.DeletedAt,
+		ExpiresAt:    item.ExpiresAt,
+	}
+}
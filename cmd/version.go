@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+type fileVersionOutput struct {
+	VersionID uint64 `json:"version_id"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	Mtime     int64  `json:"mtime"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type fileVersionRestoreOutput struct {
+	Path    string `json:"path"`
+	Created bool   `json:"created"`
+}
+
+func newVersionCommand(ctx context.Context) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "versions",
+		Short: "List, restore, or delete the kept versions of overwritten files",
+		Args:  noPositionalArgs,
+		RunE: func(*cobra.Command, []string) error {
+			return usageError("a versions subcommand is required")
+		},
+	}
+	command.AddCommand(
+		newVersionListCommand(ctx),
+		newVersionRestoreCommand(ctx),
+		newVersionDeleteCommand(ctx),
+	)
+	return command
+}
+
+func newVersionListCommand(ctx context.Context) *cobra.Command {
+	var configFile, resourcePath string
+	command := &cobra.Command{
+		Use:   "list",
+		Short: "List the versions of --path, newest first",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			if resourcePath == "" {
+				return usageError("--path is required")
+			}
+			_, manager, closeRuntime, err := openFileRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			items := make([]fileVersionOutput, 0)
+			request := filemgr.FileVersionListRequest{Path: resourcePath, Limit: 1000}
+			for {
+				page, err := manager.ListFileVersions(ctx, request)
+				if err != nil {
+					return fmt.Errorf("list file versions: %w", err)
+				}
+				for _, version := range page.Versions {
+					items = append(items, toFileVersionOutput(&version))
+				}
+				if page.NextCursor == 0 {
+					return writeCommandJSON(command, items)
+				}
+				request.Cursor = page.NextCursor
+			}
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().StringVar(&resourcePath, "path", "", "absolute path of the overwritten file")
+	return command
+}
+
+func newVersionRestoreCommand(ctx context.Context) *cobra.Command {
+	var configFile, destination string
+	var versionID uint64
+	command := &cobra.Command{
+		Use:   "restore",
+		Short: "Publish version --id at its path, keeping the current content as a version",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			if versionID == 0 {
+				return usageError("--id is required")
+			}
+			_, manager, closeRuntime, err := openFileRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			if destination == "" {
+				version, err := manager.GetFileVersion(ctx, versionID)
+				if err != nil {
+					return fmt.Errorf("read file version: %w", err)
+				}
+				destination = version.Path
+			}
+			result, err := manager.RestoreFileVersion(ctx, versionID, destination, filemgr.WebDAVMutationOptions{})
+			if err != nil {
+				return fmt.Errorf("restore file version: %w", err)
+			}
+			return writeCommandJSON(command, fileVersionRestoreOutput{Path: destination, Created: result.Created})
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().Uint64Var(&versionID, "id", 0, "version id")
+	command.Flags().StringVar(&destination, "destination", "", "absolute restore path, the version's path when empty")
+	return command
+}
+
+func newVersionDeleteCommand(ctx context.Context) *cobra.Command {
+	var configFile string
+	var versionID uint64
+	command := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete version --id and release its file",
+		Args:  noPositionalArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			if versionID == 0 {
+				return usageError("--id is required")
+			}
+			_, manager, closeRuntime, err := openFileRuntime(ctx, configFile)
+			if err != nil {
+				return err
+			}
+			defer closeRuntime()
+			version, err := manager.DeleteFileVersion(ctx, versionID)
+			if err != nil {
+				return fmt.Errorf("delete file version: %w", err)
+			}
+			return writeCommandJSON(command, toFileVersionOutput(version))
+		},
+	}
+	command.Flags().StringVar(&configFile, "config", "./config.json", "config file path")
+	command.Flags().Uint64Var(&versionID, "id", 0, "version id")
+	return command
+}
+
+func toFileVersionOutput(version *filemgr.FileVersion) fileVersionOutput {
+	return fileVersionOutput{
+		VersionID: version.VersionID,
+		Path:      version.Path,
+		Size:      version.Size,
+		Mtime:     version.Mtime,
+		CreatedAt: version.CreatedAt,
+	}
+}
@@ -9,8 +9,10 @@ import (
 	"net"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -32,10 +34,16 @@ func (c *Config) SafeLogFields() []zap.Field {
 		zap.String("bind", c.Bind),
 		zap.String("db_file", c.DBFile),
 		zap.String("bot_kind", c.BotKind),
+		zap.String("storage_class", c.StorageClass),
+		zap.Strings("storage_classes", c.StorageClassNames()),
 		zap.Strings("external_origins", c.ExternalOrigins),
 		zap.Bool("s3_enable", c.S3.Enable),
 		zap.Strings("s3_buckets", c.S3.BucketNames()),
 		zap.Int("s3_multipart_expire_hours", c.S3.MultipartExpireHours),
+		zap.String("s3_base_domain", c.S3.BaseDomain),
+		zap.Int("s3_replication_rule_count", c.S3.ReplicationRuleCount()),
+		zap.Strings("s3_replication_targets", c.S3.ReplicationTargetNames()),
+		zap.Int("s3_lifecycle_rule_count", c.S3.LifecycleRuleCount()),
 		zap.Bool("webdav_enable", c.Webdav.Enable),
 		zap.String("webdav_root", c.Webdav.Root),
 		zap.Int64("webdav_max_upload_size", c.Webdav.MaxUploadSize),
@@ -44,6 +52,36 @@ func (c *Config) SafeLogFields() []zap.Field {
 		zap.String("backup_work_dir", c.Backup.WorkDir),
 		zap.Int64("backup_max_archive_bytes", c.Backup.MaxArchiveBytes),
 		zap.Int64("backup_max_expanded_bytes", c.Backup.MaxExpandedBytes),
+		zap.Bool("trash_enable", c.Trash.Enable),
+		zap.Int("trash_retention_days", c.Trash.RetentionDays),
+		zap.Bool("snapshot_enable", c.Snapshot.Enable),
+		zap.Int("snapshot_retention_days", c.Snapshot.RetentionDays),
+		zap.Int("snapshot_schedule_count", len(c.Snapshot.Schedules)),
+		zap.Bool("version_enable", c.Version.Enable),
+		zap.Int("version_keep_count", c.Version.KeepCount),
+		zap.Int("version_keep_days", c.Version.KeepDays),
+		zap.Bool("thumbnail_enable", c.Thumbnail.Enable),
+		zap.Int("thumbnail_max_edge", c.Thumbnail.MaxEdge),
+		zap.Int("thumbnail_quality", c.Thumbnail.Quality),
+		zap.Int64("thumbnail_max_source_bytes", c.Thumbnail.MaxSourceBytes),
+		zap.Int64("thumbnail_max_source_pixels", c.Thumbnail.MaxSourcePixels),
+		zap.Int("archive_max_entries", c.Archive.MaxEntries),
+		zap.Int64("archive_max_bytes", c.Archive.MaxBytes),
+		zap.Bool("file_key_disable_legacy", c.FileKey.DisableLegacy),
+		zap.Bool("tus_enable", c.Tus.Enable),
+		zap.String("tus_temp_dir", c.Tus.TempDir),
+		zap.Int64("tus_max_upload_size", c.Tus.MaxUploadSize),
+		zap.Int("tus_expire_hours", c.Tus.ExpireHours),
+		zap.Int64("file_upload_max_upload_size", c.FileUpload.MaxUploadSize),
+		zap.String("file_upload_temp_dir", c.FileUpload.TempDir),
+		zap.Bool("fetch_enable", c.Fetch.Enable),
+		zap.String("fetch_work_dir", c.Fetch.WorkDir),
+		zap.Int64("fetch_max_size", c.Fetch.MaxSize),
+		zap.Int("fetch_workers", c.Fetch.Workers),
+		zap.Bool("fetch_allow_private_networks", c.Fetch.AllowPrivateNetworks),
+		zap.Int64("quota_default_hard_bytes", c.Quota.Default.HardBytes),
+		zap.Int64("quota_default_hard_objects", c.Quota.Default.HardObjects),
+		zap.Int("quota_user_count", len(c.Quota.Users)),
 		zap.Bool("admin_enable", c.Admin.Enable),
 		zap.Int64("admin_max_upload_size", c.Admin.MaxUploadSize),
 		zap.Bool("l1_cache_enable", c.IOCache.EnableL1Cache),
@@ -82,6 +120,10 @@ func (c *Config) SafeLogFields() []zap.Field {
 			"file_write_user_count",
 			permissionUserCount(authorizer, c.UserInfo, authz.FileWrite),
 		),
+		zap.Int(
+			"share_write_user_count",
+			permissionUserCount(authorizer, c.UserInfo, authz.ShareWrite),
+		),
 	}
 }
 
@@ -100,15 +142,83 @@ func permissionUserCount(
 }
 
 type S3BucketConfig struct {
-	Name string `json:"name"`
-	ACL  string `json:"acl"`
+	Name        string                    `json:"name"`
+	ACL         string                    `json:"acl"`
+	Replication []S3ReplicationRuleConfig `json:"replication"`
+	Lifecycle   []S3LifecycleRuleConfig   `json:"lifecycle"`
+}
+
+// S3LifecycleRuleConfig moves objects under Prefix into StorageClass once
+// they have not been modified for Days days.
+type S3LifecycleRuleConfig struct {
+	ID           string `json:"id"`
+	Prefix       string `json:"prefix"`
+	Days         int    `json:"days"`
+	StorageClass string `json:"storage_class"`
+}
+
+// S3ReplicationRuleConfig copies new and changed objects under Prefix to a
+// bucket on another S3 compatible endpoint, such as a second tgfile instance.
+// These legacy rules only seed the stored rules of a bucket that has none;
+// rules are otherwise managed with PutBucketReplication.
+type S3ReplicationRuleConfig struct {
+	ID               string `json:"id"`
+	Prefix           string `json:"prefix"`
+	Endpoint         string `json:"endpoint"`
+	Bucket           string `json:"bucket"`
+	Region           string `json:"region"`
+	AccessKey        string `json:"access_key"`
+	SecretKey        string `json:"secret_key"`
+	ReplicateDeletes bool   `json:"replicate_deletes"`
+}
+
+// S3ReplicationTargetConfig is an S3 compatible endpoint that replication
+// rules name in their Destination Account.
+type S3ReplicationTargetConfig struct {
+	Name      string `json:"name"`
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
 }
 
 type S3Config struct {
-	Enable               bool             `json:"enable"`
-	Buckets              []S3BucketConfig `json:"buckets"`
-	MaxObjectSize        int64            `json:"max_object_size"`
-	MultipartExpireHours int              `json:"multipart_expire_hours"`
+	Enable               bool                        `json:"enable"`
+	Buckets              []S3BucketConfig            `json:"buckets"`
+	MaxObjectSize        int64                       `json:"max_object_size"`
+	MultipartExpireHours int                         `json:"multipart_expire_hours"`
+	BaseDomain           string                      `json:"base_domain"`
+	ReplicationTargets   []S3ReplicationTargetConfig `json:"replication_targets"`
+}
+
+func (c S3Config) ReplicationRuleCount() int {
+	count := 0
+	for _, bucket := range c.Buckets {
+		count += len(bucket.Replication)
+	}
+	return count
+}
+
+func (c S3Config) ReplicationTargetNames() []string {
+	names := make([]string, 0, len(c.ReplicationTargets))
+	for _, target := range c.ReplicationTargets {
+		names = append(names, target.Name)
+	}
+	return names
+}
+
+// LegacyReplicationTarget names the target implied by a legacy rule. The
+// slash keeps it apart from the names of configured targets.
+func LegacyReplicationTarget(bucket, ruleID string) string {
+	return bucket + "/" + ruleID
+}
+
+func (c S3Config) LifecycleRuleCount() int {
+	count := 0
+	for _, bucket := range c.Buckets {
+		count += len(bucket.Lifecycle)
+	}
+	return count
 }
 
 func (c S3Config) BucketNames() []string {
@@ -127,6 +237,32 @@ type WebdavConfig struct {
 	QuotaBytes         int64  `json:"quota_bytes"`
 	MaxMutationEntries int    `json:"max_mutation_entries"`
 	SyncPageSize       int    `json:"sync_page_size"`
+	// Home and Shares replace the single Root with named mounts served at
+	// /webdav/<name>/; the home mount is named "home".
+	Home   WebdavHomeConfig    `json:"home"`
+	Shares []WebdavShareConfig `json:"shares"`
+	// Groups names sets of users that WebDAV ACL entries can grant or deny
+	// privileges to as one principal.
+	Groups map[string][]string `json:"groups"`
+}
+
+// WebdavHomeConfig gives every principal a private tree. Root must contain
+// {user} as one whole path segment, such as /home/{user}.
+type WebdavHomeConfig struct {
+	Root       string `json:"root"`
+	QuotaBytes int64  `json:"quota_bytes"`
+}
+
+type WebdavShareConfig struct {
+	Name       string `json:"name"`
+	Root       string `json:"root"`
+	Access     string `json:"access"`
+	QuotaBytes int64  `json:"quota_bytes"`
+}
+
+// HasMounts reports whether /webdav serves named mounts instead of Root.
+func (c WebdavConfig) HasMounts() bool {
+	return c.Home.Root != "" || len(c.Shares) != 0
 }
 
 type IOCacheConfig struct {
@@ -152,6 +288,113 @@ type BackupConfig struct {
 	JobRetentionDays       int    `json:"job_retention_days"`
 }
 
+// TrashConfig turns deletes into moves to the recycle bin, which keeps them
+// for RetentionDays before they are purged.
+type TrashConfig struct {
+	Enable        bool `json:"enable"`
+	RetentionDays int  `json:"retention_days"`
+}
+
+// SnapshotConfig enables named namespace snapshots. RetentionDays is the
+// default lifetime of a snapshot; zero keeps snapshots until they are
+// deleted.
+type SnapshotConfig struct {
+	Enable        bool                     `json:"enable"`
+	RetentionDays int                      `json:"retention_days"`
+	Schedules     []SnapshotScheduleConfig `json:"schedules"`
+}
+
+// SnapshotScheduleConfig snapshots Scope every IntervalHours under names
+// derived from Name.
+type SnapshotScheduleConfig struct {
+	Name          string `json:"name"`
+	Scope         string `json:"scope"`
+	IntervalHours int    `json:"interval_hours"`
+}
+
+// VersionConfig keeps the previous content of paths that WebDAV or S3
+// overwrite. KeepCount bounds the versions per path and KeepDays their age;
+// zero leaves that bound off, but one of them has to be set.
+type VersionConfig struct {
+	Enable    bool `json:"enable"`
+	KeepCount int  `json:"keep_count"`
+	KeepDays  int  `json:"keep_days"`
+}
+
+// ThumbnailConfig renders JPEG previews of JPEG, PNG and GIF files on first
+// request. MaxEdge bounds the longest edge of a preview; sources above
+// MaxSourceBytes or MaxSourcePixels are not decoded. Zero values take the
+// defaults.
+type ThumbnailConfig struct {
+	Enable          bool  `json:"enable"`
+	MaxEdge         int   `json:"max_edge"`
+	Quality         int   `json:"quality"`
+	MaxSourceBytes  int64 `json:"max_source_bytes"`
+	MaxSourcePixels int64 `json:"max_source_pixels"`
+}
+
+// ArchiveConfig bounds the ZIP and TAR directory downloads. MaxEntries counts
+// files and directories, MaxBytes the total file size. Zero values take the
+// defaults.
+type ArchiveConfig struct {
+	MaxEntries int   `json:"max_entries"`
+	MaxBytes   int64 `json:"max_bytes"`
+}
+
+// FileKeyConfig controls the keys of direct uploads. New uploads always get
+// random token keys; DisableLegacy stops the computed legacy keys from
+// resolving, which should follow `tgfile file-keys migrate`.
+type FileKeyConfig struct {
+	DisableLegacy bool `json:"disable_legacy"`
+}
+
+// TusConfig enables tus resumable uploads at /file/tus/. TempDir holds the
+// bytes of each upload that have not yet filled a block; uploads untouched
+// for ExpireHours are discarded. Zero values take the defaults.
+type TusConfig struct {
+	Enable        bool   `json:"enable"`
+	TempDir       string `json:"temp_dir"`
+	MaxUploadSize int64  `json:"max_upload_size"`
+	ExpireHours   int    `json:"expire_hours"`
+}
+
+// FileUploadConfig limits the raw-body uploads at /file/upload/{name}. A
+// body sent without Content-Length is spooled to TempDir before it is
+// stored. Zero values take the defaults.
+type FileUploadConfig struct {
+	MaxUploadSize int64  `json:"max_upload_size"`
+	TempDir       string `json:"temp_dir"`
+}
+
+// FetchConfig enables the remote URL fetch jobs at /fetch/v1/jobs. WorkDir
+// spools bodies that do not declare their length. Sources on loopback,
+// private and link-local addresses are refused unless AllowPrivateNetworks is
+// set. Zero values take the defaults.
+type FetchConfig struct {
+	Enable               bool   `json:"enable"`
+	WorkDir              string `json:"work_dir"`
+	MaxSize              int64  `json:"max_size"`
+	MaxRetries           int    `json:"max_retries"`
+	Workers              int    `json:"workers"`
+	AllowPrivateNetworks bool   `json:"allow_private_networks"`
+	JobRetentionDays     int    `json:"job_retention_days"`
+}
+
+// QuotaConfig limits the logical bytes and file count each principal owns.
+// An entry in Users replaces Default for that user. Zero limits are
+// unlimited; soft limits are only reported.
+type QuotaConfig struct {
+	Default QuotaLimitConfig            `json:"default"`
+	Users   map[string]QuotaLimitConfig `json:"users"`
+}
+
+type QuotaLimitConfig struct {
+	HardBytes   int64 `json:"hard_bytes"`
+	SoftBytes   int64 `json:"soft_bytes"`
+	HardObjects int64 `json:"hard_objects"`
+	SoftObjects int64 `json:"soft_objects"`
+}
+
 type AdminConfig struct {
 	Enable             bool  `json:"enable"`
 	SessionIdleMinutes int   `json:"session_idle_minutes"`
@@ -159,21 +402,41 @@ type AdminConfig struct {
 	MaxUploadSize      int64 `json:"max_upload_size"`
 }
 
+// StorageClassConfig adds a block backend that serves one S3 storage class
+// next to the primary bot_kind backend.
+type StorageClassConfig struct {
+	Name    string `json:"name"`
+	BotKind string `json:"bot_kind"`
+	BotInfo any    `json:"bot_config"`
+}
+
 type Config struct {
-	Bind            string              `json:"bind"`
-	LogInfo         logger.LogConfig    `json:"log_info"`
-	DBFile          string              `json:"db_file"`
-	BotKind         string              `json:"bot_kind"`
-	BotInfo         any                 `json:"bot_config"`
-	UserInfo        map[string]string   `json:"user_info"`
-	UserPermission  map[string][]string `json:"user_permission"`
-	ExternalOrigins []string            `json:"external_origin"`
-	S3              S3Config            `json:"s3"`
-	RotateStream    int                 `json:"rotate_stream"`
-	Webdav          WebdavConfig        `json:"webdav"`
-	IOCache         IOCacheConfig       `json:"io_cache"`
-	Backup          BackupConfig        `json:"backup"`
-	Admin           AdminConfig         `json:"admin"`
+	Bind            string               `json:"bind"`
+	LogInfo         logger.LogConfig     `json:"log_info"`
+	DBFile          string               `json:"db_file"`
+	BotKind         string               `json:"bot_kind"`
+	BotInfo         any                  `json:"bot_config"`
+	StorageClass    string               `json:"storage_class"`
+	StorageClasses  []StorageClassConfig `json:"storage_classes"`
+	UserInfo        map[string]string    `json:"user_info"`
+	UserPermission  map[string][]string  `json:"user_permission"`
+	ExternalOrigins []string             `json:"external_origin"`
+	S3              S3Config             `json:"s3"`
+	RotateStream    int                  `json:"rotate_stream"`
+	Webdav          WebdavConfig         `json:"webdav"`
+	IOCache         IOCacheConfig        `json:"io_cache"`
+	Backup          BackupConfig         `json:"backup"`
+	Trash           TrashConfig          `json:"trash"`
+	Snapshot        SnapshotConfig       `json:"snapshot"`
+	Version         VersionConfig        `json:"version"`
+	Thumbnail       ThumbnailConfig      `json:"thumbnail"`
+	Archive         ArchiveConfig        `json:"archive"`
+	FileKey         FileKeyConfig        `json:"file_key"`
+	Tus             TusConfig            `json:"tus"`
+	FileUpload      FileUploadConfig     `json:"file_upload"`
+	Fetch           FetchConfig          `json:"fetch"`
+	Quota           QuotaConfig          `json:"quota"`
+	Admin           AdminConfig          `json:"admin"`
 }
 
 func Parse(f string) (*Config, error) {
@@ -203,9 +466,18 @@ var (
 	errInvalidConfig         = errors.New("invalid configuration")
 	errMultipleJSONDocuments = errors.New("multiple JSON documents")
 	bucketNamePattern        = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	domainLabelPattern       = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	snapshotSchedulePattern  = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,46}$`)
+	storageClassPattern      = regexp.MustCompile(`^[A-Z][A-Z0-9_]{0,63}$`)
+	webdavShareNamePattern   = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,63}$`)
+	replicationTargetPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]{0,63}$`)
 	reservedBuckets          = map[string]struct{}{
 		"backup": {},
+		"fetch":  {},
 		"file":   {},
+		"quota":  {},
+		"share":  {},
+		"sts":    {},
 		"webdav": {},
 	}
 )
@@ -217,6 +489,7 @@ const (
 	defaultWebDAVMaxUploadSize      int64 = 5 * 1024 * 1024 * 1024
 	defaultWebDAVMutationEntries          = 100_000
 	defaultWebDAVSyncPageSize             = 1_000
+	webdavUserPlaceholder                 = "{user}"
 	defaultBackupMaxArchiveBytes    int64 = 100 * 1024 * 1024 * 1024
 	defaultBackupMaxExpandedBytes   int64 = 1024 * 1024 * 1024 * 1024
 	defaultBackupMaxMappingCount          = 100_000
@@ -225,13 +498,34 @@ const (
 	defaultBackupMaxPathBytes             = 1024
 	defaultArtifactRetentionHours         = 24
 	defaultBackupJobRetentionDays         = 30
+	defaultTrashRetentionDays             = 30
 	defaultAdminSessionIdleMinutes        = 30
 	defaultAdminSessionMaxHours           = 12
 	defaultAdminMaxUploadSize       int64 = 5 * 1024 * 1024 * 1024
+	defaultThumbnailMaxEdge               = 256
+	defaultThumbnailQuality               = 80
+	defaultThumbnailMaxSourceBytes  int64 = 32 * 1024 * 1024
+	defaultThumbnailMaxSourcePixels int64 = 40_000_000
+	defaultArchiveMaxEntries              = 10_000
+	defaultArchiveMaxBytes          int64 = 10 * 1024 * 1024 * 1024
+	defaultTusMaxUploadSize         int64 = 5 * 1024 * 1024 * 1024
+	defaultTusExpireHours                 = 24
+	defaultFileUploadMaxSize        int64 = 5 * 1024 * 1024 * 1024
+	defaultFetchMaxSize             int64 = 5 * 1024 * 1024 * 1024
+	defaultFetchMaxRetries                = 5
+	defaultFetchWorkers                   = 2
+	defaultFetchJobRetentionDays          = 30
+	maxFetchRetries                       = 100
+	maxFetchWorkers                       = 32
+	maxTusExpireHours                     = 24 * 30
 	maxExternalOrigins                    = 32
 	maxAdminUploadSize              int64 = 10 * 1024 * 1024 * 1024 * 1024
 	maxBackupArchiveBytes           int64 = 10 * 1024 * 1024 * 1024 * 1024
 	maxBackupExpandedBytes          int64 = 100 * 1024 * 1024 * 1024 * 1024
+	maxThumbnailSourceBytes         int64 = 1024 * 1024 * 1024
+	maxThumbnailSourcePixels        int64 = 200_000_000
+	maxArchiveEntries                     = 1_000_000
+	maxArchiveBytes                 int64 = 10 * 1024 * 1024 * 1024 * 1024
 )
 
 func (c *Config) Validate() error {
@@ -245,6 +539,9 @@ func (c *Config) Validate() error {
 	if err := c.validateExternalOrigins(); err != nil {
 		return err
 	}
+	if err := c.validateStorageClasses(); err != nil {
+		return err
+	}
 	if err := c.validateS3(); err != nil {
 		return err
 	}
@@ -257,6 +554,9 @@ func (c *Config) Validate() error {
 	if err := c.validateBackup(authorizer); err != nil {
 		return err
 	}
+	if err := c.validateFileFeatures(); err != nil {
+		return err
+	}
 	if err := c.validateAdmin(authorizer); err != nil {
 		return err
 	}
@@ -364,34 +664,24 @@ func (c *Config) validateIOCachePathConflicts() error {
 	if err != nil {
 		return fmt.Errorf("%w: canonicalize io_cache.l2_cache_dir: %w", errInvalidConfig, err)
 	}
-	paths := []struct {
-		name string
-		path string
-	}{
+	paths := []configPath{
 		{name: "db_file", path: c.DBFile},
 		{name: "backup.work_dir", path: c.Backup.WorkDir},
 		{name: "webdav.upload_temp_dir", path: c.Webdav.UploadTempDir},
+		{name: "tus.temp_dir", path: c.Tus.TempDir},
+		{name: "file_upload.temp_dir", path: c.FileUpload.TempDir},
+		{name: "fetch.work_dir", path: c.Fetch.WorkDir},
 	}
-	if c.BotKind == "localfile" {
-		var localConfig struct {
-			Dir        string `json:"dir"`
-			StorageDir string `json:"storage_dir"`
-		}
-		raw, err := json.Marshal(c.BotInfo)
+	paths, err = appendLocalfilePath(paths, "bot_config.dir", c.BotKind, c.BotInfo)
+	if err != nil {
+		return err
+	}
+	for index, tier := range c.StorageClasses {
+		name := fmt.Sprintf("storage_classes[%d].bot_config.dir", index)
+		paths, err = appendLocalfilePath(paths, name, tier.BotKind, tier.BotInfo)
 		if err != nil {
-			return fmt.Errorf("%w: encode localfile configuration: %w", errInvalidConfig, err)
+			return err
 		}
-		if err := json.Unmarshal(raw, &localConfig); err != nil {
-			return fmt.Errorf("%w: decode localfile configuration: %w", errInvalidConfig, err)
-		}
-		backendDir := localConfig.Dir
-		if backendDir == "" {
-			backendDir = localConfig.StorageDir
-		}
-		paths = append(paths, struct {
-			name string
-			path string
-		}{name: "bot_config.dir", path: backendDir})
 	}
 	for _, candidate := range paths {
 		if candidate.path == "" {
@@ -452,6 +742,276 @@ func pathsOverlap(left, right string) bool {
 		(rightToLeft != ".." && !strings.HasPrefix(rightToLeft, ".."+string(filepath.Separator)))
 }
 
+type configPath struct {
+	name string
+	path string
+}
+
+func appendLocalfilePath(paths []configPath, name, kind string, info any) ([]configPath, error) {
+	if kind != "localfile" {
+		return paths, nil
+	}
+	dir, err := localfileBackendDir(info)
+	if err != nil {
+		return nil, err
+	}
+	return append(paths, configPath{name: name, path: dir}), nil
+}
+
+func localfileBackendDir(info any) (string, error) {
+	var localConfig struct {
+		Dir        string `json:"dir"`
+		StorageDir string `json:"storage_dir"`
+	}
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return "", fmt.Errorf("%w: encode localfile configuration: %w", errInvalidConfig, err)
+	}
+	if err := json.Unmarshal(raw, &localConfig); err != nil {
+		return "", fmt.Errorf("%w: decode localfile configuration: %w", errInvalidConfig, err)
+	}
+	if localConfig.Dir != "" {
+		return localConfig.Dir, nil
+	}
+	return localConfig.StorageDir, nil
+}
+
+func (c *Config) validateTrash() error {
+	if c.Trash.RetentionDays == 0 {
+		c.Trash.RetentionDays = defaultTrashRetentionDays
+	}
+	if c.Trash.RetentionDays < 1 || c.Trash.RetentionDays > 3650 {
+		return fmt.Errorf(
+			"%w: trash.retention_days must be between 1 and 3650",
+			errInvalidConfig,
+		)
+	}
+	return nil
+}
+
+func (c *Config) validateSnapshot() error {
+	if c.Snapshot.RetentionDays < 0 || c.Snapshot.RetentionDays > 3650 {
+		return fmt.Errorf(
+			"%w: snapshot.retention_days must be between 0 and 3650",
+			errInvalidConfig,
+		)
+	}
+	names := make(map[string]struct{}, len(c.Snapshot.Schedules))
+	for index, schedule := range c.Snapshot.Schedules {
+		if !snapshotSchedulePattern.MatchString(schedule.Name) {
+			return fmt.Errorf(
+				"%w: snapshot.schedules[%d].name must be 1 to 47 letters, digits, '.', '_' or '-'",
+				errInvalidConfig,
+				index,
+			)
+		}
+		if _, exists := names[schedule.Name]; exists {
+			return fmt.Errorf("%w: duplicate snapshot schedule %q", errInvalidConfig, schedule.Name)
+		}
+		names[schedule.Name] = struct{}{}
+		if !strings.HasPrefix(schedule.Scope, "/") || path.Clean(schedule.Scope) != schedule.Scope {
+			return fmt.Errorf(
+				"%w: snapshot.schedules[%d].scope must be a clean absolute path",
+				errInvalidConfig,
+				index,
+			)
+		}
+		if schedule.IntervalHours < 1 || schedule.IntervalHours > 8760 {
+			return fmt.Errorf(
+				"%w: snapshot.schedules[%d].interval_hours must be between 1 and 8760",
+				errInvalidConfig,
+				index,
+			)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateVersion() error {
+	if c.Version.KeepCount < 0 || c.Version.KeepCount > 10000 {
+		return fmt.Errorf("%w: version.keep_count must be between 0 and 10000", errInvalidConfig)
+	}
+	if c.Version.KeepDays < 0 || c.Version.KeepDays > 3650 {
+		return fmt.Errorf("%w: version.keep_days must be between 0 and 3650", errInvalidConfig)
+	}
+	if c.Version.Enable && c.Version.KeepCount == 0 && c.Version.KeepDays == 0 {
+		return fmt.Errorf("%w: version needs keep_count or keep_days", errInvalidConfig)
+	}
+	return nil
+}
+
+func (c *Config) validateThumbnail() error {
+	c.applyThumbnailDefaults()
+	if c.Thumbnail.MaxEdge < 16 || c.Thumbnail.MaxEdge > 2048 {
+		return fmt.Errorf("%w: thumbnail.max_edge must be between 16 and 2048", errInvalidConfig)
+	}
+	if c.Thumbnail.Quality < 1 || c.Thumbnail.Quality > 100 {
+		return fmt.Errorf("%w: thumbnail.quality must be between 1 and 100", errInvalidConfig)
+	}
+	if c.Thumbnail.MaxSourceBytes < 1 || c.Thumbnail.MaxSourceBytes > maxThumbnailSourceBytes {
+		return fmt.Errorf("%w: thumbnail.max_source_bytes must be between 1 and 1GiB", errInvalidConfig)
+	}
+	if c.Thumbnail.MaxSourcePixels < 1 || c.Thumbnail.MaxSourcePixels > maxThumbnailSourcePixels {
+		return fmt.Errorf("%w: thumbnail.max_source_pixels must be between 1 and 200000000", errInvalidConfig)
+	}
+	return nil
+}
+
+func (c *Config) applyThumbnailDefaults() {
+	if c.Thumbnail.MaxEdge == 0 {
+		c.Thumbnail.MaxEdge = defaultThumbnailMaxEdge
+	}
+	if c.Thumbnail.Quality == 0 {
+		c.Thumbnail.Quality = defaultThumbnailQuality
+	}
+	if c.Thumbnail.MaxSourceBytes == 0 {
+		c.Thumbnail.MaxSourceBytes = defaultThumbnailMaxSourceBytes
+	}
+	if c.Thumbnail.MaxSourcePixels == 0 {
+		c.Thumbnail.MaxSourcePixels = defaultThumbnailMaxSourcePixels
+	}
+}
+
+func (c *Config) validateFileFeatures() error {
+	for _, validate := range []func() error{
+		c.validateTrash,
+		c.validateSnapshot,
+		c.validateVersion,
+		c.validateThumbnail,
+		c.validateArchive,
+		c.validateTus,
+		c.validateFileUpload,
+		c.validateFetch,
+		c.validateQuota,
+	} {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateTus() error {
+	if c.Tus.MaxUploadSize == 0 {
+		c.Tus.MaxUploadSize = defaultTusMaxUploadSize
+	}
+	if c.Tus.ExpireHours == 0 {
+		c.Tus.ExpireHours = defaultTusExpireHours
+	}
+	if strings.TrimSpace(c.Tus.TempDir) == "" {
+		c.Tus.TempDir = filepath.Join(filepath.Dir(c.DBFile), "tus-upload")
+	}
+	if c.Tus.MaxUploadSize < 1 || c.Tus.MaxUploadSize > maxAdminUploadSize {
+		return fmt.Errorf("%w: tus.max_upload_size must be between 1 and 10TiB", errInvalidConfig)
+	}
+	if c.BotKind == "telegram" && c.Tus.MaxUploadSize > maxFilePartCount*telegramBlockSize {
+		return fmt.Errorf("%w: tus.max_upload_size exceeds Telegram storage limit", errInvalidConfig)
+	}
+	if c.Tus.ExpireHours < 1 || c.Tus.ExpireHours > maxTusExpireHours {
+		return fmt.Errorf("%w: tus.expire_hours must be between 1 and 720", errInvalidConfig)
+	}
+	return nil
+}
+
+func (c *Config) validateFileUpload() error {
+	if c.FileUpload.MaxUploadSize == 0 {
+		c.FileUpload.MaxUploadSize = defaultFileUploadMaxSize
+	}
+	if strings.TrimSpace(c.FileUpload.TempDir) == "" {
+		c.FileUpload.TempDir = filepath.Join(filepath.Dir(c.DBFile), "file-upload")
+	}
+	if c.FileUpload.MaxUploadSize < 1 || c.FileUpload.MaxUploadSize > maxAdminUploadSize {
+		return fmt.Errorf("%w: file_upload.max_upload_size must be between 1 and 10TiB", errInvalidConfig)
+	}
+	if c.BotKind == "telegram" && c.FileUpload.MaxUploadSize > maxFilePartCount*telegramBlockSize {
+		return fmt.Errorf("%w: file_upload.max_upload_size exceeds Telegram storage limit", errInvalidConfig)
+	}
+	return nil
+}
+
+func (c *Config) validateFetch() error {
+	if c.Fetch.MaxSize == 0 {
+		c.Fetch.MaxSize = defaultFetchMaxSize
+	}
+	if c.Fetch.MaxRetries == 0 {
+		c.Fetch.MaxRetries = defaultFetchMaxRetries
+	}
+	if c.Fetch.Workers == 0 {
+		c.Fetch.Workers = defaultFetchWorkers
+	}
+	if c.Fetch.JobRetentionDays == 0 {
+		c.Fetch.JobRetentionDays = defaultFetchJobRetentionDays
+	}
+	if strings.TrimSpace(c.Fetch.WorkDir) == "" {
+		c.Fetch.WorkDir = filepath.Join(filepath.Dir(c.DBFile), "fetch")
+	}
+	workDir, err := filepath.Abs(c.Fetch.WorkDir)
+	if err != nil {
+		return fmt.Errorf("%w: resolve fetch.work_dir: %w", errInvalidConfig, err)
+	}
+	c.Fetch.WorkDir = workDir
+	if c.Fetch.MaxSize < 1 || c.Fetch.MaxSize > maxAdminUploadSize {
+		return fmt.Errorf("%w: fetch.max_size must be between 1 and 10TiB", errInvalidConfig)
+	}
+	if c.BotKind == "telegram" && c.Fetch.MaxSize > maxFilePartCount*telegramBlockSize {
+		return fmt.Errorf("%w: fetch.max_size exceeds Telegram storage limit", errInvalidConfig)
+	}
+	if c.Fetch.MaxRetries < 1 || c.Fetch.MaxRetries > maxFetchRetries {
+		return fmt.Errorf("%w: fetch.max_retries must be between 1 and 100", errInvalidConfig)
+	}
+	if c.Fetch.Workers < 1 || c.Fetch.Workers > maxFetchWorkers {
+		return fmt.Errorf("%w: fetch.workers must be between 1 and 32", errInvalidConfig)
+	}
+	if c.Fetch.JobRetentionDays < 1 || c.Fetch.JobRetentionDays > 365 {
+		return fmt.Errorf("%w: fetch.job_retention_days must be between 1 and 365", errInvalidConfig)
+	}
+	return nil
+}
+
+func (c *Config) validateQuota() error {
+	if err := validateQuotaLimits("quota.default", c.Quota.Default); err != nil {
+		return err
+	}
+	for user, limits := range c.Quota.Users {
+		if _, exists := c.UserInfo[user]; !exists {
+			return fmt.Errorf("%w: quota.users references unknown user %q", errInvalidConfig, user)
+		}
+		if err := validateQuotaLimits("quota.users."+user, limits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateQuotaLimits(field string, limits QuotaLimitConfig) error {
+	if limits.HardBytes < 0 || limits.SoftBytes < 0 || limits.HardObjects < 0 || limits.SoftObjects < 0 {
+		return fmt.Errorf("%w: %s limits must not be negative", errInvalidConfig, field)
+	}
+	if limits.HardBytes > 0 && limits.SoftBytes > limits.HardBytes {
+		return fmt.Errorf("%w: %s.soft_bytes must not exceed hard_bytes", errInvalidConfig, field)
+	}
+	if limits.HardObjects > 0 && limits.SoftObjects > limits.HardObjects {
+		return fmt.Errorf("%w: %s.soft_objects must not exceed hard_objects", errInvalidConfig, field)
+	}
+	return nil
+}
+
+func (c *Config) validateArchive() error {
+	if c.Archive.MaxEntries == 0 {
+		c.Archive.MaxEntries = defaultArchiveMaxEntries
+	}
+	if c.Archive.MaxBytes == 0 {
+		c.Archive.MaxBytes = defaultArchiveMaxBytes
+	}
+	if c.Archive.MaxEntries < 1 || c.Archive.MaxEntries > maxArchiveEntries {
+		return fmt.Errorf("%w: archive.max_entries must be between 1 and 1000000", errInvalidConfig)
+	}
+	if c.Archive.MaxBytes < 1 || c.Archive.MaxBytes > maxArchiveBytes {
+		return fmt.Errorf("%w: archive.max_bytes must be between 1 and 10TiB", errInvalidConfig)
+	}
+	return nil
+}
+
 func (c *Config) validateAdmin(authorizer *authz.Authorizer) error {
 	if !c.Admin.Enable {
 		return nil
@@ -676,7 +1236,8 @@ func (c *Config) validateBackupPath() (string, error) {
 	workDir := filepath.Clean(c.Backup.WorkDir)
 	if workDir == filepath.Clean(c.DBFile) ||
 		(c.IOCache.L2CacheDir != "" && workDir == filepath.Clean(c.IOCache.L2CacheDir)) ||
-		(c.Webdav.UploadTempDir != "" && workDir == filepath.Clean(c.Webdav.UploadTempDir)) {
+		(c.Webdav.UploadTempDir != "" && workDir == filepath.Clean(c.Webdav.UploadTempDir)) ||
+		(c.Tus.TempDir != "" && workDir == filepath.Clean(c.Tus.TempDir)) {
 		return "", fmt.Errorf("%w: backup.work_dir conflicts with another data path", errInvalidConfig)
 	}
 	return workDir, nil
@@ -759,6 +1320,12 @@ func (c *Config) validateWebDAV(authorizer *authz.Authorizer) error {
 	if err := c.validateWebDAVLimits(); err != nil {
 		return err
 	}
+	if err := c.validateWebDAVMounts(authorizer); err != nil {
+		return err
+	}
+	if err := c.validateWebDAVGroups(); err != nil {
+		return err
+	}
 	if !authorizer.Any(authz.WebDAVRead) {
 		return fmt.Errorf(
 			"%w: webdav requires at least one user with webdav:read permission",
@@ -769,6 +1336,9 @@ func (c *Config) validateWebDAV(authorizer *authz.Authorizer) error {
 }
 
 func (c *Config) validateWebDAVPathAndUpload() error {
+	if c.Webdav.HasMounts() && strings.TrimSpace(c.Webdav.Root) != "" && path.Clean(c.Webdav.Root) != "/" {
+		return fmt.Errorf("%w: webdav.root cannot be combined with webdav.home or webdav.shares", errInvalidConfig)
+	}
 	if strings.TrimSpace(c.Webdav.Root) == "" {
 		c.Webdav.Root = "/"
 	}
@@ -819,6 +1389,121 @@ func (c *Config) validateWebDAVLimits() error {
 	return nil
 }
 
+// validateWebDAVMounts checks the home and share mounts. Mount roots must not
+// nest, so a lock, quota or sync scope in one mount never covers another.
+func (c *Config) validateWebDAVMounts(authorizer *authz.Authorizer) error {
+	roots := make(map[string]string, len(c.Webdav.Shares)+1)
+	if c.Webdav.Home.Root != "" {
+		prefix, err := c.validateWebDAVHome(authorizer)
+		if err != nil {
+			return err
+		}
+		roots["home"] = prefix
+	}
+	for index := range c.Webdav.Shares {
+		share := &c.Webdav.Shares[index]
+		if err := validateWebDAVShare(share); err != nil {
+			return err
+		}
+		if _, exists := roots[share.Name]; exists || share.Name == "home" {
+			return fmt.Errorf("%w: webdav share name %q is reserved or duplicated", errInvalidConfig, share.Name)
+		}
+		for name, root := range roots {
+			if pathContains(root, share.Root) || pathContains(share.Root, root) {
+				return fmt.Errorf(
+					"%w: webdav share %q overlaps mount %q",
+					errInvalidConfig,
+					share.Name,
+					name,
+				)
+			}
+		}
+		roots[share.Name] = share.Root
+	}
+	return nil
+}
+
+// validateWebDAVHome returns the static part of the home root that every
+// principal's home lives below.
+func (c *Config) validateWebDAVHome(authorizer *authz.Authorizer) (string, error) {
+	home := &c.Webdav.Home
+	if !strings.HasPrefix(home.Root, "/") {
+		return "", fmt.Errorf("%w: webdav.home.root must be an absolute path", errInvalidConfig)
+	}
+	home.Root = path.Clean(home.Root)
+	segments := strings.Split(home.Root, "/")
+	if strings.Count(home.Root, webdavUserPlaceholder) != 1 ||
+		!slices.Contains(segments, webdavUserPlaceholder) {
+		return "", fmt.Errorf(
+			"%w: webdav.home.root must contain %s as one whole path segment",
+			errInvalidConfig,
+			webdavUserPlaceholder,
+		)
+	}
+	if home.QuotaBytes < 0 {
+		return "", fmt.Errorf("%w: webdav.home.quota_bytes must not be negative", errInvalidConfig)
+	}
+	for username := range c.UserInfo {
+		if authorizer.Has(username, authz.WebDAVRead) &&
+			(username == "." || username == ".." || strings.ContainsAny(username, "/\\")) {
+			return "", fmt.Errorf("%w: username %q cannot name a webdav home", errInvalidConfig, username)
+		}
+	}
+	prefix, _, _ := strings.Cut(home.Root, "/"+webdavUserPlaceholder)
+	if prefix == "" {
+		prefix = "/"
+	}
+	return prefix, nil
+}
+
+// validateWebDAVGroups checks that group names can appear in a principal URL
+// and that every member is a configured user.
+func (c *Config) validateWebDAVGroups() error {
+	for name, members := range c.Webdav.Groups {
+		if !webdavShareNamePattern.MatchString(name) {
+			return fmt.Errorf(
+				"%w: webdav group name %q must be 1-64 letters, digits, '.', '_' or '-' not starting with '.'",
+				errInvalidConfig,
+				name,
+			)
+		}
+		for _, member := range members {
+			if _, exists := c.UserInfo[member]; !exists {
+				return fmt.Errorf("%w: webdav group %q member %q is not a user", errInvalidConfig, name, member)
+			}
+		}
+	}
+	return nil
+}
+
+func pathContains(root, candidate string) bool {
+	return root == "/" || candidate == root || strings.HasPrefix(candidate, root+"/")
+}
+
+func validateWebDAVShare(share *WebdavShareConfig) error {
+	if !webdavShareNamePattern.MatchString(share.Name) {
+		return fmt.Errorf(
+			"%w: webdav share name %q must be 1-64 letters, digits, '.', '_' or '-' not starting with '.'",
+			errInvalidConfig,
+			share.Name,
+		)
+	}
+	if !strings.HasPrefix(share.Root, "/") || strings.Contains(share.Root, webdavUserPlaceholder) {
+		return fmt.Errorf("%w: webdav share %q root must be an absolute path", errInvalidConfig, share.Name)
+	}
+	share.Root = path.Clean(share.Root)
+	if share.Access == "" {
+		share.Access = "write"
+	}
+	if share.Access != "read" && share.Access != "write" {
+		return fmt.Errorf("%w: webdav share %q access must be read or write", errInvalidConfig, share.Name)
+	}
+	if share.QuotaBytes < 0 {
+		return fmt.Errorf("%w: webdav share %q quota_bytes must not be negative", errInvalidConfig, share.Name)
+	}
+	return nil
+}
+
 func (c *Config) validateS3() error {
 	if c.S3.MaxObjectSize < 0 {
 		return fmt.Errorf("%w: s3.max_object_size must not be negative", errInvalidConfig)
@@ -832,59 +1517,266 @@ func (c *Config) validateS3() error {
 	if c.S3.Enable && len(c.S3.Buckets) == 0 {
 		return fmt.Errorf("%w: s3.buckets must contain at least one bucket when S3 is enabled", errInvalidConfig)
 	}
+	if err := c.validateS3BaseDomain(); err != nil {
+		return err
+	}
+	if err := c.validateS3ReplicationTargets(); err != nil {
+		return err
+	}
 	seen := make(map[string]struct{}, len(c.S3.Buckets))
-	for index, bucket := range c.S3.Buckets {
-		if !bucketNamePattern.MatchString(bucket.Name) || strings.Contains(bucket.Name, "..") {
-			return fmt.Errorf("%w: s3.buckets[%d].name %q is invalid", errInvalidConfig, index, bucket.Name)
+	for index := range c.S3.Buckets {
+		if err := c.validateS3Bucket(index, seen); err != nil {
+			return err
 		}
-		if _, reserved := reservedBuckets[bucket.Name]; reserved {
-			return fmt.Errorf("%w: s3.buckets[%d].name %q is reserved", errInvalidConfig, index, bucket.Name)
+	}
+	return nil
+}
+
+func (c *Config) validateS3Bucket(index int, seen map[string]struct{}) error {
+	bucket := c.S3.Buckets[index]
+	if !bucketNamePattern.MatchString(bucket.Name) || strings.Contains(bucket.Name, "..") {
+		return fmt.Errorf("%w: s3.buckets[%d].name %q is invalid", errInvalidConfig, index, bucket.Name)
+	}
+	if _, reserved := reservedBuckets[bucket.Name]; reserved {
+		return fmt.Errorf(
+			"%w: s3.buckets[%d].name %q is reserved by the /%s/ endpoints, move its objects to another bucket",
+			errInvalidConfig,
+			index,
+			bucket.Name,
+			bucket.Name,
+		)
+	}
+	if _, exists := seen[bucket.Name]; exists {
+		return fmt.Errorf("%w: duplicate S3 bucket %q", errInvalidConfig, bucket.Name)
+	}
+	seen[bucket.Name] = struct{}{}
+	if bucket.ACL != "private" && bucket.ACL != "public-read" {
+		return fmt.Errorf(
+			"%w: s3.buckets[%d].acl must be private or public-read",
+			errInvalidConfig,
+			index,
+		)
+	}
+	if err := validateS3Replication(index, &c.S3.Buckets[index]); err != nil {
+		return err
+	}
+	if err := validateS3Lifecycle(index, &c.S3.Buckets[index], c.StorageClassNames()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateS3Lifecycle(bucketIndex int, bucket *S3BucketConfig, classes []string) error {
+	seen := make(map[string]struct{}, len(bucket.Lifecycle))
+	for index := range bucket.Lifecycle {
+		rule := &bucket.Lifecycle[index]
+		field := fmt.Sprintf("s3.buckets[%d].lifecycle[%d]", bucketIndex, index)
+		rule.ID = strings.TrimSpace(rule.ID)
+		if rule.ID == "" || len(rule.ID) > 255 {
+			return fmt.Errorf("%w: %s.id must contain 1 to 255 characters", errInvalidConfig, field)
+		}
+		if _, exists := seen[rule.ID]; exists {
+			return fmt.Errorf("%w: duplicate lifecycle rule %q in bucket %q", errInvalidConfig, rule.ID, bucket.Name)
 		}
-		if _, exists := seen[bucket.Name]; exists {
-			return fmt.Errorf("%w: duplicate S3 bucket %q", errInvalidConfig, bucket.Name)
+		seen[rule.ID] = struct{}{}
+		if rule.Days < 0 {
+			return fmt.Errorf("%w: %s.days must not be negative", errInvalidConfig, field)
 		}
-		seen[bucket.Name] = struct{}{}
-		if bucket.ACL != "private" && bucket.ACL != "public-read" {
+		if !slices.Contains(classes, rule.StorageClass) {
 			return fmt.Errorf(
-				"%w: s3.buckets[%d].acl must be private or public-read",
+				"%w: %s.storage_class %q is not a configured storage class",
 				errInvalidConfig,
-				index,
+				field,
+				rule.StorageClass,
 			)
 		}
 	}
 	return nil
 }
 
+// StorageClassNames lists the class of the primary backend followed by the
+// classes of storage_classes.
+func (c *Config) StorageClassNames() []string {
+	names := make([]string, 0, 1+len(c.StorageClasses))
+	names = append(names, c.StorageClass)
+	for _, tier := range c.StorageClasses {
+		names = append(names, tier.Name)
+	}
+	return names
+}
+
+func (c *Config) validateStorageClasses() error {
+	c.StorageClass = strings.TrimSpace(c.StorageClass)
+	if c.StorageClass == "" {
+		c.StorageClass = "STANDARD"
+	}
+	if !storageClassPattern.MatchString(c.StorageClass) {
+		return fmt.Errorf("%w: storage_class %q is invalid", errInvalidConfig, c.StorageClass)
+	}
+	classes := map[string]struct{}{c.StorageClass: {}}
+	kinds := map[string]struct{}{c.BotKind: {}}
+	for index := range c.StorageClasses {
+		tier := &c.StorageClasses[index]
+		field := fmt.Sprintf("storage_classes[%d]", index)
+		tier.Name = strings.TrimSpace(tier.Name)
+		if !storageClassPattern.MatchString(tier.Name) {
+			return fmt.Errorf("%w: %s.name %q is invalid", errInvalidConfig, field, tier.Name)
+		}
+		if _, exists := classes[tier.Name]; exists {
+			return fmt.Errorf("%w: duplicate storage class %q", errInvalidConfig, tier.Name)
+		}
+		classes[tier.Name] = struct{}{}
+		if tier.BotKind == "" {
+			return fmt.Errorf("%w: %s.bot_kind must not be empty", errInvalidConfig, field)
+		}
+		// Stored files and pending block deletions name their backend by kind.
+		if _, exists := kinds[tier.BotKind]; exists {
+			return fmt.Errorf(
+				"%w: %s.bot_kind %q is already used by another storage class",
+				errInvalidConfig,
+				field,
+				tier.BotKind,
+			)
+		}
+		kinds[tier.BotKind] = struct{}{}
+		if tier.BotKind == "telegram" {
+			if err := validateTelegramBotConfig(field+".bot_config", tier.BotInfo); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateS3ReplicationTargets() error {
+	seen := make(map[string]struct{}, len(c.S3.ReplicationTargets))
+	for index := range c.S3.ReplicationTargets {
+		target := &c.S3.ReplicationTargets[index]
+		field := fmt.Sprintf("s3.replication_targets[%d]", index)
+		if !replicationTargetPattern.MatchString(target.Name) {
+			return fmt.Errorf("%w: %s.name %q is invalid", errInvalidConfig, field, target.Name)
+		}
+		if _, exists := seen[target.Name]; exists {
+			return fmt.Errorf("%w: duplicate replication target %q", errInvalidConfig, target.Name)
+		}
+		seen[target.Name] = struct{}{}
+		if err := validateReplicationEndpoint(field, &target.Endpoint, &target.Region, target.AccessKey,
+			target.SecretKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateReplicationEndpoint(field string, endpoint, region *string, accessKey, secretKey string) error {
+	origin, ok := replicationEndpointOrigin(*endpoint)
+	if !ok {
+		return fmt.Errorf("%w: %s.endpoint must be an http or https origin", errInvalidConfig, field)
+	}
+	*endpoint = origin
+	if *region == "" {
+		*region = "us-east-1"
+	}
+	if accessKey == "" || secretKey == "" {
+		return fmt.Errorf("%w: %s requires access_key and secret_key", errInvalidConfig, field)
+	}
+	return nil
+}
+
+func validateS3Replication(bucketIndex int, bucket *S3BucketConfig) error {
+	seen := make(map[string]struct{}, len(bucket.Replication))
+	for index := range bucket.Replication {
+		rule := &bucket.Replication[index]
+		field := fmt.Sprintf("s3.buckets[%d].replication[%d]", bucketIndex, index)
+		rule.ID = strings.TrimSpace(rule.ID)
+		if rule.ID == "" || len(rule.ID) > 255 {
+			return fmt.Errorf("%w: %s.id must contain 1 to 255 characters", errInvalidConfig, field)
+		}
+		if _, exists := seen[rule.ID]; exists {
+			return fmt.Errorf("%w: duplicate replication rule %q in bucket %q", errInvalidConfig, rule.ID, bucket.Name)
+		}
+		seen[rule.ID] = struct{}{}
+		if rule.Bucket == "" {
+			rule.Bucket = bucket.Name
+		}
+		if !bucketNamePattern.MatchString(rule.Bucket) || strings.Contains(rule.Bucket, "..") {
+			return fmt.Errorf("%w: %s.bucket %q is invalid", errInvalidConfig, field, rule.Bucket)
+		}
+		if err := validateReplicationEndpoint(field, &rule.Endpoint, &rule.Region, rule.AccessKey,
+			rule.SecretKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replicationEndpointOrigin(raw string) (string, bool) {
+	endpoint, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return "", false
+	}
+	if strings.Trim(endpoint.Path, "/") != "" || endpoint.RawQuery != "" || endpoint.User != nil {
+		return "", false
+	}
+	return endpoint.Scheme + "://" + endpoint.Host, true
+}
+
+func (c *Config) validateS3BaseDomain() error {
+	domain := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(c.S3.BaseDomain)), ".")
+	if domain == "" {
+		c.S3.BaseDomain = ""
+		return nil
+	}
+	if len(domain) > 253 || net.ParseIP(domain) != nil {
+		return fmt.Errorf("%w: s3.base_domain must be a DNS name", errInvalidConfig)
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if !domainLabelPattern.MatchString(label) {
+			return fmt.Errorf("%w: s3.base_domain must be a DNS name", errInvalidConfig)
+		}
+	}
+	c.S3.BaseDomain = domain
+	return nil
+}
+
 func (c *Config) validateBlockIO() error {
 	if c.BotKind != "telegram" {
 		return nil
 	}
-	raw, err := json.Marshal(c.BotInfo)
+	if err := validateTelegramBotConfig("bot_config", c.BotInfo); err != nil {
+		return err
+	}
+	if c.S3.MaxObjectSize > maxFilePartCount*telegramBlockSize {
+		return fmt.Errorf("%w: s3.max_object_size exceeds Telegram storage limit", errInvalidConfig)
+	}
+	return nil
+}
+
+func validateTelegramBotConfig(field string, info any) error {
+	raw, err := json.Marshal(info)
 	if err != nil {
-		return fmt.Errorf("%w: encode bot_config: %w", errInvalidConfig, err)
+		return fmt.Errorf("%w: encode %s: %w", errInvalidConfig, field, err)
 	}
 	var bot BotConfig
 	if err := json.Unmarshal(raw, &bot); err != nil {
-		return fmt.Errorf("%w: decode Telegram bot_config: %w", errInvalidConfig, err)
+		return fmt.Errorf("%w: decode Telegram %s: %w", errInvalidConfig, field, err)
 	}
 	if bot.Chatid == 0 {
-		return fmt.Errorf("%w: bot_config.chatid must not be zero", errInvalidConfig)
+		return fmt.Errorf("%w: %s.chatid must not be zero", errInvalidConfig, field)
 	}
 	if strings.TrimSpace(bot.Token) == "" {
-		return fmt.Errorf("%w: bot_config.token must not be empty", errInvalidConfig)
+		return fmt.Errorf("%w: %s.token must not be empty", errInvalidConfig, field)
 	}
 	if bot.UploadMinIntervalMS == 0 {
 		bot.UploadMinIntervalMS = defaultTelegramUploadIntervalMS
 	}
 	if bot.UploadMinIntervalMS < defaultTelegramUploadIntervalMS {
 		return fmt.Errorf(
-			"%w: bot_config.upload_min_interval_ms must be at least %d",
+			"%w: %s.upload_min_interval_ms must be at least %d",
 			errInvalidConfig,
+			field,
 			defaultTelegramUploadIntervalMS,
 		)
 	}
-	if c.S3.MaxObjectSize > maxFilePartCount*telegramBlockSize {
-		return fmt.Errorf("%w: s3.max_object_size exceeds Telegram storage limit", errInvalidConfig)
-	}
 	return nil
 }
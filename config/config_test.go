@@ -79,6 +79,11 @@ func TestValidateS3AndTelegramConfiguration(t *testing.T) {
 	require.NoError(t, valid.Validate())
 	require.Equal(t, 24, valid.S3.MultipartExpireHours)
 
+	withDomain := *valid
+	withDomain.S3.BaseDomain = " S3.Example.COM. "
+	require.NoError(t, withDomain.Validate())
+	require.Equal(t, "s3.example.com", withDomain.S3.BaseDomain)
+
 	tests := []struct {
 		name   string
 		mutate func(*Config)
@@ -101,12 +106,54 @@ func TestValidateS3AndTelegramConfiguration(t *testing.T) {
 				config.S3.Buckets[1].Name = config.S3.Buckets[0].Name
 			},
 		},
+		{
+			name: "base domain with port",
+			mutate: func(config *Config) {
+				config.S3.BaseDomain = "s3.example.com:9000"
+			},
+		},
+		{
+			name: "base domain with scheme",
+			mutate: func(config *Config) {
+				config.S3.BaseDomain = "https://s3.example.com"
+			},
+		},
+		{
+			name: "base domain as IP",
+			mutate: func(config *Config) {
+				config.S3.BaseDomain = "127.0.0.1"
+			},
+		},
 		{
 			name: "reserved bucket",
 			mutate: func(config *Config) {
 				config.S3.Buckets[0].Name = "file"
 			},
 		},
+		{
+			name: "reserved fetch bucket",
+			mutate: func(config *Config) {
+				config.S3.Buckets[0].Name = "fetch"
+			},
+		},
+		{
+			name: "reserved quota bucket",
+			mutate: func(config *Config) {
+				config.S3.Buckets[0].Name = "quota"
+			},
+		},
+		{
+			name: "reserved share bucket",
+			mutate: func(config *Config) {
+				config.S3.Buckets[0].Name = "share"
+			},
+		},
+		{
+			name: "reserved sts bucket",
+			mutate: func(config *Config) {
+				config.S3.Buckets[0].Name = "sts"
+			},
+		},
 		{
 			name: "short upload interval",
 			mutate: func(config *Config) {
@@ -141,6 +188,150 @@ func TestValidateS3AndTelegramConfiguration(t *testing.T) {
 	}
 }
 
+func TestValidateS3Replication(t *testing.T) {
+	build := func(rule S3ReplicationRuleConfig) *Config {
+		return &Config{S3: S3Config{
+			Enable: true,
+			Buckets: []S3BucketConfig{{
+				Name:        "private-data",
+				ACL:         "private",
+				Replication: []S3ReplicationRuleConfig{rule},
+			}},
+		}}
+	}
+	valid := build(S3ReplicationRuleConfig{
+		ID:        " dr ",
+		Prefix:    "docs/",
+		Endpoint:  "https://dr.example.com/",
+		AccessKey: "replica",
+		SecretKey: "replica-secret",
+	})
+	require.NoError(t, valid.validateS3())
+	rule := valid.S3.Buckets[0].Replication[0]
+	require.Equal(t, "dr", rule.ID)
+	require.Equal(t, "https://dr.example.com", rule.Endpoint)
+	require.Equal(t, "private-data", rule.Bucket)
+	require.Equal(t, "us-east-1", rule.Region)
+	require.Equal(t, 1, valid.S3.ReplicationRuleCount())
+	for _, field := range valid.SafeLogFields() {
+		require.NotContains(t, field.String, "replica-secret")
+	}
+
+	for name, mutate := range map[string]func(*S3ReplicationRuleConfig){
+		"missing id":         func(rule *S3ReplicationRuleConfig) { rule.ID = "" },
+		"ftp endpoint":       func(rule *S3ReplicationRuleConfig) { rule.Endpoint = "ftp://dr.example.com" },
+		"endpoint with path": func(rule *S3ReplicationRuleConfig) { rule.Endpoint = "https://dr.example.com/s3" },
+		"invalid bucket":     func(rule *S3ReplicationRuleConfig) { rule.Bucket = "Bad_Bucket" },
+		"missing secret":     func(rule *S3ReplicationRuleConfig) { rule.SecretKey = "" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			rule := S3ReplicationRuleConfig{
+				ID: "dr", Endpoint: "https://dr.example.com", AccessKey: "replica", SecretKey: "replica-secret",
+			}
+			mutate(&rule)
+			require.ErrorIs(t, build(rule).validateS3(), errInvalidConfig)
+		})
+	}
+
+	duplicate := build(S3ReplicationRuleConfig{
+		ID: "dr", Endpoint: "https://dr.example.com", AccessKey: "replica", SecretKey: "replica-secret",
+	})
+	duplicate.S3.Buckets[0].Replication = append(duplicate.S3.Buckets[0].Replication, duplicate.S3.Buckets[0].Replication[0])
+	require.ErrorIs(t, duplicate.validateS3(), errInvalidConfig)
+}
+
+func TestValidateS3ReplicationTargets(t *testing.T) {
+	build := func(targets ...S3ReplicationTargetConfig) *Config {
+		return &Config{S3: S3Config{
+			Enable:             true,
+			Buckets:            []S3BucketConfig{{Name: "private-data", ACL: "private"}},
+			ReplicationTargets: targets,
+		}}
+	}
+	target := S3ReplicationTargetConfig{
+		Name: "dr", Endpoint: "https://dr.example.com/", AccessKey: "replica", SecretKey: "replica-secret",
+	}
+	valid := build(target)
+	require.NoError(t, valid.validateS3())
+	require.Equal(t, "https://dr.example.com", valid.S3.ReplicationTargets[0].Endpoint)
+	require.Equal(t, "us-east-1", valid.S3.ReplicationTargets[0].Region)
+	require.Equal(t, []string{"dr"}, valid.S3.ReplicationTargetNames())
+	for _, field := range valid.SafeLogFields() {
+		require.NotContains(t, field.String, "replica-secret")
+	}
+
+	for name, mutate := range map[string]func(*S3ReplicationTargetConfig){
+		"slash in name":  func(target *S3ReplicationTargetConfig) { target.Name = "private-data/dr" },
+		"empty name":     func(target *S3ReplicationTargetConfig) { target.Name = "" },
+		"ftp endpoint":   func(target *S3ReplicationTargetConfig) { target.Endpoint = "ftp://dr.example.com" },
+		"missing secret": func(target *S3ReplicationTargetConfig) { target.SecretKey = "" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			invalid := target
+			mutate(&invalid)
+			require.ErrorIs(t, build(invalid).validateS3(), errInvalidConfig)
+		})
+	}
+	require.ErrorIs(t, build(target, target).validateS3(), errInvalidConfig)
+}
+
+func TestValidateStorageClassesAndLifecycle(t *testing.T) {
+	build := func() *Config {
+		return &Config{
+			BotKind:      "telegram",
+			StorageClass: " ",
+			StorageClasses: []StorageClassConfig{{
+				Name:    "HOT",
+				BotKind: "localfile",
+				BotInfo: map[string]any{"dir": "/data/hot"},
+			}},
+			S3: S3Config{
+				Enable: true,
+				Buckets: []S3BucketConfig{{
+					Name: "archive",
+					ACL:  "private",
+					Lifecycle: []S3LifecycleRuleConfig{{
+						ID: " cold ", Prefix: "logs/", Days: 30, StorageClass: "STANDARD",
+					}},
+				}},
+			},
+		}
+	}
+	valid := build()
+	require.NoError(t, valid.validateStorageClasses())
+	require.NoError(t, valid.validateS3())
+	require.Equal(t, "STANDARD", valid.StorageClass)
+	require.Equal(t, []string{"STANDARD", "HOT"}, valid.StorageClassNames())
+	require.Equal(t, "cold", valid.S3.Buckets[0].Lifecycle[0].ID)
+	require.Equal(t, 1, valid.S3.LifecycleRuleCount())
+
+	for name, mutate := range map[string]func(*Config){
+		"lowercase class":   func(c *Config) { c.StorageClasses[0].Name = "hot" },
+		"duplicate class":   func(c *Config) { c.StorageClasses[0].Name = "STANDARD" },
+		"missing kind":      func(c *Config) { c.StorageClasses[0].BotKind = "" },
+		"shared kind":       func(c *Config) { c.StorageClasses[0].BotKind = "telegram" },
+		"tier without chat": func(c *Config) { c.BotKind, c.StorageClasses[0].BotKind = "localfile", "telegram" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			value := build()
+			mutate(value)
+			require.ErrorIs(t, value.validateStorageClasses(), errInvalidConfig)
+		})
+	}
+	for name, mutate := range map[string]func(*S3LifecycleRuleConfig){
+		"missing id":    func(rule *S3LifecycleRuleConfig) { rule.ID = "" },
+		"negative days": func(rule *S3LifecycleRuleConfig) { rule.Days = -1 },
+		"unknown class": func(rule *S3LifecycleRuleConfig) { rule.StorageClass = "GLACIER" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			value := build()
+			mutate(&value.S3.Buckets[0].Lifecycle[0])
+			require.NoError(t, value.validateStorageClasses())
+			require.ErrorIs(t, value.validateS3(), errInvalidConfig)
+		})
+	}
+}
+
 func TestLegacyBucketFieldIsNotAccepted(t *testing.T) {
 	configFile := filepath.Join(t.TempDir(), "config.json")
 	require.NoError(t, os.WriteFile(configFile, []byte(`{
@@ -304,6 +495,236 @@ func TestValidateBackupConfiguration(t *testing.T) {
 	require.ErrorIs(t, conflict.Validate(), errInvalidConfig)
 }
 
+func TestValidateTrashConfiguration(t *testing.T) {
+	dataDir := t.TempDir()
+	value := &Config{
+		BotKind:        "localfile",
+		BotInfo:        map[string]any{"storage_dir": filepath.Join(dataDir, "blocks")},
+		DBFile:         filepath.Join(dataDir, "data.db"),
+		UserInfo:       map[string]string{"operator": "secret"},
+		UserPermission: map[string][]string{"operator": {"webdav:write"}},
+		Trash:          TrashConfig{Enable: true},
+	}
+	require.NoError(t, value.Validate())
+	require.Equal(t, defaultTrashRetentionDays, value.Trash.RetentionDays)
+
+	for _, days := range []int{-1, 3651} {
+		invalid := *value
+		invalid.Trash = TrashConfig{Enable: true, RetentionDays: days}
+		require.ErrorIs(t, invalid.Validate(), errInvalidConfig)
+	}
+}
+
+func TestValidateSnapshotConfiguration(t *testing.T) {
+	dataDir := t.TempDir()
+	value := &Config{
+		BotKind:        "localfile",
+		BotInfo:        map[string]any{"storage_dir": filepath.Join(dataDir, "blocks")},
+		DBFile:         filepath.Join(dataDir, "data.db"),
+		UserInfo:       map[string]string{"operator": "secret"},
+		UserPermission: map[string][]string{"operator": {"webdav:write"}},
+		Snapshot: SnapshotConfig{
+			Enable:        true,
+			RetentionDays: 7,
+			Schedules:     []SnapshotScheduleConfig{{Name: "daily", Scope: "/docs", IntervalHours: 24}},
+		},
+	}
+	require.NoError(t, value.Validate())
+
+	for _, mutate := range []func(*SnapshotConfig){
+		func(c *SnapshotConfig) { c.RetentionDays = -1 },
+		func(c *SnapshotConfig) { c.Schedules[0].Name = ".daily" },
+		func(c *SnapshotConfig) { c.Schedules[0].Name = strings.Repeat("a", 48) },
+		func(c *SnapshotConfig) { c.Schedules[0].Scope = "docs" },
+		func(c *SnapshotConfig) { c.Schedules[0].Scope = "/docs/" },
+		func(c *SnapshotConfig) { c.Schedules[0].IntervalHours = 0 },
+		func(c *SnapshotConfig) { c.Schedules = append(c.Schedules, c.Schedules[0]) },
+	} {
+		invalid := *value
+		invalid.Snapshot = value.Snapshot
+		invalid.Snapshot.Schedules = append([]SnapshotScheduleConfig(nil), value.Snapshot.Schedules...)
+		mutate(&invalid.Snapshot)
+		require.ErrorIs(t, invalid.Validate(), errInvalidConfig)
+	}
+}
+
+func TestValidateVersionConfiguration(t *testing.T) {
+	dataDir := t.TempDir()
+	value := &Config{
+		BotKind:        "localfile",
+		BotInfo:        map[string]any{"storage_dir": filepath.Join(dataDir, "blocks")},
+		DBFile:         filepath.Join(dataDir, "data.db"),
+		UserInfo:       map[string]string{"operator": "secret"},
+		UserPermission: map[string][]string{"operator": {"webdav:write"}},
+		Version:        VersionConfig{Enable: true, KeepCount: 10, KeepDays: 30},
+	}
+	require.NoError(t, value.Validate())
+
+	for _, mutate := range []func(*VersionConfig){
+		func(c *VersionConfig) { c.KeepCount = -1 },
+		func(c *VersionConfig) { c.KeepCount = 10001 },
+		func(c *VersionConfig) { c.KeepDays = -1 },
+		func(c *VersionConfig) { c.KeepDays = 3651 },
+		func(c *VersionConfig) { c.KeepCount, c.KeepDays = 0, 0 },
+	} {
+		invalid := *value
+		mutate(&invalid.Version)
+		require.ErrorIs(t, invalid.Validate(), errInvalidConfig)
+	}
+}
+
+func TestValidateThumbnailConfiguration(t *testing.T) {
+	dataDir := t.TempDir()
+	value := &Config{
+		BotKind:   "localfile",
+		BotInfo:   map[string]any{"storage_dir": filepath.Join(dataDir, "blocks")},
+		DBFile:    filepath.Join(dataDir, "data.db"),
+		Thumbnail: ThumbnailConfig{Enable: true},
+	}
+	require.NoError(t, value.Validate())
+	require.Equal(t, defaultThumbnailMaxEdge, value.Thumbnail.MaxEdge)
+	require.Equal(t, defaultThumbnailQuality, value.Thumbnail.Quality)
+	require.Equal(t, defaultThumbnailMaxSourceBytes, value.Thumbnail.MaxSourceBytes)
+	require.Equal(t, defaultThumbnailMaxSourcePixels, value.Thumbnail.MaxSourcePixels)
+
+	for _, mutate := range []func(*ThumbnailConfig){
+		func(c *ThumbnailConfig) { c.MaxEdge = 15 },
+		func(c *ThumbnailConfig) { c.MaxEdge = 2049 },
+		func(c *ThumbnailConfig) { c.Quality = -1 },
+		func(c *ThumbnailConfig) { c.Quality = 101 },
+		func(c *ThumbnailConfig) { c.MaxSourceBytes = maxThumbnailSourceBytes + 1 },
+		func(c *ThumbnailConfig) { c.MaxSourcePixels = -1 },
+	} {
+		invalid := *value
+		mutate(&invalid.Thumbnail)
+		require.ErrorIs(t, invalid.Validate(), errInvalidConfig)
+	}
+}
+
+func TestValidateTusConfiguration(t *testing.T) {
+	dataDir := t.TempDir()
+	value := &Config{
+		BotKind: "localfile",
+		BotInfo: map[string]any{"storage_dir": filepath.Join(dataDir, "blocks")},
+		DBFile:  filepath.Join(dataDir, "data.db"),
+		Tus:     TusConfig{Enable: true},
+	}
+	require.NoError(t, value.Validate())
+	require.Equal(t, filepath.Join(dataDir, "tus-upload"), value.Tus.TempDir)
+	require.Equal(t, int64(defaultTusMaxUploadSize), value.Tus.MaxUploadSize)
+	require.Equal(t, defaultTusExpireHours, value.Tus.ExpireHours)
+
+	for _, mutate := range []func(*TusConfig){
+		func(c *TusConfig) { c.MaxUploadSize = -1 },
+		func(c *TusConfig) { c.MaxUploadSize = maxAdminUploadSize + 1 },
+		func(c *TusConfig) { c.ExpireHours = -1 },
+		func(c *TusConfig) { c.ExpireHours = maxTusExpireHours + 1 },
+	} {
+		invalid := *value
+		mutate(&invalid.Tus)
+		require.ErrorIs(t, invalid.Validate(), errInvalidConfig)
+	}
+}
+
+func TestValidateFileUploadConfiguration(t *testing.T) {
+	dataDir := t.TempDir()
+	value := &Config{
+		BotKind: "localfile",
+		BotInfo: map[string]any{"storage_dir": filepath.Join(dataDir, "blocks")},
+		DBFile:  filepath.Join(dataDir, "data.db"),
+	}
+	require.NoError(t, value.Validate())
+	require.Equal(t, filepath.Join(dataDir, "file-upload"), value.FileUpload.TempDir)
+	require.Equal(t, defaultFileUploadMaxSize, value.FileUpload.MaxUploadSize)
+
+	for _, size := range []int64{-1, maxAdminUploadSize + 1} {
+		invalid := *value
+		invalid.FileUpload.MaxUploadSize = size
+		require.ErrorIs(t, invalid.Validate(), errInvalidConfig)
+	}
+}
+
+func TestValidateFetchConfiguration(t *testing.T) {
+	dataDir := t.TempDir()
+	value := &Config{
+		BotKind: "localfile",
+		BotInfo: map[string]any{"storage_dir": filepath.Join(dataDir, "blocks")},
+		DBFile:  filepath.Join(dataDir, "data.db"),
+	}
+	require.NoError(t, value.Validate())
+	require.Equal(t, filepath.Join(dataDir, "fetch"), value.Fetch.WorkDir)
+	require.Equal(t, defaultFetchMaxSize, value.Fetch.MaxSize)
+	require.Equal(t, defaultFetchMaxRetries, value.Fetch.MaxRetries)
+	require.Equal(t, defaultFetchWorkers, value.Fetch.Workers)
+	require.Equal(t, defaultFetchJobRetentionDays, value.Fetch.JobRetentionDays)
+
+	for _, mutate := range []func(*FetchConfig){
+		func(c *FetchConfig) { c.MaxSize = -1 },
+		func(c *FetchConfig) { c.MaxSize = maxAdminUploadSize + 1 },
+		func(c *FetchConfig) { c.MaxRetries = maxFetchRetries + 1 },
+		func(c *FetchConfig) { c.Workers = -1 },
+		func(c *FetchConfig) { c.Workers = maxFetchWorkers + 1 },
+		func(c *FetchConfig) { c.JobRetentionDays = 366 },
+	} {
+		invalid := *value
+		mutate(&invalid.Fetch)
+		require.ErrorIs(t, invalid.Validate(), errInvalidConfig)
+	}
+}
+
+func TestValidateArchiveConfiguration(t *testing.T) {
+	dataDir := t.TempDir()
+	value := &Config{
+		BotKind: "localfile",
+		BotInfo: map[string]any{"storage_dir": filepath.Join(dataDir, "blocks")},
+		DBFile:  filepath.Join(dataDir, "data.db"),
+	}
+	require.NoError(t, value.Validate())
+	require.Equal(t, defaultArchiveMaxEntries, value.Archive.MaxEntries)
+	require.Equal(t, defaultArchiveMaxBytes, value.Archive.MaxBytes)
+
+	for _, mutate := range []func(*ArchiveConfig){
+		func(c *ArchiveConfig) { c.MaxEntries = -1 },
+		func(c *ArchiveConfig) { c.MaxEntries = maxArchiveEntries + 1 },
+		func(c *ArchiveConfig) { c.MaxBytes = -1 },
+		func(c *ArchiveConfig) { c.MaxBytes = maxArchiveBytes + 1 },
+	} {
+		invalid := *value
+		mutate(&invalid.Archive)
+		require.ErrorIs(t, invalid.Validate(), errInvalidConfig)
+	}
+}
+
+func TestValidateQuotaConfiguration(t *testing.T) {
+	dataDir := t.TempDir()
+	newConfig := func(quota QuotaConfig) *Config {
+		return &Config{
+			BotKind:  "localfile",
+			BotInfo:  map[string]any{"storage_dir": filepath.Join(dataDir, "blocks")},
+			DBFile:   filepath.Join(dataDir, "data.db"),
+			UserInfo: map[string]string{"alice": "alice-secret"},
+			UserPermission: map[string][]string{
+				"alice": {"file:write"},
+			},
+			Quota: quota,
+		}
+	}
+	require.NoError(t, newConfig(QuotaConfig{
+		Default: QuotaLimitConfig{HardBytes: 1024, SoftBytes: 512},
+		Users:   map[string]QuotaLimitConfig{"alice": {HardObjects: 10, SoftObjects: 10, SoftBytes: 2048}},
+	}).Validate())
+
+	for _, quota := range []QuotaConfig{
+		{Default: QuotaLimitConfig{HardBytes: -1}},
+		{Default: QuotaLimitConfig{SoftObjects: -1}},
+		{Default: QuotaLimitConfig{HardBytes: 512, SoftBytes: 1024}},
+		{Users: map[string]QuotaLimitConfig{"alice": {HardObjects: 1, SoftObjects: 2}}},
+		{Users: map[string]QuotaLimitConfig{"bob": {HardBytes: 1}}},
+	} {
+		require.ErrorIs(t, newConfig(quota).Validate(), errInvalidConfig)
+	}
+}
+
 func TestValidateAdminConfiguration(t *testing.T) {
 	dataDir := t.TempDir()
 	value := &Config{
@@ -531,6 +952,59 @@ func TestValidateRootExternalOriginsForWebDAV(t *testing.T) {
 	require.NoError(t, withoutOrigins.Validate())
 }
 
+func TestValidateWebDAVHomesAndShares(t *testing.T) {
+	newConfig := func(testingT *testing.T) *Config {
+		testingT.Helper()
+		return &Config{
+			BotKind:        "localfile",
+			BotInfo:        map[string]any{"storage_dir": testingT.TempDir()},
+			DBFile:         filepath.Join(testingT.TempDir(), "data.db"),
+			UserInfo:       map[string]string{"editor": "secret"},
+			UserPermission: map[string][]string{"editor": {"webdav:write"}},
+			Webdav: WebdavConfig{
+				Enable: true,
+				Home:   WebdavHomeConfig{Root: "/home/{user}/"},
+				Shares: []WebdavShareConfig{
+					{Name: "team", Root: "/shares/team", QuotaBytes: 1024},
+					{Name: "docs", Root: "/shares/docs", Access: "read"},
+				},
+				Groups: map[string][]string{"staff": {"editor"}},
+			},
+		}
+	}
+	value := newConfig(t)
+	require.NoError(t, value.Validate())
+	require.Equal(t, "/home/{user}", value.Webdav.Home.Root)
+	require.Equal(t, "write", value.Webdav.Shares[0].Access)
+
+	for name, mutate := range map[string]func(*Config){
+		"root with mounts":         func(value *Config) { value.Webdav.Root = "/data" },
+		"home without placeholder": func(value *Config) { value.Webdav.Home.Root = "/home" },
+		"partial placeholder":      func(value *Config) { value.Webdav.Home.Root = "/home/u-{user}" },
+		"relative share root":      func(value *Config) { value.Webdav.Shares[0].Root = "shares/team" },
+		"reserved share name":      func(value *Config) { value.Webdav.Shares[0].Name = "home" },
+		"duplicate share name":     func(value *Config) { value.Webdav.Shares[1].Name = "team" },
+		"invalid share name":       func(value *Config) { value.Webdav.Shares[0].Name = "a/b" },
+		"dot share name":           func(value *Config) { value.Webdav.Shares[0].Name = ".." },
+		"unknown access":           func(value *Config) { value.Webdav.Shares[0].Access = "admin" },
+		"negative quota":           func(value *Config) { value.Webdav.Shares[0].QuotaBytes = -1 },
+		"nested shares":            func(value *Config) { value.Webdav.Shares[1].Root = "/shares/team/docs" },
+		"share inside homes":       func(value *Config) { value.Webdav.Shares[0].Root = "/home/editor" },
+		"unknown group member":     func(value *Config) { value.Webdav.Groups["staff"] = []string{"nobody"} },
+		"invalid group name":       func(value *Config) { value.Webdav.Groups["a/b"] = nil },
+		"unsafe home user": func(value *Config) {
+			value.UserInfo["a/b"] = "secret"
+			value.UserPermission["a/b"] = []string{"webdav:read"}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			value := newConfig(t)
+			mutate(value)
+			require.ErrorIs(t, value.Validate(), errInvalidConfig)
+		})
+	}
+}
+
 func TestValidateIOCacheConfigurationAndPaths(t *testing.T) {
 	root := t.TempDir()
 	newConfig := func() *Config {
@@ -0,0 +1,85 @@
+// Package contenttype decides the media type stored for uploaded content. The
+// leading bytes of a file are sniffed once, when the file is written, and
+// combined with the name of every mapping that links to it.
+package contenttype
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/xxxsen/mimetype"
+)
+
+// SniffLength is how many leading bytes Sniff looks at.
+const SniffLength = 512
+
+// Default is the type of content nothing more is known about.
+const Default = "application/octet-stream"
+
+const textPlain = "text/plain; charset=utf-8"
+
+// Sniff returns the media type the leading bytes of a file identify, or an
+// empty string for an empty file.
+func Sniff(head []byte) string {
+	if len(head) == 0 {
+		return ""
+	}
+	return http.DetectContentType(head[:min(len(head), SniffLength)])
+}
+
+// ByExtension returns the media type the extension of name implies, or an
+// empty string when the extension is unknown.
+func ByExtension(name string) string {
+	return mimetype.Lookup(strings.ToLower(path.Ext(name)))
+}
+
+// Resolve returns the media type stored for a mapping called name whose
+// content sniffed as sniffed. A known extension wins, except over a sniffed
+// image or PDF type of another kind, so a PNG uploaded as photo.jpg is served
+// as image/png. Audio and video sniffs only name a container and never win.
+// Content is never promoted to a type a browser renders as a document (HTML,
+// XML) unless the extension asks for it; such sniffs are stored as plain text.
+func Resolve(name, sniffed string) string {
+	byExtension := ByExtension(name)
+	if byExtension != "" {
+		if binaryMedia(sniffed) && essence(sniffed) != essence(byExtension) {
+			return sniffed
+		}
+		return byExtension
+	}
+	switch {
+	case sniffed == "":
+		return Default
+	case active(sniffed):
+		return textPlain
+	}
+	return sniffed
+}
+
+// Detect sniffs head and resolves the result for name.
+func Detect(name string, head []byte) string {
+	return Resolve(name, Sniff(head))
+}
+
+func essence(value string) string {
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(value))
+	}
+	return mediaType
+}
+
+func binaryMedia(value string) bool {
+	mediaType := essence(value)
+	return strings.HasPrefix(mediaType, "image/") || mediaType == "application/pdf"
+}
+
+func active(value string) bool {
+	switch essence(value) {
+	case "text/html", "text/xml", "application/xml", "image/svg+xml":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package contenttype
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveCombinesSniffAndExtension(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	pdf := []byte("%PDF-1.7\n")
+	html := []byte("<!DOCTYPE html><html><body>x</body></html>")
+	zip := []byte("PK\x03\x04\x14\x00\x00\x00")
+	for _, tc := range []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"photo.png", png, "image/png"},
+		{"photo.jpg", png, "image/png"},
+		{"PHOTO.JPG", []byte("\xff\xd8\xff\xe0"), "image/jpeg"},
+		{"scan", pdf, "application/pdf"},
+		{"notes.json", []byte(`{"a": 1}`), "application/json"},
+		{"report.docx", zip, ByExtension("report.docx")},
+		{"archive", zip, "application/zip"},
+		{"README", []byte("plain words"), "text/plain; charset=utf-8"},
+		{"index.html", html, ByExtension("index.html")},
+		{"page.txt", html, ByExtension("page.txt")},
+		{"page", html, "text/plain; charset=utf-8"},
+		{"song.m4a", []byte("\x00\x00\x00\x18ftypM4A \x00\x00\x00\x00"), ByExtension("song.m4a")},
+		{"empty", nil, Default},
+		{"empty.txt", nil, ByExtension("empty.txt")},
+	} {
+		require.Equal(t, tc.want, Detect(tc.name, tc.head), tc.name)
+	}
+	require.NotEmpty(t, ByExtension("report.docx"))
+	require.NotEqual(t, "text/html", ByExtension("page.txt"))
+}
@@ -81,6 +81,13 @@ func (f *fileDao) GetFileInfo(
 	return rsp, nil
 }
 
+// ForgetFile drops the cached metadata of files changed outside the DAO.
+func (f *fileDao) ForgetFile(ctx context.Context, fileIDs ...uint64) {
+	for _, fid := range fileIDs {
+		_ = f.cache.Del(ctx, fid)
+	}
+}
+
 func (f *fileDao) DeleteFile(ctx context.Context, req *entity.DeleteFileRequest) (*entity.DeleteFileResponse, error) {
 	defer func() {
 		for _, fid := range req.FileId {
@@ -56,6 +56,7 @@ func (f *fileDaoImpl) CreateFileDraft(
 			"mtime":           now,
 			"file_state":      constant.FileStateInit,
 			"extinfo":         "{}",
+			"backend_kind":    req.BackendKind,
 		},
 	}
 	sql, args, err := builder.BuildInsert(f.table(), data)
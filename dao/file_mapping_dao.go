@@ -276,14 +276,16 @@ func (f *fileMappingDaoImpl) directoryEntryToFileMappingItem(
 	item directory.IDirectoryEntry,
 ) (*entity.FileLinkMeta, error) {
 	rs := &entity.FileLinkMeta{
-		EntryID:  item.EntryID(),
-		FileName: item.Name(),
-		FileId:   0,
-		FileSize: item.Size(),
-		Mode:     item.Mode(),
-		Ctime:    item.Ctime(),
-		Mtime:    item.Mtime(),
-		IsDir:    item.IsDir(),
+		EntryID:     item.EntryID(),
+		FileName:    item.Name(),
+		FileId:      0,
+		FileSize:    item.Size(),
+		Mode:        item.Mode(),
+		Ctime:       item.Ctime(),
+		Mtime:       item.Mtime(),
+		IsDir:       item.IsDir(),
+		Owner:       item.Owner(),
+		ContentType: item.ContentType(),
 	}
 	if !rs.IsDir {
 		fid, err := strconv.ParseUint(item.RefData(), 10, 64)
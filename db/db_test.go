@@ -24,7 +24,7 @@ func TestOpenCreatesSchemaFromEmbeddedMigrations(t *testing.T) {
 		require.NoError(t, client.Close())
 	})
 
-	require.Equal(t, 13, queryInt(t, client, `SELECT COUNT(*) FROM schema_migrations`))
+	require.Equal(t, 39, queryInt(t, client, `SELECT COUNT(*) FROM schema_migrations`))
 	require.Equal(t, 3, queryInt(t, client, `
 SELECT COUNT(*) FROM sqlite_master
 WHERE type = 'index' AND name IN (
@@ -59,7 +59,7 @@ func TestMigrationPlanDryRunAndPreMD5UpgradePreserveData(t *testing.T) {
 	require.True(t, plan.needsLedger)
 	require.Len(t, plan.baseline, 3)
 	require.Equal(t, 3, plan.baseline[2].version)
-	require.Len(t, plan.pending, 10)
+	require.Len(t, plan.pending, 36)
 	require.Equal(t, 4, plan.pending[0].version)
 	require.False(t, tableExistsForTest(t, legacy, "schema_migrations"))
 	require.NoError(t, legacy.Close())
@@ -70,7 +70,7 @@ func TestMigrationPlanDryRunAndPreMD5UpgradePreserveData(t *testing.T) {
 		require.NoError(t, upgraded.Close())
 	})
 
-	require.Equal(t, 13, queryInt(t, upgraded, `SELECT COUNT(*) FROM schema_migrations`))
+	require.Equal(t, 39, queryInt(t, upgraded, `SELECT COUNT(*) FROM schema_migrations`))
 	require.Equal(t, 1, queryInt(t, upgraded, `SELECT COUNT(*) FROM tg_file_tab WHERE file_id = 101`))
 	require.Equal(t, 1, queryInt(t, upgraded, `SELECT COUNT(*) FROM tg_file_mapping_tab WHERE ref_data = '101'`))
 	require.Equal(t, -1, queryInt(t, upgraded, `
@@ -96,7 +96,7 @@ func TestProductionLegacySchemaIsBaselinedAndNormalized(t *testing.T) {
 	plan, err := planMigrations(t.Context(), legacy, files)
 	require.NoError(t, err)
 	require.Len(t, plan.baseline, 4)
-	require.Len(t, plan.pending, 9)
+	require.Len(t, plan.pending, 35)
 	require.Equal(t, 5, plan.pending[0].version)
 	require.False(t, tableExistsForTest(t, legacy, "schema_migrations"))
 	require.NoError(t, legacy.Close())
@@ -107,7 +107,7 @@ func TestProductionLegacySchemaIsBaselinedAndNormalized(t *testing.T) {
 		require.NoError(t, upgraded.Close())
 	})
 
-	require.Equal(t, 13, queryInt(t, upgraded, `SELECT COUNT(*) FROM schema_migrations`))
+	require.Equal(t, 39, queryInt(t, upgraded, `SELECT COUNT(*) FROM schema_migrations`))
 	require.Equal(t, 1, queryInt(t, upgraded, `SELECT COUNT(*) FROM tg_file_tab WHERE file_id = 101`))
 	require.Equal(t, 1, queryInt(t, upgraded, `SELECT COUNT(*) FROM tg_file_part_tab WHERE file_id = 101`))
 	require.Equal(t, 1, queryInt(t, upgraded, `SELECT COUNT(*) FROM tg_file_mapping_tab WHERE ref_data = '101'`))
@@ -136,7 +136,7 @@ func TestVersionFivePlanContainsOnlyNewS3MigrationsAndIsReadOnly(t *testing.T) {
 	require.NoError(t, err)
 	require.False(t, plan.needsLedger)
 	require.Len(t, plan.current, 5)
-	require.Len(t, plan.pending, 8)
+	require.Len(t, plan.pending, 34)
 	require.Equal(t, "0006_add_s3_object_metadata.sql", plan.pending[0].filename)
 	require.Equal(t, "0007_add_block_delete_state.sql", plan.pending[1].filename)
 	require.Equal(t, "0008_add_s3_multipart_upload.sql", plan.pending[2].filename)
@@ -145,6 +145,32 @@ func TestVersionFivePlanContainsOnlyNewS3MigrationsAndIsReadOnly(t *testing.T) {
 	require.Equal(t, "0011_add_webdav_protocol_state.sql", plan.pending[5].filename)
 	require.Equal(t, "0012_add_backup_jobs.sql", plan.pending[6].filename)
 	require.Equal(t, "0013_add_admin_indexes.sql", plan.pending[7].filename)
+	require.Equal(t, "0014_add_s3_session_credentials.sql", plan.pending[8].filename)
+	require.Equal(t, "0015_add_s3_replication_queue.sql", plan.pending[9].filename)
+	require.Equal(t, "0016_add_storage_tiers.sql", plan.pending[10].filename)
+	require.Equal(t, "0017_add_s3_inventory.sql", plan.pending[11].filename)
+	require.Equal(t, "0018_add_s3_access_logging.sql", plan.pending[12].filename)
+	require.Equal(t, "0019_add_webdav_upload_sessions.sql", plan.pending[13].filename)
+	require.Equal(t, "0020_add_webdav_search_indexes.sql", plan.pending[14].filename)
+	require.Equal(t, "0021_add_webdav_acl.sql", plan.pending[15].filename)
+	require.Equal(t, "0022_add_webdav_bindings.sql", plan.pending[16].filename)
+	require.Equal(t, "0023_add_trash.sql", plan.pending[17].filename)
+	require.Equal(t, "0024_add_namespace_snapshots.sql", plan.pending[18].filename)
+	require.Equal(t, "0025_add_file_versions.sql", plan.pending[19].filename)
+	require.Equal(t, "0026_add_file_thumbnails.sql", plan.pending[20].filename)
+	require.Equal(t, "0027_add_file_keys.sql", plan.pending[21].filename)
+	require.Equal(t, "0028_add_share_links.sql", plan.pending[22].filename)
+	require.Equal(t, "0029_add_upload_sessions.sql", plan.pending[23].filename)
+	require.Equal(t, "0030_add_file_key_owner.sql", plan.pending[24].filename)
+	require.Equal(t, "0031_add_mapping_owner.sql", plan.pending[25].filename)
+	require.Equal(t, "0032_add_fetch_jobs.sql", plan.pending[26].filename)
+	require.Equal(t, "0033_add_content_types.sql", plan.pending[27].filename)
+	require.Equal(t, "0034_add_session_sealed_secret.sql", plan.pending[28].filename)
+	require.Equal(t, "0035_add_s3_replication_rules.sql", plan.pending[29].filename)
+	require.Equal(t, "0036_add_upload_session_webdav_scope.sql", plan.pending[30].filename)
+	require.Equal(t, "0037_add_mapping_creator.sql", plan.pending[31].filename)
+	require.Equal(t, "0038_move_webdav_uploads_to_sessions.sql", plan.pending[32].filename)
+	require.Equal(t, "0039_keep_only_webdav_scope_principal.sql", plan.pending[33].filename)
 
 	require.Equal(t, 5, queryInt(t, client, `SELECT COUNT(*) FROM schema_migrations`))
 	require.Equal(t, 1, queryInt(t, client, `SELECT COUNT(*) FROM tg_file_tab WHERE file_id = 101`))
@@ -174,7 +200,7 @@ func TestStrictLegacySchemaUsesCompatibilityProfile(t *testing.T) {
 	plan, err := planMigrations(t.Context(), legacy, files)
 	require.NoError(t, err)
 	require.Len(t, plan.baseline, 4)
-	require.Len(t, plan.pending, 9)
+	require.Len(t, plan.pending, 35)
 	require.NoError(t, validateCurrentSchemaFingerprint(t.Context(), legacy, plan.current))
 	require.NoError(t, legacy.Close())
 
@@ -184,7 +210,7 @@ func TestStrictLegacySchemaUsesCompatibilityProfile(t *testing.T) {
 		require.NoError(t, upgraded.Close())
 	})
 
-	require.Equal(t, 13, queryInt(t, upgraded, `SELECT COUNT(*) FROM schema_migrations`))
+	require.Equal(t, 39, queryInt(t, upgraded, `SELECT COUNT(*) FROM schema_migrations`))
 	require.Equal(t, "persisted-md5", queryString(
 		t,
 		upgraded,
@@ -229,7 +255,7 @@ WHERE type = 'index' AND name = 'migration_should_rollback'`))
 
 	setMigrationFS(original)
 	require.NoError(t, migrate(t.Context(), client))
-	require.Equal(t, 13, queryInt(t, client, `SELECT COUNT(*) FROM schema_migrations`))
+	require.Equal(t, 39, queryInt(t, client, `SELECT COUNT(*) FROM schema_migrations`))
 	require.Equal(t, 2, queryInt(t, client, `SELECT COUNT(*) FROM tg_file_tab`))
 	require.Equal(t, 1, queryInt(t, client, `SELECT COUNT(*) FROM tg_file_mapping_tab WHERE ref_data = '101'`))
 }
@@ -280,7 +306,7 @@ func TestMigrationChecksumDriftIsRejected(t *testing.T) {
 
 	err := migrate(t.Context(), client)
 	require.ErrorIs(t, err, errMigrationChanged)
-	require.Equal(t, 13, queryInt(t, client, `SELECT COUNT(*) FROM schema_migrations`))
+	require.Equal(t, 39, queryInt(t, client, `SELECT COUNT(*) FROM schema_migrations`))
 }
 
 func TestVersionEightChecksumMigrationPreservesMultipartData(t *testing.T) {
@@ -323,7 +349,7 @@ INSERT INTO tg_s3_object_metadata_tab (
 	require.NoError(t, err)
 
 	require.NoError(t, migrate(t.Context(), client))
-	require.Equal(t, 13, queryInt(t, client, `SELECT COUNT(*) FROM schema_migrations`))
+	require.Equal(t, 39, queryInt(t, client, `SELECT COUNT(*) FROM schema_migrations`))
 	require.Equal(t, 3, queryInt(t, client, `SELECT COUNT(*) FROM tg_s3_multipart_upload_tab`))
 	require.Equal(t, 1, queryInt(t, client, `SELECT COUNT(*) FROM tg_s3_multipart_part_tab`))
 	require.Equal(t, "", queryString(
@@ -430,7 +456,7 @@ INSERT INTO tg_s3_multipart_part_tab (
 	require.NoError(t, err)
 
 	require.NoError(t, migrate(t.Context(), client))
-	require.Equal(t, 13, queryInt(t, client, `SELECT COUNT(*) FROM schema_migrations`))
+	require.Equal(t, 39, queryInt(t, client, `SELECT COUNT(*) FROM schema_migrations`))
 	require.Equal(t, 2, queryInt(t, client, `
 SELECT COUNT(*) FROM tg_s3_completed_part_tab
 WHERE file_id = 500 AND checksum_state = 'available'
@@ -533,7 +559,7 @@ func TestFailedMigrationRollsBackSchemaAndLedger(t *testing.T) {
 	client := openMigratedRawDatabase(t)
 	insertLegacyRows(t, client)
 	migrationSet := embeddedMigrationMap(t)
-	migrationSet["0013_broken.sql"] = &fstest.MapFile{Data: []byte(`
+	migrationSet["0039_broken.sql"] = &fstest.MapFile{Data: []byte(`
 CREATE TABLE migration_should_rollback (id INTEGER PRIMARY KEY);
 CREATE TABLE migration_should_rollback (id INTEGER PRIMARY KEY);
 `)}
@@ -542,7 +568,7 @@ CREATE TABLE migration_should_rollback (id INTEGER PRIMARY KEY);
 	err := migrate(t.Context(), client)
 	require.Error(t, err)
 	require.False(t, tableExistsForTest(t, client, "migration_should_rollback"))
-	require.Equal(t, 13, queryInt(t, client, `SELECT COUNT(*) FROM schema_migrations`))
+	require.Equal(t, 39, queryInt(t, client, `SELECT COUNT(*) FROM schema_migrations`))
 	require.Equal(t, 1, queryInt(t, client, `SELECT COUNT(*) FROM tg_file_tab WHERE file_id = 101`))
 }
 
@@ -557,7 +583,7 @@ func TestMigrationBackupCanBeRestoredAfterFailure(t *testing.T) {
 	copyFile(t, dbFile, backupFile)
 
 	migrationSet := embeddedMigrationMap(t)
-	migrationSet["0013_broken.sql"] = &fstest.MapFile{Data: []byte(`
+	migrationSet["0039_broken.sql"] = &fstest.MapFile{Data: []byte(`
 UPDATE tg_file_tab SET extinfo = 'changed';
 CREATE TABLE tg_file_tab (id INTEGER);
 `)}
@@ -590,7 +616,7 @@ func TestSchemaDriftIsRejectedWithoutDataMutation(t *testing.T) {
 	_, err := client.ExecContext(t.Context(), `DROP INDEX idx_entry_id`)
 	require.NoError(t, err)
 	migrationSet := embeddedMigrationMap(t)
-	migrationSet["0014_add_drift_probe.sql"] = &fstest.MapFile{
+	migrationSet["0040_add_drift_probe.sql"] = &fstest.MapFile{
 		Data: []byte(`CREATE TABLE drift_probe (id INTEGER PRIMARY KEY);`),
 	}
 	useMigrationFS(t, migrationSet)
@@ -598,14 +624,14 @@ func TestSchemaDriftIsRejectedWithoutDataMutation(t *testing.T) {
 	err = migrate(t.Context(), client)
 	require.ErrorIs(t, err, errSchemaDrift)
 	require.False(t, tableExistsForTest(t, client, "drift_probe"))
-	require.Equal(t, 13, queryInt(t, client, `SELECT COUNT(*) FROM schema_migrations`))
+	require.Equal(t, 39, queryInt(t, client, `SELECT COUNT(*) FROM schema_migrations`))
 	require.Equal(t, 1, queryInt(t, client, `SELECT COUNT(*) FROM tg_file_tab WHERE file_id = 101`))
 }
 
 func TestMigrationFilesUseVersionedNames(t *testing.T) {
 	files, err := listMigrationFiles(schemamigrations.FS)
 	require.NoError(t, err)
-	require.Len(t, files, 13)
+	require.Len(t, files, 39)
 	require.Equal(t, "0001_init_legacy_schema.sql", files[0].filename)
 	require.Equal(t, "0005_normalize_constraints.sql", files[4].filename)
 	require.Equal(t, "0007_add_block_delete_state.sql", files[6].filename)
@@ -615,6 +641,32 @@ func TestMigrationFilesUseVersionedNames(t *testing.T) {
 	require.Equal(t, "0011_add_webdav_protocol_state.sql", files[10].filename)
 	require.Equal(t, "0012_add_backup_jobs.sql", files[11].filename)
 	require.Equal(t, "0013_add_admin_indexes.sql", files[12].filename)
+	require.Equal(t, "0014_add_s3_session_credentials.sql", files[13].filename)
+	require.Equal(t, "0015_add_s3_replication_queue.sql", files[14].filename)
+	require.Equal(t, "0016_add_storage_tiers.sql", files[15].filename)
+	require.Equal(t, "0017_add_s3_inventory.sql", files[16].filename)
+	require.Equal(t, "0018_add_s3_access_logging.sql", files[17].filename)
+	require.Equal(t, "0019_add_webdav_upload_sessions.sql", files[18].filename)
+	require.Equal(t, "0020_add_webdav_search_indexes.sql", files[19].filename)
+	require.Equal(t, "0021_add_webdav_acl.sql", files[20].filename)
+	require.Equal(t, "0022_add_webdav_bindings.sql", files[21].filename)
+	require.Equal(t, "0023_add_trash.sql", files[22].filename)
+	require.Equal(t, "0024_add_namespace_snapshots.sql", files[23].filename)
+	require.Equal(t, "0025_add_file_versions.sql", files[24].filename)
+	require.Equal(t, "0026_add_file_thumbnails.sql", files[25].filename)
+	require.Equal(t, "0027_add_file_keys.sql", files[26].filename)
+	require.Equal(t, "0028_add_share_links.sql", files[27].filename)
+	require.Equal(t, "0029_add_upload_sessions.sql", files[28].filename)
+	require.Equal(t, "0030_add_file_key_owner.sql", files[29].filename)
+	require.Equal(t, "0031_add_mapping_owner.sql", files[30].filename)
+	require.Equal(t, "0032_add_fetch_jobs.sql", files[31].filename)
+	require.Equal(t, "0033_add_content_types.sql", files[32].filename)
+	require.Equal(t, "0034_add_session_sealed_secret.sql", files[33].filename)
+	require.Equal(t, "0035_add_s3_replication_rules.sql", files[34].filename)
+	require.Equal(t, "0036_add_upload_session_webdav_scope.sql", files[35].filename)
+	require.Equal(t, "0037_add_mapping_creator.sql", files[36].filename)
+	require.Equal(t, "0038_move_webdav_uploads_to_sessions.sql", files[37].filename)
+	require.Equal(t, "0039_keep_only_webdav_scope_principal.sql", files[38].filename)
 
 	_, err = listMigrationFiles(fstest.MapFS{
 		"1_invalid.sql": &fstest.MapFile{Data: []byte("SELECT 1;")},
@@ -38,9 +38,10 @@ type IDGenFunc func() uint64
 type onSelectDirFunc func(ctx context.Context, parentid uint64, tx database.IQueryExecer) error
 
 type dbDirectory struct {
-	db   database.IDatabase
-	tab  string
-	idfn IDGenFunc
+	db        database.IDatabase
+	tab       string
+	idfn      IDGenFunc
+	observers []ChangeObserver
 }
 
 type directoryTransaction struct {
@@ -103,6 +104,7 @@ func (t *directoryTransaction) Create(
 			FileSize_:      size,
 			FileMode_:      defaultEntryFileMode,
 			FileName_:      name,
+			ContentType_:   ContentTypeFromContext(ctx),
 		}
 		entryID, err := t.directory.txCreateFile(ctx, tx, parentID, entry)
 		if err != nil {
@@ -188,13 +190,24 @@ func (t *directoryTransaction) Replace(
 	if entry.FileKind_ != defaultFileKindFile {
 		return nil, ErrEntryNotFile
 	}
-	statement, args, err := builder.BuildUpdate(t.directory.table(), map[string]any{
-		"entry_id": entry.EntryId_,
-	}, map[string]any{
+	update := map[string]any{
 		"ref_data":  refdata,
 		"file_size": size,
 		"mtime":     mtime,
-	})
+	}
+	// The writer of the new content owns it and is charged for it; the
+	// creator, which keeps the default right to change the ACL, stays.
+	owner := OwnerFromContext(ctx)
+	if owner != "" {
+		update["owner"] = owner
+	}
+	contentType := ContentTypeFromContext(ctx)
+	if contentType != "" {
+		update["content_type"] = contentType
+	}
+	statement, args, err := builder.BuildUpdate(t.directory.table(), map[string]any{
+		"entry_id": entry.EntryId_,
+	}, update)
 	if err != nil {
 		return nil, fmt.Errorf("build transaction replace: %w", err)
 	}
@@ -215,6 +228,12 @@ func (t *directoryTransaction) Replace(
 	entry.RefData_ = refdata
 	entry.FileSize_ = size
 	entry.Mtime_ = mtime
+	if owner != "" {
+		entry.Owner_ = owner
+	}
+	if contentType != "" {
+		entry.ContentType_ = contentType
+	}
 	return previous, nil
 }
 
@@ -443,6 +462,115 @@ func (t *directoryTransaction) Move(
 	return overwritten, nil
 }
 
+// Detach moves filename under a new anchor id and returns the anchor with the
+// detached entries, the detached root last. The entries are journaled as
+// deleted; their rows, names and ids are kept.
+func (t *directoryTransaction) Detach(
+	ctx context.Context,
+	filename string,
+) (uint64, []IDirectoryEntry, error) {
+	if _, _, isRoot := t.directory.splitFilename(filename); isRoot {
+		return 0, nil, ErrEntryMustNotBeRoot
+	}
+	entry, exists, err := t.directory.txGetEntryInfo(ctx, t.tx, filename, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("find transaction detach path %q: %w", filename, err)
+	}
+	if !exists {
+		return 0, nil, os.ErrNotExist
+	}
+	detached := make([]IDirectoryEntry, 0, 1)
+	if err := t.collectEntries(ctx, entry, &detached); err != nil {
+		return 0, nil, err
+	}
+	if err := t.directory.recordTreeChanges(ctx, t.tx, filename, "deleted"); err != nil {
+		return 0, nil, err
+	}
+	anchor := t.directory.newEntryId()
+	if err := t.directory.txChangeParent(ctx, t.tx, entry.EntryId_, anchor, nil); err != nil {
+		return 0, nil, fmt.Errorf("detach entry %q: %w", filename, err)
+	}
+	if err := t.directory.touchParent(ctx, t.tx, filename, time.Now().UnixMilli()); err != nil {
+		return 0, nil, err
+	}
+	return anchor, detached, nil
+}
+
+// Attach moves the subtree detached under anchor to filename, creating the
+// missing parent collections. An existing filename is never replaced.
+func (t *directoryTransaction) Attach(
+	ctx context.Context,
+	anchor uint64,
+	filename string,
+) (IDirectoryEntry, error) {
+	dir, name, isRoot := t.directory.splitFilename(filename)
+	if isRoot {
+		return nil, ErrEntryMustNotBeRoot
+	}
+	detached, err := t.directory.txListDir(ctx, t.tx, anchor, 0, 1)
+	if err != nil {
+		return nil, fmt.Errorf("find detached entry %d: %w", anchor, err)
+	}
+	if len(detached) == 0 {
+		return nil, os.ErrNotExist
+	}
+	entry := detached[0]
+	err = t.directory.txOnSelectDir(ctx, t.tx, dir, true, func(
+		ctx context.Context,
+		parentID uint64,
+		tx database.IQueryExecer,
+	) error {
+		if exists, err := t.directory.txIsEntryExist(ctx, tx, parentID, name); err != nil {
+			return fmt.Errorf("check attach destination %q: %w", name, err)
+		} else if exists {
+			return ErrDestinationExists
+		}
+		if err := t.directory.txChangeParent(ctx, tx, entry.EntryId_, parentID, &name); err != nil {
+			return fmt.Errorf("attach entry %q: %w", name, err)
+		}
+		entry.ParentEntryId_ = parentID
+		entry.FileName_ = name
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("attach transaction path %q: %w", filename, err)
+	}
+	if err := t.directory.recordTreeChanges(ctx, t.tx, filename, "created"); err != nil {
+		return nil, err
+	}
+	if err := t.directory.touchParent(ctx, t.tx, filename, time.Now().UnixMilli()); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Drop deletes the subtree detached under anchor and returns its entries.
+// Detached entries have no path, so nothing is journaled.
+func (t *directoryTransaction) Drop(ctx context.Context, anchor uint64) ([]IDirectoryEntry, error) {
+	detached, err := t.directory.txListAllDir(ctx, t.tx, anchor)
+	if err != nil {
+		return nil, fmt.Errorf("list detached entries %d: %w", anchor, err)
+	}
+	dropped := make([]IDirectoryEntry, 0, len(detached))
+	for _, entry := range detached {
+		if err := t.collectEntries(ctx, entry, &dropped); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range dropped {
+		statement, args, err := builder.BuildDelete(t.directory.table(), map[string]any{
+			"entry_id": entry.EntryID(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("build detached entry delete: %w", err)
+		}
+		if _, err := t.tx.ExecContext(ctx, statement, args...); err != nil {
+			return nil, fmt.Errorf("delete detached entry: %w", err)
+		}
+	}
+	return dropped, nil
+}
+
 func (e *dbDirectory) WithTransaction(ctx context.Context, callback TransactionFunc) error {
 	if err := e.db.OnTransation(ctx, func(ctx context.Context, tx database.IQueryExecer) error {
 		return callback(ctx, &directoryTransaction{directory: e, tx: tx})
@@ -501,17 +629,29 @@ func (e *dbDirectory) recordChange(
 	exec database.IExecer,
 	entryPath, kind string,
 ) error {
-	entryPath = path.Clean(entryPath)
-	if _, err := exec.ExecContext(
+	change := Change{Path: path.Clean(entryPath), Kind: kind, ChangedAt: time.Now().UnixMilli()}
+	result, err := exec.ExecContext(
 		ctx,
 		`INSERT INTO tg_webdav_change_tab(path, change_kind, changed_at)
 VALUES (?, ?, ?)`,
-		entryPath,
-		kind,
-		time.Now().UnixMilli(),
-	); err != nil {
+		change.Path,
+		change.Kind,
+		change.ChangedAt,
+	)
+	if err != nil {
 		return fmt.Errorf("record directory change: %w", err)
 	}
+	if len(e.observers) == 0 {
+		return nil
+	}
+	if change.Revision, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("read directory change revision: %w", err)
+	}
+	for _, observe := range e.observers {
+		if err := observe(ctx, exec, change); err != nil {
+			return fmt.Errorf("observe directory change: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -684,6 +824,10 @@ func (e *dbDirectory) txCreateEntry(
 	ent *directoryEntryTab,
 ) (uint64, error) {
 	eid := e.newEntryId()
+	owner := ent.Owner_
+	if contextOwner := OwnerFromContext(ctx); contextOwner != "" && pid != 0 {
+		owner = contextOwner
+	}
 	data := []map[string]any{
 		{
 			"entry_id":        eid,
@@ -695,6 +839,9 @@ func (e *dbDirectory) txCreateEntry(
 			"file_size":       ent.FileSize_,
 			"file_mode":       ent.FileMode_,
 			"file_name":       ent.FileName_,
+			"owner":           owner,
+			"creator":         owner,
+			"content_type":    ent.ContentType_,
 		},
 	}
 	sql, args, err := builder.BuildInsert(e.table(), data)
@@ -873,7 +1020,9 @@ func (e *dbDirectory) txSelectDirComponent(
 	if !allowCreate {
 		return nil, os.ErrNotExist
 	}
-	entryID, err := e.txCreateDir(ctx, tx, parentID, name)
+	// A parent created on the way to another entry belongs to nobody, so the
+	// first writer below a shared directory does not become its owner.
+	entryID, err := e.txCreateDir(ContextWithOwner(ctx, ""), tx, parentID, name)
 	if err != nil {
 		return nil, fmt.Errorf("create path component %q: %w", name, err)
 	}
@@ -1023,6 +1172,8 @@ func (e *dbDirectory) txDoIterAndCopy(
 		FileSize_:      srcinfo.FileSize_,
 		FileMode_:      srcinfo.FileMode_,
 		FileName_:      newname,
+		Owner_:         srcinfo.Owner_,
+		ContentType_:   srcinfo.ContentType_,
 	})
 	if err != nil {
 		return fmt.Errorf("create copied entry %q: %w", newname, err)
@@ -1462,10 +1613,18 @@ func (e *dbDirectory) innerScan(ctx context.Context, lastid uint64, batch uint)
 	return out, nextid, nil
 }
 
-func NewDBDirectory(db database.IDatabase, tab string, idfn IDGenFunc) (ITransactionalDirectory, error) {
+// NewDBDirectory opens the path tree stored in tab. Observers see every
+// change the tree records in the change journal.
+func NewDBDirectory(
+	db database.IDatabase,
+	tab string,
+	idfn IDGenFunc,
+	observers ...ChangeObserver,
+) (ITransactionalDirectory, error) {
 	return &dbDirectory{
-		db:   db,
-		tab:  tab,
-		idfn: idfn,
+		db:        db,
+		tab:       tab,
+		idfn:      idfn,
+		observers: observers,
 	}, nil
 }
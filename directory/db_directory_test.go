@@ -88,6 +88,68 @@ func TestReplaceReturnsPreviousEntrySnapshot(t *testing.T) {
 	assert.EqualValues(t, 7, current.Size())
 }
 
+func TestDetachAttachAndDrop(t *testing.T) {
+	setupDirectoryTest(t)
+	ctx := t.Context()
+	require.NoError(t, dav.Create(ctx, "/docs/sub/a.txt", 3, "a"))
+	require.NoError(t, dav.Create(ctx, "/docs/b.txt", 4, "b"))
+	transactional, ok := dav.(ITransactionalDirectory)
+	require.True(t, ok)
+	withTx := func(callback TransactionFunc) error {
+		return transactional.WithTransaction(ctx, callback)
+	}
+
+	var anchor uint64
+	require.NoError(t, withTx(func(ctx context.Context, tx ITransaction) error {
+		var detached []IDirectoryEntry
+		var err error
+		anchor, detached, err = tx.Detach(ctx, "/docs")
+		if err != nil {
+			return err
+		}
+		require.Len(t, detached, 4)
+		assert.Equal(t, "docs", detached[len(detached)-1].Name())
+		return nil
+	}))
+	_, err := dav.Stat(ctx, "/docs/b.txt")
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.Error(t, withTx(func(ctx context.Context, tx ITransaction) error {
+		_, _, err := tx.Detach(ctx, "/")
+		return err
+	}))
+
+	require.NoError(t, dav.Mkdir(ctx, "/docs"))
+	require.ErrorIs(t, withTx(func(ctx context.Context, tx ITransaction) error {
+		_, err := tx.Attach(ctx, anchor, "/docs")
+		return err
+	}), ErrDestinationExists)
+	require.NoError(t, withTx(func(ctx context.Context, tx ITransaction) error {
+		_, err := tx.Attach(ctx, anchor, "/restored/docs")
+		return err
+	}))
+	restored, err := dav.Stat(ctx, "/restored/docs/sub/a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "a", restored.RefData())
+
+	require.NoError(t, withTx(func(ctx context.Context, tx ITransaction) error {
+		anchor, _, err = tx.Detach(ctx, "/restored/docs")
+		return err
+	}))
+	require.NoError(t, withTx(func(ctx context.Context, tx ITransaction) error {
+		dropped, err := tx.Drop(ctx, anchor)
+		require.Len(t, dropped, 4)
+		return err
+	}))
+	rows, err := dbc.QueryContext(ctx, "SELECT entry_id FROM tg_file_mapping_tab WHERE file_name IN ('sub', 'a.txt')")
+	require.NoError(t, err)
+	assert.False(t, rows.Next())
+	require.NoError(t, rows.Close())
+	require.ErrorIs(t, withTx(func(ctx context.Context, tx ITransaction) error {
+		_, err := tx.Attach(ctx, anchor, "/again")
+		return err
+	}), os.ErrNotExist)
+}
+
 func TestStatMissingDoesNotCreateParentDirectories(t *testing.T) {
 	setupDirectoryTest(t)
 	ctx := context.Background()
@@ -8,6 +8,49 @@ import (
 
 type DirectoryScanCallbackFunc func(ctx context.Context, res []IDirectoryEntry) (bool, error)
 
+type ownerContextKey struct{}
+
+// ContextWithOwner makes the entries created or rewritten with ctx owned by
+// owner. Without one, created entries have no owner and copies keep the
+// owner of their source.
+func ContextWithOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, ownerContextKey{}, owner)
+}
+
+// OwnerFromContext returns the owner set by ContextWithOwner.
+func OwnerFromContext(ctx context.Context) string {
+	owner, _ := ctx.Value(ownerContextKey{}).(string)
+	return owner
+}
+
+type contentTypeContextKey struct{}
+
+// ContextWithContentType stores contentType on the file entries created or
+// rewritten with ctx. Copies keep the content type of their source.
+func ContextWithContentType(ctx context.Context, contentType string) context.Context {
+	return context.WithValue(ctx, contentTypeContextKey{}, contentType)
+}
+
+// ContentTypeFromContext returns the content type set by
+// ContextWithContentType.
+func ContentTypeFromContext(ctx context.Context) string {
+	contentType, _ := ctx.Value(contentTypeContextKey{}).(string)
+	return contentType
+}
+
+// Change is one entry of the change journal.
+type Change struct {
+	Revision  int64
+	Path      string
+	Kind      string
+	ChangedAt int64
+}
+
+// ChangeObserver is called for every change recorded in the journal, inside
+// the transaction that made it, so work it writes commits or rolls back with
+// the change itself.
+type ChangeObserver func(ctx context.Context, exec database.IExecer, change Change) error
+
 type PageCursor struct {
 	IsDir   bool
 	Name    string
@@ -32,6 +75,12 @@ type IDirectoryEntryMetadata interface {
 	Mtime() int64
 	Mode() uint32
 	Size() int64
+	// Owner is the principal that created the entry or wrote its content,
+	// empty when that is not known.
+	Owner() string
+	// ContentType is the media type served for a file entry, empty when it
+	// was created before content types were stored.
+	ContentType() string
 }
 
 type IDirectoryEntry interface {
@@ -93,10 +142,20 @@ type ITransactionTransfer interface {
 	Move(ctx context.Context, source, destination string, overwrite bool) ([]IDirectoryEntry, error)
 }
 
+// ITransactionArchive takes subtrees out of the path namespace and puts them
+// back. A detached subtree keeps its rows, parented under an anchor id that
+// no entry owns, so nothing resolves it by path until it is attached again.
+type ITransactionArchive interface {
+	Detach(ctx context.Context, filename string) (uint64, []IDirectoryEntry, error)
+	Attach(ctx context.Context, anchor uint64, filename string) (IDirectoryEntry, error)
+	Drop(ctx context.Context, anchor uint64) ([]IDirectoryEntry, error)
+}
+
 type ITransaction interface {
 	ITransactionReader
 	ITransactionMutation
 	ITransactionTransfer
+	ITransactionArchive
 	QueryExecer() database.IQueryExecer
 }
 
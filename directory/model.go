@@ -11,6 +11,8 @@ type directoryEntryTab struct {
 	FileSize_      int64  `json:"file_size"`
 	FileMode_      uint32 `json:"file_mode"`
 	FileName_      string `json:"file_name"`
+	Owner_         string `json:"owner"`
+	ContentType_   string `json:"content_type"`
 }
 
 func (e *directoryEntryTab) ToDirectoyEntry() IDirectoryEntry {
@@ -48,3 +50,11 @@ func (e *directoryEntryTab) Size() int64 {
 func (e *directoryEntryTab) IsDir() bool {
 	return e.FileKind_ == defaultFileKindDir
 }
+
+func (e *directoryEntryTab) Owner() string {
+	return e.Owner_
+}
+
+func (e *directoryEntryTab) ContentType() string {
+	return e.ContentType_
+}
@@ -8,6 +8,7 @@ const EmptyFileMD5Sum = "d41d8cd98f00b204e9800998ecf8427e"
 type CreateFileDraftRequest struct {
 	FileSize      int64
 	FilePartCount int32
+	BackendKind   string // 存放文件块的后端, 为空表示主后端
 }
 
 type CreateFileDraftResponse struct {
@@ -48,6 +49,7 @@ type FileInfoItem struct {
 	FileState         uint32 `json:"file_state"`
 	Extinfo           string `json:"extinfo"`
 	FileLayoutVersion int32  `json:"file_layout_version"`
+	BackendKind       string `json:"backend_kind"`
 }
 
 type FileExtInfo struct {
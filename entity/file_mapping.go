@@ -13,6 +13,10 @@ type FileLinkMeta struct {
 	Ctime    int64  `json:"ctime"`
 	Mtime    int64  `json:"mtime"`
 	IsDir    bool   `json:"is_dir"`
+	Owner    string `json:"owner"`
+	// ContentType is the stored media type of a file, empty for mappings
+	// created before content types were stored.
+	ContentType string `json:"content_type"`
 }
 
 type GetFileLinkMetaResponse struct {
@@ -0,0 +1,315 @@
+package fetchmgr
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xxxsen/mimetype"
+
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filekey"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+const (
+	progressInterval = time.Second
+	// s3CacheControl matches the default of objects stored with PutObject.
+	s3CacheControl = "public, max-age=604800"
+)
+
+// fetchJob stores the body of the source of a running job as a new file and
+// moves the job on to publishing.
+func (m *Manager) fetchJob(ctx context.Context, job *Job) error {
+	headers, err := decodeHeaders(job.headers)
+	if err != nil {
+		return err
+	}
+	state := &progress{}
+	state.total.Store(-1)
+	runCtx, stop := m.watch(ctx, job.JobID, state)
+	defer stop()
+	body := &source{
+		ctx:      runCtx,
+		client:   m.client,
+		url:      job.SourceURL,
+		headers:  headers,
+		retries:  m.options.MaxRetries,
+		backoff:  m.options.RetryBackoff,
+		progress: state,
+	}
+	if err := body.open(); err != nil {
+		return err
+	}
+	defer func() { _ = body.Close() }()
+	if body.length > m.options.MaxSize {
+		return fmt.Errorf("%w: %d bytes", errSourceTooLarge, body.length)
+	}
+	state.total.Store(body.length)
+	reader := &hashingReader{reader: body, progress: state, md5: filemgr.NewMD5CompatibilityHash(), sha256: sha256.New()}
+	fileID, size, err := m.store(runCtx, job.JobID, body.length, reader)
+	if err != nil {
+		return err
+	}
+	job.fileID = fileID
+	stop()
+	job.Progress = Progress{BytesTotal: size, BytesCompleted: size, Attempts: state.attempts.Load()}
+	job.Result = Result{
+		ContentType: contentType(body.mediaType, targetName(job.Target)),
+		MD5:         hex.EncodeToString(reader.md5.Sum(nil)),
+		SHA256:      hex.EncodeToString(reader.sha256.Sum(nil)),
+	}
+	return m.finishFetch(ctx, job)
+}
+
+// store writes the body into a new file. A body of unknown length is
+// spooled to the work directory first.
+func (m *Manager) store(ctx context.Context, jobID string, length int64, body io.Reader) (uint64, int64, error) {
+	if length >= 0 {
+		fileID, err := m.files.CreateFile(ctx, length, body)
+		if err != nil {
+			return 0, 0, fmt.Errorf("store fetched file: %w", err)
+		}
+		return fileID, length, nil
+	}
+	spoolPath := filepath.Join(m.options.WorkDir, jobID+spoolSuffix)
+	spool, err := os.OpenFile(spoolPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return 0, 0, fmt.Errorf("create fetch spool: %w", err)
+	}
+	defer func() {
+		_ = spool.Close()
+		_ = os.Remove(spoolPath)
+	}()
+	written, err := io.Copy(spool, io.LimitReader(body, m.options.MaxSize+1))
+	switch {
+	case err != nil:
+		return 0, 0, fmt.Errorf("spool fetched file: %w", err)
+	case written > m.options.MaxSize:
+		return 0, 0, fmt.Errorf("%w: more than %d bytes", errSourceTooLarge, m.options.MaxSize)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, fmt.Errorf("rewind fetch spool: %w", err)
+	}
+	fileID, err := m.files.CreateFile(ctx, written, spool)
+	if err != nil {
+		return 0, 0, fmt.Errorf("store fetched file: %w", err)
+	}
+	return fileID, written, nil
+}
+
+func (m *Manager) finishFetch(ctx context.Context, job *Job) error {
+	result, err := m.db.ExecContext(
+		ctx,
+		`UPDATE tg_fetch_job_tab
+SET job_state = 'publishing', file_id = ?, bytes_total = ?, bytes_completed = ?,
+attempts = ?, content_type = ?, content_md5 = ?, content_sha256 = ?, updated_at = ?
+WHERE job_id = ? AND job_state = 'fetching' AND cancel_requested = 0`,
+		job.fileID,
+		job.Progress.BytesTotal,
+		job.Progress.BytesCompleted,
+		job.Progress.Attempts,
+		job.Result.ContentType,
+		job.Result.MD5,
+		job.Result.SHA256,
+		time.Now().UnixMilli(),
+		job.JobID,
+	)
+	if err != nil {
+		return fmt.Errorf("record fetched file: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected != 1 {
+		return errJobCanceled
+	}
+	return nil
+}
+
+// watch records the progress of a running fetch every progressInterval and
+// cancels the returned context once the job is canceled. stop ends both and
+// may be called more than once.
+func (m *Manager) watch(ctx context.Context, jobID string, state *progress) (context.Context, func()) {
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			if m.recordProgress(runCtx, jobID, state) {
+				cancel()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return runCtx, func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+}
+
+// recordProgress stores the progress of a job and reports whether it was
+// canceled.
+func (m *Manager) recordProgress(ctx context.Context, jobID string, state *progress) bool {
+	result, err := m.db.ExecContext(
+		ctx,
+		`UPDATE tg_fetch_job_tab
+SET bytes_total = ?, bytes_completed = ?, attempts = ?, updated_at = ?
+WHERE job_id = ? AND cancel_requested = 0`,
+		state.total.Load(),
+		state.completed.Load(),
+		state.attempts.Load(),
+		time.Now().UnixMilli(),
+		jobID,
+	)
+	if err != nil {
+		return false
+	}
+	affected, err := result.RowsAffected()
+	return err == nil && affected == 0
+}
+
+// publishJob links the fetched file at the target of the job. A file that is
+// already linked there was published before a restart.
+func (m *Manager) publishJob(ctx context.Context, job *Job) error {
+	ctx = filemgr.ContextWithOwner(ctx, job.Owner)
+	link, err := m.files.StatFileLink(ctx, targetLink(job))
+	switch {
+	case err == nil && !link.IsDir && link.FileId == job.fileID:
+	case err == nil || errors.Is(err, os.ErrNotExist):
+		if err := m.publish(ctx, job); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("stat fetch target: %w", err)
+	}
+	return m.finishSuccessfulJob(ctx, job.JobID)
+}
+
+func (m *Manager) publish(ctx context.Context, job *Job) error {
+	size := job.Progress.BytesTotal
+	var err error
+	switch job.Target.Kind {
+	case TargetFile:
+		err = m.files.PublishIssuedFileKey(ctx, job.Owner, job.keyDigest, job.Target.Name, job.fileID, size)
+	case TargetS3:
+		checksum, _ := hex.DecodeString(job.Result.SHA256)
+		_, err = m.files.PublishS3Object(ctx, targetLink(job), job.fileID, size, &entity.S3ObjectMetadata{
+			ETag:           `"` + job.Result.MD5 + `"`,
+			ChecksumSHA256: base64.StdEncoding.EncodeToString(checksum),
+			ContentType:    job.Result.ContentType,
+			CacheControl:   s3CacheControl,
+			UserMetadata:   "{}",
+		}, nil)
+	default:
+		err = m.publishWebDAV(ctx, job, size)
+	}
+	if err != nil {
+		return fmt.Errorf("publish fetched file: %w", err)
+	}
+	return nil
+}
+
+// publishWebDAV links the fetched file at the WebDAV target of job with the
+// options its scope resolves to now.
+func (m *Manager) publishWebDAV(ctx context.Context, job *Job, size int64) error {
+	if m.webdav == nil {
+		return fmt.Errorf("%w: WebDAV is not enabled", filemgr.ErrWebDAVTarget)
+	}
+	scope, err := filemgr.DecodeWebDAVScope(job.webdavScope)
+	if err != nil {
+		return fmt.Errorf("read fetch scope: %w", err)
+	}
+	options, err := m.webdav.ResolveScope(ctx, scope, job.Target.Path)
+	if err != nil {
+		return fmt.Errorf("resolve fetch scope: %w", err)
+	}
+	if _, err := m.files.PublishWebDAVFile(ctx, job.Target.Path, job.fileID, size, options); err != nil {
+		return fmt.Errorf("publish fetched WebDAV file: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) finishSuccessfulJob(ctx context.Context, jobID string) error {
+	now := time.Now().UnixMilli()
+	if _, err := m.db.ExecContext(
+		ctx,
+		`UPDATE tg_fetch_job_tab
+SET job_state = 'succeeded', error_code = '', error_message = '',
+request_headers = '{}', updated_at = ?, completed_at = ?
+WHERE job_id = ? AND job_state = 'publishing'`,
+		now,
+		now,
+		jobID,
+	); err != nil {
+		return fmt.Errorf("finish successful fetch job: %w", err)
+	}
+	return nil
+}
+
+// targetLink is the namespace path the target of a job is published at.
+func targetLink(job *Job) string {
+	switch job.Target.Kind {
+	case TargetFile:
+		return filekey.TokenLink(job.keyDigest, job.Target.Name)
+	case TargetS3:
+		return "/" + job.Target.Bucket + "/" + job.Target.Key
+	default:
+		return job.Target.Path
+	}
+}
+
+func targetName(target Target) string {
+	return path.Base("/" + target.Name + target.Key + target.Path)
+}
+
+// contentType keeps the media type the source declared and otherwise guesses
+// it from the target name.
+func contentType(declared, name string) string {
+	if mediaType, params, err := mime.ParseMediaType(declared); err == nil {
+		return mime.FormatMediaType(mediaType, params)
+	}
+	return mimetype.LookupWithDefault(path.Ext(name), "application/octet-stream")
+}
+
+// hashingReader hashes and counts what a fetch reads from its source.
+type hashingReader struct {
+	reader   io.Reader
+	progress *progress
+	md5      hash.Hash
+	sha256   hash.Hash
+}
+
+func (r *hashingReader) Read(buffer []byte) (int, error) {
+	count, err := r.reader.Read(buffer)
+	if count > 0 {
+		_, _ = r.md5.Write(buffer[:count])
+		_, _ = r.sha256.Write(buffer[:count])
+		r.progress.completed.Add(int64(count))
+	}
+	if errors.Is(err, io.EOF) {
+		return count, io.EOF
+	}
+	if err != nil {
+		return count, fmt.Errorf("read fetch source: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,832 @@
+// Package fetchmgr runs jobs that save the body of a remote HTTP URL into
+// the file store without passing it through the client. A job streams the
+// body into a new file, resuming with Range when the connection drops, and
+// then publishes the file at a direct-upload key, an S3 object or a WebDAV
+// path.
+package fetchmgr
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/filekey"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+var (
+	ErrInvalidRequest      = errors.New("invalid fetch request")
+	ErrIdempotencyConflict = errors.New("fetch idempotency key has different parameters")
+	ErrJobNotFound         = errors.New("fetch job does not exist")
+	ErrJobNotCancelable    = errors.New("fetch job cannot be canceled")
+	errJobCanceled         = errors.New("fetch job was canceled")
+	errJobClaimed          = errors.New("fetch job is already being processed")
+)
+
+const (
+	TargetFile   = "file"
+	TargetS3     = "s3"
+	TargetWebDAV = "webdav"
+
+	pollInterval        = 250 * time.Millisecond
+	cleanupInterval     = time.Minute
+	defaultWorkers      = 2
+	defaultMaxRetries   = 5
+	defaultRetryBackoff = time.Second
+	spoolSuffix         = ".partial"
+)
+
+type Options struct {
+	// WorkDir holds the bodies of sources that do not declare their length
+	// while they are fetched.
+	WorkDir string
+	// MaxSize bounds the body of a source.
+	MaxSize int64
+	// MaxRetries bounds the requests a job repeats after transport errors,
+	// retryable statuses and interrupted bodies.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	Workers      int
+	// AllowPrivateNetworks lets sources resolve to loopback, private and
+	// link-local addresses.
+	AllowPrivateNetworks bool
+	// S3Buckets are the buckets a job may publish into.
+	S3Buckets    []string
+	JobRetention time.Duration
+}
+
+type Manager struct {
+	db      database.IDatabase
+	files   filemgr.IFileManager
+	webdav  filemgr.IWebDAVScopeResolver
+	client  *http.Client
+	options Options
+	buckets map[string]struct{}
+}
+
+// Target is where a job publishes the fetched file: a direct-upload key
+// called Name, the object Key in Bucket, or the WebDAV Path.
+type Target struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name,omitempty"`
+	Bucket string `json:"bucket,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+type CreateRequest struct {
+	Owner          string
+	IdempotencyKey string
+	SourceURL      string
+	Headers        map[string]string
+	Target         Target
+	// WebDAV is the scope a WebDAV target is published with.
+	WebDAV filemgr.WebDAVScope
+}
+
+type JobCursor struct {
+	CreatedAt int64
+	JobID     string
+}
+
+type ListJobsRequest struct {
+	Owner  string
+	State  string
+	Cursor *JobCursor
+	Limit  int
+}
+
+type JobPage struct {
+	Jobs       []*Job
+	NextCursor *JobCursor
+}
+
+// Progress counts the bytes read from the source. BytesTotal is -1 until
+// the length of the body is known.
+type Progress struct {
+	BytesTotal     int64 `json:"bytes_total"`
+	BytesCompleted int64 `json:"bytes_completed"`
+	Attempts       int64 `json:"attempts"`
+}
+
+type Result struct {
+	ContentType string `json:"content_type"`
+	MD5         string `json:"md5"`
+	SHA256      string `json:"sha256"`
+}
+
+type JobError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Job is a fetch job. FileKey is only set on the job Create returns for a
+// new file target; the key is not stored.
+type Job struct {
+	JobID           string   `json:"job_id"`
+	Owner           string   `json:"-"`
+	State           string   `json:"state"`
+	SourceURL       string   `json:"source_url"`
+	Target          Target   `json:"target"`
+	IdempotencyKey  string   `json:"idempotency_key"`
+	Progress        Progress `json:"progress"`
+	Result          Result   `json:"result"`
+	Error           JobError `json:"error"`
+	FileKey         string   `json:"file_key,omitempty"`
+	CreatedAt       int64    `json:"created_at"`
+	UpdatedAt       int64    `json:"updated_at"`
+	CompletedAt     int64    `json:"completed_at"`
+	headers         string
+	keyDigest       string
+	webdavScope     string
+	fileID          uint64
+	fingerprint     string
+	cancelRequested bool
+}
+
+// Cancelable reports whether Cancel can stop the job.
+func (j *Job) Cancelable() bool {
+	return j.State == "queued" || j.State == "fetching" || j.State == "canceling"
+}
+
+// progress is shared between a running fetch and the goroutine that
+// records it.
+type progress struct {
+	total     atomic.Int64
+	completed atomic.Int64
+	attempts  atomic.Int64
+}
+
+func New(
+	db database.IDatabase,
+	files filemgr.IFileManager,
+	options Options,
+) (*Manager, error) {
+	if db == nil || files == nil || !filepath.IsAbs(options.WorkDir) || options.MaxSize < 1 {
+		return nil, ErrInvalidRequest
+	}
+	if options.MaxRetries == 0 {
+		options.MaxRetries = defaultMaxRetries
+	}
+	if options.RetryBackoff <= 0 {
+		options.RetryBackoff = defaultRetryBackoff
+	}
+	if options.Workers <= 0 {
+		options.Workers = defaultWorkers
+	}
+	if err := os.MkdirAll(options.WorkDir, 0o700); err != nil {
+		return nil, fmt.Errorf("create fetch work directory: %w", err)
+	}
+	buckets := make(map[string]struct{}, len(options.S3Buckets))
+	for _, bucket := range options.S3Buckets {
+		buckets[bucket] = struct{}{}
+	}
+	return &Manager{
+		db:      db,
+		files:   files,
+		client:  newHTTPClient(options.AllowPrivateNetworks),
+		options: options,
+		buckets: buckets,
+	}, nil
+}
+
+// SetWebDAVScopeResolver lets the manager accept WebDAV targets and
+// resolves their scope through resolver when a job publishes. It must be
+// called before the manager serves requests or runs.
+func (m *Manager) SetWebDAVScopeResolver(resolver filemgr.IWebDAVScopeResolver) {
+	m.webdav = resolver
+}
+
+// Create queues a job. A repeated Idempotency key of the same owner returns
+// the job it created, without its file key, as long as the request matches.
+func (m *Manager) Create(ctx context.Context, request CreateRequest) (*Job, error) {
+	headers, err := m.validateCreateRequest(&request)
+	if err != nil {
+		return nil, err
+	}
+	target := request.Target
+	targetPath := target.Name + target.Key + target.Path
+	fingerprint := requestFingerprint(request.SourceURL, headers, target.Kind, target.Bucket, targetPath)
+	var key *filekey.Key
+	keyDigest := ""
+	if target.Kind == TargetFile {
+		if key, err = filekey.New(target.Name); err != nil {
+			return nil, fmt.Errorf("issue fetch file key: %w", err)
+		}
+		keyDigest = key.Digest()
+	}
+	scope := "{}"
+	if target.Kind == TargetWebDAV {
+		if scope, err = request.WebDAV.Encode(); err != nil {
+			return nil, fmt.Errorf("encode fetch scope: %w", err)
+		}
+	}
+	jobID, err := randomJobID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+	_, insertErr := m.db.ExecContext(
+		ctx,
+		`INSERT INTO tg_fetch_job_tab (
+job_id, owner, job_state, source_url, request_headers, target_kind, target_bucket,
+target_path, key_digest, webdav_scope, idempotency_key, request_fingerprint,
+created_at, updated_at
+) VALUES (?, ?, 'queued', ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		jobID, request.Owner, request.SourceURL, headers, target.Kind, target.Bucket,
+		targetPath, keyDigest, scope, request.IdempotencyKey, fingerprint, now, now,
+	)
+	if insertErr == nil {
+		job, err := m.GetJob(ctx, jobID)
+		if err == nil && key != nil {
+			job.FileKey = key.Raw
+		}
+		return job, err
+	}
+	existing, err := m.getJobByIdempotency(ctx, request.Owner, request.IdempotencyKey)
+	if err != nil {
+		return nil, fmt.Errorf("create fetch job: %w", insertErr)
+	}
+	if existing.fingerprint != fingerprint {
+		return nil, ErrIdempotencyConflict
+	}
+	return existing, nil
+}
+
+const jobSelectColumns = `job_id, owner, job_state, source_url, request_headers,
+target_kind, target_bucket, target_path, key_digest, webdav_scope, idempotency_key,
+request_fingerprint, bytes_total, bytes_completed, attempts, content_type,
+content_md5, content_sha256, file_id, error_code, error_message,
+cancel_requested, created_at, updated_at, completed_at`
+
+func (m *Manager) GetJob(ctx context.Context, jobID string) (*Job, error) {
+	if !validJobID(jobID) {
+		return nil, ErrJobNotFound
+	}
+	job, err := scanJob(queryJobRow(
+		ctx,
+		m.db,
+		`SELECT `+jobSelectColumns+` FROM tg_fetch_job_tab WHERE job_id = ?`,
+		jobID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read fetch job: %w", err)
+	}
+	return job, nil
+}
+
+func scanJob(scanner rowScanner) (*Job, error) {
+	var job Job
+	var targetPath string
+	var cancelRequested int
+	err := scanner.Scan(
+		&job.JobID,
+		&job.Owner,
+		&job.State,
+		&job.SourceURL,
+		&job.headers,
+		&job.Target.Kind,
+		&job.Target.Bucket,
+		&targetPath,
+		&job.keyDigest,
+		&job.webdavScope,
+		&job.IdempotencyKey,
+		&job.fingerprint,
+		&job.Progress.BytesTotal,
+		&job.Progress.BytesCompleted,
+		&job.Progress.Attempts,
+		&job.Result.ContentType,
+		&job.Result.MD5,
+		&job.Result.SHA256,
+		&job.fileID,
+		&job.Error.Code,
+		&job.Error.Message,
+		&cancelRequested,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.CompletedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("scan fetch job: %w", err)
+	}
+	switch job.Target.Kind {
+	case TargetFile:
+		job.Target.Name = targetPath
+	case TargetS3:
+		job.Target.Key = targetPath
+	default:
+		job.Target.Path = targetPath
+	}
+	job.cancelRequested = cancelRequested != 0
+	return &job, nil
+}
+
+func (m *Manager) getJobByIdempotency(ctx context.Context, owner, key string) (*Job, error) {
+	var jobID string
+	if err := queryJobRow(
+		ctx,
+		m.db,
+		`SELECT job_id FROM tg_fetch_job_tab WHERE owner = ? AND idempotency_key = ?`,
+		owner,
+		key,
+	).Scan(&jobID); err != nil {
+		return nil, fmt.Errorf("read idempotent fetch job: %w", err)
+	}
+	return m.GetJob(ctx, jobID)
+}
+
+// ListJobs pages through the jobs of Owner, or of every owner when Owner is
+// empty, newest first.
+func (m *Manager) ListJobs(ctx context.Context, request ListJobsRequest) (*JobPage, error) {
+	if !validListJobsRequest(request) {
+		return nil, ErrInvalidRequest
+	}
+	cursorCreatedAt, cursorJobID := int64(0), ""
+	if request.Cursor != nil {
+		cursorCreatedAt, cursorJobID = request.Cursor.CreatedAt, request.Cursor.JobID
+	}
+	rows, err := m.db.QueryContext(
+		ctx,
+		`SELECT `+jobSelectColumns+`
+FROM tg_fetch_job_tab
+WHERE (? = '' OR owner = ?)
+  AND (? = '' OR job_state = ?)
+  AND (? = 0 OR created_at < ? OR (created_at = ? AND job_id < ?))
+ORDER BY created_at DESC, job_id DESC
+LIMIT ?`,
+		request.Owner, request.Owner,
+		request.State, request.State,
+		cursorCreatedAt, cursorCreatedAt, cursorCreatedAt, cursorJobID,
+		request.Limit+1,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list fetch jobs: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	jobs := make([]*Job, 0, request.Limit+1)
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan fetch job page: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate fetch job page: %w", err)
+	}
+	page := &JobPage{}
+	if len(jobs) > request.Limit {
+		jobs = jobs[:request.Limit]
+		last := jobs[len(jobs)-1]
+		page.NextCursor = &JobCursor{CreatedAt: last.CreatedAt, JobID: last.JobID}
+	}
+	page.Jobs = jobs
+	return page, nil
+}
+
+func validListJobsRequest(request ListJobsRequest) bool {
+	if request.Limit < 1 || request.Limit > 200 {
+		return false
+	}
+	if request.Owner != "" && !validOwner(request.Owner) {
+		return false
+	}
+	if request.State != "" && !validJobState(request.State) {
+		return false
+	}
+	return request.Cursor == nil ||
+		request.Cursor.CreatedAt >= 1 && validJobID(request.Cursor.JobID)
+}
+
+// Cancel stops a job that has not started publishing. A queued job is
+// canceled at once; a running one when its worker notices.
+func (m *Manager) Cancel(ctx context.Context, jobID string) (*Job, error) {
+	job, err := m.GetJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if !job.Cancelable() {
+		return nil, ErrJobNotCancelable
+	}
+	now := time.Now().UnixMilli()
+	result, err := m.db.ExecContext(
+		ctx,
+		`UPDATE tg_fetch_job_tab
+SET cancel_requested = 1,
+    job_state = CASE WHEN job_state = 'queued' THEN 'canceled' ELSE 'canceling' END,
+    error_code = CASE WHEN job_state = 'queued' THEN 'canceled' ELSE error_code END,
+    error_message = CASE WHEN job_state = 'queued' THEN 'fetch job canceled' ELSE error_message END,
+    request_headers = CASE WHEN job_state = 'queued' THEN '{}' ELSE request_headers END,
+    completed_at = CASE WHEN job_state = 'queued' THEN ? ELSE completed_at END,
+    updated_at = ?
+WHERE job_id = ? AND job_state IN ('queued', 'fetching', 'canceling')`,
+		now,
+		now,
+		jobID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("request fetch cancellation: %w", err)
+	}
+	affected, _ := result.RowsAffected()
+	if affected != 1 {
+		return nil, ErrJobNotCancelable
+	}
+	return m.GetJob(ctx, jobID)
+}
+
+// Run recovers the jobs a previous process left behind and then works the
+// queue with Options.Workers workers until ctx ends.
+func (m *Manager) Run(ctx context.Context) error {
+	if err := m.recover(ctx); err != nil {
+		return err
+	}
+	if err := m.cleanup(ctx); err != nil {
+		return err
+	}
+	done := make(chan error, m.options.Workers+1)
+	for range m.options.Workers {
+		go func() { done <- m.worker(ctx) }()
+	}
+	go func() { done <- m.cleanupWorker(ctx) }()
+	err := <-done
+	if ctx.Err() != nil {
+		for range m.options.Workers {
+			<-done
+		}
+		return fmt.Errorf("run fetch workers: %w", ctx.Err())
+	}
+	return err
+}
+
+func (m *Manager) cleanupWorker(ctx context.Context) error {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("run fetch cleanup worker: %w", ctx.Err())
+		case <-ticker.C:
+			if err := m.cleanup(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (m *Manager) worker(ctx context.Context) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		worked, err := m.processNext(ctx)
+		if err != nil {
+			return err
+		}
+		if worked {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("run fetch worker: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessUntilTerminal runs a queued job in the caller's goroutine and
+// returns it once it completed.
+func (m *Manager) ProcessUntilTerminal(ctx context.Context, jobID string) (*Job, error) {
+	job, err := m.GetJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.State == "queued" {
+		if err := m.processJob(ctx, job); err != nil && !errors.Is(err, errJobClaimed) {
+			return nil, err
+		}
+	}
+	for {
+		job, err := m.GetJob(ctx, jobID)
+		if err != nil || isTerminal(job.State) {
+			return job, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("wait for fetch job: %w", ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (m *Manager) processNext(ctx context.Context) (bool, error) {
+	var jobID string
+	err := queryJobRow(
+		ctx,
+		m.db,
+		`SELECT job_id FROM tg_fetch_job_tab WHERE job_state = 'queued' ORDER BY created_at LIMIT 1`,
+	).Scan(&jobID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("claim fetch job: %w", err)
+	}
+	job, err := m.GetJob(ctx, jobID)
+	if err != nil {
+		return false, err
+	}
+	err = m.processJob(ctx, job)
+	if errors.Is(err, errJobClaimed) {
+		return true, nil
+	}
+	return true, err
+}
+
+func (m *Manager) processJob(ctx context.Context, job *Job) error {
+	if err := m.transitionState(ctx, job.JobID, "queued", "fetching"); err != nil {
+		return err
+	}
+	err := m.fetchJob(ctx, job)
+	if err == nil {
+		err = m.publishJob(ctx, job)
+	}
+	if err != nil {
+		return m.handleJobFailure(ctx, job, err)
+	}
+	return nil
+}
+
+func (m *Manager) handleJobFailure(ctx context.Context, job *Job, err error) error {
+	if ctx.Err() != nil {
+		// Shutting down: recover queues the job again on the next start.
+		return fmt.Errorf("fetch job interrupted: %w", ctx.Err())
+	}
+	cleanupCtx := context.WithoutCancel(ctx)
+	var cleanupErr error
+	if job.fileID != 0 {
+		cleanupErr = m.files.DiscardUnpublishedFile(cleanupCtx, job.fileID)
+	}
+	canceled, cancelErr := m.isCanceled(cleanupCtx, job.JobID)
+	if cancelErr == nil && canceled || errors.Is(err, errJobCanceled) {
+		return errors.Join(cleanupErr, m.finishJob(cleanupCtx, job.JobID, "canceled", "canceled", "fetch job canceled"))
+	}
+	code := classifyError(err)
+	return errors.Join(cleanupErr, cancelErr, m.finishJob(cleanupCtx, job.JobID, "failed", code, safeErrorMessage(code)))
+}
+
+func (m *Manager) finishJob(ctx context.Context, jobID, state, code, message string) error {
+	now := time.Now().UnixMilli()
+	if _, err := m.db.ExecContext(
+		ctx,
+		`UPDATE tg_fetch_job_tab
+SET job_state = ?, error_code = ?, error_message = ?, request_headers = '{}',
+updated_at = ?, completed_at = ?
+WHERE job_id = ? AND completed_at = 0`,
+		state,
+		code,
+		message,
+		now,
+		now,
+		jobID,
+	); err != nil {
+		return fmt.Errorf("finish fetch job: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) transitionState(ctx context.Context, jobID, from, to string) error {
+	result, err := m.db.ExecContext(
+		ctx,
+		`UPDATE tg_fetch_job_tab SET job_state = ?, updated_at = ?
+WHERE job_id = ? AND job_state = ? AND completed_at = 0 AND cancel_requested = 0`,
+		to,
+		time.Now().UnixMilli(),
+		jobID,
+		from,
+	)
+	if err != nil {
+		return fmt.Errorf("transition fetch job from %s to %s: %w", from, to, err)
+	}
+	affected, _ := result.RowsAffected()
+	if affected != 1 {
+		canceled, cancelErr := m.isCanceled(ctx, jobID)
+		if cancelErr == nil && canceled {
+			return errJobCanceled
+		}
+		return errJobClaimed
+	}
+	return nil
+}
+
+func (m *Manager) isCanceled(ctx context.Context, jobID string) (bool, error) {
+	var requested int
+	if err := queryJobRow(
+		ctx,
+		m.db,
+		"SELECT cancel_requested FROM tg_fetch_job_tab WHERE job_id = ?",
+		jobID,
+	).Scan(&requested); err != nil {
+		return false, fmt.Errorf("read fetch cancellation state: %w", err)
+	}
+	return requested != 0, nil
+}
+
+// recover queues the fetches an earlier process was running again from the
+// start, completes canceled ones and publishes fetched ones.
+func (m *Manager) recover(ctx context.Context) error {
+	now := time.Now().UnixMilli()
+	if _, err := m.db.ExecContext(
+		ctx,
+		`UPDATE tg_fetch_job_tab
+SET job_state = 'queued', bytes_total = -1, bytes_completed = 0, updated_at = ?
+WHERE job_state = 'fetching' AND cancel_requested = 0`,
+		now,
+	); err != nil {
+		return fmt.Errorf("queue interrupted fetch jobs: %w", err)
+	}
+	if _, err := m.db.ExecContext(
+		ctx,
+		`UPDATE tg_fetch_job_tab
+SET job_state = 'canceled', error_code = 'canceled', error_message = 'fetch job canceled',
+request_headers = '{}', updated_at = ?, completed_at = ?
+WHERE job_state IN ('fetching', 'canceling') AND completed_at = 0`,
+		now,
+		now,
+	); err != nil {
+		return fmt.Errorf("cancel interrupted fetch jobs: %w", err)
+	}
+	if err := m.removeSpools(); err != nil {
+		return err
+	}
+	return m.recoverPublishing(ctx)
+}
+
+func (m *Manager) recoverPublishing(ctx context.Context) error {
+	rows, err := m.db.QueryContext(
+		ctx,
+		`SELECT `+jobSelectColumns+` FROM tg_fetch_job_tab WHERE job_state = 'publishing' ORDER BY created_at`,
+	)
+	if err != nil {
+		return fmt.Errorf("query interrupted fetch publishes: %w", err)
+	}
+	jobs := make([]*Job, 0)
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			_ = rows.Close()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	err = errors.Join(rows.Err(), rows.Close())
+	if err != nil {
+		return fmt.Errorf("iterate interrupted fetch publishes: %w", err)
+	}
+	for _, job := range jobs {
+		if err := m.publishJob(ctx, job); err != nil {
+			if err := m.handleJobFailure(ctx, job, err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Manager) removeSpools() error {
+	entries, err := os.ReadDir(m.options.WorkDir)
+	if err != nil {
+		return fmt.Errorf("read fetch work directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), spoolSuffix) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(m.options.WorkDir, entry.Name())); err != nil &&
+			!errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove fetch spool: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) cleanup(ctx context.Context) error {
+	if m.options.JobRetention <= 0 {
+		return nil
+	}
+	cutoff := time.Now().Add(-m.options.JobRetention).UnixMilli()
+	if _, err := m.db.ExecContext(
+		ctx,
+		`DELETE FROM tg_fetch_job_tab WHERE completed_at > 0 AND completed_at < ?`,
+		cutoff,
+	); err != nil {
+		return fmt.Errorf("remove retained fetch jobs: %w", err)
+	}
+	return nil
+}
+
+func requestFingerprint(values ...string) string {
+	hash := sha256.New()
+	for _, value := range values {
+		_, _ = io.WriteString(hash, strconv.Itoa(len(value)))
+		_, _ = io.WriteString(hash, ":")
+		_, _ = io.WriteString(hash, value)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func randomJobID() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return "", fmt.Errorf("generate fetch job id: %w", err)
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func validJobID(value string) bool {
+	_, err := hex.DecodeString(value)
+	return len(value) == 64 && strings.ToLower(value) == value && err == nil
+}
+
+func isTerminal(state string) bool {
+	return state == "succeeded" || state == "failed" || state == "canceled"
+}
+
+func validJobState(state string) bool {
+	switch state {
+	case "queued", "fetching", "publishing", "canceling", "succeeded", "failed", "canceled":
+		return true
+	default:
+		return false
+	}
+}
+
+func decodeHeaders(raw string) (map[string]string, error) {
+	headers := make(map[string]string)
+	if err := json.Unmarshal([]byte(raw), &headers); err != nil {
+		return nil, fmt.Errorf("decode fetch request headers: %w", err)
+	}
+	return headers, nil
+}
+
+type rowScanner interface {
+	Scan(...any) error
+}
+
+func queryJobRow(
+	ctx context.Context,
+	queryer database.IQueryer,
+	query string,
+	args ...any,
+) rowScanner {
+	if handle, ok := queryer.(interface {
+		QueryRowContext(context.Context, string, ...any) *sql.Row
+	}); ok {
+		return handle.QueryRowContext(ctx, query, args...)
+	}
+	return &fallbackRow{ctx: ctx, queryer: queryer, query: query, args: args}
+}
+
+type fallbackRow struct {
+	ctx     context.Context
+	queryer database.IQueryer
+	query   string
+	args    []any
+}
+
+func (r *fallbackRow) Scan(dest ...any) error {
+	rows, err := r.queryer.QueryContext(r.ctx, r.query, r.args...)
+	if err != nil {
+		return fmt.Errorf("query fallback row: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("iterate fallback row: %w", err)
+		}
+		return sql.ErrNoRows
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return fmt.Errorf("scan fallback row: %w", err)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate fallback row: %w", err)
+	}
+	return nil
+}
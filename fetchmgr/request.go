@@ -0,0 +1,223 @@
+package fetchmgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+
+	"github.com/xxxsen/tgfile/directory"
+	"github.com/xxxsen/tgfile/filekey"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+const (
+	maxSourceURLBytes  = 4096
+	maxRequestHeaders  = 32
+	maxHeaderBytes     = 8 * 1024
+	maxObjectKeyBytes  = 1024
+	maxTargetPathBytes = 4096
+)
+
+// reservedHeaders are set by the fetch itself or describe the connection.
+var reservedHeaders = map[string]struct{}{
+	"Accept-Encoding":   {},
+	"Connection":        {},
+	"Content-Length":    {},
+	"Expect":            {},
+	"Host":              {},
+	"If-Range":          {},
+	"Keep-Alive":        {},
+	"Proxy-Connection":  {},
+	"Range":             {},
+	"Te":                {},
+	"Trailer":           {},
+	"Transfer-Encoding": {},
+	"Upgrade":           {},
+}
+
+// validateCreateRequest checks a request and returns its headers encoded for
+// storage, with canonical names.
+func (m *Manager) validateCreateRequest(request *CreateRequest) (string, error) {
+	if !validOwner(request.Owner) || !validIdempotencyKey(request.IdempotencyKey) ||
+		!validSourceURL(request.SourceURL) || !m.validTarget(request.Target, request.WebDAV) {
+		return "", ErrInvalidRequest
+	}
+	headers := make(map[string]string, len(request.Headers))
+	total := 0
+	for name, value := range request.Headers {
+		canonical := http.CanonicalHeaderKey(name)
+		_, reserved := reservedHeaders[canonical]
+		_, duplicate := headers[canonical]
+		if reserved || duplicate || strings.HasPrefix(canonical, "Proxy-") ||
+			!validHeaderName(name) || !validHeaderValue(value) {
+			return "", ErrInvalidRequest
+		}
+		headers[canonical] = value
+		total += len(name) + len(value)
+	}
+	if len(headers) > maxRequestHeaders || total > maxHeaderBytes {
+		return "", ErrInvalidRequest
+	}
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		return "", fmt.Errorf("encode fetch request headers: %w", err)
+	}
+	return string(encoded), nil
+}
+
+// validSourceURL accepts absolute http and https URLs. Credentials belong in
+// a header, where they are not shown with the job.
+func validSourceURL(value string) bool {
+	if len(value) > maxSourceURLBytes || hasControlCharacter(value) {
+		return false
+	}
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") &&
+		parsed.Host != "" && parsed.User == nil && parsed.Fragment == ""
+}
+
+func (m *Manager) validTarget(target Target, scope filemgr.WebDAVScope) bool {
+	switch target.Kind {
+	case TargetFile:
+		return target.Bucket == "" && target.Key == "" && target.Path == "" && validKeyName(target.Name)
+	case TargetS3:
+		_, exists := m.buckets[target.Bucket]
+		return exists && target.Name == "" && target.Path == "" && validObjectKey(target.Key)
+	case TargetWebDAV:
+		return m.webdav != nil && scope.Principal != "" && target.Name == "" && target.Bucket == "" &&
+			target.Key == "" && validTargetPath(target.Path)
+	default:
+		return false
+	}
+}
+
+func validKeyName(name string) bool {
+	return name != "" && name != "." && name != ".." && filekey.CheckName(name) == nil
+}
+
+// validObjectKey accepts keys that map to a clean path below the bucket.
+func validObjectKey(key string) bool {
+	return key != "" && len(key) <= maxObjectKeyBytes && utf8.ValidString(key) &&
+		!hasControlCharacter(key) && !strings.ContainsRune(key, '\\') &&
+		path.Clean("/"+key) == "/"+key
+}
+
+func validTargetPath(value string) bool {
+	return value != "/" && len(value) <= maxTargetPathBytes && strings.HasPrefix(value, "/") &&
+		path.Clean(value) == value && utf8.ValidString(value) && !hasControlCharacter(value)
+}
+
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, character := range name {
+		if character > 0x7e || !isTokenCharacter(byte(character)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTokenCharacter(character byte) bool {
+	switch {
+	case character >= '0' && character <= '9',
+		character >= 'a' && character <= 'z',
+		character >= 'A' && character <= 'Z':
+		return true
+	default:
+		return strings.IndexByte("!#$%&'*+-.^_`|~", character) >= 0
+	}
+}
+
+func validHeaderValue(value string) bool {
+	for _, character := range value {
+		if character != '\t' && (character < 0x20 || character == 0x7f) {
+			return false
+		}
+	}
+	return utf8.ValidString(value)
+}
+
+func validIdempotencyKey(value string) bool {
+	return len(value) >= 1 && len(value) <= 256 && !hasControlCharacter(value)
+}
+
+func validOwner(value string) bool {
+	return len(value) >= 1 && len(value) <= 256 && !hasControlCharacter(value)
+}
+
+func hasControlCharacter(value string) bool {
+	for _, character := range value {
+		if character < 0x20 || character == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+func classifyError(err error) string {
+	switch {
+	case errors.Is(err, errBlockedAddress):
+		return "source_blocked"
+	case errors.Is(err, errSourceTooLarge):
+		return "source_too_large"
+	case errors.Is(err, errSourceChanged):
+		return "source_changed"
+	case errors.Is(err, errSourceStatus):
+		return "source_status"
+	case errors.Is(err, errSourceUnavailable),
+		errors.Is(err, errTooManyRedirects),
+		errors.Is(err, errRedirectScheme):
+		return "source_unavailable"
+	case errors.Is(err, filemgr.ErrQuotaExceeded),
+		errors.Is(err, filemgr.ErrWebDAVQuota):
+		return "quota_exceeded"
+	case errors.Is(err, syscall.ENOSPC):
+		return "insufficient_storage"
+	case errors.Is(err, filemgr.ErrWebDAVForbidden),
+		errors.Is(err, filemgr.ErrWebDAVTargetForbidden),
+		errors.Is(err, filemgr.ErrWebDAVTarget):
+		return "target_forbidden"
+	case errors.Is(err, filemgr.ErrWebDAVLocked),
+		errors.Is(err, filemgr.ErrDirectoryIO),
+		errors.Is(err, filemgr.ErrNotDirectory),
+		errors.Is(err, directory.ErrPathComponentNotDirectory),
+		errors.Is(err, os.ErrNotExist):
+		return "target_conflict"
+	default:
+		return "internal"
+	}
+}
+
+func safeErrorMessage(code string) string {
+	switch code {
+	case "source_blocked":
+		return "source address is not allowed"
+	case "source_too_large":
+		return "source exceeds the configured size limit"
+	case "source_changed":
+		return "source changed or does not support resuming"
+	case "source_status":
+		return "source answered with an unexpected HTTP status"
+	case "source_unavailable":
+		return "source could not be fetched"
+	case "quota_exceeded":
+		return "file exceeds the quota of the owner"
+	case "insufficient_storage":
+		return "fetch work directory has insufficient free space"
+	case "target_conflict":
+		return "target path is locked, a directory or has no parent directory"
+	case "target_forbidden":
+		return "owner may not write the target path"
+	default:
+		return "fetch operation failed"
+	}
+}
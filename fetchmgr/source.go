@@ -0,0 +1,291 @@
+package fetchmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	errSourceStatus      = errors.New("source answered with an unexpected status")
+	errSourceChanged     = errors.New("source changed while it was fetched")
+	errSourceTooLarge    = errors.New("source exceeds the size limit")
+	errSourceUnavailable = errors.New("source is unavailable")
+	errBlockedAddress    = errors.New("source address is not allowed")
+	errTooManyRedirects  = errors.New("source redirected too often")
+	errRedirectScheme    = errors.New("source redirected to an unsupported scheme")
+)
+
+const (
+	maxRedirects          = 5
+	dialTimeout           = 10 * time.Second
+	responseHeaderTimeout = 30 * time.Second
+	stallTimeout          = time.Minute
+	maxRetryBackoff       = 30 * time.Second
+)
+
+// blockedPrefixes are the non-public ranges netip has no predicate for.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// newHTTPClient returns the client sources are fetched with. It ignores
+// proxy settings, so the address check sees the address that is dialed, and
+// follows a bounded number of redirects. Without allowPrivate it refuses to
+// connect to loopback, private, link-local and other non-public addresses,
+// including after a redirect.
+func newHTTPClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if !allowPrivate {
+		dialer.Control = rejectNonPublicAddress
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			TLSHandshakeTimeout:   dialTimeout,
+			ResponseHeaderTimeout: responseHeaderTimeout,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errTooManyRedirects
+			}
+			if request.URL.Scheme != "http" && request.URL.Scheme != "https" {
+				return errRedirectScheme
+			}
+			return nil
+		},
+	}
+}
+
+func rejectNonPublicAddress(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("split dial address: %w", err)
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("parse dial address: %w", err)
+	}
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return fmt.Errorf("%w: %s", errBlockedAddress, ip)
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(ip) {
+			return fmt.Errorf("%w: %s", errBlockedAddress, ip)
+		}
+	}
+	return nil
+}
+
+// source reads the body of a remote URL. When a read fails it requests the
+// rest of the body from the current offset with Range, and If-Range when the
+// first response carried a validator, up to retries times.
+type source struct {
+	ctx       context.Context
+	client    *http.Client
+	url       string
+	headers   map[string]string
+	retries   int
+	backoff   time.Duration
+	progress  *progress
+	length    int64
+	validator string
+	mediaType string
+	offset    int64
+	failures  int
+	body      io.ReadCloser
+	cancel    context.CancelFunc
+	stall     *time.Timer
+}
+
+// open sends the first request. The length of the body is -1 when the source
+// did not declare it.
+func (s *source) open() error {
+	response, err := s.request(0)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		s.closeBody()
+		return fmt.Errorf("%w: %d", errSourceStatus, response.StatusCode)
+	}
+	s.length = response.ContentLength
+	s.mediaType = response.Header.Get("Content-Type")
+	if etag := response.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		s.validator = etag
+	} else {
+		s.validator = response.Header.Get("Last-Modified")
+	}
+	return nil
+}
+
+func (s *source) Read(buffer []byte) (int, error) {
+	for {
+		if s.body == nil {
+			if err := s.resume(); err != nil {
+				return 0, err
+			}
+		}
+		count, err := s.body.Read(buffer)
+		if count > 0 {
+			s.offset += int64(count)
+			s.stall.Reset(stallTimeout)
+		}
+		if errors.Is(err, io.EOF) && (s.length < 0 || s.offset >= s.length) {
+			return count, io.EOF
+		}
+		if err != nil {
+			// Also a body that ended before its declared length.
+			s.closeBody()
+		}
+		if count > 0 || err == nil {
+			return count, nil
+		}
+	}
+}
+
+func (s *source) Close() error {
+	s.closeBody()
+	return nil
+}
+
+// resume requests the rest of the body after a failed read.
+func (s *source) resume() error {
+	s.failures++
+	if s.failures > s.retries {
+		return fmt.Errorf("%w: body interrupted at %d bytes", errSourceUnavailable, s.offset)
+	}
+	if err := s.wait(s.failures); err != nil {
+		return err
+	}
+	response, err := s.request(s.offset)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode == http.StatusOK && s.offset == 0 {
+		return nil
+	}
+	if response.StatusCode != http.StatusPartialContent || !s.continues(response.Header.Get("Content-Range")) {
+		s.closeBody()
+		return fmt.Errorf("%w: resumed with status %d", errSourceChanged, response.StatusCode)
+	}
+	return nil
+}
+
+// continues reports whether a Content-Range starts at the current offset
+// and, when the length is known, ends with the body.
+func (s *source) continues(value string) bool {
+	value, found := strings.CutPrefix(value, "bytes ")
+	if !found {
+		return false
+	}
+	span, total, found := strings.Cut(value, "/")
+	if !found {
+		return false
+	}
+	first, _, found := strings.Cut(span, "-")
+	start, err := strconv.ParseInt(first, 10, 64)
+	if !found || err != nil || start != s.offset {
+		return false
+	}
+	return s.length < 0 || total == strconv.FormatInt(s.length, 10)
+}
+
+// request sends a GET for the body from offset, retrying transport errors
+// and answers a retry could fix.
+func (s *source) request(offset int64) (*http.Response, error) {
+	for {
+		response, err := s.send(offset)
+		retryable := err != nil && !errors.Is(err, errBlockedAddress) &&
+			!errors.Is(err, errTooManyRedirects) && !errors.Is(err, errRedirectScheme) ||
+			err == nil && (response.StatusCode >= http.StatusInternalServerError ||
+				response.StatusCode == http.StatusTooManyRequests)
+		if !retryable {
+			return response, err
+		}
+		if err == nil {
+			s.closeBody()
+		}
+		if s.ctx.Err() != nil {
+			return nil, fmt.Errorf("fetch source: %w", s.ctx.Err())
+		}
+		s.failures++
+		if s.failures > s.retries {
+			if err == nil {
+				return nil, fmt.Errorf("%w: status %d", errSourceUnavailable, response.StatusCode)
+			}
+			return nil, fmt.Errorf("%w: %w", errSourceUnavailable, err)
+		}
+		if err := s.wait(s.failures); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *source) send(offset int64) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("build source request: %w", err)
+	}
+	for name, value := range s.headers {
+		request.Header.Set(name, value)
+	}
+	// Identity keeps Range offsets in bytes of the stored content.
+	request.Header.Set("Accept-Encoding", "identity")
+	if offset > 0 {
+		request.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		if s.validator != "" {
+			request.Header.Set("If-Range", s.validator)
+		}
+	}
+	s.progress.attempts.Add(1)
+	response, err := s.client.Do(request)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("fetch source: %w", err)
+	}
+	s.body, s.cancel = response.Body, cancel
+	s.stall = time.AfterFunc(stallTimeout, cancel)
+	return response, nil
+}
+
+func (s *source) closeBody() {
+	if s.body == nil {
+		return
+	}
+	s.stall.Stop()
+	s.cancel()
+	_ = s.body.Close()
+	s.body = nil
+}
+
+func (s *source) wait(failures int) error {
+	delay := s.backoff << min(failures-1, 16)
+	timer := time.NewTimer(min(delay, maxRetryBackoff))
+	defer timer.Stop()
+	select {
+	case <-s.ctx.Done():
+		return fmt.Errorf("wait to retry source: %w", s.ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
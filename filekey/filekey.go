@@ -0,0 +1,131 @@
+// Package filekey parses and issues the keys of direct uploads.
+//
+// A legacy key is hex(xxhash64(fileid))-<name> and maps to the link
+// /defaults/<2>/<key>. Anyone can compute it from a sequential file id, so
+// new uploads get a token key instead: 32 hex characters of a random 128-bit
+// token, then -<name>. A token key is only valid while the database records
+// it; its link is derived from the SHA-256 digest of the key, so neither the
+// namespace nor the database reveals the key itself.
+package filekey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+const (
+	Prefix = "/defaults/"
+
+	// MaxNameLength bounds the name part of a key in bytes.
+	MaxNameLength = 128
+
+	legacyHashLength = 16
+	tokenLength      = 32
+	tokenBytes       = tokenLength / 2
+)
+
+var (
+	// ErrInvalidKey is wrapped by every parse error.
+	ErrInvalidKey       = errors.New("invalid file key")
+	ErrInvalidLength    = fmt.Errorf("%w length", ErrInvalidKey)
+	ErrInvalidSeparator = fmt.Errorf("%w separator", ErrInvalidKey)
+	ErrInvalidHash      = fmt.Errorf("%w hash", ErrInvalidKey)
+	ErrInvalidSuffix    = fmt.Errorf("%w suffix", ErrInvalidKey)
+)
+
+type Kind string
+
+const (
+	KindLegacy Kind = "legacy"
+	KindToken  Kind = "token"
+)
+
+// Key is a syntactically valid key. Parsing does not tell whether a token
+// key was ever issued or has been revoked.
+type Key struct {
+	Raw  string
+	Kind Kind
+	Name string
+}
+
+// Parse validates raw as a legacy or a token key. The two are told apart by
+// the separator: a legacy key has it after 16 characters, a token key after
+// 32.
+func Parse(raw string) (*Key, error) {
+	prefixLength := tokenLength
+	kind := KindToken
+	if len(raw) > legacyHashLength && raw[legacyHashLength] == '-' {
+		prefixLength = legacyHashLength
+		kind = KindLegacy
+	}
+	if len(raw) < prefixLength+1 || len(raw) > prefixLength+1+MaxNameLength {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidLength, len(raw))
+	}
+	if raw[prefixLength] != '-' {
+		return nil, ErrInvalidSeparator
+	}
+	for i := 0; i < prefixLength; i++ {
+		value := raw[i]
+		if (value < '0' || value > '9') && (value < 'a' || value > 'f') {
+			return nil, fmt.Errorf("%w at offset %d", ErrInvalidHash, i)
+		}
+	}
+	if err := validateName(raw, prefixLength+1); err != nil {
+		return nil, err
+	}
+	return &Key{Raw: raw, Kind: kind, Name: raw[prefixLength+1:]}, nil
+}
+
+// New issues a token key for a file called name. The caller cleans the name;
+// New only rejects names a key cannot carry.
+func New(name string) (*Key, error) {
+	if err := CheckName(name); err != nil {
+		return nil, err
+	}
+	token := make([]byte, tokenBytes)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("generate file key token: %w", err)
+	}
+	return &Key{Raw: hex.EncodeToString(token) + "-" + name, Kind: KindToken, Name: name}, nil
+}
+
+// CheckName rejects names a token key cannot carry.
+func CheckName(name string) error {
+	if len(name) > MaxNameLength {
+		return fmt.Errorf("%w: %d", ErrInvalidLength, tokenLength+1+len(name))
+	}
+	return validateName(name, 0)
+}
+
+// Digest identifies the key in the database without storing it.
+func (k *Key) Digest() string {
+	sum := sha256.Sum256([]byte(k.Raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// Link is the namespace path the key serves.
+func (k *Key) Link() string {
+	if k.Kind == KindLegacy {
+		return Prefix + k.Raw[:2] + "/" + k.Raw
+	}
+	return TokenLink(k.Digest(), k.Name)
+}
+
+// TokenLink is the link of the token key with the given digest and name, for
+// callers that kept those but not the key. digest must be a Digest.
+func TokenLink(digest, name string) string {
+	return Prefix + digest[:2] + "/" + digest[:tokenLength] + "-" + name
+}
+
+func validateName(value string, start int) error {
+	for i := start; i < len(value); i++ {
+		character := value[i]
+		if character == '/' || character == '\\' || character < 0x20 || character == 0x7f {
+			return fmt.Errorf("%w at offset %d", ErrInvalidSuffix, i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package filekey
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTellsLegacyAndTokenKeysApart(t *testing.T) {
+	legacy, err := Parse("0123456789abcdef-report.txt")
+	require.NoError(t, err)
+	require.Equal(t, KindLegacy, legacy.Kind)
+	require.Equal(t, "report.txt", legacy.Name)
+	require.Equal(t, "/defaults/01/0123456789abcdef-report.txt", legacy.Link())
+
+	token, err := Parse("0123456789abcdef0123456789abcdef-报告.pdf")
+	require.NoError(t, err)
+	require.Equal(t, KindToken, token.Kind)
+	require.Equal(t, "报告.pdf", token.Name)
+	digest := token.Digest()
+	require.Len(t, digest, 64)
+	require.Equal(t, Prefix+digest[:2]+"/"+digest[:32]+"-报告.pdf", token.Link())
+
+	for value, want := range map[string]error{
+		"a-x":                                  ErrInvalidLength,
+		"0123456789abcdef0123456789abcdef_":    ErrInvalidSeparator,
+		"0123456789abcdef0123456789abcdeF-":    ErrInvalidHash,
+		"0123456789abcdef0123456789abcdef-a/b": ErrInvalidSuffix,
+		"0123456789abcdef-" + strings.Repeat("a", MaxNameLength+1): ErrInvalidLength,
+	} {
+		_, err := Parse(value)
+		require.ErrorIs(t, err, want, value)
+		require.ErrorIs(t, err, ErrInvalidKey, value)
+	}
+}
+
+func TestNewIssuesDistinctTokenKeys(t *testing.T) {
+	first, err := New("a.txt")
+	require.NoError(t, err)
+	second, err := New("a.txt")
+	require.NoError(t, err)
+	require.NotEqual(t, first.Raw, second.Raw)
+	require.NotEqual(t, first.Link(), second.Link())
+	parsed, err := Parse(first.Raw)
+	require.NoError(t, err)
+	require.Equal(t, *first, *parsed)
+
+	_, err = New("a\nb")
+	require.ErrorIs(t, err, ErrInvalidSuffix)
+	_, err = New(strings.Repeat("a", MaxNameLength+1))
+	require.ErrorIs(t, err, ErrInvalidLength)
+}
@@ -77,13 +77,15 @@ func toDirectoryPageCursor(cursor *FileLinkPageCursor) *directory.PageCursor {
 
 func fileLinkFromDirectoryEntry(entry directory.IDirectoryEntry) (*entity.FileLinkMeta, error) {
 	item := &entity.FileLinkMeta{
-		EntryID:  entry.EntryID(),
-		FileName: entry.Name(),
-		FileSize: entry.Size(),
-		Mode:     entry.Mode(),
-		Ctime:    entry.Ctime(),
-		Mtime:    entry.Mtime(),
-		IsDir:    entry.IsDir(),
+		EntryID:     entry.EntryID(),
+		FileName:    entry.Name(),
+		FileSize:    entry.Size(),
+		Mode:        entry.Mode(),
+		Ctime:       entry.Ctime(),
+		Mtime:       entry.Mtime(),
+		IsDir:       entry.IsDir(),
+		Owner:       entry.Owner(),
+		ContentType: entry.ContentType(),
 	}
 	if item.IsDir {
 		return item, nil
@@ -101,6 +101,7 @@ func newBackupManifest(
 		Mappings:         []backupfmt.Mapping{},
 		S3Objects:        []backupfmt.S3Object{},
 		WebDAVProperties: []backupfmt.WebDAVProperty{},
+		FileKeys:         []backupfmt.FileKey{},
 	}
 }
 
@@ -144,6 +145,11 @@ func (d *defaultFileManager) populateBackupSnapshotTx(
 		return err
 	}
 	manifest.WebDAVProperties = properties
+	keys, err := readBackupFileKeys(ctx, tx, selected)
+	if err != nil {
+		return err
+	}
+	manifest.FileKeys = keys
 	return nil
 }
 
@@ -332,6 +338,23 @@ file_size, file_mode, file_name FROM tg_file_mapping_tab ORDER BY entry_id`,
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("iterate backup mappings: %w", err)
 	}
+	trashIDs, err := queryColumnList[uint64](ctx, queryer, "SELECT trash_id FROM tg_trash_tab")
+	if err != nil {
+		return nil, fmt.Errorf("query trash anchors: %w", err)
+	}
+	return resolveBackupMappingPaths(result, trashIDs)
+}
+
+// resolveBackupMappingPaths fills in the full paths and drops the subtrees
+// parked in the recycle bin, which are not part of the namespace.
+func resolveBackupMappingPaths(
+	result map[uint64]*backupMappingRow,
+	trashIDs []uint64,
+) (map[uint64]*backupMappingRow, error) {
+	trashed := make(map[uint64]bool, len(trashIDs))
+	for _, trashID := range trashIDs {
+		trashed[trashID] = true
+	}
 	var resolve func(uint64, map[uint64]bool) (string, error)
 	resolve = func(entryID uint64, visiting map[uint64]bool) (string, error) {
 		row, exists := result[entryID]
@@ -353,17 +376,28 @@ file_size, file_mode, file_name FROM tg_file_mapping_tab ORDER BY entry_id`,
 			row.fullPath = "/"
 			return row.fullPath, nil
 		}
+		if trashed[row.parentID] {
+			return "", nil
+		}
 		parentPath, err := resolve(row.parentID, visiting)
-		if err != nil {
+		if err != nil || parentPath == "" {
 			return "", err
 		}
 		row.fullPath = path.Join(parentPath, row.name)
 		return row.fullPath, nil
 	}
+	detached := make([]uint64, 0)
 	for entryID := range result {
-		if _, err := resolve(entryID, make(map[uint64]bool)); err != nil {
+		fullPath, err := resolve(entryID, make(map[uint64]bool))
+		if err != nil {
 			return nil, err
 		}
+		if fullPath == "" {
+			detached = append(detached, entryID)
+		}
+	}
+	for _, entryID := range detached {
+		delete(result, entryID)
 	}
 	return result, nil
 }
@@ -795,6 +829,51 @@ FROM tg_webdav_property_tab WHERE entry_id = ? ORDER BY namespace_uri, local_nam
 	return result, nil
 }
 
+// readBackupFileKeys returns the key rows of the selected mappings in
+// digest order. Rows of links outside the selection stay behind, as the
+// archive could not restore what they point at.
+func readBackupFileKeys(
+	ctx context.Context,
+	queryer database.IQueryer,
+	selected []*backupMappingRow,
+) ([]backupfmt.FileKey, error) {
+	links := make(map[string]struct{}, len(selected))
+	for _, row := range selected {
+		links[row.fullPath] = struct{}{}
+	}
+	rows, err := queryer.QueryContext(
+		ctx,
+		`SELECT key_digest, key_kind, link_path, replaces_digest, owner, created_at, revoked_at
+FROM tg_file_key_tab ORDER BY key_digest`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query backup file keys: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	result := make([]backupfmt.FileKey, 0)
+	for rows.Next() {
+		var key backupfmt.FileKey
+		if err := rows.Scan(
+			&key.Digest,
+			&key.Kind,
+			&key.Link,
+			&key.ReplacesDigest,
+			&key.Owner,
+			&key.CreatedAt,
+			&key.RevokedAt,
+		); err != nil {
+			return nil, fmt.Errorf("scan backup file key: %w", err)
+		}
+		if _, exists := links[key.Link]; exists {
+			result = append(result, key)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate backup file keys: %w", err)
+	}
+	return result, nil
+}
+
 func sortBackupManifest(manifest *backupfmt.Manifest) {
 	sort.Slice(manifest.RequiredBuckets, func(i, j int) bool {
 		return manifest.RequiredBuckets[i].Name < manifest.RequiredBuckets[j].Name
@@ -870,17 +949,24 @@ func (d *defaultFileManager) OpenBackupPart(
 	if err != nil || partIndex < 0 {
 		return nil, fmt.Errorf("open backup part: %w", ErrBackupState)
 	}
-	var key string
+	var key, backendKind string
 	if err := queryRow(
 		ctx,
 		d.dbc,
-		`SELECT file_key FROM tg_file_part_tab WHERE file_id = ? AND file_part_id = ?`,
+		`SELECT part.file_key, COALESCE(file.backend_kind, '')
+FROM tg_file_part_tab part
+LEFT JOIN tg_file_tab file ON file.file_id = part.file_id
+WHERE part.file_id = ? AND part.file_part_id = ?`,
 		fileID,
 		partIndex,
-	).Scan(&key); err != nil {
+	).Scan(&key, &backendKind); err != nil {
 		return nil, fmt.Errorf("read backup part key: %w", err)
 	}
-	stream, err := d.bkio.Download(ctx, key, 0)
+	backend, err := d.backendFor(backendKind)
+	if err != nil {
+		return nil, fmt.Errorf("open backup part: %w", err)
+	}
+	stream, err := backend.Download(ctx, key, 0)
 	if err != nil {
 		return nil, fmt.Errorf("download backup part: %w", err)
 	}
@@ -1616,6 +1702,9 @@ func (p *backupImportPublisher) publish(ctx context.Context) error {
 	if err := p.publishWebDAVProperties(ctx); err != nil {
 		return err
 	}
+	if err := p.publishFileKeys(ctx); err != nil {
+		return err
+	}
 	return p.complete(ctx)
 }
 
@@ -1737,6 +1826,9 @@ func (p *backupImportPublisher) replaceMapping(
 	); err != nil {
 		return nil, fmt.Errorf("delete replaced WebDAV properties: %w", err)
 	}
+	if err := deleteWebDAVBindings(ctx, p.tx.QueryExecer(), []directory.IDirectoryEntry{current}); err != nil {
+		return nil, err
+	}
 	entry, err := p.tx.Replace(
 		ctx,
 		item.Path,
@@ -1785,6 +1877,49 @@ DO UPDATE SET value_xml = excluded.value_xml, ctime = excluded.ctime, mtime = ex
 	return nil
 }
 
+// publishFileKeys restores the key rows of the imported mappings, so the
+// keys issued before the export resolve again. A key the target already
+// knows takes the archived state. The owner of a key also owns its mapping.
+func (p *backupImportPublisher) publishFileKeys(ctx context.Context) error {
+	for _, key := range p.manifest.FileKeys {
+		entry, exists := p.entryByPath[key.Link]
+		if !exists {
+			return fmt.Errorf("file key target %s is missing: %w", key.Link, ErrBackupState)
+		}
+		if _, err := p.tx.QueryExecer().ExecContext(
+			ctx,
+			`INSERT INTO tg_file_key_tab (
+key_digest, key_kind, link_path, replaces_digest, owner, created_at, revoked_at
+) VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(key_digest) DO UPDATE SET key_kind = excluded.key_kind, link_path = excluded.link_path,
+replaces_digest = excluded.replaces_digest, owner = excluded.owner,
+created_at = excluded.created_at, revoked_at = excluded.revoked_at`,
+			key.Digest,
+			key.Kind,
+			key.Link,
+			key.ReplacesDigest,
+			key.Owner,
+			key.CreatedAt,
+			key.RevokedAt,
+		); err != nil {
+			return fmt.Errorf("insert imported file key: %w", err)
+		}
+		if key.Owner == "" {
+			continue
+		}
+		if _, err := p.tx.QueryExecer().ExecContext(
+			ctx,
+			"UPDATE tg_file_mapping_tab SET owner = ?, creator = ? WHERE entry_id = ?",
+			key.Owner,
+			key.Owner,
+			entry.EntryID(),
+		); err != nil {
+			return fmt.Errorf("restore file key owner: %w", err)
+		}
+	}
+	return nil
+}
+
 func (p *backupImportPublisher) complete(ctx context.Context) error {
 	now := time.Now().UnixMilli()
 	for _, fileID := range p.replacedIDs {
@@ -53,28 +53,39 @@ func (d *defaultFileManager) RunBlockDeleteWorker(ctx context.Context) error {
 }
 
 func (d *defaultFileManager) processBlockDeleteBatch(ctx context.Context) error {
+	var errs []error
+	for _, backend := range d.allBackends() {
+		if err := d.processBackendDeleteBatch(ctx, backend); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (d *defaultFileManager) processBackendDeleteBatch(ctx context.Context, backend blockio.IBlockIO) error {
 	now := time.Now()
-	claimed, err := d.claimBlockDeleteWork(ctx, now)
+	claimed, err := d.claimBlockDeleteWork(ctx, backend.Name(), now)
 	if err != nil {
 		return err
 	}
 	if len(claimed) == 0 {
 		return nil
 	}
-	return d.executeBlockDeleteWork(ctx, claimed, now)
+	return d.executeBlockDeleteWork(ctx, backend, claimed, now)
 }
 
 func (d *defaultFileManager) claimBlockDeleteWork(
 	ctx context.Context,
+	backendKind string,
 	now time.Time,
 ) ([]blockDeleteWork, error) {
 	claimed := make([]blockDeleteWork, 0, deleteBatchSize)
 	err := d.dbc.OnTransation(ctx, func(ctx context.Context, tx database.IQueryExecer) error {
 		nowMillis := now.UnixMilli()
-		if err := restoreExpiredDeleteLeases(ctx, tx, d.bkio.Name(), nowMillis); err != nil {
+		if err := restoreExpiredDeleteLeases(ctx, tx, backendKind, nowMillis); err != nil {
 			return err
 		}
-		candidates, err := queryPendingDeleteCandidates(ctx, tx, d.bkio.Name(), nowMillis)
+		candidates, err := queryPendingDeleteCandidates(ctx, tx, backendKind, nowMillis)
 		if err != nil {
 			return err
 		}
@@ -245,6 +256,7 @@ WHERE file_id = ? AND file_part_id = ? AND delete_state IN ('pending', 'deleting
 
 func (d *defaultFileManager) executeBlockDeleteWork(
 	ctx context.Context,
+	backend blockio.IBlockIO,
 	works []blockDeleteWork,
 	now time.Time,
 ) error {
@@ -253,7 +265,7 @@ func (d *defaultFileManager) executeBlockDeleteWork(
 		deleteRefs = append(deleteRefs, work.deleteRef)
 	}
 	deleteContext, cancel := context.WithTimeout(ctx, deleteTimeout)
-	err := d.bkio.DeleteBlocks(deleteContext, deleteRefs)
+	err := backend.DeleteBlocks(deleteContext, deleteRefs)
 	cancel()
 	if err == nil {
 		return d.finishBlockDeleteWork(ctx, works, "deleted", "", 0, now)
@@ -261,7 +273,7 @@ func (d *defaultFileManager) executeBlockDeleteWork(
 	code, retry, delay := classifyBlockDeleteError(err, works[0].attemptCount)
 	if !retry && len(works) > 1 {
 		for _, work := range works {
-			if err := d.executeBlockDeleteWork(ctx, []blockDeleteWork{work}, now); err != nil {
+			if err := d.executeBlockDeleteWork(ctx, backend, []blockDeleteWork{work}, now); err != nil {
 				return err
 			}
 		}
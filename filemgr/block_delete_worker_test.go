@@ -232,7 +232,7 @@ func TestDeleteWorkerAppliesRetryDeadlinePerPart(t *testing.T) {
 		now.Add(deleteLease).UnixMilli(),
 	)
 
-	err := manager.executeBlockDeleteWork(t.Context(), []blockDeleteWork{
+	err := manager.executeBlockDeleteWork(t.Context(), block, []blockDeleteWork{
 		{
 			fileID:       4,
 			partID:       0,
@@ -21,6 +21,7 @@ type compositeSegment struct {
 	sourceFileID uint64
 	size         int64
 	start        int64
+	backendKind  string
 }
 
 type compositeFileStream struct {
@@ -60,7 +61,7 @@ func (d *defaultFileManager) loadCompositeManifest(
 	fileSize int64,
 ) ([]compositeSegment, error) {
 	const query = `SELECT segment_index, source_file_id, segment_size,
-f.file_size, f.file_state, f.file_layout_version
+f.file_size, f.file_state, f.file_layout_version, COALESCE(f.backend_kind, '')
 FROM tg_s3_file_segment_tab s
 LEFT JOIN tg_file_tab f ON f.file_id = s.source_file_id
 WHERE s.file_id = ?
@@ -83,6 +84,7 @@ ORDER BY segment_index`
 			sourceSize   sql.NullInt64
 			sourceState  sql.NullInt64
 			sourceLayout sql.NullInt64
+			backendKind  string
 		)
 		if err := rows.Scan(
 			&index,
@@ -91,6 +93,7 @@ ORDER BY segment_index`
 			&sourceSize,
 			&sourceState,
 			&sourceLayout,
+			&backendKind,
 		); err != nil {
 			return nil, fmt.Errorf("scan composite segment: %w", err)
 		}
@@ -112,6 +115,7 @@ ORDER BY segment_index`
 			sourceFileID: sourceFileID,
 			size:         segmentSize,
 			start:        total,
+			backendKind:  backendKind,
 		})
 		total += segmentSize
 	}
@@ -212,7 +216,11 @@ func (f *compositeFileStream) readSegment(index int, buffer []byte) (int, error)
 
 func (f *compositeFileStream) advancePhysicalBoundary(segment compositeSegment) error {
 	sourceOffset := f.offset - segment.start
-	blockSize := f.manager.bkio.MaxFileSize()
+	backend, err := f.manager.backendFor(segment.backendKind)
+	if err != nil {
+		return err
+	}
+	blockSize := backend.MaxFileSize()
 	if blockSize <= 0 || sourceOffset <= 0 || sourceOffset%blockSize != 0 {
 		return fmt.Errorf(
 			"%w: source file %d ended at %d of %d",
@@ -246,8 +254,12 @@ func (f *compositeFileStream) openSegment(index int, segment compositeSegment) e
 	if err := f.closeCurrent(); err != nil {
 		return err
 	}
+	backend, err := f.manager.backendFor(segment.backendKind)
+	if err != nil {
+		return fmt.Errorf("open composite source %d: %w", segment.sourceFileID, err)
+	}
 	reader, err := f.manager.lowlevelIOStream(
-		f.manager.bkio,
+		backend,
 		segment.sourceFileID,
 		segment.size,
 	)(f.ctx)
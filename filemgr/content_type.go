@@ -0,0 +1,308 @@
+package filemgr
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/contenttype"
+	"github.com/xxxsen/tgfile/directory"
+)
+
+// sniffedTypeQuery returns the sniffed type of a file. A completed multipart
+// object has no content of its own, so it takes the type of its first
+// non-empty segment.
+const sniffedTypeQuery = `SELECT CASE WHEN file.sniffed_type != '' THEN file.sniffed_type ELSE COALESCE((
+    SELECT source.sniffed_type
+    FROM tg_s3_file_segment_tab segment
+    JOIN tg_file_tab source ON source.file_id = segment.source_file_id
+    WHERE segment.file_id = file.file_id AND segment.segment_size > 0
+    ORDER BY segment.segment_index
+    LIMIT 1
+), '') END
+FROM tg_file_tab file WHERE file.file_id = ?`
+
+// IContentTypeBackfiller assigns content types to mappings created before
+// they were stored.
+type IContentTypeBackfiller interface {
+	BackfillContentTypes(ctx context.Context, sniff bool) (*ContentTypeBackfillResult, error)
+}
+
+// ContentTypeBackfillResult counts the work of a content type backfill.
+type ContentTypeBackfillResult struct {
+	// Sniffed is how many files had their leading bytes read.
+	Sniffed int64 `json:"sniffed"`
+	// Assigned is how many mappings got a content type.
+	Assigned int64 `json:"assigned"`
+}
+
+// headBuffer keeps the leading bytes written to it for sniffing.
+type headBuffer struct {
+	head []byte
+}
+
+func (b *headBuffer) Write(data []byte) (int, error) {
+	if missing := contenttype.SniffLength - len(b.head); missing > 0 {
+		b.head = append(b.head, data[:min(len(data), missing)]...)
+	}
+	return len(data), nil
+}
+
+// recordSniffedType stores the type the leading bytes of a file sniffed as.
+func (d *defaultFileManager) recordSniffedType(ctx context.Context, fileID uint64, head []byte) error {
+	sniffed := contenttype.Sniff(head)
+	if sniffed == "" {
+		return nil
+	}
+	if _, err := d.dbc.ExecContext(
+		ctx,
+		"UPDATE tg_file_tab SET sniffed_type = ? WHERE file_id = ?",
+		sniffed,
+		fileID,
+	); err != nil {
+		return fmt.Errorf("store sniffed type of file %d: %w", fileID, err)
+	}
+	return nil
+}
+
+// contentTypeDirectory stores a content type on every file entry created or
+// rewritten in its transactions, resolved from the sniffed type of the file
+// and the name of the entry. A content type already in the context wins.
+type contentTypeDirectory struct {
+	directory.ITransactionalDirectory
+}
+
+func (c *contentTypeDirectory) WithTransaction(ctx context.Context, callback directory.TransactionFunc) error {
+	if err := c.ITransactionalDirectory.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
+		return callback(ctx, contentTypeTransaction{ITransaction: tx})
+	}); err != nil {
+		return fmt.Errorf("run content typed transaction: %w", err)
+	}
+	return nil
+}
+
+type contentTypeTransaction struct {
+	directory.ITransaction
+}
+
+func (t contentTypeTransaction) Create(
+	ctx context.Context,
+	filename string,
+	size int64,
+	refdata string,
+) (directory.IDirectoryEntry, error) {
+	ctx, err := t.withContentType(ctx, filename, refdata)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := t.ITransaction.Create(ctx, filename, size, refdata)
+	if err != nil {
+		return nil, fmt.Errorf("create content typed entry: %w", err)
+	}
+	return entry, nil
+}
+
+func (t contentTypeTransaction) Replace(
+	ctx context.Context,
+	filename string,
+	size int64,
+	refdata string,
+	mtime int64,
+) (directory.IDirectoryEntry, error) {
+	ctx, err := t.withContentType(ctx, filename, refdata)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := t.ITransaction.Replace(ctx, filename, size, refdata, mtime)
+	if err != nil {
+		return nil, fmt.Errorf("replace content typed entry: %w", err)
+	}
+	return previous, nil
+}
+
+func (t contentTypeTransaction) withContentType(
+	ctx context.Context,
+	filename, refdata string,
+) (context.Context, error) {
+	if directory.ContentTypeFromContext(ctx) != "" {
+		return ctx, nil
+	}
+	// An entry without a file has nothing to sniff.
+	var sniffed string
+	if fileID, err := strconv.ParseUint(refdata, 10, 64); err == nil {
+		sniffed, err = querySniffedType(ctx, t.QueryExecer(), fileID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return directory.ContextWithContentType(ctx, contenttype.Resolve(path.Base(filename), sniffed)), nil
+}
+
+func querySniffedType(ctx context.Context, queryer database.IQueryer, fileID uint64) (string, error) {
+	var sniffed string
+	err := queryRow(ctx, queryer, sniffedTypeQuery, fileID).Scan(&sniffed)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("read sniffed type of file %d: %w", fileID, err)
+	}
+	return sniffed, nil
+}
+
+type untypedMapping struct {
+	entryID  uint64
+	name     string
+	fileID   uint64
+	explicit string
+}
+
+// BackfillContentTypes stores a content type on every file mapping without
+// one. S3 objects take the Content-Type of their metadata. Other mappings
+// combine their extension with the sniffed type of their file; with sniff,
+// files written before sniffing existed have their leading bytes read once.
+func (d *defaultFileManager) BackfillContentTypes(
+	ctx context.Context,
+	sniff bool,
+) (*ContentTypeBackfillResult, error) {
+	const batch = 500
+	result := &ContentTypeBackfillResult{}
+	sniffed := make(map[uint64]string)
+	var after uint64
+	for {
+		mappings, err := queryUntypedMappings(ctx, d.dbc, after, batch)
+		if err != nil {
+			return nil, err
+		}
+		if len(mappings) == 0 {
+			return result, nil
+		}
+		for _, mapping := range mappings {
+			after = mapping.entryID
+			contentType := mapping.explicit
+			if contentType == "" {
+				fileType, err := d.backfillSniffedType(ctx, mapping.fileID, sniff, sniffed, result)
+				if err != nil {
+					return nil, err
+				}
+				contentType = contenttype.Resolve(mapping.name, fileType)
+			}
+			assigned, err := d.assignContentType(ctx, mapping.entryID, contentType)
+			if err != nil {
+				return nil, err
+			}
+			result.Assigned += assigned
+		}
+	}
+}
+
+func queryUntypedMappings(
+	ctx context.Context,
+	queryer database.IQueryer,
+	after uint64,
+	limit int,
+) ([]untypedMapping, error) {
+	rows, err := queryer.QueryContext(
+		ctx,
+		`SELECT mapping.entry_id, mapping.file_name, mapping.ref_data, COALESCE(metadata.content_type, '')
+FROM tg_file_mapping_tab mapping
+LEFT JOIN tg_s3_object_metadata_tab metadata ON metadata.entry_id = mapping.entry_id
+WHERE mapping.file_kind = 2 AND mapping.content_type = '' AND mapping.entry_id > ?
+ORDER BY mapping.entry_id LIMIT ?`,
+		after,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query untyped mappings: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var result []untypedMapping
+	for rows.Next() {
+		var (
+			mapping untypedMapping
+			refdata string
+		)
+		if err := rows.Scan(&mapping.entryID, &mapping.name, &refdata, &mapping.explicit); err != nil {
+			return nil, fmt.Errorf("scan untyped mapping: %w", err)
+		}
+		mapping.fileID, _ = strconv.ParseUint(refdata, 10, 64)
+		result = append(result, mapping)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate untyped mappings: %w", err)
+	}
+	return result, nil
+}
+
+// backfillSniffedType returns the sniffed type of a file, reading its leading
+// bytes when sniff is set and none was stored. Results are memoized in known
+// so a file linked many times is read once.
+func (d *defaultFileManager) backfillSniffedType(
+	ctx context.Context,
+	fileID uint64,
+	sniff bool,
+	known map[uint64]string,
+	result *ContentTypeBackfillResult,
+) (string, error) {
+	if fileID == 0 {
+		return "", nil
+	}
+	if value, ok := known[fileID]; ok {
+		return value, nil
+	}
+	value, err := querySniffedType(ctx, d.dbc, fileID)
+	if err != nil {
+		return "", err
+	}
+	if value == "" && sniff {
+		head, err := d.readHead(ctx, fileID)
+		if err != nil {
+			return "", err
+		}
+		result.Sniffed++
+		if err := d.recordSniffedType(ctx, fileID, head); err != nil {
+			return "", err
+		}
+		value = contenttype.Sniff(head)
+	}
+	known[fileID] = value
+	return value, nil
+}
+
+func (d *defaultFileManager) readHead(ctx context.Context, fileID uint64) ([]byte, error) {
+	file, err := d.OpenFile(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("open file %d for sniffing: %w", fileID, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	head := make([]byte, contenttype.SniffLength)
+	read, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("read head of file %d: %w", fileID, err)
+	}
+	return head[:read], nil
+}
+
+func (d *defaultFileManager) assignContentType(ctx context.Context, entryID uint64, contentType string) (int64, error) {
+	result, err := d.dbc.ExecContext(
+		ctx,
+		"UPDATE tg_file_mapping_tab SET content_type = ? WHERE entry_id = ? AND content_type = ''",
+		contentType,
+		entryID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("assign content type of mapping %d: %w", entryID, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("count assigned content types: %w", err)
+	}
+	return affected, nil
+}
@@ -0,0 +1,99 @@
+package filemgr
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/entity"
+)
+
+var pngHead = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestMappingsStoreSniffedContentTypes(t *testing.T) {
+	manager, _, _ := newCreateFileTestManager(t, 8)
+	ctx := t.Context()
+	fileID, err := manager.CreateFile(ctx, int64(len(pngHead)), bytes.NewReader(pngHead))
+	require.NoError(t, err)
+	require.NoError(t, manager.CreateFileLink(ctx, "/images/photo.jpg", fileID, int64(len(pngHead)), false))
+	require.NoError(t, manager.CreateFileLink(ctx, "/images/upload", fileID, int64(len(pngHead)), false))
+	html := []byte("<html><script>alert(1)</script></html>")
+	htmlID, err := manager.CreateFile(ctx, int64(len(html)), bytes.NewReader(html))
+	require.NoError(t, err)
+	require.NoError(t, manager.CreateFileLink(ctx, "/notes/page", htmlID, int64(len(html)), false))
+
+	for link, want := range map[string]string{
+		"/images/photo.jpg": "image/png",
+		"/images/upload":    "image/png",
+		"/notes/page":       "text/plain; charset=utf-8",
+	} {
+		info, err := manager.StatFileLink(ctx, link)
+		require.NoError(t, err)
+		require.Equal(t, want, info.ContentType, link)
+	}
+
+	// Copies and renames keep the stored type.
+	require.NoError(t, manager.CopyFileLink(ctx, "/notes/page", "/notes/copy.html", false))
+	require.NoError(t, manager.RenameFileLink(ctx, "/images/upload", "/images/renamed.txt", false))
+	copied, err := manager.StatFileLink(ctx, "/notes/copy.html")
+	require.NoError(t, err)
+	require.Equal(t, "text/plain; charset=utf-8", copied.ContentType)
+	renamed, err := manager.StatFileLink(ctx, "/images/renamed.txt")
+	require.NoError(t, err)
+	require.Equal(t, "image/png", renamed.ContentType)
+
+	// S3 objects without a Content-Type get the stored type; an explicit one
+	// replaces it for every protocol.
+	object, err := manager.PublishS3Object(ctx, "/bucket/a.bin", fileID, int64(len(pngHead)),
+		&entity.S3ObjectMetadata{UserMetadata: "{}"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "image/png", object.Metadata.ContentType)
+	object, err = manager.PublishS3Object(ctx, "/bucket/b.bin", htmlID, int64(len(html)),
+		&entity.S3ObjectMetadata{ContentType: "text/html", UserMetadata: "{}"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "text/html", object.Metadata.ContentType)
+	info, err := manager.StatFileLink(ctx, "/bucket/b.bin")
+	require.NoError(t, err)
+	require.Equal(t, "text/html", info.ContentType)
+}
+
+func TestBackfillContentTypesSniffsEachFileOnce(t *testing.T) {
+	manager, _, databaseClient := newCreateFileTestManager(t, 8)
+	ctx := t.Context()
+	fileID, err := manager.CreateFile(ctx, int64(len(pngHead)), bytes.NewReader(pngHead))
+	require.NoError(t, err)
+	require.NoError(t, manager.CreateFileLink(ctx, "/old/one", fileID, int64(len(pngHead)), false))
+	require.NoError(t, manager.CreateFileLink(ctx, "/old/two.txt", fileID, int64(len(pngHead)), false))
+	reset := func() {
+		_, err := databaseClient.ExecContext(ctx, "UPDATE tg_file_tab SET sniffed_type = ''")
+		require.NoError(t, err)
+		_, err = databaseClient.ExecContext(ctx, "UPDATE tg_file_mapping_tab SET content_type = ''")
+		require.NoError(t, err)
+	}
+
+	reset()
+	result, err := manager.BackfillContentTypes(ctx, false)
+	require.NoError(t, err)
+	require.Equal(t, &ContentTypeBackfillResult{Assigned: 2}, result)
+	info, err := manager.StatFileLink(ctx, "/old/one")
+	require.NoError(t, err)
+	require.Equal(t, "application/octet-stream", info.ContentType)
+
+	reset()
+	result, err = manager.BackfillContentTypes(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, &ContentTypeBackfillResult{Sniffed: 1, Assigned: 2}, result)
+	for _, link := range []string{"/old/one", "/old/two.txt"} {
+		info, err := manager.StatFileLink(ctx, link)
+		require.NoError(t, err)
+		require.Equal(t, "image/png", info.ContentType, link)
+	}
+	require.Equal(t, 1, queryCount(t, databaseClient,
+		"SELECT COUNT(*) FROM tg_file_tab WHERE sniffed_type = 'image/png'"))
+
+	// Mappings that have a type are left alone.
+	result, err = manager.BackfillContentTypes(ctx, true)
+	require.NoError(t, err)
+	require.Equal(t, &ContentTypeBackfillResult{}, result)
+}
@@ -79,7 +79,11 @@ func (b *captureBlockIO) Download(_ context.Context, key string, position int64)
 	return io.NopCloser(bytes.NewReader(raw[position:])), nil
 }
 
-func newCreateFileTestManager(t *testing.T, blockSize int64) (IFileManager, *captureBlockIO, database.IDatabase) {
+func newCreateFileTestManager(
+	t *testing.T,
+	blockSize int64,
+	opts ...Option,
+) (IFileManager, *captureBlockIO, database.IDatabase) {
 	t.Helper()
 	databaseClient, err := db.Open(filepath.Join(t.TempDir(), "data.db"))
 	require.NoError(t, err)
@@ -96,7 +100,7 @@ func newCreateFileTestManager(t *testing.T, blockSize int64) (IFileManager, *cap
 		maxSize: blockSize,
 		parts:   make(map[string][]byte),
 	}
-	return NewFileManager(databaseClient, block, cache), block, databaseClient
+	return NewFileManager(databaseClient, block, cache, opts...), block, databaseClient
 }
 
 func queryCount(t *testing.T, databaseClient database.IDatabase, query string) int {
@@ -0,0 +1,348 @@
+package filemgr
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xxxsen/tgfile/directory"
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filekey"
+)
+
+// FileKeyMigrateFunc receives every legacy key MigrateLegacyFileKeys
+// replaced, with the token key issued for the same file.
+type FileKeyMigrateFunc func(ctx context.Context, legacyKey, key string) error
+
+// IFileKeyManager issues, resolves, revokes and deletes the keys of direct
+// uploads. Token keys are recorded by digest in tg_file_key_tab, together
+// with the principal that uploaded the file, and only resolve while their
+// row is not revoked. Legacy keys resolve by computation unless they are
+// switched off or individually revoked.
+type IFileKeyManager interface {
+	CreateFileKey(ctx context.Context, owner, name string, fileID uint64, size int64) (string, error)
+	ResolveFileKey(ctx context.Context, key string) (string, error)
+	RevokeFileKey(ctx context.Context, key string) error
+	DeleteFileKey(ctx context.Context, key, owner string) error
+	MigrateLegacyFileKeys(ctx context.Context, fn FileKeyMigrateFunc) (int, error)
+}
+
+// IFileKeyIssuer links a file at a token key issued before the file existed,
+// e.g. by a job that answers with the key before it has the content. Only
+// the digest and name of the key are passed, as the caller should not keep
+// the key itself.
+type IFileKeyIssuer interface {
+	PublishIssuedFileKey(ctx context.Context, owner, digest, name string, fileID uint64, size int64) error
+}
+
+// WithoutLegacyFileKeys stops resolving legacy keys. Links created for them
+// stay in place, and MigrateLegacyFileKeys can still issue token keys for
+// them.
+func WithoutLegacyFileKeys() Option {
+	return func(d *defaultFileManager) {
+		d.rejectLegacyKeys = true
+	}
+}
+
+// CreateFileKey issues a token key for fileID and links the file at the path
+// of the key in the same transaction. owner is recorded as the principal
+// that uploaded the file and owns its mapping.
+func (d *defaultFileManager) CreateFileKey(
+	ctx context.Context,
+	owner, name string,
+	fileID uint64,
+	size int64,
+) (string, error) {
+	key, err := filekey.New(name)
+	if err != nil {
+		return "", fmt.Errorf("issue file key: %w", err)
+	}
+	if owner != "" {
+		ctx = ContextWithOwner(ctx, owner)
+	}
+	if err := d.publishFileKey(ctx, key.Digest(), key.Link(), fileID, size, fileKeyOrigin{owner: owner}); err != nil {
+		return "", err
+	}
+	return key.Raw, nil
+}
+
+// PublishIssuedFileKey links fileID at the token key with the given digest,
+// which filekey.New issued for name. owner is recorded as for CreateFileKey.
+func (d *defaultFileManager) PublishIssuedFileKey(
+	ctx context.Context,
+	owner, digest, name string,
+	fileID uint64,
+	size int64,
+) error {
+	if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != sha256.Size ||
+		strings.ToLower(digest) != digest {
+		return fmt.Errorf("%w digest", filekey.ErrInvalidKey)
+	}
+	if err := filekey.CheckName(name); err != nil {
+		return fmt.Errorf("check file key name: %w", err)
+	}
+	if owner != "" {
+		ctx = ContextWithOwner(ctx, owner)
+	}
+	return d.publishFileKey(ctx, digest, filekey.TokenLink(digest, name), fileID, size, fileKeyOrigin{owner: owner})
+}
+
+// fileKeyOrigin says who a token key is issued to and which legacy key it
+// replaces, if any.
+type fileKeyOrigin struct {
+	owner    string
+	replaces string
+}
+
+func (d *defaultFileManager) publishFileKey(
+	ctx context.Context,
+	digest, link string,
+	fileID uint64,
+	size int64,
+	origin fileKeyOrigin,
+) error {
+	if err := d.objectDir.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
+		if err := ensureFileTreeCanBeLinked(ctx, tx.QueryExecer(), fileID); err != nil {
+			return err
+		}
+		if _, err := tx.Create(ctx, link, size, strconv.FormatUint(fileID, 10)); err != nil {
+			return fmt.Errorf("create mapping entry: %w", err)
+		}
+		if _, err := tx.QueryExecer().ExecContext(
+			ctx,
+			`INSERT INTO tg_file_key_tab (key_digest, key_kind, link_path, replaces_digest, owner, created_at)
+VALUES (?, ?, ?, ?, ?, ?)`,
+			digest,
+			string(filekey.KindToken),
+			link,
+			origin.replaces,
+			origin.owner,
+			time.Now().UnixMilli(),
+		); err != nil {
+			return fmt.Errorf("insert file key: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("publish file key: %w", err)
+	}
+	return nil
+}
+
+// ResolveFileKey returns the link a key serves. Keys that were never issued,
+// are revoked or are legacy keys while those are switched off report
+// os.ErrNotExist, the same as a key whose file is gone.
+func (d *defaultFileManager) ResolveFileKey(ctx context.Context, raw string) (string, error) {
+	key, err := filekey.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("parse file key: %w", err)
+	}
+	if key.Kind == filekey.KindLegacy && d.rejectLegacyKeys {
+		return "", fmt.Errorf("legacy file keys are disabled: %w", os.ErrNotExist)
+	}
+	var revokedAt int64
+	err = queryRow(
+		ctx,
+		d.dbc,
+		`SELECT revoked_at FROM tg_file_key_tab WHERE key_digest = ?`,
+		key.Digest(),
+	).Scan(&revokedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows) && key.Kind == filekey.KindLegacy:
+		return key.Link(), nil
+	case errors.Is(err, sql.ErrNoRows):
+		return "", fmt.Errorf("file key is not issued: %w", os.ErrNotExist)
+	case err != nil:
+		return "", fmt.Errorf("read file key: %w", err)
+	case revokedAt != 0:
+		return "", fmt.Errorf("file key is revoked: %w", os.ErrNotExist)
+	}
+	return key.Link(), nil
+}
+
+// RevokeFileKey stops a key from resolving. The linked file stays in place.
+// Revoking a key twice succeeds; a key that does not resolve to a link
+// reports os.ErrNotExist.
+func (d *defaultFileManager) RevokeFileKey(ctx context.Context, raw string) error {
+	key, err := filekey.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("parse file key: %w", err)
+	}
+	now := time.Now().UnixMilli()
+	if key.Kind == filekey.KindLegacy {
+		if _, err := d.StatFileLink(ctx, key.Link()); err != nil {
+			return err
+		}
+		if _, err := d.dbc.ExecContext(
+			ctx,
+			`INSERT INTO tg_file_key_tab (key_digest, key_kind, link_path, created_at, revoked_at)
+VALUES (?, ?, ?, ?, ?) ON CONFLICT (key_digest) DO NOTHING`,
+			key.Digest(),
+			string(filekey.KindLegacy),
+			key.Link(),
+			now,
+			now,
+		); err != nil {
+			return fmt.Errorf("revoke legacy file key: %w", err)
+		}
+		return nil
+	}
+	result, err := d.dbc.ExecContext(
+		ctx,
+		`UPDATE tg_file_key_tab SET revoked_at = CASE WHEN revoked_at = 0 THEN ? ELSE revoked_at END
+WHERE key_digest = ?`,
+		now,
+		key.Digest(),
+	)
+	if err != nil {
+		return fmt.Errorf("revoke file key: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("read revoked file key count: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("file key is not issued: %w", os.ErrNotExist)
+	}
+	return nil
+}
+
+// DeleteFileKey removes the link a key serves, through the recycle bin when
+// it is enabled. The key row is kept, so the key resolves again if the link
+// is restored. A non-empty owner limits the deletion to keys issued to that
+// principal and reports ErrFileKeyNotOwned for others; keys without a
+// recorded owner can only be deleted without one. Revoked keys can still be
+// deleted, which is how an owner removes an upload after revoking its key.
+func (d *defaultFileManager) DeleteFileKey(ctx context.Context, raw, owner string) error {
+	key, err := filekey.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("parse file key: %w", err)
+	}
+	if key.Kind == filekey.KindLegacy && d.rejectLegacyKeys {
+		return fmt.Errorf("legacy file keys are disabled: %w", os.ErrNotExist)
+	}
+	if err := d.objectDir.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
+		var recorded string
+		err := queryRow(
+			ctx,
+			tx.QueryExecer(),
+			`SELECT owner FROM tg_file_key_tab WHERE key_digest = ?`,
+			key.Digest(),
+		).Scan(&recorded)
+		switch {
+		case errors.Is(err, sql.ErrNoRows) && key.Kind == filekey.KindToken:
+			return fmt.Errorf("file key is not issued: %w", os.ErrNotExist)
+		case err != nil && !errors.Is(err, sql.ErrNoRows):
+			return fmt.Errorf("read file key: %w", err)
+		case owner != "" && recorded != owner:
+			return ErrFileKeyNotOwned
+		}
+		return d.removeFileKeyLinkTx(ctx, tx, key.Link())
+	}); err != nil {
+		return fmt.Errorf("delete file key: %w", err)
+	}
+	return nil
+}
+
+// removeFileKeyLinkTx removes the file linked at a direct-upload link,
+// through the recycle bin when it is enabled.
+func (d *defaultFileManager) removeFileKeyLinkTx(ctx context.Context, tx directory.ITransaction, link string) error {
+	entry, exists, err := tx.Stat(ctx, link)
+	switch {
+	case err != nil:
+		return fmt.Errorf("stat file key link: %w", err)
+	case !exists:
+		return fmt.Errorf("file key link is gone: %w", os.ErrNotExist)
+	case entry.IsDir():
+		return ErrDirectoryIO
+	}
+	return d.removeOrTrashTx(ctx, tx, link, TrashOrigin{Protocol: TrashProtocolFile})
+}
+
+// MigrateLegacyFileKeys issues a token key for every legacy link below
+// /defaults that has neither been migrated nor revoked. The legacy link is
+// kept, so old keys work until legacy keys are switched off. Token keys are
+// only stored by digest, so fn is the only place the new keys are seen.
+func (d *defaultFileManager) MigrateLegacyFileKeys(ctx context.Context, fn FileKeyMigrateFunc) (int, error) {
+	directories := make([]string, 0)
+	if err := d.WalkFileLink(ctx, strings.TrimSuffix(filekey.Prefix, "/"), func(
+		_ context.Context,
+		link string,
+		item *entity.FileLinkMeta,
+	) (bool, error) {
+		if item.IsDir {
+			directories = append(directories, link)
+		}
+		return true, nil
+	}); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("list direct upload directories: %w", err)
+	}
+	migrated := 0
+	for _, dir := range directories {
+		count, err := d.migrateLegacyFileKeyDirectory(ctx, dir, fn)
+		migrated += count
+		if err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
+func (d *defaultFileManager) migrateLegacyFileKeyDirectory(
+	ctx context.Context,
+	dir string,
+	fn FileKeyMigrateFunc,
+) (int, error) {
+	legacy := make([]*entity.FileLinkMeta, 0)
+	if err := d.WalkFileLink(ctx, dir, func(_ context.Context, link string, item *entity.FileLinkMeta) (bool, error) {
+		key, err := filekey.Parse(item.FileName)
+		if err == nil && !item.IsDir && key.Kind == filekey.KindLegacy && key.Link() == link {
+			legacy = append(legacy, item)
+		}
+		return true, nil
+	}); err != nil {
+		return 0, fmt.Errorf("list direct uploads in %q: %w", dir, err)
+	}
+	migrated := 0
+	for _, item := range legacy {
+		legacyKey, err := filekey.Parse(item.FileName)
+		if err != nil {
+			return migrated, fmt.Errorf("parse legacy file key: %w", err)
+		}
+		var handled bool
+		if err := queryRow(
+			ctx,
+			d.dbc,
+			`SELECT EXISTS (SELECT 1 FROM tg_file_key_tab WHERE key_digest = ? OR replaces_digest = ?)`,
+			legacyKey.Digest(),
+			legacyKey.Digest(),
+		).Scan(&handled); err != nil {
+			return migrated, fmt.Errorf("read migrated file key: %w", err)
+		}
+		if handled {
+			continue
+		}
+		key, err := filekey.New(legacyKey.Name)
+		if err != nil {
+			return migrated, fmt.Errorf("issue file key: %w", err)
+		}
+		origin := fileKeyOrigin{replaces: legacyKey.Digest()}
+		if err := d.publishFileKey(ctx, key.Digest(), key.Link(), item.FileId, item.FileSize, origin); err != nil {
+			return migrated, err
+		}
+		migrated++
+		if err := fn(ctx, legacyKey.Raw, key.Raw); err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
@@ -0,0 +1,287 @@
+package filemgr
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/xxxsen/tgfile/directory"
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filekey"
+)
+
+// fileKeyScanBudget bounds the entries one page of ListFileKeysPage reads
+// while it skips the uploads of other principals. A page that runs out of
+// budget is returned short, with a cursor to carry on from.
+const fileKeyScanBudget = 4 * maxFileLinkPageSize
+
+// IFileKeyLister pages through the direct uploads below /defaults and
+// deletes them by the link the listing names them by.
+type IFileKeyLister interface {
+	ListFileKeysPage(ctx context.Context, request FileKeyPageRequest) (*FileKeyPageResult, error)
+	DeleteFileKeyLink(ctx context.Context, link, owner string) error
+}
+
+// FileKeyPageCursor is where a page of direct uploads stopped: Dir is the
+// upload directory below /defaults as a cursor of that listing, and Entry
+// the last entry read in it. A nil Entry means Dir was read to the end.
+type FileKeyPageCursor struct {
+	Dir   FileLinkPageCursor
+	Entry *FileLinkPageCursor
+}
+
+// FileKeyPageRequest lists the uploads of Owner, or of every principal when
+// Owner is empty.
+type FileKeyPageRequest struct {
+	Owner  string
+	Cursor *FileKeyPageCursor
+	Limit  int
+}
+
+type FileKeyPageResult struct {
+	Items      []*FileKeyItem
+	NextCursor *FileKeyPageCursor
+}
+
+// FileKeyItem is a direct upload. The key itself is never stored, so an
+// upload is named by its link.
+type FileKeyItem struct {
+	Link    string
+	Name    string
+	Owner   string
+	Revoked bool
+	*entity.FileLinkMeta
+}
+
+type fileKeyRecord struct {
+	owner   string
+	revoked bool
+}
+
+// ListFileKeysPage walks the upload directories below /defaults in order
+// with ListFileLinksPage. Uploads of other principals are skipped, so a page
+// may hold fewer than Limit items while NextCursor is still set.
+func (d *defaultFileManager) ListFileKeysPage(
+	ctx context.Context,
+	request FileKeyPageRequest,
+) (*FileKeyPageResult, error) {
+	if request.Limit < 1 || request.Limit > maxFileLinkPageSize {
+		return nil, ErrInvalidFileKeyPage
+	}
+	if request.Cursor != nil && !validFileKeyDirectory(request.Cursor.Dir) {
+		return nil, ErrInvalidFileKeyPage
+	}
+	root := strings.TrimSuffix(filekey.Prefix, "/")
+	result := &FileKeyPageResult{Items: make([]*FileKeyItem, 0)}
+	var cursor FileKeyPageCursor
+	started := request.Cursor != nil
+	if started {
+		cursor = *request.Cursor
+	}
+	for budget := fileKeyScanBudget; budget > 0 && len(result.Items) < request.Limit; {
+		if !started || cursor.Entry == nil {
+			dir, found, err := d.nextFileKeyDirectory(ctx, root, started, cursor.Dir)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				return result, nil
+			}
+			cursor, started = FileKeyPageCursor{Dir: dir}, true
+		}
+		page, err := d.ListFileLinksPage(ctx, FileLinkPageRequest{
+			Path:   path.Join(root, cursor.Dir.Name),
+			Cursor: cursor.Entry,
+			Limit:  min(request.Limit-len(result.Items), budget),
+		})
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			// The directory went away since the cursor was issued.
+			cursor.Entry = nil
+			continue
+		case err != nil:
+			return nil, err
+		}
+		budget -= len(page.Items)
+		items, err := d.fileKeyItems(ctx, path.Join(root, cursor.Dir.Name), request.Owner, page.Items)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, items...)
+		cursor.Entry = page.NextCursor
+	}
+	result.NextCursor = &cursor
+	return result, nil
+}
+
+// DeleteFileKeyLink removes the direct upload at link, a link returned by
+// ListFileKeysPage, as DeleteFileKey removes the one a key serves. A
+// non-empty owner limits the deletion to uploads recorded for that
+// principal and reports ErrFileKeyNotOwned for others, including legacy
+// uploads without a recorded owner.
+func (d *defaultFileManager) DeleteFileKeyLink(ctx context.Context, link, owner string) error {
+	if !validFileKeyLink(link) {
+		return ErrInvalidFileKeyLink
+	}
+	if err := d.objectDir.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
+		var recorded string
+		err := queryRow(
+			ctx,
+			tx.QueryExecer(),
+			`SELECT owner FROM tg_file_key_tab WHERE link_path = ? AND owner != '' LIMIT 1`,
+			link,
+		).Scan(&recorded)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("read file key owner: %w", err)
+		}
+		if owner != "" && recorded != owner {
+			return ErrFileKeyNotOwned
+		}
+		return d.removeFileKeyLinkTx(ctx, tx, link)
+	}); err != nil {
+		return fmt.Errorf("delete file key link: %w", err)
+	}
+	return nil
+}
+
+// validFileKeyLink accepts the links of uploads, one directory below
+// /defaults.
+func validFileKeyLink(link string) bool {
+	rest, ok := strings.CutPrefix(link, filekey.Prefix)
+	if !ok || path.Clean(link) != link {
+		return false
+	}
+	dir, name, ok := strings.Cut(rest, "/")
+	return ok && dir != "" && name != "" && !strings.Contains(name, "/")
+}
+
+// nextFileKeyDirectory returns the upload directory after dir, or the first
+// one unless started. It reports false once there is none left.
+func (d *defaultFileManager) nextFileKeyDirectory(
+	ctx context.Context,
+	root string,
+	started bool,
+	dir FileLinkPageCursor,
+) (FileLinkPageCursor, bool, error) {
+	request := FileLinkPageRequest{Path: root, Limit: 1}
+	if started {
+		request.Cursor = &dir
+	}
+	for {
+		page, err := d.ListFileLinksPage(ctx, request)
+		switch {
+		case errors.Is(err, os.ErrNotExist) && !started:
+			// Nothing was uploaded yet.
+			return FileLinkPageCursor{}, false, nil
+		case err != nil:
+			return FileLinkPageCursor{}, false, err
+		case len(page.Items) == 0:
+			return FileLinkPageCursor{}, false, nil
+		}
+		item := page.Items[0]
+		next := FileLinkPageCursor{
+			ParentEntryID: page.ParentEntryID,
+			IsDir:         item.IsDir,
+			Name:          item.FileName,
+			EntryID:       item.EntryID,
+		}
+		if item.IsDir {
+			return next, true, nil
+		}
+		request.Cursor = &next
+	}
+}
+
+// validFileKeyDirectory keeps a cursor inside the upload directories.
+func validFileKeyDirectory(dir FileLinkPageCursor) bool {
+	return dir.IsDir && dir.Name != "" && dir.Name != "." && dir.Name != ".." && !strings.Contains(dir.Name, "/")
+}
+
+func (d *defaultFileManager) fileKeyItems(
+	ctx context.Context,
+	dir, owner string,
+	entries []*entity.FileLinkMeta,
+) ([]*FileKeyItem, error) {
+	links := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir {
+			links = append(links, path.Join(dir, entry.FileName))
+		}
+	}
+	records, err := d.readFileKeyRecords(ctx, links)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*FileKeyItem, 0, len(links))
+	for _, entry := range entries {
+		if entry.IsDir {
+			continue
+		}
+		link := path.Join(dir, entry.FileName)
+		record := records[link]
+		if owner != "" && record.owner != owner {
+			continue
+		}
+		item := &FileKeyItem{
+			Link:         link,
+			Name:         entry.FileName,
+			Owner:        record.owner,
+			Revoked:      record.revoked,
+			FileLinkMeta: entry,
+		}
+		if key, err := filekey.Parse(entry.FileName); err == nil {
+			item.Name = key.Name
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// readFileKeyRecords returns the owner and revocation of every link that
+// has a key row. A link without one is a legacy upload nobody owns.
+func (d *defaultFileManager) readFileKeyRecords(
+	ctx context.Context,
+	links []string,
+) (map[string]fileKeyRecord, error) {
+	records := make(map[string]fileKeyRecord, len(links))
+	if len(links) == 0 {
+		return records, nil
+	}
+	args := make([]any, 0, len(links))
+	for _, link := range links {
+		args = append(args, link)
+	}
+	rows, err := d.dbc.QueryContext(
+		ctx,
+		`SELECT link_path, owner, revoked_at FROM tg_file_key_tab WHERE link_path IN (?`+
+			strings.Repeat(", ?", len(links)-1)+`)`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("read file key owners: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var link, owner string
+		var revokedAt int64
+		if err := rows.Scan(&link, &owner, &revokedAt); err != nil {
+			return nil, fmt.Errorf("scan file key owner: %w", err)
+		}
+		record := records[link]
+		if owner != "" {
+			record.owner = owner
+		}
+		record.revoked = record.revoked || revokedAt != 0
+		records[link] = record
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate file key owners: %w", err)
+	}
+	return records, nil
+}
@@ -0,0 +1,181 @@
+package filemgr
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/filekey"
+	"github.com/xxxsen/tgfile/utils"
+)
+
+func TestFileKeysResolveUntilRevoked(t *testing.T) {
+	managerInterface, _, _ := newCreateFileTestManager(t, 32)
+	manager := managerInterface.(*defaultFileManager)
+	fileID, err := manager.CreateFile(t.Context(), 4, bytes.NewReader([]byte("data")))
+	require.NoError(t, err)
+
+	key, err := manager.CreateFileKey(t.Context(), "alice", "report.txt", fileID, 4)
+	require.NoError(t, err)
+	parsed, err := filekey.Parse(key)
+	require.NoError(t, err)
+	require.Equal(t, filekey.KindToken, parsed.Kind)
+	link, err := manager.ResolveFileKey(t.Context(), key)
+	require.NoError(t, err)
+	require.Equal(t, parsed.Link(), link)
+	info, err := manager.StatFileLink(t.Context(), link)
+	require.NoError(t, err)
+	require.Equal(t, fileID, info.FileId)
+
+	unissued, err := filekey.New("report.txt")
+	require.NoError(t, err)
+	_, err = manager.ResolveFileKey(t.Context(), unissued.Raw)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.ErrorIs(t, manager.RevokeFileKey(t.Context(), unissued.Raw), os.ErrNotExist)
+	_, err = manager.ResolveFileKey(t.Context(), "bad")
+	require.ErrorIs(t, err, filekey.ErrInvalidKey)
+
+	require.NoError(t, manager.RevokeFileKey(t.Context(), key))
+	require.NoError(t, manager.RevokeFileKey(t.Context(), key))
+	_, err = manager.ResolveFileKey(t.Context(), key)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = manager.StatFileLink(t.Context(), link)
+	require.NoError(t, err)
+}
+
+func TestLegacyFileKeysMigrateAndSwitchOff(t *testing.T) {
+	managerInterface, _, _ := newCreateFileTestManager(t, 32)
+	manager := managerInterface.(*defaultFileManager)
+	legacyKey := func(name string) *filekey.Key {
+		fileID, err := manager.CreateFile(t.Context(), int64(len(name)), bytes.NewReader([]byte(name)))
+		require.NoError(t, err)
+		key, err := filekey.Parse(hex.EncodeToString(utils.FileIdToHash(fileID)) + "-" + name)
+		require.NoError(t, err)
+		require.NoError(t, manager.CreateFileLink(t.Context(), key.Link(), fileID, int64(len(name)), false))
+		return key
+	}
+	kept := legacyKey("kept.txt")
+	revoked := legacyKey("revoked.txt")
+
+	link, err := manager.ResolveFileKey(t.Context(), kept.Raw)
+	require.NoError(t, err)
+	require.Equal(t, kept.Link(), link)
+	require.NoError(t, manager.RevokeFileKey(t.Context(), revoked.Raw))
+	_, err = manager.ResolveFileKey(t.Context(), revoked.Raw)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	migrated := map[string]string{}
+	collect := func(_ context.Context, legacy, key string) error {
+		migrated[legacy] = key
+		return nil
+	}
+	count, err := manager.MigrateLegacyFileKeys(t.Context(), collect)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	require.Contains(t, migrated, kept.Raw)
+	count, err = manager.MigrateLegacyFileKeys(t.Context(), collect)
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	WithoutLegacyFileKeys()(manager)
+	_, err = manager.ResolveFileKey(t.Context(), kept.Raw)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	link, err = manager.ResolveFileKey(t.Context(), migrated[kept.Raw])
+	require.NoError(t, err)
+	migratedInfo, err := manager.StatFileLink(t.Context(), link)
+	require.NoError(t, err)
+	keptInfo, err := manager.StatFileLink(t.Context(), kept.Link())
+	require.NoError(t, err)
+	require.Equal(t, keptInfo.FileId, migratedInfo.FileId)
+}
+
+func TestDeleteFileKeyChecksOwner(t *testing.T) {
+	managerInterface, _, _ := newCreateFileTestManager(t, 32)
+	manager := managerInterface.(*defaultFileManager)
+	issue := func(owner, name string) string {
+		fileID, err := manager.CreateFile(t.Context(), 4, bytes.NewReader([]byte("data")))
+		require.NoError(t, err)
+		key, err := manager.CreateFileKey(t.Context(), owner, name, fileID, 4)
+		require.NoError(t, err)
+		return key
+	}
+	aliceKey := issue("alice", "a.txt")
+	bobKey := issue("bob", "b.txt")
+
+	require.ErrorIs(t, manager.DeleteFileKey(t.Context(), bobKey, "alice"), ErrFileKeyNotOwned)
+	require.ErrorIs(t, manager.DeleteFileKey(t.Context(), "bad", "alice"), filekey.ErrInvalidKey)
+	unissued, err := filekey.New("c.txt")
+	require.NoError(t, err)
+	require.ErrorIs(t, manager.DeleteFileKey(t.Context(), unissued.Raw, ""), os.ErrNotExist)
+
+	require.NoError(t, manager.RevokeFileKey(t.Context(), aliceKey))
+	require.NoError(t, manager.DeleteFileKey(t.Context(), aliceKey, "alice"))
+	parsed, err := filekey.Parse(aliceKey)
+	require.NoError(t, err)
+	_, err = manager.StatFileLink(t.Context(), parsed.Link())
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.ErrorIs(t, manager.DeleteFileKey(t.Context(), aliceKey, "alice"), os.ErrNotExist)
+
+	require.NoError(t, manager.DeleteFileKey(t.Context(), bobKey, ""))
+	_, err = manager.ResolveFileKey(t.Context(), bobKey)
+	require.NoError(t, err)
+}
+
+func TestListFileKeysPageFiltersOwners(t *testing.T) {
+	managerInterface, _, _ := newCreateFileTestManager(t, 32)
+	manager := managerInterface.(*defaultFileManager)
+	page, err := manager.ListFileKeysPage(t.Context(), FileKeyPageRequest{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, page.Items)
+	require.Nil(t, page.NextCursor)
+
+	want := map[string]string{}
+	for i := range 12 {
+		owner := []string{"alice", "bob", "carol"}[i%3]
+		fileID, err := manager.CreateFile(t.Context(), 1, bytes.NewReader([]byte{byte(i)}))
+		require.NoError(t, err)
+		key, err := manager.CreateFileKey(t.Context(), owner, "f.bin", fileID, 1)
+		require.NoError(t, err)
+		parsed, err := filekey.Parse(key)
+		require.NoError(t, err)
+		want[parsed.Link()] = owner
+	}
+	_, err = manager.ListFileKeysPage(t.Context(), FileKeyPageRequest{Limit: 0})
+	require.ErrorIs(t, err, ErrInvalidFileKeyPage)
+
+	collect := func(owner string) map[string]string {
+		got := map[string]string{}
+		request := FileKeyPageRequest{Owner: owner, Limit: 2}
+		for range 100 {
+			page, err := manager.ListFileKeysPage(t.Context(), request)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(page.Items), 2)
+			for _, item := range page.Items {
+				require.Equal(t, "f.bin", item.Name)
+				require.EqualValues(t, 1, item.FileSize)
+				got[item.Link] = item.Owner
+			}
+			if page.NextCursor == nil {
+				return got
+			}
+			request.Cursor = page.NextCursor
+		}
+		require.FailNow(t, "listing did not end")
+		return nil
+	}
+	require.Equal(t, want, collect(""))
+	alice := collect("alice")
+	require.Len(t, alice, 4)
+	for link, owner := range alice {
+		require.Equal(t, want[link], owner)
+		require.Equal(t, "alice", owner)
+	}
+
+	escape := &FileKeyPageCursor{Dir: FileLinkPageCursor{ParentEntryID: 1, IsDir: true, Name: "..", EntryID: 1}}
+	_, err = manager.ListFileKeysPage(t.Context(), FileKeyPageRequest{Cursor: escape, Limit: 1})
+	require.ErrorIs(t, err, ErrInvalidFileKeyPage)
+}
@@ -39,11 +39,28 @@ var (
 	ErrWebDAVQuota             = errors.New("WebDAV logical quota exceeded")
 	ErrWebDAVTooManyItems      = errors.New("WebDAV mutation exceeds the configured entry limit")
 	ErrWebDAVSyncToken         = errors.New("WebDAV sync token is not valid for the current journal")
+	ErrWebDAVSearchQuery       = errors.New("WebDAV search query is not supported")
+	ErrWebDAVForbidden         = errors.New("WebDAV privilege is not granted")
+	ErrWebDAVACL               = errors.New("WebDAV ACL is invalid")
+	ErrWebDAVBinding           = errors.New("WebDAV binding is not allowed")
+	ErrWebDAVTarget            = errors.New("invalid WebDAV target")
+	ErrWebDAVTargetForbidden   = errors.New("WebDAV target is read-only for the principal")
 	ErrBackupBackendUpload     = errors.New("backup backend upload failed")
 	ErrBackupBackendReadback   = errors.New("backup backend readback failed")
 	ErrBackupPublish           = errors.New("backup publish failed")
 	ErrInvalidFileLinkPage     = errors.New("invalid file link page request")
 	ErrFileLinkCursorStale     = errors.New("file link page cursor is stale")
+	ErrInvalidTrashRequest     = errors.New("invalid trash request")
+	ErrTrashConflict           = errors.New("trash restore destination already exists")
+	ErrInvalidSnapshotRequest  = errors.New("invalid snapshot request")
+	ErrSnapshotExists          = errors.New("snapshot name is already used")
+	ErrSnapshotConflict        = errors.New("snapshot restore destination already exists")
+	ErrInvalidVersionRequest   = errors.New("invalid file version request")
+	ErrThumbnailUnsupported    = errors.New("file has no thumbnail")
+	ErrFileKeyNotOwned         = errors.New("file key was issued to another principal")
+	ErrInvalidFileKeyPage      = errors.New("invalid file key page request")
+	ErrInvalidFileKeyLink      = errors.New("invalid file key link")
+	ErrQuotaExceeded           = errors.New("principal quota exceeded")
 )
 
 type WalkLinkFunc func(ctx context.Context, link string, item *entity.FileLinkMeta) (bool, error)
@@ -118,6 +135,24 @@ type IFileManager interface {
 	IProtocolManager
 	IBackupStorage
 	IFileLifecycle
+	IStorageClassManager
+	ITrashManager
+	ISnapshotManager
+	IVersionManager
+	IThumbnailManager
+	IFileKeyManager
+	IFileKeyIssuer
+	IFileKeyLister
+	IQuotaManager
+	IOwnerBackfiller
+	IContentTypeBackfiller
+}
+
+// IStorageClassManager maps S3 storage classes to the configured backends.
+type IStorageClassManager interface {
+	StorageClassExists(class string) bool
+	DefaultStorageClass() string
+	TransitionS3Object(ctx context.Context, path, class string) (bool, error)
 }
 
 type IFileLifecycle interface {
@@ -174,6 +209,8 @@ type IBackupPublishStorage interface {
 type S3ObjectInfo struct {
 	Link     *entity.FileLinkMeta
 	Metadata *entity.S3ObjectMetadata
+	// StorageClass is filled by StatS3Object; mutation results leave it empty.
+	StorageClass string
 }
 
 type S3ListRequest struct {
@@ -193,6 +230,7 @@ type S3ListItem struct {
 	ETag              string
 	ChecksumAlgorithm string
 	ChecksumType      string
+	StorageClass      string
 }
 
 type S3ListResult struct {
@@ -257,7 +295,12 @@ type WebDAVIfTerm struct {
 }
 
 type WebDAVMutationOptions struct {
-	Principal  string
+	Principal string
+	// Groups are the principal's groups, matched by group ACEs.
+	Groups []string
+	// Admin skips WebDAV ACL checks, so an administrator can always repair
+	// an ACL that locks everyone else out.
+	Admin      bool
 	Condition  *WebDAVCondition
 	MaxEntries int
 	QuotaRoot  string
@@ -305,6 +348,8 @@ type WebDAVLockRequest struct {
 	Depth      string
 	OwnerXML   string
 	Principal  string
+	Groups     []string
+	Admin      bool
 	Timeout    time.Duration
 	IfHeader   *WebDAVIfHeader
 	MaxEntries int
@@ -390,6 +435,7 @@ type IWebDAVDiscoveryManager interface {
 		depth string,
 		limit int,
 	) (*WebDAVChangePage, error)
+	SearchWebDAV(ctx context.Context, query *WebDAVSearchQuery) (*WebDAVSearchResult, error)
 }
 
 type IWebDAVManager interface {
@@ -397,6 +443,8 @@ type IWebDAVManager interface {
 	IWebDAVPropertyManager
 	IWebDAVLockManager
 	IWebDAVDiscoveryManager
+	IWebDAVACLManager
+	IWebDAVBindManager
 }
 
 type IS3ObjectReader interface {
@@ -419,6 +467,7 @@ type IS3ObjectReader interface {
 		maxParts int,
 	) (*S3ObjectPartPage, error)
 	ListS3Objects(ctx context.Context, req *S3ListRequest) (*S3ListResult, error)
+	IterateS3Objects(ctx context.Context, bucket, prefix string, cb S3InventoryFunc) error
 }
 
 type IS3ObjectWriter interface {
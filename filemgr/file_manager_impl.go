@@ -42,6 +42,19 @@ type defaultFileManager struct {
 	objectDir      directory.ITransactionalDirectory
 	bkio           blockio.IBlockIO
 	ioc            IFileIOCache
+	primaryClass   string
+	tiers          []storageTier
+	backends       map[string]blockio.IBlockIO
+	classes        map[string]string
+	kindClasses    map[string]string
+	trashRetention time.Duration
+	snapshots      *SnapshotOptions
+	versions       *VersionOptions
+	thumbnails     *thumbnailRenderer
+	quotas         QuotaPolicy
+	observers      []directory.ChangeObserver
+	// rejectLegacyKeys stops resolving the computable legacy file keys.
+	rejectLegacyKeys bool
 }
 
 const maxFilePartCount int64 = 100_000
@@ -181,7 +194,11 @@ func (d *defaultFileManager) OpenFile(ctx context.Context, fileid uint64) (io.Re
 	var loader func(context.Context) (io.ReadSeekCloser, error)
 	switch finfo.FileLayoutVersion {
 	case 1:
-		loader = d.lowlevelIOStream(d.bkio, fileid, finfo.FileSize)
+		backend, err := d.backendFor(finfo.BackendKind)
+		if err != nil {
+			return nil, fmt.Errorf("open file %d: %w", fileid, err)
+		}
+		loader = d.lowlevelIOStream(backend, fileid, finfo.FileSize)
 	case 2:
 		loader = d.compositeIOStream(fileid, finfo.FileSize)
 	default:
@@ -221,7 +238,19 @@ func calculateFileBlockCount(size, blockSize int64) (int64, error) {
 }
 
 func (d *defaultFileManager) CreateFileDraft(ctx context.Context, size int64) (uint64, int64, error) {
-	blockSize := d.bkio.MaxFileSize()
+	backend, err := d.classBackend(storageClassFromContext(ctx))
+	if err != nil {
+		return 0, 0, err
+	}
+	return d.createFileDraft(ctx, backend, size)
+}
+
+func (d *defaultFileManager) createFileDraft(
+	ctx context.Context,
+	backend blockio.IBlockIO,
+	size int64,
+) (uint64, int64, error) {
+	blockSize := backend.MaxFileSize()
 	blockCount, err := calculateFileBlockCount(size, blockSize)
 	if err != nil {
 		return 0, 0, err
@@ -230,6 +259,7 @@ func (d *defaultFileManager) CreateFileDraft(ctx context.Context, size int64) (u
 	rs, err := d.fileDao.CreateFileDraft(ctx, &entity.CreateFileDraftRequest{
 		FileSize:      size,
 		FilePartCount: int32(blockCount), //nolint:gosec // calculateFileBlockCount caps this at 100,000.
+		BackendKind:   backend.Name(),
 	})
 	if err != nil {
 		return 0, 0, fmt.Errorf("create file draft: %w", err)
@@ -241,9 +271,22 @@ func (d *defaultFileManager) CreateFilePart(ctx context.Context, fileid uint64,
 	if partid < 0 || partid > maxFilePartCount {
 		return fmt.Errorf("%w: %d", ErrInvalidFilePart, partid)
 	}
+	finfo, ok, err := d.internalGetFileInfo(ctx, fileid)
+	if err != nil {
+		return fmt.Errorf("read file %d before part upload: %w", fileid, err)
+	}
+	if !ok {
+		return fmt.Errorf("create part of file %d: %w", fileid, os.ErrNotExist)
+	}
+	backend, err := d.backendFor(finfo.BackendKind)
+	if err != nil {
+		return err
+	}
 	md5v := NewMD5CompatibilityHash()
-	counted := &countingReader{reader: io.TeeReader(r, md5v)}
-	upload, err := d.bkio.Upload(ctx, counted)
+	// The leading bytes of the first part are sniffed for the content type.
+	head := &headBuffer{}
+	counted := &countingReader{reader: io.TeeReader(r, io.MultiWriter(md5v, head))}
+	upload, err := backend.Upload(ctx, counted)
 	if err != nil {
 		return fmt.Errorf("upload part failed, err:%w", err)
 	}
@@ -256,12 +299,12 @@ func (d *defaultFileManager) CreateFilePart(ctx context.Context, fileid uint64,
 		FileKey:      upload.FileKey,
 		FilePartMd5:  hex.EncodeToString(md5v.Sum(nil)),
 		FilePartSize: counted.count,
-		BackendKind:  d.bkio.Name(),
+		BackendKind:  backend.Name(),
 		DeleteRef:    upload.DeleteRef,
 		UploadedAt:   upload.UploadedAt,
 	}); err != nil {
 		compensationContext, cancel := context.WithTimeout(context.WithoutCancel(ctx), deleteTimeout)
-		deleteErr := d.bkio.DeleteBlocks(compensationContext, []string{upload.DeleteRef})
+		deleteErr := backend.DeleteBlocks(compensationContext, []string{upload.DeleteRef})
 		cancel()
 		if deleteErr != nil {
 			logutil.GetLogger(ctx).Error(
@@ -273,7 +316,10 @@ func (d *defaultFileManager) CreateFilePart(ctx context.Context, fileid uint64,
 		}
 		return fmt.Errorf("create file part record: %w", err)
 	}
-	return nil
+	if partid != 0 {
+		return nil
+	}
+	return d.recordSniffedType(ctx, fileid, head.head)
 }
 
 func (d *defaultFileManager) FinishFileCreate(ctx context.Context, fileid uint64) error {
@@ -317,7 +363,22 @@ func (d *defaultFileManager) CreateFile(
 	size int64,
 	reader io.Reader,
 ) (uint64, error) {
-	fileID, err := d.createFile(ctx, size, reader)
+	backend, err := d.classBackend(storageClassFromContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	return d.createFileOn(ctx, backend, size, reader)
+}
+
+// createFileOn stores reader as a new file on backend and discards the
+// partially uploaded file when any part fails.
+func (d *defaultFileManager) createFileOn(
+	ctx context.Context,
+	backend blockio.IBlockIO,
+	size int64,
+	reader io.Reader,
+) (uint64, error) {
+	fileID, err := d.createFile(ctx, backend, size, reader)
 	if err == nil || fileID == 0 {
 		return fileID, err
 	}
@@ -329,10 +390,11 @@ func (d *defaultFileManager) CreateFile(
 
 func (d *defaultFileManager) createFile(
 	ctx context.Context,
+	backend blockio.IBlockIO,
 	size int64,
 	reader io.Reader,
 ) (uint64, error) {
-	fileid, blksize, err := d.CreateFileDraft(ctx, size)
+	fileid, blksize, err := d.createFileDraft(ctx, backend, size)
 	if err != nil {
 		return 0, err
 	}
@@ -485,6 +547,8 @@ func (d *defaultFileManager) readUnRefFileIdList(ctx context.Context, limitMtime
 FROM tg_s3_multipart_part_tab part
 JOIN tg_s3_multipart_upload_tab upload ON upload.upload_id = part.upload_id
 WHERE upload.upload_state = 'active' AND part.part_state = 'active'`,
+		"SELECT file_id FROM tg_upload_session_tab WHERE completed_at = 0",
+		"SELECT file_id FROM tg_fetch_job_tab WHERE job_state = 'publishing'",
 	} {
 		fileIDs, err := queryFileIDList(ctx, d.dbc, query)
 		if err != nil {
@@ -553,18 +617,39 @@ func (d *defaultFileManager) PurgeFile(ctx context.Context, before *int64) (int6
 	return cleaned, nil
 }
 
-func NewFileManager(dbc database.IDatabase, bkio blockio.IBlockIO, ioc IFileIOCache) IFileManager {
-	objectDir, err := directory.NewDBDirectory(dbc, "tg_file_mapping_tab", idgen.Default().NextId)
-	if err != nil {
-		panic(err)
+// WithChangeObserver calls observer for every change recorded in the change
+// journal, inside the transaction that made it.
+func WithChangeObserver(observer directory.ChangeObserver) Option {
+	return func(d *defaultFileManager) {
+		d.observers = append(d.observers, observer)
 	}
-	return &defaultFileManager{
+}
+
+func NewFileManager(
+	dbc database.IDatabase,
+	bkio blockio.IBlockIO,
+	ioc IFileIOCache,
+	opts ...Option,
+) IFileManager {
+	manager := &defaultFileManager{
 		fileDao:        cache.NewFileDao(dao.NewFileDao(dbc)),
 		filePartDao:    cache.NewFilePartDao(dao.NewFilePartDao(dbc)),
 		fileMappingDao: dao.NewFileMappingDao(dbc),
 		dbc:            dbc,
-		objectDir:      objectDir,
 		bkio:           bkio,
 		ioc:            ioc,
 	}
+	for _, opt := range opts {
+		opt(manager)
+	}
+	objectDir, err := directory.NewDBDirectory(dbc, "tg_file_mapping_tab", idgen.Default().NextId, manager.observers...)
+	if err != nil {
+		panic(err)
+	}
+	manager.initStorageTiers()
+	manager.objectDir = &contentTypeDirectory{ITransactionalDirectory: objectDir}
+	if manager.quotas.enabled() {
+		manager.objectDir = &quotaDirectory{ITransactionalDirectory: manager.objectDir, policy: manager.quotas}
+	}
+	return manager
 }
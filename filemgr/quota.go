@@ -0,0 +1,378 @@
+package filemgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/xxxsen/common/database"
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+
+	"github.com/xxxsen/tgfile/directory"
+	"github.com/xxxsen/tgfile/filekey"
+)
+
+// QuotaLimits caps the logical bytes and the file count a principal owns.
+// A zero limit is unlimited. Writes that would take usage past a hard limit
+// fail with ErrQuotaExceeded; soft limits are only reported.
+type QuotaLimits struct {
+	HardBytes   int64
+	SoftBytes   int64
+	HardObjects int64
+	SoftObjects int64
+}
+
+func (l QuotaLimits) enabled() bool {
+	return l.HardBytes > 0 || l.SoftBytes > 0 || l.HardObjects > 0 || l.SoftObjects > 0
+}
+
+// QuotaPolicy holds the limits of every principal. Principals without an
+// entry get Default.
+type QuotaPolicy struct {
+	Default    QuotaLimits
+	Principals map[string]QuotaLimits
+}
+
+// Limits returns the limits that apply to principal. Mappings nobody owns
+// are never limited.
+func (p QuotaPolicy) Limits(principal string) QuotaLimits {
+	if principal == "" {
+		return QuotaLimits{}
+	}
+	if limits, ok := p.Principals[principal]; ok {
+		return limits
+	}
+	return p.Default
+}
+
+// QuotaUsage is what a principal owns: the logical size and count of its
+// files. Files shared by several mappings count once per mapping; files in
+// the recycle bin do not count, as with the WebDAV quota.
+type QuotaUsage struct {
+	Principal string
+	Bytes     int64
+	Objects   int64
+	Limits    QuotaLimits
+}
+
+func (u QuotaUsage) SoftExceeded() bool {
+	return exceeds(u.Bytes, u.Limits.SoftBytes) || exceeds(u.Objects, u.Limits.SoftObjects)
+}
+
+func (u QuotaUsage) HardExceeded() bool {
+	return exceeds(u.Bytes, u.Limits.HardBytes) || exceeds(u.Objects, u.Limits.HardObjects)
+}
+
+func exceeds(used, limit int64) bool {
+	return limit > 0 && used > limit
+}
+
+// IQuotaManager reports the usage of the per-principal quotas. Mappings are
+// owned by the principal in their creating context, see ContextWithOwner.
+type IQuotaManager interface {
+	QuotaLimits(principal string) QuotaLimits
+	QuotaUsage(ctx context.Context, principal string) (*QuotaUsage, error)
+	ListQuotaUsage(ctx context.Context) ([]QuotaUsage, error)
+}
+
+// IOwnerBackfiller assigns owners to mappings created before owners were
+// recorded. Mappings that already have an owner are never changed.
+type IOwnerBackfiller interface {
+	BackfillOwners(ctx context.Context, root, owner string) (int64, error)
+	BackfillFileKeyOwners(ctx context.Context) (int64, error)
+}
+
+// WithQuotas enforces policy on every write made through the file manager.
+func WithQuotas(policy QuotaPolicy) Option {
+	return func(d *defaultFileManager) {
+		d.quotas = policy
+	}
+}
+
+// ContextWithOwner makes the principal the owner of the mappings written
+// with ctx and charges them to its quota.
+func ContextWithOwner(ctx context.Context, principal string) context.Context {
+	return directory.ContextWithOwner(ctx, principal)
+}
+
+// quotaDirectory checks the quota of the owner in the context at the end of
+// every transaction. Usage is measured inside the transaction before and
+// after the writes, so only a write that grows usage past a hard limit is
+// rejected; a principal above its limit can still delete and shrink.
+type quotaDirectory struct {
+	directory.ITransactionalDirectory
+	policy QuotaPolicy
+}
+
+func (q *quotaDirectory) WithTransaction(ctx context.Context, callback directory.TransactionFunc) error {
+	principal := directory.OwnerFromContext(ctx)
+	limits := q.policy.Limits(principal)
+	if !limits.enabled() {
+		if err := q.ITransactionalDirectory.WithTransaction(ctx, callback); err != nil {
+			return fmt.Errorf("run transaction: %w", err)
+		}
+		return nil
+	}
+	err := q.ITransactionalDirectory.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
+		before, err := queryQuotaUsage(ctx, tx.QueryExecer(), principal)
+		if err != nil {
+			return err
+		}
+		if err := callback(ctx, tx); err != nil {
+			return err
+		}
+		after, err := queryQuotaUsage(ctx, tx.QueryExecer(), principal)
+		if err != nil {
+			return err
+		}
+		before.Limits, after.Limits = limits, limits
+		return checkQuota(ctx, before, after)
+	})
+	if err != nil {
+		return fmt.Errorf("run quota checked transaction: %w", err)
+	}
+	return nil
+}
+
+func checkQuota(ctx context.Context, before, after *QuotaUsage) error {
+	limits := after.Limits
+	if after.Bytes > before.Bytes && exceeds(after.Bytes, limits.HardBytes) {
+		return fmt.Errorf("%w: %q would own %d bytes, limit %d",
+			ErrQuotaExceeded, after.Principal, after.Bytes, limits.HardBytes)
+	}
+	if after.Objects > before.Objects && exceeds(after.Objects, limits.HardObjects) {
+		return fmt.Errorf("%w: %q would own %d objects, limit %d",
+			ErrQuotaExceeded, after.Principal, after.Objects, limits.HardObjects)
+	}
+	if after.SoftExceeded() && !before.SoftExceeded() {
+		logutil.GetLogger(ctx).Warn("principal exceeds soft quota",
+			zap.String("principal", after.Principal),
+			zap.Int64("bytes", after.Bytes),
+			zap.Int64("objects", after.Objects),
+			zap.Int64("soft_bytes", limits.SoftBytes),
+			zap.Int64("soft_objects", limits.SoftObjects),
+		)
+	}
+	return nil
+}
+
+// trashedEntries selects the mappings held by the recycle bin.
+const trashedEntries = `WITH RECURSIVE trashed(entry_id) AS (
+SELECT entry_id FROM tg_trash_tab
+UNION ALL
+SELECT child.entry_id FROM tg_file_mapping_tab child
+JOIN trashed parent ON child.parent_entry_id = parent.entry_id
+)
+`
+
+func queryQuotaUsage(ctx context.Context, queryer database.IQueryer, principal string) (*QuotaUsage, error) {
+	usage := &QuotaUsage{Principal: principal}
+	if err := queryRow(
+		ctx,
+		queryer,
+		trashedEntries+`SELECT COALESCE(SUM(file_size), 0), COUNT(*) FROM tg_file_mapping_tab
+WHERE owner = ? AND file_kind = 2 AND entry_id NOT IN (SELECT entry_id FROM trashed)`,
+		principal,
+	).Scan(&usage.Bytes, &usage.Objects); err != nil {
+		return nil, fmt.Errorf("calculate quota usage of %q: %w", principal, err)
+	}
+	return usage, nil
+}
+
+func (d *defaultFileManager) QuotaLimits(principal string) QuotaLimits {
+	return d.quotas.Limits(principal)
+}
+
+func (d *defaultFileManager) QuotaUsage(ctx context.Context, principal string) (*QuotaUsage, error) {
+	usage, err := queryQuotaUsage(ctx, d.dbc, principal)
+	if err != nil {
+		return nil, err
+	}
+	usage.Limits = d.quotas.Limits(principal)
+	return usage, nil
+}
+
+// ListQuotaUsage returns the usage of every principal that owns a file or
+// has limits of its own, ordered by principal.
+func (d *defaultFileManager) ListQuotaUsage(ctx context.Context) ([]QuotaUsage, error) {
+	rows, err := d.dbc.QueryContext(
+		ctx,
+		trashedEntries+`SELECT owner, COALESCE(SUM(file_size), 0), COUNT(*) FROM tg_file_mapping_tab
+WHERE owner != '' AND file_kind = 2 AND entry_id NOT IN (SELECT entry_id FROM trashed)
+GROUP BY owner`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query quota usage: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	usages := make(map[string]QuotaUsage, len(d.quotas.Principals))
+	for principal := range d.quotas.Principals {
+		usages[principal] = QuotaUsage{Principal: principal}
+	}
+	for rows.Next() {
+		var usage QuotaUsage
+		if err := rows.Scan(&usage.Principal, &usage.Bytes, &usage.Objects); err != nil {
+			return nil, fmt.Errorf("scan quota usage: %w", err)
+		}
+		usages[usage.Principal] = usage
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate quota usage: %w", err)
+	}
+	result := make([]QuotaUsage, 0, len(usages))
+	for principal, usage := range usages {
+		usage.Limits = d.quotas.Limits(principal)
+		result = append(result, usage)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Principal < result[j].Principal
+	})
+	return result, nil
+}
+
+// BackfillOwners makes owner the owner, and where none is recorded the
+// creator, of root and every unowned mapping below it. It returns how many
+// mappings were assigned.
+func (d *defaultFileManager) BackfillOwners(ctx context.Context, root, owner string) (int64, error) {
+	var assigned int64
+	err := d.objectDir.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
+		entryID, err := queryWebDAVRootEntryID(ctx, tx.QueryExecer(), root)
+		if err != nil {
+			return err
+		}
+		result, err := tx.QueryExecer().ExecContext(
+			ctx,
+			`WITH RECURSIVE subtree(entry_id) AS (
+SELECT ?
+UNION ALL
+SELECT child.entry_id FROM tg_file_mapping_tab child
+JOIN subtree parent ON child.parent_entry_id = parent.entry_id
+)
+UPDATE tg_file_mapping_tab SET owner = ?, creator = CASE WHEN creator = '' THEN ? ELSE creator END
+WHERE owner = '' AND entry_id IN (SELECT entry_id FROM subtree)`,
+			entryID,
+			owner,
+			owner,
+		)
+		if err != nil {
+			return fmt.Errorf("assign mapping owners: %w", err)
+		}
+		assigned, err = result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("count assigned mapping owners: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("backfill owners below %q: %w", root, err)
+	}
+	return assigned, nil
+}
+
+// BackfillFileKeyOwners gives every unowned direct upload the principal its
+// key was issued to, as owner and, where none is recorded, as creator.
+// Legacy uploads without a key row stay unowned.
+func (d *defaultFileManager) BackfillFileKeyOwners(ctx context.Context) (int64, error) {
+	root := path.Clean(filekey.Prefix)
+	var assigned int64
+	err := d.objectDir.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
+		entryID, err := queryWebDAVRootEntryID(ctx, tx.QueryExecer(), root)
+		if errors.Is(err, os.ErrNotExist) {
+			// Nothing was uploaded yet.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		owners, err := queryFileKeyOwners(ctx, tx.QueryExecer(), entryID, root)
+		if err != nil {
+			return err
+		}
+		for mappingID, owner := range owners {
+			result, err := tx.QueryExecer().ExecContext(
+				ctx,
+				`UPDATE tg_file_mapping_tab SET owner = ?, creator = CASE WHEN creator = '' THEN ? ELSE creator END
+WHERE entry_id = ? AND owner = ''`,
+				owner,
+				owner,
+				mappingID,
+			)
+			if err != nil {
+				return fmt.Errorf("assign upload owner: %w", err)
+			}
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("count assigned upload owners: %w", err)
+			}
+			assigned += affected
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("backfill direct upload owners: %w", err)
+	}
+	return assigned, nil
+}
+
+// queryFileKeyOwners maps the unowned files below root to the owner of a key
+// that links to them.
+func queryFileKeyOwners(
+	ctx context.Context,
+	queryer database.IQueryer,
+	entryID uint64,
+	root string,
+) (map[uint64]string, error) {
+	rows, err := queryer.QueryContext(
+		ctx,
+		`WITH RECURSIVE tree(entry_id, file_kind, owner, full_path) AS (
+SELECT entry_id, file_kind, owner, ? FROM tg_file_mapping_tab WHERE entry_id = ?
+UNION ALL
+SELECT child.entry_id, child.file_kind, child.owner, tree.full_path || '/' || child.file_name
+FROM tg_file_mapping_tab child
+JOIN tree ON child.parent_entry_id = tree.entry_id
+)
+SELECT tree.entry_id, MAX(file_key.owner) FROM tree
+JOIN tg_file_key_tab file_key ON file_key.link_path = tree.full_path
+WHERE tree.file_kind = 2 AND tree.owner = '' AND file_key.owner != ''
+GROUP BY tree.entry_id`,
+		root,
+		entryID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query upload owners: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	owners := make(map[uint64]string)
+	for rows.Next() {
+		var mappingID uint64
+		var owner string
+		if err := rows.Scan(&mappingID, &owner); err != nil {
+			return nil, fmt.Errorf("scan upload owner: %w", err)
+		}
+		owners[mappingID] = owner
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate upload owners: %w", err)
+	}
+	return owners, nil
+}
+
+func (p QuotaPolicy) enabled() bool {
+	if p.Default.enabled() {
+		return true
+	}
+	for _, limits := range p.Principals {
+		if limits.enabled() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package filemgr
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func createOwnedLink(t *testing.T, ctx context.Context, manager IFileManager, link, content string) error {
+	t.Helper()
+	fileID, err := manager.CreateFile(ctx, int64(len(content)), bytes.NewReader([]byte(content)))
+	require.NoError(t, err)
+	return manager.CreateFileLink(ctx, link, fileID, int64(len(content)), false)
+}
+
+func TestQuotaRejectsWritesPastHardLimit(t *testing.T) {
+	manager, _, _ := newCreateFileTestManager(t, 32, WithQuotas(QuotaPolicy{
+		Default:    QuotaLimits{SoftBytes: 1},
+		Principals: map[string]QuotaLimits{"alice": {HardBytes: 8, HardObjects: 2}},
+	}))
+	alice := ContextWithOwner(t.Context(), "alice")
+	require.NoError(t, createOwnedLink(t, alice, manager, "/alice/one", "1111"))
+	require.NoError(t, createOwnedLink(t, alice, manager, "/alice/two", "2222"))
+	require.ErrorIs(t, createOwnedLink(t, alice, manager, "/alice/three", "3"), ErrQuotaExceeded)
+	_, err := manager.StatFileLink(t.Context(), "/alice/three")
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.ErrorIs(t, manager.CopyFileLink(alice, "/alice/one", "/alice/copy", false), ErrQuotaExceeded)
+
+	info, err := manager.StatFileLink(t.Context(), "/alice/one")
+	require.NoError(t, err)
+	require.Equal(t, "alice", info.Owner)
+	usage, err := manager.QuotaUsage(t.Context(), "alice")
+	require.NoError(t, err)
+	require.Equal(t, int64(8), usage.Bytes)
+	require.Equal(t, int64(2), usage.Objects)
+	require.False(t, usage.HardExceeded())
+
+	// Shrinking is always allowed; the object limit then stops the next file.
+	require.NoError(t, manager.RemoveFileLink(alice, "/alice/two"))
+	require.NoError(t, createOwnedLink(t, alice, manager, "/alice/two", "2"))
+	require.ErrorIs(t, createOwnedLink(t, alice, manager, "/alice/three", "3"), ErrQuotaExceeded)
+
+	// Unowned writes and soft limits are never rejected.
+	require.NoError(t, createOwnedLink(t, t.Context(), manager, "/system/blob", "0123456789"))
+	require.NoError(t, createOwnedLink(t, ContextWithOwner(t.Context(), "bob"), manager, "/bob/one", "bb"))
+	usages, err := manager.ListQuotaUsage(t.Context())
+	require.NoError(t, err)
+	require.Len(t, usages, 2)
+	require.Equal(t, "alice", usages[0].Principal)
+	require.Equal(t, int64(5), usages[0].Bytes)
+	require.Equal(t, "bob", usages[1].Principal)
+	require.True(t, usages[1].SoftExceeded())
+	require.False(t, usages[1].HardExceeded())
+}
+
+func TestBackfillOwnersOnlyAssignsUnownedMappings(t *testing.T) {
+	manager, _, databaseClient := newCreateFileTestManager(t, 32)
+	ctx := t.Context()
+	require.NoError(t, createOwnedLink(t, ctx, manager, "/docs/a", "aa"))
+	require.NoError(t, createOwnedLink(t, ctx, manager, "/docs/b", "bb"))
+	require.NoError(t, createOwnedLink(t, ContextWithOwner(ctx, "erin"), manager, "/docs/c", "cc"))
+	fileID, err := manager.CreateFile(ctx, 3, bytes.NewReader([]byte("key")))
+	require.NoError(t, err)
+	key, err := manager.CreateFileKey(ctx, "carol", "upload.txt", fileID, 3)
+	require.NoError(t, err)
+	link, err := manager.ResolveFileKey(ctx, key)
+	require.NoError(t, err)
+	info, err := manager.StatFileLink(ctx, link)
+	require.NoError(t, err)
+	require.Equal(t, "carol", info.Owner)
+	// Uploads made before owners were recorded.
+	_, err = databaseClient.ExecContext(ctx, `UPDATE tg_file_mapping_tab SET owner = '' WHERE owner = 'carol'`)
+	require.NoError(t, err)
+
+	assigned, err := manager.BackfillFileKeyOwners(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), assigned)
+	assigned, err = manager.BackfillOwners(ctx, "/docs", "dave")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), assigned)
+	assigned, err = manager.BackfillOwners(ctx, "/docs", "dave")
+	require.NoError(t, err)
+	require.Zero(t, assigned)
+	_, err = manager.BackfillOwners(ctx, "/missing", "dave")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	for principal, objects := range map[string]int64{"carol": 1, "dave": 2, "erin": 1} {
+		usage, err := manager.QuotaUsage(ctx, principal)
+		require.NoError(t, err)
+		require.Equal(t, objects, usage.Objects, principal)
+	}
+}
+
+func TestQuotaIgnoresTrashedFiles(t *testing.T) {
+	manager, _, _ := newCreateFileTestManager(t, 32, WithTrash(time.Hour), WithQuotas(QuotaPolicy{
+		Principals: map[string]QuotaLimits{"alice": {HardBytes: 4}},
+	}))
+	alice := ContextWithOwner(t.Context(), "alice")
+	require.NoError(t, createOwnedLink(t, alice, manager, "/alice/one", "1111"))
+	require.NoError(t, manager.RemoveFileLink(alice, "/alice/one"))
+	usage, err := manager.QuotaUsage(t.Context(), "alice")
+	require.NoError(t, err)
+	require.Zero(t, usage.Bytes)
+	require.NoError(t, createOwnedLink(t, alice, manager, "/alice/two", "2222"))
+}
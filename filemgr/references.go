@@ -71,6 +71,22 @@ func fileHasLiveReference(
 	if count != 0 {
 		return true, nil
 	}
+	if err := queryRow(
+		ctx,
+		queryer,
+		`SELECT COUNT(*) FROM (
+  SELECT file_id FROM tg_snapshot_entry_tab UNION ALL SELECT file_id FROM tg_file_version_tab
+  UNION ALL SELECT thumb_file_id FROM tg_file_thumbnail_tab
+) pinned WHERE file_id = ?
+   OR file_id IN (SELECT file_id FROM tg_s3_file_segment_tab WHERE source_file_id = ?)`,
+		fileID,
+		fileID,
+	).Scan(&count); err != nil {
+		return false, fmt.Errorf("count snapshot, version and thumbnail file references: %w", err)
+	}
+	if count != 0 {
+		return true, nil
+	}
 	if err := queryRow(
 		ctx,
 		queryer,
@@ -0,0 +1,126 @@
+package filemgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/xxxsen/tgfile/directory"
+	"github.com/xxxsen/tgfile/entity"
+)
+
+const s3InventoryBatch uint = 1000
+
+// S3InventoryItem is an object reported by IterateS3Objects. Checksum holds
+// the additional checksum recorded at upload, or the SHA-256 of the content
+// when the client sent none.
+type S3InventoryItem struct {
+	S3ListItem
+	Checksum string
+}
+
+type S3InventoryFunc func(ctx context.Context, items []S3InventoryItem) error
+
+// IterateS3Objects reports every object of bucket whose key starts with
+// prefix. Directories are read page by page through the directory index, so
+// no transaction stays open across pages; objects are grouped per directory
+// page and are not reported in key order.
+func (d *defaultFileManager) IterateS3Objects(
+	ctx context.Context,
+	bucket, prefix string,
+	cb S3InventoryFunc,
+) error {
+	root := "/" + bucket
+	pending := []string{""}
+	if index := strings.LastIndex(prefix, "/"); index >= 0 {
+		pending[0] = prefix[:index+1]
+	}
+	for len(pending) != 0 {
+		dir := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		err := d.objectDir.Iterate(
+			ctx,
+			path.Join(root, dir),
+			s3InventoryBatch,
+			func(ctx context.Context, entries []directory.IDirectoryEntry) (bool, error) {
+				items := make([]S3InventoryItem, 0, len(entries))
+				for _, entry := range entries {
+					key := dir + entry.Name()
+					if entry.IsDir() {
+						if strings.HasPrefix(key+"/", prefix) || strings.HasPrefix(prefix, key+"/") {
+							pending = append(pending, key+"/")
+						}
+						continue
+					}
+					if !strings.HasPrefix(key, prefix) {
+						continue
+					}
+					item, err := d.inventoryItem(ctx, root+"/"+key, key, entry)
+					if err != nil {
+						return false, err
+					}
+					items = append(items, item)
+				}
+				if len(items) == 0 {
+					return true, nil
+				}
+				return true, cb(ctx, items)
+			},
+		)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("iterate S3 objects under %q: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+func (d *defaultFileManager) inventoryItem(
+	ctx context.Context,
+	objectPath, key string,
+	entry directory.IDirectoryEntry,
+) (S3InventoryItem, error) {
+	link, err := directoryEntryToLink(objectPath, entry)
+	if err != nil {
+		return S3InventoryItem{}, err
+	}
+	metadata, found, err := readS3Metadata(ctx, d.dbc, link.EntryID)
+	if err != nil {
+		return S3InventoryItem{}, err
+	}
+	if !found {
+		metadata = legacyS3Metadata(link)
+	}
+	class, err := d.fileStorageClass(ctx, link.FileId)
+	if err != nil {
+		return S3InventoryItem{}, err
+	}
+	algorithm, checksum := inventoryChecksum(metadata)
+	return S3InventoryItem{
+		S3ListItem: S3ListItem{
+			Key:               key,
+			Size:              link.FileSize,
+			LastModified:      link.Mtime,
+			ETag:              metadata.ETag,
+			ChecksumAlgorithm: algorithm,
+			ChecksumType:      metadata.ChecksumType,
+			StorageClass:      class,
+		},
+		Checksum: checksum,
+	}, nil
+}
+
+func inventoryChecksum(metadata *entity.S3ObjectMetadata) (string, string) {
+	if metadata.RequestChecksumAlgorithm != "" {
+		return metadata.RequestChecksumAlgorithm, metadata.RequestChecksumValue
+	}
+	if metadata.ChecksumSHA256 != "" {
+		return "SHA256", metadata.ChecksumSHA256
+	}
+	return "", ""
+}
@@ -55,6 +55,9 @@ type CreateMultipartRequest struct {
 	ExpireAfter  time.Duration
 	Algorithm    s3checksum.Algorithm
 	ChecksumType s3checksum.Type
+	// StorageClass is stored with the upload and applied to every part; an
+	// empty class selects the default class.
+	StorageClass string
 }
 
 type MultipartUpload struct {
@@ -65,6 +68,7 @@ type MultipartUpload struct {
 	ExpiresAt    time.Time
 	Algorithm    s3checksum.Algorithm
 	ChecksumType s3checksum.Type
+	StorageClass string
 }
 
 // PrepareMultipartPartRequest identifies the upload whose checksum policy is
@@ -75,11 +79,13 @@ type PrepareMultipartPartRequest struct {
 	Key      string
 }
 
-// MultipartChecksumSpec is the immutable checksum policy for an upload.
+// MultipartChecksumSpec is the immutable checksum policy for an upload,
+// together with the storage class its parts are written to.
 type MultipartChecksumSpec struct {
 	Algorithm    s3checksum.Algorithm
 	ChecksumType s3checksum.Type
 	Legacy       bool
+	StorageClass string
 }
 
 type PutMultipartPartRequest struct {
@@ -170,6 +176,7 @@ type MultipartUploadListItem struct {
 	Initiated    time.Time
 	Algorithm    s3checksum.Algorithm
 	ChecksumType s3checksum.Type
+	StorageClass string
 }
 
 type MultipartUploadPage struct {
@@ -212,6 +219,7 @@ type storedMultipartUpload struct {
 	userMetadata       string
 	checksumAlgorithm  string
 	checksumType       string
+	storageClass       string
 	fingerprint        string
 	resultFileID       uint64
 	resultETag         string
@@ -233,6 +241,9 @@ func (d *defaultFileManager) CreateMultipartUpload(
 	if err != nil {
 		return nil, err
 	}
+	if request.StorageClass != "" && !d.StorageClassExists(request.StorageClass) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidStorageClass, request.StorageClass)
+	}
 	expiry := request.ExpireAfter
 	if expiry == 0 {
 		expiry = defaultMultipartExpiry
@@ -251,9 +262,9 @@ func (d *defaultFileManager) CreateMultipartUpload(
 			`INSERT INTO tg_s3_multipart_upload_tab (
 upload_id, bucket_name, object_key, upload_state,
 content_type, cache_control, content_disposition, content_encoding,
-content_language, expires, user_metadata, checksum_algorithm, checksum_type,
+content_language, expires, user_metadata, checksum_algorithm, checksum_type, storage_class,
 initiated_at, expires_at, ctime, mtime
-) VALUES (?, ?, ?, 'active', ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+) VALUES (?, ?, ?, 'active', ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 			uploadID,
 			request.Bucket,
 			request.Key,
@@ -266,6 +277,7 @@ initiated_at, expires_at, ctime, mtime
 			request.Metadata.UserMetadata,
 			algorithm,
 			checksumType,
+			request.StorageClass,
 			now.UnixMilli(),
 			now.Add(expiry).UnixMilli(),
 			now.UnixMilli(),
@@ -280,6 +292,7 @@ initiated_at, expires_at, ctime, mtime
 				ExpiresAt:    now.Add(expiry),
 				Algorithm:    algorithm,
 				ChecksumType: checksumType,
+				StorageClass: request.StorageClass,
 			}, nil
 		}
 		if !strings.Contains(err.Error(), "UNIQUE constraint failed") {
@@ -315,7 +328,7 @@ func readMultipartUpload(
 ) (storedMultipartUpload, bool, error) {
 	const query = `SELECT upload_id, bucket_name, object_key, upload_state,
 content_type, cache_control, content_disposition, content_encoding,
-content_language, expires, user_metadata, checksum_algorithm, checksum_type,
+content_language, expires, user_metadata, checksum_algorithm, checksum_type, storage_class,
 completion_fingerprint, result_file_id, result_etag, result_checksum_value,
 initiated_at, expires_at, completed_at, cleanup_at
 FROM tg_s3_multipart_upload_tab WHERE upload_id = ?`
@@ -334,6 +347,7 @@ FROM tg_s3_multipart_upload_tab WHERE upload_id = ?`
 		&upload.userMetadata,
 		&upload.checksumAlgorithm,
 		&upload.checksumType,
+		&upload.storageClass,
 		&upload.fingerprint,
 		&upload.resultFileID,
 		&upload.resultETag,
@@ -394,7 +408,7 @@ func (d *defaultFileManager) PrepareMultipartPart(
 
 func multipartChecksumSpec(upload storedMultipartUpload) (*MultipartChecksumSpec, error) {
 	if upload.checksumAlgorithm == "" && upload.checksumType == "" {
-		return &MultipartChecksumSpec{Legacy: true}, nil
+		return &MultipartChecksumSpec{Legacy: true, StorageClass: upload.storageClass}, nil
 	}
 	algorithm, err := s3checksum.ParseAlgorithm(upload.checksumAlgorithm)
 	if err != nil {
@@ -407,7 +421,11 @@ func multipartChecksumSpec(upload storedMultipartUpload) (*MultipartChecksumSpec
 	if err := s3checksum.ValidateCombination(algorithm, checksumType); err != nil {
 		return nil, fmt.Errorf("%w: stored checksum combination: %w", ErrMultipartConflict, err)
 	}
-	return &MultipartChecksumSpec{Algorithm: algorithm, ChecksumType: checksumType}, nil
+	return &MultipartChecksumSpec{
+		Algorithm:    algorithm,
+		ChecksumType: checksumType,
+		StorageClass: upload.storageClass,
+	}, nil
 }
 
 func (d *defaultFileManager) PutMultipartPart(
@@ -1154,7 +1172,7 @@ func (d *defaultFileManager) completeActiveMultipart(
 	if err := validateFinalChecksum(upload, request, checksumValue); err != nil {
 		return nil, err
 	}
-	if err := persistCompletedMultipart(
+	if err := d.persistCompletedMultipart(
 		ctx,
 		tx,
 		request,
@@ -1239,7 +1257,7 @@ checksum_value, ctime, mtime
 	return nil
 }
 
-func persistCompletedMultipart(
+func (d *defaultFileManager) persistCompletedMultipart(
 	ctx context.Context,
 	tx directory.ITransaction,
 	request *CompleteMultipartRequest,
@@ -1250,7 +1268,7 @@ func persistCompletedMultipart(
 	etag, checksumValue, fingerprint string,
 	now time.Time,
 ) error {
-	if _, err := publishS3ObjectTx(
+	if _, err := d.publishS3ObjectTx(
 		ctx,
 		tx,
 		"/"+request.Bucket+"/"+request.Key,
@@ -1743,6 +1761,7 @@ type multipartListProjection struct {
 	uploadID          string
 	checksumAlgorithm string
 	checksumType      string
+	storageClass      string
 	initiated         int64
 	common            bool
 }
@@ -1753,7 +1772,7 @@ const multipartUploadProjectionSQL = `WITH parameters AS (
 ),
 matching AS (
     SELECT upload.object_key, upload.upload_id, upload.initiated_at,
-           upload.checksum_algorithm, upload.checksum_type,
+           upload.checksum_algorithm, upload.checksum_type, upload.storage_class,
            parameters.prefix, parameters.delimiter,
            substr(upload.object_key, length(parameters.prefix) + 1) AS remainder
     FROM tg_s3_multipart_upload_tab upload
@@ -1790,6 +1809,11 @@ projected AS (
             THEN ''
             ELSE checksum_type
         END AS projected_checksum_type,
+        CASE
+            WHEN delimiter = '/' AND instr(remainder, '/') > 0
+            THEN ''
+            ELSE storage_class
+        END AS projected_storage_class,
         CASE
             WHEN delimiter = '/' AND instr(remainder, '/') > 0
             THEN 1
@@ -1802,12 +1826,13 @@ deduplicated AS (
            MAX(projected_initiated_at) AS projected_initiated_at,
            MAX(projected_checksum_algorithm) AS projected_checksum_algorithm,
            MAX(projected_checksum_type) AS projected_checksum_type,
+           MAX(projected_storage_class) AS projected_storage_class,
            is_common_prefix
     FROM projected
     GROUP BY projected_key, projected_upload_id, is_common_prefix
 )
 SELECT projected_key, projected_upload_id, projected_initiated_at,
-       projected_checksum_algorithm, projected_checksum_type, is_common_prefix
+       projected_checksum_algorithm, projected_checksum_type, projected_storage_class, is_common_prefix
 FROM deduplicated
 CROSS JOIN parameters
 WHERE projected_key > parameters.key_marker
@@ -1875,6 +1900,7 @@ func readMultipartUploadProjections(
 			&projection.initiated,
 			&projection.checksumAlgorithm,
 			&projection.checksumType,
+			&projection.storageClass,
 			&projection.common,
 		); err != nil {
 			return nil, fmt.Errorf("scan multipart upload list: %w", err)
@@ -1909,6 +1935,7 @@ func buildMultipartUploadPage(
 			Initiated:    time.UnixMilli(projection.initiated),
 			Algorithm:    s3checksum.Algorithm(projection.checksumAlgorithm),
 			ChecksumType: s3checksum.Type(projection.checksumType),
+			StorageClass: projection.storageClass,
 		})
 	}
 	if !page.IsTruncated {
@@ -10,11 +10,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/xxxsen/tgfile/contenttype"
 	"github.com/xxxsen/tgfile/directory"
 	"github.com/xxxsen/tgfile/entity"
 
 	"github.com/xxxsen/common/database"
-	"github.com/xxxsen/mimetype"
 )
 
 const defaultS3CacheControl = "public, max-age=604800"
@@ -41,7 +41,11 @@ func (d *defaultFileManager) StatS3Object(ctx context.Context, objectPath string
 	if !found {
 		metadata = legacyS3Metadata(link)
 	}
-	return &S3ObjectInfo{Link: link, Metadata: metadata}, nil
+	class, err := d.fileStorageClass(ctx, link.FileId)
+	if err != nil {
+		return nil, err
+	}
+	return &S3ObjectInfo{Link: link, Metadata: metadata, StorageClass: class}, nil
 }
 
 func directoryEntryToLink(objectPath string, entry directory.IDirectoryEntry) (*entity.FileLinkMeta, error) {
@@ -50,26 +54,24 @@ func directoryEntryToLink(objectPath string, entry directory.IDirectoryEntry) (*
 		return nil, fmt.Errorf("parse S3 object file id: %w", err)
 	}
 	return &entity.FileLinkMeta{
-		EntryID:  entry.EntryID(),
-		FileName: objectPath,
-		FileId:   fileID,
-		FileSize: entry.Size(),
-		Mode:     entry.Mode(),
-		Ctime:    entry.Ctime(),
-		Mtime:    entry.Mtime(),
-		IsDir:    false,
+		EntryID:     entry.EntryID(),
+		FileName:    objectPath,
+		FileId:      fileID,
+		FileSize:    entry.Size(),
+		Mode:        entry.Mode(),
+		Ctime:       entry.Ctime(),
+		Mtime:       entry.Mtime(),
+		IsDir:       false,
+		Owner:       entry.Owner(),
+		ContentType: entry.ContentType(),
 	}, nil
 }
 
 func legacyS3Metadata(link *entity.FileLinkMeta) *entity.S3ObjectMetadata {
-	extension := ""
-	if index := strings.LastIndexByte(link.FileName, '.'); index >= 0 {
-		extension = link.FileName[index:]
-	}
 	return &entity.S3ObjectMetadata{
 		EntryID:      link.EntryID,
 		ETag:         fmt.Sprintf(`W/"%d"`, link.FileId),
-		ContentType:  mimetype.LookupWithDefault(extension, "application/octet-stream"),
+		ContentType:  linkContentType(link),
 		CacheControl: defaultS3CacheControl,
 		UserMetadata: "{}",
 		Ctime:        link.Ctime,
@@ -154,11 +156,26 @@ func (r *rowsRow) Scan(dest ...any) error {
 	return nil
 }
 
+// linkContentType returns the stored content type of link, or the type its
+// extension implies for a mapping created before content types were stored.
+func linkContentType(link *entity.FileLinkMeta) string {
+	if link.ContentType != "" {
+		return link.ContentType
+	}
+	return contenttype.Resolve(link.FileName, "")
+}
+
+// insertS3Metadata stores the metadata of an object. An object written
+// without a Content-Type gets the content type of its mapping; an explicit
+// one replaces it, so every protocol serves the type the client chose.
 func insertS3Metadata(
 	ctx context.Context,
-	exec database.IExecer,
+	exec database.IQueryExecer,
 	metadata *entity.S3ObjectMetadata,
 ) error {
+	if err := syncS3ContentType(ctx, exec, metadata); err != nil {
+		return err
+	}
 	const statement = `INSERT INTO tg_s3_object_metadata_tab (
 entry_id, etag, checksum_sha256, request_checksum_algorithm, request_checksum_value, checksum_type,
 content_type, cache_control, content_disposition, content_encoding, content_language,
@@ -189,6 +206,35 @@ expires, user_metadata, ctime, mtime
 	return nil
 }
 
+func syncS3ContentType(ctx context.Context, exec database.IQueryExecer, metadata *entity.S3ObjectMetadata) error {
+	if metadata.ContentType != "" {
+		if _, err := exec.ExecContext(
+			ctx,
+			"UPDATE tg_file_mapping_tab SET content_type = ? WHERE entry_id = ?",
+			metadata.ContentType,
+			metadata.EntryID,
+		); err != nil {
+			return fmt.Errorf("store S3 object content type: %w", err)
+		}
+		return nil
+	}
+	var stored string
+	err := queryRow(
+		ctx,
+		exec,
+		"SELECT content_type FROM tg_file_mapping_tab WHERE entry_id = ?",
+		metadata.EntryID,
+	).Scan(&stored)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("read S3 object content type: %w", err)
+	}
+	metadata.ContentType = stored
+	if stored == "" {
+		metadata.ContentType = contenttype.Default
+	}
+	return nil
+}
+
 func evaluateS3Condition(info *S3ObjectInfo, condition *S3Condition) error {
 	if condition == nil {
 		return nil
@@ -266,7 +312,7 @@ func (d *defaultFileManager) PublishS3Object(
 	var published *S3ObjectInfo
 	err := d.objectDir.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
 		var err error
-		published, err = publishS3ObjectTx(ctx, tx, objectPath, fileID, size, metadata, condition)
+		published, err = d.publishS3ObjectTx(ctx, tx, objectPath, fileID, size, metadata, condition)
 		return err
 	})
 	if err != nil {
@@ -275,7 +321,7 @@ func (d *defaultFileManager) PublishS3Object(
 	return published, nil
 }
 
-func publishS3ObjectTx(
+func (d *defaultFileManager) publishS3ObjectTx(
 	ctx context.Context,
 	tx directory.ITransaction,
 	objectPath string,
@@ -294,7 +340,7 @@ func publishS3ObjectTx(
 	if err := ensureFileCanBeLinked(ctx, tx.QueryExecer(), fileID); err != nil {
 		return nil, err
 	}
-	replacedFileID, err := removeReplacedS3Object(ctx, tx, objectPath, current)
+	replacedFileID, err := d.removeReplacedS3Object(ctx, tx, objectPath, current)
 	if err != nil {
 		return nil, err
 	}
@@ -322,7 +368,7 @@ func publishS3ObjectTx(
 		return nil, err
 	}
 	if replacedFileID != 0 && replacedFileID != fileID {
-		if err := markFilePendingIfUnreferenced(ctx, tx.QueryExecer(), replacedFileID, now); err != nil {
+		if err := d.retireReplacedFileTx(ctx, tx.QueryExecer(), objectPath, linkVersion(current.Link), now); err != nil {
 			return nil, err
 		}
 	}
@@ -333,7 +379,7 @@ func publishS3ObjectTx(
 	return &S3ObjectInfo{Link: link, Metadata: &stored}, nil
 }
 
-func removeReplacedS3Object(
+func (d *defaultFileManager) removeReplacedS3Object(
 	ctx context.Context,
 	tx directory.ITransaction,
 	objectPath string,
@@ -415,7 +461,7 @@ func (d *defaultFileManager) CopyS3Object(
 	var copied *S3ObjectInfo
 	err := d.objectDir.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
 		var err error
-		copied, err = copyS3ObjectTx(
+		copied, err = d.copyS3ObjectTx(
 			ctx,
 			tx,
 			source,
@@ -432,7 +478,7 @@ func (d *defaultFileManager) CopyS3Object(
 	return copied, nil
 }
 
-func copyS3ObjectTx(
+func (d *defaultFileManager) copyS3ObjectTx(
 	ctx context.Context,
 	tx directory.ITransaction,
 	source, destination string,
@@ -459,7 +505,7 @@ func copyS3ObjectTx(
 	if source == destination {
 		return copySameS3ObjectTx(ctx, tx, source, sourceInfo, metadata)
 	}
-	return copyDifferentS3ObjectTx(ctx, tx, destination, sourceInfo, destinationInfo, metadata)
+	return d.copyDifferentS3ObjectTx(ctx, tx, destination, sourceInfo, destinationInfo, metadata)
 }
 
 func copySameS3ObjectTx(
@@ -491,23 +537,20 @@ func copySameS3ObjectTx(
 	return sourceInfo, nil
 }
 
-func copyDifferentS3ObjectTx(
+func (d *defaultFileManager) copyDifferentS3ObjectTx(
 	ctx context.Context,
 	tx directory.ITransaction,
 	destination string,
 	sourceInfo, destinationInfo *S3ObjectInfo,
 	metadata *entity.S3ObjectMetadata,
 ) (*S3ObjectInfo, error) {
-	replacedFileID, err := removeReplacedS3Object(ctx, tx, destination, destinationInfo)
+	replacedFileID, err := d.removeReplacedS3Object(ctx, tx, destination, destinationInfo)
 	if err != nil {
 		return nil, err
 	}
 	if destinationInfo != nil {
 		overwritten := []directory.IDirectoryEntry{linkDirectoryEntry{link: destinationInfo.Link}}
-		if err := deleteWebDAVProperties(ctx, tx.QueryExecer(), overwritten); err != nil {
-			return nil, err
-		}
-		if err := deleteWebDAVLocks(ctx, tx.QueryExecer(), overwritten); err != nil {
+		if err := deleteWebDAVProtocolState(ctx, tx.QueryExecer(), overwritten); err != nil {
 			return nil, err
 		}
 	}
@@ -541,7 +584,8 @@ func copyDifferentS3ObjectTx(
 		return nil, err
 	}
 	if replacedFileID != 0 && replacedFileID != sourceInfo.Link.FileId {
-		if err := markFilePendingIfUnreferenced(ctx, tx.QueryExecer(), replacedFileID, now); err != nil {
+		replaced := linkVersion(destinationInfo.Link)
+		if err := d.retireReplacedFileTx(ctx, tx.QueryExecer(), destination, replaced, now); err != nil {
 			return nil, err
 		}
 	}
@@ -569,26 +613,15 @@ func (d *defaultFileManager) DeleteS3Object(
 		if !exists {
 			return nil
 		}
-		if _, err := tx.Remove(ctx, objectPath); err != nil {
-			return fmt.Errorf("remove S3 object mapping: %w", err)
-		}
-		if err := deleteS3Metadata(ctx, tx.QueryExecer(), current.Link.EntryID); err != nil {
-			return err
-		}
-		removed := []directory.IDirectoryEntry{linkDirectoryEntry{link: current.Link}}
-		if err := deleteWebDAVProperties(ctx, tx.QueryExecer(), removed); err != nil {
-			return err
-		}
-		if err := deleteWebDAVLocks(ctx, tx.QueryExecer(), removed); err != nil {
+		origin := trashOriginFromContext(ctx, TrashOrigin{Protocol: TrashProtocolS3})
+		trashed, err := d.trashTreeTx(ctx, tx, objectPath, origin)
+		if err != nil {
 			return err
 		}
-		if err := markFilePendingIfUnreferenced(
-			ctx,
-			tx.QueryExecer(),
-			current.Link.FileId,
-			time.Now().UnixMilli(),
-		); err != nil {
-			return err
+		if !trashed {
+			if err := removeS3ObjectTx(ctx, tx, objectPath, current); err != nil {
+				return err
+			}
 		}
 		deleted = true
 		return nil
@@ -599,6 +632,30 @@ func (d *defaultFileManager) DeleteS3Object(
 	return deleted, nil
 }
 
+func removeS3ObjectTx(
+	ctx context.Context,
+	tx directory.ITransaction,
+	objectPath string,
+	current *S3ObjectInfo,
+) error {
+	if _, err := tx.Remove(ctx, objectPath); err != nil {
+		return fmt.Errorf("remove S3 object mapping: %w", err)
+	}
+	if err := deleteS3Metadata(ctx, tx.QueryExecer(), current.Link.EntryID); err != nil {
+		return err
+	}
+	removed := []directory.IDirectoryEntry{linkDirectoryEntry{link: current.Link}}
+	if err := deleteWebDAVProtocolState(ctx, tx.QueryExecer(), removed); err != nil {
+		return err
+	}
+	return markFilePendingIfUnreferenced(
+		ctx,
+		tx.QueryExecer(),
+		current.Link.FileId,
+		time.Now().UnixMilli(),
+	)
+}
+
 type s3ListPageEntry struct {
 	key                    string
 	refData                string
@@ -701,6 +758,10 @@ func (d *defaultFileManager) appendS3ListEntry(
 	if !found {
 		metadata = legacyS3Metadata(link)
 	}
+	class, err := d.fileStorageClass(ctx, fileID)
+	if err != nil {
+		return err
+	}
 	result.Items = append(result.Items, S3ListItem{
 		Key:               entry.key,
 		Size:              link.FileSize,
@@ -708,6 +769,7 @@ func (d *defaultFileManager) appendS3ListEntry(
 		ETag:              metadata.ETag,
 		ChecksumAlgorithm: metadata.RequestChecksumAlgorithm,
 		ChecksumType:      metadata.ChecksumType,
+		StorageClass:      class,
 	})
 	return nil
 }
@@ -0,0 +1,612 @@
+package filemgr
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/xxxsen/common/database"
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+
+	"github.com/xxxsen/tgfile/directory"
+)
+
+const (
+	SnapshotOriginAPI      = "api"
+	SnapshotOriginCLI      = "cli"
+	SnapshotOriginSchedule = "schedule"
+
+	// MaxSnapshotNameLength bounds snapshot names; scheduled names append a
+	// 17 byte timestamp suffix to the schedule name.
+	MaxSnapshotNameLength = 64
+
+	snapshotWorkerInterval    = time.Minute
+	snapshotNameTimeLayout    = "20060102T150405Z"
+	maxSnapshotEntryListLimit = 1000
+)
+
+// SnapshotSchedule takes a snapshot of Scope every Interval. Its snapshots
+// are named <Name>-<UTC timestamp>.
+type SnapshotSchedule struct {
+	Name     string
+	Scope    string
+	Interval time.Duration
+}
+
+// SnapshotOptions enables namespace snapshots. Retention is the default
+// lifetime of a snapshot; zero keeps snapshots until they are deleted.
+type SnapshotOptions struct {
+	Retention time.Duration
+	Schedules []SnapshotSchedule
+}
+
+// SnapshotInfo describes a snapshot. ExpiresAt is zero for a snapshot that
+// is kept until it is deleted.
+type SnapshotInfo struct {
+	SnapshotID   uint64
+	Name         string
+	Scope        string
+	Origin       string
+	ScheduleName string
+	Principal    string
+	FileCount    int64
+	DirCount     int64
+	TotalSize    int64
+	CreatedAt    int64
+	ExpiresAt    int64
+}
+
+// SnapshotEntry is one frozen mapping. Path is relative to the snapshot
+// scope, with "/" naming the scope itself.
+type SnapshotEntry struct {
+	Path   string
+	Name   string
+	IsDir  bool
+	FileID uint64
+	Size   int64
+	Mode   uint32
+	Ctime  int64
+	Mtime  int64
+}
+
+// SnapshotCreateRequest names a new snapshot of Scope. A zero Retention uses
+// the configured default.
+type SnapshotCreateRequest struct {
+	Name         string
+	Scope        string
+	Origin       string
+	ScheduleName string
+	Principal    string
+	Retention    time.Duration
+}
+
+// SnapshotEntryListRequest pages the children of Dir in name order. Cursor
+// is the NextCursor of the previous page, empty for the first page.
+type SnapshotEntryListRequest struct {
+	Name   string
+	Dir    string
+	Cursor string
+	Limit  int
+}
+
+type SnapshotEntryListResult struct {
+	Entries    []SnapshotEntry
+	NextCursor string
+}
+
+// SnapshotRestoreRequest copies the snapshot subtree at Path back into the
+// namespace at Destination, which defaults to where Path was taken from.
+// Without Overwrite an existing file at a restored path is a conflict; with
+// it the existing file is deleted, through the recycle bin when enabled.
+type SnapshotRestoreRequest struct {
+	Name        string
+	Path        string
+	Destination string
+	Overwrite   bool
+	Principal   string
+}
+
+type SnapshotRestoreResult struct {
+	Destination string
+	Files       int64
+	Directories int64
+}
+
+// ISnapshotManager keeps named, read-only copies of namespace subtrees.
+// Snapshot entries pin their Files, so the block delete worker leaves them
+// alone until the snapshot is deleted or expires.
+type ISnapshotManager interface {
+	SnapshotsEnabled() bool
+	CreateSnapshot(ctx context.Context, request SnapshotCreateRequest) (*SnapshotInfo, error)
+	ListSnapshots(ctx context.Context) ([]SnapshotInfo, error)
+	GetSnapshot(ctx context.Context, name string) (*SnapshotInfo, error)
+	StatSnapshotEntry(ctx context.Context, name, entryPath string) (*SnapshotEntry, error)
+	ListSnapshotEntries(ctx context.Context, request SnapshotEntryListRequest) (*SnapshotEntryListResult, error)
+	RestoreSnapshot(ctx context.Context, request SnapshotRestoreRequest) (*SnapshotRestoreResult, error)
+	DeleteSnapshot(ctx context.Context, name string) (*SnapshotInfo, error)
+	RunSnapshotWorker(ctx context.Context) error
+}
+
+// WithSnapshots enables scheduled snapshots and snapshot expiry.
+func WithSnapshots(options SnapshotOptions) Option {
+	return func(d *defaultFileManager) {
+		d.snapshots = &options
+	}
+}
+
+// ValidSnapshotName reports whether name can be used as a snapshot name and
+// as a single WebDAV path segment.
+func ValidSnapshotName(name string) bool {
+	if name == "" || len(name) > MaxSnapshotNameLength || name[0] == '.' || name[0] == '-' {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.' || r == '_' || r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (d *defaultFileManager) SnapshotsEnabled() bool {
+	return d.snapshots != nil
+}
+
+func (d *defaultFileManager) CreateSnapshot(
+	ctx context.Context,
+	request SnapshotCreateRequest,
+) (*SnapshotInfo, error) {
+	scope := path.Clean(request.Scope)
+	if !ValidSnapshotName(request.Name) || !strings.HasPrefix(request.Scope, "/") || request.Retention < 0 {
+		return nil, fmt.Errorf("%w: name %q scope %q", ErrInvalidSnapshotRequest, request.Name, request.Scope)
+	}
+	retention := request.Retention
+	if retention == 0 && d.snapshots != nil {
+		retention = d.snapshots.Retention
+	}
+	now := time.Now().UnixMilli()
+	info := &SnapshotInfo{
+		Name:         request.Name,
+		Scope:        scope,
+		Origin:       request.Origin,
+		ScheduleName: request.ScheduleName,
+		Principal:    request.Principal,
+		CreatedAt:    now,
+	}
+	if retention > 0 {
+		info.ExpiresAt = now + retention.Milliseconds()
+	}
+	err := d.objectDir.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
+		return createSnapshotTx(ctx, tx, info)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create snapshot %s: %w", request.Name, err)
+	}
+	return info, nil
+}
+
+func createSnapshotTx(ctx context.Context, tx directory.ITransaction, info *SnapshotInfo) error {
+	root, exists, err := tx.Stat(ctx, info.Scope)
+	if err != nil {
+		return fmt.Errorf("stat snapshot scope: %w", err)
+	}
+	if !exists {
+		return os.ErrNotExist
+	}
+	if !root.IsDir() {
+		return fmt.Errorf("%w: scope %s is not a directory", ErrInvalidSnapshotRequest, info.Scope)
+	}
+	queryExecer := tx.QueryExecer()
+	var used int64
+	if err := queryRow(
+		ctx,
+		queryExecer,
+		"SELECT COUNT(*) FROM tg_snapshot_tab WHERE snapshot_name = ?",
+		info.Name,
+	).Scan(&used); err != nil {
+		return fmt.Errorf("check snapshot name: %w", err)
+	}
+	if used != 0 {
+		return ErrSnapshotExists
+	}
+	result, err := queryExecer.ExecContext(
+		ctx,
+		`INSERT INTO tg_snapshot_tab (
+snapshot_name, scope, origin, schedule_name, principal, file_count, dir_count, total_size, created_at, expires_at
+) VALUES (?, ?, ?, ?, ?, 0, 1, 0, ?, ?)`,
+		info.Name, info.Scope, info.Origin, info.ScheduleName, info.Principal, info.CreatedAt, info.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert snapshot: %w", err)
+	}
+	snapshotID, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("read snapshot id: %w", err)
+	}
+	info.SnapshotID = uint64(snapshotID)
+	if err := copySnapshotEntries(ctx, queryExecer, info.SnapshotID, root.EntryID()); err != nil {
+		return err
+	}
+	return summarizeSnapshot(ctx, queryExecer, info)
+}
+
+// copySnapshotEntries freezes the mapping subtree below rootID in a single
+// statement, so the copy sees one consistent state of the namespace.
+func copySnapshotEntries(
+	ctx context.Context,
+	exec database.IExecer,
+	snapshotID, rootID uint64,
+) error {
+	if _, err := exec.ExecContext(
+		ctx,
+		`WITH RECURSIVE tree (entry_id, entry_path, parent_path, entry_name, file_kind,
+ref_data, file_size, file_mode, ctime, mtime) AS (
+  SELECT entry_id, '/', '', '', file_kind, ref_data, file_size, file_mode, ctime, mtime
+  FROM tg_file_mapping_tab WHERE entry_id = ?
+  UNION ALL
+  SELECT child.entry_id,
+         CASE WHEN tree.entry_path = '/' THEN '/' || child.file_name
+              ELSE tree.entry_path || '/' || child.file_name END,
+         tree.entry_path, child.file_name, child.file_kind, child.ref_data,
+         child.file_size, child.file_mode, child.ctime, child.mtime
+  FROM tg_file_mapping_tab child
+  JOIN tree ON child.parent_entry_id = tree.entry_id AND tree.file_kind = 1
+)
+INSERT INTO tg_snapshot_entry_tab (
+snapshot_id, entry_path, parent_path, entry_name, is_dir, file_id, file_size, file_mode, ctime, mtime
+)
+SELECT ?, entry_path, parent_path, entry_name, file_kind = 1,
+       CASE WHEN file_kind = 1 THEN 0 ELSE CAST(ref_data AS INTEGER) END,
+       CASE WHEN file_kind = 1 THEN 0 ELSE file_size END,
+       file_mode, ctime, mtime
+FROM tree`,
+		rootID,
+		snapshotID,
+	); err != nil {
+		return fmt.Errorf("copy snapshot entries: %w", err)
+	}
+	return nil
+}
+
+func summarizeSnapshot(ctx context.Context, queryExecer database.IQueryExecer, info *SnapshotInfo) error {
+	if err := queryRow(
+		ctx,
+		queryExecer,
+		`SELECT COALESCE(SUM(is_dir = 0), 0), COALESCE(SUM(is_dir), 0), COALESCE(SUM(file_size), 0)
+FROM tg_snapshot_entry_tab WHERE snapshot_id = ?`,
+		info.SnapshotID,
+	).Scan(&info.FileCount, &info.DirCount, &info.TotalSize); err != nil {
+		return fmt.Errorf("summarize snapshot: %w", err)
+	}
+	if _, err := queryExecer.ExecContext(
+		ctx,
+		"UPDATE tg_snapshot_tab SET file_count = ?, dir_count = ?, total_size = ? WHERE snapshot_id = ?",
+		info.FileCount,
+		info.DirCount,
+		info.TotalSize,
+		info.SnapshotID,
+	); err != nil {
+		return fmt.Errorf("update snapshot summary: %w", err)
+	}
+	return nil
+}
+
+func (d *defaultFileManager) ListSnapshots(ctx context.Context) ([]SnapshotInfo, error) {
+	rows, err := d.dbc.QueryContext(
+		ctx,
+		`SELECT `+snapshotColumns+` FROM tg_snapshot_tab ORDER BY created_at DESC, snapshot_id DESC`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query snapshots: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	result := make([]SnapshotInfo, 0)
+	for rows.Next() {
+		info, err := scanSnapshotInfo(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate snapshots: %w", err)
+	}
+	return result, nil
+}
+
+func (d *defaultFileManager) GetSnapshot(ctx context.Context, name string) (*SnapshotInfo, error) {
+	return readSnapshotInfo(ctx, d.dbc, name)
+}
+
+func (d *defaultFileManager) StatSnapshotEntry(
+	ctx context.Context,
+	name, entryPath string,
+) (*SnapshotEntry, error) {
+	cleaned, ok := cleanSnapshotPath(entryPath)
+	if !ok {
+		return nil, fmt.Errorf("%w: path %q", ErrInvalidSnapshotRequest, entryPath)
+	}
+	info, err := readSnapshotInfo(ctx, d.dbc, name)
+	if err != nil {
+		return nil, err
+	}
+	return readSnapshotEntry(ctx, d.dbc, info.SnapshotID, cleaned)
+}
+
+func (d *defaultFileManager) ListSnapshotEntries(
+	ctx context.Context,
+	request SnapshotEntryListRequest,
+) (*SnapshotEntryListResult, error) {
+	dir, ok := cleanSnapshotPath(request.Dir)
+	if !ok || request.Limit <= 0 || request.Limit > maxSnapshotEntryListLimit {
+		return nil, fmt.Errorf("%w: dir %q limit %d", ErrInvalidSnapshotRequest, request.Dir, request.Limit)
+	}
+	info, err := readSnapshotInfo(ctx, d.dbc, request.Name)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := readSnapshotEntry(ctx, d.dbc, info.SnapshotID, dir)
+	if err != nil {
+		return nil, err
+	}
+	if !parent.IsDir {
+		return nil, ErrNotDirectory
+	}
+	rows, err := d.dbc.QueryContext(
+		ctx,
+		`SELECT `+snapshotEntryColumns+` FROM tg_snapshot_entry_tab
+WHERE snapshot_id = ? AND parent_path = ? AND entry_name > ?
+ORDER BY entry_name LIMIT ?`,
+		info.SnapshotID,
+		dir,
+		request.Cursor,
+		request.Limit+1,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query snapshot entries: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	result := &SnapshotEntryListResult{Entries: make([]SnapshotEntry, 0, request.Limit)}
+	for rows.Next() {
+		entry, err := scanSnapshotEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Entries) == request.Limit {
+			result.NextCursor = result.Entries[len(result.Entries)-1].Name
+			break
+		}
+		result.Entries = append(result.Entries, *entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate snapshot entries: %w", err)
+	}
+	return result, nil
+}
+
+// DeleteSnapshot drops a snapshot and releases its pins. Files that nothing
+// else references become pending for the block delete worker.
+func (d *defaultFileManager) DeleteSnapshot(ctx context.Context, name string) (*SnapshotInfo, error) {
+	var deleted *SnapshotInfo
+	err := d.dbc.OnTransation(ctx, func(ctx context.Context, tx database.IQueryExecer) error {
+		info, err := readSnapshotInfo(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+		fileIDs, err := queryColumnList[uint64](
+			ctx,
+			tx,
+			"SELECT DISTINCT file_id FROM tg_snapshot_entry_tab WHERE snapshot_id = ? AND is_dir = 0",
+			info.SnapshotID,
+		)
+		if err != nil {
+			return fmt.Errorf("query snapshot files: %w", err)
+		}
+		for _, statement := range []string{
+			"DELETE FROM tg_snapshot_entry_tab WHERE snapshot_id = ?",
+			"DELETE FROM tg_snapshot_tab WHERE snapshot_id = ?",
+		} {
+			if _, err := tx.ExecContext(ctx, statement, info.SnapshotID); err != nil {
+				return fmt.Errorf("delete snapshot rows: %w", err)
+			}
+		}
+		now := time.Now().UnixMilli()
+		for _, fileID := range fileIDs {
+			if err := markFileTreePendingIfUnreferenced(ctx, tx, fileID, now); err != nil {
+				return err
+			}
+		}
+		deleted = info
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("delete snapshot %s: %w", name, err)
+	}
+	return deleted, nil
+}
+
+// RunSnapshotWorker takes scheduled snapshots and deletes expired ones. It
+// returns at once when snapshots are disabled.
+func (d *defaultFileManager) RunSnapshotWorker(ctx context.Context) error {
+	if !d.SnapshotsEnabled() {
+		return nil
+	}
+	d.runSnapshotPass(ctx, time.Now())
+	ticker := time.NewTicker(snapshotWorkerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			d.runSnapshotPass(ctx, now)
+		}
+	}
+}
+
+func (d *defaultFileManager) runSnapshotPass(ctx context.Context, now time.Time) {
+	for _, schedule := range d.snapshots.Schedules {
+		if err := d.takeScheduledSnapshot(ctx, schedule, now); err != nil {
+			logutil.GetLogger(ctx).Error(
+				"scheduled snapshot failed",
+				zap.String("schedule", schedule.Name),
+				zap.Error(err),
+			)
+		}
+	}
+	if _, err := d.deleteExpiredSnapshots(ctx, now); err != nil {
+		logutil.GetLogger(ctx).Error(
+			"snapshot expiry cleanup failed",
+			zap.String("error_code", "database"),
+		)
+	}
+}
+
+// takeScheduledSnapshot snapshots the schedule scope when its last snapshot
+// is at least one interval old.
+func (d *defaultFileManager) takeScheduledSnapshot(
+	ctx context.Context,
+	schedule SnapshotSchedule,
+	now time.Time,
+) error {
+	var last int64
+	if err := queryRow(
+		ctx,
+		d.dbc,
+		"SELECT COALESCE(MAX(created_at), 0) FROM tg_snapshot_tab WHERE schedule_name = ?",
+		schedule.Name,
+	).Scan(&last); err != nil {
+		return fmt.Errorf("read last scheduled snapshot: %w", err)
+	}
+	if last != 0 && now.Sub(time.UnixMilli(last)) < schedule.Interval {
+		return nil
+	}
+	_, err := d.CreateSnapshot(ctx, SnapshotCreateRequest{
+		Name:         schedule.Name + "-" + now.UTC().Format(snapshotNameTimeLayout),
+		Scope:        schedule.Scope,
+		Origin:       SnapshotOriginSchedule,
+		ScheduleName: schedule.Name,
+	})
+	return err
+}
+
+func (d *defaultFileManager) deleteExpiredSnapshots(ctx context.Context, now time.Time) (int, error) {
+	names, err := queryColumnList[string](
+		ctx,
+		d.dbc,
+		`SELECT snapshot_name FROM tg_snapshot_tab WHERE expires_at != 0 AND expires_at <= ?
+ORDER BY expires_at, snapshot_id`,
+		now.UnixMilli(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("query expired snapshots: %w", err)
+	}
+	deleted := 0
+	for _, name := range names {
+		if _, err := d.DeleteSnapshot(ctx, name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// cleanSnapshotPath normalizes a snapshot entry path; an empty path names
+// the snapshot root.
+func cleanSnapshotPath(value string) (string, bool) {
+	if value == "" {
+		return "/", true
+	}
+	if !strings.HasPrefix(value, "/") {
+		return "", false
+	}
+	return path.Clean(value), true
+}
+
+const snapshotColumns = `snapshot_id, snapshot_name, scope, origin, schedule_name, principal,
+file_count, dir_count, total_size, created_at, expires_at`
+
+const snapshotEntryColumns = `entry_path, entry_name, is_dir, file_id, file_size, file_mode, ctime, mtime`
+
+func readSnapshotInfo(ctx context.Context, queryer database.IQueryer, name string) (*SnapshotInfo, error) {
+	info, err := scanSnapshotInfo(queryRow(
+		ctx,
+		queryer,
+		`SELECT `+snapshotColumns+` FROM tg_snapshot_tab WHERE snapshot_name = ?`,
+		name,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, os.ErrNotExist
+	}
+	return info, err
+}
+
+func readSnapshotEntry(
+	ctx context.Context,
+	queryer database.IQueryer,
+	snapshotID uint64,
+	entryPath string,
+) (*SnapshotEntry, error) {
+	entry, err := scanSnapshotEntry(queryRow(
+		ctx,
+		queryer,
+		`SELECT `+snapshotEntryColumns+` FROM tg_snapshot_entry_tab
+WHERE snapshot_id = ? AND entry_path = ?`,
+		snapshotID,
+		entryPath,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, os.ErrNotExist
+	}
+	return entry, err
+}
+
+func scanSnapshotInfo(scanner rowScanner) (*SnapshotInfo, error) {
+	var info SnapshotInfo
+	if err := scanner.Scan(
+		&info.SnapshotID,
+		&info.Name,
+		&info.Scope,
+		&info.Origin,
+		&info.ScheduleName,
+		&info.Principal,
+		&info.FileCount,
+		&info.DirCount,
+		&info.TotalSize,
+		&info.CreatedAt,
+		&info.ExpiresAt,
+	); err != nil {
+		return nil, fmt.Errorf("scan snapshot: %w", err)
+	}
+	return &info, nil
+}
+
+func scanSnapshotEntry(scanner rowScanner) (*SnapshotEntry, error) {
+	var entry SnapshotEntry
+	if err := scanner.Scan(
+		&entry.Path,
+		&entry.Name,
+		&entry.IsDir,
+		&entry.FileID,
+		&entry.Size,
+		&entry.Mode,
+		&entry.Ctime,
+		&entry.Mtime,
+	); err != nil {
+		return nil, fmt.Errorf("scan snapshot entry: %w", err)
+	}
+	return &entry, nil
+}
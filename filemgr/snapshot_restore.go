@@ -0,0 +1,180 @@
+package filemgr
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/directory"
+)
+
+type snapshotRestorer struct {
+	manager *defaultFileManager
+	tx      directory.ITransaction
+	request SnapshotRestoreRequest
+	result  *SnapshotRestoreResult
+}
+
+// RestoreSnapshot copies a snapshot subtree back in one transaction, so a
+// conflict part way through leaves the namespace untouched. Restored files
+// link the pinned Files again; no content is uploaded.
+func (d *defaultFileManager) RestoreSnapshot(
+	ctx context.Context,
+	request SnapshotRestoreRequest,
+) (*SnapshotRestoreResult, error) {
+	source, ok := cleanSnapshotPath(request.Path)
+	if !ok || (request.Destination != "" && !strings.HasPrefix(request.Destination, "/")) {
+		return nil, fmt.Errorf("%w: path %q destination %q", ErrInvalidSnapshotRequest, request.Path, request.Destination)
+	}
+	var result *SnapshotRestoreResult
+	err := d.objectDir.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
+		info, err := readSnapshotInfo(ctx, tx.QueryExecer(), request.Name)
+		if err != nil {
+			return err
+		}
+		entries, err := readSnapshotSubtree(ctx, tx.QueryExecer(), info.SnapshotID, source)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return os.ErrNotExist
+		}
+		destination := path.Join(info.Scope, source)
+		if request.Destination != "" {
+			destination = path.Clean(request.Destination)
+		}
+		if destination == "/" && !entries[0].IsDir {
+			return fmt.Errorf("%w: a file cannot replace the root", ErrInvalidSnapshotRequest)
+		}
+		restorer := &snapshotRestorer{
+			manager: d,
+			tx:      tx,
+			request: request,
+			result:  &SnapshotRestoreResult{Destination: destination},
+		}
+		if err := restorer.ensureDirectories(ctx, path.Dir(destination)); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			target := path.Join(destination, strings.TrimPrefix(entry.Path, source))
+			if err := restorer.restoreEntry(ctx, &entry, target); err != nil {
+				return err
+			}
+		}
+		result = restorer.result
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("restore snapshot %s: %w", request.Name, err)
+	}
+	return result, nil
+}
+
+// readSnapshotSubtree returns the entry at source and everything below it,
+// parents before children.
+func readSnapshotSubtree(
+	ctx context.Context,
+	queryer database.IQueryer,
+	snapshotID uint64,
+	source string,
+) ([]SnapshotEntry, error) {
+	prefix := strings.TrimSuffix(source, "/") + "/"
+	rows, err := queryer.QueryContext(
+		ctx,
+		`SELECT `+snapshotEntryColumns+` FROM tg_snapshot_entry_tab
+WHERE snapshot_id = ? AND (entry_path = ? OR substr(entry_path, 1, ?) = ?)
+ORDER BY entry_path`,
+		snapshotID,
+		source,
+		len(prefix),
+		prefix,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query snapshot subtree: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	entries := make([]SnapshotEntry, 0)
+	for rows.Next() {
+		entry, err := scanSnapshotEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate snapshot subtree: %w", err)
+	}
+	return entries, nil
+}
+
+func (r *snapshotRestorer) restoreEntry(ctx context.Context, entry *SnapshotEntry, target string) error {
+	current, exists, err := r.tx.Stat(ctx, target)
+	if err != nil {
+		return fmt.Errorf("stat snapshot restore target: %w", err)
+	}
+	if exists {
+		if entry.IsDir && current.IsDir() {
+			return nil
+		}
+		if !entry.IsDir && current.RefData() == strconv.FormatUint(entry.FileID, 10) {
+			r.result.Files++
+			return nil
+		}
+		if !r.request.Overwrite || target == "/" {
+			return fmt.Errorf("%w: %s", ErrSnapshotConflict, target)
+		}
+		origin := TrashOrigin{Principal: r.request.Principal, Protocol: TrashProtocolSnapshot}
+		if err := r.manager.removeOrTrashTx(ctx, r.tx, target, origin); err != nil {
+			return err
+		}
+	}
+	if entry.IsDir {
+		if _, err := r.tx.Mkdir(ctx, target); err != nil {
+			return fmt.Errorf("restore snapshot directory: %w", err)
+		}
+		r.result.Directories++
+		return nil
+	}
+	if err := ensureFileTreeCanBeLinked(ctx, r.tx.QueryExecer(), entry.FileID); err != nil {
+		return err
+	}
+	if _, err := r.tx.Create(ctx, target, entry.Size, strconv.FormatUint(entry.FileID, 10)); err != nil {
+		return fmt.Errorf("restore snapshot file: %w", err)
+	}
+	if err := r.tx.Touch(ctx, target, entry.Mtime); err != nil {
+		return fmt.Errorf("restore snapshot file time: %w", err)
+	}
+	r.result.Files++
+	return nil
+}
+
+// ensureDirectories creates the missing collections of dir, which must not
+// run through a file.
+func (r *snapshotRestorer) ensureDirectories(ctx context.Context, dir string) error {
+	if dir == "/" {
+		return nil
+	}
+	if err := r.ensureDirectories(ctx, path.Dir(dir)); err != nil {
+		return err
+	}
+	current, exists, err := r.tx.Stat(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("stat snapshot restore parent: %w", err)
+	}
+	if exists && !current.IsDir() {
+		return fmt.Errorf("%w: %s", ErrSnapshotConflict, dir)
+	}
+	if !exists {
+		if _, err := r.tx.Mkdir(ctx, dir); err != nil {
+			return fmt.Errorf("create snapshot restore parent: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package filemgr
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotPinsFilesAndRestores(t *testing.T) {
+	managerInterface, _, databaseClient := newCreateFileTestManager(t, 32)
+	manager := managerInterface.(*defaultFileManager)
+	publish := func(resourcePath, content string) uint64 {
+		fileID, err := manager.CreateFile(t.Context(), int64(len(content)), bytes.NewReader([]byte(content)))
+		require.NoError(t, err)
+		_, err = manager.PublishWebDAVFile(t.Context(), resourcePath, fileID, int64(len(content)), WebDAVMutationOptions{})
+		require.NoError(t, err)
+		return fileID
+	}
+	pending := func(fileID uint64) int {
+		return queryCount(t, databaseClient, `SELECT COUNT(*) FROM tg_file_part_delete_state_tab
+WHERE delete_state = 'pending' AND file_id = `+strconv.FormatUint(fileID, 10))
+	}
+	require.NoError(t, manager.CreateFileLink(t.Context(), "/docs/sub", 0, 0, true))
+	first := publish("/docs/a.txt", "first")
+	second := publish("/docs/sub/b.txt", "second")
+
+	info, err := manager.CreateSnapshot(t.Context(), SnapshotCreateRequest{
+		Name: "before", Scope: "/docs", Origin: SnapshotOriginCLI,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), info.FileCount)
+	require.Equal(t, int64(2), info.DirCount)
+	require.Equal(t, int64(len("first")+len("second")), info.TotalSize)
+	require.Zero(t, info.ExpiresAt)
+	_, err = manager.CreateSnapshot(t.Context(), SnapshotCreateRequest{Name: "before", Scope: "/docs"})
+	require.ErrorIs(t, err, ErrSnapshotExists)
+	_, err = manager.CreateSnapshot(t.Context(), SnapshotCreateRequest{Name: ".hidden", Scope: "/docs"})
+	require.ErrorIs(t, err, ErrInvalidSnapshotRequest)
+	_, err = manager.CreateSnapshot(t.Context(), SnapshotCreateRequest{Name: "file", Scope: "/docs/a.txt"})
+	require.ErrorIs(t, err, ErrInvalidSnapshotRequest)
+
+	require.NoError(t, manager.DeleteWebDAVResource(t.Context(), "/docs", WebDAVMutationOptions{}))
+	require.Zero(t, pending(first))
+	require.Zero(t, pending(second))
+
+	page, err := manager.ListSnapshotEntries(t.Context(), SnapshotEntryListRequest{Name: "before", Dir: "/", Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	require.Equal(t, "/a.txt", page.Entries[0].Path)
+	require.Equal(t, "a.txt", page.NextCursor)
+	page, err = manager.ListSnapshotEntries(t.Context(), SnapshotEntryListRequest{
+		Name: "before", Dir: "/", Cursor: page.NextCursor, Limit: 1,
+	})
+	require.NoError(t, err)
+	require.Len(t, page.Entries, 1)
+	require.True(t, page.Entries[0].IsDir)
+	require.Empty(t, page.NextCursor)
+	entry, err := manager.StatSnapshotEntry(t.Context(), "before", "/sub/b.txt")
+	require.NoError(t, err)
+	require.Equal(t, second, entry.FileID)
+	_, err = manager.StatSnapshotEntry(t.Context(), "before", "/missing")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	result, err := manager.RestoreSnapshot(t.Context(), SnapshotRestoreRequest{Name: "before", Path: "/sub/b.txt"})
+	require.NoError(t, err)
+	require.Equal(t, "/docs/sub/b.txt", result.Destination)
+	require.Equal(t, int64(1), result.Files)
+	requireFileContent(t, manager, "/docs/sub/b.txt", "second")
+
+	replacement := publish("/docs/a.txt", "changed")
+	_, err = manager.RestoreSnapshot(t.Context(), SnapshotRestoreRequest{Name: "before"})
+	require.ErrorIs(t, err, ErrSnapshotConflict)
+	result, err = manager.RestoreSnapshot(t.Context(), SnapshotRestoreRequest{Name: "before", Overwrite: true})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), result.Files)
+	requireFileContent(t, manager, "/docs/a.txt", "first")
+	require.Equal(t, 1, pending(replacement))
+
+	result, err = manager.RestoreSnapshot(t.Context(), SnapshotRestoreRequest{
+		Name: "before", Path: "/sub", Destination: "/copies/sub",
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), result.Directories)
+	requireFileContent(t, manager, "/copies/sub/b.txt", "second")
+
+	require.NoError(t, manager.DeleteWebDAVResource(t.Context(), "/docs", WebDAVMutationOptions{}))
+	require.Zero(t, pending(first))
+	deleted, err := manager.DeleteSnapshot(t.Context(), "before")
+	require.NoError(t, err)
+	require.Equal(t, "/docs", deleted.Scope)
+	require.Equal(t, 1, pending(first))
+	require.Zero(t, pending(second))
+	_, err = manager.GetSnapshot(t.Context(), "before")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSnapshotWorkerSchedulesAndExpires(t *testing.T) {
+	managerInterface, _, _ := newCreateFileTestManager(t, 32)
+	manager := managerInterface.(*defaultFileManager)
+	require.NoError(t, manager.CreateFileLink(t.Context(), "/data", 0, 0, true))
+	WithSnapshots(SnapshotOptions{
+		Retention: time.Hour,
+		Schedules: []SnapshotSchedule{{Name: "hourly", Scope: "/data", Interval: time.Hour}},
+	})(manager)
+
+	now := time.Now()
+	manager.runSnapshotPass(t.Context(), now)
+	manager.runSnapshotPass(t.Context(), now.Add(time.Minute))
+	snapshots, err := manager.ListSnapshots(t.Context())
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	require.Equal(t, "hourly-"+now.UTC().Format(snapshotNameTimeLayout), snapshots[0].Name)
+	require.Equal(t, SnapshotOriginSchedule, snapshots[0].Origin)
+	require.Equal(t, "hourly", snapshots[0].ScheduleName)
+	require.NotZero(t, snapshots[0].ExpiresAt)
+
+	deleted, err := manager.deleteExpiredSnapshots(t.Context(), now.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	snapshots, err = manager.ListSnapshots(t.Context())
+	require.NoError(t, err)
+	require.Empty(t, snapshots)
+}
+
+func requireFileContent(t *testing.T, manager *defaultFileManager, link, expected string) {
+	t.Helper()
+	meta, err := manager.StatFileLink(t.Context(), link)
+	require.NoError(t, err)
+	stream, err := manager.OpenFile(t.Context(), meta.FileId)
+	require.NoError(t, err)
+	defer stream.Close()
+	content, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	require.Equal(t, expected, string(content))
+}
@@ -0,0 +1,167 @@
+package filemgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xxxsen/tgfile/blockio"
+)
+
+// StandardStorageClass is the class S3 clients assume when a request carries
+// no x-amz-storage-class header.
+const StandardStorageClass = "STANDARD"
+
+var ErrInvalidStorageClass = errors.New("invalid storage class")
+
+// Option customizes a file manager created by NewFileManager.
+type Option func(d *defaultFileManager)
+
+// WithPrimaryStorageClass names the storage class served by the primary
+// backend passed to NewFileManager. The default name is STANDARD.
+func WithPrimaryStorageClass(class string) Option {
+	return func(d *defaultFileManager) {
+		d.primaryClass = class
+	}
+}
+
+// WithStorageTier adds a backend that serves an additional storage class.
+// Every tier must use a backend kind distinct from the other tiers and the
+// primary backend, since stored files and pending block deletions are bound
+// to a backend by its kind.
+func WithStorageTier(class string, bkio blockio.IBlockIO) Option {
+	return func(d *defaultFileManager) {
+		d.tiers = append(d.tiers, storageTier{class: class, bkio: bkio})
+	}
+}
+
+type storageTier struct {
+	class string
+	bkio  blockio.IBlockIO
+}
+
+type storageClassKey struct{}
+
+// ContextWithStorageClass selects the storage class used by files created
+// with ctx. An empty class selects the default class.
+func ContextWithStorageClass(ctx context.Context, class string) context.Context {
+	return context.WithValue(ctx, storageClassKey{}, class)
+}
+
+func storageClassFromContext(ctx context.Context) string {
+	class, _ := ctx.Value(storageClassKey{}).(string)
+	return class
+}
+
+func (d *defaultFileManager) initStorageTiers() {
+	if d.primaryClass == "" {
+		d.primaryClass = StandardStorageClass
+	}
+	d.backends = map[string]blockio.IBlockIO{d.bkio.Name(): d.bkio}
+	d.classes = map[string]string{d.primaryClass: d.bkio.Name()}
+	d.kindClasses = map[string]string{d.bkio.Name(): d.primaryClass}
+	for _, tier := range d.tiers {
+		d.backends[tier.bkio.Name()] = tier.bkio
+		d.classes[tier.class] = tier.bkio.Name()
+		d.kindClasses[tier.bkio.Name()] = tier.class
+	}
+}
+
+// StorageClassExists reports whether class is served by a configured backend.
+func (d *defaultFileManager) StorageClassExists(class string) bool {
+	_, exists := d.classes[class]
+	return exists
+}
+
+// DefaultStorageClass is STANDARD when a backend serves it, otherwise the
+// class of the primary backend.
+func (d *defaultFileManager) DefaultStorageClass() string {
+	if d.StorageClassExists(StandardStorageClass) {
+		return StandardStorageClass
+	}
+	return d.primaryClass
+}
+
+func (d *defaultFileManager) classBackend(class string) (blockio.IBlockIO, error) {
+	if class == "" {
+		class = d.DefaultStorageClass()
+	}
+	kind, exists := d.classes[class]
+	if !exists {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidStorageClass, class)
+	}
+	return d.backends[kind], nil
+}
+
+// backendFor resolves the backend recorded on a file. Files written before
+// storage tiers record no backend and live on the primary backend.
+func (d *defaultFileManager) backendFor(kind string) (blockio.IBlockIO, error) {
+	if kind == "" {
+		return d.bkio, nil
+	}
+	backend, exists := d.backends[kind]
+	if !exists {
+		return nil, fmt.Errorf("%w: backend %q is not configured", ErrInvalidStorageClass, kind)
+	}
+	return backend, nil
+}
+
+func (d *defaultFileManager) storageClassOf(kind string) string {
+	if kind == "" {
+		return d.primaryClass
+	}
+	if class, exists := d.kindClasses[kind]; exists {
+		return class
+	}
+	return kind
+}
+
+func (d *defaultFileManager) allBackends() []blockio.IBlockIO {
+	backends := make([]blockio.IBlockIO, 0, 1+len(d.tiers))
+	backends = append(backends, d.bkio)
+	for _, tier := range d.tiers {
+		backends = append(backends, tier.bkio)
+	}
+	return backends
+}
+
+// fileStorageClass reports the class of a stored file. A composite file has
+// no blocks of its own and reports the class of its first source file.
+func (d *defaultFileManager) fileStorageClass(ctx context.Context, fileID uint64) (string, error) {
+	info, exists, err := d.internalGetFileInfo(ctx, fileID)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return d.primaryClass, nil
+	}
+	if info.FileLayoutVersion != 2 {
+		return d.storageClassOf(info.BackendKind), nil
+	}
+	rows, err := d.dbc.QueryContext(
+		ctx,
+		`SELECT COALESCE(source.backend_kind, '')
+FROM tg_s3_file_segment_tab segment
+LEFT JOIN tg_file_tab source ON source.file_id = segment.source_file_id
+WHERE segment.file_id = ?
+ORDER BY segment.segment_index
+LIMIT 1`,
+		fileID,
+	)
+	if err != nil {
+		return "", fmt.Errorf("query composite storage class: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	kind := ""
+	if rows.Next() {
+		if err := rows.Scan(&kind); err != nil {
+			return "", fmt.Errorf("scan composite storage class: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("read composite storage class: %w", err)
+	}
+	return d.storageClassOf(kind), nil
+}
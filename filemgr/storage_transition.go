@@ -0,0 +1,236 @@
+package filemgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/blockio"
+)
+
+// swapFileFunc replaces every reference to a transitioned file with its copy
+// and reports whether the reference still pointed at the original file.
+type swapFileFunc func(ctx context.Context, tx database.IQueryExecer, previous, next storedFileRecord) (bool, error)
+
+// fileInfoForgetter is implemented by file DAOs that cache file metadata.
+type fileInfoForgetter interface {
+	ForgetFile(ctx context.Context, fileIDs ...uint64)
+}
+
+// TransitionS3Object moves an object into class by uploading every physical
+// file behind it to the class backend again. The object keeps its entry,
+// metadata and modification time, so the change is not journaled and the
+// ETag is unchanged. The returned flag reports whether any file was moved; an
+// object overwritten meanwhile is left alone.
+func (d *defaultFileManager) TransitionS3Object(ctx context.Context, objectPath, class string) (bool, error) {
+	backend, err := d.classBackend(class)
+	if err != nil {
+		return false, err
+	}
+	info, err := d.StatS3Object(ctx, objectPath)
+	if err != nil {
+		return false, err
+	}
+	record, exists, err := readStoredFile(ctx, d.dbc, info.Link.FileId)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, fmt.Errorf("transition missing file %d: %w", info.Link.FileId, ErrS3ObjectConflict)
+	}
+	switch record.layout {
+	case 1:
+		entryID := info.Link.EntryID
+		return d.transitionFile(ctx, backend, record, func(
+			ctx context.Context,
+			tx database.IQueryExecer,
+			previous, next storedFileRecord,
+		) (bool, error) {
+			return swapMappedFile(ctx, tx, entryID, previous.fileID, next.fileID)
+		})
+	case 2:
+		return d.transitionCompositeFile(ctx, backend, record.fileID)
+	default:
+		return false, fmt.Errorf("%w: file=%d layout=%d", ErrInvalidFileLayout, record.fileID, record.layout)
+	}
+}
+
+func (d *defaultFileManager) transitionCompositeFile(
+	ctx context.Context,
+	backend blockio.IBlockIO,
+	compositeID uint64,
+) (bool, error) {
+	sources, err := compositeSourceFileIDs(ctx, d.dbc, compositeID)
+	if err != nil {
+		return false, err
+	}
+	moved := false
+	for _, sourceID := range sources {
+		source, exists, err := readStoredFile(ctx, d.dbc, sourceID)
+		if err != nil {
+			return moved, err
+		}
+		if !exists {
+			return moved, fmt.Errorf("transition missing source file %d: %w", sourceID, ErrS3ObjectConflict)
+		}
+		swapped, err := d.transitionFile(ctx, backend, source, func(
+			ctx context.Context,
+			tx database.IQueryExecer,
+			previous, next storedFileRecord,
+		) (bool, error) {
+			return swapCompositeSource(ctx, tx, compositeID, previous, next)
+		})
+		if err != nil {
+			return moved, err
+		}
+		if swapped {
+			// The swap rewrote the composite part count behind the DAO.
+			d.forgetFileInfo(ctx, compositeID)
+		}
+		moved = moved || swapped
+	}
+	return moved, nil
+}
+
+func (d *defaultFileManager) forgetFileInfo(ctx context.Context, fileID uint64) {
+	if forgetter, ok := d.fileDao.(fileInfoForgetter); ok {
+		forgetter.ForgetFile(ctx, fileID)
+	}
+}
+
+// transitionFile copies a physical file to backend and swaps the copy in. The
+// original is queued for block deletion once nothing references it; the copy
+// is discarded when the swap finds the reference already changed.
+func (d *defaultFileManager) transitionFile(
+	ctx context.Context,
+	backend blockio.IBlockIO,
+	record storedFileRecord,
+	swap swapFileFunc,
+) (bool, error) {
+	info, exists, err := d.internalGetFileInfo(ctx, record.fileID)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return false, fmt.Errorf("transition missing file %d: %w", record.fileID, ErrS3ObjectConflict)
+	}
+	current, err := d.backendFor(info.BackendKind)
+	if err != nil {
+		return false, err
+	}
+	if current.Name() == backend.Name() {
+		return false, nil
+	}
+	stream, err := d.lowlevelIOStream(current, record.fileID, record.size)(ctx)
+	if err != nil {
+		return false, fmt.Errorf("open file %d for transition: %w", record.fileID, err)
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+	copyID, err := d.createFileOn(ctx, backend, record.size, stream)
+	if err != nil {
+		return false, fmt.Errorf("copy file %d to %s: %w", record.fileID, backend.Name(), err)
+	}
+	return d.publishTransitionedCopy(ctx, record, copyID, swap)
+}
+
+// publishTransitionedCopy swaps copyID in for record, or discards the copy
+// when the swap does not happen.
+func (d *defaultFileManager) publishTransitionedCopy(
+	ctx context.Context,
+	record storedFileRecord,
+	copyID uint64,
+	swap swapFileFunc,
+) (bool, error) {
+	copied, exists, err := readStoredFile(ctx, d.dbc, copyID)
+	if err == nil && !exists {
+		err = fmt.Errorf("read transitioned copy %d: %w", copyID, ErrS3ObjectConflict)
+	}
+	swapped := false
+	if err == nil {
+		err = d.dbc.OnTransation(ctx, func(ctx context.Context, tx database.IQueryExecer) error {
+			var swapErr error
+			swapped, swapErr = swap(ctx, tx, record, copied)
+			if swapErr != nil || !swapped {
+				return swapErr
+			}
+			return markFileTreePendingIfUnreferenced(ctx, tx, record.fileID, time.Now().UnixMilli())
+		})
+	}
+	if err == nil && swapped {
+		return true, nil
+	}
+	cleanupContext, cancel := context.WithTimeout(context.WithoutCancel(ctx), deleteTimeout)
+	defer cancel()
+	if discardErr := d.DiscardUnpublishedFile(cleanupContext, copyID); discardErr != nil {
+		err = errors.Join(err, discardErr)
+	}
+	if err != nil {
+		return false, fmt.Errorf("swap transitioned file %d: %w", record.fileID, err)
+	}
+	return false, nil
+}
+
+func swapMappedFile(
+	ctx context.Context,
+	tx database.IExecer,
+	entryID, previous, next uint64,
+) (bool, error) {
+	result, err := tx.ExecContext(
+		ctx,
+		`UPDATE tg_file_mapping_tab SET ref_data = ? WHERE entry_id = ? AND ref_data = ?`,
+		strconv.FormatUint(next, 10),
+		entryID,
+		strconv.FormatUint(previous, 10),
+	)
+	if err != nil {
+		return false, fmt.Errorf("swap transitioned object file: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read transitioned object swap result: %w", err)
+	}
+	return affected == 1, nil
+}
+
+// swapCompositeSource points a composite file at a transitioned source. The
+// composite part count follows its sources, which may use another block size.
+func swapCompositeSource(
+	ctx context.Context,
+	tx database.IExecer,
+	compositeID uint64,
+	previous, next storedFileRecord,
+) (bool, error) {
+	result, err := tx.ExecContext(
+		ctx,
+		`UPDATE tg_s3_file_segment_tab SET source_file_id = ? WHERE file_id = ? AND source_file_id = ?`,
+		next.fileID,
+		compositeID,
+		previous.fileID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("swap transitioned composite source: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read transitioned composite swap result: %w", err)
+	}
+	if affected == 0 {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(
+		ctx,
+		`UPDATE tg_file_tab SET file_part_count = file_part_count - ? + ? WHERE file_id = ?`,
+		previous.partCount*affected,
+		next.partCount*affected,
+		compositeID,
+	); err != nil {
+		return false, fmt.Errorf("update transitioned composite part count: %w", err)
+	}
+	return true, nil
+}
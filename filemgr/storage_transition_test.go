@@ -0,0 +1,43 @@
+package filemgr
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/blockio/mem"
+)
+
+func TestTransitionCompositeFileRefreshesCachedPartCount(t *testing.T) {
+	cold, err := mem.New(64)
+	require.NoError(t, err)
+	managerInterface, _, databaseClient := newCreateFileTestManager(t, 4, WithStorageTier("GLACIER", cold))
+	manager := managerInterface.(*defaultFileManager)
+	content := []byte("abcdefghijklmno")
+	sourceID, err := manager.CreateFile(t.Context(), int64(len(content)), bytes.NewReader(content))
+	require.NoError(t, err)
+	const compositeID uint64 = 9_000_003
+	insertCompositeForTest(t, databaseClient, compositeID, []uint64{sourceID}, [][]byte{content})
+
+	info, exists, err := manager.internalGetFileInfo(t.Context(), compositeID)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.EqualValues(t, 4, info.FilePartCount)
+
+	moved, err := manager.transitionCompositeFile(t.Context(), cold, compositeID)
+	require.NoError(t, err)
+	require.True(t, moved)
+
+	info, exists, err = manager.internalGetFileInfo(t.Context(), compositeID)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.EqualValues(t, 1, info.FilePartCount)
+	reader, err := manager.OpenFile(t.Context(), compositeID)
+	require.NoError(t, err)
+	actual, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, content, actual)
+	require.NoError(t, reader.Close())
+}
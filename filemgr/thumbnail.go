@@ -0,0 +1,438 @@
+package filemgr
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Registers the GIF decoder for image.Decode.
+	"image/jpeg"
+	_ "image/png" // Registers the PNG decoder for image.Decode.
+	"io"
+	"os"
+	"time"
+
+	"github.com/xxxsen/common/database"
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+)
+
+const (
+	thumbnailWorkerInterval = 30 * time.Minute
+	thumbnailSweepBatchSize = 256
+)
+
+// ThumbnailOptions renders JPEG previews of JPEG, PNG and GIF Files.
+// MaxEdge bounds the longest edge of a preview; smaller images keep their
+// size. Sources larger than MaxSourceBytes or MaxSourcePixels are not
+// decoded, and Concurrency bounds the previews rendered at the same time.
+type ThumbnailOptions struct {
+	MaxEdge         int
+	Quality         int
+	MaxSourceBytes  int64
+	MaxSourcePixels int64
+	Concurrency     int
+}
+
+// Thumbnail is the stored preview of a source File. Previews are always
+// JPEG images.
+type Thumbnail struct {
+	SourceFileID uint64
+	FileID       uint64
+	Size         int64
+	Width        int
+	Height       int
+	CreatedAt    int64
+}
+
+// IThumbnailManager renders previews of image Files on first request and
+// keeps them as Files of their own. Previews follow the source File, not a
+// path, so every path and version sharing a File shares its preview, and the
+// preview of a File never changes. The worker releases the previews of Files
+// that are no longer referenced.
+type IThumbnailManager interface {
+	ThumbnailsEnabled() bool
+	GetThumbnail(ctx context.Context, sourceFileID uint64) (*Thumbnail, error)
+	RunThumbnailWorker(ctx context.Context) error
+}
+
+type thumbnailRenderer struct {
+	options ThumbnailOptions
+	slots   chan struct{}
+}
+
+type renderedThumbnail struct {
+	content       []byte
+	width, height int
+}
+
+// WithThumbnails renders previews of image Files.
+func WithThumbnails(options ThumbnailOptions) Option {
+	return func(d *defaultFileManager) {
+		d.thumbnails = &thumbnailRenderer{
+			options: options,
+			slots:   make(chan struct{}, max(options.Concurrency, 1)),
+		}
+	}
+}
+
+func (d *defaultFileManager) ThumbnailsEnabled() bool {
+	return d.thumbnails != nil
+}
+
+// GetThumbnail returns the preview of a File, rendering it when the File has
+// none yet. It returns ErrThumbnailUnsupported when previews are disabled or
+// the File is not an image that can be previewed; that outcome is stored
+// too, so the File is not decoded again.
+func (d *defaultFileManager) GetThumbnail(ctx context.Context, sourceFileID uint64) (*Thumbnail, error) {
+	if !d.ThumbnailsEnabled() {
+		return nil, ErrThumbnailUnsupported
+	}
+	thumbnail, err := d.readThumbnail(ctx, d.dbc, sourceFileID)
+	if !errors.Is(err, os.ErrNotExist) {
+		return usableThumbnail(thumbnail, err)
+	}
+	select {
+	case d.thumbnails.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("wait for thumbnail renderer: %w", ctx.Err())
+	}
+	defer func() {
+		<-d.thumbnails.slots
+	}()
+	// Another request may have rendered the preview while this one waited.
+	thumbnail, err = d.readThumbnail(ctx, d.dbc, sourceFileID)
+	if !errors.Is(err, os.ErrNotExist) {
+		return usableThumbnail(thumbnail, err)
+	}
+	rendered, err := d.renderThumbnail(ctx, sourceFileID)
+	if err != nil && !errors.Is(err, ErrThumbnailUnsupported) {
+		return nil, err
+	}
+	return usableThumbnail(d.storeThumbnail(ctx, sourceFileID, rendered))
+}
+
+func usableThumbnail(thumbnail *Thumbnail, err error) (*Thumbnail, error) {
+	if err != nil {
+		return nil, err
+	}
+	if thumbnail.FileID == 0 {
+		return nil, fmt.Errorf("%w: file %d", ErrThumbnailUnsupported, thumbnail.SourceFileID)
+	}
+	return thumbnail, nil
+}
+
+// renderThumbnail decodes a source File and scales it down. It returns
+// ErrThumbnailUnsupported when the File cannot be previewed.
+func (d *defaultFileManager) renderThumbnail(ctx context.Context, sourceFileID uint64) (*renderedThumbnail, error) {
+	options := d.thumbnails.options
+	record, exists, err := readStoredFile(ctx, d.dbc, sourceFileID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, os.ErrNotExist
+	}
+	if record.size == 0 || record.size > options.MaxSourceBytes {
+		return nil, fmt.Errorf("%w: source size %d", ErrThumbnailUnsupported, record.size)
+	}
+	stream, err := d.OpenFile(ctx, sourceFileID)
+	if err != nil {
+		return nil, fmt.Errorf("open thumbnail source: %w", err)
+	}
+	defer func() {
+		_ = stream.Close()
+	}()
+	config, _, err := image.DecodeConfig(stream)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrThumbnailUnsupported, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 ||
+		int64(config.Width)*int64(config.Height) > options.MaxSourcePixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels", ErrThumbnailUnsupported, config.Width, config.Height)
+	}
+	if _, err := stream.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("rewind thumbnail source: %w", err)
+	}
+	source, _, err := image.Decode(stream)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrThumbnailUnsupported, err)
+	}
+	width, height := thumbnailSize(config.Width, config.Height, options.MaxEdge)
+	var content bytes.Buffer
+	if err := jpeg.Encode(
+		&content,
+		scaleThumbnail(source, width, height),
+		&jpeg.Options{Quality: options.Quality},
+	); err != nil {
+		return nil, fmt.Errorf("encode thumbnail: %w", err)
+	}
+	return &renderedThumbnail{content: content.Bytes(), width: width, height: height}, nil
+}
+
+// storeThumbnail writes a rendered preview and records it for the source
+// File. When the source lost its last reference meanwhile, or a concurrent
+// request stored a preview first, the new preview File is released again.
+func (d *defaultFileManager) storeThumbnail(
+	ctx context.Context,
+	sourceFileID uint64,
+	rendered *renderedThumbnail,
+) (*Thumbnail, error) {
+	thumbnail := &Thumbnail{SourceFileID: sourceFileID, CreatedAt: time.Now().UnixMilli()}
+	if rendered != nil {
+		fileID, err := d.CreateFile(ctx, int64(len(rendered.content)), bytes.NewReader(rendered.content))
+		if err != nil {
+			return nil, fmt.Errorf("create thumbnail file: %w", err)
+		}
+		thumbnail.FileID = fileID
+		thumbnail.Size = int64(len(rendered.content))
+		thumbnail.Width = rendered.width
+		thumbnail.Height = rendered.height
+	}
+	stored := false
+	err := d.dbc.OnTransation(ctx, func(ctx context.Context, tx database.IQueryExecer) error {
+		referenced, err := fileHasLiveReference(ctx, tx, sourceFileID)
+		if err != nil {
+			return err
+		}
+		if referenced {
+			if stored, err = d.insertThumbnailTx(ctx, tx, thumbnail); err != nil {
+				return err
+			}
+		}
+		if stored || thumbnail.FileID == 0 {
+			return nil
+		}
+		return markFileTreePendingIfUnreferenced(ctx, tx, thumbnail.FileID, thumbnail.CreatedAt)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("store thumbnail of file %d: %w", sourceFileID, err)
+	}
+	if stored {
+		return thumbnail, nil
+	}
+	return d.readThumbnail(ctx, d.dbc, sourceFileID)
+}
+
+// insertThumbnailTx records a preview unless the source File already has
+// one, and reports whether it did.
+func (d *defaultFileManager) insertThumbnailTx(
+	ctx context.Context,
+	queryExecer database.IQueryExecer,
+	thumbnail *Thumbnail,
+) (bool, error) {
+	result, err := queryExecer.ExecContext(
+		ctx,
+		`INSERT INTO tg_file_thumbnail_tab
+(source_file_id, max_edge, thumb_file_id, file_size, width, height, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+		thumbnail.SourceFileID,
+		d.thumbnails.options.MaxEdge,
+		thumbnail.FileID,
+		thumbnail.Size,
+		thumbnail.Width,
+		thumbnail.Height,
+		thumbnail.CreatedAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("insert thumbnail: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read inserted thumbnail count: %w", err)
+	}
+	return affected == 1, nil
+}
+
+// RunThumbnailWorker releases the previews of Files that lost their last
+// reference and the previews rendered for another MaxEdge.
+func (d *defaultFileManager) RunThumbnailWorker(ctx context.Context) error {
+	if !d.ThumbnailsEnabled() {
+		return nil
+	}
+	d.runThumbnailPass(ctx, time.Now())
+	ticker := time.NewTicker(thumbnailWorkerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			d.runThumbnailPass(ctx, now)
+		}
+	}
+}
+
+func (d *defaultFileManager) runThumbnailPass(ctx context.Context, now time.Time) {
+	if _, err := d.dropStaleThumbnails(ctx, now); err != nil {
+		logutil.GetLogger(ctx).Error(
+			"thumbnail cleanup failed",
+			zap.String("error_code", "database"),
+		)
+	}
+}
+
+// dropStaleThumbnails walks the sources with previews in batches, so a large
+// table does not hold one long transaction, and returns the number of
+// previews dropped.
+func (d *defaultFileManager) dropStaleThumbnails(ctx context.Context, now time.Time) (int, error) {
+	var cursor uint64
+	dropped := 0
+	for {
+		var sources []uint64
+		err := d.dbc.OnTransation(ctx, func(ctx context.Context, tx database.IQueryExecer) error {
+			var err error
+			sources, err = queryColumnList[uint64](
+				ctx,
+				tx,
+				`SELECT DISTINCT source_file_id FROM tg_file_thumbnail_tab WHERE source_file_id > ?
+ORDER BY source_file_id LIMIT ?`,
+				cursor,
+				thumbnailSweepBatchSize,
+			)
+			if err != nil {
+				return fmt.Errorf("query thumbnail sources: %w", err)
+			}
+			for _, source := range sources {
+				referenced, err := fileHasLiveReference(ctx, tx, source)
+				if err != nil {
+					return err
+				}
+				keepEdge := 0
+				if referenced {
+					keepEdge = d.thumbnails.options.MaxEdge
+				}
+				count, err := deleteThumbnailsTx(ctx, tx, source, keepEdge, now.UnixMilli())
+				if err != nil {
+					return err
+				}
+				dropped += count
+			}
+			return nil
+		})
+		if err != nil {
+			return dropped, fmt.Errorf("drop stale thumbnails: %w", err)
+		}
+		if len(sources) < thumbnailSweepBatchSize {
+			return dropped, nil
+		}
+		cursor = sources[len(sources)-1]
+	}
+}
+
+// deleteThumbnailsTx deletes the previews of a source File except the one
+// rendered for keepEdge and releases their Files.
+func deleteThumbnailsTx(
+	ctx context.Context,
+	queryExecer database.IQueryExecer,
+	sourceFileID uint64,
+	keepEdge int,
+	now int64,
+) (int, error) {
+	fileIDs, err := queryColumnList[uint64](
+		ctx,
+		queryExecer,
+		"SELECT thumb_file_id FROM tg_file_thumbnail_tab WHERE source_file_id = ? AND max_edge != ?",
+		sourceFileID,
+		keepEdge,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("query stale thumbnails: %w", err)
+	}
+	if len(fileIDs) == 0 {
+		return 0, nil
+	}
+	if _, err := queryExecer.ExecContext(
+		ctx,
+		"DELETE FROM tg_file_thumbnail_tab WHERE source_file_id = ? AND max_edge != ?",
+		sourceFileID,
+		keepEdge,
+	); err != nil {
+		return 0, fmt.Errorf("delete stale thumbnails: %w", err)
+	}
+	for _, fileID := range fileIDs {
+		if fileID == 0 {
+			continue
+		}
+		if err := markFileTreePendingIfUnreferenced(ctx, queryExecer, fileID, now); err != nil {
+			return 0, err
+		}
+	}
+	return len(fileIDs), nil
+}
+
+func (d *defaultFileManager) readThumbnail(
+	ctx context.Context,
+	queryer database.IQueryer,
+	sourceFileID uint64,
+) (*Thumbnail, error) {
+	thumbnail := Thumbnail{SourceFileID: sourceFileID}
+	err := queryRow(
+		ctx,
+		queryer,
+		`SELECT thumb_file_id, file_size, width, height, created_at FROM tg_file_thumbnail_tab
+WHERE source_file_id = ? AND max_edge = ?`,
+		sourceFileID,
+		d.thumbnails.options.MaxEdge,
+	).Scan(&thumbnail.FileID, &thumbnail.Size, &thumbnail.Width, &thumbnail.Height, &thumbnail.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read thumbnail of file %d: %w", sourceFileID, err)
+	}
+	return &thumbnail, nil
+}
+
+// thumbnailSize fits width and height into maxEdge, keeping the aspect ratio
+// and never scaling up.
+func thumbnailSize(width, height, maxEdge int) (int, int) {
+	longest := max(width, height)
+	if longest <= maxEdge {
+		return width, height
+	}
+	return max(width*maxEdge/longest, 1), max(height*maxEdge/longest, 1)
+}
+
+// scaleThumbnail scales source down to width by height, averaging the source
+// pixels each target pixel covers. Transparent pixels are composed onto
+// white because JPEG has no alpha channel.
+func scaleThumbnail(source image.Image, width, height int) *image.RGBA {
+	bounds := source.Bounds()
+	target := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		top := bounds.Min.Y + y*bounds.Dy()/height
+		bottom := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		for x := range width {
+			left := bounds.Min.X + x*bounds.Dx()/width
+			right := bounds.Min.X + (x+1)*bounds.Dx()/width
+			target.SetRGBA(x, y, averageOnWhite(source, image.Rect(left, top, right, bottom)))
+		}
+	}
+	return target
+}
+
+func averageOnWhite(source image.Image, box image.Rectangle) color.RGBA {
+	var red, green, blue, alpha uint64
+	for y := box.Min.Y; y < box.Max.Y; y++ {
+		for x := box.Min.X; x < box.Max.X; x++ {
+			r, g, b, a := source.At(x, y).RGBA()
+			red += uint64(r)
+			green += uint64(g)
+			blue += uint64(b)
+			alpha += uint64(a)
+		}
+	}
+	count := uint64(box.Dx() * box.Dy())
+	// The colors are alpha-premultiplied, so white fills what alpha leaves.
+	white := 0xffff*count - alpha
+	return color.RGBA{
+		R: uint8((red + white) / count >> 8),
+		G: uint8((green + white) / count >> 8),
+		B: uint8((blue + white) / count >> 8),
+		A: 0xff,
+	}
+}
@@ -0,0 +1,100 @@
+package filemgr
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestThumbnailsRenderOnceAndFollowTheSourceFile(t *testing.T) {
+	managerInterface, _, databaseClient := newCreateFileTestManager(t, 64)
+	manager := managerInterface.(*defaultFileManager)
+	options := ThumbnailOptions{MaxEdge: 40, Quality: 80, MaxSourceBytes: 1 << 20, MaxSourcePixels: 1 << 20}
+	WithThumbnails(options)(manager)
+	require.NoError(t, manager.CreateFileLink(t.Context(), "/pictures", 0, 0, true))
+	publish := func(resourcePath string, content []byte) uint64 {
+		fileID, err := manager.CreateFile(t.Context(), int64(len(content)), bytes.NewReader(content))
+		require.NoError(t, err)
+		_, err = manager.PublishWebDAVFile(t.Context(), resourcePath, fileID, int64(len(content)), WebDAVMutationOptions{})
+		require.NoError(t, err)
+		return fileID
+	}
+	pending := func(fileID uint64) int {
+		return queryCount(t, databaseClient, `SELECT COUNT(*) FROM tg_file_part_delete_state_tab
+WHERE delete_state = 'pending' AND file_id = `+strconv.FormatUint(fileID, 10))
+	}
+
+	source := image.NewNRGBA(image.Rect(0, 0, 100, 50))
+	for x := range 50 {
+		for y := range 50 {
+			source.SetNRGBA(x, y, color.NRGBA{R: 0xff, A: 0xff})
+		}
+	}
+	var encoded bytes.Buffer
+	require.NoError(t, png.Encode(&encoded, source))
+	pictureID := publish("/pictures/red.png", encoded.Bytes())
+	textID := publish("/pictures/notes.txt", []byte("not an image"))
+
+	thumbnail, err := manager.GetThumbnail(t.Context(), pictureID)
+	require.NoError(t, err)
+	require.Equal(t, 40, thumbnail.Width)
+	require.Equal(t, 20, thumbnail.Height)
+	stream, err := manager.OpenFile(t.Context(), thumbnail.FileID)
+	require.NoError(t, err)
+	preview, err := jpeg.Decode(stream)
+	require.NoError(t, stream.Close())
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 40, 20), preview.Bounds())
+	red, green, _, _ := preview.At(5, 10).RGBA()
+	require.Greater(t, red>>8, uint32(0xe0))
+	require.Less(t, green>>8, uint32(0x40))
+	_, green, _, _ = preview.At(35, 10).RGBA()
+	require.Greater(t, green>>8, uint32(0xe0), "transparent pixels are composed onto white")
+
+	again, err := manager.GetThumbnail(t.Context(), pictureID)
+	require.NoError(t, err)
+	require.Equal(t, thumbnail.FileID, again.FileID)
+	_, err = manager.GetThumbnail(t.Context(), textID)
+	require.ErrorIs(t, err, ErrThumbnailUnsupported)
+	require.Equal(t, 2, queryCount(t, databaseClient, "SELECT COUNT(*) FROM tg_file_thumbnail_tab"))
+
+	dropped, err := manager.dropStaleThumbnails(t.Context(), time.Now())
+	require.NoError(t, err)
+	require.Zero(t, dropped)
+	publish("/pictures/red.png", []byte("replaced"))
+	require.NotZero(t, pending(pictureID))
+	require.Zero(t, pending(thumbnail.FileID))
+	dropped, err = manager.dropStaleThumbnails(t.Context(), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, dropped)
+	require.NotZero(t, pending(thumbnail.FileID))
+
+	options.MaxEdge = 20
+	WithThumbnails(options)(manager)
+	dropped, err = manager.dropStaleThumbnails(t.Context(), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 1, dropped)
+	require.Zero(t, queryCount(t, databaseClient, "SELECT COUNT(*) FROM tg_file_thumbnail_tab"))
+}
+
+func TestThumbnailSizeKeepsAspectRatioWithoutUpscaling(t *testing.T) {
+	for _, test := range []struct {
+		width, height, maxEdge, wantWidth, wantHeight int
+	}{
+		{width: 10, height: 5, maxEdge: 40, wantWidth: 10, wantHeight: 5},
+		{width: 400, height: 300, maxEdge: 40, wantWidth: 40, wantHeight: 30},
+		{width: 300, height: 400, maxEdge: 40, wantWidth: 30, wantHeight: 40},
+		{width: 4000, height: 1, maxEdge: 40, wantWidth: 40, wantHeight: 1},
+	} {
+		width, height := thumbnailSize(test.width, test.height, test.maxEdge)
+		require.Equal(t, test.wantWidth, width)
+		require.Equal(t, test.wantHeight, height)
+	}
+}
@@ -0,0 +1,394 @@
+package filemgr
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/xxxsen/common/database"
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+
+	"github.com/xxxsen/tgfile/directory"
+)
+
+const (
+	TrashProtocolWebDAV   = "webdav"
+	TrashProtocolS3       = "s3"
+	TrashProtocolFile     = "file"
+	TrashProtocolSnapshot = "snapshot"
+
+	trashCleanupInterval  = 10 * time.Minute
+	trashCleanupBatchSize = 64
+	maxTrashListLimit     = 1000
+)
+
+// TrashOrigin records who deleted a mapping and through which protocol.
+type TrashOrigin struct {
+	Principal string
+	Protocol  string
+}
+
+// TrashItem is one deleted mapping subtree held by the recycle bin. Size is
+// the total size of the files in the subtree.
+type TrashItem struct {
+	TrashID      uint64
+	EntryID      uint64
+	OriginalPath string
+	IsDir        bool
+	Size         int64
+	EntryCount   int64
+	Principal    string
+	Protocol     string
+	DeletedAt    int64
+	ExpiresAt    int64
+}
+
+// TrashListRequest pages the recycle bin from the newest item. Cursor is the
+// NextCursor of the previous page, zero for the first page.
+type TrashListRequest struct {
+	Cursor uint64
+	Limit  int
+}
+
+type TrashListResult struct {
+	Items      []TrashItem
+	NextCursor uint64
+}
+
+// ITrashManager restores and purges deleted mappings. Deletes only go to the
+// recycle bin when it is enabled; restore and purge work either way so items
+// left over from an enabled period can still be drained. RestoreTrash returns
+// the item with OriginalPath set to the path it was restored to.
+type ITrashManager interface {
+	TrashEnabled() bool
+	ListTrash(ctx context.Context, request TrashListRequest) (*TrashListResult, error)
+	RestoreTrash(ctx context.Context, trashID uint64, destination string) (*TrashItem, error)
+	PurgeTrash(ctx context.Context, trashID uint64) (*TrashItem, error)
+	RunTrashWorker(ctx context.Context) error
+}
+
+// WithTrash makes deletes move mappings to the recycle bin, where they are
+// kept for retention before the trash worker purges them. A zero retention
+// keeps deletes immediate.
+func WithTrash(retention time.Duration) Option {
+	return func(d *defaultFileManager) {
+		d.trashRetention = retention
+	}
+}
+
+type trashOriginKey struct{}
+
+// ContextWithTrashOrigin records the principal and protocol of deletes made
+// with ctx in the recycle bin.
+func ContextWithTrashOrigin(ctx context.Context, origin TrashOrigin) context.Context {
+	return context.WithValue(ctx, trashOriginKey{}, origin)
+}
+
+func trashOriginFromContext(ctx context.Context, fallback TrashOrigin) TrashOrigin {
+	origin, ok := ctx.Value(trashOriginKey{}).(TrashOrigin)
+	if !ok {
+		return fallback
+	}
+	if origin.Principal == "" {
+		origin.Principal = fallback.Principal
+	}
+	if origin.Protocol == "" {
+		origin.Protocol = fallback.Protocol
+	}
+	return origin
+}
+
+func (d *defaultFileManager) TrashEnabled() bool {
+	return d.trashRetention > 0
+}
+
+// trashTreeTx moves resourcePath to the recycle bin. It reports false when
+// the recycle bin is disabled and the caller must remove the mapping itself.
+// The subtree keeps its rows, so its Files stay referenced; its locks and
+// bindings are dropped, while metadata, properties and ACL wait for restore.
+func (d *defaultFileManager) trashTreeTx(
+	ctx context.Context,
+	tx directory.ITransaction,
+	resourcePath string,
+	origin TrashOrigin,
+) (bool, error) {
+	if !d.TrashEnabled() {
+		return false, nil
+	}
+	trashID, entries, err := tx.Detach(ctx, resourcePath)
+	if err != nil {
+		return false, fmt.Errorf("move mapping to trash: %w", err)
+	}
+	if err := deleteWebDAVLocks(ctx, tx.QueryExecer(), entries); err != nil {
+		return false, err
+	}
+	if err := deleteWebDAVBindings(ctx, tx.QueryExecer(), entries); err != nil {
+		return false, err
+	}
+	root := entries[len(entries)-1]
+	var size int64
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			size += entry.Size()
+		}
+	}
+	if _, err := tx.QueryExecer().ExecContext(
+		ctx,
+		`INSERT INTO tg_trash_tab (
+trash_id, entry_id, original_path, is_dir, file_size, entry_count, principal, protocol, deleted_at
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		trashID,
+		root.EntryID(),
+		path.Clean(resourcePath),
+		root.IsDir(),
+		size,
+		len(entries),
+		origin.Principal,
+		origin.Protocol,
+		time.Now().UnixMilli(),
+	); err != nil {
+		return false, fmt.Errorf("insert trash item: %w", err)
+	}
+	return true, nil
+}
+
+// removeOrTrashTx deletes resourcePath, through the recycle bin when it is
+// enabled. fallback fills in what the context does not say about the origin.
+func (d *defaultFileManager) removeOrTrashTx(
+	ctx context.Context,
+	tx directory.ITransaction,
+	resourcePath string,
+	fallback TrashOrigin,
+) error {
+	trashed, err := d.trashTreeTx(ctx, tx, resourcePath, trashOriginFromContext(ctx, fallback))
+	if err != nil || trashed {
+		return err
+	}
+	removed, err := tx.Remove(ctx, resourcePath)
+	if err != nil {
+		return fmt.Errorf("remove mapping: %w", err)
+	}
+	return finalizeRemovedWebDAVEntries(ctx, tx.QueryExecer(), removed)
+}
+
+func (d *defaultFileManager) ListTrash(
+	ctx context.Context,
+	request TrashListRequest,
+) (*TrashListResult, error) {
+	if request.Limit <= 0 || request.Limit > maxTrashListLimit {
+		return nil, fmt.Errorf("%w: limit %d", ErrInvalidTrashRequest, request.Limit)
+	}
+	cursor := request.Cursor
+	if cursor == 0 {
+		cursor = ^uint64(0) >> 1
+	}
+	rows, err := d.dbc.QueryContext(
+		ctx,
+		`SELECT `+trashColumns+` FROM tg_trash_tab
+WHERE trash_id < ? ORDER BY trash_id DESC LIMIT ?`,
+		cursor,
+		request.Limit+1,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query trash items: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	result := &TrashListResult{Items: make([]TrashItem, 0, request.Limit)}
+	for rows.Next() {
+		item, err := d.scanTrashItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Items) == request.Limit {
+			result.NextCursor = result.Items[len(result.Items)-1].TrashID
+			break
+		}
+		result.Items = append(result.Items, *item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate trash items: %w", err)
+	}
+	return result, nil
+}
+
+// RestoreTrash moves a trashed subtree back to destination, or to its
+// original path when destination is empty. Missing parent collections are
+// recreated; an existing destination is never replaced.
+func (d *defaultFileManager) RestoreTrash(
+	ctx context.Context,
+	trashID uint64,
+	destination string,
+) (*TrashItem, error) {
+	if destination != "" && (!strings.HasPrefix(destination, "/") || path.Clean(destination) == "/") {
+		return nil, fmt.Errorf("%w: destination %q", ErrInvalidTrashRequest, destination)
+	}
+	var restored *TrashItem
+	err := d.objectDir.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
+		item, err := d.readTrashItem(ctx, tx.QueryExecer(), trashID)
+		if err != nil {
+			return err
+		}
+		target := item.OriginalPath
+		if destination != "" {
+			target = path.Clean(destination)
+		}
+		if _, err := tx.Attach(ctx, trashID, target); err != nil {
+			if errors.Is(err, directory.ErrDestinationExists) ||
+				errors.Is(err, directory.ErrPathComponentNotDirectory) {
+				return fmt.Errorf("%w: %s", ErrTrashConflict, target)
+			}
+			return fmt.Errorf("restore trash item: %w", err)
+		}
+		if err := deleteTrashItem(ctx, tx.QueryExecer(), trashID); err != nil {
+			return err
+		}
+		item.OriginalPath = target
+		restored = item
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("restore trash item %d: %w", trashID, err)
+	}
+	return restored, nil
+}
+
+// PurgeTrash deletes a trashed subtree for good. Its Files become eligible
+// for the block delete worker once nothing else references them.
+func (d *defaultFileManager) PurgeTrash(ctx context.Context, trashID uint64) (*TrashItem, error) {
+	var purged *TrashItem
+	err := d.objectDir.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
+		item, err := d.readTrashItem(ctx, tx.QueryExecer(), trashID)
+		if err != nil {
+			return err
+		}
+		dropped, err := tx.Drop(ctx, trashID)
+		if err != nil {
+			return fmt.Errorf("drop trash item: %w", err)
+		}
+		if err := finalizeRemovedWebDAVEntries(ctx, tx.QueryExecer(), dropped); err != nil {
+			return err
+		}
+		if err := deleteTrashItem(ctx, tx.QueryExecer(), trashID); err != nil {
+			return err
+		}
+		purged = item
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("purge trash item %d: %w", trashID, err)
+	}
+	return purged, nil
+}
+
+// RunTrashWorker purges trash items older than the retention window. It
+// returns at once when the recycle bin is disabled.
+func (d *defaultFileManager) RunTrashWorker(ctx context.Context) error {
+	if !d.TrashEnabled() {
+		return nil
+	}
+	d.runTrashCleanupPass(ctx)
+	ticker := time.NewTicker(trashCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			d.runTrashCleanupPass(ctx)
+		}
+	}
+}
+
+func (d *defaultFileManager) runTrashCleanupPass(ctx context.Context) {
+	if _, err := d.purgeExpiredTrash(ctx, time.Now(), trashCleanupBatchSize); err != nil {
+		logutil.GetLogger(ctx).Error(
+			"trash expiry cleanup failed",
+			zap.String("error_code", "database"),
+		)
+	}
+}
+
+// purgeExpiredTrash purges the items deleted before now minus the retention
+// window, limit items per query, and returns how many it purged.
+func (d *defaultFileManager) purgeExpiredTrash(ctx context.Context, now time.Time, limit int) (int, error) {
+	cutoff := now.Add(-d.trashRetention).UnixMilli()
+	purged := 0
+	for {
+		trashIDs, err := queryColumnList[uint64](
+			ctx,
+			d.dbc,
+			`SELECT trash_id FROM tg_trash_tab WHERE deleted_at <= ?
+ORDER BY deleted_at, trash_id LIMIT ?`,
+			cutoff,
+			limit,
+		)
+		if err != nil {
+			return purged, fmt.Errorf("query expired trash items: %w", err)
+		}
+		for _, trashID := range trashIDs {
+			if _, err := d.PurgeTrash(ctx, trashID); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return purged, err
+			}
+			purged++
+		}
+		if len(trashIDs) < limit {
+			return purged, nil
+		}
+	}
+}
+
+const trashColumns = `trash_id, entry_id, original_path, is_dir, file_size, entry_count,
+principal, protocol, deleted_at`
+
+func (d *defaultFileManager) readTrashItem(
+	ctx context.Context,
+	queryer database.IQueryer,
+	trashID uint64,
+) (*TrashItem, error) {
+	item, err := d.scanTrashItem(queryRow(
+		ctx,
+		queryer,
+		`SELECT `+trashColumns+` FROM tg_trash_tab WHERE trash_id = ?`,
+		trashID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, os.ErrNotExist
+	}
+	return item, err
+}
+
+func (d *defaultFileManager) scanTrashItem(scanner rowScanner) (*TrashItem, error) {
+	var item TrashItem
+	if err := scanner.Scan(
+		&item.TrashID,
+		&item.EntryID,
+		&item.OriginalPath,
+		&item.IsDir,
+		&item.Size,
+		&item.EntryCount,
+		&item.Principal,
+		&item.Protocol,
+		&item.DeletedAt,
+	); err != nil {
+		return nil, fmt.Errorf("scan trash item: %w", err)
+	}
+	if d.TrashEnabled() {
+		item.ExpiresAt = item.DeletedAt + d.trashRetention.Milliseconds()
+	}
+	return &item, nil
+}
+
+func deleteTrashItem(ctx context.Context, exec database.IExecer, trashID uint64) error {
+	if _, err := exec.ExecContext(ctx, "DELETE FROM tg_trash_tab WHERE trash_id = ?", trashID); err != nil {
+		return fmt.Errorf("delete trash item: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,367 @@
+package filemgr
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/xxxsen/common/database"
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+
+	"github.com/xxxsen/tgfile/entity"
+)
+
+const (
+	versionWorkerInterval   = 10 * time.Minute
+	versionPruneBatchSize   = 256
+	maxFileVersionListLimit = 1000
+)
+
+// VersionOptions keeps the previous content of a path when it is
+// overwritten. KeepCount bounds the versions kept per path and KeepFor their
+// age; a zero value leaves that bound off.
+type VersionOptions struct {
+	KeepCount int
+	KeepFor   time.Duration
+}
+
+// FileVersion is the content a path had before an overwrite. Mtime is the
+// modification time of that content, CreatedAt the time it was replaced.
+type FileVersion struct {
+	VersionID uint64
+	Path      string
+	FileID    uint64
+	Size      int64
+	Mtime     int64
+	CreatedAt int64
+}
+
+// FileVersionListRequest pages the versions of Path from the newest one.
+// Cursor is the NextCursor of the previous page, zero for the first page.
+type FileVersionListRequest struct {
+	Path   string
+	Cursor uint64
+	Limit  int
+}
+
+type FileVersionListResult struct {
+	Versions   []FileVersion
+	NextCursor uint64
+}
+
+// IVersionManager keeps the contents that WebDAV and S3 overwrites replace.
+// Version rows pin their Files, so the block delete worker leaves them alone
+// until the version is pruned or deleted. Restoring publishes the version's
+// File at a path like any other overwrite, so the content it replaces becomes
+// a version in turn.
+type IVersionManager interface {
+	VersionsEnabled() bool
+	ListFileVersions(ctx context.Context, request FileVersionListRequest) (*FileVersionListResult, error)
+	GetFileVersion(ctx context.Context, versionID uint64) (*FileVersion, error)
+	RestoreFileVersion(
+		ctx context.Context,
+		versionID uint64,
+		destination string,
+		options WebDAVMutationOptions,
+	) (*WebDAVPublishResult, error)
+	DeleteFileVersion(ctx context.Context, versionID uint64) (*FileVersion, error)
+	RunVersionWorker(ctx context.Context) error
+}
+
+// WithVersions records the replaced content of overwritten paths.
+func WithVersions(options VersionOptions) Option {
+	return func(d *defaultFileManager) {
+		d.versions = &options
+	}
+}
+
+func (d *defaultFileManager) VersionsEnabled() bool {
+	return d.versions != nil
+}
+
+// recordFileVersionTx keeps the File an overwrite of resourcePath replaced
+// and prunes the versions of the path beyond KeepCount. It has to run before
+// the replaced File is released, so the new version row already pins it.
+func (d *defaultFileManager) recordFileVersionTx(
+	ctx context.Context,
+	queryExecer database.IQueryExecer,
+	resourcePath string,
+	fileID uint64,
+	size, mtime, now int64,
+) error {
+	if !d.VersionsEnabled() {
+		return nil
+	}
+	if _, err := queryExecer.ExecContext(
+		ctx,
+		`INSERT INTO tg_file_version_tab (resource_path, file_id, file_size, mtime, created_at)
+VALUES (?, ?, ?, ?, ?)`,
+		resourcePath,
+		fileID,
+		size,
+		mtime,
+		now,
+	); err != nil {
+		return fmt.Errorf("insert file version: %w", err)
+	}
+	if d.versions.KeepCount <= 0 {
+		return nil
+	}
+	versionIDs, err := queryColumnList[uint64](
+		ctx,
+		queryExecer,
+		`SELECT version_id FROM tg_file_version_tab WHERE resource_path = ?
+ORDER BY version_id DESC LIMIT -1 OFFSET ?`,
+		resourcePath,
+		d.versions.KeepCount,
+	)
+	if err != nil {
+		return fmt.Errorf("query surplus file versions: %w", err)
+	}
+	return deleteFileVersionsTx(ctx, queryExecer, versionIDs, now)
+}
+
+// retireReplacedFileTx records the File an overwrite replaced as a version of
+// resourcePath and releases it when nothing else references it.
+func (d *defaultFileManager) retireReplacedFileTx(
+	ctx context.Context,
+	queryExecer database.IQueryExecer,
+	resourcePath string,
+	replaced *FileVersion,
+	now int64,
+) error {
+	if err := d.recordFileVersionTx(
+		ctx, queryExecer, resourcePath, replaced.FileID, replaced.Size, replaced.Mtime, now,
+	); err != nil {
+		return err
+	}
+	return markFilePendingIfUnreferenced(ctx, queryExecer, replaced.FileID, now)
+}
+
+func (d *defaultFileManager) ListFileVersions(
+	ctx context.Context,
+	request FileVersionListRequest,
+) (*FileVersionListResult, error) {
+	resourcePath, ok := cleanVersionPath(request.Path)
+	if !ok || request.Limit <= 0 || request.Limit > maxFileVersionListLimit {
+		return nil, fmt.Errorf("%w: path %q limit %d", ErrInvalidVersionRequest, request.Path, request.Limit)
+	}
+	rows, err := d.dbc.QueryContext(
+		ctx,
+		`SELECT `+fileVersionColumns+` FROM tg_file_version_tab
+WHERE resource_path = ? AND (? = 0 OR version_id < ?)
+ORDER BY version_id DESC LIMIT ?`,
+		resourcePath,
+		request.Cursor,
+		request.Cursor,
+		request.Limit+1,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query file versions: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	result := &FileVersionListResult{Versions: make([]FileVersion, 0, request.Limit)}
+	for rows.Next() {
+		version, err := scanFileVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Versions) == request.Limit {
+			result.NextCursor = result.Versions[len(result.Versions)-1].VersionID
+			break
+		}
+		result.Versions = append(result.Versions, *version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate file versions: %w", err)
+	}
+	return result, nil
+}
+
+func (d *defaultFileManager) GetFileVersion(ctx context.Context, versionID uint64) (*FileVersion, error) {
+	return readFileVersion(ctx, d.dbc, versionID)
+}
+
+// RestoreFileVersion publishes the content of a version at destination,
+// which defaults to the path the version was taken from. The version itself
+// is kept.
+func (d *defaultFileManager) RestoreFileVersion(
+	ctx context.Context,
+	versionID uint64,
+	destination string,
+	options WebDAVMutationOptions,
+) (*WebDAVPublishResult, error) {
+	version, err := readFileVersion(ctx, d.dbc, versionID)
+	if err != nil {
+		return nil, fmt.Errorf("read file version: %w", err)
+	}
+	if destination == "" {
+		destination = version.Path
+	}
+	target, ok := cleanVersionPath(destination)
+	if !ok || target == "/" {
+		return nil, fmt.Errorf("%w: destination %q", ErrInvalidVersionRequest, destination)
+	}
+	return d.PublishWebDAVFile(ctx, target, version.FileID, version.Size, options)
+}
+
+// DeleteFileVersion drops a version and releases its File when nothing else
+// references it.
+func (d *defaultFileManager) DeleteFileVersion(ctx context.Context, versionID uint64) (*FileVersion, error) {
+	var deleted *FileVersion
+	err := d.dbc.OnTransation(ctx, func(ctx context.Context, tx database.IQueryExecer) error {
+		version, err := readFileVersion(ctx, tx, versionID)
+		if err != nil {
+			return err
+		}
+		if err := deleteFileVersionsTx(ctx, tx, []uint64{versionID}, time.Now().UnixMilli()); err != nil {
+			return err
+		}
+		deleted = version
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("delete file version %d: %w", versionID, err)
+	}
+	return deleted, nil
+}
+
+// RunVersionWorker deletes versions older than KeepFor. It returns at once
+// when versions are disabled or kept regardless of their age.
+func (d *defaultFileManager) RunVersionWorker(ctx context.Context) error {
+	if !d.VersionsEnabled() || d.versions.KeepFor <= 0 {
+		return nil
+	}
+	d.runVersionPass(ctx, time.Now())
+	ticker := time.NewTicker(versionWorkerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			d.runVersionPass(ctx, now)
+		}
+	}
+}
+
+func (d *defaultFileManager) runVersionPass(ctx context.Context, now time.Time) {
+	if _, err := d.deleteExpiredFileVersions(ctx, now); err != nil {
+		logutil.GetLogger(ctx).Error(
+			"file version expiry cleanup failed",
+			zap.String("error_code", "database"),
+		)
+	}
+}
+
+// deleteExpiredFileVersions deletes the versions replaced more than KeepFor
+// before now, in batches so a large backlog does not hold one long
+// transaction.
+func (d *defaultFileManager) deleteExpiredFileVersions(ctx context.Context, now time.Time) (int, error) {
+	deadline := now.Add(-d.versions.KeepFor).UnixMilli()
+	deleted := 0
+	for {
+		var batch int
+		err := d.dbc.OnTransation(ctx, func(ctx context.Context, tx database.IQueryExecer) error {
+			versionIDs, err := queryColumnList[uint64](
+				ctx,
+				tx,
+				`SELECT version_id FROM tg_file_version_tab WHERE created_at <= ?
+ORDER BY version_id LIMIT ?`,
+				deadline,
+				versionPruneBatchSize,
+			)
+			if err != nil {
+				return fmt.Errorf("query expired file versions: %w", err)
+			}
+			batch = len(versionIDs)
+			return deleteFileVersionsTx(ctx, tx, versionIDs, now.UnixMilli())
+		})
+		if err != nil {
+			return deleted, fmt.Errorf("delete expired file versions: %w", err)
+		}
+		deleted += batch
+		if batch < versionPruneBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+func deleteFileVersionsTx(
+	ctx context.Context,
+	queryExecer database.IQueryExecer,
+	versionIDs []uint64,
+	now int64,
+) error {
+	for _, versionID := range versionIDs {
+		var fileID uint64
+		if err := queryRow(
+			ctx,
+			queryExecer,
+			"SELECT file_id FROM tg_file_version_tab WHERE version_id = ?",
+			versionID,
+		).Scan(&fileID); err != nil {
+			return fmt.Errorf("read file version file: %w", err)
+		}
+		if _, err := queryExecer.ExecContext(
+			ctx,
+			"DELETE FROM tg_file_version_tab WHERE version_id = ?",
+			versionID,
+		); err != nil {
+			return fmt.Errorf("delete file version: %w", err)
+		}
+		if err := markFileTreePendingIfUnreferenced(ctx, queryExecer, fileID, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cleanVersionPath(value string) (string, bool) {
+	if !strings.HasPrefix(value, "/") {
+		return "", false
+	}
+	return path.Clean(value), true
+}
+
+const fileVersionColumns = `version_id, resource_path, file_id, file_size, mtime, created_at`
+
+func readFileVersion(ctx context.Context, queryer database.IQueryer, versionID uint64) (*FileVersion, error) {
+	version, err := scanFileVersion(queryRow(
+		ctx,
+		queryer,
+		`SELECT `+fileVersionColumns+` FROM tg_file_version_tab WHERE version_id = ?`,
+		versionID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, os.ErrNotExist
+	}
+	return version, err
+}
+
+func scanFileVersion(scanner rowScanner) (*FileVersion, error) {
+	var version FileVersion
+	if err := scanner.Scan(
+		&version.VersionID,
+		&version.Path,
+		&version.FileID,
+		&version.Size,
+		&version.Mtime,
+		&version.CreatedAt,
+	); err != nil {
+		return nil, fmt.Errorf("scan file version: %w", err)
+	}
+	return &version, nil
+}
+
+func linkVersion(link *entity.FileLinkMeta) *FileVersion {
+	return &FileVersion{FileID: link.FileId, Size: link.FileSize, Mtime: link.Mtime}
+}
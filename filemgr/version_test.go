@@ -0,0 +1,108 @@
+package filemgr
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/entity"
+)
+
+func TestFileVersionsKeepReplacedContent(t *testing.T) {
+	managerInterface, _, databaseClient := newCreateFileTestManager(t, 32)
+	manager := managerInterface.(*defaultFileManager)
+	WithVersions(VersionOptions{KeepCount: 2})(manager)
+	require.NoError(t, manager.CreateFileLink(t.Context(), "/docs", 0, 0, true))
+	publish := func(resourcePath, content string) uint64 {
+		fileID, err := manager.CreateFile(t.Context(), int64(len(content)), bytes.NewReader([]byte(content)))
+		require.NoError(t, err)
+		_, err = manager.PublishWebDAVFile(t.Context(), resourcePath, fileID, int64(len(content)), WebDAVMutationOptions{})
+		require.NoError(t, err)
+		return fileID
+	}
+	pending := func(fileID uint64) int {
+		return queryCount(t, databaseClient, `SELECT COUNT(*) FROM tg_file_part_delete_state_tab
+WHERE delete_state = 'pending' AND file_id = `+strconv.FormatUint(fileID, 10))
+	}
+	list := func() []FileVersion {
+		result, err := manager.ListFileVersions(t.Context(), FileVersionListRequest{Path: "/docs/notes.txt", Limit: 10})
+		require.NoError(t, err)
+		return result.Versions
+	}
+
+	first := publish("/docs/notes.txt", "one")
+	require.Empty(t, list())
+	second := publish("/docs/notes.txt", "two")
+	third := publish("/docs/notes.txt", "three")
+	versions := list()
+	require.Len(t, versions, 2)
+	require.Equal(t, second, versions[0].FileID)
+	require.Equal(t, first, versions[1].FileID)
+	require.Equal(t, int64(len("two")), versions[0].Size)
+	require.Zero(t, pending(first))
+
+	publish("/docs/notes.txt", "four")
+	versions = list()
+	require.Len(t, versions, 2)
+	require.Equal(t, third, versions[0].FileID)
+	require.Equal(t, 1, pending(first))
+	require.Zero(t, pending(second))
+
+	page, err := manager.ListFileVersions(t.Context(), FileVersionListRequest{Path: "/docs/notes.txt", Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, versions[0].VersionID, page.NextCursor)
+	page, err = manager.ListFileVersions(t.Context(), FileVersionListRequest{
+		Path: "/docs/notes.txt", Cursor: page.NextCursor, Limit: 1,
+	})
+	require.NoError(t, err)
+	require.Len(t, page.Versions, 1)
+	require.Equal(t, second, page.Versions[0].FileID)
+	require.Zero(t, page.NextCursor)
+	_, err = manager.ListFileVersions(t.Context(), FileVersionListRequest{Path: "notes.txt", Limit: 1})
+	require.ErrorIs(t, err, ErrInvalidVersionRequest)
+
+	_, err = manager.RestoreFileVersion(t.Context(), versions[1].VersionID, "", WebDAVMutationOptions{})
+	require.NoError(t, err)
+	requireFileContent(t, manager, "/docs/notes.txt", "two")
+	require.Equal(t, versions[0].VersionID, list()[1].VersionID)
+
+	_, err = manager.RestoreFileVersion(t.Context(), versions[0].VersionID, "/docs/copy.txt", WebDAVMutationOptions{})
+	require.NoError(t, err)
+	requireFileContent(t, manager, "/docs/copy.txt", "three")
+
+	deleted, err := manager.DeleteFileVersion(t.Context(), versions[0].VersionID)
+	require.NoError(t, err)
+	require.Equal(t, third, deleted.FileID)
+	require.Zero(t, pending(third))
+	_, err = manager.GetFileVersion(t.Context(), versions[0].VersionID)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestFileVersionWorkerExpiresOldVersions(t *testing.T) {
+	managerInterface, _, _ := newCreateFileTestManager(t, 32)
+	manager := managerInterface.(*defaultFileManager)
+	WithVersions(VersionOptions{KeepFor: time.Hour})(manager)
+	for _, content := range []string{"a", "b", "c"} {
+		fileID, err := manager.CreateFile(t.Context(), int64(len(content)), bytes.NewReader([]byte(content)))
+		require.NoError(t, err)
+		_, err = manager.PublishS3Object(t.Context(), "/bucket/key", fileID, int64(len(content)), &entity.S3ObjectMetadata{}, nil)
+		require.NoError(t, err)
+	}
+	result, err := manager.ListFileVersions(t.Context(), FileVersionListRequest{Path: "/bucket/key", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, result.Versions, 2)
+
+	deleted, err := manager.deleteExpiredFileVersions(t.Context(), time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+	deleted, err = manager.deleteExpiredFileVersions(t.Context(), time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+	result, err = manager.ListFileVersions(t.Context(), FileVersionListRequest{Path: "/bucket/key", Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, result.Versions)
+}
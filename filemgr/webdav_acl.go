@@ -0,0 +1,413 @@
+package filemgr
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/directory"
+)
+
+// WebDAVPrivilege is a set of RFC 3744 privileges.
+type WebDAVPrivilege uint32
+
+const (
+	WebDAVPrivilegeRead WebDAVPrivilege = 1 << iota
+	WebDAVPrivilegeWriteProperties
+	WebDAVPrivilegeWriteContent
+	WebDAVPrivilegeBind
+	WebDAVPrivilegeUnbind
+	WebDAVPrivilegeReadACL
+	WebDAVPrivilegeWriteACL
+)
+
+const (
+	// WebDAVPrivilegeWrite is the DAV:write aggregate. DAV:write-acl is not
+	// part of it, as in RFC 3744.
+	WebDAVPrivilegeWrite = WebDAVPrivilegeWriteProperties | WebDAVPrivilegeWriteContent |
+		WebDAVPrivilegeBind | WebDAVPrivilegeUnbind
+	WebDAVPrivilegeAll = WebDAVPrivilegeRead | WebDAVPrivilegeWrite |
+		WebDAVPrivilegeReadACL | WebDAVPrivilegeWriteACL
+)
+
+// Principals an ACE can name besides WebDAVUserPrincipal and
+// WebDAVGroupPrincipal. Every WebDAV request is authenticated, so both match
+// any principal.
+const (
+	WebDAVPrincipalAll           = "all"
+	WebDAVPrincipalAuthenticated = "authenticated"
+)
+
+const (
+	webDAVUserPrincipalPrefix  = "user:"
+	webDAVGroupPrincipalPrefix = "group:"
+)
+
+func WebDAVUserPrincipal(username string) string {
+	return webDAVUserPrincipalPrefix + username
+}
+
+func WebDAVGroupPrincipal(group string) string {
+	return webDAVGroupPrincipalPrefix + group
+}
+
+// WebDAVACE is one access control entry. Inherited is the number of levels
+// above the resource of the collection that set the entry; it is 0 for the
+// resource's own entries.
+type WebDAVACE struct {
+	Principal  string
+	Deny       bool
+	Privileges WebDAVPrivilege
+	Inherited  int
+}
+
+type IWebDAVACLManager interface {
+	ReadWebDAVACL(ctx context.Context, entryID uint64) ([]WebDAVACE, error)
+	WebDAVPrivileges(
+		ctx context.Context,
+		entryID uint64,
+		principal string,
+		groups []string,
+	) (WebDAVPrivilege, error)
+	SetWebDAVACL(
+		ctx context.Context,
+		path string,
+		aces []WebDAVACE,
+		options WebDAVMutationOptions,
+	) error
+}
+
+// ReadWebDAVACL returns the entry's own ACEs followed by those inherited from
+// its ancestors, nearest first, in evaluation order.
+func (d *defaultFileManager) ReadWebDAVACL(ctx context.Context, entryID uint64) ([]WebDAVACE, error) {
+	return queryWebDAVACL(ctx, d.dbc, entryID)
+}
+
+// WebDAVPrivileges evaluates the entry's ACL for a principal and its groups.
+func (d *defaultFileManager) WebDAVPrivileges(
+	ctx context.Context,
+	entryID uint64,
+	principal string,
+	groups []string,
+) (WebDAVPrivilege, error) {
+	aces, err := queryWebDAVACL(ctx, d.dbc, entryID)
+	if err != nil {
+		return 0, err
+	}
+	owner, err := queryWebDAVOwner(ctx, d.dbc, entryID)
+	if err != nil {
+		return 0, err
+	}
+	return evaluateWebDAVACL(aces, principal, groups, owner), nil
+}
+
+// SetWebDAVACL replaces the resource's own ACEs. Inherited entries are
+// changed on the collection that set them.
+func (d *defaultFileManager) SetWebDAVACL(
+	ctx context.Context,
+	resourcePath string,
+	aces []WebDAVACE,
+	options WebDAVMutationOptions,
+) error {
+	for _, ace := range aces {
+		if ace.Inherited != 0 || strings.TrimSpace(ace.Principal) == "" ||
+			ace.Privileges == 0 || ace.Privileges&^WebDAVPrivilegeAll != 0 {
+			return ErrWebDAVACL
+		}
+	}
+	err := d.objectDir.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
+		entry, exists, err := tx.Stat(ctx, resourcePath)
+		if err != nil {
+			return fmt.Errorf("stat WebDAV ACL target: %w", err)
+		}
+		if !exists {
+			return os.ErrNotExist
+		}
+		if err := assertWebDAVPrivilegeTx(ctx, tx, resourcePath, WebDAVPrivilegeWriteACL, options); err != nil {
+			return err
+		}
+		if _, err := tx.QueryExecer().ExecContext(
+			ctx,
+			"DELETE FROM tg_webdav_ace_tab WHERE entry_id = ?",
+			entry.EntryID(),
+		); err != nil {
+			return fmt.Errorf("delete WebDAV ACL: %w", err)
+		}
+		now := time.Now().UnixMilli()
+		for position, ace := range aces {
+			if _, err := tx.QueryExecer().ExecContext(
+				ctx,
+				`INSERT INTO tg_webdav_ace_tab (entry_id, position, principal, deny, privileges, ctime)
+VALUES (?, ?, ?, ?, ?, ?)`,
+				entry.EntryID(),
+				position,
+				ace.Principal,
+				boolToInteger(ace.Deny),
+				ace.Privileges,
+				now,
+			); err != nil {
+				return fmt.Errorf("insert WebDAV ACE: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("set WebDAV ACL: %w", err)
+	}
+	return nil
+}
+
+func queryWebDAVACL(
+	ctx context.Context,
+	queryer database.IQueryer,
+	entryID uint64,
+) ([]WebDAVACE, error) {
+	rows, err := queryer.QueryContext(
+		ctx,
+		`WITH RECURSIVE ancestry (entry_id, parent_entry_id, hops) AS (
+SELECT entry_id, parent_entry_id, 0 FROM tg_file_mapping_tab WHERE entry_id = ?
+UNION ALL
+SELECT parent.entry_id, parent.parent_entry_id, ancestry.hops + 1
+FROM ancestry JOIN tg_file_mapping_tab parent ON parent.entry_id = ancestry.parent_entry_id
+WHERE ancestry.parent_entry_id != 0
+)
+SELECT ancestry.hops, ace.principal, ace.deny, ace.privileges
+FROM ancestry JOIN tg_webdav_ace_tab ace ON ace.entry_id = ancestry.entry_id
+ORDER BY ancestry.hops, ace.position`,
+		entryID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query WebDAV ACL: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	aces := make([]WebDAVACE, 0)
+	for rows.Next() {
+		var (
+			ace  WebDAVACE
+			deny int
+		)
+		if err := rows.Scan(&ace.Inherited, &ace.Principal, &deny, &ace.Privileges); err != nil {
+			return nil, fmt.Errorf("scan WebDAV ACE: %w", err)
+		}
+		ace.Deny = deny != 0
+		aces = append(aces, ace)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate WebDAV ACL: %w", err)
+	}
+	return aces, nil
+}
+
+// webDAVACLChains holds the parent and own ACEs of a set of entries and of
+// all their ancestors.
+type webDAVACLChains struct {
+	parents map[uint64]uint64
+	own     map[uint64][]WebDAVACE
+}
+
+// queryWebDAVACLChains reads what queryWebDAVACL reads for each of entryIDs
+// in one query; shared ancestors are read once.
+func queryWebDAVACLChains(
+	ctx context.Context,
+	queryer database.IQueryer,
+	entryIDs []uint64,
+) (*webDAVACLChains, error) {
+	args := make([]any, 0, len(entryIDs))
+	for _, entryID := range entryIDs {
+		args = append(args, entryID)
+	}
+	rows, err := queryer.QueryContext(
+		ctx,
+		`WITH RECURSIVE ancestry (entry_id, parent_entry_id) AS (
+SELECT entry_id, parent_entry_id FROM tg_file_mapping_tab
+WHERE entry_id IN (?`+strings.Repeat(", ?", len(entryIDs)-1)+`)
+UNION
+SELECT parent.entry_id, parent.parent_entry_id
+FROM ancestry JOIN tg_file_mapping_tab parent ON parent.entry_id = ancestry.parent_entry_id
+WHERE ancestry.parent_entry_id != 0
+)
+SELECT ancestry.entry_id, ancestry.parent_entry_id, ace.principal, ace.deny, ace.privileges
+FROM ancestry LEFT JOIN tg_webdav_ace_tab ace ON ace.entry_id = ancestry.entry_id
+ORDER BY ancestry.entry_id, ace.position`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query WebDAV ACL chains: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	chains := &webDAVACLChains{parents: make(map[uint64]uint64), own: make(map[uint64][]WebDAVACE)}
+	for rows.Next() {
+		var (
+			entryID, parentID uint64
+			principal         sql.Null[string]
+			deny              sql.Null[int64]
+			privileges        sql.Null[WebDAVPrivilege]
+		)
+		if err := rows.Scan(&entryID, &parentID, &principal, &deny, &privileges); err != nil {
+			return nil, fmt.Errorf("scan WebDAV ACL chain: %w", err)
+		}
+		chains.parents[entryID] = parentID
+		if principal.Valid {
+			chains.own[entryID] = append(chains.own[entryID], WebDAVACE{
+				Principal:  principal.V,
+				Deny:       deny.V != 0,
+				Privileges: privileges.V,
+			})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate WebDAV ACL chains: %w", err)
+	}
+	return chains, nil
+}
+
+// aces returns the ACL of entryID in the evaluation order of
+// queryWebDAVACL.
+func (c *webDAVACLChains) aces(entryID uint64) []WebDAVACE {
+	aces := make([]WebDAVACE, 0)
+	for hops, current := 0, entryID; ; hops++ {
+		for _, ace := range c.own[current] {
+			ace.Inherited = hops
+			aces = append(aces, ace)
+		}
+		parent, exists := c.parents[current]
+		if !exists || parent == 0 {
+			return aces
+		}
+		current = parent
+	}
+}
+
+// queryWebDAVOwner returns the creator of an entry, the WebDAV owner. The
+// mapping owner follows the last writer for quotas and would let anyone
+// holding DAV:write take over the ACL by overwriting the file.
+func queryWebDAVOwner(ctx context.Context, queryer database.IQueryer, entryID uint64) (string, error) {
+	rows, err := queryer.QueryContext(ctx, "SELECT creator FROM tg_file_mapping_tab WHERE entry_id = ?", entryID)
+	if err != nil {
+		return "", fmt.Errorf("query WebDAV owner: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var owner string
+	if rows.Next() {
+		if err := rows.Scan(&owner); err != nil {
+			return "", fmt.Errorf("scan WebDAV owner: %w", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("read WebDAV owner: %w", err)
+	}
+	return owner, nil
+}
+
+// evaluateWebDAVACL lets the first matching ACE decide each privilege, so a
+// resource's own entries override inherited ones and nearer collections
+// override farther ones. Privileges no entry decides stay granted, except
+// DAV:write-acl, which only the resource's owner keeps by default; the
+// webdav:read and webdav:write permissions remain the upper bound.
+func evaluateWebDAVACL(aces []WebDAVACE, principal string, groups []string, owner string) WebDAVPrivilege {
+	var decided, granted WebDAVPrivilege
+	for _, ace := range aces {
+		if !webDAVPrincipalMatches(ace.Principal, principal, groups) {
+			continue
+		}
+		undecided := ace.Privileges &^ decided
+		if !ace.Deny {
+			granted |= undecided
+		}
+		decided |= undecided
+	}
+	defaults := WebDAVPrivilegeAll &^ WebDAVPrivilegeWriteACL
+	if principal != "" && principal == owner {
+		defaults = WebDAVPrivilegeAll
+	}
+	return granted | defaults&^decided
+}
+
+func webDAVPrincipalMatches(ace, principal string, groups []string) bool {
+	switch {
+	case ace == WebDAVPrincipalAll, ace == WebDAVPrincipalAuthenticated:
+		return principal != ""
+	case strings.HasPrefix(ace, webDAVUserPrincipalPrefix):
+		return ace == WebDAVUserPrincipal(principal)
+	case strings.HasPrefix(ace, webDAVGroupPrincipalPrefix):
+		return slices.Contains(groups, strings.TrimPrefix(ace, webDAVGroupPrincipalPrefix))
+	}
+	return false
+}
+
+// assertWebDAVPrivilegeTx rejects a mutation unless the principal holds every
+// required privilege on resourcePath. Mutations without a principal do not
+// come from WebDAV clients and, like those of administrators, are not subject
+// to WebDAV ACLs; a missing target is left to the mutation itself to report.
+func assertWebDAVPrivilegeTx(
+	ctx context.Context,
+	tx directory.ITransaction,
+	resourcePath string,
+	required WebDAVPrivilege,
+	options WebDAVMutationOptions,
+) error {
+	if options.Principal == "" || options.Admin {
+		return nil
+	}
+	entry, exists, err := tx.Stat(ctx, resourcePath)
+	if err != nil {
+		return fmt.Errorf("stat WebDAV ACL target: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+	aces, err := queryWebDAVACL(ctx, tx.QueryExecer(), entry.EntryID())
+	if err != nil {
+		return err
+	}
+	owner, err := queryWebDAVOwner(ctx, tx.QueryExecer(), entry.EntryID())
+	if err != nil {
+		return err
+	}
+	if evaluateWebDAVACL(aces, options.Principal, options.Groups, owner)&required != required {
+		return ErrWebDAVForbidden
+	}
+	return nil
+}
+
+// assertWebDAVParentPrivilegeTx checks DAV:bind or DAV:unbind, which RFC 3744
+// requires on the collection a member is added to or removed from.
+func assertWebDAVParentPrivilegeTx(
+	ctx context.Context,
+	tx directory.ITransaction,
+	resourcePath string,
+	required WebDAVPrivilege,
+	options WebDAVMutationOptions,
+) error {
+	return assertWebDAVPrivilegeTx(ctx, tx, path.Dir(path.Clean(resourcePath)), required, options)
+}
+
+func deleteWebDAVACL(
+	ctx context.Context,
+	exec database.IExecer,
+	entries []directory.IDirectoryEntry,
+) error {
+	for _, entry := range entries {
+		if _, err := exec.ExecContext(
+			ctx,
+			"DELETE FROM tg_webdav_ace_tab WHERE entry_id = ?",
+			entry.EntryID(),
+		); err != nil {
+			return fmt.Errorf("delete WebDAV ACL: %w", err)
+		}
+	}
+	return nil
+}
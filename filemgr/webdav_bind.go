@@ -0,0 +1,362 @@
+package filemgr
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/directory"
+)
+
+// WebDAVBinding is one path that refers to a resource.
+type WebDAVBinding struct {
+	EntryID uint64
+	Path    string
+}
+
+// WebDAVBindingSet describes a resource and every path bound to it. An
+// unbound mapping is its own resource with a single binding.
+type WebDAVBindingSet struct {
+	ResourceID uint64
+	Bindings   []WebDAVBinding
+}
+
+type IWebDAVBindManager interface {
+	ReadWebDAVBindings(ctx context.Context, entryID uint64) (*WebDAVBindingSet, error)
+	BindWebDAVResource(
+		ctx context.Context,
+		source, destination string,
+		overwrite bool,
+		options WebDAVMutationOptions,
+	) (*WebDAVMutationResult, error)
+}
+
+func (d *defaultFileManager) ReadWebDAVBindings(ctx context.Context, entryID uint64) (*WebDAVBindingSet, error) {
+	return queryWebDAVBindings(ctx, d.dbc, entryID)
+}
+
+// BindWebDAVResource adds destination as another binding of the file at
+// source. The new Mapping references the same File, so it is neither uploaded
+// nor charged against the quota again. A collection has exactly one parent in
+// the Mapping tree and cannot be bound.
+func (d *defaultFileManager) BindWebDAVResource(
+	ctx context.Context,
+	source, destination string,
+	overwrite bool,
+	options WebDAVMutationOptions,
+) (*WebDAVMutationResult, error) {
+	if err := d.cleanupExpiredWebDAVLocks(ctx); err != nil {
+		return nil, err
+	}
+	var result WebDAVMutationResult
+	err := d.objectDir.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
+		return bindWebDAVResourceTx(ctx, tx, source, destination, overwrite, options, &result)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bind WebDAV resource: %w", err)
+	}
+	return &result, nil
+}
+
+func bindWebDAVResourceTx(
+	ctx context.Context,
+	tx directory.ITransaction,
+	source, destination string,
+	overwrite bool,
+	options WebDAVMutationOptions,
+	result *WebDAVMutationResult,
+) error {
+	sourceEntry, fileID, err := statWebDAVBindSourceTx(ctx, tx, source, destination, options)
+	if err != nil {
+		return err
+	}
+	destinationExists, err := prepareWebDAVCopyDestinationTx(ctx, tx, destination, options)
+	if err != nil {
+		return err
+	}
+	if destinationExists {
+		if !overwrite {
+			return directory.ErrDestinationExists
+		}
+		if err := removeWebDAVBindDestinationTx(ctx, tx, destination, options); err != nil {
+			return err
+		}
+	}
+	if err := enforceWebDAVQuotaTx(
+		ctx,
+		tx.QueryExecer(),
+		options.QuotaRoot,
+		options.QuotaBytes,
+		nil,
+		0,
+		fileID,
+		sourceEntry.Size(),
+	); err != nil {
+		return err
+	}
+	if err := ensureFileTreeCanBeLinked(ctx, tx.QueryExecer(), fileID); err != nil {
+		return err
+	}
+	entry, err := tx.Create(ctx, destination, sourceEntry.Size(), sourceEntry.RefData())
+	if err != nil {
+		return fmt.Errorf("create WebDAV binding mapping: %w", err)
+	}
+	copies := []directory.EntryCopy{{Source: sourceEntry, Destination: entry}}
+	if err := copyWebDAVMetadata(ctx, tx.QueryExecer(), copies); err != nil {
+		return err
+	}
+	if err := copyWebDAVProperties(ctx, tx.QueryExecer(), copies); err != nil {
+		return err
+	}
+	result.Created = !destinationExists
+	return insertWebDAVBindingTx(ctx, tx.QueryExecer(), sourceEntry.EntryID(), entry.EntryID())
+}
+
+func statWebDAVBindSourceTx(
+	ctx context.Context,
+	tx directory.ITransaction,
+	source, destination string,
+	options WebDAVMutationOptions,
+) (directory.IDirectoryEntry, uint64, error) {
+	entry, exists, err := tx.Stat(ctx, source)
+	if err != nil {
+		return nil, 0, fmt.Errorf("stat WebDAV bind source: %w", err)
+	}
+	if !exists {
+		return nil, 0, os.ErrNotExist
+	}
+	// Replacing an ancestor of the source would remove the source itself.
+	if entry.IsDir() || webDAVChangeInScope(destination, source, webDAVLockDepthInfinity) {
+		return nil, 0, ErrWebDAVBinding
+	}
+	if err := assertWebDAVPrivilegeTx(ctx, tx, source, WebDAVPrivilegeRead, options); err != nil {
+		return nil, 0, err
+	}
+	fileID, err := strconv.ParseUint(entry.RefData(), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("parse WebDAV bind source file id: %w", err)
+	}
+	return entry, fileID, nil
+}
+
+// removeWebDAVBindDestinationTx clears the segment a binding replaces, before
+// the quota check so the replaced resource no longer counts.
+func removeWebDAVBindDestinationTx(
+	ctx context.Context,
+	tx directory.ITransaction,
+	destination string,
+	options WebDAVMutationOptions,
+) error {
+	entry, _, err := tx.Stat(ctx, destination)
+	if err != nil {
+		return fmt.Errorf("stat WebDAV bind destination: %w", err)
+	}
+	if err := enforceWebDAVMutationLimitTx(ctx, tx.QueryExecer(), entry.EntryID(), options.MaxEntries); err != nil {
+		return err
+	}
+	removed, err := tx.Remove(ctx, destination)
+	if err != nil {
+		return fmt.Errorf("remove WebDAV bind destination: %w", err)
+	}
+	return finalizeRemovedWebDAVEntries(ctx, tx.QueryExecer(), removed)
+}
+
+// insertWebDAVBindingTx joins entryID to the resource of sourceEntryID. A
+// source that was never bound becomes a resource identified by its own
+// entry_id, so its DAV:resource-id does not change.
+func insertWebDAVBindingTx(
+	ctx context.Context,
+	exec database.IExecer,
+	sourceEntryID, entryID uint64,
+) error {
+	if _, err := exec.ExecContext(
+		ctx,
+		`INSERT INTO tg_webdav_binding_tab (entry_id, resource_id) VALUES (?, ?)
+ON CONFLICT(entry_id) DO NOTHING`,
+		sourceEntryID,
+		sourceEntryID,
+	); err != nil {
+		return fmt.Errorf("insert WebDAV binding source: %w", err)
+	}
+	if _, err := exec.ExecContext(
+		ctx,
+		`INSERT INTO tg_webdav_binding_tab (entry_id, resource_id)
+SELECT ?, resource_id FROM tg_webdav_binding_tab WHERE entry_id = ?`,
+		entryID,
+		sourceEntryID,
+	); err != nil {
+		return fmt.Errorf("insert WebDAV binding: %w", err)
+	}
+	return nil
+}
+
+// queryWebDAVBindings resolves the full path of every Mapping bound to the
+// same resource as entryID, including entryID itself.
+func queryWebDAVBindings(
+	ctx context.Context,
+	queryer database.IQueryer,
+	entryID uint64,
+) (*WebDAVBindingSet, error) {
+	set := &WebDAVBindingSet{ResourceID: entryID}
+	rows, err := queryer.QueryContext(
+		ctx,
+		`WITH RECURSIVE member (entry_id) AS (
+SELECT entry_id FROM tg_webdav_binding_tab
+WHERE resource_id = (SELECT resource_id FROM tg_webdav_binding_tab WHERE entry_id = ?)
+UNION SELECT ?
+),
+ancestry (entry_id, cursor, relative) AS (
+SELECT mapping.entry_id, mapping.parent_entry_id, '/' || mapping.file_name
+FROM member JOIN tg_file_mapping_tab mapping ON mapping.entry_id = member.entry_id
+UNION ALL
+SELECT ancestry.entry_id, parent.parent_entry_id, '/' || parent.file_name || ancestry.relative
+FROM ancestry JOIN tg_file_mapping_tab parent ON parent.entry_id = ancestry.cursor
+WHERE parent.parent_entry_id != 0
+)
+SELECT ancestry.entry_id, ancestry.relative,
+COALESCE((SELECT resource_id FROM tg_webdav_binding_tab WHERE entry_id = ?), ?)
+FROM ancestry JOIN tg_file_mapping_tab root ON root.entry_id = ancestry.cursor
+WHERE root.parent_entry_id = 0
+ORDER BY ancestry.relative`,
+		entryID,
+		entryID,
+		entryID,
+		entryID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query WebDAV bindings: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var binding WebDAVBinding
+		if err := rows.Scan(&binding.EntryID, &binding.Path, &set.ResourceID); err != nil {
+			return nil, fmt.Errorf("scan WebDAV binding: %w", err)
+		}
+		set.Bindings = append(set.Bindings, binding)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate WebDAV bindings: %w", err)
+	}
+	return set, nil
+}
+
+// otherWebDAVBindings returns the bindings of resourcePath's resource other
+// than resourcePath itself.
+func otherWebDAVBindings(set *WebDAVBindingSet, resourcePath string) []WebDAVBinding {
+	others := make([]WebDAVBinding, 0, len(set.Bindings))
+	for _, binding := range set.Bindings {
+		if binding.Path != path.Clean(resourcePath) {
+			others = append(others, binding)
+		}
+	}
+	return others
+}
+
+// prepareWebDAVPublishBindingsTx returns the other bindings a PUT to an
+// existing resource replaces, after checking that the PUT may write them.
+func prepareWebDAVPublishBindingsTx(
+	ctx context.Context,
+	tx directory.ITransaction,
+	resourcePath string,
+	current directory.IDirectoryEntry,
+	options WebDAVMutationOptions,
+) ([]WebDAVBinding, error) {
+	if current == nil {
+		return nil, nil
+	}
+	set, err := queryWebDAVBindings(ctx, tx.QueryExecer(), current.EntryID())
+	if err != nil {
+		return nil, err
+	}
+	others := otherWebDAVBindings(set, resourcePath)
+	if err := assertWebDAVBindingsWritableTx(ctx, tx, others, WebDAVPrivilegeWriteContent, options); err != nil {
+		return nil, err
+	}
+	return others, nil
+}
+
+// assertWebDAVBindingsWritableTx applies the lock and privilege checks of a
+// write to every other binding it will reach, so binding a resource into a
+// writable collection cannot bypass the locks or ACL of the original path.
+func assertWebDAVBindingsWritableTx(
+	ctx context.Context,
+	tx directory.ITransaction,
+	others []WebDAVBinding,
+	required WebDAVPrivilege,
+	options WebDAVMutationOptions,
+) error {
+	for _, binding := range others {
+		if err := assertWebDAVLocksTx(
+			ctx,
+			tx.QueryExecer(),
+			[]string{binding.Path},
+			options.Principal,
+			options.Condition,
+		); err != nil {
+			return err
+		}
+		if err := assertWebDAVPrivilegeTx(ctx, tx, binding.Path, required, options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceWebDAVBindingsTx points the other bindings of a resource at the File
+// a PUT just published through one of them.
+func (d *defaultFileManager) replaceWebDAVBindingsTx(
+	ctx context.Context,
+	tx directory.ITransaction,
+	others []WebDAVBinding,
+	fileID uint64,
+	size int64,
+) error {
+	for _, binding := range others {
+		entry, err := d.replaceWebDAVMappingTx(ctx, tx, binding.Path, fileID, size)
+		if err != nil {
+			return err
+		}
+		link, err := webDAVEntryToLink(binding.Path, entry)
+		if err != nil {
+			return err
+		}
+		if err := insertS3Metadata(ctx, tx.QueryExecer(), webDAVS3Metadata(link)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webDAVBindingsUnderRoot counts the bindings a quota root charges for.
+func webDAVBindingsUnderRoot(bindings []WebDAVBinding, root string) int {
+	count := 0
+	for _, binding := range bindings {
+		if webDAVChangeInScope(root, binding.Path, webDAVLockDepthInfinity) {
+			count++
+		}
+	}
+	return count
+}
+
+func deleteWebDAVBindings(
+	ctx context.Context,
+	exec database.IExecer,
+	entries []directory.IDirectoryEntry,
+) error {
+	for _, entry := range entries {
+		if _, err := exec.ExecContext(
+			ctx,
+			"DELETE FROM tg_webdav_binding_tab WHERE entry_id = ?",
+			entry.EntryID(),
+		); err != nil {
+			return fmt.Errorf("delete WebDAV binding: %w", err)
+		}
+	}
+	return nil
+}
@@ -13,11 +13,10 @@ import (
 
 func (d *defaultFileManager) removeWebDAVLink(ctx context.Context, link string) error {
 	if err := d.objectDir.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
-		removed, err := tx.Remove(ctx, link)
-		if err != nil {
-			return fmt.Errorf("remove mapping entry: %w", err)
+		if _, exists, err := tx.Stat(ctx, link); err != nil || !exists {
+			return err
 		}
-		return finalizeRemovedWebDAVEntries(ctx, tx.QueryExecer(), removed)
+		return d.removeOrTrashTx(ctx, tx, link, TrashOrigin{Protocol: TrashProtocolFile})
 	}); err != nil {
 		return fmt.Errorf("remove file link %q: %w", link, err)
 	}
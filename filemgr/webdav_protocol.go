@@ -13,7 +13,6 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/xxxsen/common/database"
-	"github.com/xxxsen/mimetype"
 
 	"github.com/xxxsen/tgfile/directory"
 	"github.com/xxxsen/tgfile/entity"
@@ -58,6 +57,9 @@ func (d *defaultFileManager) CreateWebDAVCollection(
 		} else if exists {
 			return os.ErrExist
 		}
+		if err := assertWebDAVParentPrivilegeTx(ctx, tx, resourcePath, WebDAVPrivilegeBind, options); err != nil {
+			return err
+		}
 		if _, err := tx.Mkdir(ctx, resourcePath); err != nil {
 			return fmt.Errorf("create WebDAV collection mapping: %w", err)
 		}
@@ -107,7 +109,7 @@ func (d *defaultFileManager) publishWebDAVFileTx(
 	options WebDAVMutationOptions,
 	result *WebDAVPublishResult,
 ) error {
-	exists, err := prepareWebDAVPublishTx(
+	exists, others, err := prepareWebDAVPublishTx(
 		ctx,
 		tx,
 		resourcePath,
@@ -118,6 +120,9 @@ func (d *defaultFileManager) publishWebDAVFileTx(
 	if err != nil {
 		return err
 	}
+	if err := d.replaceWebDAVBindingsTx(ctx, tx, others, fileID, size); err != nil {
+		return err
+	}
 	entry, created, err := d.storeWebDAVMappingTx(
 		ctx,
 		tx,
@@ -149,6 +154,8 @@ func (d *defaultFileManager) publishWebDAVFileTx(
 	return nil
 }
 
+// prepareWebDAVPublishTx checks a PUT and returns whether the target exists
+// and the other bindings of its resource, which the PUT replaces as well.
 func prepareWebDAVPublishTx(
 	ctx context.Context,
 	tx directory.ITransaction,
@@ -156,23 +163,23 @@ func prepareWebDAVPublishTx(
 	fileID uint64,
 	size int64,
 	options WebDAVMutationOptions,
-) (bool, error) {
+) (bool, []WebDAVBinding, error) {
 	currentEntry, exists, err := tx.Stat(ctx, resourcePath)
 	if err != nil {
-		return false, fmt.Errorf("stat WebDAV publish target: %w", err)
+		return false, nil, fmt.Errorf("stat WebDAV publish target: %w", err)
 	}
 	var currentLink *entity.FileLinkMeta
 	if exists {
 		currentLink, err = webDAVEntryToLink(resourcePath, currentEntry)
 		if err != nil {
-			return false, err
+			return false, nil, err
 		}
 		if currentEntry.IsDir() {
-			return false, directory.ErrEntryNotFile
+			return false, nil, directory.ErrEntryNotFile
 		}
 	}
 	if err := evaluateWebDAVCondition(currentLink, exists, options.Condition); err != nil {
-		return false, err
+		return false, nil, err
 	}
 	if err := assertWebDAVLocksTx(
 		ctx,
@@ -181,7 +188,18 @@ func prepareWebDAVPublishTx(
 		options.Principal,
 		options.Condition,
 	); err != nil {
-		return false, err
+		return false, nil, err
+	}
+	required, target := WebDAVPrivilegeWriteContent, resourcePath
+	if !exists {
+		required, target = WebDAVPrivilegeBind, path.Dir(path.Clean(resourcePath))
+	}
+	if err := assertWebDAVPrivilegeTx(ctx, tx, target, required, options); err != nil {
+		return false, nil, err
+	}
+	others, err := prepareWebDAVPublishBindingsTx(ctx, tx, resourcePath, currentEntry, options)
+	if err != nil {
+		return false, nil, err
 	}
 	if err := enforceWebDAVQuotaTx(
 		ctx,
@@ -189,15 +207,16 @@ func prepareWebDAVPublishTx(
 		options.QuotaRoot,
 		options.QuotaBytes,
 		currentLink,
+		1+webDAVBindingsUnderRoot(others, options.QuotaRoot),
 		fileID,
 		size,
 	); err != nil {
-		return false, err
+		return false, nil, err
 	}
 	if err := ensureFileTreeCanBeLinked(ctx, tx.QueryExecer(), fileID); err != nil {
-		return false, err
+		return false, nil, err
 	}
-	return exists, nil
+	return exists, others, nil
 }
 
 func (d *defaultFileManager) storeWebDAVMappingTx(
@@ -254,7 +273,8 @@ func (d *defaultFileManager) replaceWebDAVMappingTx(
 		return nil, err
 	}
 	if len(oldFileIDs) != 0 && oldFileIDs[0] != fileID {
-		if err := markMappingFilesPending(ctx, tx.QueryExecer(), oldFileIDs); err != nil {
+		replaced := &FileVersion{FileID: oldFileIDs[0], Size: previous.Size(), Mtime: previous.Mtime()}
+		if err := d.retireReplacedFileTx(ctx, tx.QueryExecer(), resourcePath, replaced, time.Now().UnixMilli()); err != nil {
 			return nil, err
 		}
 	}
@@ -301,11 +321,13 @@ func (d *defaultFileManager) DeleteWebDAVResource(
 		); err != nil {
 			return err
 		}
-		removed, err := tx.Remove(ctx, resourcePath)
-		if err != nil {
-			return fmt.Errorf("remove WebDAV resource mapping: %w", err)
+		if err := assertWebDAVParentPrivilegeTx(ctx, tx, resourcePath, WebDAVPrivilegeUnbind, options); err != nil {
+			return err
 		}
-		return finalizeRemovedWebDAVEntries(ctx, tx.QueryExecer(), removed)
+		return d.removeOrTrashTx(ctx, tx, resourcePath, TrashOrigin{
+			Principal: options.Principal,
+			Protocol:  TrashProtocolWebDAV,
+		})
 	})
 	if err != nil {
 		return fmt.Errorf("delete WebDAV resource: %w", err)
@@ -363,6 +385,9 @@ func copyWebDAVResourceTx(
 	if err := evaluateWebDAVCondition(sourceLink, true, options.Condition); err != nil {
 		return err
 	}
+	if err := assertWebDAVPrivilegeTx(ctx, tx, source, WebDAVPrivilegeRead, options); err != nil {
+		return err
+	}
 	if recursive {
 		if err := enforceWebDAVMutationLimitTx(
 			ctx,
@@ -425,9 +450,27 @@ func prepareWebDAVCopyDestinationTx(
 	); err != nil {
 		return false, err
 	}
+	if err := assertWebDAVParentPrivilegeTx(
+		ctx,
+		tx,
+		destination,
+		webDAVDestinationPrivilege(destinationExists),
+		options,
+	); err != nil {
+		return false, err
+	}
 	return destinationExists, nil
 }
 
+// webDAVDestinationPrivilege is what COPY and MOVE need on the destination's
+// parent: DAV:bind, plus DAV:unbind when an existing member is replaced.
+func webDAVDestinationPrivilege(destinationExists bool) WebDAVPrivilege {
+	if destinationExists {
+		return WebDAVPrivilegeBind | WebDAVPrivilegeUnbind
+	}
+	return WebDAVPrivilegeBind
+}
+
 func (d *defaultFileManager) MoveWebDAVResource(
 	ctx context.Context,
 	source, destination string,
@@ -439,67 +482,15 @@ func (d *defaultFileManager) MoveWebDAVResource(
 	}
 	var result WebDAVMutationResult
 	err := d.objectDir.WithTransaction(ctx, func(ctx context.Context, tx directory.ITransaction) error {
-		sourceEntry, sourceExists, err := tx.Stat(ctx, source)
-		if err != nil {
-			return fmt.Errorf("stat WebDAV move source: %w", err)
-		}
-		if !sourceExists {
-			return os.ErrNotExist
-		}
-		sourceLink, err := webDAVEntryToLink(source, sourceEntry)
-		if err != nil {
-			return err
-		}
-		if err := evaluateWebDAVCondition(sourceLink, true, options.Condition); err != nil {
-			return err
-		}
-		if err := enforceWebDAVMutationLimitTx(
-			ctx,
-			tx.QueryExecer(),
-			sourceEntry.EntryID(),
-			options.MaxEntries,
-		); err != nil {
-			return err
-		}
-		if err := assertWebDAVTreeLocksTx(
-			ctx,
-			tx.QueryExecer(),
-			[]string{source, destination},
-			options.Principal,
-			options.Condition,
-		); err != nil {
-			return err
-		}
-		if err := ensureWebDAVParentTx(ctx, tx, destination); err != nil {
-			return err
-		}
-		_, destinationExists, err := tx.Stat(ctx, destination)
-		if err != nil {
-			return fmt.Errorf("stat WebDAV move destination: %w", err)
-		}
-		result.Created = !destinationExists
-		overwritten, err := tx.Move(ctx, source, destination, overwrite)
-		if err != nil {
-			return fmt.Errorf("move WebDAV directory entries: %w", err)
-		}
-		if err := finalizeRemovedWebDAVEntries(ctx, tx.QueryExecer(), overwritten); err != nil {
-			return err
-		}
-		cleanSource := path.Clean(source)
-		cleanDestination := path.Clean(destination)
-		if _, err := tx.QueryExecer().ExecContext(
+		return moveWebDAVResourceTx(
 			ctx,
-			`UPDATE tg_webdav_lock_tab
-SET root_path = ? || substr(root_path, ?)
-WHERE root_path = ? OR root_path LIKE ? ESCAPE '\'`,
-			cleanDestination,
-			len(cleanSource)+1,
-			cleanSource,
-			escapeSQLiteLike(cleanSource+"/")+"%",
-		); err != nil {
-			return fmt.Errorf("move WebDAV lock root: %w", err)
-		}
-		return nil
+			tx,
+			source,
+			destination,
+			overwrite,
+			options,
+			&result,
+		)
 	})
 	if err != nil {
 		return nil, fmt.Errorf("move WebDAV resource: %w", err)
@@ -507,6 +498,97 @@ WHERE root_path = ? OR root_path LIKE ? ESCAPE '\'`,
 	return &result, nil
 }
 
+func moveWebDAVResourceTx(
+	ctx context.Context,
+	tx directory.ITransaction,
+	source, destination string,
+	overwrite bool,
+	options WebDAVMutationOptions,
+	result *WebDAVMutationResult,
+) error {
+	sourceEntry, sourceExists, err := tx.Stat(ctx, source)
+	if err != nil {
+		return fmt.Errorf("stat WebDAV move source: %w", err)
+	}
+	if !sourceExists {
+		return os.ErrNotExist
+	}
+	sourceLink, err := webDAVEntryToLink(source, sourceEntry)
+	if err != nil {
+		return err
+	}
+	if err := evaluateWebDAVCondition(sourceLink, true, options.Condition); err != nil {
+		return err
+	}
+	if err := enforceWebDAVMutationLimitTx(
+		ctx,
+		tx.QueryExecer(),
+		sourceEntry.EntryID(),
+		options.MaxEntries,
+	); err != nil {
+		return err
+	}
+	if err := assertWebDAVTreeLocksTx(
+		ctx,
+		tx.QueryExecer(),
+		[]string{source, destination},
+		options.Principal,
+		options.Condition,
+	); err != nil {
+		return err
+	}
+	if err := assertWebDAVParentPrivilegeTx(ctx, tx, source, WebDAVPrivilegeUnbind, options); err != nil {
+		return err
+	}
+	if err := ensureWebDAVParentTx(ctx, tx, destination); err != nil {
+		return err
+	}
+	_, destinationExists, err := tx.Stat(ctx, destination)
+	if err != nil {
+		return fmt.Errorf("stat WebDAV move destination: %w", err)
+	}
+	if err := assertWebDAVParentPrivilegeTx(
+		ctx,
+		tx,
+		destination,
+		webDAVDestinationPrivilege(destinationExists),
+		options,
+	); err != nil {
+		return err
+	}
+	result.Created = !destinationExists
+	overwritten, err := tx.Move(ctx, source, destination, overwrite)
+	if err != nil {
+		return fmt.Errorf("move WebDAV directory entries: %w", err)
+	}
+	if err := finalizeRemovedWebDAVEntries(ctx, tx.QueryExecer(), overwritten); err != nil {
+		return err
+	}
+	return moveWebDAVLockRootsTx(ctx, tx.QueryExecer(), source, destination)
+}
+
+func moveWebDAVLockRootsTx(
+	ctx context.Context,
+	exec database.IExecer,
+	source, destination string,
+) error {
+	cleanSource := path.Clean(source)
+	cleanDestination := path.Clean(destination)
+	if _, err := exec.ExecContext(
+		ctx,
+		`UPDATE tg_webdav_lock_tab
+SET root_path = ? || substr(root_path, ?)
+WHERE root_path = ? OR root_path LIKE ? ESCAPE '\'`,
+		cleanDestination,
+		len(cleanSource)+1,
+		cleanSource,
+		escapeSQLiteLike(cleanSource+"/")+"%",
+	); err != nil {
+		return fmt.Errorf("move WebDAV lock root: %w", err)
+	}
+	return nil
+}
+
 func (d *defaultFileManager) ReadWebDAVProperties(
 	ctx context.Context,
 	entryID uint64,
@@ -567,39 +649,37 @@ func (d *defaultFileManager) PatchWebDAVProperties(
 		); err != nil {
 			return err
 		}
+		if err := assertWebDAVPrivilegeTx(
+			ctx,
+			tx,
+			resourcePath,
+			WebDAVPrivilegeWriteProperties,
+			options,
+		); err != nil {
+			return err
+		}
+		set, err := queryWebDAVBindings(ctx, tx.QueryExecer(), entry.EntryID())
+		if err != nil {
+			return err
+		}
+		if err := assertWebDAVBindingsWritableTx(
+			ctx,
+			tx,
+			otherWebDAVBindings(set, resourcePath),
+			WebDAVPrivilegeWriteProperties,
+			options,
+		); err != nil {
+			return err
+		}
 		now := time.Now().UnixMilli()
 		for _, patch := range patches {
 			if err := validateWebDAVDeadProperty(patch.Property); err != nil {
 				return err
 			}
-			if patch.Set {
-				if _, err := tx.QueryExecer().ExecContext(
-					ctx,
-					`INSERT INTO tg_webdav_property_tab (
-entry_id, namespace_uri, local_name, value_xml, ctime, mtime
-) VALUES (?, ?, ?, ?, ?, ?)
-ON CONFLICT(entry_id, namespace_uri, local_name)
-DO UPDATE SET value_xml = excluded.value_xml, mtime = excluded.mtime`,
-					entry.EntryID(),
-					patch.Property.Name.Namespace,
-					patch.Property.Name.LocalName,
-					patch.Property.ValueXML,
-					now,
-					now,
-				); err != nil {
-					return fmt.Errorf("set WebDAV property: %w", err)
+			for _, binding := range set.Bindings {
+				if err := applyWebDAVPropertyPatch(ctx, tx.QueryExecer(), binding.EntryID, patch, now); err != nil {
+					return err
 				}
-				continue
-			}
-			if _, err := tx.QueryExecer().ExecContext(
-				ctx,
-				`DELETE FROM tg_webdav_property_tab
-WHERE entry_id = ? AND namespace_uri = ? AND local_name = ?`,
-				entry.EntryID(),
-				patch.Property.Name.Namespace,
-				patch.Property.Name.LocalName,
-			); err != nil {
-				return fmt.Errorf("remove WebDAV property: %w", err)
 			}
 		}
 		return tx.Touch(ctx, resourcePath, now)
@@ -610,6 +690,47 @@ WHERE entry_id = ? AND namespace_uri = ? AND local_name = ?`,
 	return nil
 }
 
+// applyWebDAVPropertyPatch applies one PROPPATCH instruction to one binding.
+// Dead properties belong to the resource, so every binding gets the patch.
+func applyWebDAVPropertyPatch(
+	ctx context.Context,
+	exec database.IExecer,
+	entryID uint64,
+	patch WebDAVPropertyPatch,
+	now int64,
+) error {
+	if patch.Set {
+		if _, err := exec.ExecContext(
+			ctx,
+			`INSERT INTO tg_webdav_property_tab (
+entry_id, namespace_uri, local_name, value_xml, ctime, mtime
+) VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT(entry_id, namespace_uri, local_name)
+DO UPDATE SET value_xml = excluded.value_xml, mtime = excluded.mtime`,
+			entryID,
+			patch.Property.Name.Namespace,
+			patch.Property.Name.LocalName,
+			patch.Property.ValueXML,
+			now,
+			now,
+		); err != nil {
+			return fmt.Errorf("set WebDAV property: %w", err)
+		}
+		return nil
+	}
+	if _, err := exec.ExecContext(
+		ctx,
+		`DELETE FROM tg_webdav_property_tab
+WHERE entry_id = ? AND namespace_uri = ? AND local_name = ?`,
+		entryID,
+		patch.Property.Name.Namespace,
+		patch.Property.Name.LocalName,
+	); err != nil {
+		return fmt.Errorf("remove WebDAV property: %w", err)
+	}
+	return nil
+}
+
 func (d *defaultFileManager) ListWebDAVLocks(
 	ctx context.Context,
 	resourcePath string,
@@ -733,7 +854,11 @@ func ensureWebDAVLockTargetTx(
 	if err != nil {
 		return nil, false, fmt.Errorf("stat WebDAV lock target: %w", err)
 	}
+	options := WebDAVMutationOptions{Principal: request.Principal, Groups: request.Groups, Admin: request.Admin}
 	if exists {
+		if err := assertWebDAVPrivilegeTx(ctx, tx, request.Path, WebDAVPrivilegeWriteContent, options); err != nil {
+			return nil, false, err
+		}
 		if err := assertNoConflictingWebDAVLockTx(
 			ctx,
 			tx.QueryExecer(),
@@ -747,6 +872,9 @@ func ensureWebDAVLockTargetTx(
 	if err := ensureWebDAVParentTx(ctx, tx, request.Path); err != nil {
 		return nil, false, err
 	}
+	if err := assertWebDAVParentPrivilegeTx(ctx, tx, request.Path, WebDAVPrivilegeBind, options); err != nil {
+		return nil, false, err
+	}
 	if unpublishedFileID == 0 {
 		return nil, false, errWebDAVLockNullNotPrepared
 	}
@@ -1010,27 +1138,25 @@ func webDAVEntryToLink(
 		}
 	}
 	return &entity.FileLinkMeta{
-		EntryID:  entry.EntryID(),
-		FileName: path.Base(resourcePath),
-		FileId:   fileID,
-		FileSize: entry.Size(),
-		Mode:     entry.Mode(),
-		Ctime:    entry.Ctime(),
-		Mtime:    entry.Mtime(),
-		IsDir:    entry.IsDir(),
+		EntryID:     entry.EntryID(),
+		FileName:    path.Base(resourcePath),
+		FileId:      fileID,
+		FileSize:    entry.Size(),
+		Mode:        entry.Mode(),
+		Ctime:       entry.Ctime(),
+		Mtime:       entry.Mtime(),
+		IsDir:       entry.IsDir(),
+		Owner:       entry.Owner(),
+		ContentType: entry.ContentType(),
 	}, nil
 }
 
 func webDAVS3Metadata(link *entity.FileLinkMeta) *entity.S3ObjectMetadata {
-	extension := ""
-	if index := strings.LastIndexByte(link.FileName, '.'); index >= 0 {
-		extension = link.FileName[index:]
-	}
 	now := time.Now().UnixMilli()
 	return &entity.S3ObjectMetadata{
 		EntryID:      link.EntryID,
 		ETag:         WebDAVETag(link),
-		ContentType:  mimetype.LookupWithDefault(extension, "application/octet-stream"),
+		ContentType:  link.ContentType,
 		CacheControl: defaultS3CacheControl,
 		UserMetadata: "{}",
 		Ctime:        now,
@@ -1569,13 +1695,29 @@ func finalizeRemovedWebDAVEntries(
 	if err := deleteMappingMetadata(ctx, queryExecer, entries); err != nil {
 		return err
 	}
-	if err := deleteWebDAVProperties(ctx, queryExecer, entries); err != nil {
+	if err := deleteWebDAVProtocolState(ctx, queryExecer, entries); err != nil {
+		return err
+	}
+	return markMappingFilesPending(ctx, queryExecer, fileIDs)
+}
+
+// deleteWebDAVProtocolState drops the properties, locks, ACL and binding of
+// Mappings that no longer exist.
+func deleteWebDAVProtocolState(
+	ctx context.Context,
+	exec database.IExecer,
+	entries []directory.IDirectoryEntry,
+) error {
+	if err := deleteWebDAVProperties(ctx, exec, entries); err != nil {
 		return err
 	}
-	if err := deleteWebDAVLocks(ctx, queryExecer, entries); err != nil {
+	if err := deleteWebDAVLocks(ctx, exec, entries); err != nil {
 		return err
 	}
-	return markMappingFilesPending(ctx, queryExecer, fileIDs)
+	if err := deleteWebDAVACL(ctx, exec, entries); err != nil {
+		return err
+	}
+	return deleteWebDAVBindings(ctx, exec, entries)
 }
 
 func deleteWebDAVProperties(
@@ -1652,6 +1794,23 @@ func rebindWebDAVProtocolState(
 	); err != nil {
 		return fmt.Errorf("rebind WebDAV properties: %w", err)
 	}
+	if _, err := exec.ExecContext(
+		ctx,
+		"UPDATE tg_webdav_ace_tab SET entry_id = ? WHERE entry_id = ?",
+		destinationEntryID,
+		sourceEntryID,
+	); err != nil {
+		return fmt.Errorf("rebind WebDAV ACL: %w", err)
+	}
+	// An S3 write gives the key a new, independent resource; the other
+	// bindings keep the previous content.
+	if _, err := exec.ExecContext(
+		ctx,
+		"DELETE FROM tg_webdav_binding_tab WHERE entry_id = ?",
+		sourceEntryID,
+	); err != nil {
+		return fmt.Errorf("unbind overwritten WebDAV resource: %w", err)
+	}
 	if _, err := exec.ExecContext(
 		ctx,
 		`UPDATE tg_webdav_lock_tab
@@ -1680,6 +1839,12 @@ func (e linkDirectoryEntry) Ctime() int64 { return e.link.Ctime }
 func (e linkDirectoryEntry) Mtime() int64 { return e.link.Mtime }
 func (e linkDirectoryEntry) Mode() uint32 { return e.link.Mode }
 func (e linkDirectoryEntry) Size() int64  { return e.link.FileSize }
+func (e linkDirectoryEntry) Owner() string {
+	return e.link.Owner
+}
+func (e linkDirectoryEntry) ContentType() string {
+	return e.link.ContentType
+}
 
 func enforceWebDAVMutationLimitTx(
 	ctx context.Context,
@@ -1790,12 +1955,16 @@ SELECT entry_id FROM tree WHERE full_path = ?`
 	return entryID, nil
 }
 
+// enforceWebDAVQuotaTx projects the quota use after current is replaced by
+// newFileID. released is how many references to current under root the write
+// replaces; the old File stops counting once no other reference remains.
 func enforceWebDAVQuotaTx(
 	ctx context.Context,
 	queryer database.IQueryer,
 	root string,
 	limit int64,
 	current *entity.FileLinkMeta,
+	released int,
 	newFileID uint64,
 	newSize int64,
 ) error {
@@ -1824,7 +1993,7 @@ func enforceWebDAVQuotaTx(
 		if err != nil {
 			return err
 		}
-		if oldReferenceCount == 1 {
+		if oldReferenceCount <= released {
 			projected -= current.FileSize
 		}
 	}
@@ -0,0 +1,56 @@
+package filemgr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// WebDAVScope is the stored form of a WebDAV write that is published after
+// the request that asked for it, by a tus upload, a resumable PUT or a fetch
+// job: the principal it is published as and the mount, empty without
+// mounts, its target was addressed through. The groups, admin override and
+// quota of the write are not kept; IWebDAVScopeResolver looks them up again
+// when the write is published, so a principal that lost them in between
+// does not publish with them.
+type WebDAVScope struct {
+	Principal string `json:"principal"`
+	Mount     string `json:"mount,omitempty"`
+}
+
+// IWebDAVTargetResolver maps a WebDAV target path, as the client addresses
+// it below the WebDAV root, to the namespace path and scope a deferred write
+// by principal uses. It reports ErrWebDAVTarget for a path that is not a
+// file below a share root and ErrWebDAVTargetForbidden when principal may
+// not write the share.
+type IWebDAVTargetResolver interface {
+	ResolveTarget(ctx context.Context, principal, relative string) (string, WebDAVScope, error)
+}
+
+// IWebDAVScopeResolver returns the options a write of scope is published
+// with at target, a namespace path, as of now. It fails like
+// IWebDAVTargetResolver when the principal can no longer write there.
+type IWebDAVScopeResolver interface {
+	ResolveScope(ctx context.Context, scope WebDAVScope, target string) (WebDAVMutationOptions, error)
+}
+
+// Encode returns the JSON form of s that DecodeWebDAVScope reads.
+func (s WebDAVScope) Encode() (string, error) {
+	encoded, err := json.Marshal(s)
+	if err != nil {
+		return "", fmt.Errorf("encode WebDAV scope: %w", err)
+	}
+	return string(encoded), nil
+}
+
+// DecodeWebDAVScope reads a scope written by WebDAVScope.Encode.
+func DecodeWebDAVScope(raw string) (WebDAVScope, error) {
+	var scope WebDAVScope
+	if err := json.Unmarshal([]byte(raw), &scope); err != nil {
+		return WebDAVScope{}, fmt.Errorf("decode WebDAV scope: %w", err)
+	}
+	if scope.Principal == "" {
+		return WebDAVScope{}, fmt.Errorf("%w: scope has no principal", ErrWebDAVTarget)
+	}
+	return scope, nil
+}
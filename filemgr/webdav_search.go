@@ -0,0 +1,365 @@
+package filemgr
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/xxxsen/tgfile/entity"
+)
+
+// WebDAVSearchInfinite is the Depth of a DASL scope that covers the whole
+// subtree.
+const WebDAVSearchInfinite = -1
+
+const (
+	// minWebDAVSearchPage is the fewest candidates read per query, so a
+	// small limit does not turn into many queries when matches are hidden.
+	minWebDAVSearchPage = 100
+	// maxWebDAVSearchScan bounds the candidates one search checks against
+	// the ACL; a search that reaches it reports its result as truncated.
+	maxWebDAVSearchScan = 10_000
+)
+
+// WebDAVSearchField is a live property that basicsearch can test or sort on.
+type WebDAVSearchField int
+
+const (
+	WebDAVSearchDisplayName WebDAVSearchField = iota + 1
+	WebDAVSearchContentLength
+	WebDAVSearchLastModified
+)
+
+// WebDAVSearchOperator names a DASL basicsearch operator.
+type WebDAVSearchOperator string
+
+const (
+	WebDAVSearchAnd          WebDAVSearchOperator = "and"
+	WebDAVSearchOr           WebDAVSearchOperator = "or"
+	WebDAVSearchNot          WebDAVSearchOperator = "not"
+	WebDAVSearchEqual        WebDAVSearchOperator = "eq"
+	WebDAVSearchLess         WebDAVSearchOperator = "lt"
+	WebDAVSearchLessEqual    WebDAVSearchOperator = "lte"
+	WebDAVSearchGreater      WebDAVSearchOperator = "gt"
+	WebDAVSearchGreaterEqual WebDAVSearchOperator = "gte"
+	WebDAVSearchLike         WebDAVSearchOperator = "like"
+	WebDAVSearchCollection   WebDAVSearchOperator = "is-collection"
+	WebDAVSearchDefined      WebDAVSearchOperator = "is-defined"
+)
+
+// WebDAVSearchCondition is one node of a basicsearch where clause. Text holds
+// the displayname literal or like pattern; Number holds the content length
+// or the last modification time in Unix milliseconds.
+type WebDAVSearchCondition struct {
+	Operator WebDAVSearchOperator
+	Field    WebDAVSearchField
+	Text     string
+	Number   int64
+	Operands []*WebDAVSearchCondition
+}
+
+type WebDAVSearchOrder struct {
+	Field      WebDAVSearchField
+	Descending bool
+}
+
+// WebDAVSearchQuery is a basicsearch evaluated below Scope. A nil Where
+// matches every resource in the scope. Principal and Groups keep only the
+// resources the principal holds DAV:read on; an empty Principal, as for
+// administrators, skips the ACL.
+type WebDAVSearchQuery struct {
+	Scope     string
+	Depth     int
+	Where     *WebDAVSearchCondition
+	OrderBy   []WebDAVSearchOrder
+	Limit     int
+	Principal string
+	Groups    []string
+}
+
+type WebDAVSearchMatch struct {
+	Path string
+	Item *entity.FileLinkMeta
+}
+
+// WebDAVSearchResult holds at most Limit readable matches in order.
+// Truncated reports that more exist, or that the search stopped after
+// maxWebDAVSearchScan candidates without knowing.
+type WebDAVSearchResult struct {
+	Matches   []WebDAVSearchMatch
+	Truncated bool
+}
+
+// SearchWebDAV filters tg_file_mapping_tab with the compiled where clause
+// first, so the size, mtime and name indexes select the candidates, and then
+// walks each candidate's ancestors to keep only those inside the scope. The
+// candidates are read in result order a page at a time and checked against
+// the ACL before the next page, so hidden resources neither shorten the
+// result nor cost a query each.
+func (d *defaultFileManager) SearchWebDAV(
+	ctx context.Context,
+	query *WebDAVSearchQuery,
+) (*WebDAVSearchResult, error) {
+	scope, err := d.StatFileLink(ctx, query.Scope)
+	if err != nil {
+		return nil, err
+	}
+	statement, args, err := compileWebDAVSearchQuery(query, scope.EntryID)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := max(query.Limit+1, minWebDAVSearchPage)
+	result := &WebDAVSearchResult{}
+	for offset := 0; ; offset += pageSize {
+		if offset >= maxWebDAVSearchScan {
+			result.Truncated = true
+			return result, nil
+		}
+		page, err := d.scanWebDAVSearchMatches(ctx, query.Scope, statement,
+			append(slices.Clip(args), pageSize, offset))
+		if err != nil {
+			return nil, err
+		}
+		readable, err := d.readableWebDAVSearchMatches(ctx, query, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range readable {
+			if len(result.Matches) == query.Limit {
+				result.Truncated = true
+				return result, nil
+			}
+			result.Matches = append(result.Matches, match)
+		}
+		if len(page) < pageSize {
+			return result, nil
+		}
+	}
+}
+
+// compileWebDAVSearchQuery returns the statement for one page of candidates;
+// the caller appends the page size and offset to args.
+func compileWebDAVSearchQuery(query *WebDAVSearchQuery, scopeID uint64) (string, []any, error) {
+	where, args, err := compileWebDAVSearchCondition(query.Where)
+	if err != nil {
+		return "", nil, err
+	}
+	scopeFilter := "1 = 1"
+	maxDepth := int64(query.Depth)
+	switch query.Depth {
+	case 0:
+		scopeFilter = "entry_id = ?"
+		args = append(args, scopeID)
+	case 1:
+		scopeFilter = "(entry_id = ? OR parent_entry_id = ?)"
+		args = append(args, scopeID, scopeID)
+	default:
+		maxDepth = -1
+	}
+	statement := `WITH RECURSIVE candidate AS (
+SELECT entry_id, parent_entry_id, ref_data, file_kind, ctime, mtime, file_size, file_mode, file_name
+FROM tg_file_mapping_tab
+WHERE (` + where + `) AND ` + scopeFilter + `
+),
+ancestry (entry_id, cursor, relative, hops) AS (
+SELECT entry_id, entry_id, '', 0 FROM candidate
+UNION ALL
+SELECT ancestry.entry_id, parent.parent_entry_id, '/' || parent.file_name || ancestry.relative, ancestry.hops + 1
+FROM ancestry JOIN tg_file_mapping_tab parent ON parent.entry_id = ancestry.cursor
+WHERE ancestry.cursor != ? AND (? < 0 OR ancestry.hops < ?)
+)
+SELECT candidate.entry_id, candidate.ref_data, candidate.file_kind, candidate.ctime, candidate.mtime,
+candidate.file_size, candidate.file_mode, candidate.file_name, ancestry.relative
+FROM ancestry JOIN candidate ON candidate.entry_id = ancestry.entry_id
+WHERE ancestry.cursor = ?
+ORDER BY ` + webDAVSearchOrderClause(query.OrderBy) + `
+LIMIT ? OFFSET ?`
+	return statement, append(args, scopeID, maxDepth, maxDepth, scopeID), nil
+}
+
+// readableWebDAVSearchMatches keeps the matches the query's principal holds
+// DAV:read on. The ACEs of every match and its ancestors are read in one
+// query and evaluated like WebDAVPrivileges would.
+func (d *defaultFileManager) readableWebDAVSearchMatches(
+	ctx context.Context,
+	query *WebDAVSearchQuery,
+	matches []WebDAVSearchMatch,
+) ([]WebDAVSearchMatch, error) {
+	if query.Principal == "" || len(matches) == 0 {
+		return matches, nil
+	}
+	ids := make([]uint64, 0, len(matches))
+	for _, match := range matches {
+		ids = append(ids, match.Item.EntryID)
+	}
+	chains, err := queryWebDAVACLChains(ctx, d.dbc, ids)
+	if err != nil {
+		return nil, err
+	}
+	readable := make([]WebDAVSearchMatch, 0, len(matches))
+	for _, match := range matches {
+		// DAV:read does not depend on the owner, which only keeps
+		// DAV:write-acl by default.
+		aces := chains.aces(match.Item.EntryID)
+		if evaluateWebDAVACL(aces, query.Principal, query.Groups, "")&WebDAVPrivilegeRead != 0 {
+			readable = append(readable, match)
+		}
+	}
+	return readable, nil
+}
+
+func (d *defaultFileManager) scanWebDAVSearchMatches(
+	ctx context.Context,
+	scope, statement string,
+	args []any,
+) ([]WebDAVSearchMatch, error) {
+	rows, err := d.dbc.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query WebDAV search: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var matches []WebDAVSearchMatch
+	for rows.Next() {
+		var (
+			item     entity.FileLinkMeta
+			refData  string
+			fileKind int
+			relative string
+		)
+		if err := rows.Scan(&item.EntryID, &refData, &fileKind, &item.Ctime, &item.Mtime,
+			&item.FileSize, &item.Mode, &item.FileName, &relative); err != nil {
+			return nil, fmt.Errorf("scan WebDAV search match: %w", err)
+		}
+		item.IsDir = fileKind != 2
+		if !item.IsDir {
+			if item.FileId, err = strconv.ParseUint(refData, 10, 64); err != nil {
+				return nil, fmt.Errorf("parse WebDAV search file id: %w", err)
+			}
+		}
+		matches = append(matches, WebDAVSearchMatch{Path: path.Join(scope, relative), Item: &item})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate WebDAV search matches: %w", err)
+	}
+	return matches, nil
+}
+
+func compileWebDAVSearchCondition(condition *WebDAVSearchCondition) (string, []any, error) {
+	if condition == nil {
+		return "1 = 1", nil, nil
+	}
+	switch condition.Operator {
+	case WebDAVSearchAnd, WebDAVSearchOr:
+		return compileWebDAVSearchJunction(condition)
+	case WebDAVSearchNot:
+		if len(condition.Operands) != 1 {
+			return "", nil, fmt.Errorf("%w: not takes one operand", ErrWebDAVSearchQuery)
+		}
+		clause, args, err := compileWebDAVSearchCondition(condition.Operands[0])
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT (" + clause + ")", args, nil
+	case WebDAVSearchCollection:
+		return "file_kind != 2", nil, nil
+	case WebDAVSearchDefined:
+		if condition.Field == WebDAVSearchContentLength {
+			return "file_kind = 2", nil, nil
+		}
+		return "1 = 1", nil, nil
+	case WebDAVSearchLike:
+		if condition.Field != WebDAVSearchDisplayName {
+			return "", nil, fmt.Errorf("%w: like only applies to displayname", ErrWebDAVSearchQuery)
+		}
+		return `file_name LIKE ? ESCAPE '\'`, []any{condition.Text}, nil
+	}
+	return compileWebDAVSearchComparison(condition)
+}
+
+func compileWebDAVSearchJunction(condition *WebDAVSearchCondition) (string, []any, error) {
+	if len(condition.Operands) == 0 {
+		return "", nil, fmt.Errorf("%w: %s needs operands", ErrWebDAVSearchQuery, condition.Operator)
+	}
+	clauses := make([]string, 0, len(condition.Operands))
+	var args []any
+	for _, operand := range condition.Operands {
+		clause, operandArgs, err := compileWebDAVSearchCondition(operand)
+		if err != nil {
+			return "", nil, err
+		}
+		clauses = append(clauses, "("+clause+")")
+		args = append(args, operandArgs...)
+	}
+	return strings.Join(clauses, " "+strings.ToUpper(string(condition.Operator))+" "), args, nil
+}
+
+func compileWebDAVSearchComparison(condition *WebDAVSearchCondition) (string, []any, error) {
+	operators := map[WebDAVSearchOperator]string{
+		WebDAVSearchEqual:        "=",
+		WebDAVSearchLess:         "<",
+		WebDAVSearchLessEqual:    "<=",
+		WebDAVSearchGreater:      ">",
+		WebDAVSearchGreaterEqual: ">=",
+	}
+	operator, ok := operators[condition.Operator]
+	if !ok {
+		return "", nil, fmt.Errorf("%w: unsupported operator %q", ErrWebDAVSearchQuery, condition.Operator)
+	}
+	switch condition.Field {
+	case WebDAVSearchDisplayName:
+		if operator != "=" {
+			return "", nil, fmt.Errorf("%w: displayname only supports eq and like", ErrWebDAVSearchQuery)
+		}
+		return "file_name = ? COLLATE NOCASE", []any{condition.Text}, nil
+	case WebDAVSearchContentLength:
+		return "file_kind = 2 AND file_size " + operator + " ?", []any{condition.Number}, nil
+	case WebDAVSearchLastModified:
+		return compileWebDAVSearchModified(condition)
+	}
+	return "", nil, fmt.Errorf("%w: unsupported property", ErrWebDAVSearchQuery)
+}
+
+// compileWebDAVSearchModified compares getlastmodified at the one second
+// resolution it is reported with, as millisecond bounds that the mtime index
+// can serve.
+func compileWebDAVSearchModified(condition *WebDAVSearchCondition) (string, []any, error) {
+	start := condition.Number / 1000 * 1000
+	end := start + 1000
+	switch condition.Operator {
+	case WebDAVSearchEqual:
+		return "mtime >= ? AND mtime < ?", []any{start, end}, nil
+	case WebDAVSearchLess:
+		return "mtime < ?", []any{start}, nil
+	case WebDAVSearchGreaterEqual:
+		return "mtime >= ?", []any{start}, nil
+	case WebDAVSearchLessEqual:
+		return "mtime < ?", []any{end}, nil
+	default:
+		return "mtime >= ?", []any{end}, nil
+	}
+}
+
+func webDAVSearchOrderClause(orders []WebDAVSearchOrder) string {
+	columns := map[WebDAVSearchField]string{
+		WebDAVSearchDisplayName:   "candidate.file_name COLLATE NOCASE",
+		WebDAVSearchContentLength: "CASE WHEN candidate.file_kind = 2 THEN candidate.file_size END",
+		WebDAVSearchLastModified:  "candidate.mtime",
+	}
+	clauses := make([]string, 0, len(orders)+1)
+	for _, order := range orders {
+		column, ok := columns[order.Field]
+		if !ok {
+			continue
+		}
+		if order.Descending {
+			column += " DESC"
+		}
+		clauses = append(clauses, column)
+	}
+	return strings.Join(append(clauses, "ancestry.relative"), ", ")
+}
@@ -0,0 +1,114 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrInvalidConfiguration = errors.New("invalid inventory configuration")
+
+type Format string
+
+const (
+	FormatCSV Format = "CSV"
+	// FormatNDJSON writes one JSON object per object, with the same fields
+	// as the CSV columns.
+	FormatNDJSON Format = "NDJSON"
+)
+
+type Frequency string
+
+const (
+	FrequencyDaily  Frequency = "Daily"
+	FrequencyWeekly Frequency = "Weekly"
+)
+
+// Report columns after Bucket and Key. Checksum is not an S3 field; it holds
+// the value of the checksum named by ChecksumAlgorithm.
+const (
+	FieldSize                = "Size"
+	FieldLastModifiedDate    = "LastModifiedDate"
+	FieldETag                = "ETag"
+	FieldStorageClass        = "StorageClass"
+	FieldIsMultipartUploaded = "IsMultipartUploaded"
+	FieldChecksumAlgorithm   = "ChecksumAlgorithm"
+	FieldChecksum            = "Checksum"
+)
+
+var supportedFields = []string{
+	FieldSize,
+	FieldLastModifiedDate,
+	FieldETag,
+	FieldStorageClass,
+	FieldIsMultipartUploaded,
+	FieldChecksumAlgorithm,
+	FieldChecksum,
+}
+
+var configurationIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+const maxPrefixBytes = 512
+
+// Configuration is a scheduled inventory report of Bucket written into
+// DestinationBucket under DestinationPrefix.
+type Configuration struct {
+	ID                string
+	Bucket            string
+	Enabled           bool
+	Prefix            string
+	DestinationBucket string
+	DestinationPrefix string
+	Format            Format
+	Frequency         Frequency
+	OptionalFields    []string
+	LastRunAt         int64
+	LastError         string
+}
+
+// SupportedFields lists the optional report columns in report order.
+func SupportedFields() []string {
+	return append([]string(nil), supportedFields...)
+}
+
+func (c *Configuration) period() time.Duration {
+	if c.Frequency == FrequencyWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+func (m *Manager) validate(config *Configuration) error {
+	if !configurationIDPattern.MatchString(config.ID) {
+		return fmt.Errorf("%w: id must contain 1 to 64 letters, digits, '.', '_' or '-'", ErrInvalidConfiguration)
+	}
+	if !slices.Contains(m.options.Buckets, config.DestinationBucket) {
+		return fmt.Errorf("%w: destination bucket %q is not configured", ErrInvalidConfiguration, config.DestinationBucket)
+	}
+	for _, prefix := range []string{config.Prefix, config.DestinationPrefix} {
+		if len(prefix) > maxPrefixBytes || strings.HasPrefix(prefix, "/") || strings.Contains(prefix, "//") ||
+			slices.Contains(strings.Split(prefix, "/"), "..") {
+			return fmt.Errorf("%w: prefix %q is invalid", ErrInvalidConfiguration, prefix)
+		}
+	}
+	if config.Format != FormatCSV && config.Format != FormatNDJSON {
+		return fmt.Errorf("%w: format must be CSV or NDJSON", ErrInvalidConfiguration)
+	}
+	if config.Frequency != FrequencyDaily && config.Frequency != FrequencyWeekly {
+		return fmt.Errorf("%w: frequency must be Daily or Weekly", ErrInvalidConfiguration)
+	}
+	seen := make(map[string]struct{}, len(config.OptionalFields))
+	for _, field := range config.OptionalFields {
+		if !slices.Contains(supportedFields, field) {
+			return fmt.Errorf("%w: optional field %q is not supported", ErrInvalidConfiguration, field)
+		}
+		if _, exists := seen[field]; exists {
+			return fmt.Errorf("%w: optional field %q is repeated", ErrInvalidConfiguration, field)
+		}
+		seen[field] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xxxsen/common/database"
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+const (
+	defaultPollInterval = time.Minute
+	defaultRetryDelay   = time.Hour
+	dueBatchSize        = 16
+	maxLastErrorBytes   = 1024
+)
+
+type Options struct {
+	// Buckets are the configured S3 buckets that may receive reports.
+	Buckets      []string
+	WorkDir      string
+	PollInterval time.Duration
+	RetryDelay   time.Duration
+}
+
+type Manager struct {
+	db      database.IDatabase
+	files   filemgr.IFileManager
+	options Options
+	now     func() time.Time
+}
+
+func New(db database.IDatabase, files filemgr.IFileManager, options Options) *Manager {
+	if options.WorkDir == "" {
+		options.WorkDir = os.TempDir()
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = defaultPollInterval
+	}
+	if options.RetryDelay <= 0 {
+		options.RetryDelay = defaultRetryDelay
+	}
+	return &Manager{db: db, files: files, options: options, now: time.Now}
+}
+
+// Put creates or replaces a configuration. A new configuration is due at
+// once; replacing one keeps its schedule.
+func (m *Manager) Put(ctx context.Context, config *Configuration) error {
+	if err := m.validate(config); err != nil {
+		return err
+	}
+	now := m.now().UnixMilli()
+	if _, err := m.db.ExecContext(
+		ctx,
+		`INSERT INTO tg_s3_inventory_tab (
+bucket, config_id, enabled, prefix, destination_bucket, destination_prefix, format, frequency,
+optional_fields, next_run_at, created_at, updated_at
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(bucket, config_id) DO UPDATE SET
+enabled = excluded.enabled, prefix = excluded.prefix,
+destination_bucket = excluded.destination_bucket, destination_prefix = excluded.destination_prefix,
+format = excluded.format, frequency = excluded.frequency, optional_fields = excluded.optional_fields,
+updated_at = excluded.updated_at`,
+		config.Bucket,
+		config.ID,
+		config.Enabled,
+		config.Prefix,
+		config.DestinationBucket,
+		config.DestinationPrefix,
+		string(config.Format),
+		string(config.Frequency),
+		strings.Join(config.OptionalFields, ","),
+		now,
+		now,
+		now,
+	); err != nil {
+		return fmt.Errorf("save inventory configuration: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) Get(ctx context.Context, bucket, id string) (*Configuration, bool, error) {
+	configs, err := m.query(ctx, `WHERE bucket = ? AND config_id = ?`, bucket, id)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(configs) == 0 {
+		return nil, false, nil
+	}
+	return configs[0], true, nil
+}
+
+func (m *Manager) Delete(ctx context.Context, bucket, id string) (bool, error) {
+	result, err := m.db.ExecContext(
+		ctx,
+		`DELETE FROM tg_s3_inventory_tab WHERE bucket = ? AND config_id = ?`,
+		bucket,
+		id,
+	)
+	if err != nil {
+		return false, fmt.Errorf("delete inventory configuration: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("read inventory delete result: %w", err)
+	}
+	return affected != 0, nil
+}
+
+// List returns up to limit configurations of bucket whose id sorts after
+// after, in id order.
+func (m *Manager) List(ctx context.Context, bucket, after string, limit int) ([]*Configuration, error) {
+	return m.query(ctx, `WHERE bucket = ? AND config_id > ? ORDER BY config_id LIMIT ?`, bucket, after, limit)
+}
+
+func (m *Manager) query(ctx context.Context, where string, args ...any) ([]*Configuration, error) {
+	rows, err := m.db.QueryContext(
+		ctx,
+		`SELECT bucket, config_id, enabled, prefix, destination_bucket, destination_prefix, format, frequency,
+optional_fields, last_run_at, last_error
+FROM tg_s3_inventory_tab `+where,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query inventory configurations: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	configs := make([]*Configuration, 0)
+	for rows.Next() {
+		config, err := scanConfiguration(rows)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read inventory configurations: %w", err)
+	}
+	return configs, nil
+}
+
+func scanConfiguration(rows *sql.Rows) (*Configuration, error) {
+	var (
+		config Configuration
+		fields string
+		format string
+		period string
+	)
+	if err := rows.Scan(
+		&config.Bucket,
+		&config.ID,
+		&config.Enabled,
+		&config.Prefix,
+		&config.DestinationBucket,
+		&config.DestinationPrefix,
+		&format,
+		&period,
+		&fields,
+		&config.LastRunAt,
+		&config.LastError,
+	); err != nil {
+		return nil, fmt.Errorf("scan inventory configuration: %w", err)
+	}
+	config.Format = Format(format)
+	config.Frequency = Frequency(period)
+	if fields != "" {
+		config.OptionalFields = strings.Split(fields, ",")
+	}
+	return &config, nil
+}
+
+func (m *Manager) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.options.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := m.runOnce(ctx); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("run inventory worker: %w", ctx.Err())
+			}
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("run inventory worker: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Manager) runOnce(ctx context.Context) error {
+	due, err := m.query(
+		ctx,
+		`WHERE enabled = 1 AND next_run_at <= ? ORDER BY next_run_at, bucket, config_id LIMIT ?`,
+		m.now().UnixMilli(),
+		dueBatchSize,
+	)
+	if err != nil {
+		return err
+	}
+	for _, config := range due {
+		started := m.now()
+		reportErr := m.generate(ctx, config, started)
+		if ctx.Err() != nil {
+			return fmt.Errorf("generate inventory report: %w", ctx.Err())
+		}
+		if reportErr != nil {
+			logutil.GetLogger(ctx).Warn(
+				"generate S3 inventory report failed",
+				zap.String("bucket", config.Bucket),
+				zap.String("id", config.ID),
+				zap.Error(reportErr),
+			)
+		}
+		if err := m.finish(ctx, config, started, reportErr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Manager) finish(ctx context.Context, config *Configuration, started time.Time, reportErr error) error {
+	next := started.Add(config.period())
+	lastRun := started.UnixMilli()
+	lastError := ""
+	if reportErr != nil {
+		next = started.Add(m.options.RetryDelay)
+		lastRun = config.LastRunAt
+		lastError = reportErr.Error()
+		if len(lastError) > maxLastErrorBytes {
+			lastError = lastError[:maxLastErrorBytes]
+		}
+	}
+	if _, err := m.db.ExecContext(
+		ctx,
+		`UPDATE tg_s3_inventory_tab SET next_run_at = ?, last_run_at = ?, last_error = ?
+WHERE bucket = ? AND config_id = ?`,
+		next.UnixMilli(),
+		lastRun,
+		lastError,
+		config.Bucket,
+		config.ID,
+	); err != nil {
+		return fmt.Errorf("schedule inventory configuration: %w", err)
+	}
+	return nil
+}
+
+// reportFields returns the selected optional fields in report order.
+func reportFields(config *Configuration) []string {
+	fields := make([]string, 0, len(config.OptionalFields))
+	for _, field := range supportedFields {
+		if slices.Contains(config.OptionalFields, field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
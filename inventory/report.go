@@ -0,0 +1,324 @@
+package inventory
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5" //nolint:gosec // S3 manifests identify files by MD5.
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+const (
+	manifestVersion    = "2016-11-30"
+	maxRowsPerFile     = 1000000
+	reportCacheControl = "no-cache"
+	s3TimeLayout       = "2006-01-02T15:04:05.000Z"
+	manifestDirectory  = "2006-01-02T15-04Z"
+)
+
+var errReportPublish = errors.New("publish inventory report object")
+
+type manifestFile struct {
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	MD5Checksum string `json:"MD5checksum"`
+}
+
+type manifest struct {
+	SourceBucket      string         `json:"sourceBucket"`
+	DestinationBucket string         `json:"destinationBucket"`
+	Version           string         `json:"version"`
+	CreationTimestamp string         `json:"creationTimestamp"`
+	FileFormat        string         `json:"fileFormat"`
+	FileSchema        string         `json:"fileSchema"`
+	Files             []manifestFile `json:"files"`
+}
+
+// reportWriter streams rows into gzip compressed data files in the work
+// directory and publishes each one once it holds maxRowsPerFile rows.
+type reportWriter struct {
+	m      *Manager
+	config *Configuration
+	fields []string
+	base   string
+
+	file   *os.File
+	size   int64
+	md5    hash.Hash
+	sha256 hash.Hash
+	gzip   *gzip.Writer
+	csv    *csv.Writer
+	json   *json.Encoder
+	rows   int
+	output []manifestFile
+}
+
+func (m *Manager) generate(ctx context.Context, config *Configuration, started time.Time) error {
+	writer := &reportWriter{
+		m:      m,
+		config: config,
+		fields: reportFields(config),
+		base:   reportBase(config),
+	}
+	defer writer.abort()
+	err := m.files.IterateS3Objects(
+		ctx,
+		config.Bucket,
+		config.Prefix,
+		func(ctx context.Context, items []filemgr.S3InventoryItem) error {
+			for _, item := range items {
+				if err := writer.write(ctx, item); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("list inventory objects: %w", err)
+	}
+	if err := writer.flush(ctx); err != nil {
+		return err
+	}
+	return writer.publishManifest(ctx, started)
+}
+
+func reportBase(config *Configuration) string {
+	parts := make([]string, 0, 3)
+	if prefix := strings.Trim(config.DestinationPrefix, "/"); prefix != "" {
+		parts = append(parts, prefix)
+	}
+	return strings.Join(append(parts, config.Bucket, config.ID), "/")
+}
+
+func (w *reportWriter) write(ctx context.Context, item filemgr.S3InventoryItem) error {
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	if err := w.encode(item); err != nil {
+		return err
+	}
+	w.rows++
+	if w.rows >= maxRowsPerFile {
+		return w.flush(ctx)
+	}
+	return nil
+}
+
+func (w *reportWriter) open() error {
+	file, err := os.CreateTemp(w.m.options.WorkDir, "tgfile-inventory-*.gz")
+	if err != nil {
+		return fmt.Errorf("create inventory data file: %w", err)
+	}
+	w.file = file
+	w.size = 0
+	w.md5 = md5.New() //nolint:gosec // S3 manifests identify files by MD5.
+	w.sha256 = sha256.New()
+	w.gzip = gzip.NewWriter(io.MultiWriter(file, w.md5, w.sha256, (*sizeCounter)(&w.size)))
+	w.rows = 0
+	if w.config.Format == FormatCSV {
+		w.csv = csv.NewWriter(w.gzip)
+		return nil
+	}
+	w.json = json.NewEncoder(w.gzip)
+	return nil
+}
+
+func (w *reportWriter) encode(item filemgr.S3InventoryItem) error {
+	if w.csv != nil {
+		row := append([]string{w.config.Bucket, url.QueryEscape(item.Key)}, w.values(item)...)
+		if err := w.csv.Write(row); err != nil {
+			return fmt.Errorf("write inventory row: %w", err)
+		}
+		return nil
+	}
+	record := map[string]any{"Bucket": w.config.Bucket, "Key": item.Key}
+	values := w.values(item)
+	for index, field := range w.fields {
+		switch field {
+		case FieldSize:
+			record[field] = item.Size
+		case FieldIsMultipartUploaded:
+			record[field] = values[index] == "true"
+		default:
+			record[field] = values[index]
+		}
+	}
+	if err := w.json.Encode(record); err != nil {
+		return fmt.Errorf("write inventory row: %w", err)
+	}
+	return nil
+}
+
+func (w *reportWriter) values(item filemgr.S3InventoryItem) []string {
+	values := make([]string, 0, len(w.fields))
+	etag := strings.Trim(item.ETag, `"`)
+	for _, field := range w.fields {
+		switch field {
+		case FieldSize:
+			values = append(values, strconv.FormatInt(item.Size, 10))
+		case FieldLastModifiedDate:
+			values = append(values, time.UnixMilli(item.LastModified).UTC().Format(s3TimeLayout))
+		case FieldETag:
+			values = append(values, etag)
+		case FieldStorageClass:
+			values = append(values, storageClass(item.StorageClass))
+		case FieldIsMultipartUploaded:
+			values = append(values, strconv.FormatBool(strings.Contains(etag, "-")))
+		case FieldChecksumAlgorithm:
+			values = append(values, item.ChecksumAlgorithm)
+		case FieldChecksum:
+			values = append(values, item.Checksum)
+		}
+	}
+	return values
+}
+
+func storageClass(class string) string {
+	if class == "" {
+		return "STANDARD"
+	}
+	return class
+}
+
+// flush closes the current data file, if any, and publishes it.
+func (w *reportWriter) flush(ctx context.Context) error {
+	if w.file == nil {
+		return nil
+	}
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			return fmt.Errorf("flush inventory rows: %w", err)
+		}
+	}
+	if err := w.gzip.Close(); err != nil {
+		return fmt.Errorf("close inventory data file: %w", err)
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewind inventory data file: %w", err)
+	}
+	extension := ".csv.gz"
+	if w.config.Format == FormatNDJSON {
+		extension = ".ndjson.gz"
+	}
+	key := w.base + "/data/" + uuid.NewString() + extension
+	hashes := &objectHashes{md5: w.md5.Sum(nil), sha256: w.sha256.Sum(nil)}
+	if err := w.m.publish(ctx, w.config.DestinationBucket, key, w.file, w.size, hashes, "application/gzip"); err != nil {
+		return err
+	}
+	w.output = append(w.output, manifestFile{Key: key, Size: w.size, MD5Checksum: hex.EncodeToString(hashes.md5)})
+	w.abort()
+	return nil
+}
+
+// abort drops the current data file without publishing it.
+func (w *reportWriter) abort() {
+	if w.file == nil {
+		return
+	}
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+	w.file, w.gzip, w.csv, w.json = nil, nil, nil, nil
+}
+
+func (w *reportWriter) publishManifest(ctx context.Context, started time.Time) error {
+	schema := append([]string{"Bucket", "Key"}, w.fields...)
+	files := w.output
+	if files == nil {
+		files = []manifestFile{}
+	}
+	raw, err := json.Marshal(&manifest{
+		SourceBucket:      w.config.Bucket,
+		DestinationBucket: "arn:aws:s3:::" + w.config.DestinationBucket,
+		Version:           manifestVersion,
+		CreationTimestamp: strconv.FormatInt(started.UnixMilli(), 10),
+		FileFormat:        string(w.config.Format),
+		FileSchema:        strings.Join(schema, ", "),
+		Files:             files,
+	})
+	if err != nil {
+		return fmt.Errorf("encode inventory manifest: %w", err)
+	}
+	directory := w.base + "/" + started.UTC().Format(manifestDirectory) + "/"
+	err = w.m.publishBytes(ctx, w.config.DestinationBucket, directory+"manifest.json", raw, "application/json")
+	if err != nil {
+		return err
+	}
+	// The checksum object is written last so its presence marks a complete report.
+	sum := md5.Sum(raw) //nolint:gosec // S3 manifests identify files by MD5.
+	return w.m.publishBytes(
+		ctx,
+		w.config.DestinationBucket,
+		directory+"manifest.checksum",
+		[]byte(hex.EncodeToString(sum[:])),
+		"text/plain",
+	)
+}
+
+type objectHashes struct {
+	md5    []byte
+	sha256 []byte
+}
+
+func (m *Manager) publishBytes(ctx context.Context, bucket, key string, data []byte, contentType string) error {
+	md5Sum := md5.Sum(data) //nolint:gosec // S3 ETags of single part objects are MD5.
+	sha256Sum := sha256.Sum256(data)
+	return m.publish(ctx, bucket, key, bytes.NewReader(data), int64(len(data)), &objectHashes{
+		md5:    md5Sum[:],
+		sha256: sha256Sum[:],
+	}, contentType)
+}
+
+func (m *Manager) publish(
+	ctx context.Context,
+	bucket, key string,
+	reader io.Reader,
+	size int64,
+	hashes *objectHashes,
+	contentType string,
+) error {
+	fileID, err := m.files.CreateFile(ctx, size, reader)
+	if err != nil {
+		return fmt.Errorf("store inventory object %q: %w", key, err)
+	}
+	if _, err := m.files.PublishS3Object(ctx, path.Join("/"+bucket, key), fileID, size, &entity.S3ObjectMetadata{
+		ETag:           `"` + hex.EncodeToString(hashes.md5) + `"`,
+		ChecksumSHA256: base64.StdEncoding.EncodeToString(hashes.sha256),
+		ContentType:    contentType,
+		CacheControl:   reportCacheControl,
+		UserMetadata:   "{}",
+	}, nil); err != nil {
+		_ = m.files.DiscardUnpublishedFile(ctx, fileID)
+		return fmt.Errorf("%w %q: %w", errReportPublish, key, err)
+	}
+	return nil
+}
+
+type sizeCounter int64
+
+func (s *sizeCounter) Write(p []byte) (int, error) {
+	*s += sizeCounter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,173 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+var ErrInvalidRule = errors.New("invalid lifecycle rule")
+
+const (
+	defaultScanInterval = time.Hour
+	listPageSize        = 1000
+	day                 = 24 * time.Hour
+)
+
+// Rule moves objects of Bucket whose key starts with Prefix into StorageClass
+// once they have not been modified for Days days.
+type Rule struct {
+	ID           string
+	Bucket       string
+	Prefix       string
+	Days         int
+	StorageClass string
+}
+
+type Options struct {
+	Rules        []Rule
+	ScanInterval time.Duration
+}
+
+type Manager struct {
+	files   filemgr.IFileManager
+	options Options
+	buckets map[string][]Rule
+	now     func() time.Time
+}
+
+func New(files filemgr.IFileManager, options Options) (*Manager, error) {
+	if options.ScanInterval <= 0 {
+		options.ScanInterval = defaultScanInterval
+	}
+	manager := &Manager{
+		files:   files,
+		options: options,
+		buckets: make(map[string][]Rule),
+		now:     time.Now,
+	}
+	seen := make(map[string]struct{}, len(options.Rules))
+	for _, rule := range options.Rules {
+		if rule.ID == "" || rule.Bucket == "" || rule.Days < 0 {
+			return nil, fmt.Errorf("%w: id and bucket are required and days must not be negative", ErrInvalidRule)
+		}
+		if !files.StorageClassExists(rule.StorageClass) {
+			return nil, fmt.Errorf("%w: unknown storage class %q", ErrInvalidRule, rule.StorageClass)
+		}
+		key := rule.Bucket + "/" + rule.ID
+		if _, exists := seen[key]; exists {
+			return nil, fmt.Errorf("%w: duplicate rule %q for bucket %q", ErrInvalidRule, rule.ID, rule.Bucket)
+		}
+		seen[key] = struct{}{}
+		manager.buckets[rule.Bucket] = append(manager.buckets[rule.Bucket], rule)
+	}
+	return manager, nil
+}
+
+// Rules returns the lifecycle rules of a bucket in configuration order.
+func (m *Manager) Rules(bucket string) []Rule {
+	return append([]Rule(nil), m.buckets[bucket]...)
+}
+
+func (m *Manager) Run(ctx context.Context) error {
+	if len(m.buckets) == 0 {
+		<-ctx.Done()
+		return fmt.Errorf("run lifecycle worker: %w", ctx.Err())
+	}
+	ticker := time.NewTicker(m.options.ScanInterval)
+	defer ticker.Stop()
+	for {
+		m.runOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("run lifecycle worker: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Manager) runOnce(ctx context.Context) {
+	for _, rules := range m.buckets {
+		for _, rule := range rules {
+			if err := m.applyRule(ctx, rule); err != nil && ctx.Err() == nil {
+				logutil.GetLogger(ctx).Warn(
+					"apply S3 lifecycle rule failed",
+					zap.String("bucket", rule.Bucket),
+					zap.String("rule", rule.ID),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+func (m *Manager) applyRule(ctx context.Context, rule Rule) error {
+	request := &filemgr.S3ListRequest{
+		Bucket:  rule.Bucket,
+		Prefix:  rule.Prefix,
+		MaxKeys: listPageSize,
+	}
+	for {
+		page, err := m.files.ListS3Objects(ctx, request)
+		if err != nil {
+			return fmt.Errorf("list lifecycle candidates: %w", err)
+		}
+		for _, item := range page.Items {
+			age := m.now().Sub(time.UnixMilli(item.LastModified))
+			if m.targetClass(rule.Bucket, item.Key, age) != rule.StorageClass ||
+				item.StorageClass == rule.StorageClass {
+				continue
+			}
+			if err := m.transition(ctx, rule, item.Key); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated {
+			return nil
+		}
+		request.ContinuationToken = page.NextKey
+	}
+}
+
+// targetClass picks, among the rules covering key, the one with the most days
+// that have already elapsed, so staged rules such as 30 days to one class and
+// 90 days to another move an object step by step.
+func (m *Manager) targetClass(bucket, key string, age time.Duration) string {
+	best := -1
+	class := ""
+	for _, rule := range m.buckets[bucket] {
+		if !strings.HasPrefix(key, rule.Prefix) || age < time.Duration(rule.Days)*day || rule.Days <= best {
+			continue
+		}
+		best = rule.Days
+		class = rule.StorageClass
+	}
+	return class
+}
+
+func (m *Manager) transition(ctx context.Context, rule Rule, key string) error {
+	_, err := m.files.TransitionS3Object(ctx, "/"+rule.Bucket+"/"+key, rule.StorageClass)
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("transition object: %w", ctx.Err())
+	}
+	logutil.GetLogger(ctx).Warn(
+		"transition S3 object failed",
+		zap.String("bucket", rule.Bucket),
+		zap.String("rule", rule.ID),
+		zap.String("key", key),
+		zap.String("storage_class", rule.StorageClass),
+		zap.Error(err),
+	)
+	return nil
+}
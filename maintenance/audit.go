@@ -98,8 +98,11 @@ type AuditReport struct {
 }
 
 type AuditOptions struct {
-	S3Buckets      []AuditBucket
-	BackendKind    string
+	S3Buckets   []AuditBucket
+	BackendKind string
+	// TierKinds lists the backends of additional storage classes, whose
+	// pending deletions are not reported as mismatches.
+	TierKinds      []string
 	BackupWorkDir  string
 	TelegramBotID  int64
 	TelegramChatID int64
@@ -355,6 +358,9 @@ AND NOT EXISTS (
     SELECT 1 FROM tg_s3_file_segment_tab segment
     WHERE segment.file_id = file.file_id OR segment.source_file_id = file.file_id
 )
+AND NOT EXISTS (SELECT 1 FROM tg_snapshot_entry_tab snapshot_entry WHERE snapshot_entry.file_id = file.file_id)
+AND NOT EXISTS (SELECT 1 FROM tg_file_version_tab version WHERE version.file_id = file.file_id)
+AND NOT EXISTS (SELECT 1 FROM tg_file_thumbnail_tab thumbnail WHERE thumbnail.thumb_file_id = file.file_id)
 AND NOT EXISTS (
     SELECT 1
     FROM tg_s3_multipart_part_tab part
@@ -362,6 +368,14 @@ AND NOT EXISTS (
     WHERE part.file_id = file.file_id
       AND part.part_state = 'active'
       AND upload.upload_state = 'active'
+)
+AND NOT EXISTS (
+    SELECT 1 FROM tg_upload_session_tab session
+    WHERE session.file_id = file.file_id AND session.completed_at = 0
+)
+AND NOT EXISTS (
+    SELECT 1 FROM tg_fetch_job_tab fetch_job
+    WHERE fetch_job.file_id = file.file_id AND fetch_job.job_state = 'publishing'
 );`).Scan(&report.UnreferencedFileCount); err != nil {
 		return fmt.Errorf("count unreferenced files: %w", err)
 	}
@@ -1175,7 +1189,11 @@ WHERE item.file_kind = 2 AND metadata.entry_id IS NULL`, bucket.Name).Scan(&coun
 		}
 		report.S3MappingWithoutMetadataByBucket[bucket.Name] = count
 	}
-	if err := readBlockDeleteAudit(ctx, database, report, options.BackendKind); err != nil {
+	backendKinds := []string(nil)
+	if options.BackendKind != "" {
+		backendKinds = append([]string{options.BackendKind}, options.TierKinds...)
+	}
+	if err := readBlockDeleteAudit(ctx, database, report, backendKinds); err != nil {
 		return err
 	}
 	return readPrivateSharingAudit(ctx, database, report, options.S3Buckets)
@@ -1185,7 +1203,7 @@ func readBlockDeleteAudit(
 	ctx context.Context,
 	database *sql.DB,
 	report *AuditReport,
-	backendKind string,
+	backendKinds []string,
 ) error {
 	if err := readBlockDeleteStateCounts(ctx, database, report); err != nil {
 		return err
@@ -1220,12 +1238,17 @@ LEFT JOIN tg_file_part_tab part
 WHERE part.file_id IS NULL`).Scan(&report.DeleteStateWithoutPart); err != nil {
 		return fmt.Errorf("count delete states without parts: %w", err)
 	}
-	if backendKind == "" {
+	if len(backendKinds) == 0 {
 		return nil
 	}
+	args := make([]any, 0, len(backendKinds))
+	for _, kind := range backendKinds {
+		args = append(args, kind)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(backendKinds)), ", ")
 	if err := database.QueryRowContext(ctx, `
 SELECT COUNT(*) FROM tg_file_part_delete_state_tab
-WHERE backend_kind <> ?`, backendKind).Scan(&report.BlockDeleteBackendMismatch); err != nil {
+WHERE backend_kind NOT IN (`+placeholders+`)`, args...).Scan(&report.BlockDeleteBackendMismatch); err != nil {
 		return fmt.Errorf("count block delete backend mismatches: %w", err)
 	}
 	return nil
@@ -16,6 +16,7 @@ type AuditConfig struct {
 	DatabaseFile   string
 	S3Buckets      []AuditBucket
 	BackendKind    string
+	TierKinds      []string
 	BackupWorkDir  string
 	TelegramBotID  int64
 	TelegramChatID int64
@@ -41,6 +42,9 @@ func ReadAuditConfig(file string) (*AuditConfig, error) {
 			ChatID int64  `json:"chatid"`
 			Token  string `json:"token"`
 		} `json:"bot_config"`
+		StorageClasses []struct {
+			BotKind string `json:"bot_kind"`
+		} `json:"storage_classes"`
 		S3 struct {
 			Buckets []struct {
 				Name string `json:"name"`
@@ -72,6 +76,10 @@ func ReadAuditConfig(file string) (*AuditConfig, error) {
 	for _, bucket := range value.S3.Buckets {
 		buckets = append(buckets, AuditBucket{Name: bucket.Name, ACL: bucket.ACL})
 	}
+	tierKinds := make([]string, 0, len(value.StorageClasses))
+	for _, tier := range value.StorageClasses {
+		tierKinds = append(tierKinds, tier.BotKind)
+	}
 	botID := int64(0)
 	if prefix, _, found := strings.Cut(value.BotConfig.Token, ":"); found {
 		botID, _ = strconv.ParseInt(prefix, 10, 64)
@@ -80,6 +88,7 @@ func ReadAuditConfig(file string) (*AuditConfig, error) {
 		DatabaseFile:   value.DatabaseFile,
 		S3Buckets:      buckets,
 		BackendKind:    value.BotKind,
+		TierKinds:      tierKinds,
 		BackupWorkDir:  workDir,
 		TelegramBotID:  botID,
 		TelegramChatID: value.BotConfig.ChatID,
@@ -0,0 +1,23 @@
+-- Temporary S3 credentials minted from a configured principal. Only a SHA-256
+-- digest of the session token is stored. The secret access key is random and
+-- kept sealed under the token (sealed_secret, added by 0034), so a database
+-- copy alone cannot sign requests.
+CREATE TABLE tg_s3_session_credential_tab (
+    access_key_id TEXT NOT NULL PRIMARY KEY CHECK (access_key_id != ''),
+    owner TEXT NOT NULL CHECK (owner != ''),
+    token_sha256 TEXT NOT NULL CHECK (length(token_sha256) = 64),
+    scope_bucket TEXT NOT NULL DEFAULT '',
+    scope_prefix TEXT NOT NULL DEFAULT '',
+    read_only INTEGER NOT NULL DEFAULT 0 CHECK (read_only IN (0, 1)),
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    revoked_at INTEGER NOT NULL DEFAULT 0 CHECK (revoked_at >= 0),
+    CHECK (expires_at > created_at),
+    CHECK (scope_prefix = '' OR scope_bucket != '')
+);
+
+CREATE INDEX idx_tg_s3_session_credential_owner
+ON tg_s3_session_credential_tab (owner, created_at DESC, access_key_id);
+
+CREATE INDEX idx_tg_s3_session_credential_expiry
+ON tg_s3_session_credential_tab (expires_at);
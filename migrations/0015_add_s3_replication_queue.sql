@@ -0,0 +1,29 @@
+-- Per-object replication work derived from tg_webdav_change_tab. The change
+-- journal is written in the same transaction as every object mutation, so it
+-- acts as the outbox; each rule keeps its own journal cursor and only changes
+-- made after a rule was configured are replicated.
+CREATE TABLE tg_s3_replication_cursor_tab (
+    bucket TEXT NOT NULL CHECK (bucket != ''),
+    rule_id TEXT NOT NULL CHECK (rule_id != ''),
+    revision INTEGER NOT NULL CHECK (revision >= 0),
+    PRIMARY KEY (bucket, rule_id)
+);
+
+CREATE TABLE tg_s3_replication_tab (
+    bucket TEXT NOT NULL CHECK (bucket != ''),
+    rule_id TEXT NOT NULL CHECK (rule_id != ''),
+    object_key TEXT NOT NULL CHECK (object_key != ''),
+    revision INTEGER NOT NULL CHECK (revision > 0),
+    change_kind TEXT NOT NULL
+        CHECK (change_kind IN ('created', 'updated', 'deleted')),
+    state TEXT NOT NULL
+        CHECK (state IN ('pending', 'completed', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0 CHECK (attempts >= 0),
+    next_attempt_at INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    updated_at INTEGER NOT NULL,
+    PRIMARY KEY (bucket, rule_id, object_key)
+);
+
+CREATE INDEX idx_tg_s3_replication_due
+ON tg_s3_replication_tab (state, next_attempt_at);
@@ -0,0 +1,11 @@
+-- Backend that holds a physical file's blocks. An empty value is the primary
+-- bot_kind backend, which is where every file written before storage tiers
+-- lives. Composite files keep the value empty; their class follows their
+-- source files.
+ALTER TABLE tg_file_tab
+    ADD COLUMN backend_kind TEXT NOT NULL DEFAULT '';
+
+-- Storage class requested by CreateMultipartUpload. An empty value selects
+-- the default class when parts are uploaded.
+ALTER TABLE tg_s3_multipart_upload_tab
+    ADD COLUMN storage_class TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,24 @@
+-- Inventory configurations stored by PutBucketInventoryConfiguration. The
+-- scheduled worker writes a report for every enabled configuration whose
+-- next_run_at has passed; optional_fields is the comma separated list of
+-- report columns that follow Bucket and Key.
+CREATE TABLE tg_s3_inventory_tab (
+    bucket TEXT NOT NULL CHECK (bucket != ''),
+    config_id TEXT NOT NULL CHECK (config_id != ''),
+    enabled INTEGER NOT NULL CHECK (enabled IN (0, 1)),
+    prefix TEXT NOT NULL DEFAULT '',
+    destination_bucket TEXT NOT NULL CHECK (destination_bucket != ''),
+    destination_prefix TEXT NOT NULL DEFAULT '',
+    format TEXT NOT NULL CHECK (format IN ('CSV', 'NDJSON')),
+    frequency TEXT NOT NULL CHECK (frequency IN ('Daily', 'Weekly')),
+    optional_fields TEXT NOT NULL DEFAULT '',
+    next_run_at INTEGER NOT NULL,
+    last_run_at INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    PRIMARY KEY (bucket, config_id)
+);
+
+CREATE INDEX idx_tg_s3_inventory_due
+ON tg_s3_inventory_tab (enabled, next_run_at);
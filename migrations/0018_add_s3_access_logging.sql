@@ -0,0 +1,9 @@
+-- Access logging targets stored by PutBucketLogging. Records are buffered in
+-- memory and flushed as log objects into target_bucket under target_prefix.
+CREATE TABLE tg_s3_logging_tab (
+    bucket TEXT NOT NULL PRIMARY KEY CHECK (bucket != ''),
+    target_bucket TEXT NOT NULL CHECK (target_bucket != ''),
+    target_prefix TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
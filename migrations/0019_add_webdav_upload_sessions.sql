@@ -0,0 +1,25 @@
+-- Resumable WebDAV PUT sessions. The bytes are staged in upload_temp_dir;
+-- the received byte ranges are kept here, merged, so a client can resume.
+CREATE TABLE tg_webdav_upload_tab (
+    upload_id TEXT NOT NULL PRIMARY KEY CHECK (length(upload_id) = 36),
+    principal TEXT NOT NULL CHECK (principal != ''),
+    path TEXT NOT NULL CHECK (path != ''),
+    total_size INTEGER NOT NULL CHECK (total_size >= 0),
+    upload_state TEXT NOT NULL
+        CHECK (upload_state IN ('active', 'completing')),
+    ctime INTEGER NOT NULL,
+    mtime INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    UNIQUE (principal, path)
+);
+
+CREATE INDEX idx_tg_webdav_upload_expire
+ON tg_webdav_upload_tab (expires_at);
+
+CREATE TABLE tg_webdav_upload_range_tab (
+    upload_id TEXT NOT NULL,
+    range_start INTEGER NOT NULL CHECK (range_start >= 0),
+    range_end INTEGER NOT NULL,
+    PRIMARY KEY (upload_id, range_start),
+    CHECK (range_end > range_start)
+);
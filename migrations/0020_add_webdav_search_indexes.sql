@@ -0,0 +1,10 @@
+-- WebDAV SEARCH filters tg_file_mapping_tab before resolving paths; these
+-- indexes serve the getcontentlength, getlastmodified and displayname tests.
+CREATE INDEX idx_tg_file_mapping_size
+ON tg_file_mapping_tab (file_size);
+
+CREATE INDEX idx_tg_file_mapping_mtime
+ON tg_file_mapping_tab (mtime);
+
+CREATE INDEX idx_tg_file_mapping_name_nocase
+ON tg_file_mapping_tab (file_name COLLATE NOCASE);
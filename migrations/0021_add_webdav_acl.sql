@@ -0,0 +1,12 @@
+-- WebDAV access control entries (RFC 3744). Entries are keyed by entry_id so
+-- they follow MOVE, and are inherited by every descendant of the entry.
+-- privileges is a bit set of filemgr.WebDAVPrivilege values.
+CREATE TABLE tg_webdav_ace_tab (
+    entry_id INTEGER NOT NULL,
+    position INTEGER NOT NULL CHECK (position >= 0),
+    principal TEXT NOT NULL CHECK (principal != ''),
+    deny INTEGER NOT NULL DEFAULT 0 CHECK (deny IN (0, 1)),
+    privileges INTEGER NOT NULL CHECK (privileges > 0),
+    ctime INTEGER NOT NULL,
+    PRIMARY KEY (entry_id, position)
+);
@@ -0,0 +1,10 @@
+-- WebDAV bindings (RFC 5842). Mappings that share a resource_id are bindings
+-- of one resource: a write through any of them updates all of them. Unbound
+-- mappings have no row and use their own entry_id as resource id.
+CREATE TABLE tg_webdav_binding_tab (
+    entry_id INTEGER PRIMARY KEY,
+    resource_id INTEGER NOT NULL
+);
+
+CREATE INDEX idx_tg_webdav_binding_resource
+ON tg_webdav_binding_tab (resource_id);
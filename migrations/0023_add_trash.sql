@@ -0,0 +1,17 @@
+-- Recycle bin. A trashed mapping subtree is re-parented under trash_id, an
+-- entry id that no mapping owns, so it is hidden from path resolution while
+-- its rows, references and per-entry metadata stay in place.
+CREATE TABLE tg_trash_tab (
+    trash_id INTEGER PRIMARY KEY,
+    entry_id INTEGER NOT NULL,
+    original_path TEXT NOT NULL CHECK (original_path != ''),
+    is_dir INTEGER NOT NULL CHECK (is_dir IN (0, 1)),
+    file_size INTEGER NOT NULL CHECK (file_size >= 0),
+    entry_count INTEGER NOT NULL CHECK (entry_count > 0),
+    principal TEXT NOT NULL,
+    protocol TEXT NOT NULL,
+    deleted_at INTEGER NOT NULL
+);
+
+CREATE INDEX idx_tg_trash_deleted_at
+ON tg_trash_tab (deleted_at);
@@ -0,0 +1,43 @@
+-- Namespace snapshots. A snapshot is a frozen copy of the mapping rows under
+-- a scope. Entry paths are relative to the scope, with "/" naming the scope
+-- itself. Snapshot entries keep their Files referenced until the snapshot is
+-- deleted, the same way backup export pins do.
+CREATE TABLE tg_snapshot_tab (
+    snapshot_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    snapshot_name TEXT NOT NULL UNIQUE CHECK (snapshot_name != ''),
+    scope TEXT NOT NULL CHECK (substr(scope, 1, 1) = '/'),
+    origin TEXT NOT NULL CHECK (origin IN ('api', 'cli', 'schedule')),
+    schedule_name TEXT NOT NULL DEFAULT '',
+    principal TEXT NOT NULL DEFAULT '',
+    file_count INTEGER NOT NULL CHECK (file_count >= 0),
+    dir_count INTEGER NOT NULL CHECK (dir_count > 0),
+    total_size INTEGER NOT NULL CHECK (total_size >= 0),
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL CHECK (expires_at >= 0)
+);
+
+CREATE INDEX idx_tg_snapshot_expires_at
+ON tg_snapshot_tab (expires_at);
+
+CREATE INDEX idx_tg_snapshot_schedule
+ON tg_snapshot_tab (schedule_name, created_at);
+
+CREATE TABLE tg_snapshot_entry_tab (
+    snapshot_id INTEGER NOT NULL,
+    entry_path TEXT NOT NULL CHECK (substr(entry_path, 1, 1) = '/'),
+    parent_path TEXT NOT NULL,
+    entry_name TEXT NOT NULL,
+    is_dir INTEGER NOT NULL CHECK (is_dir IN (0, 1)),
+    file_id INTEGER NOT NULL CHECK (file_id >= 0),
+    file_size INTEGER NOT NULL CHECK (file_size >= 0),
+    file_mode INTEGER NOT NULL,
+    ctime INTEGER NOT NULL,
+    mtime INTEGER NOT NULL,
+    PRIMARY KEY (snapshot_id, entry_path)
+);
+
+CREATE INDEX idx_tg_snapshot_entry_parent
+ON tg_snapshot_entry_tab (snapshot_id, parent_path, entry_name);
+
+CREATE INDEX idx_tg_snapshot_entry_file
+ON tg_snapshot_entry_tab (file_id);
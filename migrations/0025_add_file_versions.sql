@@ -0,0 +1,21 @@
+-- Previous contents of overwritten paths. A version row keeps its File
+-- referenced until the version is pruned, deleted or restored over again.
+-- Versions follow the path, not the mapping entry, so they survive the
+-- deletion of the path and stay behind when the path is moved.
+CREATE TABLE tg_file_version_tab (
+    version_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    resource_path TEXT NOT NULL CHECK (substr(resource_path, 1, 1) = '/'),
+    file_id INTEGER NOT NULL CHECK (file_id > 0),
+    file_size INTEGER NOT NULL CHECK (file_size >= 0),
+    mtime INTEGER NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
+CREATE INDEX idx_tg_file_version_path
+ON tg_file_version_tab (resource_path, version_id);
+
+CREATE INDEX idx_tg_file_version_file
+ON tg_file_version_tab (file_id);
+
+CREATE INDEX idx_tg_file_version_created_at
+ON tg_file_version_tab (created_at);
@@ -0,0 +1,19 @@
+-- Generated previews of image Files. A row is keyed by the source File and
+-- the longest edge it was rendered for, so changing the configured size
+-- renders new previews. thumb_file_id is zero when the source cannot be
+-- previewed, which keeps the manager from decoding it again on every
+-- request. A row pins its preview File until the worker drops it after the
+-- source File lost its last reference.
+CREATE TABLE tg_file_thumbnail_tab (
+    source_file_id INTEGER NOT NULL CHECK (source_file_id > 0),
+    max_edge INTEGER NOT NULL CHECK (max_edge > 0),
+    thumb_file_id INTEGER NOT NULL CHECK (thumb_file_id >= 0),
+    file_size INTEGER NOT NULL CHECK (file_size >= 0),
+    width INTEGER NOT NULL CHECK (width >= 0),
+    height INTEGER NOT NULL CHECK (height >= 0),
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (source_file_id, max_edge)
+);
+
+CREATE INDEX idx_tg_file_thumbnail_file
+ON tg_file_thumbnail_tab (thumb_file_id);
@@ -0,0 +1,18 @@
+-- Keys of direct uploads. Token keys are random and only resolve while
+-- their row exists and is not revoked; a row is keyed by the SHA-256 digest
+-- of the key, so the key itself is never stored. Legacy keys resolve without
+-- a row and get one only when they are revoked. replaces_digest names the
+-- legacy key a token key was issued for by the migration, which keeps the
+-- migration from issuing a second key for the same link.
+CREATE TABLE tg_file_key_tab (
+    key_digest TEXT PRIMARY KEY,
+    key_kind TEXT NOT NULL CHECK (key_kind IN ('legacy', 'token')),
+    link_path TEXT NOT NULL,
+    replaces_digest TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL,
+    revoked_at INTEGER NOT NULL DEFAULT 0 CHECK (revoked_at >= 0)
+);
+
+CREATE UNIQUE INDEX idx_tg_file_key_replaces
+ON tg_file_key_tab (replaces_digest)
+WHERE replaces_digest != '';
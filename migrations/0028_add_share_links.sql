@@ -0,0 +1,23 @@
+-- Public share links of namespace paths. Only a SHA-256 digest of the link
+-- token and a PBKDF2 hash of the optional password are stored. The link
+-- follows the path, so it stops working when the path is deleted or moved.
+CREATE TABLE tg_share_link_tab (
+    share_id TEXT NOT NULL PRIMARY KEY CHECK (share_id != ''),
+    token_sha256 TEXT NOT NULL UNIQUE CHECK (length(token_sha256) = 64),
+    owner TEXT NOT NULL CHECK (owner != ''),
+    link_path TEXT NOT NULL CHECK (substr(link_path, 1, 1) = '/'),
+    password_hash TEXT NOT NULL DEFAULT '',
+    allow_listing INTEGER NOT NULL DEFAULT 0 CHECK (allow_listing IN (0, 1)),
+    max_downloads INTEGER NOT NULL DEFAULT 0 CHECK (max_downloads >= 0),
+    download_count INTEGER NOT NULL DEFAULT 0 CHECK (download_count >= 0),
+    view_count INTEGER NOT NULL DEFAULT 0 CHECK (view_count >= 0),
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL DEFAULT 0 CHECK (expires_at >= 0),
+    last_access_at INTEGER NOT NULL DEFAULT 0,
+    revoked_at INTEGER NOT NULL DEFAULT 0 CHECK (revoked_at >= 0),
+    CHECK (expires_at = 0 OR expires_at > created_at),
+    CHECK (max_downloads = 0 OR download_count <= max_downloads)
+);
+
+CREATE INDEX idx_tg_share_link_owner
+ON tg_share_link_tab (owner, created_at DESC, share_id);
@@ -0,0 +1,25 @@
+-- Resumable upload sessions. file_id is an unpublished draft until the last
+-- byte arrives; block_count blocks of it are already in the backend and the
+-- bytes after them wait in the session's file below tus.temp_dir. A finished
+-- session stays until it expires so that clients can still read its offset.
+CREATE TABLE tg_upload_session_tab (
+    upload_id TEXT NOT NULL PRIMARY KEY CHECK (upload_id != ''),
+    owner TEXT NOT NULL CHECK (owner != ''),
+    file_id INTEGER NOT NULL,
+    file_name TEXT NOT NULL CHECK (file_name != ''),
+    target_path TEXT NOT NULL DEFAULT '',
+    metadata TEXT NOT NULL DEFAULT '',
+    upload_size INTEGER NOT NULL CHECK (upload_size >= 0),
+    block_size INTEGER NOT NULL CHECK (block_size > 0),
+    upload_offset INTEGER NOT NULL DEFAULT 0,
+    block_count INTEGER NOT NULL DEFAULT 0 CHECK (block_count >= 0),
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    completed_at INTEGER NOT NULL DEFAULT 0 CHECK (completed_at >= 0),
+    CHECK (upload_offset >= 0 AND upload_offset <= upload_size),
+    CHECK (target_path = '' OR substr(target_path, 1, 1) = '/')
+);
+
+CREATE INDEX idx_tg_upload_session_expires
+ON tg_upload_session_tab (expires_at);
@@ -0,0 +1,7 @@
+-- The principal that uploaded the file a key serves. Keys issued before
+-- this column existed, keys the legacy migration issued and revoked legacy
+-- keys have no owner, so only administrators can delete their uploads.
+ALTER TABLE tg_file_key_tab ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+
+-- Listing direct uploads looks owners up by link.
+CREATE INDEX idx_tg_file_key_link ON tg_file_key_tab (link_path);
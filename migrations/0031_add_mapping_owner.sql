@@ -0,0 +1,8 @@
+-- The principal that created a mapping, or wrote its current content. It is
+-- empty for mappings created before this column existed, by background work
+-- or by the CLI; `tgfile quota backfill-owner` assigns those. Quotas charge
+-- the logical size of every file row a principal owns, so the index covers
+-- the per-principal sums.
+ALTER TABLE tg_file_mapping_tab ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_tg_file_mapping_owner ON tg_file_mapping_tab (owner, file_kind, file_size);
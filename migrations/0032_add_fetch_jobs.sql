@@ -0,0 +1,52 @@
+-- Remote URL ingestion jobs. request_headers holds the JSON object of the
+-- headers sent to the source; it may carry credentials, so it is cleared as
+-- soon as a job completes. A file target stores the digest and name of the
+-- key issued at creation, never the key. content_md5 and content_sha256 are
+-- set with file_id when the fetch finishes, so publishing can be resumed.
+-- webdav_scope holds the groups, quota root and limits a WebDAV target is
+-- published with, resolved from the share the client addressed.
+CREATE TABLE tg_fetch_job_tab (
+    job_id TEXT NOT NULL PRIMARY KEY CHECK (length(job_id) = 64),
+    owner TEXT NOT NULL CHECK (owner != ''),
+    job_state TEXT NOT NULL CHECK (job_state IN (
+        'queued',
+        'fetching',
+        'publishing',
+        'canceling',
+        'succeeded',
+        'failed',
+        'canceled'
+    )),
+    source_url TEXT NOT NULL,
+    request_headers TEXT NOT NULL DEFAULT '{}',
+    target_kind TEXT NOT NULL CHECK (target_kind IN ('file', 's3', 'webdav')),
+    target_bucket TEXT NOT NULL DEFAULT '',
+    target_path TEXT NOT NULL DEFAULT '',
+    key_digest TEXT NOT NULL DEFAULT '',
+    webdav_scope TEXT NOT NULL DEFAULT '{}',
+    idempotency_key TEXT NOT NULL,
+    request_fingerprint TEXT NOT NULL,
+    bytes_total INTEGER NOT NULL DEFAULT -1 CHECK (bytes_total >= -1),
+    bytes_completed INTEGER NOT NULL DEFAULT 0 CHECK (bytes_completed >= 0),
+    attempts INTEGER NOT NULL DEFAULT 0 CHECK (attempts >= 0),
+    content_type TEXT NOT NULL DEFAULT '',
+    content_md5 TEXT NOT NULL DEFAULT '',
+    content_sha256 TEXT NOT NULL DEFAULT '',
+    file_id INTEGER NOT NULL DEFAULT 0 CHECK (file_id >= 0),
+    error_code TEXT NOT NULL DEFAULT '',
+    error_message TEXT NOT NULL DEFAULT '',
+    cancel_requested INTEGER NOT NULL DEFAULT 0 CHECK (cancel_requested IN (0, 1)),
+    created_at INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    completed_at INTEGER NOT NULL DEFAULT 0,
+    UNIQUE (owner, idempotency_key)
+);
+
+CREATE INDEX idx_tg_fetch_job_work
+ON tg_fetch_job_tab (job_state, created_at);
+
+CREATE INDEX idx_tg_fetch_job_page
+ON tg_fetch_job_tab (owner, created_at, job_id);
+
+CREATE INDEX idx_tg_fetch_job_retention
+ON tg_fetch_job_tab (completed_at);
@@ -0,0 +1,9 @@
+-- The media type the leading bytes of a file sniffed as when it was written,
+-- empty for empty files and for files written before this column existed.
+ALTER TABLE tg_file_tab ADD COLUMN sniffed_type TEXT NOT NULL DEFAULT '';
+
+-- The media type served for a mapping: the sniffed type of its file combined
+-- with the extension of its name, or the Content-Type an S3 client stored.
+-- It is empty for mappings created before this column existed until
+-- `tgfile content-type backfill` assigns one.
+ALTER TABLE tg_file_mapping_tab ADD COLUMN content_type TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,4 @@
+-- Session secrets are random and stored sealed under a key derived from the
+-- session token. Credentials issued before this migration derived their secret
+-- from the token alone; they keep an empty sealed_secret and are rejected.
+ALTER TABLE tg_s3_session_credential_tab ADD COLUMN sealed_secret TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,24 @@
+-- Replication rules set by PutBucketReplication. A row in
+-- tg_s3_replication_config_tab marks a bucket whose rules have been stored,
+-- so rules seeded once from the legacy per-bucket configuration are not
+-- restored after DeleteBucketReplication.
+CREATE TABLE tg_s3_replication_config_tab (
+    bucket TEXT NOT NULL PRIMARY KEY CHECK (bucket != ''),
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE tg_s3_replication_rule_tab (
+    bucket TEXT NOT NULL CHECK (bucket != ''),
+    rule_id TEXT NOT NULL CHECK (rule_id != ''),
+    priority INTEGER NOT NULL DEFAULT 0,
+    enabled INTEGER NOT NULL CHECK (enabled IN (0, 1)),
+    prefix TEXT NOT NULL DEFAULT '',
+    target TEXT NOT NULL CHECK (target != ''),
+    target_bucket TEXT NOT NULL CHECK (target_bucket != ''),
+    replicate_deletes INTEGER NOT NULL CHECK (replicate_deletes IN (0, 1)),
+    PRIMARY KEY (bucket, rule_id)
+);
+
+-- Queue rows are now written in the transaction of each change, so the
+-- per-rule journal cursors are no longer read.
+DROP TABLE tg_s3_replication_cursor_tab;
@@ -0,0 +1,8 @@
+-- webdav_scope holds the groups, admin override, quota root and limits an
+-- upload with a target path is published with, resolved from the WebDAV
+-- share the client addressed when it created the session. Unfinished
+-- sessions created before this migration never had their target resolved;
+-- they expire at once and the worker discards their drafts.
+ALTER TABLE tg_upload_session_tab ADD COLUMN webdav_scope TEXT NOT NULL DEFAULT '{}';
+
+UPDATE tg_upload_session_tab SET expires_at = 0 WHERE target_path != '' AND completed_at = 0;
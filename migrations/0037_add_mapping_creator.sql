@@ -0,0 +1,8 @@
+-- The principal that created a mapping. owner follows whoever last wrote
+-- the content, so quotas charge the account that stored it; creator never
+-- changes on overwrite. WebDAV keeps DAV:write-acl by default for the
+-- creator, so DAV:write on a file cannot be turned into control of its ACL
+-- by overwriting it. Existing mappings take their current owner.
+ALTER TABLE tg_file_mapping_tab ADD COLUMN creator TEXT NOT NULL DEFAULT '';
+
+UPDATE tg_file_mapping_tab SET creator = owner;
@@ -0,0 +1,15 @@
+-- Resumable WebDAV PUTs become upload sessions like tus uploads: their
+-- blocks go to the backend as they fill instead of waiting in a sparse
+-- staging file. origin keeps the two kinds apart; a WebDAV session is found
+-- by account and path, so each account has one unfinished session per path.
+-- Sessions of the old tables cannot be carried over, because their bytes
+-- were staged locally in any order; clients restart those uploads.
+ALTER TABLE tg_upload_session_tab ADD COLUMN origin TEXT NOT NULL DEFAULT 'tus'
+    CHECK (origin IN ('tus', 'webdav'));
+
+CREATE UNIQUE INDEX idx_tg_upload_session_webdav_target
+ON tg_upload_session_tab (owner, target_path)
+WHERE origin = 'webdav' AND completed_at = 0;
+
+DROP TABLE tg_webdav_upload_range_tab;
+DROP TABLE tg_webdav_upload_tab;
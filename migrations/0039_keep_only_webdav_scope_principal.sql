@@ -0,0 +1,11 @@
+-- webdav_scope keeps only the account a deferred WebDAV write is published
+-- as and the mount its target was addressed through. Groups, the admin
+-- override and the quota are looked up again when the write is published,
+-- so an account that lost them in between does not publish with them.
+-- Earlier rows did not record the mount: they publish through the WebDAV
+-- root, and fail to publish once mounts are configured.
+UPDATE tg_fetch_job_tab SET webdav_scope = json_object('principal', owner)
+WHERE target_kind = 'webdav';
+
+UPDATE tg_upload_session_tab SET webdav_scope = json_object('principal', owner)
+WHERE target_path != '';
@@ -0,0 +1,197 @@
+package presign
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsv4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+var ErrInvalidRequest = errors.New("invalid presign request")
+
+const (
+	DefaultExpires = 15 * time.Minute
+	MinExpires     = time.Second
+	MaxExpires     = 7 * 24 * time.Hour
+
+	// Region and service match the scope s3verify accepts.
+	region          = "us-east-1"
+	service         = "s3"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	maxPartNumber   = 10000
+)
+
+// ResponseOverrides are the response-* query parameters GetObject honours.
+var ResponseOverrides = []string{
+	"response-cache-control",
+	"response-content-disposition",
+	"response-content-encoding",
+	"response-content-language",
+	"response-content-type",
+	"response-expires",
+}
+
+// Request describes one presigned S3 request. PartNumber and UploadID select
+// UploadPart; ContentType, when set, is signed so the uploader must send it.
+type Request struct {
+	Endpoint     string
+	Method       string
+	Bucket       string
+	Key          string
+	PartNumber   int
+	UploadID     string
+	Expires      time.Duration
+	ContentType  string
+	Overrides    map[string]string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Now          time.Time
+}
+
+type Result struct {
+	URL       string            `json:"url"`
+	Method    string            `json:"method"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+// Sign builds a path style SigV4 presigned URL for request.
+func Sign(ctx context.Context, request *Request) (*Result, error) {
+	if err := validateTarget(request); err != nil {
+		return nil, err
+	}
+	if err := validateOptions(request); err != nil {
+		return nil, err
+	}
+	target, err := objectURL(request)
+	if err != nil {
+		return nil, err
+	}
+	httpRequest, err := http.NewRequestWithContext(ctx, request.Method, target.String(), http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("build presign request: %w", err)
+	}
+	if request.ContentType != "" {
+		httpRequest.Header.Set("Content-Type", request.ContentType)
+	}
+	now := request.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	signer := awsv4.NewSigner(func(options *awsv4.SignerOptions) {
+		options.DisableURIPathEscaping = true
+	})
+	signedURL, signedHeaders, err := signer.PresignHTTP(
+		ctx,
+		aws.Credentials{
+			AccessKeyID:     request.AccessKey,
+			SecretAccessKey: request.SecretKey,
+			SessionToken:    request.SessionToken,
+		},
+		httpRequest,
+		unsignedPayload,
+		service,
+		region,
+		now.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("presign request: %w", err)
+	}
+	result := &Result{
+		URL:       signedURL,
+		Method:    request.Method,
+		ExpiresAt: now.UTC().Truncate(time.Second).Add(request.Expires),
+	}
+	// The client has to send every signed header except Host verbatim.
+	for name, values := range signedHeaders {
+		if strings.EqualFold(name, "Host") || len(values) == 0 {
+			continue
+		}
+		if result.Headers == nil {
+			result.Headers = make(map[string]string)
+		}
+		result.Headers[http.CanonicalHeaderKey(name)] = strings.Join(values, ",")
+	}
+	return result, nil
+}
+
+func objectURL(request *Request) (*url.URL, error) {
+	target, err := url.Parse(request.Endpoint)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" ||
+		(target.Path != "" && target.Path != "/") || target.RawQuery != "" {
+		return nil, fmt.Errorf("%w: endpoint %q must be an http(s) origin", ErrInvalidRequest, request.Endpoint)
+	}
+	target.Path = "/" + request.Bucket + "/" + request.Key
+	target.RawPath = "/" + request.Bucket + "/" + escapeObjectKey(request.Key)
+	query := url.Values{}
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(request.Expires/time.Second), 10))
+	if request.UploadID != "" {
+		query.Set("partNumber", strconv.Itoa(request.PartNumber))
+		query.Set("uploadId", request.UploadID)
+	}
+	for name, value := range request.Overrides {
+		query.Set(name, value)
+	}
+	target.RawQuery = query.Encode()
+	return target, nil
+}
+
+func validateTarget(request *Request) error {
+	switch {
+	case request.Method != http.MethodGet && request.Method != http.MethodPut:
+		return fmt.Errorf("%w: method must be GET or PUT", ErrInvalidRequest)
+	case request.Bucket == "" || request.Key == "":
+		return fmt.Errorf("%w: bucket and key are required", ErrInvalidRequest)
+	case request.AccessKey == "" || request.SecretKey == "":
+		return fmt.Errorf("%w: credentials are required", ErrInvalidRequest)
+	case request.Expires < MinExpires || request.Expires > MaxExpires || request.Expires%time.Second != 0:
+		return fmt.Errorf("%w: expiry must be whole seconds between %s and %s", ErrInvalidRequest, MinExpires, MaxExpires)
+	}
+	return nil
+}
+
+func validateOptions(request *Request) error {
+	switch {
+	case (request.UploadID == "") != (request.PartNumber == 0):
+		return fmt.Errorf("%w: part number and upload id must be given together", ErrInvalidRequest)
+	case request.UploadID != "" && request.Method != http.MethodPut:
+		return fmt.Errorf("%w: UploadPart must use PUT", ErrInvalidRequest)
+	case request.PartNumber < 0 || request.PartNumber > maxPartNumber:
+		return fmt.Errorf("%w: part number must be between 1 and %d", ErrInvalidRequest, maxPartNumber)
+	case request.ContentType != "" && request.Method != http.MethodPut:
+		return fmt.Errorf("%w: a content type restriction only applies to PUT", ErrInvalidRequest)
+	case len(request.Overrides) != 0 && request.Method != http.MethodGet:
+		return fmt.Errorf("%w: response overrides only apply to GET", ErrInvalidRequest)
+	}
+	for name := range request.Overrides {
+		if !slices.Contains(ResponseOverrides, name) {
+			return fmt.Errorf("%w: unsupported response override %q", ErrInvalidRequest, name)
+		}
+	}
+	return nil
+}
+
+// escapeObjectKey percent-encodes key like the S3 clients do, keeping "/".
+func escapeObjectKey(key string) string {
+	var builder strings.Builder
+	for index := 0; index < len(key); index++ {
+		char := key[index]
+		switch {
+		case char >= 'A' && char <= 'Z', char >= 'a' && char <= 'z', char >= '0' && char <= '9',
+			char == '-', char == '_', char == '.', char == '~', char == '/':
+			builder.WriteByte(char)
+		default:
+			fmt.Fprintf(&builder, "%%%02X", char)
+		}
+	}
+	return builder.String()
+}
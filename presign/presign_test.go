@@ -0,0 +1,79 @@
+package presign
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignBuildsPathStyleURL(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	result, err := Sign(t.Context(), &Request{
+		Endpoint:     "https://files.example.com",
+		Method:       http.MethodPut,
+		Bucket:       "hackmd",
+		Key:          "reports/q 1+2.txt",
+		PartNumber:   3,
+		UploadID:     "upload/id",
+		Expires:      time.Hour,
+		ContentType:  "text/plain",
+		AccessKey:    "access",
+		SecretKey:    "secret",
+		SessionToken: "token",
+		Now:          now,
+	})
+	require.NoError(t, err)
+	signed, err := url.Parse(result.URL)
+	require.NoError(t, err)
+	require.Equal(t, "/hackmd/reports/q%201%2B2.txt", signed.EscapedPath())
+	query := signed.Query()
+	require.Equal(t, "3600", query.Get("X-Amz-Expires"))
+	require.Equal(t, "3", query.Get("partNumber"))
+	require.Equal(t, "upload/id", query.Get("uploadId"))
+	require.Equal(t, "token", query.Get("X-Amz-Security-Token"))
+	require.Equal(t, "access/20260102/us-east-1/s3/aws4_request", query.Get("X-Amz-Credential"))
+	require.Equal(t, "content-type;host", query.Get("X-Amz-SignedHeaders"))
+	require.NotEmpty(t, query.Get("X-Amz-Signature"))
+	require.Equal(t, now.Add(time.Hour), result.ExpiresAt)
+	require.Equal(t, map[string]string{"Content-Type": "text/plain"}, result.Headers)
+}
+
+func TestSignRejectsInvalidRequests(t *testing.T) {
+	valid := Request{
+		Endpoint:  "http://127.0.0.1:8080",
+		Method:    http.MethodGet,
+		Bucket:    "hackmd",
+		Key:       "a.txt",
+		Expires:   time.Minute,
+		AccessKey: "access",
+		SecretKey: "secret",
+	}
+	for name, mutate := range map[string]func(*Request){
+		"method":            func(r *Request) { r.Method = http.MethodDelete },
+		"key":               func(r *Request) { r.Key = "" },
+		"secret":            func(r *Request) { r.SecretKey = "" },
+		"short expiry":      func(r *Request) { r.Expires = 0 },
+		"long expiry":       func(r *Request) { r.Expires = MaxExpires + time.Second },
+		"fractional expiry": func(r *Request) { r.Expires = 1500 * time.Millisecond },
+		"part without id":   func(r *Request) { r.Method, r.PartNumber = http.MethodPut, 1 },
+		"part on GET":       func(r *Request) { r.PartNumber, r.UploadID = 1, "u" },
+		"part number":       func(r *Request) { r.Method, r.PartNumber, r.UploadID = http.MethodPut, 10001, "u" },
+		"content type":      func(r *Request) { r.ContentType = "text/plain" },
+		"override on PUT": func(r *Request) {
+			r.Method, r.Overrides = http.MethodPut, map[string]string{"response-content-type": "a/b"}
+		},
+		"unknown override": func(r *Request) { r.Overrides = map[string]string{"response-x": "y"} },
+		"endpoint path":    func(r *Request) { r.Endpoint = "http://127.0.0.1:8080/s3" },
+		"endpoint scheme":  func(r *Request) { r.Endpoint = "ftp://127.0.0.1" },
+	} {
+		request := valid
+		mutate(&request)
+		_, err := Sign(t.Context(), &request)
+		require.ErrorIs(t, err, ErrInvalidRequest, name)
+	}
+	_, err := Sign(t.Context(), &valid)
+	require.NoError(t, err)
+}
@@ -0,0 +1,277 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	awsv4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/xxxsen/common/database"
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+var (
+	ErrInvalidRule   = errors.New("invalid replication rule")
+	errUnknownTarget = errors.New("replication target is not configured")
+)
+
+// Status is the per-object value reported in x-amz-replication-status.
+type Status string
+
+const (
+	StatusPending   Status = "PENDING"
+	StatusCompleted Status = "COMPLETED"
+	StatusFailed    Status = "FAILED"
+)
+
+const (
+	defaultPollInterval = time.Second
+	defaultMaxAttempts  = 8
+	defaultMinBackoff   = time.Second
+	defaultMaxBackoff   = 10 * time.Minute
+	defaultHTTPTimeout  = 30 * time.Minute
+	batchSize           = 100
+	maxRules            = 1000
+	maxRuleIDBytes      = 255
+	maxPrefixBytes      = 1024
+)
+
+// Target is an S3 compatible endpoint, such as a second tgfile instance,
+// that rules replicate to. Targets come from the service configuration so
+// their credentials never pass through the S3 API.
+type Target struct {
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// Rule replicates objects of Bucket whose key starts with Prefix to
+// TargetBucket on the endpoint named Target.
+type Rule struct {
+	ID               string
+	Bucket           string
+	Priority         int
+	Enabled          bool
+	Prefix           string
+	Target           string
+	TargetBucket     string
+	ReplicateDeletes bool
+}
+
+type Options struct {
+	// Targets are the configured endpoints by name.
+	Targets map[string]Target
+	// Seed holds rules from the legacy per-bucket configuration. They are
+	// stored once for buckets that never had replication rules stored.
+	Seed         []Rule
+	Client       *http.Client
+	PollInterval time.Duration
+	MaxAttempts  int
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+}
+
+type ruleKey struct {
+	bucket string
+	id     string
+}
+
+type Manager struct {
+	db      database.IDatabase
+	files   filemgr.IFileManager
+	options Options
+	signer  *awsv4.Signer
+	now     func() time.Time
+}
+
+// New prepares the replication worker and stores the seed rules of buckets
+// without stored rules.
+func New(ctx context.Context, db database.IDatabase, files filemgr.IFileManager, options Options) (*Manager, error) {
+	if options.Client == nil {
+		options.Client = &http.Client{Timeout: defaultHTTPTimeout}
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = defaultPollInterval
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = defaultMaxAttempts
+	}
+	if options.MinBackoff <= 0 {
+		options.MinBackoff = defaultMinBackoff
+	}
+	if options.MaxBackoff < options.MinBackoff {
+		options.MaxBackoff = max(defaultMaxBackoff, options.MinBackoff)
+	}
+	manager := &Manager{
+		db:      db,
+		files:   files,
+		options: options,
+		signer: awsv4.NewSigner(func(signer *awsv4.SignerOptions) {
+			signer.DisableURIPathEscaping = true
+		}),
+		now: time.Now,
+	}
+	if err := manager.seed(ctx); err != nil {
+		return nil, err
+	}
+	return manager, nil
+}
+
+// TargetNames returns the configured target names in sorted order.
+func (m *Manager) TargetNames() []string {
+	names := make([]string, 0, len(m.options.Targets))
+	for name := range m.options.Targets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Run sends queued changes to their targets. Changes are queued by
+// EnqueueChange in the transaction that made them.
+func (m *Manager) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.options.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := m.runOnce(ctx); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("run replication worker: %w", ctx.Err())
+			}
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("run replication worker: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Manager) runOnce(ctx context.Context) error {
+	for {
+		processed, err := m.processDue(ctx)
+		if err != nil {
+			return err
+		}
+		if processed < batchSize {
+			return nil
+		}
+	}
+}
+
+// Status reports the replication state of an object across all rules that
+// queued its latest change: FAILED wins over PENDING, which wins over
+// COMPLETED. An empty status means no rule applies to the object.
+func (m *Manager) Status(ctx context.Context, bucket, key string) (Status, error) {
+	states, err := m.taskStates(ctx, bucket, key)
+	if err != nil {
+		return "", err
+	}
+	var status Status
+	for _, state := range states {
+		switch state {
+		case statePending:
+			status = worseStatus(status, StatusPending)
+		case stateFailed:
+			status = worseStatus(status, StatusFailed)
+		default:
+			status = worseStatus(status, StatusCompleted)
+		}
+	}
+	return status, nil
+}
+
+func worseStatus(current, next Status) Status {
+	rank := map[Status]int{"": 0, StatusCompleted: 1, StatusPending: 2, StatusFailed: 3}
+	if rank[next] > rank[current] {
+		return next
+	}
+	return current
+}
+
+func (m *Manager) processDue(ctx context.Context) (int, error) {
+	tasks, err := m.dueTasks(ctx)
+	if err != nil || len(tasks) == 0 {
+		return 0, err
+	}
+	rules, err := m.allRules(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, task := range tasks {
+		rule, exists := rules[ruleKey{bucket: task.bucket, id: task.ruleID}]
+		if !exists || !rule.Enabled {
+			if err := m.finish(ctx, task, false); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		keep, replicateErr := m.replicate(ctx, rule, task)
+		if ctx.Err() != nil {
+			return 0, fmt.Errorf("replicate object: %w", ctx.Err())
+		}
+		if replicateErr != nil {
+			logutil.GetLogger(ctx).Warn(
+				"replicate S3 object failed",
+				zap.String("bucket", task.bucket),
+				zap.String("rule", task.ruleID),
+				zap.String("key", task.key),
+				zap.Int("attempt", task.attempts+1),
+				zap.Error(replicateErr),
+			)
+			err = m.retry(ctx, task, replicateErr)
+		} else {
+			err = m.finish(ctx, task, keep)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(tasks), nil
+}
+
+// replicate applies the object's current state to the target. It reports
+// whether the queue row should be kept for status reporting; rows of deleted
+// objects and of collections are dropped once handled.
+func (m *Manager) replicate(ctx context.Context, rule Rule, task replicationTask) (bool, error) {
+	target, exists := m.options.Targets[rule.Target]
+	if !exists {
+		return true, fmt.Errorf("%w: %q", errUnknownTarget, rule.Target)
+	}
+	if task.kind != changeDeleted {
+		info, err := m.files.StatS3Object(ctx, objectPath(task.bucket, task.key))
+		if err == nil {
+			return true, m.putObject(ctx, target, rule, task.key, info)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return true, fmt.Errorf("stat replication source: %w", err)
+		}
+		// Either a collection or an object removed since; a removal has its
+		// own journal entry and is replicated from that.
+		return false, nil
+	}
+	if !rule.ReplicateDeletes {
+		return false, nil
+	}
+	return false, m.deleteObject(ctx, target, rule, task.key)
+}
+
+func (m *Manager) backoff(attempts int) time.Duration {
+	delay := m.options.MinBackoff
+	for step := 1; step < attempts && delay < m.options.MaxBackoff; step++ {
+		delay *= 2
+	}
+	return min(delay, m.options.MaxBackoff)
+}
+
+func objectPath(bucket, key string) string {
+	return "/" + bucket + "/" + key
+}
@@ -0,0 +1,204 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/directory"
+)
+
+const (
+	changeDeleted = "deleted"
+
+	statePending   = "pending"
+	stateCompleted = "completed"
+	stateFailed    = "failed"
+
+	maxLastErrorBytes = 1024
+)
+
+type replicationTask struct {
+	bucket   string
+	ruleID   string
+	key      string
+	revision int64
+	kind     string
+	attempts int
+}
+
+// EnqueueChange queues a journal change for every enabled rule whose bucket
+// and prefix cover the changed path. It runs as a directory change observer
+// inside the transaction of the change, so the queue never misses or
+// outlives a committed change. A newer change of the same object replaces
+// the queued one.
+func EnqueueChange(ctx context.Context, exec database.IExecer, change directory.Change) error {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(change.Path, "/"), "/")
+	if !ok || bucket == "" || key == "" {
+		return nil
+	}
+	if _, err := exec.ExecContext(
+		ctx,
+		`INSERT INTO tg_s3_replication_tab (
+    bucket, rule_id, object_key, revision, change_kind, state, attempts, next_attempt_at, last_error, updated_at
+)
+SELECT bucket, rule_id, ?, ?, ?, ?, 0, ?, '', ?
+FROM tg_s3_replication_rule_tab
+WHERE bucket = ? AND enabled = 1 AND substr(?, 1, length(prefix)) = prefix
+ON CONFLICT(bucket, rule_id, object_key) DO UPDATE SET
+    revision = excluded.revision,
+    change_kind = excluded.change_kind,
+    state = excluded.state,
+    attempts = 0,
+    next_attempt_at = excluded.next_attempt_at,
+    last_error = '',
+    updated_at = excluded.updated_at`,
+		key,
+		change.Revision,
+		change.Kind,
+		statePending,
+		change.ChangedAt,
+		change.ChangedAt,
+		bucket,
+		key,
+	); err != nil {
+		return fmt.Errorf("enqueue replication task: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) dueTasks(ctx context.Context) ([]replicationTask, error) {
+	rows, err := m.db.QueryContext(
+		ctx,
+		`SELECT bucket, rule_id, object_key, revision, change_kind, attempts
+FROM tg_s3_replication_tab
+WHERE state = ? AND next_attempt_at <= ?
+ORDER BY next_attempt_at, revision
+LIMIT ?`,
+		statePending,
+		m.now().UnixMilli(),
+		batchSize,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query replication queue: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	tasks := make([]replicationTask, 0, batchSize)
+	for rows.Next() {
+		var task replicationTask
+		if err := rows.Scan(
+			&task.bucket,
+			&task.ruleID,
+			&task.key,
+			&task.revision,
+			&task.kind,
+			&task.attempts,
+		); err != nil {
+			return nil, fmt.Errorf("scan replication task: %w", err)
+		}
+		tasks = append(tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate replication queue: %w", err)
+	}
+	return tasks, nil
+}
+
+// finish records a handled task. Every update is guarded by the revision the
+// task was read at, so a newer change queued meanwhile is never overwritten.
+func (m *Manager) finish(ctx context.Context, task replicationTask, keep bool) error {
+	if !keep {
+		if _, err := m.db.ExecContext(
+			ctx,
+			`DELETE FROM tg_s3_replication_tab
+WHERE bucket = ? AND rule_id = ? AND object_key = ? AND revision = ?`,
+			task.bucket,
+			task.ruleID,
+			task.key,
+			task.revision,
+		); err != nil {
+			return fmt.Errorf("drop replication task: %w", err)
+		}
+		return nil
+	}
+	if _, err := m.db.ExecContext(
+		ctx,
+		`UPDATE tg_s3_replication_tab
+SET state = ?, attempts = attempts + 1, last_error = '', updated_at = ?
+WHERE bucket = ? AND rule_id = ? AND object_key = ? AND revision = ?`,
+		stateCompleted,
+		m.now().UnixMilli(),
+		task.bucket,
+		task.ruleID,
+		task.key,
+		task.revision,
+	); err != nil {
+		return fmt.Errorf("complete replication task: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) retry(ctx context.Context, task replicationTask, cause error) error {
+	attempts := task.attempts + 1
+	now := m.now()
+	state := statePending
+	if attempts >= m.options.MaxAttempts {
+		state = stateFailed
+	}
+	message := cause.Error()
+	if len(message) > maxLastErrorBytes {
+		message = message[:maxLastErrorBytes]
+	}
+	if _, err := m.db.ExecContext(
+		ctx,
+		`UPDATE tg_s3_replication_tab
+SET state = ?, attempts = ?, next_attempt_at = ?, last_error = ?, updated_at = ?
+WHERE bucket = ? AND rule_id = ? AND object_key = ? AND revision = ?`,
+		state,
+		attempts,
+		now.Add(m.backoff(attempts)).UnixMilli(),
+		message,
+		now.UnixMilli(),
+		task.bucket,
+		task.ruleID,
+		task.key,
+		task.revision,
+	); err != nil {
+		return fmt.Errorf("reschedule replication task: %w", err)
+	}
+	return nil
+}
+
+// taskStates returns the queue states of an object under its current rules.
+func (m *Manager) taskStates(ctx context.Context, bucket, key string) ([]string, error) {
+	rows, err := m.db.QueryContext(
+		ctx,
+		`SELECT task.state FROM tg_s3_replication_tab task
+JOIN tg_s3_replication_rule_tab rule ON rule.bucket = task.bucket AND rule.rule_id = task.rule_id
+WHERE task.bucket = ? AND task.object_key = ? AND rule.enabled = 1`,
+		bucket,
+		key,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query replication status: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	states := make([]string, 0)
+	for rows.Next() {
+		var state string
+		if err := rows.Scan(&state); err != nil {
+			return nil, fmt.Errorf("scan replication status: %w", err)
+		}
+		states = append(states, state)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read replication status: %w", err)
+	}
+	return states, nil
+}
@@ -0,0 +1,208 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/xxxsen/common/database"
+)
+
+const ruleColumns = `bucket, rule_id, priority, enabled, prefix, target, target_bucket, replicate_deletes`
+
+// Rules returns the stored rules of a bucket in priority order.
+func (m *Manager) Rules(ctx context.Context, bucket string) ([]Rule, error) {
+	return m.queryRules(ctx, `WHERE bucket = ? ORDER BY priority, rule_id`, bucket)
+}
+
+// PutRules replaces the rules of a bucket, as PutBucketReplication does. A
+// new rule only replicates changes made after it was stored.
+func (m *Manager) PutRules(ctx context.Context, bucket string, rules []Rule) error {
+	if err := m.validate(bucket, rules); err != nil {
+		return err
+	}
+	if err := m.db.OnTransation(ctx, func(ctx context.Context, tx database.IQueryExecer) error {
+		if err := m.markConfigured(ctx, tx, bucket); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM tg_s3_replication_rule_tab WHERE bucket = ?`, bucket); err != nil {
+			return fmt.Errorf("delete replaced replication rules: %w", err)
+		}
+		return insertRules(ctx, tx, rules)
+	}); err != nil {
+		return fmt.Errorf("save replication rules: %w", err)
+	}
+	return nil
+}
+
+// DeleteRules removes the rules of a bucket. Queued changes of the removed
+// rules are dropped by the worker. The bucket stays marked as configured, so
+// legacy seed rules do not come back on the next start.
+func (m *Manager) DeleteRules(ctx context.Context, bucket string) error {
+	if err := m.db.OnTransation(ctx, func(ctx context.Context, tx database.IQueryExecer) error {
+		if err := m.markConfigured(ctx, tx, bucket); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM tg_s3_replication_rule_tab WHERE bucket = ?`, bucket); err != nil {
+			return fmt.Errorf("delete replication rules: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("delete replication configuration: %w", err)
+	}
+	return nil
+}
+
+// seed stores the legacy rules of every bucket that was never configured.
+func (m *Manager) seed(ctx context.Context) error {
+	buckets := make(map[string][]Rule)
+	order := make([]string, 0)
+	for _, rule := range m.options.Seed {
+		if _, exists := buckets[rule.Bucket]; !exists {
+			order = append(order, rule.Bucket)
+		}
+		buckets[rule.Bucket] = append(buckets[rule.Bucket], rule)
+	}
+	for _, bucket := range order {
+		if err := m.validate(bucket, buckets[bucket]); err != nil {
+			return err
+		}
+		if err := m.db.OnTransation(ctx, func(ctx context.Context, tx database.IQueryExecer) error {
+			result, err := tx.ExecContext(
+				ctx,
+				`INSERT INTO tg_s3_replication_config_tab (bucket, updated_at) VALUES (?, ?)
+ON CONFLICT(bucket) DO NOTHING`,
+				bucket,
+				m.now().UnixMilli(),
+			)
+			if err != nil {
+				return fmt.Errorf("mark seeded replication bucket: %w", err)
+			}
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("read seeded replication bucket: %w", err)
+			}
+			if affected == 0 {
+				return nil
+			}
+			return insertRules(ctx, tx, buckets[bucket])
+		}); err != nil {
+			return fmt.Errorf("seed replication rules: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) markConfigured(ctx context.Context, tx database.IExecer, bucket string) error {
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO tg_s3_replication_config_tab (bucket, updated_at) VALUES (?, ?)
+ON CONFLICT(bucket) DO UPDATE SET updated_at = excluded.updated_at`,
+		bucket,
+		m.now().UnixMilli(),
+	); err != nil {
+		return fmt.Errorf("mark replication bucket: %w", err)
+	}
+	return nil
+}
+
+func insertRules(ctx context.Context, tx database.IExecer, rules []Rule) error {
+	for _, rule := range rules {
+		if _, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO tg_s3_replication_rule_tab (`+ruleColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			rule.Bucket,
+			rule.ID,
+			rule.Priority,
+			rule.Enabled,
+			rule.Prefix,
+			rule.Target,
+			rule.TargetBucket,
+			rule.ReplicateDeletes,
+		); err != nil {
+			return fmt.Errorf("insert replication rule: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) allRules(ctx context.Context) (map[ruleKey]Rule, error) {
+	rules, err := m.queryRules(ctx, ``)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[ruleKey]Rule, len(rules))
+	for _, rule := range rules {
+		result[ruleKey{bucket: rule.Bucket, id: rule.ID}] = rule
+	}
+	return result, nil
+}
+
+func (m *Manager) queryRules(ctx context.Context, where string, args ...any) ([]Rule, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT `+ruleColumns+` FROM tg_s3_replication_rule_tab `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query replication rules: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	rules := make([]Rule, 0)
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate replication rules: %w", err)
+	}
+	return rules, nil
+}
+
+func scanRule(rows *sql.Rows) (Rule, error) {
+	var rule Rule
+	if err := rows.Scan(
+		&rule.Bucket,
+		&rule.ID,
+		&rule.Priority,
+		&rule.Enabled,
+		&rule.Prefix,
+		&rule.Target,
+		&rule.TargetBucket,
+		&rule.ReplicateDeletes,
+	); err != nil {
+		return Rule{}, fmt.Errorf("scan replication rule: %w", err)
+	}
+	return rule, nil
+}
+
+func (m *Manager) validate(bucket string, rules []Rule) error {
+	if len(rules) == 0 || len(rules) > maxRules {
+		return fmt.Errorf("%w: a configuration holds 1 to %d rules", ErrInvalidRule, maxRules)
+	}
+	seen := make(map[string]struct{}, len(rules))
+	for _, rule := range rules {
+		if rule.Bucket != bucket {
+			return fmt.Errorf("%w: rule %q belongs to another bucket", ErrInvalidRule, rule.ID)
+		}
+		if rule.ID == "" || len(rule.ID) > maxRuleIDBytes {
+			return fmt.Errorf("%w: rule id must contain 1 to %d bytes", ErrInvalidRule, maxRuleIDBytes)
+		}
+		if _, exists := seen[rule.ID]; exists {
+			return fmt.Errorf("%w: duplicate rule %q", ErrInvalidRule, rule.ID)
+		}
+		seen[rule.ID] = struct{}{}
+		if len(rule.Prefix) > maxPrefixBytes || strings.ContainsRune(rule.Prefix, 0) {
+			return fmt.Errorf("%w: prefix of rule %q is invalid", ErrInvalidRule, rule.ID)
+		}
+		if _, exists := m.options.Targets[rule.Target]; !exists {
+			return fmt.Errorf("%w: target %q of rule %q is not configured", ErrInvalidRule, rule.Target, rule.ID)
+		}
+		if rule.TargetBucket == "" || strings.ContainsAny(rule.TargetBucket, "/\x00") {
+			return fmt.Errorf("%w: destination bucket of rule %q is invalid", ErrInvalidRule, rule.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package replication
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/s3checksum"
+)
+
+var errTargetRejected = errors.New("replication target rejected the request")
+
+const (
+	unsignedPayload    = "UNSIGNED-PAYLOAD"
+	maxErrorBodyBytes  = 512
+	plainETagHexLength = 32
+)
+
+func (m *Manager) putObject(
+	ctx context.Context,
+	target Target,
+	rule Rule,
+	key string,
+	info *filemgr.S3ObjectInfo,
+) error {
+	file, err := m.files.OpenFile(ctx, info.Link.FileId)
+	if err != nil {
+		return fmt.Errorf("open replication source: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	var body io.Reader = http.NoBody
+	if info.Link.FileSize > 0 {
+		// The transport closes request bodies; the deferred Close owns the file.
+		body = io.NopCloser(file)
+	}
+	request, err := m.newRequest(ctx, http.MethodPut, target, rule, key, body)
+	if err != nil {
+		return err
+	}
+	request.ContentLength = info.Link.FileSize
+	setReplicaHeaders(request.Header, info.Metadata)
+	return m.send(ctx, target, request, http.StatusOK)
+}
+
+func (m *Manager) deleteObject(ctx context.Context, target Target, rule Rule, key string) error {
+	request, err := m.newRequest(ctx, http.MethodDelete, target, rule, key, http.NoBody)
+	if err != nil {
+		return err
+	}
+	return m.send(ctx, target, request, http.StatusNoContent, http.StatusOK, http.StatusNotFound)
+}
+
+func (m *Manager) newRequest(
+	ctx context.Context,
+	method string,
+	target Target,
+	rule Rule,
+	key string,
+	body io.Reader,
+) (*http.Request, error) {
+	endpoint, err := url.Parse(target.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse replication endpoint: %w", err)
+	}
+	endpoint.Path = "/" + rule.TargetBucket + "/" + key
+	endpoint.RawPath = "/" + rule.TargetBucket + "/" + escapeObjectKey(key)
+	request, err := http.NewRequestWithContext(ctx, method, endpoint.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("build replication request: %w", err)
+	}
+	return request, nil
+}
+
+func (m *Manager) send(ctx context.Context, target Target, request *http.Request, accepted ...int) error {
+	request.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	if err := m.signer.SignHTTP(
+		ctx,
+		aws.Credentials{AccessKeyID: target.AccessKey, SecretAccessKey: target.SecretKey},
+		request,
+		unsignedPayload,
+		"s3",
+		target.Region,
+		m.now(),
+	); err != nil {
+		return fmt.Errorf("sign replication request: %w", err)
+	}
+	response, err := m.options.Client.Do(request)
+	if err != nil {
+		return fmt.Errorf("send replication request: %w", err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	for _, status := range accepted {
+		if response.StatusCode == status {
+			_, _ = io.Copy(io.Discard, response.Body)
+			return nil
+		}
+	}
+	detail, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBodyBytes))
+	return fmt.Errorf(
+		"%w: %s %s: %s: %s",
+		errTargetRejected,
+		request.Method,
+		request.URL.Redacted(),
+		response.Status,
+		strings.TrimSpace(string(detail)),
+	)
+}
+
+// setReplicaHeaders carries the stored object metadata to the target. A plain
+// ETag is the object's MD5 and a full-object checksum covers the whole body,
+// so both let the target verify the copied bytes and keep the same values.
+func setReplicaHeaders(header http.Header, metadata *entity.S3ObjectMetadata) {
+	for name, value := range map[string]string{
+		"Content-Type":        metadata.ContentType,
+		"Cache-Control":       metadata.CacheControl,
+		"Content-Disposition": metadata.ContentDisposition,
+		"Content-Encoding":    metadata.ContentEncoding,
+		"Content-Language":    metadata.ContentLanguage,
+		"Expires":             metadata.Expires,
+	} {
+		if value != "" {
+			header.Set(name, value)
+		}
+	}
+	var userMetadata map[string]string
+	if err := json.Unmarshal([]byte(metadata.UserMetadata), &userMetadata); err == nil {
+		for name, value := range userMetadata {
+			header.Set("X-Amz-Meta-"+name, value)
+		}
+	}
+	if digest := plainETagDigest(metadata.ETag); digest != nil {
+		header.Set("Content-MD5", base64.StdEncoding.EncodeToString(digest))
+	}
+	if metadata.RequestChecksumAlgorithm != "" && metadata.ChecksumType == string(s3checksum.TypeFullObject) {
+		name, err := s3checksum.HeaderName(s3checksum.Algorithm(metadata.RequestChecksumAlgorithm))
+		if err == nil {
+			header.Set(name, metadata.RequestChecksumValue)
+		}
+	}
+}
+
+func plainETagDigest(etag string) []byte {
+	value := strings.Trim(etag, `"`)
+	if len(value) != plainETagHexLength {
+		return nil
+	}
+	digest, err := hex.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+	return digest
+}
+
+// escapeObjectKey applies S3 URI encoding: every byte except unreserved
+// characters and the path separator is percent-encoded.
+func escapeObjectKey(key string) string {
+	var builder strings.Builder
+	for index := 0; index < len(key); index++ {
+		char := key[index]
+		switch {
+		case char >= 'A' && char <= 'Z', char >= 'a' && char <= 'z', char >= '0' && char <= '9',
+			char == '-', char == '_', char == '.', char == '~', char == '/':
+			builder.WriteByte(char)
+		default:
+			fmt.Fprintf(&builder, "%%%02X", char)
+		}
+	}
+	return builder.String()
+}
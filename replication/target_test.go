@@ -0,0 +1,52 @@
+package replication
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/entity"
+)
+
+func TestEscapeObjectKey(t *testing.T) {
+	require.Equal(t, "docs/a%20b%2Bc~_-.txt", escapeObjectKey("docs/a b+c~_-.txt"))
+	require.Equal(t, "%E6%96%87%E4%BB%B6", escapeObjectKey("文件"))
+}
+
+func TestSetReplicaHeaders(t *testing.T) {
+	header := http.Header{}
+	setReplicaHeaders(header, &entity.S3ObjectMetadata{
+		ETag:                     `"9e107d9d372bb6826bd81d3542a419d6"`,
+		RequestChecksumAlgorithm: "CRC32",
+		RequestChecksumValue:     "AAAAAA==",
+		ChecksumType:             "FULL_OBJECT",
+		ContentType:              "text/plain",
+		UserMetadata:             `{"team":"storage"}`,
+	})
+	require.Equal(t, "text/plain", header.Get("Content-Type"))
+	require.Equal(t, "storage", header.Get("X-Amz-Meta-Team"))
+	require.Equal(t, "nhB9nTcrtoJr2B01QqQZ1g==", header.Get("Content-MD5"))
+	require.Equal(t, "AAAAAA==", header.Get("x-amz-checksum-crc32"))
+	require.Empty(t, header.Get("Cache-Control"))
+
+	multipart := http.Header{}
+	setReplicaHeaders(multipart, &entity.S3ObjectMetadata{
+		ETag:                     `"9e107d9d372bb6826bd81d3542a419d6-2"`,
+		RequestChecksumAlgorithm: "CRC32",
+		RequestChecksumValue:     "AAAAAA==-2",
+		ChecksumType:             "COMPOSITE",
+	})
+	require.Empty(t, multipart.Get("Content-MD5"))
+	require.Empty(t, multipart.Get("x-amz-checksum-crc32"))
+}
+
+func TestBackoffDoublesUpToLimit(t *testing.T) {
+	manager := &Manager{options: Options{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}}
+	require.Equal(t, time.Second, manager.backoff(1))
+	require.Equal(t, 2*time.Second, manager.backoff(2))
+	require.Equal(t, 4*time.Second, manager.backoff(3))
+	require.Equal(t, 5*time.Second, manager.backoff(4))
+	require.Equal(t, 5*time.Second, manager.backoff(40))
+}
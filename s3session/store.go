@@ -0,0 +1,390 @@
+package s3session
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/authz"
+)
+
+var (
+	ErrInvalidRequest     = errors.New("invalid session credential request")
+	ErrCredentialNotFound = errors.New("session credential does not exist")
+)
+
+const (
+	DefaultDuration = time.Hour
+	MinDuration     = 15 * time.Minute
+	MaxDuration     = 36 * time.Hour
+
+	accessKeyPrefix   = "TGSA"
+	tokenPrefix       = "tgs1."
+	maxPrefixBytes    = 1024
+	expiredRetention  = 24 * time.Hour
+	sealDerivation    = "tgfile-s3-session-seal\x00"
+	randomAccessBytes = 10
+	randomTokenBytes  = 32
+	randomSecretBytes = 32
+)
+
+type IssueRequest struct {
+	Owner    string
+	Scope    authz.Scope
+	Duration time.Duration
+}
+
+// Credential is returned exactly once, when it is issued. The secret and the
+// session token cannot be recovered from the database afterwards.
+type Credential struct {
+	AccessKeyID     string    `json:"access_key_id"`
+	SecretAccessKey string    `json:"secret_access_key"`
+	SessionToken    string    `json:"session_token"`
+	Expiration      time.Time `json:"expiration"`
+	Bucket          string    `json:"bucket,omitempty"`
+	Prefix          string    `json:"prefix,omitempty"`
+	ReadOnly        bool      `json:"read_only"`
+}
+
+type Session struct {
+	AccessKeyID string    `json:"access_key_id"`
+	Owner       string    `json:"owner"`
+	Bucket      string    `json:"bucket,omitempty"`
+	Prefix      string    `json:"prefix,omitempty"`
+	ReadOnly    bool      `json:"read_only"`
+	CreatedAt   time.Time `json:"created_at"`
+	Expiration  time.Time `json:"expiration"`
+	Revoked     bool      `json:"revoked"`
+}
+
+func (s *Session) Scope() authz.Scope {
+	return authz.Scope{Bucket: s.Bucket, Prefix: s.Prefix, ReadOnly: s.ReadOnly}
+}
+
+type Store struct {
+	db  database.IDatabase
+	now func() time.Time
+}
+
+func New(db database.IDatabase) *Store {
+	return &Store{db: db, now: time.Now}
+}
+
+func (s *Store) Issue(ctx context.Context, request IssueRequest) (*Credential, error) {
+	if err := validateIssueRequest(&request); err != nil {
+		return nil, err
+	}
+	accessKeyID, err := randomAccessKeyID()
+	if err != nil {
+		return nil, err
+	}
+	token, err := randomSessionToken()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealSecret(accessKeyID, token, secret)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	expiration := now.Add(request.Duration)
+	if _, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM tg_s3_session_credential_tab WHERE expires_at < ?`,
+		now.Add(-expiredRetention).UnixMilli(),
+	); err != nil {
+		return nil, fmt.Errorf("purge expired session credentials: %w", err)
+	}
+	if _, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO tg_s3_session_credential_tab (
+    access_key_id, owner, token_sha256, sealed_secret, scope_bucket, scope_prefix, read_only, created_at, expires_at
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		accessKeyID,
+		request.Owner,
+		tokenDigest(token),
+		sealed,
+		request.Scope.Bucket,
+		request.Scope.Prefix,
+		boolInt(request.Scope.ReadOnly),
+		now.UnixMilli(),
+		expiration.UnixMilli(),
+	); err != nil {
+		return nil, fmt.Errorf("insert session credential: %w", err)
+	}
+	return &Credential{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secret,
+		SessionToken:    token,
+		Expiration:      time.UnixMilli(expiration.UnixMilli()).UTC(),
+		Bucket:          request.Scope.Bucket,
+		Prefix:          request.Scope.Prefix,
+		ReadOnly:        request.Scope.ReadOnly,
+	}, nil
+}
+
+// Lookup resolves a live credential and returns its secret. Unknown, expired,
+// revoked and token-mismatched credentials all report false, as do credentials
+// issued before secrets were sealed.
+func (s *Store) Lookup(ctx context.Context, accessKeyID, token string) (*Session, string, bool, error) {
+	if !strings.HasPrefix(accessKeyID, accessKeyPrefix) || !strings.HasPrefix(token, tokenPrefix) {
+		return nil, "", false, nil
+	}
+	session, stored, err := s.read(ctx, accessKeyID)
+	if errors.Is(err, ErrCredentialNotFound) {
+		return nil, "", false, nil
+	}
+	if err != nil {
+		return nil, "", false, err
+	}
+	if subtle.ConstantTimeCompare([]byte(stored.digest), []byte(tokenDigest(token))) != 1 {
+		return nil, "", false, nil
+	}
+	if session.Revoked || !s.now().Before(session.Expiration) {
+		return nil, "", false, nil
+	}
+	secret, ok := openSecret(accessKeyID, token, stored.sealed)
+	if !ok {
+		return nil, "", false, nil
+	}
+	return session, secret, true, nil
+}
+
+func (s *Store) List(ctx context.Context, owner string) ([]*Session, error) {
+	query := `SELECT access_key_id, owner, scope_bucket, scope_prefix, read_only, created_at, expires_at, revoked_at
+FROM tg_s3_session_credential_tab`
+	args := []any{}
+	if owner != "" {
+		query += ` WHERE owner = ?`
+		args = append(args, owner)
+	}
+	query += ` ORDER BY created_at DESC, access_key_id`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query session credentials: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	sessions := make([]*Session, 0)
+	for rows.Next() {
+		session, _, err := scanSession(rows, false)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate session credentials: %w", err)
+	}
+	return sessions, nil
+}
+
+// Revoke invalidates a credential immediately. An empty owner revokes a
+// credential of any principal and is reserved for offline administration.
+func (s *Store) Revoke(ctx context.Context, owner, accessKeyID string) error {
+	query := `UPDATE tg_s3_session_credential_tab SET revoked_at = ?
+WHERE access_key_id = ? AND revoked_at = 0`
+	args := []any{s.now().UnixMilli(), accessKeyID}
+	if owner != "" {
+		query += ` AND owner = ?`
+		args = append(args, owner)
+	}
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("revoke session credential: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("read revoked session credential count: %w", err)
+	}
+	if affected != 0 {
+		return nil
+	}
+	session, _, err := s.read(ctx, accessKeyID)
+	if err != nil {
+		return err
+	}
+	if owner != "" && session.Owner != owner {
+		return ErrCredentialNotFound
+	}
+	return nil
+}
+
+// storedSecret holds the columns that authenticate a credential.
+type storedSecret struct {
+	digest string
+	sealed string
+}
+
+func (s *Store) read(ctx context.Context, accessKeyID string) (*Session, storedSecret, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT access_key_id, owner, scope_bucket, scope_prefix, read_only, created_at, expires_at, revoked_at,
+token_sha256, sealed_secret
+FROM tg_s3_session_credential_tab WHERE access_key_id = ?`,
+		accessKeyID,
+	)
+	if err != nil {
+		return nil, storedSecret{}, fmt.Errorf("query session credential: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, storedSecret{}, fmt.Errorf("read session credential: %w", err)
+		}
+		return nil, storedSecret{}, ErrCredentialNotFound
+	}
+	return scanSession(rows, true)
+}
+
+func scanSession(rows *sql.Rows, withSecret bool) (*Session, storedSecret, error) {
+	var (
+		session                         Session
+		readOnly                        int
+		createdAt, expiresAt, revokedAt int64
+		stored                          storedSecret
+	)
+	dest := []any{
+		&session.AccessKeyID,
+		&session.Owner,
+		&session.Bucket,
+		&session.Prefix,
+		&readOnly,
+		&createdAt,
+		&expiresAt,
+		&revokedAt,
+	}
+	if withSecret {
+		dest = append(dest, &stored.digest, &stored.sealed)
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, storedSecret{}, fmt.Errorf("scan session credential: %w", err)
+	}
+	session.ReadOnly = readOnly != 0
+	session.CreatedAt = time.UnixMilli(createdAt).UTC()
+	session.Expiration = time.UnixMilli(expiresAt).UTC()
+	session.Revoked = revokedAt != 0
+	return &session, stored, nil
+}
+
+func validateIssueRequest(request *IssueRequest) error {
+	if request.Owner == "" {
+		return fmt.Errorf("%w: owner is required", ErrInvalidRequest)
+	}
+	if request.Duration == 0 {
+		request.Duration = DefaultDuration
+	}
+	if request.Duration < MinDuration || request.Duration > MaxDuration {
+		return fmt.Errorf("%w: duration must be between %s and %s", ErrInvalidRequest, MinDuration, MaxDuration)
+	}
+	if request.Scope.Prefix != "" && request.Scope.Bucket == "" {
+		return fmt.Errorf("%w: prefix requires a bucket", ErrInvalidRequest)
+	}
+	if len(request.Scope.Prefix) > maxPrefixBytes || strings.ContainsRune(request.Scope.Prefix, 0) {
+		return fmt.Errorf("%w: prefix is invalid", ErrInvalidRequest)
+	}
+	return nil
+}
+
+func randomAccessKeyID() (string, error) {
+	raw := make([]byte, randomAccessBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate session access key: %w", err)
+	}
+	return accessKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw), nil
+}
+
+func randomSessionToken() (string, error) {
+	raw := make([]byte, randomTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate session token: %w", err)
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func tokenDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomSecret() (string, error) {
+	raw := make([]byte, randomSecretBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate session secret: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// sealSecret encrypts the random secret under a key derived from the session
+// token. The token travels with every request, but it only opens the sealed
+// value stored in the database; neither alone yields the secret.
+func sealSecret(accessKeyID, token, secret string) (string, error) {
+	aead, err := sealCipher(accessKeyID, token)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generate session secret nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(secret), []byte(accessKeyID))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func openSecret(accessKeyID, token, sealed string) (string, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || sealed == "" {
+		return "", false
+	}
+	aead, err := sealCipher(accessKeyID, token)
+	if err != nil || len(raw) < aead.NonceSize() {
+		return "", false
+	}
+	secret, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], []byte(accessKeyID))
+	if err != nil {
+		return "", false
+	}
+	return string(secret), true
+}
+
+func sealCipher(accessKeyID, token string) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(sealDerivation + accessKeyID))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("create session secret cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create session secret cipher: %w", err)
+	}
+	return aead, nil
+}
+
+func boolInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,113 @@
+package s3session
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/db"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	database, err := db.Open(filepath.Join(t.TempDir(), "data.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, database.Close())
+	})
+	return New(database)
+}
+
+func TestIssueLookupAndRevoke(t *testing.T) {
+	store := newTestStore(t)
+	credential, err := store.Issue(t.Context(), IssueRequest{
+		Owner: "access",
+		Scope: authz.Scope{Bucket: "data", Prefix: "ci/", ReadOnly: true},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, credential.SecretAccessKey)
+
+	rows, err := store.db.QueryContext(
+		t.Context(),
+		`SELECT access_key_id FROM tg_s3_session_credential_tab
+WHERE token_sha256 IN (?, ?) OR sealed_secret IN (?, ?)`,
+		credential.SessionToken,
+		credential.SecretAccessKey,
+		credential.SessionToken,
+		credential.SecretAccessKey,
+	)
+	require.NoError(t, err)
+	require.False(t, rows.Next())
+	require.NoError(t, rows.Close())
+
+	session, secret, ok, err := store.Lookup(t.Context(), credential.AccessKeyID, credential.SessionToken)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, credential.SecretAccessKey, secret)
+	require.Equal(t, "access", session.Owner)
+	require.Equal(t, authz.Scope{Bucket: "data", Prefix: "ci/", ReadOnly: true}, session.Scope())
+
+	_, _, ok, err = store.Lookup(t.Context(), credential.AccessKeyID, credential.SessionToken+"x")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.ErrorIs(t, store.Revoke(t.Context(), "other", credential.AccessKeyID), ErrCredentialNotFound)
+	require.NoError(t, store.Revoke(t.Context(), "access", credential.AccessKeyID))
+	require.NoError(t, store.Revoke(t.Context(), "access", credential.AccessKeyID))
+	_, _, ok, err = store.Lookup(t.Context(), credential.AccessKeyID, credential.SessionToken)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	sessions, err := store.List(t.Context(), "access")
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.True(t, sessions[0].Revoked)
+}
+
+func TestCredentialWithoutSealedSecretIsRejected(t *testing.T) {
+	store := newTestStore(t)
+	first, err := store.Issue(t.Context(), IssueRequest{Owner: "access"})
+	require.NoError(t, err)
+	_, _, ok, err := store.Lookup(t.Context(), first.AccessKeyID, first.SessionToken)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// Credentials issued before secrets were sealed derived them from the token.
+	_, err = store.db.ExecContext(
+		t.Context(),
+		`UPDATE tg_s3_session_credential_tab SET sealed_secret = '' WHERE access_key_id = ?`,
+		first.AccessKeyID,
+	)
+	require.NoError(t, err)
+	_, _, ok, err = store.Lookup(t.Context(), first.AccessKeyID, first.SessionToken)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestExpiredCredentialIsRejected(t *testing.T) {
+	store := newTestStore(t)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	credential, err := store.Issue(t.Context(), IssueRequest{Owner: "access", Duration: MinDuration})
+	require.NoError(t, err)
+	store.now = func() time.Time { return now.Add(MinDuration) }
+	_, _, ok, err := store.Lookup(t.Context(), credential.AccessKeyID, credential.SessionToken)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestIssueRejectsInvalidRequest(t *testing.T) {
+	store := newTestStore(t)
+	for _, request := range []IssueRequest{
+		{},
+		{Owner: "access", Duration: time.Minute},
+		{Owner: "access", Duration: MaxDuration + time.Second},
+		{Owner: "access", Scope: authz.Scope{Prefix: "ci/"}},
+	} {
+		_, err := store.Issue(t.Context(), request)
+		require.ErrorIs(t, err, ErrInvalidRequest)
+	}
+}
@@ -0,0 +1,125 @@
+package server_test
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/accesslog"
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/server"
+)
+
+func newAccessLogEnvironment(t *testing.T) *integrationEnvironment {
+	t.Helper()
+	var worker *accesslog.Manager
+	environment := newIntegrationEnvironmentWith(t, func(
+		db database.IDatabase,
+		manager filemgr.IFileManager,
+	) []server.Option {
+		var err error
+		worker, err = accesslog.New(t.Context(), db, manager, accesslog.Options{
+			Buckets:       []string{"hackmd", "private-data"},
+			FlushInterval: 20 * time.Millisecond,
+		})
+		require.NoError(t, err)
+		return []server.Option{server.WithAccessLog(worker)}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- worker.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		require.ErrorIs(t, <-done, context.Canceled)
+	})
+	return environment
+}
+
+func putBucketLogging(t *testing.T, client *http.Client, bucketURL, body string) (int, string) {
+	t.Helper()
+	response, err := client.Do(authenticatedRequest(t, http.MethodPut, bucketURL+"?logging", strings.NewReader(body)))
+	require.NoError(t, err)
+	return response.StatusCode, string(readResponse(t, response))
+}
+
+// readAccessLogs concatenates every log object under prefix in private-data.
+func readAccessLogs(t *testing.T, client *http.Client, baseURL, prefix string) string {
+	t.Helper()
+	response, err := client.Do(authenticatedRequest(
+		t, http.MethodGet, baseURL+"/private-data?list-type=2&prefix="+prefix, nil,
+	))
+	require.NoError(t, err)
+	var result struct {
+		Keys []string `xml:"Contents>Key"`
+	}
+	require.NoError(t, xml.Unmarshal(readResponse(t, response), &result))
+	var builder strings.Builder
+	for _, key := range result.Keys {
+		builder.Write(getPrivateObject(t, client, baseURL, key))
+	}
+	return builder.String()
+}
+
+func findAccessLogLine(logs, operation, key string) []string {
+	for _, line := range strings.Split(logs, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 9 && fields[7] == operation && fields[8] == key {
+			return fields
+		}
+	}
+	return nil
+}
+
+func TestS3AccessLogging(t *testing.T) {
+	environment := newAccessLogEnvironment(t)
+	client := environment.server.Client()
+	bucketURL := environment.server.URL + "/hackmd"
+
+	response, err := client.Do(authenticatedRequest(t, http.MethodGet, bucketURL+"?logging", nil))
+	require.NoError(t, err)
+	require.NotContains(t, string(readResponse(t, response)), "LoggingEnabled")
+	status, body := putBucketLogging(t, client, bucketURL, `<BucketLoggingStatus><LoggingEnabled>`+
+		`<TargetBucket>missing</TargetBucket><TargetPrefix>logs/</TargetPrefix></LoggingEnabled></BucketLoggingStatus>`)
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Contains(t, body, "InvalidTargetBucketForLogging")
+	status, body = putBucketLogging(t, client, bucketURL, `<BucketLoggingStatus><LoggingEnabled>`+
+		`<TargetBucket>private-data</TargetBucket><TargetPrefix>logs/</TargetPrefix></LoggingEnabled></BucketLoggingStatus>`)
+	require.Equal(t, http.StatusOK, status, body)
+	response, err = client.Do(authenticatedRequest(t, http.MethodGet, bucketURL+"?logging", nil))
+	require.NoError(t, err)
+	require.Contains(t, string(readResponse(t, response)), "<TargetBucket>private-data</TargetBucket>")
+
+	require.Equal(t, http.StatusOK, putWithStorageClass(t, client, bucketURL+"/reports/q 1.txt", "", []byte("quarter")))
+	assertObjectContent(t, client, bucketURL+"/reports/q%201.txt", []byte("quarter"))
+	response, err = client.Do(authenticatedRequest(t, http.MethodGet, bucketURL+"/missing.txt", nil))
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	var logs string
+	require.Eventually(t, func() bool {
+		logs = readAccessLogs(t, client, environment.server.URL, "logs/")
+		return findAccessLogLine(logs, "REST.GET.OBJECT", "missing.txt") != nil
+	}, 5*time.Second, 20*time.Millisecond)
+	put := findAccessLogLine(logs, "REST.PUT.OBJECT", "reports/q+1.txt")
+	require.NotNil(t, put, logs)
+	require.Equal(t, []string{"tgfile", "hackmd"}, put[:2])
+	require.Equal(t, "access", put[5])
+	get := findAccessLogLine(logs, "REST.GET.OBJECT", "reports/q+1.txt")
+	require.NotNil(t, get, logs)
+	require.Contains(t, strings.Join(get, " "), `"GET /hackmd/reports/q%201.txt HTTP/1.1" 200 - 7 7 `)
+	missing := findAccessLogLine(logs, "REST.GET.OBJECT", "missing.txt")
+	require.Equal(t, []string{"404", "NoSuchKey"}, missing[12:14])
+
+	status, body = putBucketLogging(t, client, bucketURL, `<BucketLoggingStatus/>`)
+	require.Equal(t, http.StatusOK, status, body)
+	response, err = client.Do(authenticatedRequest(t, http.MethodGet, bucketURL+"?logging", nil))
+	require.NoError(t, err)
+	require.NotContains(t, string(readResponse(t, response)), "LoggingEnabled")
+}
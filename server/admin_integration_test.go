@@ -25,6 +25,7 @@ import (
 	"github.com/xxxsen/tgfile/db"
 	"github.com/xxxsen/tgfile/filemgr"
 	"github.com/xxxsen/tgfile/server"
+	"github.com/xxxsen/tgfile/sharelink"
 )
 
 const (
@@ -476,9 +477,15 @@ func TestAdminFileAndBackupWorkflowWithRoleIsolation(t *testing.T) {
 type adminTestEnvironment struct {
 	handler http.Handler
 	manager *backupmgr.Manager
+	files   filemgr.IFileManager
 }
 
 func newAdminTestEnvironment(t *testing.T) adminTestEnvironment {
+	t.Helper()
+	return newAdminTestEnvironmentWithStorage(t, nil)
+}
+
+func newAdminTestEnvironmentWithStorage(t *testing.T, storage []filemgr.Option) adminTestEnvironment {
 	t.Helper()
 	databaseClient, err := db.Open(filepath.Join(t.TempDir(), "data.db"))
 	require.NoError(t, err)
@@ -491,7 +498,7 @@ func newAdminTestEnvironment(t *testing.T) adminTestEnvironment {
 	})
 	require.NoError(t, err)
 	registerIntegrationCacheCleanup(t, cache)
-	files := filemgr.NewFileManager(databaseClient, block, cache)
+	files := filemgr.NewFileManager(databaseClient, block, cache, storage...)
 	require.NoError(t, files.CreateFileLink(t.Context(), "/uploads", 0, 0, true))
 	manager, err := backupmgr.New(databaseClient, files, backupmgr.Options{
 		WorkDir: filepath.Join(t.TempDir(), "backup-work"),
@@ -515,6 +522,7 @@ func newAdminTestEnvironment(t *testing.T) adminTestEnvironment {
 			"backuponly": {string(authz.BackupWrite)},
 		})),
 		server.WithFileManager(files),
+		server.WithShareLinks(sharelink.New(databaseClient, files)),
 		server.WithBackup(server.BackupOptions{Enabled: false}, manager),
 		server.WithAdmin(server.AdminOptions{
 			Enabled: true,
@@ -527,7 +535,7 @@ func newAdminTestEnvironment(t *testing.T) adminTestEnvironment {
 		}),
 	)
 	require.NoError(t, err)
-	return adminTestEnvironment{handler: handler, manager: manager}
+	return adminTestEnvironment{handler: handler, manager: manager, files: files}
 }
 
 func serveAdminRequest(
@@ -0,0 +1,188 @@
+package server_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/archive"
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/server"
+)
+
+func uploadDirectFile(t *testing.T, environment *integrationEnvironment, name string, content []byte) string {
+	t.Helper()
+	return uploadDirectFileAs(t, environment, "access", "secret", name, content)
+}
+
+func uploadDirectFileAs(
+	t *testing.T,
+	environment *integrationEnvironment,
+	username, password, name string,
+	content []byte,
+) string {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", name)
+	require.NoError(t, err)
+	_, err = part.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	request := authenticatedRequest(t, http.MethodPost, environment.server.URL+"/file/upload", &body)
+	request.SetBasicAuth(username, password)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	response, err := environment.server.Client().Do(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	var uploadResponse struct {
+		Data struct {
+			Key string `json:"key"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(readResponse(t, response), &uploadResponse))
+	return uploadResponse.Data.Key
+}
+
+func readZIPArchive(t *testing.T, raw []byte) map[string]string {
+	t.Helper()
+	reader, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+	require.NoError(t, err)
+	members := map[string]string{}
+	for _, member := range reader.File {
+		stream, err := member.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(stream)
+		require.NoError(t, err)
+		require.NoError(t, stream.Close())
+		members[member.Name] = string(content)
+	}
+	return members
+}
+
+func readTARArchive(t *testing.T, raw []byte) map[string]string {
+	t.Helper()
+	reader := tar.NewReader(bytes.NewReader(raw))
+	members := map[string]string{}
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return members
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		members[header.Name] = string(content)
+	}
+}
+
+func TestWebDAVAndFileAPIArchives(t *testing.T) {
+	environment := newIntegrationEnvironmentWithStorage(t, nil, func(database.IDatabase, filemgr.IFileManager) []server.Option {
+		return []server.Option{server.WithArchive(archive.Limits{MaxEntries: 5})}
+	})
+	client := environment.server.Client()
+	for name, content := range map[string]string{"docs/a.txt": "alpha", "docs/sub/b.txt": "bravo"} {
+		response, err := client.Do(authenticatedRequest(
+			t, http.MethodPut, environment.server.URL+"/hackmd/"+name, bytes.NewBufferString(content),
+		))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		_ = readResponse(t, response)
+	}
+
+	collection := environment.server.URL + "/webdav/hackmd/docs/"
+	response, err := client.Do(authenticatedRequest(t, http.MethodGet, collection+"?archive=zip", nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "application/zip", response.Header.Get("Content-Type"))
+	require.Equal(t, `attachment; filename=docs.zip`, response.Header.Get("Content-Disposition"))
+	require.Equal(t, map[string]string{
+		"docs/": "", "docs/a.txt": "alpha", "docs/sub/": "", "docs/sub/b.txt": "bravo",
+	}, readZIPArchive(t, readResponse(t, response)))
+
+	response, err = client.Do(authenticatedRequest(t, http.MethodGet, collection+"?archive=tar", nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "application/x-tar", response.Header.Get("Content-Type"))
+	require.Equal(t, "bravo", readTARArchive(t, readResponse(t, response))["docs/sub/b.txt"])
+
+	for target, status := range map[string]int{
+		collection:                  http.StatusMethodNotAllowed,
+		collection + "?archive=rar": http.StatusBadRequest,
+	} {
+		response, err = client.Do(authenticatedRequest(t, http.MethodGet, target, nil))
+		require.NoError(t, err)
+		require.Equal(t, status, response.StatusCode, target)
+		_ = readResponse(t, response)
+	}
+	for _, name := range []string{"c.txt", "d.txt"} {
+		response, err = client.Do(authenticatedRequest(
+			t, http.MethodPut, environment.server.URL+"/hackmd/docs/"+name, bytes.NewBufferString(name),
+		))
+		require.NoError(t, err)
+		_ = readResponse(t, response)
+	}
+	response, err = client.Do(authenticatedRequest(t, http.MethodGet, collection+"?archive=zip", nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	_ = readResponse(t, response)
+
+	first := uploadDirectFile(t, environment, "report.txt", []byte("first"))
+	second := uploadDirectFile(t, environment, "report.txt", []byte("second"))
+	query := url.Values{"key": {first, second}, "format": {"zip"}}
+	response, err = getResponse(t, client, environment.server.URL+"/file/archive?"+query.Encode())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, map[string]string{"report.txt": "first", second: "second"},
+		readZIPArchive(t, readResponse(t, response)))
+	for _, target := range []string{"/file/archive", "/file/archive?key=bad", "/file/archive?format=rar&key=" + first} {
+		response, err = getResponse(t, client, environment.server.URL+target)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, response.StatusCode, target)
+		_ = readResponse(t, response)
+	}
+}
+
+func TestAdminArchive(t *testing.T) {
+	environment := newAdminTestEnvironment(t)
+	require.NoError(t, environment.files.CreateFileLink(t.Context(), "/uploads/deep", 0, 0, true))
+	testServer := httptest.NewServer(environment.handler)
+	defer testServer.Close()
+	viewerClient := adminHTTPClient(t)
+	viewer := loginAdmin(t, viewerClient, testServer.URL, "viewer", "view-secret")
+	operatorClient := adminHTTPClient(t)
+	operator := loginAdmin(t, operatorClient, testServer.URL, "operator", "write-secret")
+	uploadAdminFile(t, operatorClient, testServer.URL, operator, "/uploads/one.txt", []byte("one"), "*", http.StatusCreated)
+	uploadAdminFile(t, operatorClient, testServer.URL, operator, "/uploads/deep/two.txt", []byte("two"), "*", http.StatusCreated)
+
+	archiveAPI := testServer.URL + "/_admin/api/v1/archive?"
+	response := doAdminRequest(t, viewerClient, http.MethodGet,
+		archiveAPI+url.Values{"path": {"/uploads"}, "format": {"tar"}}.Encode(), nil, viewer, nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "application/x-tar", response.Header.Get("Content-Type"))
+	require.Equal(t, map[string]string{
+		"uploads/": "", "uploads/deep/": "", "uploads/deep/two.txt": "two", "uploads/one.txt": "one",
+	}, readTARArchive(t, readResponse(t, response)))
+
+	for target, status := range map[string]int{
+		"path=/uploads/one.txt":        http.StatusConflict,
+		"path=/uploads&format=rar":     http.StatusBadRequest,
+		"path=/missing":                http.StatusNotFound,
+		"path=/uploads&unknown=option": http.StatusBadRequest,
+	} {
+		response = doAdminRequest(t, viewerClient, http.MethodGet, archiveAPI+target, nil, viewer, nil)
+		require.Equal(t, status, response.StatusCode, target)
+		closeResponse(t, response)
+	}
+}
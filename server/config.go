@@ -3,9 +3,18 @@ package server
 import (
 	"time"
 
+	"github.com/xxxsen/tgfile/accesslog"
+	"github.com/xxxsen/tgfile/archive"
 	"github.com/xxxsen/tgfile/authz"
 	"github.com/xxxsen/tgfile/backupmgr"
+	"github.com/xxxsen/tgfile/fetchmgr"
 	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/inventory"
+	"github.com/xxxsen/tgfile/lifecycle"
+	"github.com/xxxsen/tgfile/replication"
+	"github.com/xxxsen/tgfile/s3session"
+	"github.com/xxxsen/tgfile/sharelink"
+	"github.com/xxxsen/tgfile/uploadsession"
 )
 
 type config struct {
@@ -16,7 +25,18 @@ type config struct {
 	backup        BackupOptions
 	backupManager *backupmgr.Manager
 	admin         AdminOptions
+	archive       archive.Limits
+	fileUpload    FileUploadOptions
 	fmgr          filemgr.IFileManager
+	sessions      *s3session.Store
+	shareLinks    *sharelink.Store
+	uploads       *uploadsession.Store
+	webdavUploads *uploadsession.Store
+	fetches       *fetchmgr.Manager
+	replication   *replication.Manager
+	lifecycle     *lifecycle.Manager
+	inventory     *inventory.Manager
+	accessLog     *accesslog.Manager
 }
 
 type Option func(c *config)
@@ -38,6 +58,7 @@ type S3Options struct {
 	Buckets              []S3BucketOptions
 	MaxObjectSize        int64
 	MultipartExpireHours int
+	BaseDomain           string
 }
 
 type WebDAVOptions struct {
@@ -49,6 +70,20 @@ type WebDAVOptions struct {
 	QuotaBytes         int64
 	MaxMutationEntries int
 	SyncPageSize       int
+	// Mounts, when non-empty, serves named shares below /webdav instead of
+	// mapping /webdav onto Root.
+	Mounts []WebDAVMountOptions
+	// Groups maps a group name to its members for WebDAV ACL entries.
+	Groups map[string][]string
+}
+
+// WebDAVMountOptions is one share mounted at /webdav/<Name>/. Root may
+// contain {user} to give every principal a separate home tree.
+type WebDAVMountOptions struct {
+	Name       string
+	Root       string
+	ReadOnly   bool
+	QuotaBytes int64
 }
 
 type BackupOptions struct {
@@ -65,6 +100,13 @@ type AdminOptions struct {
 	MaxMutationEntries int
 }
 
+// FileUploadOptions bounds the raw-body uploads at /file/upload/{name}.
+// Bodies without Content-Length are spooled to TempDir.
+type FileUploadOptions struct {
+	MaxUploadSize int64
+	TempDir       string
+}
+
 func WithS3(options S3Options) Option {
 	return func(c *config) {
 		c.s3 = options
@@ -117,6 +159,83 @@ func WithBackup(options BackupOptions, manager *backupmgr.Manager) Option {
 	}
 }
 
+// WithS3Sessions enables temporary S3 credentials backed by store.
+func WithS3Sessions(store *s3session.Store) Option {
+	return func(c *config) {
+		c.sessions = store
+	}
+}
+
+// WithShareLinks serves the share links of store at /s/ and enables the
+// share link API.
+func WithShareLinks(store *sharelink.Store) Option {
+	return func(c *config) {
+		c.shareLinks = store
+	}
+}
+
+// WithUploadSessions serves the resumable uploads of store over the tus
+// protocol at /file/tus/.
+func WithUploadSessions(store *uploadsession.Store) Option {
+	return func(c *config) {
+		c.uploads = store
+	}
+}
+
+// WithWebDAVUploadSessions keeps the resumable Content-Range PUTs of the
+// WebDAV endpoint in store, whose origin is uploadsession.OriginWebDAV.
+func WithWebDAVUploadSessions(store *uploadsession.Store) Option {
+	return func(c *config) {
+		c.webdavUploads = store
+	}
+}
+
+// WithFetch serves the remote URL fetch jobs of manager at /fetch/v1/ and
+// lists them in the admin UI.
+func WithFetch(manager *fetchmgr.Manager) Option {
+	return func(c *config) {
+		c.fetches = manager
+	}
+}
+
+func WithReplication(manager *replication.Manager) Option {
+	return func(c *config) {
+		c.replication = manager
+	}
+}
+
+func WithLifecycle(manager *lifecycle.Manager) Option {
+	return func(c *config) {
+		c.lifecycle = manager
+	}
+}
+
+func WithInventory(manager *inventory.Manager) Option {
+	return func(c *config) {
+		c.inventory = manager
+	}
+}
+
+func WithAccessLog(manager *accesslog.Manager) Option {
+	return func(c *config) {
+		c.accessLog = manager
+	}
+}
+
+// WithArchive bounds the ZIP and TAR downloads of the file API, the admin API
+// and WebDAV. Unset limits take the archive package defaults.
+func WithArchive(limits archive.Limits) Option {
+	return func(c *config) {
+		c.archive = limits
+	}
+}
+
+func WithFileUpload(options FileUploadOptions) Option {
+	return func(c *config) {
+		c.fileUpload = options
+	}
+}
+
 func WithAdmin(options AdminOptions) Option {
 	return func(c *config) {
 		c.admin = options
@@ -0,0 +1,64 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSniffedContentTypeIsServedByEveryProtocol(t *testing.T) {
+	environment := newIntegrationEnvironment(t)
+	client := environment.server.Client()
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	key := uploadDirectFile(t, environment, "scan", png)
+	response, err := getResponse(t, client, environment.server.URL+"/file/download/"+key)
+	require.NoError(t, err)
+	require.Equal(t, "image/png", response.Header.Get("Content-Type"))
+	require.Equal(t, png, readResponse(t, response))
+	response, err = getResponse(t, client, environment.server.URL+"/file/meta/"+key)
+	require.NoError(t, err)
+	var meta struct {
+		Data struct {
+			Item struct {
+				ContentType string `json:"content_type"`
+			} `json:"item"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(readResponse(t, response), &meta))
+	require.Equal(t, "image/png", meta.Data.Item.ContentType)
+
+	requireWebDAVStatus(t, doWebDAVRequest(t, client, "access", "secret", http.MethodPut,
+		environment.server.URL+"/webdav/picture", bytes.NewReader(png), nil,
+	), http.StatusCreated)
+	got := doWebDAVRequest(t, client, "access", "secret", http.MethodGet,
+		environment.server.URL+"/webdav/picture", nil, nil)
+	require.Equal(t, http.StatusOK, got.StatusCode)
+	require.Equal(t, "image/png", got.Header.Get("Content-Type"))
+	raw := requireWebDAVStatus(t, doWebDAVRequest(t, client, "access", "secret", "PROPFIND",
+		environment.server.URL+"/webdav/picture", nil, map[string]string{"Depth": "0"},
+	), http.StatusMultiStatus)
+	var properties struct {
+		ContentType string `xml:"response>propstat>prop>getcontenttype"`
+	}
+	require.NoError(t, xml.Unmarshal(raw, &properties))
+	require.Equal(t, "image/png", properties.ContentType)
+
+	// An S3 upload without a Content-Type stores the sniffed type.
+	response, err = client.Do(authenticatedRequest(
+		t, http.MethodPut, environment.server.URL+"/hackmd/images/raw", bytes.NewReader(png),
+	))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	_ = readResponse(t, response)
+	response, err = client.Do(authenticatedRequest(
+		t, http.MethodGet, environment.server.URL+"/hackmd/images/raw", nil,
+	))
+	require.NoError(t, err)
+	require.Equal(t, "image/png", response.Header.Get("Content-Type"))
+	require.Equal(t, png, readResponse(t, response))
+}
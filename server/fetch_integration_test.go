@@ -0,0 +1,126 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/fetchmgr"
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/server"
+)
+
+func TestFetchJobAPIStoresRemoteFiles(t *testing.T) {
+	content := []byte("remote body")
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write(content)
+	}))
+	defer source.Close()
+	var manager *fetchmgr.Manager
+	environment := newIntegrationEnvironmentWith(
+		t,
+		func(databaseClient database.IDatabase, files filemgr.IFileManager) []server.Option {
+			var err error
+			manager, err = fetchmgr.New(databaseClient, files, fetchmgr.Options{
+				WorkDir:              filepath.Join(t.TempDir(), "fetch"),
+				MaxSize:              1024,
+				RetryBackoff:         time.Millisecond,
+				AllowPrivateNetworks: true,
+				S3Buckets:            []string{"hackmd", "private-data"},
+			})
+			require.NoError(t, err)
+			return []server.Option{server.WithFetch(manager)}
+		},
+	)
+	createJob := func(username, password, key string, target map[string]string) (int, *fetchmgr.Job) {
+		body, err := json.Marshal(map[string]any{"source_url": source.URL + "/notes.txt", "target": target})
+		require.NoError(t, err)
+		request := authenticatedRequest(t, http.MethodPost, environment.server.URL+"/fetch/v1/jobs", bytes.NewReader(body))
+		request.SetBasicAuth(username, password)
+		request.Header.Set("Idempotency-Key", key)
+		response, err := environment.server.Client().Do(request)
+		require.NoError(t, err)
+		raw := readResponse(t, response)
+		if response.StatusCode != http.StatusAccepted {
+			return response.StatusCode, nil
+		}
+		var job fetchmgr.Job
+		require.NoError(t, json.Unmarshal(raw, &job))
+		return response.StatusCode, &job
+	}
+
+	status, _ := createJob("fileonly", "file-secret", "s3", map[string]string{
+		"kind": "s3", "bucket": "hackmd", "key": "notes.txt",
+	})
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = createJob("fileonly", "file-secret", "webdav", map[string]string{"kind": "webdav", "path": "/notes.txt"})
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = createJob("reader", "reader-secret", "file", map[string]string{"kind": "file", "name": "notes.txt"})
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = createJob("access", "secret", "bad", map[string]string{"kind": "webdav", "path": "/"})
+	require.Equal(t, http.StatusBadRequest, status)
+
+	status, job := createJob("fileonly", "file-secret", "file", map[string]string{"kind": "file", "name": "notes.txt"})
+	require.Equal(t, http.StatusAccepted, status)
+	require.NotEmpty(t, job.FileKey)
+	processed, err := manager.ProcessUntilTerminal(t.Context(), job.JobID)
+	require.NoError(t, err)
+	require.Equal(t, "succeeded", processed.State)
+	response, err := getResponse(t, environment.server.Client(), environment.server.URL+"/file/download/"+job.FileKey)
+	require.NoError(t, err)
+	require.Equal(t, content, readResponse(t, response))
+
+	status, webdavJob := createJob(
+		"access", "secret", "webdav", map[string]string{"kind": "webdav", "path": "/notes.txt"},
+	)
+	require.Equal(t, http.StatusAccepted, status)
+	_, err = manager.ProcessUntilTerminal(t.Context(), webdavJob.JobID)
+	require.NoError(t, err)
+	response, err = environment.server.Client().Do(
+		authenticatedRequest(t, http.MethodGet, environment.server.URL+"/webdav/notes.txt", nil),
+	)
+	require.NoError(t, err)
+	require.Equal(t, content, readResponse(t, response))
+
+	// Jobs of other principals are not visible through the fetch API.
+	request := authenticatedRequest(t, http.MethodGet, environment.server.URL+"/fetch/v1/jobs/"+job.JobID, nil)
+	response, err = environment.server.Client().Do(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+	readResponse(t, response)
+	request.SetBasicAuth("fileonly", "file-secret")
+	response, err = environment.server.Client().Do(request)
+	require.NoError(t, err)
+	var fetched fetchmgr.Job
+	require.NoError(t, json.Unmarshal(readResponse(t, response), &fetched))
+	require.Equal(t, "succeeded", fetched.State)
+	require.Empty(t, fetched.FileKey)
+	require.Equal(t, "text/plain", fetched.Result.ContentType)
+
+	request = authenticatedRequest(
+		t, http.MethodPost, environment.server.URL+"/fetch/v1/jobs/"+job.JobID+"/cancel", nil,
+	)
+	request.SetBasicAuth("fileonly", "file-secret")
+	response, err = environment.server.Client().Do(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusConflict, response.StatusCode)
+	readResponse(t, response)
+
+	request = authenticatedRequest(t, http.MethodGet, environment.server.URL+"/fetch/v1/jobs?limit=10", nil)
+	response, err = environment.server.Client().Do(request)
+	require.NoError(t, err)
+	var page struct {
+		Jobs []fetchmgr.Job `json:"jobs"`
+	}
+	require.NoError(t, json.Unmarshal(readResponse(t, response), &page))
+	require.Len(t, page.Jobs, 1)
+	require.Equal(t, webdavJob.JobID, page.Jobs[0].JobID)
+}
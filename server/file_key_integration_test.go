@@ -0,0 +1,244 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/filekey"
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/utils"
+)
+
+func revokeDirectFileKey(t *testing.T, environment *integrationEnvironment, key string) int {
+	t.Helper()
+	body, err := json.Marshal(map[string]string{"key": key})
+	require.NoError(t, err)
+	request := authenticatedRequest(t, http.MethodPost, environment.server.URL+"/file/revoke", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response, err := environment.server.Client().Do(request)
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	return response.StatusCode
+}
+
+func downloadStatus(t *testing.T, environment *integrationEnvironment, key string) int {
+	t.Helper()
+	response, err := getResponse(t, environment.server.Client(), environment.server.URL+"/file/download/"+key)
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	return response.StatusCode
+}
+
+func createLegacyFileKey(t *testing.T, manager filemgr.IFileManager, content string) string {
+	t.Helper()
+	fileID, err := manager.CreateFile(t.Context(), int64(len(content)), bytes.NewBufferString(content))
+	require.NoError(t, err)
+	key, err := filekey.Parse(hex.EncodeToString(utils.FileIdToHash(fileID)) + "-legacy.txt")
+	require.NoError(t, err)
+	require.NoError(t, manager.CreateFileLink(t.Context(), key.Link(), fileID, int64(len(content)), false))
+	return key.Raw
+}
+
+func TestDirectUploadKeysAreRandomAndRevocable(t *testing.T) {
+	environment := newIntegrationEnvironment(t)
+	key := uploadDirectFile(t, environment, "report.txt", []byte("report"))
+	parsed, err := filekey.Parse(key)
+	require.NoError(t, err)
+	require.Equal(t, filekey.KindToken, parsed.Kind)
+	require.Equal(t, "report.txt", parsed.Name)
+	require.NotEqual(t, key, uploadDirectFile(t, environment, "report.txt", []byte("report")))
+	require.Equal(t, http.StatusOK, downloadStatus(t, environment, key))
+
+	legacy := createLegacyFileKey(t, environment.manager, "legacy")
+	require.Equal(t, http.StatusOK, downloadStatus(t, environment, legacy))
+
+	unissued, err := filekey.New("report.txt")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, downloadStatus(t, environment, unissued.Raw))
+	require.Equal(t, http.StatusNotFound, revokeDirectFileKey(t, environment, unissued.Raw))
+	require.Equal(t, http.StatusBadRequest, revokeDirectFileKey(t, environment, "bad"))
+
+	for _, revoked := range []string{key, legacy} {
+		require.Equal(t, http.StatusOK, revokeDirectFileKey(t, environment, revoked))
+		require.Equal(t, http.StatusOK, revokeDirectFileKey(t, environment, revoked))
+		require.Equal(t, http.StatusBadRequest, downloadStatus(t, environment, revoked))
+		response, err := getResponse(t, environment.server.Client(), environment.server.URL+"/file/meta/"+revoked)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		require.Contains(t, string(readResponse(t, response)), `"exist":false`)
+	}
+
+	request, err := http.NewRequestWithContext(
+		t.Context(), http.MethodPost, environment.server.URL+"/file/revoke", bytes.NewBufferString(`{"key":"x"}`),
+	)
+	require.NoError(t, err)
+	request.Header.Set("Content-Type", "application/json")
+	response, err := environment.server.Client().Do(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	_ = readResponse(t, response)
+}
+
+func TestLegacyFileKeysCanBeSwitchedOff(t *testing.T) {
+	environment := newIntegrationEnvironmentWithStorage(t, []filemgr.Option{filemgr.WithoutLegacyFileKeys()}, nil)
+	legacy := createLegacyFileKey(t, environment.manager, "legacy")
+	require.Equal(t, http.StatusBadRequest, downloadStatus(t, environment, legacy))
+	key := uploadDirectFile(t, environment, "report.txt", []byte("report"))
+	require.Equal(t, http.StatusOK, downloadStatus(t, environment, key))
+}
+
+type fileListItem struct {
+	Link  string `json:"link"`
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+}
+
+type fileListResponse struct {
+	Data struct {
+		Items      []fileListItem `json:"items"`
+		NextCursor string         `json:"next_cursor"`
+	} `json:"data"`
+}
+
+func directFileRequest(
+	t *testing.T,
+	environment *integrationEnvironment,
+	method, target, username, password string,
+) (int, []byte) {
+	t.Helper()
+	request := authenticatedRequest(t, method, environment.server.URL+target, nil)
+	request.SetBasicAuth(username, password)
+	response, err := environment.server.Client().Do(request)
+	require.NoError(t, err)
+	return response.StatusCode, readResponse(t, response)
+}
+
+func listDirectFiles(
+	t *testing.T,
+	environment *integrationEnvironment,
+	query, username, password string,
+) map[string]fileListItem {
+	t.Helper()
+	items := map[string]fileListItem{}
+	cursor := ""
+	for range 20 {
+		status, raw := directFileRequest(t, environment, http.MethodGet,
+			"/file/list?limit=1&cursor="+cursor+query, username, password)
+		require.Equal(t, http.StatusOK, status, string(raw))
+		var page fileListResponse
+		require.NoError(t, json.Unmarshal(raw, &page))
+		for _, item := range page.Data.Items {
+			items[item.Name] = item
+		}
+		if cursor = page.Data.NextCursor; cursor == "" {
+			return items
+		}
+	}
+	require.FailNow(t, "listing did not end")
+	return nil
+}
+
+func listDirectFileOwners(
+	t *testing.T,
+	environment *integrationEnvironment,
+	query, username, password string,
+) map[string]string {
+	t.Helper()
+	owners := map[string]string{}
+	for name, item := range listDirectFiles(t, environment, query, username, password) {
+		owners[name] = item.Owner
+	}
+	return owners
+}
+
+func deleteDirectFileLink(
+	t *testing.T,
+	environment *integrationEnvironment,
+	link, username, password string,
+) int {
+	t.Helper()
+	body, err := json.Marshal(map[string]string{"link": link})
+	require.NoError(t, err)
+	request := authenticatedRequest(t, http.MethodPost, environment.server.URL+"/file/delete", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.SetBasicAuth(username, password)
+	response, err := environment.server.Client().Do(request)
+	require.NoError(t, err)
+	readResponse(t, response)
+	return response.StatusCode
+}
+
+func TestDirectUploadsAreListedAndDeletedByOwner(t *testing.T) {
+	environment := newIntegrationEnvironment(t)
+	adminKey := uploadDirectFile(t, environment, "admin.txt", []byte("admin"))
+	ownKey := uploadDirectFileAs(t, environment, "fileonly", "file-secret", "own.txt", []byte("own"))
+	legacy := createLegacyFileKey(t, environment.manager, "legacy")
+
+	require.Equal(t, map[string]string{"own.txt": "fileonly"},
+		listDirectFileOwners(t, environment, "", "fileonly", "file-secret"))
+	require.Equal(t, map[string]string{"admin.txt": "access", "own.txt": "fileonly", "legacy.txt": ""},
+		listDirectFileOwners(t, environment, "", "access", "secret"))
+	require.Equal(t, map[string]string{"own.txt": "fileonly"},
+		listDirectFileOwners(t, environment, "&owner=fileonly", "access", "secret"))
+	status, _ := directFileRequest(t, environment, http.MethodGet, "/file/list?owner=access", "fileonly", "file-secret")
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = directFileRequest(t, environment, http.MethodGet, "/file/list?cursor=bad", "fileonly", "file-secret")
+	require.Equal(t, http.StatusBadRequest, status)
+	status, _ = directFileRequest(t, environment, http.MethodGet, "/file/list", "reader", "reader-secret")
+	require.Equal(t, http.StatusForbidden, status)
+
+	for _, key := range []string{adminKey, legacy} {
+		status, _ = directFileRequest(t, environment, http.MethodDelete, "/file/"+key, "fileonly", "file-secret")
+		require.Equal(t, http.StatusForbidden, status)
+	}
+	status, _ = directFileRequest(t, environment, http.MethodDelete, "/file/"+ownKey, "reader", "reader-secret")
+	require.Equal(t, http.StatusForbidden, status)
+	status, _ = directFileRequest(t, environment, http.MethodDelete, "/file/"+ownKey, "fileonly", "file-secret")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, http.StatusBadRequest, downloadStatus(t, environment, ownKey))
+	status, _ = directFileRequest(t, environment, http.MethodDelete, "/file/"+ownKey, "fileonly", "file-secret")
+	require.Equal(t, http.StatusNotFound, status)
+	status, _ = directFileRequest(t, environment, http.MethodDelete, "/file/bad", "fileonly", "file-secret")
+	require.Equal(t, http.StatusBadRequest, status)
+
+	for _, key := range []string{adminKey, legacy} {
+		status, _ = directFileRequest(t, environment, http.MethodDelete, "/file/"+key, "access", "secret")
+		require.Equal(t, http.StatusOK, status)
+	}
+	require.Empty(t, listDirectFileOwners(t, environment, "", "access", "secret"))
+}
+
+func TestDirectUploadsAreDeletedByListedLink(t *testing.T) {
+	environment := newIntegrationEnvironment(t)
+	uploadDirectFile(t, environment, "admin.txt", []byte("admin"))
+	ownKey := uploadDirectFileAs(t, environment, "fileonly", "file-secret", "own.txt", []byte("own"))
+	createLegacyFileKey(t, environment.manager, "legacy")
+	links := listDirectFiles(t, environment, "", "access", "secret")
+	require.Len(t, links, 3)
+
+	for _, name := range []string{"admin.txt", "legacy.txt"} {
+		require.Equal(t, http.StatusForbidden,
+			deleteDirectFileLink(t, environment, links[name].Link, "fileonly", "file-secret"))
+	}
+	require.Equal(t, http.StatusForbidden,
+		deleteDirectFileLink(t, environment, links["own.txt"].Link, "reader", "reader-secret"))
+	for _, link := range []string{"/defaults", "/defaults/x", "/defaults/x/../y/z", "/other/x/y", ""} {
+		require.Equal(t, http.StatusBadRequest, deleteDirectFileLink(t, environment, link, "fileonly", "file-secret"))
+	}
+
+	require.Equal(t, http.StatusOK,
+		deleteDirectFileLink(t, environment, links["own.txt"].Link, "fileonly", "file-secret"))
+	require.Equal(t, http.StatusBadRequest, downloadStatus(t, environment, ownKey))
+	require.Equal(t, http.StatusNotFound,
+		deleteDirectFileLink(t, environment, links["own.txt"].Link, "fileonly", "file-secret"))
+
+	for _, name := range []string{"admin.txt", "legacy.txt"} {
+		require.Equal(t, http.StatusOK, deleteDirectFileLink(t, environment, links[name].Link, "access", "secret"))
+	}
+	require.Empty(t, listDirectFiles(t, environment, "", "access", "secret"))
+}
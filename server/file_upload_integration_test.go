@@ -0,0 +1,111 @@
+package server_test
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec // Content-MD5 is part of the upload protocol.
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/server"
+)
+
+type rawUploadResponse struct {
+	Code uint32 `json:"code"`
+	Data struct {
+		Key      string `json:"key"`
+		FileSize int64  `json:"file_size"`
+		Md5      string `json:"md5"`
+		Sha256   string `json:"sha256"`
+	} `json:"data"`
+}
+
+func rawUpload(
+	t *testing.T,
+	environment *integrationEnvironment,
+	method, name string,
+	body io.Reader,
+	header http.Header,
+) (int, rawUploadResponse) {
+	t.Helper()
+	request := authenticatedRequest(t, method, environment.server.URL+"/file/upload/"+name, body)
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	response, err := environment.server.Client().Do(request)
+	require.NoError(t, err)
+	var decoded rawUploadResponse
+	raw := readResponse(t, response)
+	if response.StatusCode == http.StatusOK {
+		require.NoError(t, json.Unmarshal(raw, &decoded))
+	}
+	return response.StatusCode, decoded
+}
+
+func TestRawUploadStreamsBodyAndVerifiesDigests(t *testing.T) {
+	spoolDir := t.TempDir()
+	environment := newIntegrationEnvironmentWith(t, func(database.IDatabase, filemgr.IFileManager) []server.Option {
+		return []server.Option{server.WithFileUpload(server.FileUploadOptions{MaxUploadSize: 64, TempDir: spoolDir})}
+	})
+	content := []byte("raw upload content")
+	md5Sum := md5.Sum(content) //nolint:gosec // Content-MD5 is part of the upload protocol.
+	sha256Sum := sha256.Sum256(content)
+
+	status, uploaded := rawUpload(t, environment, http.MethodPut, "report.txt", bytes.NewReader(content), http.Header{
+		"Content-Md5":      {base64.StdEncoding.EncodeToString(md5Sum[:])},
+		"X-Content-Sha256": {hex.EncodeToString(sha256Sum[:])},
+	})
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, int64(len(content)), uploaded.Data.FileSize)
+	require.Equal(t, hex.EncodeToString(md5Sum[:]), uploaded.Data.Md5)
+	require.Equal(t, hex.EncodeToString(sha256Sum[:]), uploaded.Data.Sha256)
+	response, err := getResponse(
+		t, environment.server.Client(), environment.server.URL+"/file/download/"+uploaded.Data.Key,
+	)
+	require.NoError(t, err)
+	require.Equal(t, content, readResponse(t, response))
+
+	// A body without Content-Length is spooled, and the spool removed after.
+	status, uploaded = rawUpload(
+		t, environment, http.MethodPost, "chunked.bin", io.MultiReader(bytes.NewReader(content)),
+		http.Header{"Content-Type": {"application/octet-stream"}},
+	)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, int64(len(content)), uploaded.Data.FileSize)
+	entries, err := os.ReadDir(spoolDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	status, _ = rawUpload(t, environment, http.MethodPost, "form.bin", bytes.NewReader(content), http.Header{
+		"Content-Type": {"text/plain"},
+	})
+	require.Equal(t, http.StatusUnsupportedMediaType, status)
+	status, _ = rawUpload(t, environment, http.MethodPut, "bad.txt", bytes.NewReader(content), http.Header{
+		"X-Content-Sha256": {hex.EncodeToString(md5Sum[:])},
+	})
+	require.Equal(t, http.StatusBadRequest, status)
+	status, _ = rawUpload(t, environment, http.MethodPut, "bad.txt", bytes.NewReader(content), http.Header{
+		"Content-Md5": {base64.StdEncoding.EncodeToString(sha256Sum[:16])},
+	})
+	require.Equal(t, http.StatusBadRequest, status)
+	large := make([]byte, 65)
+	status, _ = rawUpload(t, environment, http.MethodPut, "large.bin", bytes.NewReader(large), nil)
+	require.Equal(t, http.StatusRequestEntityTooLarge, status)
+	status, _ = rawUpload(t, environment, http.MethodPut, "large.bin", io.MultiReader(bytes.NewReader(large)), nil)
+	require.Equal(t, http.StatusRequestEntityTooLarge, status)
+
+	require.Equal(t, 2, queryIntegrationCount(
+		t,
+		environment.database,
+		"SELECT COUNT(*) FROM tg_file_mapping_tab WHERE file_kind = 2",
+	))
+}
@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"path"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+
+	"github.com/xxxsen/tgfile/archive"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+// downloadArchive streams a directory with its whole tree as a ZIP or TAR
+// attachment. The limits are checked while listing, so an oversized tree is
+// refused before any bytes are sent.
+func (h *Handler) downloadArchive(c *gin.Context) {
+	query, ok := h.parseQuery(c, "path", "format")
+	if !ok {
+		return
+	}
+	resourcePath, ok := h.parsePath(c, query.Get("path"))
+	if !ok {
+		return
+	}
+	setAuditPath(c, resourcePath)
+	format, err := archive.ParseFormat(query.Get("format"))
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	info, err := h.files.StatFileLink(ctx, resourcePath)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	if !info.IsDir {
+		h.writeMappedError(c, filemgr.ErrNotDirectory)
+		return
+	}
+	folder := path.Base(resourcePath)
+	if resourcePath == "/" {
+		folder = "root"
+	}
+	entries, err := archive.Plan(ctx, h.files, resourcePath, folder, h.archiveLimits, nil)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	setAdminSecurityHeaders(c.Writer.Header())
+	if err := archive.Serve(c.Writer, c.Request, folder+"."+string(format), format, h.files, entries); err != nil {
+		logutil.GetLogger(ctx).Warn(
+			"stream admin archive failed",
+			zap.Int("entries", len(entries)),
+			zap.String("error_type", safeAdminErrorType(err)),
+		)
+	}
+}
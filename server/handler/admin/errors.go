@@ -13,10 +13,13 @@ import (
 	"github.com/xxxsen/common/trace"
 	"go.uber.org/zap"
 
+	"github.com/xxxsen/tgfile/archive"
 	"github.com/xxxsen/tgfile/backupfmt"
 	"github.com/xxxsen/tgfile/backupmgr"
 	"github.com/xxxsen/tgfile/directory"
+	"github.com/xxxsen/tgfile/fetchmgr"
 	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/sharelink"
 )
 
 type mappedAdminError struct {
@@ -33,15 +36,30 @@ var mappedAdminErrors = []mappedAdminError{
 	{filemgr.ErrInvalidFileSize, http.StatusBadRequest, "invalid_request", "请求参数无效"},
 	{filemgr.ErrFileShortRead, http.StatusBadRequest, "invalid_request", "请求体短于声明长度"},
 	{filemgr.ErrInvalidFileLinkPage, http.StatusBadRequest, "invalid_request", "请求参数无效"},
+	{filemgr.ErrInvalidTrashRequest, http.StatusBadRequest, "invalid_request", "请求参数无效"},
+	{filemgr.ErrInvalidSnapshotRequest, http.StatusBadRequest, "invalid_request", "请求参数无效"},
+	{filemgr.ErrInvalidVersionRequest, http.StatusBadRequest, "invalid_request", "请求参数无效"},
 	{directory.ErrInvalidPath, http.StatusBadRequest, "invalid_request", "请求参数无效"},
+	{archive.ErrInvalidFormat, http.StatusBadRequest, "invalid_request", "请求参数无效"},
+	{sharelink.ErrInvalidRequest, http.StatusBadRequest, "invalid_request", "请求参数无效"},
+	{fetchmgr.ErrInvalidRequest, http.StatusBadRequest, "invalid_request", "请求参数无效"},
+	{archive.ErrTooManyEntries, http.StatusRequestEntityTooLarge, "archive_too_large", "目录条目过多，无法打包"},
+	{archive.ErrTooLarge, http.StatusRequestEntityTooLarge, "archive_too_large", "目录过大，无法打包"},
 	{backupmgr.ErrJobNotFound, http.StatusNotFound, "job_not_found", "备份任务不存在"},
+	{sharelink.ErrNotFound, http.StatusNotFound, "share_not_found", "分享链接不存在"},
+	{fetchmgr.ErrJobNotFound, http.StatusNotFound, "job_not_found", "抓取任务不存在"},
 	{os.ErrNotExist, http.StatusNotFound, "not_found", "资源不存在"},
+	{filemgr.ErrThumbnailUnsupported, http.StatusNotFound, "no_thumbnail", "该文件没有预览"},
 	{directory.ErrSourceNotFound, http.StatusNotFound, "not_found", "资源不存在"},
 	{filemgr.ErrNotDirectory, http.StatusConflict, "not_directory", "目标不是目录"},
+	{sharelink.ErrNotDirectory, http.StatusConflict, "not_directory", "目标不是目录"},
 	{directory.ErrPathComponentNotDirectory, http.StatusConflict, "not_directory", "目标不是目录"},
 	{filemgr.ErrDirectoryIO, http.StatusConflict, "target_is_directory", "目标是目录"},
 	{directory.ErrEntryNotFile, http.StatusConflict, "target_is_directory", "目标是目录"},
 	{filemgr.ErrFileLinkCursorStale, http.StatusConflict, "cursor_stale", "目录已变化，请刷新"},
+	{filemgr.ErrTrashConflict, http.StatusConflict, "destination_exists", "恢复目标已存在"},
+	{filemgr.ErrSnapshotConflict, http.StatusConflict, "destination_exists", "恢复目标已存在"},
+	{filemgr.ErrSnapshotExists, http.StatusConflict, "snapshot_exists", "快照名称已被使用"},
 	{
 		filemgr.ErrWebDAVPrecondition,
 		http.StatusPreconditionFailed,
@@ -51,9 +69,11 @@ var mappedAdminErrors = []mappedAdminError{
 	{filemgr.ErrWebDAVLocked, http.StatusLocked, "locked", "目标已被 WebDAV 锁定"},
 	{filemgr.ErrWebDAVQuota, http.StatusInsufficientStorage, "quota_exceeded", "操作超过服务限制"},
 	{filemgr.ErrWebDAVTooManyItems, http.StatusInsufficientStorage, "quota_exceeded", "操作超过服务限制"},
+	{filemgr.ErrQuotaExceeded, http.StatusInsufficientStorage, "quota_exceeded", "操作超过用户配额"},
 	{backupmgr.ErrIdempotencyConflict, http.StatusConflict, "job_conflict", "幂等键与已有任务冲突"},
 	{backupmgr.ErrJobNotCancelable, http.StatusConflict, "job_not_ready", "任务当前不能执行该操作"},
 	{backupmgr.ErrArtifactUnavailable, http.StatusConflict, "job_not_ready", "任务当前不能执行该操作"},
+	{fetchmgr.ErrJobNotCancelable, http.StatusConflict, "job_not_ready", "任务当前不能执行该操作"},
 	{backupfmt.ErrLimitExceeded, http.StatusRequestEntityTooLarge, "payload_too_large", "数据超过配置限制"},
 	{filemgr.ErrTooManyFileParts, http.StatusRequestEntityTooLarge, "payload_too_large", "数据超过配置限制"},
 	{syscall.ENOSPC, http.StatusInsufficientStorage, "insufficient_storage", "存储空间不足"},
@@ -0,0 +1,108 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/backupmgr"
+	"github.com/xxxsen/tgfile/fetchmgr"
+)
+
+type fetchJobDTO struct {
+	*fetchmgr.Job
+	Owner      string `json:"owner"`
+	Cancelable bool   `json:"cancelable"`
+}
+
+// listFetchJobs lists the fetch jobs of every principal to read-write
+// administrators and the own jobs to everyone else.
+func (h *Handler) listFetchJobs(c *gin.Context) {
+	user, ok := h.principal(c)
+	if !ok {
+		h.writePublicError(c, http.StatusUnauthorized, "unauthenticated", "请重新登录", nil)
+		return
+	}
+	query, ok := h.parseQuery(c, "limit", "cursor", "state", "owner")
+	if !ok {
+		return
+	}
+	if h.fetches == nil {
+		h.writeData(c, http.StatusOK, map[string]any{"enabled": false, "jobs": []fetchJobDTO{}, "next_cursor": ""})
+		return
+	}
+	limit, err := parsePositiveInt(query.Get("limit"), 50, 200)
+	if err != nil {
+		h.writePublicError(c, http.StatusBadRequest, "invalid_request", "分页大小无效", err)
+		return
+	}
+	decodedCursor, hasCursor, err := decodeJobCursor(query.Get("cursor"))
+	if err != nil {
+		h.writePublicError(c, http.StatusBadRequest, "invalid_cursor", "分页游标无效", err)
+		return
+	}
+	request := fetchmgr.ListJobsRequest{Owner: query.Get("owner"), State: query.Get("state"), Limit: limit}
+	if hasCursor {
+		request.Cursor = &fetchmgr.JobCursor{CreatedAt: decodedCursor.CreatedAt, JobID: decodedCursor.JobID}
+	}
+	if user.Role == roleRead {
+		if request.Owner != "" && request.Owner != user.Username {
+			h.writePublicError(c, http.StatusForbidden, "forbidden", "不能查看其他用户任务", nil)
+			return
+		}
+		request.Owner = user.Username
+	}
+	page, err := h.fetches.ListJobs(c.Request.Context(), request)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	jobs := make([]fetchJobDTO, 0, len(page.Jobs))
+	for _, job := range page.Jobs {
+		jobs = append(jobs, fetchJobDTO{Job: job, Owner: job.Owner, Cancelable: job.Cancelable()})
+	}
+	var next string
+	if page.NextCursor != nil {
+		next, err = encodeJobCursor(&backupmgr.JobCursor{
+			CreatedAt: page.NextCursor.CreatedAt,
+			JobID:     page.NextCursor.JobID,
+		})
+		if err != nil {
+			h.writeMappedError(c, err)
+			return
+		}
+	}
+	h.writeData(c, http.StatusOK, map[string]any{"enabled": true, "jobs": jobs, "next_cursor": next})
+}
+
+func (h *Handler) cancelFetchJob(c *gin.Context) {
+	user, ok := h.principal(c)
+	if !ok || !h.requireMutation(c, user) || !h.requireFetches(c) {
+		return
+	}
+	if _, ok := h.parseQuery(c); !ok {
+		return
+	}
+	job, err := h.fetches.GetJob(c.Request.Context(), c.Param("job_id"))
+	if err == nil && user.Role != roleReadWrite && job.Owner != user.Username {
+		err = fetchmgr.ErrJobNotFound
+	}
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	job, err = h.fetches.Cancel(c.Request.Context(), job.JobID)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	h.writeData(c, http.StatusAccepted, fetchJobDTO{Job: job, Owner: job.Owner, Cancelable: job.Cancelable()})
+}
+
+func (h *Handler) requireFetches(c *gin.Context) bool {
+	if h.fetches != nil {
+		return true
+	}
+	h.writePublicError(c, http.StatusNotFound, "not_found", "远程抓取未启用", nil)
+	return false
+}
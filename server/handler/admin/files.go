@@ -115,6 +115,12 @@ func (h *Handler) download(c *gin.Context) {
 		h.writeMappedError(c, err)
 		return
 	}
+	h.serveFile(c, info)
+}
+
+// serveFile streams a file the caller has already resolved as an
+// attachment, honouring conditional and range headers.
+func (h *Handler) serveFile(c *gin.Context, info *entity.FileLinkMeta) {
 	if info.IsDir {
 		h.writeMappedError(c, filemgr.ErrDirectoryIO)
 		return
@@ -133,7 +139,7 @@ func (h *Handler) download(c *gin.Context) {
 		}
 	}()
 	setAdminSecurityHeaders(c.Writer.Header())
-	c.Header("Content-Type", httpkit.DetermineMimeType(info.FileName))
+	c.Header("Content-Type", httpkit.ContentType(info))
 	c.Header("Content-Disposition", safeDisposition(info.FileName))
 	c.Header("Cache-Control", "private, no-store")
 	c.Header("ETag", filemgr.WebDAVETag(info))
@@ -191,6 +197,7 @@ func (h *Handler) upload(c *gin.Context) {
 		contentLength,
 		filemgr.WebDAVMutationOptions{
 			Principal:  user.Username,
+			Admin:      true,
 			Condition:  condition,
 			MaxEntries: h.mutationMaxItems,
 		},
@@ -240,18 +247,21 @@ func (h *Handler) uploadCondition(
 func (h *Handler) entry(resourcePath string, item *entity.FileLinkMeta) entryDTO {
 	kind := "file"
 	etag := filemgr.WebDAVETag(item)
+	contentType := httpkit.ContentType(item)
 	if item.IsDir {
 		kind = "directory"
 		etag = ""
+		contentType = ""
 	}
 	return entryDTO{
-		Name:  item.FileName,
-		Path:  resourcePath,
-		Kind:  kind,
-		Size:  item.FileSize,
-		Ctime: item.Ctime,
-		Mtime: item.Mtime,
-		ETag:  etag,
+		Name:        item.FileName,
+		Path:        resourcePath,
+		Kind:        kind,
+		Size:        item.FileSize,
+		Ctime:       item.Ctime,
+		Mtime:       item.Mtime,
+		ETag:        etag,
+		ContentType: contentType,
 	}
 }
 
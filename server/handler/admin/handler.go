@@ -21,6 +21,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/filemgr"
 	"github.com/xxxsen/tgfile/server/handler/admin/ui"
 )
 
@@ -47,6 +48,10 @@ func New(options Options) (*Handler, error) {
 		maxUploadSize:    options.MaxUploadSize,
 		maxPathBytes:     options.MaxPathBytes,
 		mutationMaxItems: options.MutationMaxItems,
+		s3Buckets:        append([]string(nil), options.S3Buckets...),
+		archiveLimits:    options.ArchiveLimits,
+		shareLinks:       options.ShareLinks,
+		fetches:          options.FetchManager,
 		sessions:         newSessionStore(options.SessionIdle, options.SessionMaximum),
 		loginLimiter:     newLoginLimiter(),
 	}
@@ -172,6 +177,32 @@ func (h *Handler) newAPIHandler() http.Handler {
 	authenticated.GET("/content", h.download)
 	authenticated.HEAD("/content", h.download)
 	authenticated.PUT("/content", h.upload)
+	authenticated.POST("/presign", h.presignObject)
+	authenticated.GET("/trash", h.listTrash)
+	authenticated.POST("/trash/:trash_id/restore", h.restoreTrash)
+	authenticated.DELETE("/trash/:trash_id", h.purgeTrash)
+	authenticated.GET("/snapshots", h.listSnapshots)
+	authenticated.POST("/snapshots", h.createSnapshot)
+	authenticated.DELETE("/snapshots/:name", h.deleteSnapshot)
+	authenticated.GET("/snapshots/:name/entries", h.listSnapshotEntries)
+	authenticated.GET("/snapshots/:name/content", h.downloadSnapshot)
+	authenticated.HEAD("/snapshots/:name/content", h.downloadSnapshot)
+	authenticated.POST("/snapshots/:name/restore", h.restoreSnapshot)
+	authenticated.GET("/versions", h.listVersions)
+	authenticated.DELETE("/versions/:version_id", h.deleteVersion)
+	authenticated.GET("/versions/:version_id/content", h.downloadVersion)
+	authenticated.HEAD("/versions/:version_id/content", h.downloadVersion)
+	authenticated.POST("/versions/:version_id/restore", h.restoreVersion)
+	authenticated.GET("/thumbnail", h.downloadThumbnail)
+	authenticated.HEAD("/thumbnail", h.downloadThumbnail)
+	authenticated.GET("/archive", h.downloadArchive)
+	authenticated.GET("/quotas", h.listQuotas)
+	authenticated.GET("/shares", h.listShares)
+	authenticated.POST("/shares", h.createShare)
+	authenticated.DELETE("/shares/:share_id", h.revokeShare)
+	authenticated.HEAD("/archive", h.downloadArchive)
+	authenticated.GET("/fetch/jobs", h.listFetchJobs)
+	authenticated.POST("/fetch/jobs/:job_id/cancel", h.cancelFetchJob)
 	authenticated.GET("/backup/jobs", h.listJobs)
 	authenticated.GET("/backup/jobs/:job_id", h.getJob)
 	authenticated.POST("/backup/jobs/:job_id/cancel", h.cancelJob)
@@ -395,6 +426,7 @@ func (h *Handler) requireSession(c *gin.Context) {
 		CSRF:     session.csrf,
 	})
 	c.Set(principalKey+"-session", session)
+	c.Request = c.Request.WithContext(filemgr.ContextWithOwner(c.Request.Context(), session.username))
 	c.Header("Vary", "Cookie")
 	c.Next()
 }
@@ -498,6 +530,8 @@ func (h *Handler) sessionDTO(session *sessionRecord) map[string]any {
 		"csrf_token":          session.csrf,
 		"idle_expires_at":     h.sessions.idleExpiry(session).UnixMilli(),
 		"absolute_expires_at": session.expiresAt.UnixMilli(),
+		"s3_buckets":          h.s3Buckets,
+		"thumbnails":          h.files.ThumbnailsEnabled(),
 	}
 }
 
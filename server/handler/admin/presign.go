@@ -0,0 +1,121 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/presign"
+)
+
+type presignRequest struct {
+	Path           string            `json:"path"`
+	Method         string            `json:"method"`
+	ExpiresSeconds int64             `json:"expires_seconds"`
+	ContentType    string            `json:"content_type"`
+	Response       map[string]string `json:"response"`
+}
+
+// presignObject signs an S3 URL for an object under a bucket directory with
+// the logged-in user's own S3 credentials, so the link grants no more than
+// the user could already do through S3.
+func (h *Handler) presignObject(c *gin.Context) {
+	user, ok := h.principal(c)
+	if !ok || !h.requireMutation(c, user) {
+		return
+	}
+	if _, ok := h.parseQuery(c); !ok {
+		return
+	}
+	if c.ContentType() != "application/json" {
+		h.writePublicError(c, http.StatusBadRequest, "invalid_request", "请求格式无效", nil)
+		return
+	}
+	var request presignRequest
+	if err := decodeStrictJSON(c.Request.Body, 16*1024, &request); err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	resourcePath, ok := h.parsePath(c, request.Path)
+	if !ok {
+		return
+	}
+	setAuditPath(c, resourcePath)
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(resourcePath, "/"), "/")
+	if key == "" || !slices.Contains(h.s3Buckets, bucket) {
+		h.writePublicError(c, http.StatusBadRequest, "not_s3_object", "只能为 S3 存储桶中的对象生成链接", nil)
+		return
+	}
+	if request.Method == "" {
+		request.Method = http.MethodGet
+	}
+	permission := authz.S3Read
+	if request.Method == http.MethodPut {
+		permission = authz.S3Write
+	}
+	if !h.authorizer.Has(user.Username, permission) {
+		h.writePublicError(c, http.StatusForbidden, "forbidden", "当前账号没有对应的 S3 权限", nil)
+		return
+	}
+	if request.Method == http.MethodGet && !h.presignTargetIsFile(c, resourcePath) {
+		return
+	}
+	h.signPresignRequest(c, user, bucket, key, &request)
+}
+
+func (h *Handler) presignTargetIsFile(c *gin.Context, resourcePath string) bool {
+	info, err := h.files.StatFileLink(c.Request.Context(), resourcePath)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return false
+	}
+	if info.IsDir {
+		h.writeMappedError(c, filemgr.ErrDirectoryIO)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) signPresignRequest(c *gin.Context, user principal, bucket, key string, request *presignRequest) {
+	// requireMutation has already checked Origin against external_origin, so
+	// it is the address the browser reaches this service at.
+	_, endpoint, err := parseOrigin(c.GetHeader("Origin"))
+	if err != nil {
+		h.writePublicError(c, http.StatusForbidden, "origin_invalid", "请求来源无效", err)
+		return
+	}
+	if request.ExpiresSeconds < 0 || request.ExpiresSeconds > int64(presign.MaxExpires/time.Second) {
+		h.writePublicError(c, http.StatusBadRequest, "invalid_request", "链接有效期无效", nil)
+		return
+	}
+	expires := presign.DefaultExpires
+	if request.ExpiresSeconds != 0 {
+		expires = time.Duration(request.ExpiresSeconds) * time.Second
+	}
+	result, err := presign.Sign(c.Request.Context(), &presign.Request{
+		Endpoint:    endpoint,
+		Method:      request.Method,
+		Bucket:      bucket,
+		Key:         key,
+		Expires:     expires,
+		ContentType: request.ContentType,
+		Overrides:   request.Response,
+		AccessKey:   user.Username,
+		SecretKey:   h.users[user.Username],
+	})
+	if err != nil {
+		if errors.Is(err, presign.ErrInvalidRequest) {
+			h.writePublicError(c, http.StatusBadRequest, "invalid_request", "链接参数无效", err)
+			return
+		}
+		h.writeMappedError(c, err)
+		return
+	}
+	h.writeData(c, http.StatusOK, result)
+}
@@ -0,0 +1,57 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+type quotaUsageDTO struct {
+	Principal    string `json:"principal"`
+	Bytes        int64  `json:"bytes"`
+	Objects      int64  `json:"objects"`
+	HardBytes    int64  `json:"hard_bytes"`
+	SoftBytes    int64  `json:"soft_bytes"`
+	HardObjects  int64  `json:"hard_objects"`
+	SoftObjects  int64  `json:"soft_objects"`
+	SoftExceeded bool   `json:"soft_exceeded"`
+	HardExceeded bool   `json:"hard_exceeded"`
+}
+
+// listQuotas reports the usage of every principal that owns files or has
+// limits of its own. A zero limit is unlimited.
+func (h *Handler) listQuotas(c *gin.Context) {
+	if _, ok := h.principal(c); !ok {
+		h.writePublicError(c, http.StatusUnauthorized, "unauthenticated", "请重新登录", nil)
+		return
+	}
+	if _, ok := h.parseQuery(c); !ok {
+		return
+	}
+	usages, err := h.files.ListQuotaUsage(c.Request.Context())
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	items := make([]quotaUsageDTO, 0, len(usages))
+	for _, usage := range usages {
+		items = append(items, toQuotaUsageDTO(usage))
+	}
+	h.writeData(c, http.StatusOK, map[string]any{"items": items})
+}
+
+func toQuotaUsageDTO(usage filemgr.QuotaUsage) quotaUsageDTO {
+	return quotaUsageDTO{
+		Principal:    usage.Principal,
+		Bytes:        usage.Bytes,
+		Objects:      usage.Objects,
+		HardBytes:    usage.Limits.HardBytes,
+		SoftBytes:    usage.Limits.SoftBytes,
+		HardObjects:  usage.Limits.HardObjects,
+		SoftObjects:  usage.Limits.SoftObjects,
+		SoftExceeded: usage.SoftExceeded(),
+		HardExceeded: usage.HardExceeded(),
+	}
+}
@@ -0,0 +1,100 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/sharelink"
+)
+
+type createShareRequest struct {
+	Path           string `json:"path"`
+	ExpiresSeconds int64  `json:"expires_seconds"`
+	Password       string `json:"password"`
+	MaxDownloads   int64  `json:"max_downloads"`
+	Listing        bool   `json:"listing"`
+}
+
+// listShares lists the share links of every principal, so an administrator
+// can revoke links created through the share API as well.
+func (h *Handler) listShares(c *gin.Context) {
+	if _, ok := h.principal(c); !ok {
+		h.writePublicError(c, http.StatusUnauthorized, "unauthenticated", "请重新登录", nil)
+		return
+	}
+	if _, ok := h.parseQuery(c); !ok {
+		return
+	}
+	if h.shareLinks == nil {
+		h.writeData(c, http.StatusOK, map[string]any{"enabled": false, "items": []*sharelink.Link{}})
+		return
+	}
+	links, err := h.shareLinks.List(c.Request.Context(), "")
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	h.writeData(c, http.StatusOK, map[string]any{"enabled": true, "items": links})
+}
+
+func (h *Handler) createShare(c *gin.Context) {
+	user, ok := h.requireWrite(c)
+	if !ok || !h.requireMutation(c, user) || !h.requireShareLinks(c) {
+		return
+	}
+	if _, ok := h.parseQuery(c); !ok {
+		return
+	}
+	if c.ContentType() != "application/json" {
+		h.writePublicError(c, http.StatusBadRequest, "invalid_request", "请求格式无效", nil)
+		return
+	}
+	var request createShareRequest
+	if err := decodeStrictJSON(c.Request.Body, 16*1024, &request); err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	resourcePath, ok := h.parsePath(c, request.Path)
+	if !ok {
+		return
+	}
+	setAuditPath(c, resourcePath)
+	created, err := h.shareLinks.Create(c.Request.Context(), sharelink.CreateRequest{
+		Owner:        user.Username,
+		Path:         resourcePath,
+		Expires:      time.Duration(request.ExpiresSeconds) * time.Second,
+		Password:     request.Password,
+		MaxDownloads: request.MaxDownloads,
+		Listing:      request.Listing,
+	})
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	h.writeData(c, http.StatusCreated, created)
+}
+
+func (h *Handler) revokeShare(c *gin.Context) {
+	user, ok := h.requireWrite(c)
+	if !ok || !h.requireMutation(c, user) || !h.requireShareLinks(c) {
+		return
+	}
+	if _, ok := h.parseQuery(c); !ok {
+		return
+	}
+	if err := h.shareLinks.Revoke(c.Request.Context(), "", c.Param("share_id")); err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) requireShareLinks(c *gin.Context) bool {
+	if h.shareLinks != nil {
+		return true
+	}
+	h.writePublicError(c, http.StatusNotFound, "not_found", "分享链接未启用", nil)
+	return false
+}
@@ -0,0 +1,273 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+type snapshotDTO struct {
+	SnapshotID   string `json:"snapshot_id"`
+	Name         string `json:"name"`
+	Scope        string `json:"scope"`
+	Origin       string `json:"origin"`
+	ScheduleName string `json:"schedule_name"`
+	Principal    string `json:"principal"`
+	FileCount    int64  `json:"file_count"`
+	DirCount     int64  `json:"dir_count"`
+	TotalSize    int64  `json:"total_size"`
+	CreatedAt    int64  `json:"created_at"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+type createSnapshotRequest struct {
+	Name          string `json:"name"`
+	Scope         string `json:"scope"`
+	RetentionDays int    `json:"retention_days"`
+}
+
+type restoreSnapshotRequest struct {
+	Path        string `json:"path"`
+	Destination string `json:"destination"`
+	Overwrite   bool   `json:"overwrite"`
+}
+
+type snapshotRestoreDTO struct {
+	Destination string `json:"destination"`
+	Files       int64  `json:"files"`
+	Directories int64  `json:"directories"`
+}
+
+func (h *Handler) listSnapshots(c *gin.Context) {
+	if _, ok := h.principal(c); !ok {
+		h.writePublicError(c, http.StatusUnauthorized, "unauthenticated", "请重新登录", nil)
+		return
+	}
+	if _, ok := h.parseQuery(c); !ok {
+		return
+	}
+	snapshots, err := h.files.ListSnapshots(c.Request.Context())
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	items := make([]snapshotDTO, 0, len(snapshots))
+	for _, info := range snapshots {
+		items = append(items, toSnapshotDTO(&info))
+	}
+	h.writeData(c, http.StatusOK, map[string]any{
+		"enabled": h.files.SnapshotsEnabled(),
+		"items":   items,
+	})
+}
+
+func (h *Handler) createSnapshot(c *gin.Context) {
+	user, ok := h.requireWrite(c)
+	if !ok || !h.requireMutation(c, user) {
+		return
+	}
+	if _, ok := h.parseQuery(c); !ok {
+		return
+	}
+	if c.ContentType() != "application/json" {
+		h.writePublicError(c, http.StatusBadRequest, "invalid_request", "请求格式无效", nil)
+		return
+	}
+	var request createSnapshotRequest
+	if err := decodeStrictJSON(c.Request.Body, 16*1024, &request); err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	scope, ok := h.parsePath(c, request.Scope)
+	if !ok {
+		return
+	}
+	if request.RetentionDays < 0 || request.RetentionDays > 3650 {
+		h.writePublicError(c, http.StatusBadRequest, "invalid_request", "保留天数无效", nil)
+		return
+	}
+	setAuditPath(c, scope)
+	info, err := h.files.CreateSnapshot(c.Request.Context(), filemgr.SnapshotCreateRequest{
+		Name:      request.Name,
+		Scope:     scope,
+		Origin:    filemgr.SnapshotOriginAPI,
+		Principal: user.Username,
+		Retention: time.Duration(request.RetentionDays) * 24 * time.Hour,
+	})
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	h.writeData(c, http.StatusCreated, toSnapshotDTO(info))
+}
+
+func (h *Handler) deleteSnapshot(c *gin.Context) {
+	user, ok := h.requireWrite(c)
+	if !ok || !h.requireMutation(c, user) {
+		return
+	}
+	name, ok := h.parseSnapshotName(c)
+	if !ok {
+		return
+	}
+	info, err := h.files.DeleteSnapshot(c.Request.Context(), name)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	setAuditPath(c, info.Scope)
+	c.Status(http.StatusNoContent)
+}
+
+// listSnapshotEntries pages through one directory of a snapshot. Paths are
+// relative to the snapshot scope, and the cursor is the last name returned.
+func (h *Handler) listSnapshotEntries(c *gin.Context) {
+	if _, ok := h.principal(c); !ok {
+		h.writePublicError(c, http.StatusUnauthorized, "unauthenticated", "请重新登录", nil)
+		return
+	}
+	name, ok := h.parseSnapshotName(c, "path", "limit", "cursor")
+	if !ok {
+		return
+	}
+	query := c.Request.URL.Query()
+	dir, ok := h.parsePath(c, query.Get("path"))
+	if !ok {
+		return
+	}
+	limit, err := parsePositiveInt(query.Get("limit"), 100, 500)
+	if err != nil {
+		h.writePublicError(c, http.StatusBadRequest, "invalid_request", "分页大小无效", err)
+		return
+	}
+	page, err := h.files.ListSnapshotEntries(c.Request.Context(), filemgr.SnapshotEntryListRequest{
+		Name:   name,
+		Dir:    dir,
+		Cursor: query.Get("cursor"),
+		Limit:  limit,
+	})
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	items := make([]entryDTO, 0, len(page.Entries))
+	for _, entry := range page.Entries {
+		items = append(items, h.entry(entry.Path, snapshotLinkMeta(&entry)))
+	}
+	h.writeData(c, http.StatusOK, map[string]any{
+		"path":        dir,
+		"items":       items,
+		"next_cursor": page.NextCursor,
+	})
+}
+
+func (h *Handler) downloadSnapshot(c *gin.Context) {
+	name, ok := h.parseSnapshotName(c, "path")
+	if !ok {
+		return
+	}
+	entryPath, ok := h.parsePath(c, c.Request.URL.Query().Get("path"))
+	if !ok {
+		return
+	}
+	entry, err := h.files.StatSnapshotEntry(c.Request.Context(), name, entryPath)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	h.serveFile(c, snapshotLinkMeta(entry))
+}
+
+// restoreSnapshot links the files of a snapshot subtree back into the
+// namespace, at their original place unless a destination is given.
+// Existing entries are only replaced, through the recycle bin when it is
+// enabled, if the request asks for it.
+func (h *Handler) restoreSnapshot(c *gin.Context) {
+	user, ok := h.requireWrite(c)
+	if !ok || !h.requireMutation(c, user) {
+		return
+	}
+	name, ok := h.parseSnapshotName(c)
+	if !ok {
+		return
+	}
+	if c.ContentType() != "application/json" {
+		h.writePublicError(c, http.StatusBadRequest, "invalid_request", "请求格式无效", nil)
+		return
+	}
+	request := restoreSnapshotRequest{Path: "/"}
+	if err := decodeStrictJSON(c.Request.Body, 16*1024, &request); err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	if _, ok := h.parsePath(c, request.Path); !ok {
+		return
+	}
+	if request.Destination != "" {
+		if _, ok := h.parsePath(c, request.Destination); !ok {
+			return
+		}
+	}
+	result, err := h.files.RestoreSnapshot(c.Request.Context(), filemgr.SnapshotRestoreRequest{
+		Name:        name,
+		Path:        request.Path,
+		Destination: request.Destination,
+		Overwrite:   request.Overwrite,
+		Principal:   user.Username,
+	})
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	setAuditPath(c, result.Destination)
+	h.writeData(c, http.StatusOK, snapshotRestoreDTO{
+		Destination: result.Destination,
+		Files:       result.Files,
+		Directories: result.Directories,
+	})
+}
+
+func (h *Handler) parseSnapshotName(c *gin.Context, allowed ...string) (string, bool) {
+	if _, ok := h.parseQuery(c, allowed...); !ok {
+		return "", false
+	}
+	name := c.Param("name")
+	if !filemgr.ValidSnapshotName(name) {
+		h.writePublicError(c, http.StatusNotFound, "not_found", "资源不存在", nil)
+		return "", false
+	}
+	return name, true
+}
+
+func toSnapshotDTO(info *filemgr.SnapshotInfo) snapshotDTO {
+	return snapshotDTO{
+		SnapshotID:   strconv.FormatUint(info.SnapshotID, 10),
+		Name:         info.Name,
+		Scope:        info.Scope,
+		Origin:       info.Origin,
+		ScheduleName: info.ScheduleName,
+		Principal:    info.Principal,
+		FileCount:    info.FileCount,
+		DirCount:     info.DirCount,
+		TotalSize:    info.TotalSize,
+		CreatedAt:    info.CreatedAt,
+		ExpiresAt:    info.ExpiresAt,
+	}
+}
+
+func snapshotLinkMeta(entry *filemgr.SnapshotEntry) *entity.FileLinkMeta {
+	return &entity.FileLinkMeta{
+		FileName: entry.Name,
+		FileId:   entry.FileID,
+		FileSize: entry.Size,
+		Mode:     entry.Mode,
+		Ctime:    entry.Ctime,
+		Mtime:    entry.Mtime,
+		IsDir:    entry.IsDir,
+	}
+}
@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/entity"
+)
+
+// downloadThumbnail serves the JPEG preview of an image file, rendering it on
+// the first request. Files that cannot be previewed answer 404.
+func (h *Handler) downloadThumbnail(c *gin.Context) {
+	query, ok := h.parseQuery(c, "path")
+	if !ok {
+		return
+	}
+	resourcePath, ok := h.parsePath(c, query.Get("path"))
+	if !ok {
+		return
+	}
+	info, err := h.files.StatFileLink(c.Request.Context(), resourcePath)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	if info.IsDir {
+		h.writePublicError(c, http.StatusNotFound, "not_found", "资源不存在", nil)
+		return
+	}
+	thumbnail, err := h.files.GetThumbnail(c.Request.Context(), info.FileId)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	h.serveFile(c, &entity.FileLinkMeta{
+		FileName: path.Base(resourcePath) + ".jpg",
+		FileId:   thumbnail.FileID,
+		FileSize: thumbnail.Size,
+		Mtime:    thumbnail.CreatedAt,
+	})
+}
@@ -0,0 +1,151 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+type trashItemDTO struct {
+	TrashID      string `json:"trash_id"`
+	OriginalPath string `json:"original_path"`
+	Kind         string `json:"kind"`
+	Size         int64  `json:"size"`
+	EntryCount   int64  `json:"entry_count"`
+	Principal    string `json:"principal"`
+	Protocol     string `json:"protocol"`
+	DeletedAt    int64  `json:"deleted_at"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+type restoreTrashRequest struct {
+	Path string `json:"path"`
+}
+
+func (h *Handler) listTrash(c *gin.Context) {
+	if _, ok := h.principal(c); !ok {
+		h.writePublicError(c, http.StatusUnauthorized, "unauthenticated", "请重新登录", nil)
+		return
+	}
+	query, ok := h.parseQuery(c, "limit", "cursor")
+	if !ok {
+		return
+	}
+	limit, err := parsePositiveInt(query.Get("limit"), 50, 200)
+	if err != nil {
+		h.writePublicError(c, http.StatusBadRequest, "invalid_request", "分页大小无效", err)
+		return
+	}
+	var cursor uint64
+	if value := query.Get("cursor"); value != "" {
+		if cursor, err = strconv.ParseUint(value, 10, 64); err != nil || cursor == 0 {
+			h.writePublicError(c, http.StatusBadRequest, "invalid_cursor", "分页游标无效", err)
+			return
+		}
+	}
+	page, err := h.files.ListTrash(c.Request.Context(), filemgr.TrashListRequest{Cursor: cursor, Limit: limit})
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	items := make([]trashItemDTO, 0, len(page.Items))
+	for _, item := range page.Items {
+		items = append(items, toTrashItemDTO(&item))
+	}
+	next := ""
+	if page.NextCursor != 0 {
+		next = strconv.FormatUint(page.NextCursor, 10)
+	}
+	h.writeData(c, http.StatusOK, map[string]any{
+		"enabled":     h.files.TrashEnabled(),
+		"items":       items,
+		"next_cursor": next,
+	})
+}
+
+// restoreTrash moves an item back to its original path, or to the path in
+// the request body. An existing destination is never replaced.
+func (h *Handler) restoreTrash(c *gin.Context) {
+	user, ok := h.requireWrite(c)
+	if !ok || !h.requireMutation(c, user) {
+		return
+	}
+	trashID, ok := h.parseTrashID(c)
+	if !ok {
+		return
+	}
+	if c.ContentType() != "application/json" {
+		h.writePublicError(c, http.StatusBadRequest, "invalid_request", "请求格式无效", nil)
+		return
+	}
+	var request restoreTrashRequest
+	if err := decodeStrictJSON(c.Request.Body, 16*1024, &request); err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	if request.Path != "" {
+		destination, ok := h.parsePath(c, request.Path)
+		if !ok {
+			return
+		}
+		request.Path = destination
+	}
+	item, err := h.files.RestoreTrash(c.Request.Context(), trashID, request.Path)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	setAuditPath(c, item.OriginalPath)
+	h.writeData(c, http.StatusOK, toTrashItemDTO(item))
+}
+
+func (h *Handler) purgeTrash(c *gin.Context) {
+	user, ok := h.requireWrite(c)
+	if !ok || !h.requireMutation(c, user) {
+		return
+	}
+	trashID, ok := h.parseTrashID(c)
+	if !ok {
+		return
+	}
+	item, err := h.files.PurgeTrash(c.Request.Context(), trashID)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	setAuditPath(c, item.OriginalPath)
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) parseTrashID(c *gin.Context) (uint64, bool) {
+	if _, ok := h.parseQuery(c); !ok {
+		return 0, false
+	}
+	trashID, err := strconv.ParseUint(c.Param("trash_id"), 10, 64)
+	if err != nil || trashID == 0 {
+		h.writePublicError(c, http.StatusNotFound, "not_found", "资源不存在", nil)
+		return 0, false
+	}
+	return trashID, true
+}
+
+func toTrashItemDTO(item *filemgr.TrashItem) trashItemDTO {
+	kind := "file"
+	if item.IsDir {
+		kind = "directory"
+	}
+	return trashItemDTO{
+		TrashID:      strconv.FormatUint(item.TrashID, 10),
+		OriginalPath: item.OriginalPath,
+		Kind:         kind,
+		Size:         item.Size,
+		EntryCount:   item.EntryCount,
+		Principal:    item.Principal,
+		Protocol:     item.Protocol,
+		DeletedAt:    item.DeletedAt,
+		ExpiresAt:    item.ExpiresAt,
+	}
+}
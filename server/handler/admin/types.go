@@ -6,9 +6,12 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/xxxsen/tgfile/archive"
 	"github.com/xxxsen/tgfile/authz"
 	"github.com/xxxsen/tgfile/backupmgr"
+	"github.com/xxxsen/tgfile/fetchmgr"
 	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/sharelink"
 )
 
 const (
@@ -39,6 +42,14 @@ type Options struct {
 	MaxUploadSize    int64
 	MaxPathBytes     int
 	MutationMaxItems int
+	// S3Buckets are the bucket directories whose objects can be presigned.
+	S3Buckets []string
+	// ArchiveLimits bounds the ZIP and TAR directory downloads.
+	ArchiveLimits archive.Limits
+	// ShareLinks manages the public share links; nil hides them.
+	ShareLinks *sharelink.Store
+	// FetchManager runs the remote URL fetch jobs; nil hides them.
+	FetchManager *fetchmgr.Manager
 }
 
 type Handler struct {
@@ -51,6 +62,10 @@ type Handler struct {
 	maxUploadSize    int64
 	maxPathBytes     int
 	mutationMaxItems int
+	s3Buckets        []string
+	archiveLimits    archive.Limits
+	shareLinks       *sharelink.Store
+	fetches          *fetchmgr.Manager
 	sessions         *sessionStore
 	loginLimiter     *loginLimiter
 	dummyPassword    [sha256.Size]byte
@@ -78,11 +93,12 @@ type publicError struct {
 }
 
 type entryDTO struct {
-	Name  string `json:"name"`
-	Path  string `json:"path"`
-	Kind  string `json:"kind"`
-	Size  int64  `json:"size"`
-	Ctime int64  `json:"ctime"`
-	Mtime int64  `json:"mtime"`
-	ETag  string `json:"etag"`
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Kind        string `json:"kind"`
+	Size        int64  `json:"size"`
+	Ctime       int64  `json:"ctime"`
+	Mtime       int64  `json:"mtime"`
+	ETag        string `json:"etag"`
+	ContentType string `json:"content_type,omitempty"`
 }
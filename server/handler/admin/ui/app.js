@@ -4,6 +4,14 @@ const state = {
   path: "/",
   entriesCursor: "",
   jobsCursor: "",
+  trashCursor: "",
+  snapshot: "",
+  snapshotScope: "/",
+  snapshotPath: "/",
+  snapshotCursor: "",
+  versionPath: "/",
+  versionCursor: "",
+  fetchCursor: "",
   activeRequest: null,
   pollStarted: 0,
   pollTimer: 0,
@@ -14,6 +22,11 @@ const loginView = $("login-view");
 const appView = $("app-view");
 const filesView = $("files-view");
 const backupView = $("backup-view");
+const trashView = $("trash-view");
+const snapshotsView = $("snapshots-view");
+const versionsView = $("versions-view");
+const sharesView = $("shares-view");
+const fetchesView = $("fetches-view");
 const statusBox = $("status");
 
 function showStatus(message) {
@@ -60,6 +73,8 @@ function showApp(session) {
   const writable = session.role === "read-write";
   $("upload-label").hidden = !writable;
   $("import-panel").hidden = !writable;
+  $("snapshot-create-panel").hidden = !writable;
+  $("share-create-panel").hidden = !writable;
   void loadEntries(true);
 }
 
@@ -97,27 +112,54 @@ $("logout-button").addEventListener("click", async () => {
 });
 
 function switchTab(tab) {
-  const files = tab === "files";
-  filesView.hidden = !files;
-  backupView.hidden = files;
-  $("files-tab").classList.toggle("active", files);
-  $("backup-tab").classList.toggle("active", !files);
-  $("files-tab").setAttribute("aria-selected", String(files));
-  $("backup-tab").setAttribute("aria-selected", String(!files));
-  if (!files) {
+  const views = {
+    files: filesView, backup: backupView, trash: trashView, snapshots: snapshotsView, versions: versionsView,
+    shares: sharesView, fetches: fetchesView,
+  };
+  for (const [name, view] of Object.entries(views)) {
+    view.hidden = name !== tab;
+    $(`${name}-tab`).classList.toggle("active", name === tab);
+    $(`${name}-tab`).setAttribute("aria-selected", String(name === tab));
+  }
+  if (tab === "backup") {
     $("export-scope").value = state.path;
     void loadJobs(true);
   } else {
     window.clearTimeout(state.pollTimer);
   }
+  if (tab === "trash") void loadTrash(true);
+  if (tab === "snapshots") {
+    $("snapshot-scope").value = state.path;
+    void loadSnapshots();
+  }
+  if (tab === "versions") {
+    $("version-path").value = state.versionPath;
+    void loadVersions(true);
+  }
+  if (tab === "shares") void loadShares();
+  if (tab === "fetches") void loadFetches(true);
 }
 
 $("files-tab").addEventListener("click", () => switchTab("files"));
 $("backup-tab").addEventListener("click", () => switchTab("backup"));
+$("trash-tab").addEventListener("click", () => switchTab("trash"));
+$("snapshots-tab").addEventListener("click", () => switchTab("snapshots"));
+$("versions-tab").addEventListener("click", () => switchTab("versions"));
+$("shares-tab").addEventListener("click", () => switchTab("shares"));
+$("fetches-tab").addEventListener("click", () => switchTab("fetches"));
 $("refresh-files").addEventListener("click", () => void loadEntries(true));
 $("load-more-files").addEventListener("click", () => void loadEntries(false));
 $("refresh-jobs").addEventListener("click", () => void loadJobs(true));
 $("load-more-jobs").addEventListener("click", () => void loadJobs(false));
+$("refresh-trash").addEventListener("click", () => void loadTrash(true));
+$("load-more-trash").addEventListener("click", () => void loadTrash(false));
+$("refresh-snapshots").addEventListener("click", () => void loadSnapshots());
+$("refresh-shares").addEventListener("click", () => void loadShares());
+$("refresh-fetches").addEventListener("click", () => void loadFetches(true));
+$("fetch-state").addEventListener("change", () => void loadFetches(true));
+$("load-more-fetches").addEventListener("click", () => void loadFetches(false));
+$("load-more-snapshot-entries").addEventListener("click", () => void loadSnapshotEntries(false));
+$("load-more-versions").addEventListener("click", () => void loadVersions(false));
 
 function renderBreadcrumbs() {
   const container = $("breadcrumbs");
@@ -166,6 +208,11 @@ async function loadEntries(reset) {
   }
 }
 
+function entryType(item) {
+  if (item.kind === "directory") return "目录";
+  return item.content_type || "文件";
+}
+
 function renderEntry(item) {
   const row = document.createElement("tr");
   const nameCell = document.createElement("td");
@@ -178,11 +225,14 @@ function renderEntry(item) {
     button.addEventListener("click", () => navigate(item.path));
     nameCell.append(button);
   } else {
-    nameCell.textContent = item.name;
+    const name = document.createElement("span");
+    if (state.session?.thumbnails && hasPreview(item.name)) name.append(thumbnailImage(item.path));
+    name.append(item.name);
+    nameCell.append(name);
   }
   const sizeCell = cell(item.kind === "directory" ? "—" : formatBytes(item.size), "大小");
   if (item.kind === "file") sizeCell.title = `${item.size} bytes`;
-  row.append(nameCell, cell(item.kind === "directory" ? "目录" : "文件", "类型"),
+  row.append(nameCell, cell(entryType(item), "类型"),
     sizeCell, cell(formatTime(item.mtime), "修改时间"));
   const actions = document.createElement("td");
   actions.dataset.label = "操作";
@@ -191,11 +241,73 @@ function renderEntry(item) {
     link.href = `/_admin/api/v1/content?${new URLSearchParams({path: item.path})}`;
     link.textContent = "下载";
     actions.append(link);
+    if (isS3Object(item.path)) {
+      const share = document.createElement("button");
+      share.type = "button";
+      share.className = "secondary";
+      share.textContent = "复制分享链接";
+      share.addEventListener("click", () => void copyShareLink(item.path));
+      actions.append(share);
+    }
+    const history = document.createElement("button");
+    history.type = "button";
+    history.className = "secondary";
+    history.textContent = "历史版本";
+    history.addEventListener("click", () => showVersions(item.path));
+    actions.append(history);
+  } else {
+    for (const format of ["zip", "tar"]) {
+      const link = document.createElement("a");
+      link.href = `/_admin/api/v1/archive?${new URLSearchParams({path: item.path, format})}`;
+      link.textContent = `打包下载 ${format.toUpperCase()}`;
+      actions.append(link);
+    }
+  }
+  if (state.session?.role === "read-write") {
+    const share = document.createElement("button");
+    share.type = "button";
+    share.className = "secondary";
+    share.textContent = "创建分享";
+    share.addEventListener("click", () => showShareForm(item));
+    actions.append(share);
   }
   row.append(actions);
   $("entries-body").append(row);
 }
 
+function hasPreview(name) {
+  return /\.(jpe?g|png|gif)$/i.test(name);
+}
+
+function thumbnailImage(path) {
+  const image = document.createElement("img");
+  image.className = "thumbnail";
+  image.alt = "";
+  image.loading = "lazy";
+  image.addEventListener("error", () => image.remove());
+  image.src = `/_admin/api/v1/thumbnail?${new URLSearchParams({path})}`;
+  return image;
+}
+
+function isS3Object(path) {
+  const segments = path.split("/");
+  return segments.length > 2 && (state.session?.s3_buckets || []).includes(segments[1]);
+}
+
+async function copyShareLink(path) {
+  try {
+    const result = await api("/_admin/api/v1/presign", {
+      method: "POST",
+      headers: mutationHeaders({"Content-Type": "application/json"}),
+      body: JSON.stringify({path, method: "GET", expires_seconds: 24 * 3600}),
+    });
+    await navigator.clipboard.writeText(result.url);
+    showStatus("分享链接已复制，24 小时内有效");
+  } catch (error) {
+    showStatus(error.message);
+  }
+}
+
 function cell(value, label = "") {
   const element = document.createElement("td");
   element.textContent = value;
@@ -444,6 +556,500 @@ function scheduleJobPoll(jobs) {
   state.pollTimer = window.setTimeout(() => void loadJobs(true, true), delay);
 }
 
+async function loadTrash(reset) {
+  if (reset) {
+    state.trashCursor = "";
+    $("trash-body").replaceChildren();
+  }
+  const query = new URLSearchParams({limit: "100"});
+  if (state.trashCursor) query.set("cursor", state.trashCursor);
+  try {
+    const data = await api(`/_admin/api/v1/trash?${query}`);
+    $("trash-disabled").hidden = data.enabled;
+    for (const item of data.items) renderTrashItem(item);
+    state.trashCursor = data.next_cursor || "";
+    $("load-more-trash").hidden = !state.trashCursor;
+  } catch (error) {
+    showStatus(error.message);
+  }
+}
+
+function renderTrashItem(item) {
+  const row = document.createElement("tr");
+  const sizeCell = cell(formatBytes(item.size), "大小");
+  sizeCell.title = `${item.entry_count} 项，${item.size} bytes`;
+  row.append(cell(item.original_path, "原路径"), cell(item.kind === "directory" ? "目录" : "文件", "类型"),
+    sizeCell, cell(`${item.principal || "—"} (${item.protocol})`, "删除者"),
+    cell(formatTime(item.deleted_at), "删除时间"), cell(formatTime(item.expires_at), "到期时间"));
+  const actions = document.createElement("td");
+  actions.dataset.label = "操作";
+  if (state.session?.role === "read-write") {
+    const restore = document.createElement("button");
+    restore.type = "button";
+    restore.className = "secondary";
+    restore.textContent = "恢复";
+    restore.addEventListener("click", () => void restoreTrash(item));
+    const purge = document.createElement("button");
+    purge.type = "button";
+    purge.className = "danger";
+    purge.textContent = "彻底删除";
+    purge.addEventListener("click", () => void purgeTrash(item));
+    actions.append(restore, purge);
+  }
+  row.append(actions);
+  $("trash-body").append(row);
+}
+
+async function restoreTrash(item, destination = "") {
+  try {
+    const restored = await api(`/_admin/api/v1/trash/${encodeURIComponent(item.trash_id)}/restore`, {
+      method: "POST",
+      headers: mutationHeaders({"Content-Type": "application/json"}),
+      body: JSON.stringify({path: destination}),
+    });
+    showStatus(`已恢复到 ${restored.original_path}`);
+    await loadTrash(true);
+  } catch (error) {
+    if (error.code !== "destination_exists") return showStatus(error.message);
+    const target = window.prompt(`${destination || item.original_path} 已存在，输入新的恢复路径`, item.original_path);
+    if (target) await restoreTrash(item, target);
+  }
+}
+
+async function purgeTrash(item) {
+  if (!window.confirm(`彻底删除 ${item.original_path}？此操作不能撤销。`)) return;
+  try {
+    await api(`/_admin/api/v1/trash/${encodeURIComponent(item.trash_id)}`,
+      {method: "DELETE", headers: mutationHeaders()});
+    showStatus("已彻底删除");
+    await loadTrash(true);
+  } catch (error) {
+    showStatus(error.message);
+  }
+}
+
+$("snapshot-form").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  try {
+    const created = await api("/_admin/api/v1/snapshots", {
+      method: "POST",
+      headers: mutationHeaders({"Content-Type": "application/json"}),
+      body: JSON.stringify({name: $("snapshot-name").value.trim(), scope: $("snapshot-scope").value.trim()}),
+    });
+    showStatus(`已创建快照 ${created.name}，共 ${created.file_count} 个文件`);
+    $("snapshot-name").value = "";
+    await loadSnapshots();
+  } catch (error) {
+    showStatus(error.message);
+  }
+});
+
+async function loadSnapshots() {
+  try {
+    const data = await api("/_admin/api/v1/snapshots");
+    $("snapshots-disabled").hidden = data.enabled;
+    $("snapshots-body").replaceChildren();
+    for (const item of data.items) renderSnapshot(item);
+    if (state.snapshot && !data.items.some((item) => item.name === state.snapshot)) closeSnapshotBrowser();
+  } catch (error) {
+    showStatus(error.message);
+  }
+}
+
+function renderSnapshot(item) {
+  const row = document.createElement("tr");
+  const contentCell = cell(`${item.file_count} 个文件，${formatBytes(item.total_size)}`, "内容");
+  contentCell.title = `${item.dir_count} 个目录，${item.total_size} bytes`;
+  const origin = item.schedule_name ? `${item.origin}: ${item.schedule_name}` : item.origin;
+  row.append(cell(item.name, "名称"), cell(item.scope, "Scope"), contentCell,
+    cell(item.principal ? `${origin} (${item.principal})` : origin, "来源"),
+    cell(formatTime(item.created_at), "创建时间"), cell(formatTime(item.expires_at), "到期时间"));
+  const actions = document.createElement("td");
+  actions.dataset.label = "操作";
+  const browse = document.createElement("button");
+  browse.type = "button";
+  browse.className = "secondary";
+  browse.textContent = "浏览";
+  browse.addEventListener("click", () => {
+    state.snapshotScope = item.scope;
+    void browseSnapshot(item.name, "/");
+  });
+  actions.append(browse);
+  if (state.session?.role === "read-write") {
+    const restore = document.createElement("button");
+    restore.type = "button";
+    restore.className = "secondary";
+    restore.textContent = "全部恢复";
+    restore.addEventListener("click", () => void restoreSnapshot(item.name, "/", item.scope));
+    const remove = document.createElement("button");
+    remove.type = "button";
+    remove.className = "danger";
+    remove.textContent = "删除";
+    remove.addEventListener("click", () => void deleteSnapshot(item));
+    actions.append(restore, remove);
+  }
+  row.append(actions);
+  $("snapshots-body").append(row);
+}
+
+async function browseSnapshot(name, path) {
+  state.snapshot = name;
+  state.snapshotPath = path;
+  $("snapshot-browser").hidden = false;
+  $("snapshot-browser-title").textContent = `快照 ${name}`;
+  renderSnapshotBreadcrumbs();
+  await loadSnapshotEntries(true);
+}
+
+function closeSnapshotBrowser() {
+  state.snapshot = "";
+  $("snapshot-browser").hidden = true;
+  $("snapshot-entries-body").replaceChildren();
+}
+
+function renderSnapshotBreadcrumbs() {
+  const container = $("snapshot-breadcrumbs");
+  container.replaceChildren();
+  const root = document.createElement("button");
+  root.type = "button";
+  root.textContent = "/";
+  root.addEventListener("click", () => void browseSnapshot(state.snapshot, "/"));
+  container.append(root);
+  let current = "";
+  for (const segment of state.snapshotPath.split("/").filter(Boolean)) {
+    current += `/${segment}`;
+    const target = current;
+    const button = document.createElement("button");
+    button.type = "button";
+    button.textContent = segment;
+    button.addEventListener("click", () => void browseSnapshot(state.snapshot, target));
+    container.append(button);
+  }
+}
+
+async function loadSnapshotEntries(reset) {
+  if (reset) {
+    state.snapshotCursor = "";
+    $("snapshot-entries-body").replaceChildren();
+  }
+  const query = new URLSearchParams({path: state.snapshotPath, limit: "100"});
+  if (state.snapshotCursor) query.set("cursor", state.snapshotCursor);
+  try {
+    const data = await api(`/_admin/api/v1/snapshots/${encodeURIComponent(state.snapshot)}/entries?${query}`);
+    for (const item of data.items) renderSnapshotEntry(item);
+    state.snapshotCursor = data.next_cursor || "";
+    $("load-more-snapshot-entries").hidden = !state.snapshotCursor;
+  } catch (error) {
+    showStatus(error.message);
+  }
+}
+
+function renderSnapshotEntry(item) {
+  const row = document.createElement("tr");
+  const nameCell = document.createElement("td");
+  nameCell.dataset.label = "名称";
+  if (item.kind === "directory") {
+    const button = document.createElement("button");
+    button.type = "button";
+    button.className = "name-button";
+    button.textContent = item.name;
+    button.addEventListener("click", () => void browseSnapshot(state.snapshot, item.path));
+    nameCell.append(button);
+  } else {
+    nameCell.textContent = item.name;
+  }
+  row.append(nameCell, cell(entryType(item), "类型"),
+    cell(item.kind === "directory" ? "—" : formatBytes(item.size), "大小"),
+    cell(formatTime(item.mtime), "修改时间"));
+  const actions = document.createElement("td");
+  actions.dataset.label = "操作";
+  if (item.kind === "file") {
+    const link = document.createElement("a");
+    link.href = `/_admin/api/v1/snapshots/${encodeURIComponent(state.snapshot)}/content?${
+      new URLSearchParams({path: item.path})}`;
+    link.textContent = "下载";
+    actions.append(link);
+  }
+  if (state.session?.role === "read-write") {
+    const restore = document.createElement("button");
+    restore.type = "button";
+    restore.className = "secondary";
+    restore.textContent = "恢复";
+    const original = state.snapshotScope === "/" ? item.path : `${state.snapshotScope}${item.path}`;
+    restore.addEventListener("click", () => void restoreSnapshot(state.snapshot, item.path, original));
+    actions.append(restore);
+  }
+  row.append(actions);
+  $("snapshot-entries-body").append(row);
+}
+
+async function restoreSnapshot(name, path, label, destination = "", overwrite = false) {
+  try {
+    const result = await api(`/_admin/api/v1/snapshots/${encodeURIComponent(name)}/restore`, {
+      method: "POST",
+      headers: mutationHeaders({"Content-Type": "application/json"}),
+      body: JSON.stringify({path, destination, overwrite}),
+    });
+    showStatus(`已恢复到 ${result.destination}：${result.files} 个文件，${result.directories} 个目录`);
+  } catch (error) {
+    if (error.code !== "destination_exists" || overwrite) return showStatus(error.message);
+    if (window.confirm(`${destination || label} 下已有不同的内容，是否用快照覆盖？`)) {
+      await restoreSnapshot(name, path, label, destination, true);
+      return;
+    }
+    const target = window.prompt("输入新的恢复路径", destination || "");
+    if (target) await restoreSnapshot(name, path, label, target);
+  }
+}
+
+async function deleteSnapshot(item) {
+  if (!window.confirm(`删除快照 ${item.name}？此操作不能撤销。`)) return;
+  try {
+    await api(`/_admin/api/v1/snapshots/${encodeURIComponent(item.name)}`,
+      {method: "DELETE", headers: mutationHeaders()});
+    showStatus("快照已删除");
+    await loadSnapshots();
+  } catch (error) {
+    showStatus(error.message);
+  }
+}
+
+function showVersions(path) {
+  state.versionPath = path;
+  switchTab("versions");
+}
+
+$("version-form").addEventListener("submit", (event) => {
+  event.preventDefault();
+  state.versionPath = $("version-path").value.trim();
+  void loadVersions(true);
+});
+
+async function loadVersions(reset) {
+  if (reset) {
+    state.versionCursor = "";
+    $("versions-body").replaceChildren();
+  }
+  const query = new URLSearchParams({path: state.versionPath, limit: "100"});
+  if (state.versionCursor) query.set("cursor", state.versionCursor);
+  try {
+    const data = await api(`/_admin/api/v1/versions?${query}`);
+    $("versions-disabled").hidden = data.enabled;
+    for (const item of data.items) renderVersion(item);
+    state.versionCursor = data.next_cursor || "";
+    $("load-more-versions").hidden = !state.versionCursor;
+  } catch (error) {
+    showStatus(error.message);
+  }
+}
+
+function renderVersion(item) {
+  const row = document.createElement("tr");
+  const sizeCell = cell(formatBytes(item.size), "大小");
+  sizeCell.title = `${item.size} bytes`;
+  row.append(cell(`#${item.version_id}`, "版本"), sizeCell, cell(formatTime(item.mtime), "修改时间"),
+    cell(formatTime(item.created_at), "被覆盖时间"));
+  const actions = document.createElement("td");
+  actions.dataset.label = "操作";
+  const link = document.createElement("a");
+  link.href = `/_admin/api/v1/versions/${encodeURIComponent(item.version_id)}/content`;
+  link.textContent = "下载";
+  actions.append(link);
+  if (state.session?.role === "read-write") {
+    const restore = document.createElement("button");
+    restore.type = "button";
+    restore.className = "secondary";
+    restore.textContent = "恢复";
+    restore.addEventListener("click", () => void restoreVersion(item));
+    const remove = document.createElement("button");
+    remove.type = "button";
+    remove.className = "danger";
+    remove.textContent = "删除";
+    remove.addEventListener("click", () => void deleteVersion(item));
+    actions.append(restore, remove);
+  }
+  row.append(actions);
+  $("versions-body").append(row);
+}
+
+async function restoreVersion(item) {
+  const destination = window.prompt("恢复到以下路径，当前内容会保留为新的历史版本", item.path);
+  if (!destination) return;
+  try {
+    const restored = await api(`/_admin/api/v1/versions/${encodeURIComponent(item.version_id)}/restore`, {
+      method: "POST",
+      headers: mutationHeaders({"Content-Type": "application/json"}),
+      body: JSON.stringify({destination}),
+    });
+    showStatus(`已恢复到 ${restored.path}`);
+    await loadVersions(true);
+  } catch (error) {
+    showStatus(error.message);
+  }
+}
+
+async function deleteVersion(item) {
+  if (!window.confirm(`删除版本 #${item.version_id}？此操作不能撤销。`)) return;
+  try {
+    await api(`/_admin/api/v1/versions/${encodeURIComponent(item.version_id)}`,
+      {method: "DELETE", headers: mutationHeaders()});
+    showStatus("版本已删除");
+    await loadVersions(true);
+  } catch (error) {
+    showStatus(error.message);
+  }
+}
+
+function showShareForm(item) {
+  switchTab("shares");
+  $("share-path").value = item.path;
+  $("share-listing").checked = item.kind === "directory";
+  $("share-created").hidden = true;
+  $("share-path").focus();
+}
+
+$("share-form").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  try {
+    const created = await api("/_admin/api/v1/shares", {
+      method: "POST",
+      headers: mutationHeaders({"Content-Type": "application/json"}),
+      body: JSON.stringify({
+        path: $("share-path").value.trim(),
+        expires_seconds: Math.round(Number($("share-expires").value) * 3600),
+        password: $("share-password").value,
+        max_downloads: Number($("share-max-downloads").value),
+        listing: $("share-listing").checked,
+      }),
+    });
+    const url = `${location.origin}${created.url_path}`;
+    $("share-created").textContent = `分享地址（只显示这一次）：${url}`;
+    $("share-created").hidden = false;
+    $("share-password").value = "";
+    try {
+      await navigator.clipboard.writeText(url);
+      showStatus("分享链接已创建并复制");
+    } catch {
+      showStatus("分享链接已创建，请手动复制");
+    }
+    await loadShares();
+  } catch (error) {
+    showStatus(error.message);
+  }
+});
+
+async function loadShares() {
+  try {
+    const data = await api("/_admin/api/v1/shares");
+    $("shares-disabled").hidden = data.enabled;
+    $("shares-body").replaceChildren();
+    for (const item of data.items) renderShare(item);
+  } catch (error) {
+    showStatus(error.message);
+  }
+}
+
+function renderShare(item) {
+  const row = document.createElement("tr");
+  const pathCell = cell(item.path, "路径");
+  const flags = [item.has_password ? "有密码" : "", item.listing ? "可浏览目录" : ""].filter(Boolean);
+  if (flags.length) pathCell.title = flags.join("，");
+  const downloads = item.max_downloads ? `${item.downloads} / ${item.max_downloads}` : String(item.downloads);
+  row.append(pathCell, cell(item.owner, "创建者"), cell(downloads, "下载"), cell(String(item.views), "浏览"),
+    cell(formatTime(item.created_at), "创建时间"), cell(formatTime(item.expires_at), "到期时间"),
+    cell(shareStatus(item), "状态"));
+  const actions = document.createElement("td");
+  actions.dataset.label = "操作";
+  if (state.session?.role === "read-write" && !item.revoked) {
+    const revoke = document.createElement("button");
+    revoke.type = "button";
+    revoke.className = "danger";
+    revoke.textContent = "撤销";
+    revoke.addEventListener("click", () => void revokeShare(item));
+    actions.append(revoke);
+  }
+  row.append(actions);
+  $("shares-body").append(row);
+}
+
+function shareStatus(item) {
+  if (item.revoked) return "已撤销";
+  if (item.expires_at && new Date(item.expires_at) <= new Date()) return "已过期";
+  if (item.max_downloads && item.downloads >= item.max_downloads) return "次数已用完";
+  return "有效";
+}
+
+async function revokeShare(item) {
+  if (!window.confirm(`撤销 ${item.path} 的分享链接？撤销后链接立即失效。`)) return;
+  try {
+    await api(`/_admin/api/v1/shares/${encodeURIComponent(item.id)}`, {method: "DELETE", headers: mutationHeaders()});
+    showStatus("分享链接已撤销");
+    await loadShares();
+  } catch (error) {
+    showStatus(error.message);
+  }
+}
+
+async function loadFetches(reset) {
+  if (reset) {
+    state.fetchCursor = "";
+    $("fetches-body").replaceChildren();
+  }
+  const query = new URLSearchParams({limit: "50"});
+  if (state.fetchCursor) query.set("cursor", state.fetchCursor);
+  if ($("fetch-state").value) query.set("state", $("fetch-state").value);
+  try {
+    const data = await api(`/_admin/api/v1/fetch/jobs?${query}`);
+    $("fetches-disabled").hidden = data.enabled;
+    for (const job of data.jobs) renderFetch(job);
+    state.fetchCursor = data.next_cursor || "";
+    $("load-more-fetches").hidden = !state.fetchCursor;
+  } catch (error) {
+    showStatus(error.message);
+  }
+}
+
+function renderFetch(job) {
+  const row = document.createElement("tr");
+  const target = job.target.kind === "s3" ? `s3://${job.target.bucket}/${job.target.key}`
+    : job.target.kind === "webdav" ? job.target.path : `file:${job.target.name}`;
+  row.append(cell(job.source_url, "来源"), cell(target, "目标"), cell(job.owner, "用户"),
+    cell(job.idempotency_key, "幂等键"), cell(job.state, "状态"), cell(fetchProgress(job), "进度"),
+    cell(formatTime(job.created_at), "创建时间"));
+  const actions = document.createElement("td");
+  actions.dataset.label = "操作";
+  if (job.cancelable && (state.session?.role === "read-write" || job.owner === state.session?.username)) {
+    const button = document.createElement("button");
+    button.type = "button";
+    button.className = "secondary";
+    button.textContent = "取消";
+    button.addEventListener("click", () => void cancelFetch(job.job_id));
+    actions.append(button);
+  }
+  if (job.error?.message) actions.title = `${job.error.code}: ${job.error.message}`;
+  row.append(actions);
+  $("fetches-body").append(row);
+}
+
+function fetchProgress(job) {
+  const done = job.progress.bytes_completed;
+  const total = job.progress.bytes_total;
+  const attempts = job.progress.attempts > 1 ? `（第 ${job.progress.attempts} 次请求）` : "";
+  if (total > 0) return `${formatBytes(done)} / ${formatBytes(total)}${attempts}`;
+  return `${formatBytes(done)}${attempts}`;
+}
+
+async function cancelFetch(jobID) {
+  try {
+    await api(`/_admin/api/v1/fetch/jobs/${encodeURIComponent(jobID)}/cancel`,
+      {method: "POST", headers: mutationHeaders()});
+    showStatus("已请求取消抓取任务");
+    await loadFetches(true);
+  } catch (error) {
+    showStatus(error.message);
+  }
+}
+
 document.addEventListener("visibilitychange", () => {
   if (!document.hidden && !backupView.hidden) void loadJobs(true);
 });
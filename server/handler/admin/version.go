@@ -0,0 +1,182 @@
+package admin
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+type fileVersionDTO struct {
+	VersionID string `json:"version_id"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	Mtime     int64  `json:"mtime"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type restoreVersionRequest struct {
+	Destination string `json:"destination"`
+}
+
+// listVersions pages the kept versions of one path from the newest. Versions
+// outlive the path, so the path does not have to exist.
+func (h *Handler) listVersions(c *gin.Context) {
+	if _, ok := h.principal(c); !ok {
+		h.writePublicError(c, http.StatusUnauthorized, "unauthenticated", "请重新登录", nil)
+		return
+	}
+	query, ok := h.parseQuery(c, "path", "limit", "cursor")
+	if !ok {
+		return
+	}
+	resourcePath, ok := h.parsePath(c, query.Get("path"))
+	if !ok {
+		return
+	}
+	limit, err := parsePositiveInt(query.Get("limit"), 50, 200)
+	if err != nil {
+		h.writePublicError(c, http.StatusBadRequest, "invalid_request", "分页大小无效", err)
+		return
+	}
+	var cursor uint64
+	if value := query.Get("cursor"); value != "" {
+		if cursor, err = strconv.ParseUint(value, 10, 64); err != nil || cursor == 0 {
+			h.writePublicError(c, http.StatusBadRequest, "invalid_cursor", "分页游标无效", err)
+			return
+		}
+	}
+	page, err := h.files.ListFileVersions(c.Request.Context(), filemgr.FileVersionListRequest{
+		Path:   resourcePath,
+		Cursor: cursor,
+		Limit:  limit,
+	})
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	items := make([]fileVersionDTO, 0, len(page.Versions))
+	for _, version := range page.Versions {
+		items = append(items, toFileVersionDTO(&version))
+	}
+	next := ""
+	if page.NextCursor != 0 {
+		next = strconv.FormatUint(page.NextCursor, 10)
+	}
+	h.writeData(c, http.StatusOK, map[string]any{
+		"enabled":     h.files.VersionsEnabled(),
+		"path":        resourcePath,
+		"items":       items,
+		"next_cursor": next,
+	})
+}
+
+func (h *Handler) downloadVersion(c *gin.Context) {
+	versionID, ok := h.parseVersionID(c)
+	if !ok {
+		return
+	}
+	version, err := h.files.GetFileVersion(c.Request.Context(), versionID)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	h.serveFile(c, &entity.FileLinkMeta{
+		FileName: path.Base(version.Path),
+		FileId:   version.FileID,
+		FileSize: version.Size,
+		Mtime:    version.Mtime,
+	})
+}
+
+// restoreVersion publishes a version at the path it was taken from, or at
+// the destination in the request body. The content it replaces is kept as a
+// version in turn.
+func (h *Handler) restoreVersion(c *gin.Context) {
+	user, ok := h.requireWrite(c)
+	if !ok || !h.requireMutation(c, user) {
+		return
+	}
+	versionID, ok := h.parseVersionID(c)
+	if !ok {
+		return
+	}
+	if c.ContentType() != "application/json" {
+		h.writePublicError(c, http.StatusBadRequest, "invalid_request", "请求格式无效", nil)
+		return
+	}
+	var request restoreVersionRequest
+	if err := decodeStrictJSON(c.Request.Body, 16*1024, &request); err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	destination := request.Destination
+	if destination == "" {
+		version, err := h.files.GetFileVersion(c.Request.Context(), versionID)
+		if err != nil {
+			h.writeMappedError(c, err)
+			return
+		}
+		destination = version.Path
+	}
+	destination, ok = h.parsePath(c, destination)
+	if !ok {
+		return
+	}
+	setAuditPath(c, destination)
+	result, err := h.files.RestoreFileVersion(
+		c.Request.Context(),
+		versionID,
+		destination,
+		filemgr.WebDAVMutationOptions{Principal: user.Username, Admin: true, MaxEntries: h.mutationMaxItems},
+	)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	h.writeData(c, http.StatusOK, h.entry(destination, result.Link))
+}
+
+func (h *Handler) deleteVersion(c *gin.Context) {
+	user, ok := h.requireWrite(c)
+	if !ok || !h.requireMutation(c, user) {
+		return
+	}
+	versionID, ok := h.parseVersionID(c)
+	if !ok {
+		return
+	}
+	version, err := h.files.DeleteFileVersion(c.Request.Context(), versionID)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	setAuditPath(c, version.Path)
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) parseVersionID(c *gin.Context) (uint64, bool) {
+	if _, ok := h.parseQuery(c); !ok {
+		return 0, false
+	}
+	versionID, err := strconv.ParseUint(c.Param("version_id"), 10, 64)
+	if err != nil || versionID == 0 {
+		h.writePublicError(c, http.StatusNotFound, "not_found", "资源不存在", nil)
+		return 0, false
+	}
+	return versionID, true
+}
+
+func toFileVersionDTO(version *filemgr.FileVersion) fileVersionDTO {
+	return fileVersionDTO{
+		VersionID: strconv.FormatUint(version.VersionID, 10),
+		Path:      version.Path,
+		Size:      version.Size,
+		Mtime:     version.Mtime,
+		CreatedAt: version.CreatedAt,
+	}
+}
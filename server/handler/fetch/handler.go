@@ -0,0 +1,243 @@
+package fetch
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xxxsen/common/webapi/proxyutil"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/fetchmgr"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+var (
+	errTrailingJSON  = errors.New("request contains trailing JSON")
+	errInvalidCursor = errors.New("invalid fetch job cursor")
+)
+
+// Handler serves the fetch job API. Every principal sees only the jobs it
+// created; the admin API lists all of them.
+type Handler struct {
+	manager    *fetchmgr.Manager
+	authorizer *authz.Authorizer
+	webdav     filemgr.IWebDAVTargetResolver
+}
+
+// New returns a handler for manager. A nil resolver refuses WebDAV targets.
+func New(manager *fetchmgr.Manager, authorizer *authz.Authorizer, resolver filemgr.IWebDAVTargetResolver) *Handler {
+	return &Handler{manager: manager, authorizer: authorizer, webdav: resolver}
+}
+
+type createRequest struct {
+	SourceURL string            `json:"source_url"`
+	Headers   map[string]string `json:"headers"`
+	Target    fetchmgr.Target   `json:"target"`
+}
+
+type jobPage struct {
+	Jobs       []*fetchmgr.Job `json:"jobs"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+type jobCursor struct {
+	CreatedAt int64  `json:"created_at"`
+	JobID     string `json:"job_id"`
+}
+
+func (h *Handler) Create(c *gin.Context) {
+	owner, ok := principal(c)
+	if !ok {
+		return
+	}
+	var body createRequest
+	if err := decodeJSON(c.Request.Body, &body); err != nil {
+		writeError(c, fmt.Errorf("%w: %w", fetchmgr.ErrInvalidRequest, err))
+		return
+	}
+	request := fetchmgr.CreateRequest{
+		Owner:          owner,
+		IdempotencyKey: c.GetHeader("Idempotency-Key"),
+		SourceURL:      body.SourceURL,
+		Headers:        body.Headers,
+		Target:         body.Target,
+	}
+	if !h.authorizeTarget(c, &request) {
+		return
+	}
+	job, err := h.manager.Create(c.Request.Context(), request)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+// authorizeTarget checks the permission the target needs on top of
+// file:write and resolves a WebDAV target into the namespace.
+func (h *Handler) authorizeTarget(c *gin.Context, request *fetchmgr.CreateRequest) bool {
+	switch request.Target.Kind {
+	case fetchmgr.TargetS3:
+		if !h.authorizer.Has(request.Owner, authz.S3Write) {
+			c.Status(http.StatusForbidden)
+			return false
+		}
+	case fetchmgr.TargetWebDAV:
+		if h.webdav == nil {
+			writeError(c, fetchmgr.ErrInvalidRequest)
+			return false
+		}
+		resolved, scope, err := h.webdav.ResolveTarget(c.Request.Context(), request.Owner, request.Target.Path)
+		switch {
+		case errors.Is(err, filemgr.ErrWebDAVTargetForbidden):
+			c.Status(http.StatusForbidden)
+			return false
+		case errors.Is(err, filemgr.ErrWebDAVTarget):
+			writeError(c, fetchmgr.ErrInvalidRequest)
+			return false
+		case err != nil:
+			writeError(c, err)
+			return false
+		}
+		request.Target.Path, request.WebDAV = resolved, scope
+	}
+	return true
+}
+
+func (h *Handler) List(c *gin.Context) {
+	owner, ok := principal(c)
+	if !ok {
+		return
+	}
+	request := fetchmgr.ListJobsRequest{Owner: owner, State: c.Query("state"), Limit: defaultPageSize}
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			writeError(c, fetchmgr.ErrInvalidRequest)
+			return
+		}
+		request.Limit = limit
+	}
+	if raw := c.Query("cursor"); raw != "" {
+		cursor, err := decodeCursor(raw)
+		if err != nil {
+			writeError(c, fmt.Errorf("%w: %w", fetchmgr.ErrInvalidRequest, err))
+			return
+		}
+		request.Cursor = cursor
+	}
+	page, err := h.manager.ListJobs(c.Request.Context(), request)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	response := jobPage{Jobs: page.Jobs}
+	if page.NextCursor != nil {
+		response.NextCursor = encodeCursor(page.NextCursor)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *Handler) GetJob(c *gin.Context) {
+	job, ok := h.ownedJob(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+func (h *Handler) Cancel(c *gin.Context) {
+	if _, ok := h.ownedJob(c); !ok {
+		return
+	}
+	job, err := h.manager.Cancel(c.Request.Context(), c.Param("job_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+// ownedJob loads the job of the request path. A job of another principal is
+// reported as missing.
+func (h *Handler) ownedJob(c *gin.Context) (*fetchmgr.Job, bool) {
+	owner, ok := principal(c)
+	if !ok {
+		return nil, false
+	}
+	job, err := h.manager.GetJob(c.Request.Context(), c.Param("job_id"))
+	if err == nil && job.Owner != owner {
+		err = fetchmgr.ErrJobNotFound
+	}
+	if err != nil {
+		writeError(c, err)
+		return nil, false
+	}
+	return job, true
+}
+
+func principal(c *gin.Context) (string, bool) {
+	user, ok := proxyutil.GetUserInfo(c.Request.Context())
+	if !ok {
+		c.Status(http.StatusUnauthorized)
+		return "", false
+	}
+	return user.Username, true
+}
+
+func decodeJSON(reader io.Reader, output any) error {
+	decoder := json.NewDecoder(io.LimitReader(reader, 64*1024))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(output); err != nil {
+		return fmt.Errorf("decode request: %w", err)
+	}
+	var extra any
+	if err := decoder.Decode(&extra); !errors.Is(err, io.EOF) {
+		return errTrailingJSON
+	}
+	return nil
+}
+
+func encodeCursor(cursor *fetchmgr.JobCursor) string {
+	raw, _ := json.Marshal(jobCursor{CreatedAt: cursor.CreatedAt, JobID: cursor.JobID})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(value string) (*fetchmgr.JobCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cursor jobCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, errInvalidCursor
+	}
+	return &fetchmgr.JobCursor{CreatedAt: cursor.CreatedAt, JobID: cursor.JobID}, nil
+}
+
+func writeError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	message := "fetch operation failed"
+	switch {
+	case errors.Is(err, fetchmgr.ErrInvalidRequest):
+		status, message = http.StatusBadRequest, "invalid fetch request"
+	case errors.Is(err, fetchmgr.ErrIdempotencyConflict):
+		status, message = http.StatusConflict, "idempotency key conflicts with an existing request"
+	case errors.Is(err, fetchmgr.ErrJobNotFound):
+		status, message = http.StatusNotFound, "fetch job not found"
+	case errors.Is(err, fetchmgr.ErrJobNotCancelable):
+		status, message = http.StatusConflict, "fetch job can no longer be canceled"
+	}
+	c.JSON(status, gin.H{"error": message})
+}
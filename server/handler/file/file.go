@@ -1,13 +1,38 @@
 package file
 
-import "github.com/xxxsen/tgfile/filemgr"
+import (
+	"github.com/xxxsen/tgfile/archive"
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+// UploadOptions bounds the raw-body uploads. A body sent without a length is
+// spooled to TempDir, or the system temporary directory when it is empty.
+type UploadOptions struct {
+	MaxUploadSize int64
+	TempDir       string
+}
 
 type FileHandler struct {
-	m filemgr.IFileManager
+	m             filemgr.IFileManager
+	archiveLimits archive.Limits
+	uploads       UploadOptions
+	authorizer    *authz.Authorizer
 }
 
-func NewFileHandler(m filemgr.IFileManager) *FileHandler {
+func NewFileHandler(
+	m filemgr.IFileManager,
+	archiveLimits archive.Limits,
+	uploads UploadOptions,
+	authorizer *authz.Authorizer,
+) *FileHandler {
+	if uploads.MaxUploadSize <= 0 {
+		uploads.MaxUploadSize = defaultMaxUploadSize
+	}
 	return &FileHandler{
-		m: m,
+		m:             m,
+		archiveLimits: archiveLimits,
+		uploads:       uploads,
+		authorizer:    authorizer,
 	}
 }
@@ -0,0 +1,74 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/xxxsen/common/logutil"
+	"github.com/xxxsen/common/webapi/proxyutil"
+	"go.uber.org/zap"
+
+	"github.com/xxxsen/tgfile/archive"
+	"github.com/xxxsen/tgfile/filekey"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errNoArchiveKey = errors.New("no file key given")
+
+// FileArchive bundles several uploaded files into one ZIP or TAR download.
+// Like the single download it needs no login, since every key already grants
+// access to its file. Each file is named after its original name; a name
+// that repeats keeps the whole key.
+func (h *FileHandler) FileArchive(c *gin.Context) {
+	ctx := c.Request.Context()
+	format, err := archive.ParseFormat(c.Query("format"))
+	if err != nil {
+		proxyutil.FailJson(c, http.StatusBadRequest, err)
+		return
+	}
+	keys := c.QueryArray("key")
+	if len(keys) == 0 {
+		proxyutil.FailJson(c, http.StatusBadRequest, errNoArchiveKey)
+		return
+	}
+	if len(keys) > h.archiveLimits.WithDefaults().MaxEntries {
+		proxyutil.FailJson(c, http.StatusRequestEntityTooLarge, archive.ErrTooManyEntries)
+		return
+	}
+	entries := make([]archive.Entry, 0, len(keys))
+	names := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		parsed, err := filekey.Parse(key)
+		if err != nil {
+			proxyutil.FailJson(c, http.StatusBadRequest, fmt.Errorf("invalid fkey, err:%w", err))
+			return
+		}
+		link, err := h.m.ResolveFileKey(ctx, key)
+		if err != nil {
+			proxyutil.FailJson(c, fileKeyStatus(err), fmt.Errorf("invalid archive key, key:%s, err:%w", key, err))
+			return
+		}
+		finfo, err := h.m.StatFileLink(ctx, link)
+		if err != nil {
+			proxyutil.FailJson(c, http.StatusBadRequest, fmt.Errorf("invalid archive key, key:%s, err:%w", key, err))
+			return
+		}
+		name := parsed.Name
+		if _, exists := names[name]; exists {
+			name = key
+		}
+		names[name] = struct{}{}
+		entries = append(entries, archive.Entry{
+			Name: name, FileID: finfo.FileId, Size: finfo.FileSize, Mtime: finfo.Mtime,
+		})
+	}
+	if err := h.archiveLimits.Check(entries); err != nil {
+		proxyutil.FailJson(c, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	if err := archive.Serve(c.Writer, c.Request, "files."+string(format), format, h.m, entries); err != nil {
+		logutil.GetLogger(ctx).Error("stream file archive failed", zap.Int("files", len(entries)), zap.Error(err))
+	}
+}
@@ -1,36 +1,31 @@
 package file
 
 import (
-	"encoding/hex"
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"os"
 	"path"
 	"regexp"
 
-	"github.com/xxxsen/tgfile/utils"
+	"github.com/xxxsen/common/webapi/proxyutil"
+
+	"github.com/xxxsen/tgfile/filekey"
 )
 
 const (
-	defaultUploadPrefix           = "/defaults/"
-	defaultMaxAllowFileNameLength = 128
+	defaultMaxAllowFileNameLength = filekey.MaxNameLength
 	defaultMaxAllowExtLength      = 16
-	fileKeyHashLength             = 16
-	maxFileKeyLength              = fileKeyHashLength + 1 + defaultMaxAllowFileNameLength
 )
 
-var (
-	errInvalidFileKeyLength    = errors.New("invalid file key length")
-	errInvalidFileKeySeparator = errors.New("invalid file key separator")
-	errInvalidFileKeyHash      = errors.New("invalid file key hash")
-	errInvalidFileKeySuffix    = errors.New("invalid file key suffix")
-	defaultFileNameCleaner     = regexp.MustCompile(`[\\/:*?"<>|+#%{}'&$@!~\(\)\[\]^` + "`" + ` ]`)
-)
+var defaultFileNameCleaner = regexp.MustCompile(`[\x00-\x1f\x7f\\/:*?"<>|+#%{}'&$@!~\(\)\[\]^` + "`" + ` ]`)
 
-func (h *FileHandler) removeInvalidChar(name string) string {
+func removeInvalidChar(name string) string {
 	return defaultFileNameCleaner.ReplaceAllString(name, "")
 }
 
-func (h *FileHandler) tryCutBaseName(base string) string {
+func tryCutBaseName(base string) string {
 	// 尽可能地保持extname
 	if len(base) <= defaultMaxAllowFileNameLength {
 		return base
@@ -44,38 +39,38 @@ func (h *FileHandler) tryCutBaseName(base string) string {
 	return name + ext
 }
 
-func (h *FileHandler) buildFileKeyLink(filename string, fileid uint64) (string, string) {
-	fkey := hex.EncodeToString(utils.FileIdToHash(fileid))
-	p1 := fkey[:2]
-	base := path.Base(filename)
-	base = h.tryCutBaseName(h.removeInvalidChar(base))
-	fkey = fkey + "-" + base
-	link := path.Join(defaultUploadPrefix, p1, fkey)
-	return link, fkey
+// KeyFileName is the name part of the key issued for an upload called
+// filename.
+func KeyFileName(filename string) string {
+	return tryCutBaseName(removeInvalidChar(path.Base(filename)))
 }
 
-func (h *FileHandler) extractLinkFromFileKey(fkey string) (string, error) {
-	return ExtractLinkFromFileKey(fkey)
+// fileKeyStatus answers malformed, unknown and revoked keys alike, so a
+// response does not tell a revoked key from one that never existed.
+func fileKeyStatus(err error) int {
+	if errors.Is(err, filekey.ErrInvalidKey) || errors.Is(err, os.ErrNotExist) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
 }
 
+// ExtractLinkFromFileKey returns the link a legacy or token key maps to. It
+// only checks the syntax; whether a token key was issued or revoked is
+// recorded in the database.
 func ExtractLinkFromFileKey(fkey string) (string, error) {
-	if len(fkey) < fileKeyHashLength+1 || len(fkey) > maxFileKeyLength {
-		return "", fmt.Errorf("%w: %d", errInvalidFileKeyLength, len(fkey))
-	}
-	if fkey[fileKeyHashLength] != '-' {
-		return "", errInvalidFileKeySeparator
+	key, err := filekey.Parse(fkey)
+	if err != nil {
+		return "", fmt.Errorf("parse file key: %w", err)
 	}
-	for i := 0; i < fileKeyHashLength; i++ {
-		value := fkey[i]
-		if (value < '0' || value > '9') && (value < 'a' || value > 'f') {
-			return "", fmt.Errorf("%w at offset %d", errInvalidFileKeyHash, i)
-		}
-	}
-	for i := fileKeyHashLength + 1; i < len(fkey); i++ {
-		value := fkey[i]
-		if value == '/' || value == '\\' || value == 0 || value < 0x20 || value == 0x7f {
-			return "", fmt.Errorf("%w at offset %d", errInvalidFileKeySuffix, i)
-		}
+	return key.Link(), nil
+}
+
+// uploader is the principal a request acts for, recorded as the owner of the
+// keys it issues.
+func uploader(ctx context.Context) string {
+	user, ok := proxyutil.GetUserInfo(ctx)
+	if !ok {
+		return ""
 	}
-	return defaultUploadPrefix + fkey[:2] + "/" + fkey, nil
+	return user.Username
 }
@@ -0,0 +1,77 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xxxsen/common/logutil"
+	"github.com/xxxsen/common/webapi/proxyutil"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/filekey"
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/server/model"
+)
+
+var errInvalidDeleteRequest = errors.New("invalid delete request")
+
+// FileDelete removes the upload a direct-download key serves, through the
+// recycle bin when it is enabled. With file:write a principal deletes its
+// own uploads only; admin:write deletes any of them.
+func (h *FileHandler) FileDelete(c *gin.Context) {
+	ctx, owner := h.deleteContext(c.Request.Context())
+	h.writeDeleteResult(ctx, c, h.m.DeleteFileKey(ctx, c.Param("key"), owner))
+}
+
+// FileDeleteLink removes an upload by the link FileList names it by, with
+// the owner check of FileDelete.
+func (h *FileHandler) FileDeleteLink(ctx context.Context, c *gin.Context, request any) {
+	req, ok := request.(*model.DeleteFileLinkRequest)
+	if !ok {
+		proxyutil.FailJson(c, http.StatusInternalServerError, errInvalidDeleteRequest)
+		return
+	}
+	ctx, owner := h.deleteContext(ctx)
+	h.writeDeleteResult(ctx, c, h.m.DeleteFileKeyLink(ctx, req.Link, owner))
+}
+
+// deleteContext returns the owner a deletion is limited to, empty for
+// admin:write, and ctx recording the principal as the origin of the trash
+// entry.
+func (h *FileHandler) deleteContext(ctx context.Context) (context.Context, string) {
+	user := uploader(ctx)
+	owner := user
+	if h.authorizer.Has(user, authz.AdminWrite) {
+		owner = ""
+	}
+	return filemgr.ContextWithTrashOrigin(ctx, filemgr.TrashOrigin{
+		Principal: user,
+		Protocol:  filemgr.TrashProtocolFile,
+	}), owner
+}
+
+func (h *FileHandler) writeDeleteResult(ctx context.Context, c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, filekey.ErrInvalidKey):
+		proxyutil.FailJson(c, http.StatusBadRequest, fmt.Errorf("invalid fkey, err:%w", err))
+		return
+	case errors.Is(err, filemgr.ErrInvalidFileKeyLink):
+		proxyutil.FailJson(c, http.StatusBadRequest, fmt.Errorf("invalid link, err:%w", err))
+		return
+	case errors.Is(err, filemgr.ErrFileKeyNotOwned):
+		proxyutil.FailJson(c, http.StatusForbidden, fmt.Errorf("delete file failed, err:%w", err))
+		return
+	case errors.Is(err, os.ErrNotExist):
+		proxyutil.FailJson(c, http.StatusNotFound, fmt.Errorf("file not found, err:%w", err))
+		return
+	case err != nil:
+		proxyutil.FailJson(c, http.StatusInternalServerError, fmt.Errorf("delete file failed, err:%w", err))
+		return
+	}
+	logutil.GetLogger(ctx).Info("delete file succ")
+	proxyutil.SuccessJson(c, nil)
+}
@@ -16,9 +16,9 @@ import (
 func (h *FileHandler) FileDownload(c *gin.Context) {
 	ctx := c.Request.Context()
 	key := c.Param("key")
-	path, err := h.extractLinkFromFileKey(key)
+	path, err := h.m.ResolveFileKey(ctx, key)
 	if err != nil {
-		proxyutil.FailJson(c, http.StatusBadRequest, fmt.Errorf("invalid fkey, err:%w", err))
+		proxyutil.FailJson(c, fileKeyStatus(err), fmt.Errorf("invalid fkey, err:%w", err))
 		return
 	}
 	finfo, err := h.m.StatFileLink(ctx, path)
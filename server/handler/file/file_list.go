@@ -0,0 +1,181 @@
+package file
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xxxsen/common/webapi/proxyutil"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/server/httpkit"
+	"github.com/xxxsen/tgfile/server/model"
+)
+
+const (
+	defaultFileListLimit = 100
+	maxFileListLimit     = 500
+	maxFileListCursor    = 2048
+)
+
+var (
+	errInvalidFileListLimit  = errors.New("invalid limit")
+	errInvalidFileListCursor = errors.New("invalid cursor")
+	errFileListOwner         = errors.New("listing the uploads of another principal needs admin:write")
+)
+
+type fileListCursor struct {
+	Version int               `json:"v"`
+	Dir     fileListPosition  `json:"dir"`
+	Entry   *fileListPosition `json:"entry,omitempty"`
+}
+
+type fileListPosition struct {
+	ParentEntryID uint64 `json:"parent_entry_id"`
+	IsDir         bool   `json:"is_dir"`
+	Name          string `json:"name"`
+	EntryID       uint64 `json:"entry_id"`
+}
+
+// FileList pages through direct uploads. With file:write a principal sees
+// its own uploads; admin:write sees everyone's, or those of ?owner=. Keys
+// are only stored by digest, so uploads are named by their link, which
+// FileDeleteLink accepts. A page may be short while next_cursor is set; the
+// listing ends when next_cursor is empty.
+func (h *FileHandler) FileList(c *gin.Context) {
+	ctx := c.Request.Context()
+	user := uploader(ctx)
+	owner := c.Query("owner")
+	switch {
+	case h.authorizer.Has(user, authz.AdminWrite):
+	case owner == "" || owner == user:
+		owner = user
+	default:
+		proxyutil.FailJson(c, http.StatusForbidden, errFileListOwner)
+		return
+	}
+	limit, err := parseFileListLimit(c.Query("limit"))
+	if err != nil {
+		proxyutil.FailJson(c, http.StatusBadRequest, err)
+		return
+	}
+	decoded, hasCursor, err := decodeFileListCursor(c.Query("cursor"))
+	if err != nil {
+		proxyutil.FailJson(c, http.StatusBadRequest, err)
+		return
+	}
+	var cursor *filemgr.FileKeyPageCursor
+	if hasCursor {
+		cursor = &decoded
+	}
+	page, err := h.m.ListFileKeysPage(ctx, filemgr.FileKeyPageRequest{Owner: owner, Cursor: cursor, Limit: limit})
+	switch {
+	case errors.Is(err, filemgr.ErrFileLinkCursorStale), errors.Is(err, filemgr.ErrInvalidFileKeyPage):
+		proxyutil.FailJson(c, http.StatusBadRequest, fmt.Errorf("%w: %w", errInvalidFileListCursor, err))
+		return
+	case err != nil:
+		proxyutil.FailJson(c, http.StatusInternalServerError, fmt.Errorf("list file failed, err:%w", err))
+		return
+	}
+	items := make([]*model.FileListItem, 0, len(page.Items))
+	for _, item := range page.Items {
+		items = append(items, &model.FileListItem{
+			Link:        item.Link,
+			Name:        item.Name,
+			Owner:       item.Owner,
+			Revoked:     item.Revoked,
+			FileSize:    item.FileSize,
+			ContentType: httpkit.ContentType(item.FileLinkMeta),
+			Ctime:       item.Ctime,
+			Mtime:       item.Mtime,
+		})
+	}
+	next, err := encodeFileListCursor(page.NextCursor)
+	if err != nil {
+		proxyutil.FailJson(c, http.StatusInternalServerError, err)
+		return
+	}
+	proxyutil.SuccessJson(c, &model.ListFileResponse{Items: items, NextCursor: next})
+}
+
+func parseFileListLimit(value string) (int, error) {
+	if value == "" {
+		return defaultFileListLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > maxFileListLimit {
+		return 0, errInvalidFileListLimit
+	}
+	return limit, nil
+}
+
+func decodeFileListCursor(value string) (filemgr.FileKeyPageCursor, bool, error) {
+	if value == "" {
+		return filemgr.FileKeyPageCursor{}, false, nil
+	}
+	if len(value) > maxFileListCursor {
+		return filemgr.FileKeyPageCursor{}, false, errInvalidFileListCursor
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return filemgr.FileKeyPageCursor{}, false, errInvalidFileListCursor
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	var cursor fileListCursor
+	if err := decoder.Decode(&cursor); err != nil ||
+		cursor.Version != 1 || !cursor.Dir.valid() ||
+		(cursor.Entry != nil && !cursor.Entry.valid()) {
+		return filemgr.FileKeyPageCursor{}, false, errInvalidFileListCursor
+	}
+	result := filemgr.FileKeyPageCursor{Dir: cursor.Dir.pageCursor()}
+	if cursor.Entry != nil {
+		entry := cursor.Entry.pageCursor()
+		result.Entry = &entry
+	}
+	return result, true, nil
+}
+
+func encodeFileListCursor(cursor *filemgr.FileKeyPageCursor) (string, error) {
+	if cursor == nil {
+		return "", nil
+	}
+	value := fileListCursor{Version: 1, Dir: newFileListPosition(cursor.Dir)}
+	if cursor.Entry != nil {
+		entry := newFileListPosition(*cursor.Entry)
+		value.Entry = &entry
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("encode file list cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func newFileListPosition(cursor filemgr.FileLinkPageCursor) fileListPosition {
+	return fileListPosition{
+		ParentEntryID: cursor.ParentEntryID,
+		IsDir:         cursor.IsDir,
+		Name:          cursor.Name,
+		EntryID:       cursor.EntryID,
+	}
+}
+
+func (p fileListPosition) valid() bool {
+	return p.ParentEntryID != 0 && p.Name != "" && p.EntryID != 0
+}
+
+func (p fileListPosition) pageCursor() filemgr.FileLinkPageCursor {
+	return filemgr.FileLinkPageCursor{
+		ParentEntryID: p.ParentEntryID,
+		IsDir:         p.IsDir,
+		Name:          p.Name,
+		EntryID:       p.EntryID,
+	}
+}
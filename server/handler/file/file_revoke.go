@@ -0,0 +1,42 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xxxsen/common/logutil"
+	"github.com/xxxsen/common/webapi/proxyutil"
+
+	"github.com/xxxsen/tgfile/filekey"
+	"github.com/xxxsen/tgfile/server/model"
+)
+
+var errInvalidRevokeRequest = errors.New("invalid revoke request")
+
+// FileRevoke stops a direct-download key from resolving. The file stays
+// linked, so other keys issued for it keep working.
+func (h *FileHandler) FileRevoke(ctx context.Context, c *gin.Context, request any) {
+	req, ok := request.(*model.RevokeFileKeyRequest)
+	if !ok {
+		proxyutil.FailJson(c, http.StatusInternalServerError, errInvalidRevokeRequest)
+		return
+	}
+	err := h.m.RevokeFileKey(ctx, req.Key)
+	switch {
+	case errors.Is(err, filekey.ErrInvalidKey):
+		proxyutil.FailJson(c, http.StatusBadRequest, fmt.Errorf("invalid fkey, err:%w", err))
+		return
+	case errors.Is(err, os.ErrNotExist):
+		proxyutil.FailJson(c, http.StatusNotFound, fmt.Errorf("file key not found, err:%w", err))
+		return
+	case err != nil:
+		proxyutil.FailJson(c, http.StatusInternalServerError, fmt.Errorf("revoke file key failed, err:%w", err))
+		return
+	}
+	logutil.GetLogger(ctx).Info("revoke file key succ")
+	proxyutil.SuccessJson(c, nil)
+}
@@ -0,0 +1,52 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/xxxsen/common/webapi/proxyutil"
+
+	"github.com/xxxsen/tgfile/filemgr"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FileThumbnail serves the JPEG preview of an uploaded image. The preview of
+// a key never changes, so it is cached like the download and revalidated by
+// the preview file id.
+func (h *FileHandler) FileThumbnail(c *gin.Context) {
+	ctx := c.Request.Context()
+	key := c.Param("key")
+	link, err := h.m.ResolveFileKey(ctx, key)
+	if err != nil {
+		proxyutil.FailJson(c, fileKeyStatus(err), fmt.Errorf("invalid fkey, err:%w", err))
+		return
+	}
+	finfo, err := h.m.StatFileLink(ctx, link)
+	if err != nil {
+		proxyutil.FailJson(c, http.StatusBadRequest, fmt.Errorf("invalid thumb key, key:%s, err:%w", key, err))
+		return
+	}
+	thumbnail, err := h.m.GetThumbnail(ctx, finfo.FileId)
+	if errors.Is(err, filemgr.ErrThumbnailUnsupported) || errors.Is(err, os.ErrNotExist) {
+		proxyutil.FailJson(c, http.StatusNotFound, fmt.Errorf("no thumbnail, key:%s, err:%w", key, err))
+		return
+	}
+	if err != nil {
+		proxyutil.FailJson(c, http.StatusInternalServerError, fmt.Errorf("render thumbnail failed, err:%w", err))
+		return
+	}
+	file, err := h.m.OpenFile(ctx, thumbnail.FileID)
+	if err != nil {
+		proxyutil.FailJson(c, http.StatusInternalServerError, fmt.Errorf("open thumbnail failed, err:%w", err))
+		return
+	}
+	defer logCloseError(ctx, file, "close thumbnail file")
+	c.Writer.Header().Set("Content-Type", "image/jpeg")
+	c.Writer.Header().Set("Cache-Control", "public, max-age=604800")
+	c.Writer.Header().Set("ETag", fmt.Sprintf("\"thumb-%d\"", thumbnail.FileID))
+	http.ServeContent(c.Writer, c.Request, "", time.UnixMilli(thumbnail.CreatedAt), file)
+}
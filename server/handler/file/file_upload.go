@@ -12,6 +12,7 @@ import (
 	"github.com/xxxsen/common/webapi/proxyutil"
 	"go.uber.org/zap"
 
+	"github.com/xxxsen/tgfile/filemgr"
 	"github.com/xxxsen/tgfile/server/model"
 
 	"github.com/gin-gonic/gin"
@@ -32,29 +33,53 @@ func (h *FileHandler) FileUpload(ctx context.Context, c *gin.Context, request an
 		return
 	}
 	defer logCloseError(ctx, file, "close uploaded file")
-	fileid, err := h.m.CreateFile(ctx, header.Size, file)
+	digests := newUploadDigests()
+	fileid, err := h.m.CreateFile(ctx, header.Size, io.TeeReader(file, digests))
 	if err != nil {
 		proxyutil.FailJson(c, http.StatusInternalServerError, fmt.Errorf("upload file fail, err:%w", err))
 		return
 	}
-	path, key := h.buildFileKeyLink(header.Filename, fileid)
-	if err := h.m.CreateFileLink(ctx, path, fileid, header.Size, false); err != nil {
-		cleanupContext, cancel := context.WithTimeout(context.WithoutCancel(ctx), 15*time.Second)
-		cleanupErr := h.m.DiscardUnpublishedFile(cleanupContext, fileid)
-		cancel()
-		proxyutil.FailJson(
-			c,
-			http.StatusInternalServerError,
-			fmt.Errorf("create link failed: %w", errors.Join(err, cleanupErr)),
-		)
+	h.publishUpload(ctx, c, KeyFileName(header.Filename), fileid, header.Size, digests)
+}
+
+// publishUpload issues a key for an uploaded file and answers with it. The
+// file is discarded when no key could be issued.
+func (h *FileHandler) publishUpload(
+	ctx context.Context,
+	c *gin.Context,
+	name string,
+	fileid uint64,
+	size int64,
+	digests *uploadDigests,
+) {
+	key, err := h.m.CreateFileKey(ctx, uploader(ctx), name, fileid, size)
+	if err != nil {
+		cleanupErr := h.discardUpload(ctx, fileid)
+		status := http.StatusInternalServerError
+		if errors.Is(err, filemgr.ErrQuotaExceeded) {
+			status = http.StatusInsufficientStorage
+		}
+		proxyutil.FailJson(c, status, fmt.Errorf("create link failed: %w", errors.Join(err, cleanupErr)))
 		return
 	}
 
 	proxyutil.SuccessJson(c, &model.UploadFileResponse{
-		Key: key,
+		Key:      key,
+		FileSize: size,
+		Md5:      digests.md5Hex(),
+		Sha256:   digests.sha256Hex(),
 	})
 }
 
+func (h *FileHandler) discardUpload(ctx context.Context, fileid uint64) error {
+	cleanupContext, cancel := context.WithTimeout(context.WithoutCancel(ctx), 15*time.Second)
+	defer cancel()
+	if err := h.m.DiscardUnpublishedFile(cleanupContext, fileid); err != nil {
+		return fmt.Errorf("discard upload: %w", err)
+	}
+	return nil
+}
+
 func logCloseError(ctx context.Context, closer io.Closer, message string) {
 	if err := closer.Close(); err != nil {
 		logutil.GetLogger(ctx).Error(message, zap.Error(err))
@@ -0,0 +1,139 @@
+package file
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xxxsen/common/webapi/proxyutil"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+const (
+	rawUploadContentType = "application/octet-stream"
+	contentSHA256Header  = "X-Content-SHA256"
+)
+
+var (
+	errRawUploadContentType = errors.New("raw upload requires Content-Type application/octet-stream")
+	errInvalidContentMD5    = errors.New("invalid Content-MD5")
+	errInvalidContentSHA256 = errors.New("invalid X-Content-SHA256")
+	errUploadDigestMismatch = errors.New("upload digest mismatch")
+)
+
+// FileRawUpload stores the request body as a file called by the last path
+// segment and issues a key for it. A body with Content-Length is streamed
+// into blocks as it arrives; one without is spooled to disk first. The
+// optional Content-MD5 (base64) and X-Content-SHA256 (hex) headers are
+// checked before the key is issued.
+func (h *FileHandler) FileRawUpload(c *gin.Context) {
+	ctx := c.Request.Context()
+	if c.Request.Method == http.MethodPost && !isRawUploadContentType(c.GetHeader("Content-Type")) {
+		proxyutil.FailJson(c, http.StatusUnsupportedMediaType, errRawUploadContentType)
+		return
+	}
+	expected, err := parseExpectedDigests(c.Request.Header)
+	if err != nil {
+		proxyutil.FailJson(c, http.StatusBadRequest, err)
+		return
+	}
+	body, size, err := h.rawUploadBody(c.Request)
+	switch {
+	case errors.Is(err, errUploadTooLarge):
+		proxyutil.FailJson(c, http.StatusRequestEntityTooLarge, err)
+		return
+	case err != nil:
+		proxyutil.FailJson(c, http.StatusBadRequest, fmt.Errorf("read upload fail, err:%w", err))
+		return
+	}
+	defer logCloseError(ctx, body, "close upload body")
+	digests := newUploadDigests()
+	fileid, err := h.m.CreateFile(ctx, size, io.TeeReader(body, digests))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, filemgr.ErrFileShortRead) {
+			status = http.StatusBadRequest
+		}
+		proxyutil.FailJson(c, status, fmt.Errorf("upload file fail, err:%w", err))
+		return
+	}
+	if err := digests.verify(expected); err != nil {
+		proxyutil.FailJson(c, http.StatusBadRequest, errors.Join(err, h.discardUpload(ctx, fileid)))
+		return
+	}
+	h.publishUpload(ctx, c, KeyFileName(c.Param("name")), fileid, size, digests)
+}
+
+func isRawUploadContentType(value string) bool {
+	mediaType, _, err := mime.ParseMediaType(value)
+	return err == nil && mediaType == rawUploadContentType
+}
+
+// expectedDigests are the digests a client declared for its upload; a nil
+// digest was not declared.
+type expectedDigests struct {
+	md5    []byte
+	sha256 []byte
+}
+
+func parseExpectedDigests(header http.Header) (expectedDigests, error) {
+	var expected expectedDigests
+	if value := header.Get("Content-MD5"); value != "" {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(decoded) != filemgr.MD5CompatibilitySize {
+			return expectedDigests{}, errInvalidContentMD5
+		}
+		expected.md5 = decoded
+	}
+	if value := header.Get(contentSHA256Header); value != "" {
+		decoded, err := hex.DecodeString(value)
+		if err != nil || len(decoded) != sha256.Size {
+			return expectedDigests{}, errInvalidContentSHA256
+		}
+		expected.sha256 = decoded
+	}
+	return expected, nil
+}
+
+// uploadDigests hashes an upload while it is stored.
+type uploadDigests struct {
+	md5    hash.Hash
+	sha256 hash.Hash
+}
+
+func newUploadDigests() *uploadDigests {
+	return &uploadDigests{md5: filemgr.NewMD5CompatibilityHash(), sha256: sha256.New()}
+}
+
+func (d *uploadDigests) Write(p []byte) (int, error) {
+	_, _ = d.md5.Write(p)
+	_, _ = d.sha256.Write(p)
+	return len(p), nil
+}
+
+func (d *uploadDigests) verify(expected expectedDigests) error {
+	if expected.md5 != nil && !bytes.Equal(expected.md5, d.md5.Sum(nil)) {
+		return fmt.Errorf("%w: Content-MD5", errUploadDigestMismatch)
+	}
+	if expected.sha256 != nil && !bytes.Equal(expected.sha256, d.sha256.Sum(nil)) {
+		return fmt.Errorf("%w: %s", errUploadDigestMismatch, contentSHA256Header)
+	}
+	return nil
+}
+
+func (d *uploadDigests) md5Hex() string {
+	return hex.EncodeToString(d.md5.Sum(nil))
+}
+
+func (d *uploadDigests) sha256Hex() string {
+	return hex.EncodeToString(d.sha256.Sum(nil))
+}
@@ -1,6 +1,7 @@
 package file
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -8,6 +9,8 @@ import (
 
 	"github.com/xxxsen/common/webapi/proxyutil"
 
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/server/httpkit"
 	"github.com/xxxsen/tgfile/server/model"
 
 	"github.com/gin-gonic/gin"
@@ -16,12 +19,7 @@ import (
 func (h *FileHandler) GetMetaInfo(c *gin.Context) {
 	ctx := c.Request.Context()
 	key := c.Param("key")
-	link, err := h.extractLinkFromFileKey(key)
-	if err != nil {
-		proxyutil.FailJson(c, http.StatusBadRequest, fmt.Errorf("invalid fkey, err:%w", err))
-		return
-	}
-	info, err := h.m.StatFileLink(ctx, link)
+	info, err := h.statFileKey(ctx, key)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			proxyutil.SuccessJson(c, model.GetFileInfoResponse{
@@ -32,7 +30,7 @@ func (h *FileHandler) GetMetaInfo(c *gin.Context) {
 			})
 			return
 		}
-		proxyutil.FailJson(c, http.StatusInternalServerError, fmt.Errorf("read file info fail, err:%w", err))
+		proxyutil.FailJson(c, fileKeyStatus(err), fmt.Errorf("read file info fail, err:%w", err))
 		return
 	}
 	fidinfo, err := h.m.StatFile(ctx, info.FileId)
@@ -49,6 +47,21 @@ func (h *FileHandler) GetMetaInfo(c *gin.Context) {
 			Mtime:         info.Mtime,
 			Md5:           fidinfo.Md5Sum,
 			FilePartCount: fidinfo.FilePartCount,
+			ContentType:   httpkit.ContentType(info),
 		},
 	})
 }
+
+// statFileKey reports unknown and revoked keys as os.ErrNotExist, like a key
+// whose file is gone.
+func (h *FileHandler) statFileKey(ctx context.Context, key string) (*entity.FileLinkMeta, error) {
+	link, err := h.m.ResolveFileKey(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("resolve file key: %w", err)
+	}
+	info, err := h.m.StatFileLink(ctx, link)
+	if err != nil {
+		return nil, fmt.Errorf("stat file key link: %w", err)
+	}
+	return info, nil
+}
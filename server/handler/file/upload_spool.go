@@ -0,0 +1,136 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxUploadSize int64 = 5 * 1024 * 1024 * 1024
+	rawUploadPrefix            = "/file/upload/"
+	uploadSpoolPattern         = "tgfile-upload-*"
+)
+
+var errUploadTooLarge = errors.New("upload exceeds configured limit")
+
+type unknownContentLengthKey struct{}
+
+// PreserveUnknownContentLength prevents the common HTTP middleware from
+// buffering a raw upload whose length was not declared. The handler spools
+// it to disk after authentication instead.
+func PreserveUnknownContentLength(request *http.Request) *http.Request {
+	if request == nil || request.ContentLength >= 0 ||
+		request.Method != http.MethodPut && request.Method != http.MethodPost ||
+		!strings.HasPrefix(request.URL.Path, rawUploadPrefix) ||
+		len(request.URL.Path) == len(rawUploadPrefix) {
+		return request
+	}
+	ctx := context.WithValue(request.Context(), unknownContentLengthKey{}, true)
+	cloned := request.Clone(ctx)
+	cloned.ContentLength = 0
+	return cloned
+}
+
+func requestContentLength(request *http.Request) int64 {
+	if unknown, _ := request.Context().Value(unknownContentLengthKey{}).(bool); unknown {
+		return -1
+	}
+	return request.ContentLength
+}
+
+// rawUploadBody returns the body of a raw upload and its length. A body of
+// unknown length is copied to a spool file first, which is removed when the
+// returned body is closed.
+func (h *FileHandler) rawUploadBody(request *http.Request) (io.ReadCloser, int64, error) {
+	limit := h.uploads.MaxUploadSize
+	length := requestContentLength(request)
+	if length > limit {
+		return nil, 0, errUploadTooLarge
+	}
+	if length >= 0 {
+		return request.Body, length, nil
+	}
+	return spoolUpload(request.Body, h.uploads.TempDir, limit)
+}
+
+func spoolUpload(body io.Reader, tempDir string, limit int64) (io.ReadCloser, int64, error) {
+	if strings.TrimSpace(tempDir) == "" {
+		tempDir = os.TempDir()
+	}
+	if err := os.MkdirAll(tempDir, 0o700); err != nil {
+		return nil, 0, fmt.Errorf("create upload temp directory: %w", err)
+	}
+	file, err := os.CreateTemp(tempDir, uploadSpoolPattern)
+	if err != nil {
+		return nil, 0, fmt.Errorf("create upload spool: %w", err)
+	}
+	spool := &removeOnCloseFile{File: file, path: file.Name()}
+	written, err := io.Copy(file, io.LimitReader(body, limit+1))
+	switch {
+	case err != nil:
+		err = fmt.Errorf("spool upload: %w", err)
+	case written > limit:
+		err = errUploadTooLarge
+	default:
+		if _, seekErr := file.Seek(0, io.SeekStart); seekErr != nil {
+			err = fmt.Errorf("rewind upload spool: %w", seekErr)
+		}
+	}
+	if err != nil {
+		return nil, 0, errors.Join(err, spool.Close())
+	}
+	return spool, written, nil
+}
+
+type removeOnCloseFile struct {
+	*os.File
+	path string
+	once sync.Once
+	err  error
+}
+
+func (f *removeOnCloseFile) Close() error {
+	f.once.Do(func() {
+		f.err = errors.Join(f.File.Close(), os.Remove(f.path))
+	})
+	return f.err
+}
+
+// CleanupStaleUploads removes the spool files of uploads a previous process
+// left behind in directory.
+func CleanupStaleUploads(directory string, olderThan time.Duration) error {
+	if strings.TrimSpace(directory) == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(directory)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read upload temp directory: %w", err)
+	}
+	cutoff := time.Now().Add(-olderThan)
+	prefix := strings.TrimSuffix(uploadSpoolPattern, "*")
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(directory, entry.Name())); err != nil &&
+			!errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove stale upload spool: %w", err)
+		}
+	}
+	return nil
+}
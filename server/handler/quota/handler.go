@@ -0,0 +1,71 @@
+package quota
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xxxsen/common/webapi/proxyutil"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+type Handler struct {
+	quotas filemgr.IQuotaManager
+}
+
+func New(quotas filemgr.IQuotaManager) *Handler {
+	return &Handler{quotas: quotas}
+}
+
+// Metrics exposes the usage and limits of every principal in the Prometheus
+// text format. Limits are only reported when they are set.
+func (h *Handler) Metrics(c *gin.Context) {
+	usages, err := h.quotas.ListQuotaUsage(c.Request.Context())
+	if err != nil {
+		proxyutil.FailJson(c, http.StatusInternalServerError, err)
+		return
+	}
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Header("Cache-Control", "private, no-store")
+	c.String(http.StatusOK, renderMetrics(usages))
+}
+
+func renderMetrics(usages []filemgr.QuotaUsage) string {
+	var output strings.Builder
+	for _, usage := range usages {
+		principal := "principal=" + strconv.Quote(usage.Principal)
+		writeMetric(&output, "tgfile_quota_used_bytes", principal, usage.Bytes)
+		writeMetric(&output, "tgfile_quota_used_objects", principal, usage.Objects)
+		writeLimit(&output, "tgfile_quota_limit_bytes", principal, "hard", usage.Limits.HardBytes)
+		writeLimit(&output, "tgfile_quota_limit_bytes", principal, "soft", usage.Limits.SoftBytes)
+		writeLimit(&output, "tgfile_quota_limit_objects", principal, "hard", usage.Limits.HardObjects)
+		writeLimit(&output, "tgfile_quota_limit_objects", principal, "soft", usage.Limits.SoftObjects)
+		writeMetric(&output, "tgfile_quota_exceeded", principal+`,kind="hard"`, boolMetric(usage.HardExceeded()))
+		writeMetric(&output, "tgfile_quota_exceeded", principal+`,kind="soft"`, boolMetric(usage.SoftExceeded()))
+	}
+	return output.String()
+}
+
+func writeLimit(output *strings.Builder, name, principal, kind string, limit int64) {
+	if limit > 0 {
+		writeMetric(output, name, principal+",kind="+strconv.Quote(kind), limit)
+	}
+}
+
+func boolMetric(value bool) int64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func writeMetric(output *strings.Builder, name, labels string, value int64) {
+	output.WriteString(name)
+	output.WriteByte('{')
+	output.WriteString(labels)
+	output.WriteString("} ")
+	output.WriteString(strconv.FormatInt(value, 10))
+	output.WriteByte('\n')
+}
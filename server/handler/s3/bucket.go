@@ -116,6 +116,9 @@ func (h *S3Handler) GetBucket(c *gin.Context) {
 		return
 	}
 	query := c.Request.URL.Query()
+	if h.getBucketConfiguration(c, bucketName, query) {
+		return
+	}
 	switch {
 	case query.Get("list-type") == "2":
 		h.listObjectsV2(c, bucketName)
@@ -134,6 +137,24 @@ func (h *S3Handler) GetBucket(c *gin.Context) {
 	}
 }
 
+// getBucketConfiguration serves the bucket configuration subresources and
+// reports whether query addressed one of them.
+func (h *S3Handler) getBucketConfiguration(c *gin.Context, bucketName string, query url.Values) bool {
+	switch {
+	case len(query) == 1 && hasQueryKey(query, "replication"):
+		h.GetBucketReplication(c, bucketName)
+	case len(query) == 1 && hasQueryKey(query, "lifecycle"):
+		h.GetBucketLifecycleConfiguration(c, bucketName)
+	case hasQueryKey(query, "inventory"):
+		h.getBucketInventory(c, bucketName)
+	case len(query) == 1 && hasQueryKey(query, "logging"):
+		h.GetBucketLogging(c, bucketName)
+	default:
+		return false
+	}
+	return true
+}
+
 func (h *S3Handler) HeadBucketOrObject(c *gin.Context) {
 	_, key := requestBucketKey(c.Request.URL.Path)
 	if key == "" {
@@ -355,7 +376,7 @@ func listContents(items []filemgr.S3ListItem, encodingType string, fetchOwner bo
 			Size:              item.Size,
 			ChecksumAlgorithm: item.ChecksumAlgorithm,
 			ChecksumType:      item.ChecksumType,
-			StorageClass:      "STANDARD",
+			StorageClass:      responseStorageClass(item.StorageClass),
 		}
 		if fetchOwner {
 			content.Owner = &listOwner{ID: "tgfile", DisplayName: "tgfile"}
@@ -43,6 +43,11 @@ func (h *S3Handler) CopyObject(c *gin.Context) {
 		s3base.WriteError(c, apiError)
 		return
 	}
+	storageClass, apiError := h.requestStorageClass(c)
+	if apiError != nil {
+		s3base.WriteError(c, apiError)
+		return
+	}
 	result, err := h.fmgr.CopyS3Object(
 		c.Request.Context(),
 		preparation.sourcePath,
@@ -59,6 +64,14 @@ func (h *S3Handler) CopyObject(c *gin.Context) {
 		s3base.WriteError(c, mutationError(err))
 		return
 	}
+	if storageClass != "" {
+		// S3 clients change the class of an object by copying it onto itself.
+		ctx := c.Request.Context()
+		if _, err := h.fmgr.TransitionS3Object(ctx, preparation.destinationPath, storageClass); err != nil {
+			s3base.WriteError(c, s3base.InternalError(err))
+			return
+		}
+	}
 	response := &copyObjectResult{
 		XMLNS:        s3XMLNamespace,
 		LastModified: time.UnixMilli(result.Link.Mtime).UTC().Format("2006-01-02T15:04:05.000Z"),
@@ -105,6 +118,9 @@ func (h *S3Handler) prepareCopyPaths(c *gin.Context) (*copyPreparation, *s3base.
 	if err := validateHistoricalObjectKeyBoundary(sourceBucketName, sourceKey); err != nil {
 		return nil, objectNameError(err)
 	}
+	if !scopeCovers(c, sourceBucketName, sourceKey) {
+		return nil, s3base.AccessDenied(errPermissionDenied)
+	}
 	return &copyPreparation{
 		sourcePath:      "/" + sourceBucketName + "/" + sourceKey,
 		destinationPath: "/" + destinationBucket.Name + "/" + destinationKey,
@@ -164,7 +180,7 @@ func copyReplacementMetadata(
 			nil,
 		)
 	}
-	replacement, apiError := parseRequestMetadata(c.Request, destinationKey)
+	replacement, apiError := parseRequestMetadata(c.Request)
 	if apiError != nil {
 		return nil, apiError
 	}
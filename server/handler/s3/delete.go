@@ -59,7 +59,7 @@ func (h *S3Handler) DeleteObject(c *gin.Context) {
 		s3base.WriteError(c, objectNameError(err))
 		return
 	}
-	_, err := h.fmgr.DeleteS3Object(c.Request.Context(), objectPath, condition)
+	_, err := h.fmgr.DeleteS3Object(trashOriginContext(c), objectPath, condition)
 	if err != nil {
 		s3base.WriteError(c, mutationError(err))
 		return
@@ -67,6 +67,17 @@ func (h *S3Handler) DeleteObject(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// trashOriginContext names the requester of a delete for the recycle bin.
+func trashOriginContext(c *gin.Context) context.Context {
+	origin := filemgr.TrashOrigin{Protocol: filemgr.TrashProtocolS3}
+	if value, exists := c.Get(identityContextKey); exists {
+		if identity, ok := value.(*Identity); ok {
+			origin.Principal = identity.Username
+		}
+	}
+	return filemgr.ContextWithTrashOrigin(c.Request.Context(), origin)
+}
+
 func parseDeleteCondition(request *http.Request) (*filemgr.S3Condition, *s3base.APIError) {
 	ifMatch := request.Header.Get("If-Match")
 	if ifMatch != "" && ifMatch != "*" && !validSingleETag(ifMatch) {
@@ -411,6 +422,9 @@ func (h *S3Handler) deleteObjectItem(
 	if err := validateHistoricalObjectKeyBoundary(bucket.Name, object.Key); err != nil {
 		return objectNameError(err)
 	}
+	if !scopeCovers(c, bucket.Name, object.Key) {
+		return s3base.AccessDenied(errPermissionDenied)
+	}
 	objectPath := "/" + bucket.Name + "/" + object.Key
 	if err := validateNewObjectKey(object.Key); err != nil {
 		if _, statErr := h.fmgr.StatS3Object(c.Request.Context(), objectPath); statErr != nil {
@@ -420,7 +434,7 @@ func (h *S3Handler) deleteObjectItem(
 	unlock := h.locks.lock(objectPath)
 	defer unlock()
 	condition := &filemgr.S3Condition{IfMatch: strings.TrimSpace(object.ETag)}
-	if _, err := h.fmgr.DeleteS3Object(c.Request.Context(), objectPath, condition); err != nil {
+	if _, err := h.fmgr.DeleteS3Object(trashOriginContext(c), objectPath, condition); err != nil {
 		return mutationError(err)
 	}
 	return nil
@@ -0,0 +1,341 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/inventory"
+	"github.com/xxxsen/tgfile/server/handler/s3/s3base"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xxxsen/s3verify"
+)
+
+const (
+	maxBucketConfigurationBody = 64 * 1024
+	inventoryListPageSize      = 100
+	s3BucketARNPrefix          = "arn:aws:s3:::"
+)
+
+type inventoryConfiguration struct {
+	XMLName                xml.Name                 `xml:"InventoryConfiguration"`
+	XMLNS                  string                   `xml:"xmlns,attr,omitempty"`
+	ID                     string                   `xml:"Id"`
+	IsEnabled              bool                     `xml:"IsEnabled"`
+	Filter                 *inventoryFilter         `xml:"Filter,omitempty"`
+	Destination            inventoryDestination     `xml:"Destination"`
+	Schedule               inventorySchedule        `xml:"Schedule"`
+	IncludedObjectVersions string                   `xml:"IncludedObjectVersions"`
+	OptionalFields         *inventoryOptionalFields `xml:"OptionalFields,omitempty"`
+}
+
+type inventoryFilter struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type inventoryDestination struct {
+	S3BucketDestination inventoryBucketDestination `xml:"S3BucketDestination"`
+}
+
+type inventoryBucketDestination struct {
+	Bucket string `xml:"Bucket"`
+	Format string `xml:"Format"`
+	Prefix string `xml:"Prefix,omitempty"`
+}
+
+type inventorySchedule struct {
+	Frequency string `xml:"Frequency"`
+}
+
+type inventoryOptionalFields struct {
+	Fields []string `xml:"Field"`
+}
+
+type listInventoryConfigurationsResult struct {
+	XMLName               xml.Name                  `xml:"ListInventoryConfigurationsResult"`
+	XMLNS                 string                    `xml:"xmlns,attr"`
+	ContinuationToken     string                    `xml:"ContinuationToken,omitempty"`
+	Configurations        []*inventoryConfiguration `xml:"InventoryConfiguration"`
+	IsTruncated           bool                      `xml:"IsTruncated"`
+	NextContinuationToken string                    `xml:"NextContinuationToken,omitempty"`
+}
+
+// PutBucket dispatches bucket level PUT subresources.
+func (h *S3Handler) PutBucket(c *gin.Context) {
+	query := c.Request.URL.Query()
+	switch {
+	case hasQueryKey(query, "inventory"):
+		h.PutBucketInventoryConfiguration(c)
+	case len(query) == 1 && hasQueryKey(query, "logging"):
+		h.PutBucketLogging(c)
+	case len(query) == 1 && hasQueryKey(query, "replication"):
+		h.PutBucketReplication(c)
+	default:
+		h.NotImplemented(c)
+	}
+}
+
+// DeleteBucket dispatches bucket level DELETE subresources.
+func (h *S3Handler) DeleteBucket(c *gin.Context) {
+	query := c.Request.URL.Query()
+	switch {
+	case hasQueryKey(query, "inventory"):
+		h.DeleteBucketInventoryConfiguration(c)
+	case len(query) == 1 && hasQueryKey(query, "replication"):
+		h.DeleteBucketReplication(c)
+	default:
+		h.NotImplemented(c)
+	}
+}
+
+func (h *S3Handler) PutBucketInventoryConfiguration(c *gin.Context) {
+	bucketName, id, apiError := h.authorizeInventory(c, authz.S3Write)
+	if apiError != nil {
+		s3base.WriteError(c, apiError)
+		return
+	}
+	config, apiError := readInventoryConfiguration(c, bucketName, id)
+	if apiError != nil {
+		s3base.WriteError(c, apiError)
+		return
+	}
+	if !inventoryScopeCovers(c, config) {
+		s3base.WriteError(c, s3base.AccessDenied(errPermissionDenied))
+		return
+	}
+	if err := h.inventory.Put(c.Request.Context(), config); err != nil {
+		s3base.WriteError(c, inventoryError(err))
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (h *S3Handler) DeleteBucketInventoryConfiguration(c *gin.Context) {
+	bucketName, id, apiError := h.authorizeInventory(c, authz.S3Write)
+	if apiError != nil {
+		s3base.WriteError(c, apiError)
+		return
+	}
+	config, found, err := h.inventory.Get(c.Request.Context(), bucketName, id)
+	if err != nil {
+		s3base.WriteError(c, inventoryError(err))
+		return
+	}
+	if !found {
+		s3base.WriteError(c, noSuchInventoryConfiguration(bucketName))
+		return
+	}
+	if !inventoryScopeCovers(c, config) {
+		s3base.WriteError(c, s3base.AccessDenied(errPermissionDenied))
+		return
+	}
+	deleted, err := h.inventory.Delete(c.Request.Context(), bucketName, id)
+	if err != nil {
+		s3base.WriteError(c, inventoryError(err))
+		return
+	}
+	if !deleted {
+		s3base.WriteError(c, noSuchInventoryConfiguration(bucketName))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// getBucketInventory serves GetBucketInventoryConfiguration, or
+// ListBucketInventoryConfigurations when no id is given. GetBucket has
+// already authorized the request; a scoped credential only sees the
+// configurations its scope covers.
+func (h *S3Handler) getBucketInventory(c *gin.Context, bucketName string) {
+	if h.inventory == nil {
+		writeUnsupportedBucketSubresource(c)
+		return
+	}
+	query := c.Request.URL.Query()
+	if !hasQueryKey(query, "id") {
+		h.listBucketInventoryConfigurations(c, bucketName, query.Get("continuation-token"))
+		return
+	}
+	config, found, err := h.inventory.Get(c.Request.Context(), bucketName, query.Get("id"))
+	if err != nil {
+		s3base.WriteError(c, inventoryError(err))
+		return
+	}
+	if !found {
+		s3base.WriteError(c, noSuchInventoryConfiguration(bucketName))
+		return
+	}
+	if !inventoryScopeCovers(c, config) {
+		s3base.WriteError(c, s3base.AccessDenied(errPermissionDenied))
+		return
+	}
+	result := inventoryConfigurationXML(config)
+	result.XMLNS = s3XMLNamespace
+	c.XML(http.StatusOK, result)
+}
+
+func (h *S3Handler) listBucketInventoryConfigurations(c *gin.Context, bucketName, token string) {
+	configs, err := h.inventory.List(c.Request.Context(), bucketName, token, inventoryListPageSize+1)
+	if err != nil {
+		s3base.WriteError(c, inventoryError(err))
+		return
+	}
+	result := &listInventoryConfigurationsResult{
+		XMLNS:             s3XMLNamespace,
+		ContinuationToken: token,
+		Configurations:    make([]*inventoryConfiguration, 0, len(configs)),
+	}
+	if len(configs) > inventoryListPageSize {
+		configs = configs[:inventoryListPageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = configs[len(configs)-1].ID
+	}
+	for _, config := range configs {
+		if inventoryScopeCovers(c, config) {
+			result.Configurations = append(result.Configurations, inventoryConfigurationXML(config))
+		}
+	}
+	c.XML(http.StatusOK, result)
+}
+
+// inventoryScopeCovers reports whether the credential of the request covers
+// both the objects a configuration lists and the place its reports go.
+func inventoryScopeCovers(c *gin.Context, config *inventory.Configuration) bool {
+	return scopeCovers(c, config.Bucket, config.Prefix) &&
+		scopeCovers(c, config.DestinationBucket, config.DestinationPrefix)
+}
+
+func (h *S3Handler) authorizeInventory(c *gin.Context, permission authz.Permission) (string, string, *s3base.APIError) {
+	bucketName, _ := requestBucketKey(c.Request.URL.Path)
+	if _, exists := h.Bucket(bucketName); !exists {
+		return "", "", noSuchBucketError(bucketName)
+	}
+	if _, apiError := h.Authorize(c, true, permission); apiError != nil {
+		return "", "", apiError
+	}
+	if h.inventory == nil {
+		return "", "", s3base.NewError(
+			http.StatusNotImplemented,
+			"NotImplemented",
+			"The requested bucket subresource is not implemented.",
+			nil,
+		)
+	}
+	id := c.Request.URL.Query().Get("id")
+	if id == "" {
+		return "", "", invalidArgumentError("The id parameter is required.")
+	}
+	return bucketName, id, nil
+}
+
+func readInventoryConfiguration(c *gin.Context, bucketName, id string) (*inventory.Configuration, *s3base.APIError) {
+	body, apiError := readBucketConfigurationBody(c)
+	if apiError != nil {
+		return nil, apiError
+	}
+	var request inventoryConfiguration
+	if apiError := decodeBucketConfiguration(body, &request); apiError != nil {
+		return nil, apiError
+	}
+	if request.ID != id {
+		return nil, invalidArgumentError("The configuration Id must match the id parameter.")
+	}
+	if request.IncludedObjectVersions != "Current" {
+		return nil, invalidArgumentError("Only Current object versions can be included.")
+	}
+	destination := request.Destination.S3BucketDestination
+	if !strings.HasPrefix(destination.Bucket, s3BucketARNPrefix) {
+		return nil, invalidArgumentError("The destination bucket must be an S3 bucket ARN.")
+	}
+	config := &inventory.Configuration{
+		ID:                id,
+		Bucket:            bucketName,
+		Enabled:           request.IsEnabled,
+		DestinationBucket: strings.TrimPrefix(destination.Bucket, s3BucketARNPrefix),
+		DestinationPrefix: destination.Prefix,
+		Format:            inventory.Format(destination.Format),
+		Frequency:         inventory.Frequency(request.Schedule.Frequency),
+	}
+	if request.Filter != nil {
+		config.Prefix = request.Filter.Prefix
+	}
+	if request.OptionalFields != nil {
+		config.OptionalFields = request.OptionalFields.Fields
+	}
+	return config, nil
+}
+
+// readBucketConfigurationBody reads the XML body of a bucket configuration
+// PUT such as ?inventory or ?logging.
+func readBucketConfigurationBody(c *gin.Context) ([]byte, *s3base.APIError) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBucketConfigurationBody+1))
+	if err != nil {
+		var verifyError *s3verify.VerifyError
+		if errors.As(err, &verifyError) {
+			return nil, verifierBodyError(err)
+		}
+		return nil, s3base.NewError(http.StatusBadRequest, "MalformedXML", "The XML body is invalid.", err)
+	}
+	if len(body) > maxBucketConfigurationBody {
+		return nil, s3base.NewError(http.StatusBadRequest, "MalformedXML", "The XML request body is too large.", nil)
+	}
+	return body, nil
+}
+
+func decodeBucketConfiguration(body []byte, target any) *s3base.APIError {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = true
+	if err := decoder.Decode(target); err != nil {
+		return s3base.NewError(http.StatusBadRequest, "MalformedXML", "The XML body is invalid.", err)
+	}
+	return nil
+}
+
+// invalidArgumentError reports a bucket configuration value that S3 rejects
+// with InvalidArgument.
+func invalidArgumentError(message string) *s3base.APIError {
+	return s3base.NewError(http.StatusBadRequest, "InvalidArgument", message, nil)
+}
+
+func inventoryConfigurationXML(config *inventory.Configuration) *inventoryConfiguration {
+	result := &inventoryConfiguration{
+		ID:        config.ID,
+		IsEnabled: config.Enabled,
+		Destination: inventoryDestination{S3BucketDestination: inventoryBucketDestination{
+			Bucket: s3BucketARNPrefix + config.DestinationBucket,
+			Format: string(config.Format),
+			Prefix: config.DestinationPrefix,
+		}},
+		Schedule:               inventorySchedule{Frequency: string(config.Frequency)},
+		IncludedObjectVersions: "Current",
+	}
+	if config.Prefix != "" {
+		result.Filter = &inventoryFilter{Prefix: config.Prefix}
+	}
+	if len(config.OptionalFields) != 0 {
+		result.OptionalFields = &inventoryOptionalFields{Fields: config.OptionalFields}
+	}
+	return result
+}
+
+func inventoryError(err error) *s3base.APIError {
+	if errors.Is(err, inventory.ErrInvalidConfiguration) {
+		return s3base.NewError(http.StatusBadRequest, "InvalidArgument", err.Error(), err)
+	}
+	return s3base.InternalError(err)
+}
+
+func noSuchInventoryConfiguration(bucketName string) *s3base.APIError {
+	apiError := s3base.NewError(
+		http.StatusNotFound,
+		"NoSuchConfiguration",
+		"The specified configuration does not exist.",
+		nil,
+	)
+	apiError.Bucket = bucketName
+	return apiError
+}
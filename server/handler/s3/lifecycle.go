@@ -0,0 +1,66 @@
+package s3
+
+import (
+	"encoding/xml"
+	"net/http"
+
+	"github.com/xxxsen/tgfile/lifecycle"
+	"github.com/xxxsen/tgfile/server/handler/s3/s3base"
+
+	"github.com/gin-gonic/gin"
+)
+
+type lifecycleConfiguration struct {
+	XMLName xml.Name        `xml:"LifecycleConfiguration"`
+	XMLNS   string          `xml:"xmlns,attr"`
+	Rules   []lifecycleRule `xml:"Rule"`
+}
+
+type lifecycleRule struct {
+	ID         string              `xml:"ID"`
+	Filter     lifecycleFilter     `xml:"Filter"`
+	Status     string              `xml:"Status"`
+	Transition lifecycleTransition `xml:"Transition"`
+}
+
+type lifecycleFilter struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type lifecycleTransition struct {
+	Days         int    `xml:"Days"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+// GetBucketLifecycleConfiguration reports the transition rules taken from
+// the service configuration.
+func (h *S3Handler) GetBucketLifecycleConfiguration(c *gin.Context, bucketName string) {
+	var rules []lifecycle.Rule
+	if h.lifecycle != nil {
+		rules = h.lifecycle.Rules(bucketName)
+	}
+	if len(rules) == 0 {
+		apiError := s3base.NewError(
+			http.StatusNotFound,
+			"NoSuchLifecycleConfiguration",
+			"The lifecycle configuration does not exist.",
+			nil,
+		)
+		apiError.Bucket = bucketName
+		s3base.WriteError(c, apiError)
+		return
+	}
+	result := &lifecycleConfiguration{
+		XMLNS: "http://s3.amazonaws.com/doc/2006-03-01/",
+		Rules: make([]lifecycleRule, 0, len(rules)),
+	}
+	for _, rule := range rules {
+		result.Rules = append(result.Rules, lifecycleRule{
+			ID:         rule.ID,
+			Filter:     lifecycleFilter{Prefix: rule.Prefix},
+			Status:     "Enabled",
+			Transition: lifecycleTransition{Days: rule.Days, StorageClass: rule.StorageClass},
+		})
+	}
+	c.XML(http.StatusOK, result)
+}
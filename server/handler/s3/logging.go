@@ -0,0 +1,232 @@
+package s3
+
+import (
+	"crypto/tls"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xxxsen/tgfile/accesslog"
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/server/handler/s3/s3base"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xxxsen/common/trace"
+)
+
+type bucketLoggingStatus struct {
+	XMLName        xml.Name        `xml:"BucketLoggingStatus"`
+	XMLNS          string          `xml:"xmlns,attr,omitempty"`
+	LoggingEnabled *loggingEnabled `xml:"LoggingEnabled,omitempty"`
+}
+
+type loggingEnabled struct {
+	TargetBucket string `xml:"TargetBucket"`
+	TargetPrefix string `xml:"TargetPrefix"`
+}
+
+// AccessLog records every bucket request for buckets with logging enabled.
+func (h *S3Handler) AccessLog(c *gin.Context) {
+	if h.accessLog == nil {
+		c.Next()
+		return
+	}
+	started := time.Now()
+	request := c.Request
+	bucket, key := requestBucketKey(request.URL.Path)
+	record := &accesslog.Record{
+		Bucket:     bucket,
+		Time:       started,
+		RemoteIP:   c.ClientIP(),
+		Operation:  accessLogOperation(request, key),
+		Key:        key,
+		RequestURI: request.Method + " " + redactedRequestURI(request.RequestURI) + " " + request.Proto,
+		Referer:    request.Referer(),
+		UserAgent:  request.UserAgent(),
+		Host:       request.Host,
+	}
+	record.RequestID, _ = trace.GetTraceId(request.Context())
+	record.SignatureVersion, record.AuthType = accessLogAuthentication(request)
+	if request.TLS != nil {
+		record.CipherSuite = tls.CipherSuiteName(request.TLS.CipherSuite)
+		record.TLSVersion = strings.ReplaceAll(tls.VersionName(request.TLS.Version), "TLS ", "TLSv")
+	}
+	c.Next()
+	record.TotalTime = time.Since(started)
+	record.Status = c.Writer.Status()
+	record.BytesSent = int64(c.Writer.Size())
+	if record.BytesSent <= 0 {
+		record.BytesSent = -1
+	}
+	record.ObjectSize = accessLogObjectSize(c, key)
+	if value, exists := c.Get("s3-result-code"); exists {
+		record.ErrorCode, _ = value.(string)
+	}
+	if value, exists := c.Get(identityContextKey); exists {
+		if identity, ok := value.(*Identity); ok {
+			record.Requester = identity.Username
+		}
+	}
+	h.accessLog.Record(record)
+}
+
+// accessLogOperation names the request as REST.METHOD.RESOURCE, as S3 does.
+func accessLogOperation(request *http.Request, key string) string {
+	query := request.URL.Query()
+	method := request.Method
+	resource := "BUCKET"
+	switch {
+	case key == "":
+		for _, name := range []string{"uploads", "delete", "logging", "inventory", "lifecycle", "replication", "location"} {
+			if hasQueryKey(query, name) {
+				resource = strings.ToUpper(name)
+				break
+			}
+		}
+		if resource == "DELETE" {
+			resource = "MULTI_OBJECT_DELETE"
+		}
+	case hasQueryKey(query, "uploadId") && method == http.MethodPut:
+		resource = "PART"
+	case hasQueryKey(query, "uploadId"), hasQueryKey(query, "uploads"):
+		resource = "UPLOAD"
+	case hasQueryKey(query, "attributes"):
+		resource = "OBJECT_ATTRIBUTES"
+	case method == http.MethodPut && request.Header.Get("X-Amz-Copy-Source") != "":
+		method, resource = "COPY", "OBJECT"
+	default:
+		resource = "OBJECT"
+	}
+	return "REST." + method + "." + resource
+}
+
+func accessLogAuthentication(request *http.Request) (string, string) {
+	if request.URL.Query().Get("X-Amz-Algorithm") != "" {
+		return "SigV4", "QueryString"
+	}
+	authorization := request.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(authorization, "AWS4-"):
+		return "SigV4", "AuthHeader"
+	case authorization != "":
+		return "", "AuthHeader"
+	default:
+		return "", ""
+	}
+}
+
+// redactedRequestURI drops presigned signatures and session tokens, which
+// would let a log reader replay the request.
+func redactedRequestURI(requestURI string) string {
+	base, rawQuery, found := strings.Cut(requestURI, "?")
+	if !found {
+		return requestURI
+	}
+	kept := make([]string, 0)
+	for _, parameter := range strings.Split(rawQuery, "&") {
+		name, _, _ := strings.Cut(parameter, "=")
+		if decoded, err := url.QueryUnescape(name); err == nil {
+			name = decoded
+		}
+		switch strings.ToLower(name) {
+		case "x-amz-signature", "x-amz-security-token":
+			continue
+		}
+		kept = append(kept, parameter)
+	}
+	if len(kept) == 0 {
+		return base
+	}
+	return base + "?" + strings.Join(kept, "&")
+}
+
+func accessLogObjectSize(c *gin.Context, key string) int64 {
+	if key == "" {
+		return -1
+	}
+	switch c.Request.Method {
+	case http.MethodPut:
+		if value, exists := c.Get("s3-decoded-content-length"); exists {
+			if length, ok := value.(int64); ok {
+				return length
+			}
+		}
+		return c.Request.ContentLength
+	case http.MethodGet, http.MethodHead:
+		header := c.Writer.Header()
+		if contentRange := header.Get("Content-Range"); contentRange != "" {
+			_, total, _ := strings.Cut(contentRange, "/")
+			if size, err := strconv.ParseInt(total, 10, 64); err == nil {
+				return size
+			}
+		}
+		if size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil {
+			return size
+		}
+	}
+	return -1
+}
+
+// PutBucketLogging enables logging into the given target bucket, or disables
+// it when LoggingEnabled is absent. TargetGrants are ignored.
+func (h *S3Handler) PutBucketLogging(c *gin.Context) {
+	bucketName, _ := requestBucketKey(c.Request.URL.Path)
+	if _, exists := h.Bucket(bucketName); !exists {
+		s3base.WriteError(c, noSuchBucketError(bucketName))
+		return
+	}
+	if _, apiError := h.Authorize(c, true, authz.S3Write); apiError != nil {
+		s3base.WriteError(c, apiError)
+		return
+	}
+	if h.accessLog == nil {
+		writeUnsupportedBucketSubresource(c)
+		return
+	}
+	body, apiError := readBucketConfigurationBody(c)
+	if apiError != nil {
+		s3base.WriteError(c, apiError)
+		return
+	}
+	var request bucketLoggingStatus
+	if apiError := decodeBucketConfiguration(body, &request); apiError != nil {
+		s3base.WriteError(c, apiError)
+		return
+	}
+	var target *accesslog.Target
+	if request.LoggingEnabled != nil {
+		target = &accesslog.Target{
+			Bucket: request.LoggingEnabled.TargetBucket,
+			Prefix: request.LoggingEnabled.TargetPrefix,
+		}
+		if !scopeCovers(c, target.Bucket, target.Prefix) {
+			s3base.WriteError(c, s3base.AccessDenied(errPermissionDenied))
+			return
+		}
+	}
+	if err := h.accessLog.SetTarget(c.Request.Context(), bucketName, target); err != nil {
+		if errors.Is(err, accesslog.ErrInvalidTarget) {
+			s3base.WriteError(c, s3base.NewError(http.StatusBadRequest, "InvalidTargetBucketForLogging", err.Error(), err))
+			return
+		}
+		s3base.WriteError(c, s3base.InternalError(err))
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// GetBucketLogging reports the logging target. GetBucket has already
+// authorized the request.
+func (h *S3Handler) GetBucketLogging(c *gin.Context, bucketName string) {
+	result := &bucketLoggingStatus{XMLNS: s3XMLNamespace}
+	if h.accessLog != nil {
+		if target, exists := h.accessLog.Target(bucketName); exists {
+			result.LoggingEnabled = &loggingEnabled{TargetBucket: target.Bucket, TargetPrefix: target.Prefix}
+		}
+	}
+	c.XML(http.StatusOK, result)
+}
@@ -0,0 +1,47 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactedRequestURIDropsReplayableCredentials(t *testing.T) {
+	testCases := map[string]string{
+		"/hackmd/a.txt": "/hackmd/a.txt",
+		"/hackmd/a.txt?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Signature=abc&partNumber=1": "/hackmd/a.txt?" +
+			"X-Amz-Algorithm=AWS4-HMAC-SHA256&partNumber=1",
+		"/hackmd?x-amz-security-token=secret":  "/hackmd",
+		"/hackmd?X%2DAmz%2DSignature=abc&list": "/hackmd?list",
+	}
+	for requestURI, expected := range testCases {
+		require.Equal(t, expected, redactedRequestURI(requestURI), requestURI)
+	}
+}
+
+func TestAccessLogOperation(t *testing.T) {
+	testCases := []struct {
+		method   string
+		target   string
+		copy     bool
+		expected string
+	}{
+		{method: http.MethodGet, target: "/hackmd?list-type=2", expected: "REST.GET.BUCKET"},
+		{method: http.MethodPost, target: "/hackmd?delete", expected: "REST.POST.MULTI_OBJECT_DELETE"},
+		{method: http.MethodPut, target: "/hackmd?logging", expected: "REST.PUT.LOGGING"},
+		{method: http.MethodPut, target: "/hackmd/a?partNumber=1&uploadId=u", expected: "REST.PUT.PART"},
+		{method: http.MethodPost, target: "/hackmd/a?uploads", expected: "REST.POST.UPLOAD"},
+		{method: http.MethodPut, target: "/hackmd/a", copy: true, expected: "REST.COPY.OBJECT"},
+		{method: http.MethodHead, target: "/hackmd/a", expected: "REST.HEAD.OBJECT"},
+	}
+	for _, testCase := range testCases {
+		request := httptest.NewRequestWithContext(t.Context(), testCase.method, testCase.target, nil)
+		if testCase.copy {
+			request.Header.Set("X-Amz-Copy-Source", "/hackmd/b")
+		}
+		_, key := requestBucketKey(request.URL.Path)
+		require.Equal(t, testCase.expected, accessLogOperation(request, key), testCase.target)
+	}
+}
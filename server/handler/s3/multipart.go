@@ -150,17 +150,12 @@ func (h *S3Handler) CreateMultipartUpload(c *gin.Context) {
 		s3base.WriteError(c, apiError)
 		return
 	}
-	storageClass := c.GetHeader("x-amz-storage-class")
-	if storageClass != "" && storageClass != "STANDARD" {
-		s3base.WriteError(c, s3base.NewError(
-			http.StatusBadRequest,
-			"InvalidStorageClass",
-			"The storage class you specified is not valid.",
-			nil,
-		))
+	storageClass, apiError := h.requestStorageClass(c)
+	if apiError != nil {
+		s3base.WriteError(c, apiError)
 		return
 	}
-	metadata, apiError := parseRequestMetadata(c.Request, key)
+	metadata, apiError := parseRequestMetadata(c.Request)
 	if apiError != nil {
 		s3base.WriteError(c, apiError)
 		return
@@ -172,6 +167,7 @@ func (h *S3Handler) CreateMultipartUpload(c *gin.Context) {
 		ExpireAfter:  h.multipartExpiry,
 		Algorithm:    algorithm,
 		ChecksumType: checksumType,
+		StorageClass: storageClass,
 	})
 	if err != nil {
 		s3base.WriteError(c, multipartError(err))
@@ -613,7 +609,7 @@ func buildMultipartUploadListResult(
 			UploadID:          upload.UploadID,
 			Initiator:         owner,
 			Owner:             owner,
-			StorageClass:      "STANDARD",
+			StorageClass:      responseStorageClass(upload.StorageClass),
 			Initiated:         formatS3Timestamp(upload.Initiated),
 			ChecksumAlgorithm: string(upload.Algorithm),
 			ChecksumType:      string(upload.ChecksumType),
@@ -1073,7 +1069,8 @@ func multipartError(err error) *s3base.APIError {
 		return s3base.InvalidRequest("The multipart object size did not match.", err)
 	case errors.Is(err, filemgr.ErrS3Precondition),
 		errors.Is(err, filemgr.ErrS3ObjectConflict),
-		errors.Is(err, filemgr.ErrMultipartConflict):
+		errors.Is(err, filemgr.ErrMultipartConflict),
+		errors.Is(err, filemgr.ErrQuotaExceeded):
 		return mutationError(err)
 	default:
 		return s3base.InternalError(err)
@@ -27,7 +27,6 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/xxxsen/common/logutil"
-	"github.com/xxxsen/mimetype"
 	"go.uber.org/zap"
 )
 
@@ -105,6 +104,7 @@ func (h *S3Handler) DownloadObject(c *gin.Context) {
 		observation.readMode = objectReadModeRange
 	}
 	setObjectHeaders(c, info, includeChecksum)
+	h.setReplicationStatus(c, bucket.Name, key)
 	if includeChecksum {
 		applyResponseOverrides(c, options)
 	}
@@ -157,6 +157,7 @@ func (h *S3Handler) HeadObject(c *gin.Context) {
 		return
 	}
 	setObjectHeaders(c, info, true)
+	h.setReplicationStatus(c, bucket.Name, key)
 	applyResponseOverrides(c, options)
 	c.Header("Accept-Ranges", "bytes")
 	c.Header("Content-Length", strconv.FormatInt(info.Link.FileSize, 10))
@@ -329,7 +330,7 @@ func (h *S3Handler) UploadObject(c *gin.Context) {
 		s3base.WriteError(c, apiError)
 		return
 	}
-	fileID, hashes, apiError := h.receiveUpload(c, preparation.size)
+	fileID, hashes, apiError := h.receiveUpload(c, preparation)
 	if apiError != nil {
 		s3base.WriteError(c, apiError)
 		return
@@ -419,10 +420,11 @@ func hasUnsupportedObjectQuery(query url.Values) bool {
 }
 
 type uploadPreparation struct {
-	objectPath string
-	size       int64
-	metadata   *entity.S3ObjectMetadata
-	condition  *filemgr.S3Condition
+	objectPath   string
+	size         int64
+	metadata     *entity.S3ObjectMetadata
+	condition    *filemgr.S3Condition
+	storageClass string
 }
 
 func (h *S3Handler) prepareUpload(c *gin.Context) (*uploadPreparation, *s3base.APIError) {
@@ -456,7 +458,7 @@ func (h *S3Handler) prepareUpload(c *gin.Context) (*uploadPreparation, *s3base.A
 			nil,
 		)
 	}
-	metadata, apiError := parseRequestMetadata(c.Request, key)
+	metadata, apiError := parseRequestMetadata(c.Request)
 	if apiError != nil {
 		return nil, apiError
 	}
@@ -464,11 +466,16 @@ func (h *S3Handler) prepareUpload(c *gin.Context) (*uploadPreparation, *s3base.A
 	if apiError != nil {
 		return nil, apiError
 	}
+	storageClass, apiError := h.requestStorageClass(c)
+	if apiError != nil {
+		return nil, apiError
+	}
 	return &uploadPreparation{
-		objectPath: "/" + bucket.Name + "/" + key,
-		size:       size,
-		metadata:   metadata,
-		condition:  condition,
+		objectPath:   "/" + bucket.Name + "/" + key,
+		size:         size,
+		metadata:     metadata,
+		condition:    condition,
+		storageClass: storageClass,
 	}, nil
 }
 
@@ -496,13 +503,14 @@ func uploadSize(c *gin.Context) int64 {
 
 func (h *S3Handler) receiveUpload(
 	c *gin.Context,
-	size int64,
+	preparation *uploadPreparation,
 ) (uint64, *uploadHashes, *s3base.APIError) {
 	hashes, reader, apiError := newUploadHashes(c.Request)
 	if apiError != nil {
 		return 0, nil, apiError
 	}
-	fileID, err := h.fmgr.CreateFile(c.Request.Context(), size, reader)
+	ctx := filemgr.ContextWithStorageClass(c.Request.Context(), preparation.storageClass)
+	fileID, err := h.fmgr.CreateFile(ctx, preparation.size, reader)
 	if err != nil {
 		return 0, nil, uploadError(err)
 	}
@@ -530,7 +538,8 @@ func (h *S3Handler) receiveMultipartUpload(
 	if apiError != nil {
 		return 0, nil, apiError
 	}
-	fileID, err := h.fmgr.CreateFile(c.Request.Context(), size, reader)
+	ctx := filemgr.ContextWithStorageClass(c.Request.Context(), spec.StorageClass)
+	fileID, err := h.fmgr.CreateFile(ctx, size, reader)
 	if err != nil {
 		return 0, nil, uploadError(err)
 	}
@@ -685,6 +694,9 @@ func setObjectHeaders(c *gin.Context, info *filemgr.S3ObjectInfo, includeChecksu
 	setOptionalHeader(c, "Content-Encoding", metadata.ContentEncoding)
 	setOptionalHeader(c, "Content-Language", metadata.ContentLanguage)
 	setOptionalHeader(c, "Expires", metadata.Expires)
+	if info.StorageClass != "" && info.StorageClass != objectStorageClassStandard {
+		c.Header("x-amz-storage-class", info.StorageClass)
+	}
 	if includeChecksum {
 		setObjectChecksumHeaders(c, metadata)
 	}
@@ -892,12 +904,10 @@ func (h *S3Handler) fastCheckCondition(
 	return nil
 }
 
-func parseRequestMetadata(request *http.Request, key string) (*entity.S3ObjectMetadata, *s3base.APIError) {
+// parseRequestMetadata reads the object metadata headers. Without a
+// Content-Type the object is stored with the type sniffed from its content.
+func parseRequestMetadata(request *http.Request) (*entity.S3ObjectMetadata, *s3base.APIError) {
 	contentType := request.Header.Get("Content-Type")
-	if contentType == "" {
-		extension := path.Ext(key)
-		contentType = mimetype.LookupWithDefault(extension, "application/octet-stream")
-	}
 	cacheControl := request.Header.Get("Cache-Control")
 	if cacheControl == "" {
 		cacheControl = defaultObjectCacheControl
@@ -1177,6 +1187,14 @@ func mutationError(err error) *s3base.APIError {
 			err,
 		)
 	}
+	if errors.Is(err, filemgr.ErrQuotaExceeded) {
+		return s3base.NewError(
+			http.StatusForbidden,
+			"QuotaExceeded",
+			"The request exceeds the storage quota of the principal.",
+			err,
+		)
+	}
 	return s3base.InternalError(err)
 }
 
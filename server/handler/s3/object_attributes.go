@@ -294,7 +294,7 @@ func (h *S3Handler) buildObjectAttributesResponse(
 		}
 	}
 	if attributes.storageClass {
-		response.StorageClass = objectStorageClassStandard
+		response.StorageClass = responseStorageClass(info.StorageClass)
 	}
 	if attributes.objectSize {
 		size := info.Link.FileSize
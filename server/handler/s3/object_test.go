@@ -52,10 +52,11 @@ func TestRequestMetadataNormalizesUserMetadata(t *testing.T) {
 	request.Header.Add("X-Amz-Meta-Team", "two")
 	request.Header.Set("Expires", "Sun, 06 Nov 1994 08:49:37 GMT")
 
-	metadata, apiError := parseRequestMetadata(request, "a.txt")
+	metadata, apiError := parseRequestMetadata(request)
 
 	require.Nil(t, apiError)
-	require.Equal(t, "text/plain", metadata.ContentType)
+	// The file manager fills in the sniffed type when none was sent.
+	require.Empty(t, metadata.ContentType)
 	require.Equal(t, `{"team":"one,two"}`, metadata.UserMetadata)
 	require.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", metadata.Expires)
 }
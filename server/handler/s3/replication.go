@@ -0,0 +1,251 @@
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/replication"
+	"github.com/xxxsen/tgfile/server/handler/s3/s3base"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+)
+
+const (
+	replicationEnabled  = "Enabled"
+	replicationDisabled = "Disabled"
+)
+
+type replicationConfiguration struct {
+	XMLName xml.Name          `xml:"ReplicationConfiguration"`
+	XMLNS   string            `xml:"xmlns,attr,omitempty"`
+	Rules   []replicationRule `xml:"Rule"`
+}
+
+type replicationRule struct {
+	ID       string             `xml:"ID"`
+	Priority int                `xml:"Priority"`
+	Status   string             `xml:"Status"`
+	Prefix   *string            `xml:"Prefix"`
+	Filter   *replicationFilter `xml:"Filter"`
+	// Destination.Account names the configured replication target; the
+	// bucket ARN names the bucket on that target.
+	Destination             replicationDestination   `xml:"Destination"`
+	DeleteMarkerReplication *replicationDeleteMarker `xml:"DeleteMarkerReplication"`
+}
+
+type replicationFilter struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type replicationDestination struct {
+	Bucket  string `xml:"Bucket"`
+	Account string `xml:"Account,omitempty"`
+}
+
+type replicationDeleteMarker struct {
+	Status string `xml:"Status"`
+}
+
+// GetBucketReplication reports the stored rules. Target endpoints and
+// credentials stay in the service configuration and are not exposed.
+func (h *S3Handler) GetBucketReplication(c *gin.Context, bucketName string) {
+	if h.replication == nil {
+		writeUnsupportedBucketSubresource(c)
+		return
+	}
+	rules, err := h.replication.Rules(c.Request.Context(), bucketName)
+	if err != nil {
+		s3base.WriteError(c, s3base.InternalError(err))
+		return
+	}
+	if len(rules) == 0 {
+		s3base.WriteError(c, noSuchReplicationConfiguration(bucketName))
+		return
+	}
+	result := &replicationConfiguration{
+		XMLNS: s3XMLNamespace,
+		Rules: make([]replicationRule, 0, len(rules)),
+	}
+	for _, rule := range rules {
+		result.Rules = append(result.Rules, replicationRuleXML(rule))
+	}
+	c.XML(http.StatusOK, result)
+}
+
+// PutBucketReplication replaces every rule of the bucket. Rules only apply to
+// changes made after they are stored.
+func (h *S3Handler) PutBucketReplication(c *gin.Context) {
+	bucketName, apiError := h.authorizeReplication(c)
+	if apiError != nil {
+		s3base.WriteError(c, apiError)
+		return
+	}
+	body, apiError := readBucketConfigurationBody(c)
+	if apiError != nil {
+		s3base.WriteError(c, apiError)
+		return
+	}
+	var request replicationConfiguration
+	if apiError := decodeBucketConfiguration(body, &request); apiError != nil {
+		s3base.WriteError(c, apiError)
+		return
+	}
+	rules, apiError := replicationRules(bucketName, request.Rules)
+	if apiError != nil {
+		s3base.WriteError(c, apiError)
+		return
+	}
+	for _, rule := range rules {
+		if !scopeCovers(c, bucketName, rule.Prefix) {
+			s3base.WriteError(c, s3base.AccessDenied(errPermissionDenied))
+			return
+		}
+	}
+	if err := h.replication.PutRules(c.Request.Context(), bucketName, rules); err != nil {
+		s3base.WriteError(c, replicationError(err))
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (h *S3Handler) DeleteBucketReplication(c *gin.Context) {
+	bucketName, apiError := h.authorizeReplication(c)
+	if apiError != nil {
+		s3base.WriteError(c, apiError)
+		return
+	}
+	if !scopeCovers(c, bucketName, "") {
+		s3base.WriteError(c, s3base.AccessDenied(errPermissionDenied))
+		return
+	}
+	if err := h.replication.DeleteRules(c.Request.Context(), bucketName); err != nil {
+		s3base.WriteError(c, replicationError(err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *S3Handler) authorizeReplication(c *gin.Context) (string, *s3base.APIError) {
+	bucketName, _ := requestBucketKey(c.Request.URL.Path)
+	if _, exists := h.Bucket(bucketName); !exists {
+		return "", noSuchBucketError(bucketName)
+	}
+	if _, apiError := h.Authorize(c, true, authz.S3Write); apiError != nil {
+		return "", apiError
+	}
+	if h.replication == nil {
+		return "", s3base.NewError(
+			http.StatusNotImplemented,
+			"NotImplemented",
+			"The requested bucket subresource is not implemented.",
+			nil,
+		)
+	}
+	return bucketName, nil
+}
+
+func replicationRules(bucketName string, input []replicationRule) ([]replication.Rule, *s3base.APIError) {
+	rules := make([]replication.Rule, 0, len(input))
+	for index, item := range input {
+		if item.Status != replicationEnabled && item.Status != replicationDisabled {
+			return nil, invalidArgumentError("The replication rule Status must be Enabled or Disabled.")
+		}
+		if !strings.HasPrefix(item.Destination.Bucket, s3BucketARNPrefix) {
+			return nil, invalidArgumentError("The destination bucket must be an S3 bucket ARN.")
+		}
+		if item.Destination.Account == "" {
+			return nil, invalidArgumentError("The destination Account must name a replication target.")
+		}
+		rule := replication.Rule{
+			ID:           item.ID,
+			Bucket:       bucketName,
+			Priority:     item.Priority,
+			Enabled:      item.Status == replicationEnabled,
+			Target:       item.Destination.Account,
+			TargetBucket: strings.TrimPrefix(item.Destination.Bucket, s3BucketARNPrefix),
+		}
+		if rule.Priority == 0 {
+			rule.Priority = index + 1
+		}
+		switch {
+		case item.Filter != nil:
+			rule.Prefix = item.Filter.Prefix
+		case item.Prefix != nil:
+			rule.Prefix = *item.Prefix
+		}
+		if item.DeleteMarkerReplication != nil {
+			switch item.DeleteMarkerReplication.Status {
+			case replicationEnabled:
+				rule.ReplicateDeletes = true
+			case replicationDisabled:
+			default:
+				return nil, invalidArgumentError("The DeleteMarkerReplication Status must be Enabled or Disabled.")
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func replicationRuleXML(rule replication.Rule) replicationRule {
+	status, deletes := replicationDisabled, replicationDisabled
+	if rule.Enabled {
+		status = replicationEnabled
+	}
+	if rule.ReplicateDeletes {
+		deletes = replicationEnabled
+	}
+	return replicationRule{
+		ID:       rule.ID,
+		Priority: rule.Priority,
+		Status:   status,
+		Filter:   &replicationFilter{Prefix: rule.Prefix},
+		Destination: replicationDestination{
+			Bucket:  s3BucketARNPrefix + rule.TargetBucket,
+			Account: rule.Target,
+		},
+		DeleteMarkerReplication: &replicationDeleteMarker{Status: deletes},
+	}
+}
+
+func replicationError(err error) *s3base.APIError {
+	if errors.Is(err, replication.ErrInvalidRule) {
+		return s3base.NewError(http.StatusBadRequest, "InvalidArgument", err.Error(), err)
+	}
+	return s3base.InternalError(err)
+}
+
+func noSuchReplicationConfiguration(bucketName string) *s3base.APIError {
+	apiError := s3base.NewError(
+		http.StatusNotFound,
+		"ReplicationConfigurationNotFoundError",
+		"The replication configuration was not found.",
+		nil,
+	)
+	apiError.Bucket = bucketName
+	return apiError
+}
+
+func (h *S3Handler) setReplicationStatus(c *gin.Context, bucket, key string) {
+	if h.replication == nil {
+		return
+	}
+	status, err := h.replication.Status(c.Request.Context(), bucket, key)
+	if err != nil {
+		logutil.GetLogger(c.Request.Context()).Warn(
+			"read S3 replication status failed",
+			zap.String("bucket", bucket),
+			zap.String("key", key),
+			zap.Error(err),
+		)
+		return
+	}
+	if status != "" {
+		c.Header("x-amz-replication-status", string(status))
+	}
+}
@@ -10,8 +10,13 @@ import (
 	"sync"
 	"time"
 
+	"github.com/xxxsen/tgfile/accesslog"
 	"github.com/xxxsen/tgfile/authz"
 	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/inventory"
+	"github.com/xxxsen/tgfile/lifecycle"
+	"github.com/xxxsen/tgfile/replication"
+	"github.com/xxxsen/tgfile/s3session"
 	"github.com/xxxsen/tgfile/server/handler/s3/s3base"
 
 	"github.com/gin-gonic/gin"
@@ -39,6 +44,10 @@ type Bucket struct {
 
 type Identity struct {
 	Username string
+	// AccessKeyID and Scope are set for temporary session credentials;
+	// Username is then the principal the credential was issued for.
+	AccessKeyID string
+	Scope       *authz.Scope
 }
 
 type Config struct {
@@ -47,6 +56,12 @@ type Config struct {
 	MultipartExpireHours int
 	Users                map[string]string
 	Authorizer           *authz.Authorizer
+	BaseDomain           string
+	Sessions             *s3session.Store
+	Replication          *replication.Manager
+	Lifecycle            *lifecycle.Manager
+	Inventory            *inventory.Manager
+	AccessLog            *accesslog.Manager
 }
 
 type S3Handler struct {
@@ -60,6 +75,11 @@ type S3Handler struct {
 	users           map[string]string
 	authorizer      *authz.Authorizer
 	verifier        *s3verify.Verifier
+	baseDomain      string
+	replication     *replication.Manager
+	lifecycle       *lifecycle.Manager
+	inventory       *inventory.Manager
+	accessLog       *accesslog.Manager
 }
 
 func NewS3Handler(fmgr filemgr.IFileManager, configs ...Config) *S3Handler {
@@ -78,9 +98,20 @@ func NewS3Handler(fmgr filemgr.IFileManager, configs ...Config) *S3Handler {
 		users[accessKey] = secret
 	}
 	provider := s3verify.CredentialProviderFunc(func(
-		_ context.Context,
+		ctx context.Context,
 		accessKey string,
 	) (s3verify.Credential, bool, error) {
+		if lookup, ok := ctx.Value(sessionLookupKey{}).(*sessionLookup); ok && lookup.token != "" {
+			secret, exists, err := resolveSessionCredential(ctx, config.Sessions, lookup, accessKey)
+			if err != nil || !exists {
+				return s3verify.Credential{}, false, err
+			}
+			return s3verify.Credential{
+				AccessKeyID:     accessKey,
+				SecretAccessKey: secret,
+				SessionToken:    lookup.token,
+			}, true, nil
+		}
 		secret, exists := users[accessKey]
 		if !exists {
 			return s3verify.Credential{}, false, nil
@@ -110,6 +141,11 @@ func NewS3Handler(fmgr filemgr.IFileManager, configs ...Config) *S3Handler {
 		users:           users,
 		authorizer:      config.Authorizer,
 		verifier:        verifier,
+		baseDomain:      strings.TrimSuffix(strings.ToLower(config.BaseDomain), "."),
+		replication:     config.Replication,
+		lifecycle:       config.Lifecycle,
+		inventory:       config.Inventory,
+		accessLog:       config.AccessLog,
 	}
 }
 
@@ -145,17 +181,31 @@ func (h *S3Handler) Authorize(
 		if hasSigQuery {
 			return nil, s3base.InvalidRequest("Multiple authentication mechanisms are not allowed.", nil)
 		}
-		accessKey, secret, ok := c.Request.BasicAuth()
-		expected, exists := h.users[accessKey]
-		if !ok || !exists || !hmac.Equal([]byte(expected), []byte(secret)) {
-			return nil, s3base.AccessDenied(errBasicAuthentication)
-		}
-		if !h.hasPermissions(accessKey, permissions) {
-			return nil, s3base.AccessDenied(errPermissionDenied)
-		}
-		return &Identity{Username: accessKey}, nil
+		return h.authorizeBasic(c, permissions)
+	}
+	return h.authorizeSigned(c, permissions)
+}
+
+func (h *S3Handler) authorizeBasic(c *gin.Context, permissions []authz.Permission) (*Identity, *s3base.APIError) {
+	accessKey, secret, ok := c.Request.BasicAuth()
+	expected, exists := h.users[accessKey]
+	if !ok || !exists || !hmac.Equal([]byte(expected), []byte(secret)) {
+		return nil, s3base.AccessDenied(errBasicAuthentication)
+	}
+	if !h.hasPermissions(accessKey, permissions) {
+		return nil, s3base.AccessDenied(errPermissionDenied)
 	}
-	result, err := h.verifier.Verify(c.Request.Context(), c.Request)
+	identity := &Identity{Username: accessKey}
+	setIdentity(c, identity)
+	return identity, nil
+}
+
+// authorizeSigned verifies a SigV4 header or presigned query. Temporary
+// session credentials act as their owner, narrowed to the session scope.
+func (h *S3Handler) authorizeSigned(c *gin.Context, permissions []authz.Permission) (*Identity, *s3base.APIError) {
+	lookup := &sessionLookup{token: requestSessionToken(c.Request)}
+	ctx := context.WithValue(c.Request.Context(), sessionLookupKey{}, lookup)
+	result, err := h.verifier.Verify(ctx, requestForVerification(c.Request))
 	if err != nil {
 		return nil, verifierAPIError(err)
 	}
@@ -164,10 +214,30 @@ func (h *S3Handler) Authorize(
 		c.Set("s3-decoded-content-length", result.DecodedContentLength)
 	}
 	c.Set("s3-verified-trailers", result.Trailers)
-	if !h.hasPermissions(result.AccessKeyID, permissions) {
+	identity := &Identity{Username: result.AccessKeyID}
+	if lookup.session != nil {
+		scope := lookup.session.Scope()
+		identity = &Identity{
+			Username:    lookup.session.Owner,
+			AccessKeyID: result.AccessKeyID,
+			Scope:       &scope,
+		}
+	}
+	if !h.identityHasPermissions(identity, permissions) {
 		return nil, s3base.AccessDenied(errPermissionDenied)
 	}
-	return &Identity{Username: result.AccessKeyID}, nil
+	if identity.Scope != nil && !scopeCoversRequest(c.Request, *identity.Scope) {
+		return nil, s3base.AccessDenied(errPermissionDenied)
+	}
+	setIdentity(c, identity)
+	return identity, nil
+}
+
+// setIdentity records the authenticated principal, which also owns the
+// objects the request writes.
+func setIdentity(c *gin.Context, identity *Identity) {
+	c.Set(identityContextKey, identity)
+	c.Request = c.Request.WithContext(filemgr.ContextWithOwner(c.Request.Context(), identity.Username))
 }
 
 func (h *S3Handler) hasPermissions(username string, permissions []authz.Permission) bool {
@@ -0,0 +1,131 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/s3session"
+)
+
+const identityContextKey = "s3-identity"
+
+type sessionLookupKey struct{}
+
+// sessionLookup carries the presented session token into the credential
+// provider and the resolved session back out of it.
+type sessionLookup struct {
+	token   string
+	session *s3session.Session
+}
+
+func requestSessionToken(request *http.Request) string {
+	if token := request.Header.Get("X-Amz-Security-Token"); token != "" {
+		return token
+	}
+	for key, values := range request.URL.Query() {
+		if strings.EqualFold(key, "x-amz-security-token") && len(values) != 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+func resolveSessionCredential(
+	ctx context.Context,
+	sessions *s3session.Store,
+	lookup *sessionLookup,
+	accessKey string,
+) (string, bool, error) {
+	if sessions == nil {
+		return "", false, nil
+	}
+	session, secret, ok, err := sessions.Lookup(ctx, accessKey, lookup.token)
+	if err != nil {
+		return "", false, fmt.Errorf("resolve session credential: %w", err)
+	}
+	if !ok {
+		return "", false, nil
+	}
+	lookup.session = session
+	return secret, true, nil
+}
+
+func (h *S3Handler) identityHasPermissions(identity *Identity, permissions []authz.Permission) bool {
+	if identity.Scope == nil {
+		return h.hasPermissions(identity.Username, permissions)
+	}
+	for _, permission := range permissions {
+		if !h.authorizer.HasScoped(identity.Username, *identity.Scope, permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// scopeCoversRequest checks the action of the request and the bucket and key
+// addressed by its path. Keys carried elsewhere (copy source, DeleteObjects
+// body) are checked by the handlers through scopeCovers.
+func scopeCoversRequest(request *http.Request, scope authz.Scope) bool {
+	permission, known := requestPermission(request)
+	if !known || !scope.Permits(permission) {
+		return false
+	}
+	bucket, key := requestBucketKey(request.URL.Path)
+	if bucket == "" {
+		return scope.Bucket == ""
+	}
+	if key != "" {
+		return scope.Covers(bucket, key)
+	}
+	if scope.Bucket != "" && scope.Bucket != bucket {
+		return false
+	}
+	switch {
+	case scope.Prefix == "", request.Method == http.MethodHead, request.Method == http.MethodPost:
+		return true
+	default:
+		return strings.HasPrefix(request.URL.Query().Get("prefix"), scope.Prefix)
+	}
+}
+
+// requestPermission maps a request to the action it performs. Each POST
+// subresource names one action: ?delete on a bucket is DeleteObjects, ?uploads
+// and ?uploadId on a key create and complete multipart uploads. Any other POST
+// is unknown and refused to scoped credentials.
+func requestPermission(request *http.Request) (authz.Permission, bool) {
+	if request.Method == http.MethodGet || request.Method == http.MethodHead {
+		return authz.S3Read, true
+	}
+	if request.Method != http.MethodPost {
+		return authz.S3Write, true
+	}
+	query := request.URL.Query()
+	_, key := requestBucketKey(request.URL.Path)
+	switch {
+	case key == "" && hasQueryKey(query, "delete"):
+		return authz.S3Write, true
+	case key != "" && (hasQueryKey(query, "uploads") || hasQueryKey(query, "uploadId")):
+		return authz.S3Write, true
+	default:
+		return "", false
+	}
+}
+
+// scopeCovers reports whether the authenticated identity of the request may
+// address bucket/key.
+func scopeCovers(c *gin.Context, bucket, key string) bool {
+	value, exists := c.Get(identityContextKey)
+	if !exists {
+		return true
+	}
+	identity, ok := value.(*Identity)
+	if !ok || identity.Scope == nil {
+		return true
+	}
+	return identity.Scope.Covers(bucket, key)
+}
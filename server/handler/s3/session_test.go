@@ -0,0 +1,37 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/authz"
+)
+
+func TestScopeCoversRequestChecksPostActions(t *testing.T) {
+	readOnly := authz.Scope{Bucket: "data", Prefix: "ci/", ReadOnly: true}
+	writable := authz.Scope{Bucket: "data", Prefix: "ci/"}
+	for _, tc := range []struct {
+		method, target string
+		scope          authz.Scope
+		want           bool
+	}{
+		{http.MethodPost, "/data?delete", readOnly, false},
+		{http.MethodPost, "/data?delete", writable, true},
+		{http.MethodPost, "/other?delete", writable, false},
+		{http.MethodPost, "/data", writable, false},
+		{http.MethodPost, "/data?uploads", writable, false},
+		{http.MethodPost, "/data/ci/a.bin?uploads", readOnly, false},
+		{http.MethodPost, "/data/ci/a.bin?uploads", writable, true},
+		{http.MethodPost, "/data/ci/a.bin?uploadId=1", writable, true},
+		{http.MethodPost, "/data/out/a.bin?uploadId=1", writable, false},
+		{http.MethodPost, "/data/ci/a.bin?select", writable, false},
+		{http.MethodPut, "/data/ci/a.bin", readOnly, false},
+		{http.MethodGet, "/data/ci/a.bin", readOnly, true},
+	} {
+		request := httptest.NewRequest(tc.method, tc.target, nil)
+		require.Equal(t, tc.want, scopeCoversRequest(request, tc.scope), tc.method+" "+tc.target)
+	}
+}
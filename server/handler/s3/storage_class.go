@@ -0,0 +1,33 @@
+package s3
+
+import (
+	"net/http"
+
+	"github.com/xxxsen/tgfile/server/handler/s3/s3base"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestStorageClass returns the x-amz-storage-class of a write request. An
+// empty class selects the default class of the file manager.
+func (h *S3Handler) requestStorageClass(c *gin.Context) (string, *s3base.APIError) {
+	class := c.GetHeader("x-amz-storage-class")
+	if class == "" || h.fmgr.StorageClassExists(class) {
+		return class, nil
+	}
+	return "", s3base.NewError(
+		http.StatusBadRequest,
+		"InvalidStorageClass",
+		"The storage class you specified is not valid.",
+		nil,
+	)
+}
+
+// responseStorageClass maps a stored class to the value S3 reports; an empty
+// class belongs to a caller that could not resolve it and reads as STANDARD.
+func responseStorageClass(class string) string {
+	if class == "" {
+		return objectStorageClassStandard
+	}
+	return class
+}
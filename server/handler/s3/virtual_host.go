@@ -0,0 +1,88 @@
+package s3
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type virtualHostKey struct{}
+
+type virtualHostedRequest struct {
+	bucket  string
+	path    string
+	rawPath string
+}
+
+// virtualHostBucket returns the bucket label of a virtual-hosted-style host,
+// e.g. "photos" for "photos.s3.example.com:9000" with base domain
+// "s3.example.com".
+func (h *S3Handler) virtualHostBucket(host string) (string, bool) {
+	if h.baseDomain == "" {
+		return "", false
+	}
+	hostname := host
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		hostname = name
+	}
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	bucket, ok := strings.CutSuffix(hostname, "."+h.baseDomain)
+	if !ok || bucket == "" {
+		return "", false
+	}
+	return bucket, true
+}
+
+// RewriteVirtualHostedRequest maps a virtual-hosted-style request onto the
+// path-style layout used by the router. The original path is kept in the
+// request context so signature verification still sees what the client signed.
+func (h *S3Handler) RewriteVirtualHostedRequest(request *http.Request) (*http.Request, bool) {
+	bucket, ok := h.virtualHostBucket(request.Host)
+	if !ok {
+		return request, false
+	}
+	original := virtualHostedRequest{
+		bucket:  bucket,
+		path:    request.URL.Path,
+		rawPath: request.URL.RawPath,
+	}
+	ctx := context.WithValue(request.Context(), virtualHostKey{}, original)
+	clone := request.Clone(ctx)
+	cloneURL := *request.URL
+	cloneURL.Path = "/" + bucket + ensureLeadingSlash(original.path)
+	if original.rawPath != "" {
+		cloneURL.RawPath = "/" + bucket + ensureLeadingSlash(original.rawPath)
+	}
+	clone.URL = &cloneURL
+	return clone, true
+}
+
+// VirtualHostedBucket reports the bucket addressed through the Host header.
+func VirtualHostedBucket(request *http.Request) (string, bool) {
+	original, ok := request.Context().Value(virtualHostKey{}).(virtualHostedRequest)
+	if !ok {
+		return "", false
+	}
+	return original.bucket, true
+}
+
+func requestForVerification(request *http.Request) *http.Request {
+	original, ok := request.Context().Value(virtualHostKey{}).(virtualHostedRequest)
+	if !ok {
+		return request
+	}
+	clone := *request
+	cloneURL := *request.URL
+	cloneURL.Path = original.path
+	cloneURL.RawPath = original.rawPath
+	clone.URL = &cloneURL
+	return &clone
+}
+
+func ensureLeadingSlash(value string) string {
+	if strings.HasPrefix(value, "/") {
+		return value
+	}
+	return "/" + value
+}
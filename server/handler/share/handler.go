@@ -0,0 +1,213 @@
+package share
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xxxsen/common/webapi/proxyutil"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/filekey"
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/sharelink"
+)
+
+// WebDAVReader decides whether a principal can read a namespace path over
+// WebDAV, including its ACL.
+type WebDAVReader interface {
+	CanRead(ctx context.Context, principal, resourcePath string) (bool, error)
+}
+
+// Sources describes the protocols a shared path can be read through.
+type Sources struct {
+	// Buckets are the configured S3 buckets, stored below /<bucket>.
+	Buckets []string
+	// WebDAV is nil when WebDAV is disabled.
+	WebDAV WebDAVReader
+}
+
+type Handler struct {
+	store      *sharelink.Store
+	files      filemgr.IFileManager
+	authorizer *authz.Authorizer
+	sources    Sources
+	limiter    *passwordLimiter
+	cookieKey  []byte
+}
+
+var errTrailingJSON = errors.New("request contains trailing JSON")
+
+func New(
+	store *sharelink.Store,
+	files filemgr.IFileManager,
+	authorizer *authz.Authorizer,
+	sources Sources,
+) *Handler {
+	return &Handler{
+		store:      store,
+		files:      files,
+		authorizer: authorizer,
+		sources:    sources,
+		limiter:    newPasswordLimiter(),
+		cookieKey:  newPasswordCookieKey(),
+	}
+}
+
+type createRequest struct {
+	Path           string `json:"path"`
+	ExpiresSeconds int64  `json:"expires_seconds"`
+	Password       string `json:"password"`
+	MaxDownloads   int64  `json:"max_downloads"`
+	Listing        bool   `json:"listing"`
+}
+
+// Create shares a path the principal can read itself. The token in the
+// response is the only copy; the link is reached at its url_path below the
+// service origin.
+func (h *Handler) Create(c *gin.Context) {
+	owner, ok := h.authorize(c)
+	if !ok {
+		return
+	}
+	var request createRequest
+	if err := decodeJSON(c.Request.Body, &request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share link request"})
+		return
+	}
+	if !strings.HasPrefix(request.Path, "/") || path.Clean(request.Path) != request.Path {
+		writeError(c, fmt.Errorf("%w: path must be a clean absolute path", sharelink.ErrInvalidRequest))
+		return
+	}
+	readable, err := h.canRead(c.Request.Context(), owner, request.Path)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	if !readable {
+		c.Status(http.StatusForbidden)
+		return
+	}
+	created, err := h.store.Create(c.Request.Context(), sharelink.CreateRequest{
+		Owner:        owner,
+		Path:         request.Path,
+		Expires:      time.Duration(request.ExpiresSeconds) * time.Second,
+		Password:     request.Password,
+		MaxDownloads: request.MaxDownloads,
+		Listing:      request.Listing,
+	})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+func (h *Handler) List(c *gin.Context) {
+	owner, ok := h.authorize(c)
+	if !ok {
+		return
+	}
+	links, err := h.store.List(c.Request.Context(), owner)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"links": links})
+}
+
+func (h *Handler) Revoke(c *gin.Context) {
+	owner, ok := h.authorize(c)
+	if !ok {
+		return
+	}
+	if err := h.store.Revoke(c.Request.Context(), owner, c.Param("share_id")); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// authorize admits principals with share:write. They manage their own links
+// only; the admin UI and the CLI see the links of every principal.
+func (h *Handler) authorize(c *gin.Context) (string, bool) {
+	user, ok := proxyutil.GetUserInfo(c.Request.Context())
+	if !ok {
+		c.Header("WWW-Authenticate", `Basic realm="Restricted Area"`)
+		c.Status(http.StatusUnauthorized)
+		return "", false
+	}
+	if !h.authorizer.Has(user.Username, authz.ShareWrite) {
+		c.Status(http.StatusForbidden)
+		return "", false
+	}
+	return user.Username, true
+}
+
+// canRead reports whether principal can read sharedPath through any protocol
+// it is stored under: an S3 bucket with s3:read, a file it uploaded directly
+// with file:write (any direct upload with admin:write), or WebDAV with
+// DAV:read.
+func (h *Handler) canRead(ctx context.Context, principal, sharedPath string) (bool, error) {
+	bucket, _, _ := strings.Cut(strings.TrimPrefix(sharedPath, "/"), "/")
+	if slices.Contains(h.sources.Buckets, bucket) && h.authorizer.Has(principal, authz.S3Read) {
+		return true, nil
+	}
+	if strings.HasPrefix(sharedPath, filekey.Prefix) && h.authorizer.Has(principal, authz.FileWrite) {
+		info, err := h.files.StatFileLink(ctx, sharedPath)
+		if err != nil {
+			return false, fmt.Errorf("stat shared upload: %w", err)
+		}
+		// Directories below the prefix are shared by every uploader; only
+		// an administrator may share one.
+		if (!info.IsDir && info.Owner == principal) || h.authorizer.Has(principal, authz.AdminWrite) {
+			return true, nil
+		}
+	}
+	if h.sources.WebDAV == nil {
+		return false, nil
+	}
+	readable, err := h.sources.WebDAV.CanRead(ctx, principal, sharedPath)
+	if err != nil {
+		return false, fmt.Errorf("authorize shared path: %w", err)
+	}
+	return readable, nil
+}
+
+func decodeJSON(reader io.Reader, output any) error {
+	decoder := json.NewDecoder(io.LimitReader(reader, 16*1024))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(output); err != nil {
+		return fmt.Errorf("decode request: %w", err)
+	}
+	var extra any
+	if err := decoder.Decode(&extra); !errors.Is(err, io.EOF) {
+		return errTrailingJSON
+	}
+	return nil
+}
+
+func writeError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	message := "share link operation failed"
+	switch {
+	case errors.Is(err, sharelink.ErrInvalidRequest):
+		status, message = http.StatusBadRequest, err.Error()
+	case errors.Is(err, sharelink.ErrNotFound):
+		status, message = http.StatusNotFound, "share link not found"
+	case errors.Is(err, sharelink.ErrNotDirectory):
+		status, message = http.StatusBadRequest, err.Error()
+	case errors.Is(err, os.ErrNotExist):
+		status, message = http.StatusNotFound, "path not found"
+	}
+	c.JSON(status, gin.H{"error": message})
+}
@@ -0,0 +1,95 @@
+package share
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+const (
+	maxPasswordLimiterKeys = 4096
+	maxPasswordFailures    = 5
+	maxGlobalPasswordTries = 120
+	passwordFailureWindow  = 5 * time.Minute
+	globalPasswordWindow   = time.Minute
+)
+
+type passwordFailure struct {
+	attempts []time.Time
+}
+
+// passwordLimiter bounds the password hashes visitors can make the server
+// compute: a client gets a few failures per link and window, and all
+// clients together a fixed number of checks per minute.
+type passwordLimiter struct {
+	mu       sync.Mutex
+	failures map[[sha256.Size]byte]*passwordFailure
+	global   []time.Time
+}
+
+func newPasswordLimiter() *passwordLimiter {
+	return &passwordLimiter{failures: make(map[[sha256.Size]byte]*passwordFailure)}
+}
+
+func (l *passwordLimiter) begin(peer, linkID string, now time.Time) ([sha256.Size]byte, bool) {
+	key := sha256.Sum256([]byte(peer + "\x00" + linkID))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.global = trimTimes(l.global, now.Add(-globalPasswordWindow))
+	if len(l.global) >= maxGlobalPasswordTries {
+		return key, false
+	}
+	record, exists := l.failures[key]
+	if !exists {
+		if len(l.failures) >= maxPasswordLimiterKeys {
+			l.cleanupLocked(now)
+			if len(l.failures) >= maxPasswordLimiterKeys {
+				return key, false
+			}
+		}
+		l.global = append(l.global, now)
+		return key, true
+	}
+	record.attempts = trimTimes(record.attempts, now.Add(-passwordFailureWindow))
+	if len(record.attempts) == 0 {
+		delete(l.failures, key)
+	} else if len(record.attempts) >= maxPasswordFailures {
+		return key, false
+	}
+	l.global = append(l.global, now)
+	return key, true
+}
+
+func (l *passwordLimiter) fail(key [sha256.Size]byte, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	record := l.failures[key]
+	if record == nil {
+		record = &passwordFailure{}
+		l.failures[key] = record
+	}
+	record.attempts = append(trimTimes(record.attempts, now.Add(-passwordFailureWindow)), now)
+}
+
+func (l *passwordLimiter) success(key [sha256.Size]byte) {
+	l.mu.Lock()
+	delete(l.failures, key)
+	l.mu.Unlock()
+}
+
+func (l *passwordLimiter) cleanupLocked(now time.Time) {
+	for key, record := range l.failures {
+		record.attempts = trimTimes(record.attempts, now.Add(-passwordFailureWindow))
+		if len(record.attempts) == 0 {
+			delete(l.failures, key)
+		}
+	}
+}
+
+func trimTimes(values []time.Time, cutoff time.Time) []time.Time {
+	index := 0
+	for index < len(values) && values[index].Before(cutoff) {
+		index++
+	}
+	return values[index:]
+}
@@ -0,0 +1,110 @@
+package share
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/sharelink"
+)
+
+const (
+	passwordCookieName = "tgfile_share"
+	// passwordCookieLifetime is how long a browser skips the password check
+	// after entering the password once.
+	passwordCookieLifetime = 15 * time.Minute
+	passwordCookieKeyBytes = 32
+)
+
+// newPasswordCookieKey returns the key cookies are signed with. It lives in
+// memory, so a restart asks visitors for the password again.
+func newPasswordCookieKey() []byte {
+	key := make([]byte, passwordCookieKeyBytes)
+	// crypto/rand.Read does not return errors since Go 1.24.
+	_, _ = rand.Read(key)
+	return key
+}
+
+// checkPassword admits a visitor to a link with a password. A signed cookie
+// from an earlier success skips the password hash; failures are rate
+// limited per client and link before the hash is computed.
+func (h *Handler) checkPassword(c *gin.Context, token string, link *sharelink.Link) bool {
+	if !link.HasPassword || h.validPasswordCookie(c, link, time.Now()) {
+		return true
+	}
+	_, password, _ := c.Request.BasicAuth()
+	if password == "" {
+		writePasswordRequired(c)
+		return false
+	}
+	key, allowed := h.limiter.begin(directPeerIP(c.Request), link.ID, time.Now())
+	if !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(passwordFailureWindow.Seconds())))
+		c.String(http.StatusTooManyRequests, "too many password attempts\n")
+		return false
+	}
+	if !link.CheckPassword(password) {
+		h.limiter.fail(key, time.Now())
+		writePasswordRequired(c)
+		return false
+	}
+	h.limiter.success(key)
+	expires := time.Now().Add(passwordCookieLifetime)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     passwordCookieName,
+		Value:    h.signPasswordCookie(link.ID, expires),
+		Path:     "/s/" + token,
+		Expires:  expires,
+		Secure:   c.Request.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return true
+}
+
+func (h *Handler) validPasswordCookie(c *gin.Context, link *sharelink.Link, now time.Time) bool {
+	value, err := c.Cookie(passwordCookieName)
+	if err != nil {
+		return false
+	}
+	rawExpiry, _, _ := strings.Cut(value, ".")
+	expiry, err := strconv.ParseInt(rawExpiry, 10, 64)
+	if err != nil || !now.Before(time.Unix(expiry, 0)) {
+		return false
+	}
+	return hmac.Equal([]byte(value), []byte(h.signPasswordCookie(link.ID, time.Unix(expiry, 0))))
+}
+
+// signPasswordCookie binds the cookie to one link and its expiry.
+func (h *Handler) signPasswordCookie(linkID string, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, h.cookieKey)
+	mac.Write([]byte(linkID + "\x00" + expiry))
+	return expiry + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func writePasswordRequired(c *gin.Context) {
+	c.Header("WWW-Authenticate", `Basic realm="tgfile share", charset="UTF-8"`)
+	c.String(http.StatusUnauthorized, "password required\n")
+}
+
+// directPeerIP keys the limiter by the connection peer; forwarded headers
+// are under the client's control.
+func directPeerIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err == nil {
+		return host
+	}
+	if len(request.RemoteAddr) > 256 {
+		return request.RemoteAddr[:256]
+	}
+	return request.RemoteAddr
+}
@@ -0,0 +1,296 @@
+package share
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/server/httpkit"
+	"github.com/xxxsen/tgfile/sharelink"
+)
+
+// maxListingEntries bounds one listing page; larger directories show the
+// first entries only.
+const maxListingEntries = 500
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!doctype html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<style>
+body{font-family:system-ui,sans-serif;margin:2rem auto;max-width:60rem;padding:0 1rem;color:#222}
+table{border-collapse:collapse;width:100%}td,th{padding:.4rem;border-bottom:1px solid #ddd;text-align:left}
+td.size{text-align:right;white-space:nowrap}a{color:#0b57d0;text-decoration:none}
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<table>
+<thead><tr><th>名称</th><th>大小</th><th>修改时间</th></tr></thead>
+<tbody>
+{{if .Parent}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>{{end}}
+{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td class="size">{{.Size}}</td><td>{{.Mtime}}</td></tr>
+{{end}}
+</tbody>
+</table>
+{{if .Truncated}}<p>目录过大，仅显示前 {{.Limit}} 项。</p>{{end}}
+</body>
+</html>
+`))
+
+type listingPage struct {
+	Title     string
+	Parent    bool
+	Entries   []listingEntry
+	Truncated bool
+	Limit     int
+}
+
+type listingEntry struct {
+	Name  string
+	Href  string
+	Size  string
+	Mtime string
+}
+
+// Serve answers GET and HEAD on /s/<token>[/<path>]. A file link serves the
+// file; a directory link serves the files below it and, when listing is
+// enabled, an index page for each directory. A password is sent as the
+// password of HTTP Basic authentication; a correct one sets a cookie that
+// spares the browser the check for the next few minutes.
+func (h *Handler) Serve(c *gin.Context) {
+	token, rest, _ := strings.Cut(strings.TrimPrefix(c.Param("share"), "/"), "/")
+	ctx := c.Request.Context()
+	setShareHeaders(c)
+	link, err := h.store.Resolve(ctx, token)
+	if err != nil {
+		writeServeError(c, err)
+		return
+	}
+	if !h.checkPassword(c, token, link) {
+		return
+	}
+	target := link.Path
+	if rest != "" {
+		target = path.Join(link.Path, path.Clean("/"+rest))
+	}
+	info, err := h.files.StatFileLink(ctx, target)
+	if err != nil {
+		writeServeError(c, err)
+		return
+	}
+	if info.IsDir {
+		h.serveListing(c, link, target, rest)
+		return
+	}
+	h.serveFile(c, link, info)
+}
+
+func (h *Handler) serveFile(c *gin.Context, link *sharelink.Link, info *entity.FileLinkMeta) {
+	ctx := c.Request.Context()
+	file, err := h.files.OpenFile(ctx, info.FileId)
+	if err != nil {
+		writeServeError(c, err)
+		return
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			logutil.GetLogger(ctx).Error("close shared file failed", zap.Error(err))
+		}
+	}()
+	c.Header("Content-Security-Policy", "default-src 'none'; sandbox")
+	c.Header("Content-Type", httpkit.ContentType(info))
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.FileName}))
+	c.Header("ETag", strconv.Quote(strconv.FormatUint(info.FileId, 10)))
+	writer := &downloadWriter{
+		ResponseWriter: c.Writer,
+		request:        c.Request,
+		size:           info.FileSize,
+		record: func() error {
+			if err := h.store.RecordDownload(ctx, link.ID); err != nil {
+				return fmt.Errorf("record share download: %w", err)
+			}
+			return nil
+		},
+	}
+	http.ServeContent(writer, c.Request, "", time.UnixMilli(info.Mtime), file)
+	if writer.err != nil {
+		for _, name := range []string{
+			"Accept-Ranges", "Content-Disposition", "Content-Length", "Content-Range", "Content-Type",
+			"ETag", "Last-Modified",
+		} {
+			c.Writer.Header().Del(name)
+		}
+		writeServeError(c, writer.err)
+	}
+}
+
+// downloadWriter records a download once the response status is known and
+// before any byte of the file is written. When the link has no downloads
+// left the response is dropped and err is set instead.
+type downloadWriter struct {
+	http.ResponseWriter
+	request *http.Request
+	size    int64
+	record  func() error
+	decided bool
+	err     error
+}
+
+func (w *downloadWriter) WriteHeader(status int) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	if countsAsDownload(w.request, status, w.Header(), w.size) {
+		if w.err = w.record(); w.err != nil {
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *downloadWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.err != nil {
+		return 0, w.err
+	}
+	count, err := w.ResponseWriter.Write(data)
+	if err != nil {
+		return count, fmt.Errorf("write shared file: %w", err)
+	}
+	return count, nil
+}
+
+// countsAsDownload counts a GET response that sends the first byte of the
+// file, whatever the request asked for. A download manager that fetches the
+// rest of the file in later ranges, or resumes it, uses one download; HEAD,
+// 304 and other responses without the file use none.
+func countsAsDownload(request *http.Request, status int, header http.Header, size int64) bool {
+	if request.Method != http.MethodGet {
+		return false
+	}
+	switch status {
+	case http.StatusOK:
+		return true
+	case http.StatusPartialContent:
+		if contentRange := header.Get("Content-Range"); contentRange != "" {
+			return strings.HasPrefix(contentRange, "bytes 0-")
+		}
+		return rangesIncludeFirstByte(request.Header.Get("Range"), size)
+	default:
+		return false
+	}
+}
+
+// rangesIncludeFirstByte reports whether a multi-range request, served as
+// multipart/byteranges without a Content-Range header, asks for byte 0.
+func rangesIncludeFirstByte(header string, size int64) bool {
+	specs, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok {
+		return false
+	}
+	for spec := range strings.SplitSeq(specs, ",") {
+		start, end, _ := strings.Cut(strings.TrimSpace(spec), "-")
+		if start = strings.TrimSpace(start); start == "" {
+			suffix, err := strconv.ParseInt(strings.TrimSpace(end), 10, 64)
+			if err == nil && suffix >= size {
+				return true
+			}
+			continue
+		}
+		if offset, err := strconv.ParseInt(start, 10, 64); err == nil && offset == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) serveListing(c *gin.Context, link *sharelink.Link, target, rest string) {
+	if !link.Listing {
+		c.String(http.StatusNotFound, "not found\n")
+		return
+	}
+	if !strings.HasSuffix(c.Request.URL.Path, "/") {
+		c.Redirect(http.StatusMovedPermanently, url.PathEscape(path.Base(c.Request.URL.Path))+"/")
+		return
+	}
+	ctx := c.Request.Context()
+	result, err := h.files.ListFileLinksPage(ctx, filemgr.FileLinkPageRequest{Path: target, Limit: maxListingEntries})
+	if err != nil {
+		writeServeError(c, err)
+		return
+	}
+	if err := h.store.RecordView(ctx, link.ID); err != nil {
+		logutil.GetLogger(ctx).Error("record share link view failed", zap.Error(err))
+	}
+	page := listingPage{
+		Title:     path.Base(target),
+		Parent:    strings.Trim(rest, "/") != "",
+		Entries:   make([]listingEntry, 0, len(result.Items)),
+		Truncated: result.NextCursor != nil,
+		Limit:     maxListingEntries,
+	}
+	for _, item := range result.Items {
+		entry := listingEntry{
+			Name:  item.FileName,
+			Href:  "./" + url.PathEscape(item.FileName),
+			Size:  strconv.FormatInt(item.FileSize, 10),
+			Mtime: time.UnixMilli(item.Mtime).UTC().Format(time.DateTime),
+		}
+		if item.IsDir {
+			entry.Name += "/"
+			entry.Href += "/"
+			entry.Size = ""
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+	if err := listingTemplate.Execute(c.Writer, page); err != nil {
+		logutil.GetLogger(ctx).Error("render share listing failed", zap.Error(err))
+	}
+}
+
+// setShareHeaders keeps the token out of Referer headers and stops the
+// shared content from running as part of this origin.
+func setShareHeaders(c *gin.Context) {
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+}
+
+func writeServeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, sharelink.ErrNotFound), errors.Is(err, os.ErrNotExist):
+		c.String(http.StatusNotFound, "not found\n")
+	case errors.Is(err, sharelink.ErrExpired):
+		c.String(http.StatusGone, "share link has expired\n")
+	case errors.Is(err, sharelink.ErrExhausted):
+		c.String(http.StatusGone, "share link download limit reached\n")
+	default:
+		logutil.GetLogger(c.Request.Context()).Error("serve share link failed", zap.Error(err))
+		c.String(http.StatusInternalServerError, "internal error\n")
+	}
+}
@@ -0,0 +1,139 @@
+package sts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xxxsen/common/webapi/proxyutil"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/s3session"
+)
+
+type Handler struct {
+	store      *s3session.Store
+	authorizer *authz.Authorizer
+	buckets    map[string]struct{}
+}
+
+var errTrailingJSON = errors.New("request contains trailing JSON")
+
+func New(store *s3session.Store, authorizer *authz.Authorizer, buckets []string) *Handler {
+	bucketSet := make(map[string]struct{}, len(buckets))
+	for _, bucket := range buckets {
+		bucketSet[bucket] = struct{}{}
+	}
+	return &Handler{store: store, authorizer: authorizer, buckets: bucketSet}
+}
+
+type issueRequest struct {
+	Bucket          string `json:"bucket"`
+	Prefix          string `json:"prefix"`
+	ReadOnly        bool   `json:"read_only"`
+	DurationSeconds int64  `json:"duration_seconds"`
+}
+
+func (h *Handler) Issue(c *gin.Context) {
+	owner, ok := h.authorize(c)
+	if !ok {
+		return
+	}
+	var request issueRequest
+	if c.Request.ContentLength == 0 {
+		request = issueRequest{}
+	} else if err := decodeJSON(c.Request.Body, &request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session credential request"})
+		return
+	}
+	if request.Bucket != "" {
+		if _, exists := h.buckets[request.Bucket]; !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bucket does not exist"})
+			return
+		}
+	}
+	credential, err := h.store.Issue(c.Request.Context(), s3session.IssueRequest{
+		Owner: owner,
+		Scope: authz.Scope{
+			Bucket:   request.Bucket,
+			Prefix:   request.Prefix,
+			ReadOnly: request.ReadOnly || !h.authorizer.Has(owner, authz.S3Write),
+		},
+		Duration: time.Duration(request.DurationSeconds) * time.Second,
+	})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, credential)
+}
+
+func (h *Handler) List(c *gin.Context) {
+	owner, ok := h.authorize(c)
+	if !ok {
+		return
+	}
+	sessions, err := h.store.List(c.Request.Context(), owner)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"credentials": sessions})
+}
+
+func (h *Handler) Revoke(c *gin.Context) {
+	owner, ok := h.authorize(c)
+	if !ok {
+		return
+	}
+	if err := h.store.Revoke(c.Request.Context(), owner, c.Param("access_key_id")); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// authorize admits principals that hold any S3 permission. Session
+// credentials only ever narrow the grants of that principal.
+func (h *Handler) authorize(c *gin.Context) (string, bool) {
+	user, ok := proxyutil.GetUserInfo(c.Request.Context())
+	if !ok {
+		c.Header("WWW-Authenticate", `Basic realm="Restricted Area"`)
+		c.Status(http.StatusUnauthorized)
+		return "", false
+	}
+	if !h.authorizer.Has(user.Username, authz.S3Read) {
+		c.Status(http.StatusForbidden)
+		return "", false
+	}
+	return user.Username, true
+}
+
+func decodeJSON(reader io.Reader, output any) error {
+	decoder := json.NewDecoder(io.LimitReader(reader, 16*1024))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(output); err != nil {
+		return fmt.Errorf("decode request: %w", err)
+	}
+	var extra any
+	if err := decoder.Decode(&extra); !errors.Is(err, io.EOF) {
+		return errTrailingJSON
+	}
+	return nil
+}
+
+func writeError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	message := "session credential operation failed"
+	switch {
+	case errors.Is(err, s3session.ErrInvalidRequest):
+		status, message = http.StatusBadRequest, err.Error()
+	case errors.Is(err, s3session.ErrCredentialNotFound):
+		status, message = http.StatusNotFound, "session credential not found"
+	}
+	c.JSON(status, gin.H{"error": message})
+}
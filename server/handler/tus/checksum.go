@@ -0,0 +1,96 @@
+package tus
+
+import (
+	"bytes"
+	"crypto/md5"  //nolint:gosec // tus clients send MD5 checksums; it only detects corruption.
+	"crypto/sha1" //nolint:gosec // tus clients send SHA-1 checksums; it only detects corruption.
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var checksumAlgorithms = []string{"sha1", "sha256", "md5"}
+
+var (
+	errInvalidChecksum  = errors.New("invalid Upload-Checksum")
+	errChecksumMismatch = errors.New("chunk does not match Upload-Checksum")
+	errChunkTooLarge    = errors.New("chunk exceeds Upload-Length")
+	errReadChunk        = errors.New("read chunk")
+)
+
+func newChecksumHash(algorithm string) (hash.Hash, bool) {
+	switch algorithm {
+	case "sha1":
+		return sha1.New(), true //nolint:gosec // See the import.
+	case "sha256":
+		return sha256.New(), true
+	case "md5":
+		return md5.New(), true //nolint:gosec // See the import.
+	}
+	return nil, false
+}
+
+// verifiedBody returns the request body to write at offset. Without
+// Upload-Checksum it is the body itself. With one the chunk is staged in
+// the session directory first, so that none of it reaches the upload unless
+// the whole chunk matches.
+func (h *Handler) verifiedBody(c *gin.Context, owner, id string, offset int64) (io.ReadCloser, error) {
+	header := c.GetHeader("Upload-Checksum")
+	if header == "" {
+		return c.Request.Body, nil
+	}
+	algorithm, encoded, _ := strings.Cut(header, " ")
+	expected, err := base64.StdEncoding.DecodeString(encoded)
+	checksum, supported := newChecksumHash(algorithm)
+	if err != nil || !supported {
+		return nil, errInvalidChecksum
+	}
+	// A chunk may not be longer than what is left of the upload, so the
+	// stage is bounded by the upload, not by the request.
+	session, err := h.store.Get(c.Request.Context(), owner, id)
+	if err != nil {
+		return nil, fmt.Errorf("load upload: %w", err)
+	}
+	chunk, err := h.store.CreateChunkFile()
+	if err != nil {
+		return nil, fmt.Errorf("stage chunk: %w", err)
+	}
+	staged := &stagedChunk{File: chunk}
+	limit := max(session.Size-offset, 0) + 1
+	copied, err := io.Copy(io.MultiWriter(chunk, checksum), io.LimitReader(c.Request.Body, limit))
+	switch {
+	case err != nil:
+		return nil, errors.Join(fmt.Errorf("%w: %w", errReadChunk, err), staged.Close())
+	case copied == limit:
+		return nil, errors.Join(errChunkTooLarge, staged.Close())
+	case !bytes.Equal(checksum.Sum(nil), expected):
+		return nil, errors.Join(errChecksumMismatch, staged.Close())
+	}
+	if _, err := chunk.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Join(fmt.Errorf("rewind chunk: %w", err), staged.Close())
+	}
+	return staged, nil
+}
+
+// stagedChunk removes the staged file when it is closed.
+type stagedChunk struct {
+	*os.File
+}
+
+func (s *stagedChunk) Close() error {
+	return errors.Join(s.File.Close(), removeChunk(s.Name()))
+}
+
+func removeChunk(name string) error {
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove staged chunk: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+// Package tus serves upload sessions over the tus 1.0 resumable upload
+// protocol with the creation, expiration, checksum and termination
+// extensions.
+package tus
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xxxsen/common/logutil"
+	"github.com/xxxsen/common/webapi/proxyutil"
+	"go.uber.org/zap"
+
+	"github.com/xxxsen/tgfile/directory"
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/server/handler/file"
+	"github.com/xxxsen/tgfile/uploadsession"
+)
+
+const (
+	Version    = "1.0.0"
+	Extensions = "creation,expiration,checksum,termination"
+
+	// FileKeyHeader carries the direct-download key of an upload without a
+	// target path on the response to the PATCH that finishes it.
+	FileKeyHeader = "X-Tgfile-File-Key"
+
+	offsetContentType = "application/offset+octet-stream"
+	// statusChecksumMismatch is the status the checksum extension defines
+	// for a chunk that does not match Upload-Checksum.
+	statusChecksumMismatch = 460
+	defaultFileName        = "upload"
+)
+
+var errMalformedMetadata = errors.New("malformed Upload-Metadata")
+
+type Handler struct {
+	store    *uploadsession.Store
+	webdav   filemgr.IWebDAVTargetResolver
+	basePath string
+}
+
+// New serves store below basePath, which is the path Location headers
+// point into. A nil resolver refuses uploads with a target path.
+func New(store *uploadsession.Store, resolver filemgr.IWebDAVTargetResolver, basePath string) *Handler {
+	return &Handler{store: store, webdav: resolver, basePath: strings.TrimSuffix(basePath, "/")}
+}
+
+// Protocol answers every request with the protocol version and rejects
+// requests, other than OPTIONS, for a version the server does not speak.
+func (h *Handler) Protocol(c *gin.Context) {
+	c.Header("Tus-Resumable", Version)
+	if c.Request.Method == http.MethodOptions || c.GetHeader("Tus-Resumable") == Version {
+		c.Next()
+		return
+	}
+	c.Header("Tus-Version", Version)
+	c.AbortWithStatus(http.StatusPreconditionFailed)
+}
+
+// Options describes the server; it needs no credentials.
+func (h *Handler) Options(c *gin.Context) {
+	c.Header("Tus-Version", Version)
+	c.Header("Tus-Extension", Extensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(h.store.MaxSize(), 10))
+	c.Header("Tus-Checksum-Algorithm", strings.Join(checksumAlgorithms, ","))
+	c.Status(http.StatusNoContent)
+}
+
+// Create starts an upload. The "filename" metadata names the key issued
+// when it finishes; "path" publishes it at that WebDAV path instead, which
+// the principal has to be able to write over WebDAV.
+func (h *Handler) Create(c *gin.Context) {
+	owner := principal(c)
+	if c.GetHeader("Upload-Defer-Length") != "" {
+		writeMessage(c, http.StatusBadRequest, "Upload-Defer-Length is not supported")
+		return
+	}
+	size, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || size < 0 {
+		writeMessage(c, http.StatusBadRequest, "invalid Upload-Length")
+		return
+	}
+	if c.Request.ContentLength > 0 {
+		writeMessage(c, http.StatusBadRequest, "creation requests carry no data")
+		return
+	}
+	rawMetadata := c.GetHeader("Upload-Metadata")
+	metadata, err := parseMetadata(rawMetadata)
+	if err != nil {
+		writeMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	request := uploadsession.CreateRequest{
+		Owner:      owner,
+		FileName:   sessionFileName(metadata, metadata["path"]),
+		TargetPath: metadata["path"],
+		Metadata:   rawMetadata,
+		Size:       size,
+	}
+	if !h.resolveTarget(c, &request) {
+		return
+	}
+	session, err := h.store.Create(c.Request.Context(), request)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Header("Location", h.basePath+"/"+session.ID)
+	writeSession(c, session)
+	c.Status(http.StatusCreated)
+}
+
+// resolveTarget maps the WebDAV path of an upload into the namespace and
+// records the scope it is published with, as a WebDAV PUT there would be.
+func (h *Handler) resolveTarget(c *gin.Context, request *uploadsession.CreateRequest) bool {
+	if request.TargetPath == "" {
+		return true
+	}
+	if h.webdav == nil {
+		writeMessage(c, http.StatusBadRequest, "WebDAV is not enabled")
+		return false
+	}
+	resolved, scope, err := h.webdav.ResolveTarget(c.Request.Context(), request.Owner, request.TargetPath)
+	switch {
+	case errors.Is(err, filemgr.ErrWebDAVTargetForbidden):
+		c.Status(http.StatusForbidden)
+		return false
+	case errors.Is(err, filemgr.ErrWebDAVTarget):
+		writeMessage(c, http.StatusBadRequest, "invalid target path")
+		return false
+	case err != nil:
+		writeError(c, err)
+		return false
+	}
+	request.TargetPath, request.WebDAV = resolved, scope
+	return true
+}
+
+// Head reports how many bytes of an upload the server holds.
+func (h *Handler) Head(c *gin.Context) {
+	session, err := h.store.Get(c.Request.Context(), principal(c), c.Param("upload_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Length", strconv.FormatInt(session.Size, 10))
+	if session.Metadata != "" {
+		c.Header("Upload-Metadata", session.Metadata)
+	}
+	writeSession(c, session)
+	c.Status(http.StatusOK)
+}
+
+// Patch appends the request body at Upload-Offset.
+func (h *Handler) Patch(c *gin.Context) {
+	if c.ContentType() != offsetContentType {
+		writeMessage(c, http.StatusUnsupportedMediaType, "Content-Type must be "+offsetContentType)
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeMessage(c, http.StatusBadRequest, "invalid Upload-Offset")
+		return
+	}
+	ctx := c.Request.Context()
+	owner, id := principal(c), c.Param("upload_id")
+	body, err := h.verifiedBody(c, owner, id, offset)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	defer func() {
+		if err := body.Close(); err != nil {
+			logutil.GetLogger(ctx).Error("close tus chunk failed", zap.Error(err))
+		}
+	}()
+	session, err := h.store.Write(ctx, owner, id, offset, body)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	writeSession(c, session)
+	c.Status(http.StatusNoContent)
+}
+
+// Delete terminates an upload and discards the bytes it holds.
+func (h *Handler) Delete(c *gin.Context) {
+	if err := h.store.Delete(c.Request.Context(), principal(c), c.Param("upload_id")); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func writeSession(c *gin.Context, session *uploadsession.Session) {
+	c.Header("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	if !session.Completed {
+		c.Header("Upload-Expires", session.ExpiresAt.Format(http.TimeFormat))
+	}
+	if session.FileKey != "" {
+		c.Header(FileKeyHeader, session.FileKey)
+	}
+}
+
+// parseMetadata decodes the comma separated "key base64-value" pairs of
+// Upload-Metadata. A key may come without a value.
+func parseMetadata(raw string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(raw) == "" {
+		return metadata, nil
+	}
+	for pair := range strings.SplitSeq(raw, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" || strings.ContainsAny(encoded, " ") {
+			return nil, errMalformedMetadata
+		}
+		if _, exists := metadata[key]; exists {
+			return nil, errMalformedMetadata
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errMalformedMetadata
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func sessionFileName(metadata map[string]string, targetPath string) string {
+	if targetPath != "" {
+		return path.Base(targetPath)
+	}
+	name := metadata["filename"]
+	if name == "" {
+		name = metadata["name"]
+	}
+	if name = file.KeyFileName(name); name == "" || name == "." {
+		return defaultFileName
+	}
+	return name
+}
+
+func principal(c *gin.Context) string {
+	user, _ := proxyutil.GetUserInfo(c.Request.Context())
+	if user == nil {
+		return ""
+	}
+	return user.Username
+}
+
+func writeMessage(c *gin.Context, status int, message string) {
+	c.JSON(status, gin.H{"error": message})
+}
+
+var mappedErrors = []struct {
+	target  error
+	status  int
+	message string
+}{
+	{uploadsession.ErrInvalidRequest, http.StatusBadRequest, ""},
+	{errInvalidChecksum, http.StatusBadRequest, ""},
+	{errReadChunk, http.StatusBadRequest, "failed to read the chunk"},
+	{errChecksumMismatch, statusChecksumMismatch, ""},
+	{errChunkTooLarge, http.StatusRequestEntityTooLarge, ""},
+	{uploadsession.ErrNotFound, http.StatusNotFound, "upload not found"},
+	{uploadsession.ErrOffsetMismatch, http.StatusConflict, "Upload-Offset does not match the upload"},
+	{uploadsession.ErrBusy, http.StatusLocked, "another request is writing the upload"},
+	{uploadsession.ErrTooLarge, http.StatusRequestEntityTooLarge, "upload exceeds Upload-Length or Tus-Max-Size"},
+	{filemgr.ErrTooManyFileParts, http.StatusRequestEntityTooLarge, "upload has too many blocks"},
+	{filemgr.ErrWebDAVLocked, http.StatusLocked, "target path is locked"},
+	{filemgr.ErrWebDAVForbidden, http.StatusForbidden, "target path is not writable by the principal"},
+	{filemgr.ErrWebDAVTargetForbidden, http.StatusForbidden, "target path is not writable by the principal"},
+	{filemgr.ErrWebDAVTarget, http.StatusConflict, "target path is no longer below a WebDAV share"},
+	{filemgr.ErrWebDAVQuota, http.StatusInsufficientStorage, "upload exceeds a service limit"},
+	{filemgr.ErrWebDAVTooManyItems, http.StatusInsufficientStorage, "upload exceeds a service limit"},
+	{filemgr.ErrQuotaExceeded, http.StatusInsufficientStorage, "upload exceeds the quota of the principal"},
+	{filemgr.ErrDirectoryIO, http.StatusConflict, "target path is a directory"},
+	{filemgr.ErrNotDirectory, http.StatusConflict, "target parent is not a directory"},
+	{directory.ErrPathComponentNotDirectory, http.StatusConflict, "target parent is not a directory"},
+	{directory.ErrInvalidPath, http.StatusBadRequest, "invalid target path"},
+	// Session lookups report ErrNotFound, so a missing file here is the
+	// parent directory of the target path.
+	{os.ErrNotExist, http.StatusConflict, "target directory not found"},
+	{syscall.ENOSPC, http.StatusInsufficientStorage, "insufficient storage"},
+}
+
+func writeError(c *gin.Context, err error) {
+	for _, mapped := range mappedErrors {
+		if !errors.Is(err, mapped.target) {
+			continue
+		}
+		message := mapped.message
+		if message == "" {
+			message = err.Error()
+		}
+		writeMessage(c, mapped.status, message)
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		// The client went away; it resumes from the offset HEAD reports.
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	logutil.GetLogger(c.Request.Context()).Error("tus request failed", zap.Error(err))
+	writeMessage(c, http.StatusInternalServerError, "upload failed")
+}
@@ -0,0 +1,529 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// davPrivileges lists the concrete privileges in the order they are reported.
+var davPrivileges = []struct {
+	name      string
+	privilege filemgr.WebDAVPrivilege
+}{
+	{name: "read", privilege: filemgr.WebDAVPrivilegeRead},
+	{name: "write-properties", privilege: filemgr.WebDAVPrivilegeWriteProperties},
+	{name: "write-content", privilege: filemgr.WebDAVPrivilegeWriteContent},
+	{name: "bind", privilege: filemgr.WebDAVPrivilegeBind},
+	{name: "unbind", privilege: filemgr.WebDAVPrivilegeUnbind},
+	{name: "read-acl", privilege: filemgr.WebDAVPrivilegeReadACL},
+	{name: "write-acl", privilege: filemgr.WebDAVPrivilegeWriteACL},
+}
+
+// davPrivilegeNode is one DAV:supported-privilege. Abstract privileges cannot
+// appear in an ACE.
+type davPrivilegeNode struct {
+	name        string
+	description string
+	abstract    bool
+	children    []davPrivilegeNode
+}
+
+var supportedPrivileges = davPrivilegeNode{
+	name:        "all",
+	description: "Any operation",
+	children: []davPrivilegeNode{
+		{name: "read", description: "Read resource content, properties and members"},
+		{
+			name:        "write",
+			description: "Write any object",
+			children: []davPrivilegeNode{
+				{name: "write-properties", description: "Write properties"},
+				{name: "write-content", description: "Write resource content"},
+				{name: "bind", description: "Add a member to a collection"},
+				{name: "unbind", description: "Remove a member from a collection"},
+			},
+		},
+		{name: "read-acl", description: "Read the access control list"},
+		{name: "write-acl", description: "Write the access control list"},
+		{
+			name:        "read-current-user-privilege-set",
+			description: "Read the current user privilege set",
+			abstract:    true,
+		},
+	},
+}
+
+// aclPropertyNames are the RFC 3744 live properties. Like RFC 3744 asks, they
+// are only returned when requested by name or DAV:include.
+var aclPropertyNames = map[string]struct{}{
+	"acl":                        {},
+	"current-user-privilege-set": {},
+	"supported-privilege-set":    {},
+	"principal-collection-set":   {},
+	"acl-restrictions":           {},
+}
+
+// aclPreconditions maps ACL request errors to their RFC 3744 403 condition.
+var aclPreconditions = []struct {
+	err          error
+	precondition string
+}{
+	{err: errACLInvert, precondition: "no-invert"},
+	{err: errACLProtected, precondition: "no-protected-ace-conflict"},
+	{err: errACLInherited, precondition: "no-inherited-ace-conflict"},
+	{err: errUnsupportedPrivilege, precondition: "not-supported-privilege"},
+	{err: errUnrecognizedPrincipal, precondition: "recognized-principal"},
+}
+
+type davACE struct {
+	Principal struct {
+		Href          []string  `xml:"DAV: href"`
+		All           *struct{} `xml:"DAV: all"`
+		Authenticated *struct{} `xml:"DAV: authenticated"`
+		Other         []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"DAV: principal"`
+	Invert    *struct{}         `xml:"DAV: invert"`
+	Grant     *davPrivilegeList `xml:"DAV: grant"`
+	Deny      *davPrivilegeList `xml:"DAV: deny"`
+	Protected *struct{}         `xml:"DAV: protected"`
+	Inherited *struct{}         `xml:"DAV: inherited"`
+}
+
+type davPrivilegeList struct {
+	Privileges []struct {
+		Names []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	} `xml:"DAV: privilege"`
+}
+
+// davACEValue is an ACE ready to be reported in DAV:acl.
+type davACEValue struct {
+	ACE       filemgr.WebDAVACE
+	Principal string
+	Inherited string
+}
+
+func (h *WebdavHandler) handleACL(c *gin.Context) {
+	aces, err := h.parseACLRequest(c.Request)
+	if err != nil {
+		for _, mapping := range aclPreconditions {
+			if errors.Is(err, mapping.err) {
+				h.writeError(c, http.StatusForbidden, err, mapping.precondition)
+				return
+			}
+		}
+		h.writeError(c, http.StatusBadRequest, err, "")
+		return
+	}
+	if _, ok := h.stat(c); !ok {
+		return
+	}
+	if err := h.fmgr.SetWebDAVACL(
+		c.Request.Context(),
+		h.buildSrcPath(c),
+		aces,
+		h.mutationOptions(c, nil),
+	); err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	setPrivateDAVHeaders(c.Writer.Header())
+	c.Status(http.StatusOK)
+}
+
+func (h *WebdavHandler) parseACLRequest(request *http.Request) ([]filemgr.WebDAVACE, error) {
+	raw, err := readLimitedXMLBody(request)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, errACLBodyRequired
+	}
+	var body struct {
+		XMLName xml.Name `xml:"DAV: acl"`
+		ACEs    []davACE `xml:"DAV: ace"`
+	}
+	if err := xml.Unmarshal(raw, &body); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidACL, err)
+	}
+	aces := make([]filemgr.WebDAVACE, 0, len(body.ACEs))
+	for _, entry := range body.ACEs {
+		ace, err := h.parseACE(entry)
+		if err != nil {
+			return nil, err
+		}
+		aces = append(aces, ace)
+	}
+	return aces, nil
+}
+
+func (h *WebdavHandler) parseACE(entry davACE) (filemgr.WebDAVACE, error) {
+	var ace filemgr.WebDAVACE
+	switch {
+	case entry.Invert != nil:
+		return ace, errACLInvert
+	case entry.Protected != nil:
+		return ace, errACLProtected
+	case entry.Inherited != nil:
+		return ace, errACLInherited
+	case (entry.Grant == nil) == (entry.Deny == nil):
+		return ace, fmt.Errorf("%w: an ACE needs exactly one of grant or deny", errInvalidACL)
+	}
+	principal, err := h.parseACEPrincipal(entry)
+	if err != nil {
+		return ace, err
+	}
+	list := entry.Grant
+	if entry.Deny != nil {
+		list = entry.Deny
+		ace.Deny = true
+	}
+	ace.Principal = principal
+	for _, privilege := range list.Privileges {
+		if len(privilege.Names) != 1 || privilege.Names[0].XMLName.Space != davNamespace {
+			return ace, errUnsupportedPrivilege
+		}
+		value, ok := parseDAVPrivilege(privilege.Names[0].XMLName.Local)
+		if !ok {
+			return ace, fmt.Errorf("%w: %s", errUnsupportedPrivilege, privilege.Names[0].XMLName.Local)
+		}
+		ace.Privileges |= value
+	}
+	if ace.Privileges == 0 {
+		return ace, fmt.Errorf("%w: an ACE needs a privilege", errInvalidACL)
+	}
+	return ace, nil
+}
+
+func parseDAVPrivilege(name string) (filemgr.WebDAVPrivilege, bool) {
+	switch name {
+	case "all":
+		return filemgr.WebDAVPrivilegeAll, true
+	case "write":
+		return filemgr.WebDAVPrivilegeWrite, true
+	}
+	for _, candidate := range davPrivileges {
+		if candidate.name == name {
+			return candidate.privilege, true
+		}
+	}
+	return 0, false
+}
+
+func (h *WebdavHandler) parseACEPrincipal(entry davACE) (string, error) {
+	principal := entry.Principal
+	if len(principal.Other) != 0 ||
+		len(principal.Href)+boolCount(principal.All != nil)+boolCount(principal.Authenticated != nil) != 1 {
+		return "", errUnrecognizedPrincipal
+	}
+	switch {
+	case principal.All != nil:
+		return filemgr.WebDAVPrincipalAll, nil
+	case principal.Authenticated != nil:
+		return filemgr.WebDAVPrincipalAuthenticated, nil
+	}
+	return h.principalFromHref(principal.Href[0])
+}
+
+func boolCount(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// privileges is the current principal's privilege set on an entry: its ACL
+// evaluated for the principal and its groups, or every privilege for an
+// administrator, capped by the webdav:read or webdav:write permission.
+func (h *WebdavHandler) privileges(ctx context.Context, entryID uint64) (filemgr.WebDAVPrivilege, error) {
+	username := contextPrincipal(ctx)
+	var ceiling filemgr.WebDAVPrivilege
+	switch h.level(username) {
+	case authz.LevelReadWrite:
+		ceiling = filemgr.WebDAVPrivilegeAll
+	case authz.LevelRead:
+		ceiling = filemgr.WebDAVPrivilegeRead | filemgr.WebDAVPrivilegeReadACL
+	default:
+		return 0, nil
+	}
+	if h.isAdmin(username) {
+		return ceiling, nil
+	}
+	granted, err := h.fmgr.WebDAVPrivileges(ctx, entryID, username, h.memberships[username])
+	if err != nil {
+		return 0, fmt.Errorf("evaluate WebDAV ACL: %w", err)
+	}
+	return granted & ceiling, nil
+}
+
+// requirePrivilege writes a 403 DAV:need-privileges response unless the
+// current principal holds required on item.
+func (h *WebdavHandler) requirePrivilege(
+	c *gin.Context,
+	item *entity.FileLinkMeta,
+	required filemgr.WebDAVPrivilege,
+) bool {
+	privileges, err := h.privileges(c.Request.Context(), item.EntryID)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return false
+	}
+	if privileges&required != required {
+		h.writeError(c, http.StatusForbidden, filemgr.ErrWebDAVForbidden, "need-privileges")
+		return false
+	}
+	return true
+}
+
+func isACLPropertyName(name filemgr.WebDAVPropertyName) bool {
+	if name.Namespace != davNamespace {
+		return false
+	}
+	_, exists := aclPropertyNames[name.LocalName]
+	return exists
+}
+
+// resolveACLProperty returns an RFC 3744 property with its propstat status.
+func (h *WebdavHandler) resolveACLProperty(
+	ctx context.Context,
+	resourcePath string,
+	item *entity.FileLinkMeta,
+	name filemgr.WebDAVPropertyName,
+	privileges filemgr.WebDAVPrivilege,
+) (davPropertyValue, int, error) {
+	value := davPropertyValue{Name: name, Kind: name.LocalName}
+	switch name.LocalName {
+	case "current-user-privilege-set":
+		value.Privileges = privileges
+	case "principal-collection-set":
+		value.Kind = "href-set"
+		value.Hrefs = []string{h.principalRoot + "/"}
+	case "acl":
+		if privileges&filemgr.WebDAVPrivilegeReadACL == 0 {
+			return value, http.StatusForbidden, nil
+		}
+		aces, err := h.fmgr.ReadWebDAVACL(ctx, item.EntryID)
+		if err != nil {
+			return value, 0, fmt.Errorf("read WebDAV ACL: %w", err)
+		}
+		value.ACEs = h.externalizeACL(resourcePath, aces)
+	}
+	return value, http.StatusOK, nil
+}
+
+func (h *WebdavHandler) externalizeACL(resourcePath string, aces []filemgr.WebDAVACE) []davACEValue {
+	result := make([]davACEValue, 0, len(aces))
+	for _, ace := range aces {
+		value := davACEValue{ACE: ace, Principal: h.principalHref(ace.Principal)}
+		if ace.Inherited != 0 {
+			source := path.Clean(resourcePath)
+			for range ace.Inherited {
+				source = path.Dir(source)
+			}
+			// Entries set above the mount root are reported on the mount
+			// root rather than disclosing a path outside the share.
+			if !pathWithinRoot(h.davRoot, source) {
+				source = h.davRoot
+			}
+			value.Inherited = h.externalPath(source, true)
+		}
+		result = append(result, value)
+	}
+	return result
+}
+
+func encodeACLPropertyValue(encoder *xml.Encoder, property davPropertyValue) error {
+	switch property.Kind {
+	case "acl":
+		for _, ace := range property.ACEs {
+			if err := encodeACE(encoder, ace); err != nil {
+				return err
+			}
+		}
+	case "current-user-privilege-set":
+		names := expandedPrivilegeNames(property.Privileges)
+		return encodePrivileges(encoder, append(names, "read-current-user-privilege-set"))
+	case "supported-privilege-set":
+		return encodeSupportedPrivilege(encoder, supportedPrivileges)
+	case "acl-restrictions":
+		return encodeEmptyElement(encoder, xml.Name{Space: davNamespace, Local: "no-invert"})
+	case "href-set":
+		for _, href := range property.Hrefs {
+			if err := encodeSimpleElement(encoder, xml.Name{Space: davNamespace, Local: "href"}, href); err != nil {
+				return err
+			}
+		}
+	case "principal":
+		return encodeEmptyElement(encoder, xml.Name{Space: davNamespace, Local: "principal"})
+	}
+	return nil
+}
+
+func encodeACE(encoder *xml.Encoder, ace davACEValue) error {
+	start := xml.StartElement{Name: xml.Name{Space: davNamespace, Local: "ace"}}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	principal := xml.StartElement{Name: xml.Name{Space: davNamespace, Local: "principal"}}
+	if err := encoder.EncodeToken(principal); err != nil {
+		return err
+	}
+	var err error
+	switch ace.ACE.Principal {
+	case filemgr.WebDAVPrincipalAll, filemgr.WebDAVPrincipalAuthenticated:
+		err = encodeEmptyElement(encoder, xml.Name{Space: davNamespace, Local: ace.ACE.Principal})
+	default:
+		err = encodeSimpleElement(encoder, xml.Name{Space: davNamespace, Local: "href"}, ace.Principal)
+	}
+	if err != nil {
+		return err
+	}
+	if err := encoder.EncodeToken(principal.End()); err != nil {
+		return err
+	}
+	decision := xml.StartElement{Name: xml.Name{Space: davNamespace, Local: "grant"}}
+	if ace.ACE.Deny {
+		decision.Name.Local = "deny"
+	}
+	if err := encoder.EncodeToken(decision); err != nil {
+		return err
+	}
+	if err := encodePrivileges(encoder, privilegeNames(ace.ACE.Privileges)); err != nil {
+		return err
+	}
+	if err := encoder.EncodeToken(decision.End()); err != nil {
+		return err
+	}
+	if ace.Inherited != "" {
+		inherited := xml.StartElement{Name: xml.Name{Space: davNamespace, Local: "inherited"}}
+		if err := encoder.EncodeToken(inherited); err != nil {
+			return err
+		}
+		if err := encodeSimpleElement(encoder, xml.Name{Space: davNamespace, Local: "href"}, ace.Inherited); err != nil {
+			return err
+		}
+		if err := encoder.EncodeToken(inherited.End()); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+// privilegeNames names a privilege set as written in an ACE, using the
+// DAV:all and DAV:write aggregates where they apply.
+func privilegeNames(privileges filemgr.WebDAVPrivilege) []string {
+	if privileges == filemgr.WebDAVPrivilegeAll {
+		return []string{"all"}
+	}
+	names := make([]string, 0, len(davPrivileges))
+	if privileges&filemgr.WebDAVPrivilegeWrite == filemgr.WebDAVPrivilegeWrite {
+		names = append(names, "write")
+		privileges &^= filemgr.WebDAVPrivilegeWrite
+	}
+	for _, candidate := range davPrivileges {
+		if privileges&candidate.privilege != 0 {
+			names = append(names, candidate.name)
+		}
+	}
+	return names
+}
+
+// expandedPrivilegeNames lists every privilege in the set, and the
+// aggregates it fully contains, for DAV:current-user-privilege-set.
+func expandedPrivilegeNames(privileges filemgr.WebDAVPrivilege) []string {
+	names := make([]string, 0, len(davPrivileges)+2)
+	if privileges == filemgr.WebDAVPrivilegeAll {
+		names = append(names, "all")
+	}
+	if privileges&filemgr.WebDAVPrivilegeWrite == filemgr.WebDAVPrivilegeWrite {
+		names = append(names, "write")
+	}
+	for _, candidate := range davPrivileges {
+		if privileges&candidate.privilege != 0 {
+			names = append(names, candidate.name)
+		}
+	}
+	return names
+}
+
+func encodePrivileges(encoder *xml.Encoder, names []string) error {
+	for _, name := range names {
+		if err := encodePrivilege(encoder, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodePrivilege(encoder *xml.Encoder, name string) error {
+	start := xml.StartElement{Name: xml.Name{Space: davNamespace, Local: "privilege"}}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	if err := encodeEmptyElement(encoder, xml.Name{Space: davNamespace, Local: name}); err != nil {
+		return err
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+func encodeSupportedPrivilege(encoder *xml.Encoder, node davPrivilegeNode) error {
+	start := xml.StartElement{Name: xml.Name{Space: davNamespace, Local: "supported-privilege"}}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	if err := encodePrivilege(encoder, node.name); err != nil {
+		return err
+	}
+	if node.abstract {
+		if err := encodeEmptyElement(encoder, xml.Name{Space: davNamespace, Local: "abstract"}); err != nil {
+			return err
+		}
+	}
+	description := xml.StartElement{
+		Name: xml.Name{Space: davNamespace, Local: "description"},
+		Attr: []xml.Attr{{Name: xml.Name{Space: xmlNamespace, Local: "lang"}, Value: "en"}},
+	}
+	if err := encoder.EncodeToken(description); err != nil {
+		return err
+	}
+	if err := encoder.EncodeToken(xml.CharData(node.description)); err != nil {
+		return err
+	}
+	if err := encoder.EncodeToken(description.End()); err != nil {
+		return err
+	}
+	for _, child := range node.children {
+		if err := encodeSupportedPrivilege(encoder, child); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+// principalHref is the principal URL an ACE principal is reported with.
+func (h *WebdavHandler) principalHref(principal string) string {
+	if username, ok := strings.CutPrefix(principal, filemgr.WebDAVUserPrincipal("")); ok {
+		return h.principalURL(principalUsers, username)
+	}
+	if group, ok := strings.CutPrefix(principal, filemgr.WebDAVGroupPrincipal("")); ok {
+		return h.principalURL(principalGroups, group)
+	}
+	return principal
+}
@@ -0,0 +1,61 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+
+	"github.com/xxxsen/tgfile/archive"
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+// archiveQuery turns a GET or HEAD on a collection into a ZIP or TAR download
+// of its tree, for example GET /webdav/docs/?archive=zip.
+const archiveQuery = "archive"
+
+// serveArchive streams the tree below the collection item. Members the
+// principal may not read are left out; a collection without read access is
+// left out with its subtree.
+func (h *WebdavHandler) serveArchive(c *gin.Context, item *entity.FileLinkMeta) {
+	if !h.requirePrivilege(c, item, filemgr.WebDAVPrivilegeRead) {
+		return
+	}
+	format, err := archive.ParseFormat(c.Query(archiveQuery))
+	if err != nil {
+		h.writeError(c, http.StatusBadRequest, err, "")
+		return
+	}
+	folder := path.Base(strings.TrimSuffix(c.Request.URL.Path, "/"))
+	ctx := c.Request.Context()
+	entries, err := archive.Plan(ctx, h.fmgr, h.buildSrcPath(c), folder, h.archiveLimits, func(
+		ctx context.Context,
+		_ string,
+		child *entity.FileLinkMeta,
+	) (bool, error) {
+		privileges, err := h.privileges(ctx, child.EntryID)
+		if err != nil {
+			return false, err
+		}
+		return privileges&filemgr.WebDAVPrivilegeRead != 0, nil
+	})
+	if errors.Is(err, archive.ErrTooManyEntries) || errors.Is(err, archive.ErrTooLarge) {
+		h.writeError(c, http.StatusRequestEntityTooLarge, err, "")
+		return
+	}
+	if err != nil {
+		h.writeMappedError(c, fmt.Errorf("plan WebDAV archive: %w", err))
+		return
+	}
+	setPrivateDAVHeaders(c.Writer.Header())
+	if err := archive.Serve(c.Writer, c.Request, folder+"."+string(format), format, h.fmgr, entries); err != nil {
+		logutil.GetLogger(ctx).Error("stream WebDAV archive failed", zap.Int("entries", len(entries)), zap.Error(err))
+	}
+}
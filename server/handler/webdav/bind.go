@@ -0,0 +1,252 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+// bindingMethod describes the request body and RFC 5842 preconditions of
+// BIND, UNBIND and REBIND, which all name a member of the request collection.
+type bindingMethod struct {
+	element    string
+	collection string
+	source     string
+}
+
+var bindingMethods = map[string]bindingMethod{
+	"BIND":   {element: "bind", collection: "bind-into-collection", source: "bind-source-exists"},
+	"UNBIND": {element: "unbind", collection: "unbind-from-collection", source: "unbind-source-exists"},
+	"REBIND": {element: "rebind", collection: "rebind-into-collection", source: "rebind-source-exists"},
+}
+
+// bindingRequest is a parsed BIND, UNBIND or REBIND. target is the member the
+// segment names; source is the resource the href names, empty for UNBIND.
+type bindingRequest struct {
+	method    bindingMethod
+	target    string
+	source    string
+	overwrite bool
+	condition *filemgr.WebDAVCondition
+}
+
+// davParentValue is one entry of DAV:parent-set.
+type davParentValue struct {
+	Href    string
+	Segment string
+}
+
+// handleBind adds a binding of an existing file to the request collection.
+func (h *WebdavHandler) handleBind(c *gin.Context) {
+	request, ok := h.parseBindingRequest(c)
+	if !ok {
+		return
+	}
+	result, err := h.fmgr.BindWebDAVResource(
+		c.Request.Context(),
+		request.source,
+		request.target,
+		request.overwrite,
+		h.mutationOptions(c, request.condition),
+	)
+	h.writeBindingResult(c, request, result, err)
+}
+
+// handleUnbind removes one binding. The resource itself is only deleted with
+// its last binding, as with DELETE.
+func (h *WebdavHandler) handleUnbind(c *gin.Context) {
+	request, ok := h.parseBindingRequest(c)
+	if !ok {
+		return
+	}
+	err := h.fmgr.DeleteWebDAVResource(
+		c.Request.Context(),
+		request.target,
+		h.mutationOptions(c, request.condition),
+	)
+	h.writeBindingResult(c, request, &filemgr.WebDAVMutationResult{}, err)
+}
+
+// handleRebind moves a binding into the request collection. Unlike COPY the
+// resource keeps its identity, which MOVE already guarantees.
+func (h *WebdavHandler) handleRebind(c *gin.Context) {
+	request, ok := h.parseBindingRequest(c)
+	if !ok {
+		return
+	}
+	if request.source == h.davRoot {
+		h.writeMappedError(c, errMountRoot)
+		return
+	}
+	result, err := h.fmgr.MoveWebDAVResource(
+		c.Request.Context(),
+		request.source,
+		request.target,
+		request.overwrite,
+		h.mutationOptions(c, request.condition),
+	)
+	h.writeBindingResult(c, request, result, err)
+}
+
+func (h *WebdavHandler) writeBindingResult(
+	c *gin.Context,
+	request *bindingRequest,
+	result *filemgr.WebDAVMutationResult,
+	err error,
+) {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		h.writeError(c, http.StatusConflict, err, request.method.source)
+		return
+	case errors.Is(err, filemgr.ErrWebDAVBinding):
+		h.writeError(c, http.StatusForbidden, err, "binding-allowed")
+		return
+	case err != nil:
+		h.writeMappedError(c, err)
+		return
+	}
+	setPrivateDAVHeaders(c.Writer.Header())
+	if result.Created {
+		c.Status(http.StatusCreated)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (h *WebdavHandler) parseBindingRequest(c *gin.Context) (*bindingRequest, bool) {
+	method := bindingMethods[c.Request.Method]
+	item, ok := h.stat(c)
+	if !ok {
+		return nil, false
+	}
+	if !item.IsDir {
+		h.writeError(c, http.StatusForbidden, errBindingCollection, method.collection)
+		return nil, false
+	}
+	segment, href, err := parseBindingBody(c.Request, method.element)
+	if err != nil {
+		h.writeError(c, http.StatusBadRequest, err, "")
+		return nil, false
+	}
+	request := &bindingRequest{method: method, target: path.Join(h.buildSrcPath(c), segment)}
+	if method.element != "unbind" {
+		if request.source, err = h.resolveHref(c.Request, href); err != nil {
+			h.writeMappedError(c, err)
+			return nil, false
+		}
+		if request.source == request.target {
+			h.writeMappedError(c, errSameResource)
+			return nil, false
+		}
+		if request.overwrite, err = parseOverwrite(c.GetHeader("Overwrite")); err != nil {
+			h.writeMappedError(c, err)
+			return nil, false
+		}
+	}
+	if request.condition, err = h.requestCondition(c); err != nil {
+		h.writeMappedError(c, err)
+		return nil, false
+	}
+	return request, true
+}
+
+func parseBindingBody(request *http.Request, element string) (string, string, error) {
+	raw, err := readLimitedXMLBody(request)
+	if err != nil {
+		return "", "", err
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return "", "", errBindingBodyRequired
+	}
+	var body struct {
+		XMLName xml.Name
+		Segment []string `xml:"DAV: segment"`
+		Href    []string `xml:"DAV: href"`
+	}
+	if err := xml.Unmarshal(raw, &body); err != nil {
+		return "", "", fmt.Errorf("%w: %w", errInvalidBinding, err)
+	}
+	hrefs := 1
+	if element == "unbind" {
+		hrefs = 0
+	}
+	if body.XMLName.Space != davNamespace || body.XMLName.Local != element ||
+		len(body.Segment) != 1 || len(body.Href) != hrefs {
+		return "", "", errInvalidBinding
+	}
+	segment, err := url.PathUnescape(strings.TrimSpace(body.Segment[0]))
+	if err != nil || segment == "" || segment == "." || segment == ".." ||
+		strings.ContainsAny(segment, "/\\\x00") {
+		return "", "", fmt.Errorf("%w: %q", errInvalidSegment, body.Segment[0])
+	}
+	href := ""
+	if hrefs == 1 {
+		href = body.Href[0]
+	}
+	return segment, href, nil
+}
+
+// resolveBindDAVProperty returns DAV:resource-id or DAV:parent-set. Bindings
+// outside this handler's root are not reported in DAV:parent-set.
+func (h *WebdavHandler) resolveBindDAVProperty(
+	ctx context.Context,
+	item *entity.FileLinkMeta,
+	value davPropertyValue,
+) (davPropertyValue, bool, error) {
+	set, err := h.fmgr.ReadWebDAVBindings(ctx, item.EntryID)
+	if err != nil {
+		return value, false, fmt.Errorf("read WebDAV bindings: %w", err)
+	}
+	if value.Name.LocalName == "resource-id" {
+		value.Kind = "href-set"
+		value.Hrefs = []string{resourceIDURN + strconv.FormatUint(set.ResourceID, 10)}
+		return value, true, nil
+	}
+	value.Kind = "parent-set"
+	for _, binding := range set.Bindings {
+		if binding.Path == h.davRoot || !pathWithinRoot(h.davRoot, binding.Path) {
+			continue
+		}
+		value.Parents = append(value.Parents, davParentValue{
+			Href:    h.externalPath(path.Dir(binding.Path), true),
+			Segment: path.Base(binding.Path),
+		})
+	}
+	return value, true, nil
+}
+
+func encodeParentSet(encoder *xml.Encoder, parents []davParentValue) error {
+	for _, parent := range parents {
+		start := xml.StartElement{Name: xml.Name{Space: davNamespace, Local: "parent"}}
+		if err := encoder.EncodeToken(start); err != nil {
+			return err
+		}
+		if err := encodeSimpleElement(encoder, xml.Name{Space: davNamespace, Local: "href"}, parent.Href); err != nil {
+			return err
+		}
+		if err := encodeSimpleElement(
+			encoder,
+			xml.Name{Space: davNamespace, Local: "segment"},
+			parent.Segment,
+		); err != nil {
+			return err
+		}
+		if err := encoder.EncodeToken(start.End()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
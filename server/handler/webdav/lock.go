@@ -58,6 +58,8 @@ func (h *WebdavHandler) handleLock(c *gin.Context) {
 			Depth:      depth,
 			OwnerXML:   owner,
 			Principal:  h.principal(c),
+			Groups:     h.memberships[h.principal(c)],
+			Admin:      h.isAdmin(h.principal(c)),
 			Timeout:    timeout,
 			IfHeader:   ifHeader,
 			MaxEntries: h.maxMutationEntries,
@@ -16,6 +16,11 @@ var AllowMethods = []string{
 	"LOCK",
 	"UNLOCK",
 	"REPORT",
+	"SEARCH",
+	"ACL",
+	"BIND",
+	"UNBIND",
+	"REBIND",
 }
 
 var ReadOnlyMethods = []string{
@@ -24,4 +29,37 @@ var ReadOnlyMethods = []string{
 	http.MethodHead,
 	"PROPFIND",
 	"REPORT",
+	"SEARCH",
+}
+
+// PrincipalMethods are served below PrincipalRoot.
+var PrincipalMethods = []string{
+	http.MethodOptions,
+	"PROPFIND",
+	"REPORT",
+}
+
+// SnapshotMethods are served below the read-only snapshot collection.
+var SnapshotMethods = []string{
+	http.MethodOptions,
+	http.MethodGet,
+	http.MethodHead,
+	"PROPFIND",
+}
+
+// VersionMethods are served below the version collection; COPY restores a
+// version.
+var VersionMethods = []string{
+	http.MethodOptions,
+	http.MethodGet,
+	http.MethodHead,
+	"PROPFIND",
+	"COPY",
+}
+
+// ThumbnailMethods are served below the thumbnail collection.
+var ThumbnailMethods = []string{
+	http.MethodOptions,
+	http.MethodGet,
+	http.MethodHead,
 }
@@ -0,0 +1,224 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+// UserPlaceholder in a mount root is replaced with the authenticated
+// principal, giving every user a private home tree.
+const UserPlaceholder = "{user}"
+
+var (
+	errUnknownMount    = errors.New("unknown WebDAV mount")
+	errHomeUnavailable = errors.New("principal has no usable WebDAV home")
+)
+
+// Mount is a named WebDAV share served at <webRoot>/<Name>/. A Root that
+// contains UserPlaceholder resolves to a different tree for every principal.
+type Mount struct {
+	Name       string
+	Root       string
+	ReadOnly   bool
+	QuotaBytes int64
+}
+
+type mountTable struct {
+	mounts map[string]Mount
+	names  []string
+	homes  sync.Map
+}
+
+func newMountTable(mounts []Mount) *mountTable {
+	if len(mounts) == 0 {
+		return nil
+	}
+	table := &mountTable{mounts: make(map[string]Mount, len(mounts))}
+	for _, mount := range mounts {
+		table.mounts[mount.Name] = mount
+		table.names = append(table.names, mount.Name)
+	}
+	slices.Sort(table.names)
+	return table
+}
+
+func (h *WebdavHandler) initMounts() error {
+	for _, mount := range h.mounts.mounts {
+		if strings.Contains(mount.Root, UserPlaceholder) {
+			continue
+		}
+		if err := h.initWebdav(path.Clean(mount.Root)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mountName returns the first path segment below webRoot, which selects the
+// mount; an empty name addresses the mount index.
+func (h *WebdavHandler) mountName(requestPath string) string {
+	relative := strings.TrimPrefix(requestPath, h.webRoot)
+	name, _, _ := strings.Cut(strings.TrimPrefix(relative, "/"), "/")
+	return name
+}
+
+// MountReadOnly reports whether requestPath lies in a share configured as
+// read-only, so an upload can be refused before its body is spooled.
+func (h *WebdavHandler) MountReadOnly(requestPath string) bool {
+	if h.mounts == nil {
+		return false
+	}
+	mount, exists := h.mounts.mounts[h.mountName(requestPath)]
+	return exists && mount.ReadOnly
+}
+
+// resolveMount returns a handler scoped to the mount addressed by the
+// request. Every path, lock, quota and sync token computed by the scoped
+// handler is confined to the mount root. A nil handler with true means the
+// request targets the mount index itself.
+func (h *WebdavHandler) resolveMount(c *gin.Context) (*WebdavHandler, bool) {
+	name := h.mountName(c.Request.URL.Path)
+	if name == "" {
+		return nil, true
+	}
+	scoped, err := h.scopeMount(c.Request.Context(), name, h.principal(c))
+	switch {
+	case errors.Is(err, errUnknownMount):
+		h.writeError(c, http.StatusNotFound, err, "")
+		return nil, false
+	case errors.Is(err, errHomeUnavailable):
+		h.writeError(c, http.StatusForbidden, err, "")
+		return nil, false
+	case err != nil:
+		h.writeMappedError(c, err)
+		return nil, false
+	}
+	return scoped, true
+}
+
+func (h *WebdavHandler) scopeMount(ctx context.Context, name, username string) (*WebdavHandler, error) {
+	mount, exists := h.mounts.mounts[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", errUnknownMount, name)
+	}
+	scoped := *h
+	scoped.mounts = nil
+	scoped.davRoot = path.Clean(mount.Root)
+	scoped.webRoot = h.webRoot + "/" + name
+	scoped.readOnly = mount.ReadOnly
+	scoped.quotaBytes = mount.QuotaBytes
+	scoped.syncScope = name
+	scoped.mount = name
+	if strings.Contains(mount.Root, UserPlaceholder) {
+		if !ValidHomeName(username) {
+			return nil, errHomeUnavailable
+		}
+		scoped.davRoot = path.Clean(strings.ReplaceAll(mount.Root, UserPlaceholder, username))
+		scoped.syncScope = name + "/" + url.PathEscape(username)
+		if err := h.ensureHome(ctx, scoped.davRoot); err != nil {
+			return nil, err
+		}
+	}
+	return &scoped, nil
+}
+
+// ValidHomeName reports whether username can replace UserPlaceholder without
+// changing the number of path segments of the home root.
+func ValidHomeName(username string) bool {
+	return username != "" && username != "." && username != ".." && !strings.ContainsAny(username, "/\\")
+}
+
+func (h *WebdavHandler) ensureHome(ctx context.Context, root string) error {
+	if _, created := h.mounts.homes.Load(root); created {
+		return nil
+	}
+	if err := h.fmgr.CreateFileLink(ctx, root, 0, 0, true); err != nil {
+		return fmt.Errorf("create WebDAV home %q: %w", root, err)
+	}
+	h.mounts.homes.Store(root, struct{}{})
+	return nil
+}
+
+// handleMountIndex serves the virtual collection at webRoot that lists the
+// configured mounts. It has no backing entry, so only discovery works.
+func (h *WebdavHandler) handleMountIndex(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodOptions:
+		setPrivateDAVHeaders(c.Writer.Header())
+		c.Header("Allow", "OPTIONS, PROPFIND")
+		c.Header("DAV", "1, 2, sync-collection")
+		c.Status(http.StatusOK)
+	case "PROPFIND":
+		h.writeMountIndex(c)
+	default:
+		setPrivateDAVHeaders(c.Writer.Header())
+		c.Header("Allow", "OPTIONS, PROPFIND")
+		c.Status(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *WebdavHandler) writeMountIndex(c *gin.Context) {
+	depth, err := parsePropfindDepth(c.GetHeader("Depth"))
+	if errors.Is(err, errInfinitePropfind) {
+		h.writeError(c, http.StatusForbidden, err, "propfind-finite-depth")
+		return
+	}
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	if _, err := parsePropfindRequest(c.Request); err != nil {
+		h.writeError(c, http.StatusBadRequest, err, "")
+		return
+	}
+	c.Header("Content-Type", "application/xml; charset=utf-8")
+	setPrivateDAVHeaders(c.Writer.Header())
+	c.Status(http.StatusMultiStatus)
+	encoder := xml.NewEncoder(c.Writer)
+	root := xml.StartElement{Name: xml.Name{Space: davNamespace, Local: "multistatus"}}
+	if err := encoder.EncodeToken(root); err != nil {
+		return
+	}
+	if err := h.writeMountEntry(encoder, h.webRoot+"/", ""); err != nil {
+		return
+	}
+	if depth == 1 {
+		for _, name := range h.mounts.names {
+			href := (&url.URL{Path: h.webRoot + "/" + name + "/"}).EscapedPath()
+			if err := h.writeMountEntry(encoder, href, name); err != nil {
+				return
+			}
+		}
+	}
+	_ = encoder.EncodeToken(root.End())
+	_ = encoder.Flush()
+}
+
+func (h *WebdavHandler) writeMountEntry(encoder *xml.Encoder, href, name string) error {
+	return h.writeDAVResponseElement(encoder, href, []davPropstat{{
+		Status: http.StatusOK,
+		Properties: []davPropertyValue{
+			{
+				Name: filemgr.WebDAVPropertyName{Namespace: davNamespace, LocalName: "displayname"},
+				Text: name,
+			},
+			{
+				Name:       filemgr.WebDAVPropertyName{Namespace: davNamespace, LocalName: "resourcetype"},
+				Collection: true,
+				Kind:       "resourcetype",
+			},
+		},
+	}})
+}
@@ -0,0 +1,344 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+// PrincipalRoot is where the RFC 3744 principal collection is served. Its
+// users and groups collections hold one principal per configured user and
+// webdav.groups entry.
+const PrincipalRoot = "/_principals"
+
+const (
+	principalUsers  = "users"
+	principalGroups = "groups"
+)
+
+var principalPropertyNames = []filemgr.WebDAVPropertyName{
+	{Namespace: davNamespace, LocalName: "displayname"},
+	{Namespace: davNamespace, LocalName: "resourcetype"},
+	{Namespace: davNamespace, LocalName: "principal-URL"},
+	{Namespace: davNamespace, LocalName: "alternate-URI-set"},
+	{Namespace: davNamespace, LocalName: "group-member-set"},
+	{Namespace: davNamespace, LocalName: "group-membership"},
+	{Namespace: davNamespace, LocalName: "principal-collection-set"},
+}
+
+// davPrincipalResource is a principal or one of the collections that list
+// them.
+type davPrincipalResource struct {
+	Href       string
+	Name       string
+	Collection bool
+	// Members are the group's member URLs, or the user's group URLs.
+	Members []string
+	Group   bool
+}
+
+// principalSearchRequest is a DAV:principal-property-search. Only
+// DAV:displayname is searchable, so each search is reduced to its match text.
+type principalSearchRequest struct {
+	Matches    []string
+	AnyOf      bool
+	Properties []filemgr.WebDAVPropertyName
+	// Apply searches the principal collections instead of the members of
+	// the request URI.
+	Apply bool
+}
+
+// PrincipalHandler serves the principal collection below PrincipalRoot.
+func (h *WebdavHandler) PrincipalHandler(c *gin.Context) {
+	if !h.authorize(c) {
+		return
+	}
+	switch c.Request.Method {
+	case http.MethodOptions:
+		setPrivateDAVHeaders(c.Writer.Header())
+		c.Header("Allow", strings.Join(PrincipalMethods, ", "))
+		c.Header("DAV", "1, access-control")
+		c.Status(http.StatusOK)
+	case "PROPFIND":
+		h.handlePrincipalPropfind(c)
+	case "REPORT":
+		h.handlePrincipalReport(c)
+	default:
+		setPrivateDAVHeaders(c.Writer.Header())
+		c.Header("Allow", strings.Join(PrincipalMethods, ", "))
+		c.Status(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *WebdavHandler) handlePrincipalPropfind(c *gin.Context) {
+	depth, err := parsePropfindDepth(c.GetHeader("Depth"))
+	if errors.Is(err, errInfinitePropfind) {
+		h.writeError(c, http.StatusForbidden, err, "propfind-finite-depth")
+		return
+	}
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	spec, err := parsePropfindRequest(c.Request)
+	if err != nil {
+		h.writeError(c, http.StatusBadRequest, err, "")
+		return
+	}
+	resource, members, ok := h.resolvePrincipal(c.Request.URL.Path)
+	if !ok {
+		h.writeError(c, http.StatusNotFound, errUnknownPrincipal, "")
+		return
+	}
+	resources := []davPrincipalResource{resource}
+	if depth == 1 {
+		resources = append(resources, members...)
+	}
+	h.writePrincipalResponses(c, resources, spec)
+}
+
+func (h *WebdavHandler) handlePrincipalReport(c *gin.Context) {
+	if c.GetHeader("Depth") != "0" {
+		h.writeMappedError(c, errInvalidDepth)
+		return
+	}
+	raw, err := readLimitedXMLBody(c.Request)
+	if err != nil {
+		h.writeError(c, http.StatusBadRequest, err, "")
+		return
+	}
+	if reportName(raw) != principalPropertySearchReport {
+		h.writeError(c, http.StatusBadRequest, errUnsupportedReport, "")
+		return
+	}
+	h.handlePrincipalPropertySearch(c, raw, true)
+}
+
+// handlePrincipalPropertySearch answers DAV:principal-property-search. On a
+// WebDAV resource, whose members are not principals, only a request with
+// DAV:apply-to-principal-collection-set can match anything.
+func (h *WebdavHandler) handlePrincipalPropertySearch(c *gin.Context, raw []byte, principalCollection bool) {
+	request, err := parsePrincipalSearchRequest(raw)
+	if err != nil {
+		h.writeError(c, http.StatusBadRequest, err, "")
+		return
+	}
+	candidates := make([]davPrincipalResource, 0)
+	if request.Apply || principalCollection {
+		candidates = append(candidates, h.principalMembers(principalUsers)...)
+		candidates = append(candidates, h.principalMembers(principalGroups)...)
+	}
+	matches := make([]davPrincipalResource, 0, len(candidates))
+	for _, candidate := range candidates {
+		if request.matches(candidate) {
+			matches = append(matches, candidate)
+		}
+	}
+	spec := &propertyFindRequest{Mode: propertyExplicit, Properties: request.Properties}
+	if len(spec.Properties) == 0 {
+		spec.Properties = []filemgr.WebDAVPropertyName{{Namespace: davNamespace, LocalName: "displayname"}}
+	}
+	h.writePrincipalResponses(c, matches, spec)
+}
+
+func (r *principalSearchRequest) matches(resource davPrincipalResource) bool {
+	for _, match := range r.Matches {
+		matched := strings.Contains(strings.ToLower(resource.Name), strings.ToLower(match))
+		if matched == r.AnyOf {
+			return matched
+		}
+	}
+	return !r.AnyOf
+}
+
+func parsePrincipalSearchRequest(raw []byte) (*principalSearchRequest, error) {
+	var envelope struct {
+		Test     string `xml:"test,attr"`
+		Searches []struct {
+			Prop  propertyNameContainer `xml:"DAV: prop"`
+			Match string                `xml:"DAV: match"`
+		} `xml:"DAV: property-search"`
+		Prop  []propertyNameContainer `xml:"DAV: prop"`
+		Apply *struct{}               `xml:"DAV: apply-to-principal-collection-set"`
+	}
+	if err := xml.Unmarshal(raw, &envelope); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidPrincipalSearch, err)
+	}
+	if len(envelope.Searches) == 0 || len(envelope.Prop) > 1 ||
+		(envelope.Test != "" && envelope.Test != "allof" && envelope.Test != "anyof") {
+		return nil, errInvalidPrincipalSearch
+	}
+	request := &principalSearchRequest{AnyOf: envelope.Test == "anyof", Apply: envelope.Apply != nil}
+	if len(envelope.Prop) == 1 {
+		request.Properties = envelope.Prop[0].Names
+	}
+	for _, search := range envelope.Searches {
+		for _, name := range search.Prop.Names {
+			if name.Namespace != davNamespace || name.LocalName != "displayname" {
+				return nil, fmt.Errorf("%w: only DAV:displayname is searchable", errInvalidPrincipalSearch)
+			}
+		}
+		request.Matches = append(request.Matches, search.Match)
+	}
+	return request, nil
+}
+
+func (h *WebdavHandler) writePrincipalResponses(
+	c *gin.Context,
+	resources []davPrincipalResource,
+	spec *propertyFindRequest,
+) {
+	names := spec.Properties
+	if spec.Mode != propertyExplicit {
+		names = principalPropertyNames
+	}
+	c.Header("Content-Type", "application/xml; charset=utf-8")
+	setPrivateDAVHeaders(c.Writer.Header())
+	c.Status(http.StatusMultiStatus)
+	encoder := xml.NewEncoder(c.Writer)
+	root := xml.StartElement{Name: xml.Name{Space: davNamespace, Local: "multistatus"}}
+	if err := encoder.EncodeToken(root); err != nil {
+		return
+	}
+	for _, resource := range resources {
+		found := make([]davPropertyValue, 0, len(names))
+		missing := make([]davPropertyValue, 0)
+		for _, name := range names {
+			value, ok := h.resolvePrincipalProperty(resource, name)
+			if spec.Mode == propertyNames {
+				value, ok = davPropertyValue{Name: name}, true
+			}
+			if ok {
+				found = append(found, value)
+			} else {
+				missing = append(missing, davPropertyValue{Name: name})
+			}
+		}
+		if err := h.writeDAVResponseElement(encoder, resource.Href, groupPropstats(found, nil, missing)); err != nil {
+			return
+		}
+	}
+	_ = encoder.EncodeToken(root.End())
+	_ = encoder.Flush()
+}
+
+func (h *WebdavHandler) resolvePrincipalProperty(
+	resource davPrincipalResource,
+	name filemgr.WebDAVPropertyName,
+) (davPropertyValue, bool) {
+	value := davPropertyValue{Name: name, Kind: "href-set"}
+	if name.Namespace != davNamespace {
+		return value, false
+	}
+	switch name.LocalName {
+	case "displayname":
+		value.Kind, value.Text = "", resource.Name
+	case "resourcetype":
+		value.Kind, value.Collection = "resourcetype", resource.Collection
+		if !resource.Collection {
+			value.Kind = "principal"
+		}
+	case "principal-collection-set":
+		value.Hrefs = []string{h.principalRoot + "/"}
+	case "principal-URL":
+		value.Hrefs = []string{resource.Href}
+		return value, !resource.Collection
+	case "alternate-URI-set":
+		return value, !resource.Collection
+	case "group-member-set":
+		value.Hrefs = resource.Members
+		return value, resource.Group
+	case "group-membership":
+		value.Hrefs = resource.Members
+		return value, !resource.Collection && !resource.Group
+	default:
+		return value, false
+	}
+	return value, true
+}
+
+// resolvePrincipal maps a request path below the principal root to the
+// resource it names and that resource's members.
+func (h *WebdavHandler) resolvePrincipal(requestPath string) (davPrincipalResource, []davPrincipalResource, bool) {
+	relative := strings.Trim(strings.TrimPrefix(requestPath, h.principalRoot), "/")
+	kind, name, hasName := strings.Cut(relative, "/")
+	switch {
+	case relative == "":
+		return davPrincipalResource{Href: h.principalRoot + "/", Collection: true}, []davPrincipalResource{
+			{Href: h.principalRoot + "/" + principalUsers + "/", Name: principalUsers, Collection: true},
+			{Href: h.principalRoot + "/" + principalGroups + "/", Name: principalGroups, Collection: true},
+		}, true
+	case kind != principalUsers && kind != principalGroups:
+		return davPrincipalResource{}, nil, false
+	case !hasName:
+		return davPrincipalResource{
+			Href:       h.principalRoot + "/" + kind + "/",
+			Name:       kind,
+			Collection: true,
+		}, h.principalMembers(kind), true
+	}
+	for _, member := range h.principalMembers(kind) {
+		if member.Name == name {
+			return member, nil, true
+		}
+	}
+	return davPrincipalResource{}, nil, false
+}
+
+func (h *WebdavHandler) principalMembers(kind string) []davPrincipalResource {
+	members := make([]davPrincipalResource, 0)
+	if kind == principalGroups {
+		for _, group := range slices.Sorted(maps.Keys(h.groups)) {
+			resource := davPrincipalResource{Href: h.principalURL(kind, group), Name: group, Group: true}
+			for _, member := range h.groups[group] {
+				resource.Members = append(resource.Members, h.principalURL(principalUsers, member))
+			}
+			members = append(members, resource)
+		}
+		return members
+	}
+	for _, username := range h.users {
+		resource := davPrincipalResource{Href: h.principalURL(kind, username), Name: username}
+		for _, group := range h.memberships[username] {
+			resource.Members = append(resource.Members, h.principalURL(principalGroups, group))
+		}
+		members = append(members, resource)
+	}
+	return members
+}
+
+func (h *WebdavHandler) principalURL(kind, name string) string {
+	return (&url.URL{Path: h.principalRoot + "/" + kind + "/" + name}).EscapedPath()
+}
+
+// principalFromHref maps a principal URL, absolute or not, to the principal
+// stored in an ACE.
+func (h *WebdavHandler) principalFromHref(href string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errUnrecognizedPrincipal, err)
+	}
+	relative, ok := strings.CutPrefix(strings.TrimSuffix(parsed.Path, "/"), h.principalRoot+"/")
+	if !ok {
+		return "", errUnrecognizedPrincipal
+	}
+	kind, name, _ := strings.Cut(relative, "/")
+	switch {
+	case kind == principalUsers && slices.Contains(h.users, name):
+		return filemgr.WebDAVUserPrincipal(name), nil
+	case kind == principalGroups:
+		if _, exists := h.groups[name]; exists {
+			return filemgr.WebDAVGroupPrincipal(name), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", errUnrecognizedPrincipal, href)
+}
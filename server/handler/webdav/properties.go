@@ -71,6 +71,10 @@ type davPropertyValue struct {
 	Collection bool
 	Locks      []filemgr.WebDAVLock
 	Kind       string
+	ACEs       []davACEValue
+	Privileges filemgr.WebDAVPrivilege
+	Hrefs      []string
+	Parents    []davParentValue
 }
 
 type davPropstat struct {
@@ -113,6 +117,9 @@ func (h *WebdavHandler) handlePropfind(c *gin.Context) {
 		h.writeMappedError(c, err)
 		return
 	}
+	if !h.requirePrivilege(c, base, filemgr.WebDAVPrivilegeRead) {
+		return
+	}
 
 	c.Header("Content-Type", "application/xml; charset=utf-8")
 	setPrivateDAVHeaders(c.Writer.Header())
@@ -252,6 +259,13 @@ func (h *WebdavHandler) writePropertyResponse(
 	item *entity.FileLinkMeta,
 	spec *propertyFindRequest,
 ) error {
+	privileges, err := h.privileges(ctx, item.EntryID)
+	if err != nil {
+		return err
+	}
+	if privileges&filemgr.WebDAVPrivilegeRead == 0 {
+		return h.writeDAVStatusResponse(encoder, h.externalPath(resourcePath, item.IsDir), http.StatusForbidden)
+	}
 	dead, err := h.fmgr.ReadWebDAVProperties(ctx, item.EntryID)
 	if err != nil {
 		return fmt.Errorf("read WebDAV properties: %w", err)
@@ -260,44 +274,57 @@ func (h *WebdavHandler) writePropertyResponse(
 	if err != nil {
 		return fmt.Errorf("list WebDAV locks: %w", err)
 	}
-	requested := requestedPropertyNames(spec, dead, h.quotaBytes > 0)
+	requested := requestedPropertyNames(spec, dead, h.quotaEnabled(ctx))
 	okProperties := make([]davPropertyValue, 0, len(requested))
+	forbiddenProperties := make([]davPropertyValue, 0)
 	missingProperties := make([]davPropertyValue, 0)
 	for _, name := range requested {
-		value, found, err := h.resolveDAVProperty(ctx, resourcePath, item, name, dead, locks)
+		value, status := davPropertyValue{Name: name}, http.StatusOK
+		switch {
+		case spec.Mode == propertyNames:
+		case isACLPropertyName(name):
+			value, status, err = h.resolveACLProperty(ctx, resourcePath, item, name, privileges)
+		default:
+			var found bool
+			value, found, err = h.resolveDAVProperty(ctx, resourcePath, item, name, dead, locks)
+			if !found {
+				status = http.StatusNotFound
+			}
+		}
 		if err != nil {
 			return err
 		}
-		if spec.Mode == propertyNames {
-			value = davPropertyValue{Name: name}
-			found = true
-		}
-		if found {
+		switch status {
+		case http.StatusOK:
 			okProperties = append(okProperties, value)
-		} else {
+		case http.StatusForbidden:
+			forbiddenProperties = append(forbiddenProperties, davPropertyValue{Name: name})
+		default:
 			missingProperties = append(missingProperties, davPropertyValue{Name: name})
 		}
 	}
-	propstats := make([]davPropstat, 0, 2)
-	if len(okProperties) != 0 {
-		propstats = append(propstats, davPropstat{
-			Status:     http.StatusOK,
-			Properties: okProperties,
-		})
-	}
-	if len(missingProperties) != 0 {
-		propstats = append(propstats, davPropstat{
-			Status:     http.StatusNotFound,
-			Properties: missingProperties,
-		})
-	}
 	return h.writeDAVResponseElement(
 		encoder,
 		h.externalPath(resourcePath, item.IsDir),
-		propstats,
+		groupPropstats(okProperties, forbiddenProperties, missingProperties),
 	)
 }
 
+// groupPropstats drops the empty groups of a response's properties.
+func groupPropstats(found, forbidden, missing []davPropertyValue) []davPropstat {
+	propstats := make([]davPropstat, 0, 3)
+	for _, group := range []davPropstat{
+		{Status: http.StatusOK, Properties: found},
+		{Status: http.StatusForbidden, Properties: forbidden},
+		{Status: http.StatusNotFound, Properties: missing},
+	} {
+		if len(group.Properties) != 0 {
+			propstats = append(propstats, group)
+		}
+	}
+	return propstats
+}
+
 func requestedPropertyNames(
 	spec *propertyFindRequest,
 	dead []filemgr.WebDAVProperty,
@@ -335,6 +362,9 @@ func (h *WebdavHandler) resolveDAVProperty(
 	locks []filemgr.WebDAVLock,
 ) (davPropertyValue, bool, error) {
 	value := davPropertyValue{Name: name}
+	if name == thumbnailProperty {
+		return h.resolveThumbnailDAVProperty(resourcePath, item, value)
+	}
 	if name.Namespace != davNamespace {
 		return resolveDeadDAVProperty(value, dead)
 	}
@@ -356,6 +386,8 @@ func (h *WebdavHandler) resolveLiveDAVProperty(
 		return h.resolveQuotaDAVProperty(ctx, value)
 	case "sync-token":
 		return h.resolveSyncTokenDAVProperty(ctx, resourcePath, item, value)
+	case "resource-id", "parent-set":
+		return h.resolveBindDAVProperty(ctx, item, value)
 	}
 	switch value.Name.LocalName {
 	case "displayname":
@@ -391,23 +423,44 @@ func resolveFileDAVProperty(
 	case "getcontentlength":
 		value.Text = strconv.FormatInt(item.FileSize, 10)
 	case "getcontenttype":
-		value.Text = httpkit.DetermineMimeType(item.FileName)
+		value.Text = httpkit.ContentType(item)
 	case "getetag":
 		value.Text = filemgr.WebDAVETag(item)
 	}
 	return value, true, nil
 }
 
+// quotaEnabled reports whether the mount or the principal has a byte quota
+// to show in the quota properties.
+func (h *WebdavHandler) quotaEnabled(ctx context.Context) bool {
+	return h.quotaBytes > 0 || h.fmgr.QuotaLimits(contextPrincipal(ctx)).HardBytes > 0
+}
+
+// resolveQuotaDAVProperty reports the mount quota or the hard byte quota of
+// the principal, whichever leaves less space available.
 func (h *WebdavHandler) resolveQuotaDAVProperty(
 	ctx context.Context,
 	value davPropertyValue,
 ) (davPropertyValue, bool, error) {
-	if h.quotaBytes <= 0 {
+	if !h.quotaEnabled(ctx) {
 		return value, false, nil
 	}
-	used, available, err := h.fmgr.WebDAVQuota(ctx, h.davRoot, h.quotaBytes)
-	if err != nil {
-		return value, false, fmt.Errorf("read WebDAV quota: %w", err)
+	used, available := int64(0), int64(-1)
+	if h.quotaBytes > 0 {
+		var err error
+		used, available, err = h.fmgr.WebDAVQuota(ctx, h.davRoot, h.quotaBytes)
+		if err != nil {
+			return value, false, fmt.Errorf("read WebDAV quota: %w", err)
+		}
+	}
+	if limit := h.fmgr.QuotaLimits(contextPrincipal(ctx)).HardBytes; limit > 0 {
+		usage, err := h.fmgr.QuotaUsage(ctx, contextPrincipal(ctx))
+		if err != nil {
+			return value, false, fmt.Errorf("read principal quota: %w", err)
+		}
+		if remaining := max(0, limit-usage.Bytes); available < 0 || remaining < available {
+			used, available = usage.Bytes, remaining
+		}
 	}
 	if value.Name.LocalName == "quota-used-bytes" {
 		value.Text = strconv.FormatInt(used, 10)
@@ -430,7 +483,7 @@ func (h *WebdavHandler) resolveSyncTokenDAVProperty(
 	if err != nil {
 		return value, false, fmt.Errorf("read WebDAV sync revision: %w", err)
 	}
-	value.Text = formatSyncToken(page.SyncRevision)
+	value.Text = h.formatSyncToken(page.SyncRevision)
 	return value, true, nil
 }
 
@@ -451,6 +504,11 @@ func (h *WebdavHandler) externalizeLocks(locks []filemgr.WebDAVLock) []filemgr.W
 	result := make([]filemgr.WebDAVLock, len(locks))
 	copy(result, locks)
 	for index := range result {
+		// A lock taken above the mount root is reported on the mount root
+		// rather than disclosing a path outside the share.
+		if !pathWithinRoot(h.davRoot, result[index].RootPath) {
+			result[index].RootPath = h.davRoot
+		}
 		result[index].RootPath = h.externalPath(result[index].RootPath, false)
 	}
 	return result
@@ -547,16 +605,23 @@ func encodeDAVPropertyValue(encoder *xml.Encoder, property davPropertyValue) err
 		if err := encodeSupportedReportSet(encoder); err != nil {
 			return err
 		}
+	case "acl", "current-user-privilege-set", "supported-privilege-set", "acl-restrictions",
+		"href-set", "principal":
+		return encodeACLPropertyValue(encoder, property)
+	case "parent-set":
+		return encodeParentSet(encoder, property.Parents)
 	default:
-		if property.InnerXML != "" {
-			if err := encodeInnerXML(encoder, property.InnerXML); err != nil {
-				return err
-			}
-		} else if property.Text != "" {
-			if err := encoder.EncodeToken(xml.CharData(property.Text)); err != nil {
-				return err
-			}
-		}
+		return encodeTextPropertyValue(encoder, property)
+	}
+	return nil
+}
+
+func encodeTextPropertyValue(encoder *xml.Encoder, property davPropertyValue) error {
+	if property.InnerXML != "" {
+		return encodeInnerXML(encoder, property.InnerXML)
+	}
+	if property.Text != "" {
+		return encoder.EncodeToken(xml.CharData(property.Text))
 	}
 	return nil
 }
@@ -760,24 +825,29 @@ func encodeLockShape(encoder *xml.Encoder) error {
 }
 
 func encodeSupportedReportSet(encoder *xml.Encoder) error {
-	report := xml.StartElement{Name: xml.Name{Space: davNamespace, Local: "supported-report"}}
-	if err := encoder.EncodeToken(report); err != nil {
-		return err
-	}
-	reportBody := xml.StartElement{Name: xml.Name{Space: davNamespace, Local: "report"}}
-	if err := encoder.EncodeToken(reportBody); err != nil {
-		return err
-	}
-	if err := encodeEmptyElement(
-		encoder,
-		xml.Name{Space: davNamespace, Local: "sync-collection"},
-	); err != nil {
-		return err
-	}
-	if err := encoder.EncodeToken(reportBody.End()); err != nil {
-		return err
+	for _, name := range []string{"sync-collection", principalPropertySearchReport.Local} {
+		report := xml.StartElement{Name: xml.Name{Space: davNamespace, Local: "supported-report"}}
+		if err := encoder.EncodeToken(report); err != nil {
+			return err
+		}
+		reportBody := xml.StartElement{Name: xml.Name{Space: davNamespace, Local: "report"}}
+		if err := encoder.EncodeToken(reportBody); err != nil {
+			return err
+		}
+		if err := encodeEmptyElement(
+			encoder,
+			xml.Name{Space: davNamespace, Local: name},
+		); err != nil {
+			return err
+		}
+		if err := encoder.EncodeToken(reportBody.End()); err != nil {
+			return err
+		}
+		if err := encoder.EncodeToken(report.End()); err != nil {
+			return err
+		}
 	}
-	return encoder.EncodeToken(report.End())
+	return nil
 }
 
 func (h *WebdavHandler) handlePropPatch(c *gin.Context) {
@@ -796,7 +866,7 @@ func (h *WebdavHandler) handlePropPatch(c *gin.Context) {
 	}
 	hasProtectedProperty := false
 	for _, patch := range patches {
-		if patch.Property.Name.Namespace == davNamespace {
+		if protectedProperty(patch.Property.Name) {
 			hasProtectedProperty = true
 			break
 		}
@@ -805,7 +875,7 @@ func (h *WebdavHandler) handlePropPatch(c *gin.Context) {
 	if hasProtectedProperty {
 		for index, patch := range patches {
 			statuses[index] = http.StatusFailedDependency
-			if patch.Property.Name.Namespace == davNamespace {
+			if protectedProperty(patch.Property.Name) {
 				statuses[index] = http.StatusForbidden
 			}
 		}
@@ -27,12 +27,27 @@ func (h *WebdavHandler) handleReport(c *gin.Context) {
 		h.writeMappedError(c, errInvalidDepth)
 		return
 	}
-	request, err := parseSyncCollectionRequest(c.Request)
+	raw, err := readLimitedXMLBody(c.Request)
 	if err != nil {
 		h.writeError(c, http.StatusBadRequest, err, "")
 		return
 	}
-	since, err := parseSyncToken(request.Token)
+	switch reportName(raw) {
+	case principalPropertySearchReport:
+		h.handlePrincipalPropertySearch(c, raw, false)
+		return
+	case versionTreeReport:
+		if h.fmgr.VersionsEnabled() {
+			h.handleVersionTree(c, raw)
+			return
+		}
+	}
+	request, err := parseSyncCollectionRequest(raw)
+	if err != nil {
+		h.writeError(c, http.StatusBadRequest, err, "")
+		return
+	}
+	since, err := h.parseSyncToken(request.Token)
 	if err != nil {
 		h.writeError(c, http.StatusForbidden, err, "valid-sync-token")
 		return
@@ -50,6 +65,9 @@ func (h *WebdavHandler) handleReport(c *gin.Context) {
 		h.writeError(c, http.StatusForbidden, errSyncRootNotCollection, "")
 		return
 	}
+	if !h.requirePrivilege(c, rootItem, filemgr.WebDAVPrivilegeRead) {
+		return
+	}
 	page, err := h.fmgr.WebDAVChanges(
 		c.Request.Context(),
 		rootPath,
@@ -61,7 +79,15 @@ func (h *WebdavHandler) handleReport(c *gin.Context) {
 		h.writeMappedError(c, err)
 		return
 	}
+	h.writeSyncReport(c, rootPath, request, page)
+}
 
+func (h *WebdavHandler) writeSyncReport(
+	c *gin.Context,
+	rootPath string,
+	request *syncCollectionRequest,
+	page *filemgr.WebDAVChangePage,
+) {
 	c.Header("Content-Type", "application/xml; charset=utf-8")
 	setPrivateDAVHeaders(c.Writer.Header())
 	c.Status(http.StatusMultiStatus)
@@ -94,7 +120,7 @@ func (h *WebdavHandler) handleReport(c *gin.Context) {
 	if err := encodeSimpleElement(
 		encoder,
 		xml.Name{Space: davNamespace, Local: "sync-token"},
-		formatSyncToken(page.SyncRevision),
+		h.formatSyncToken(page.SyncRevision),
 	); err != nil {
 		return
 	}
@@ -177,11 +203,20 @@ func (h *WebdavHandler) writeSyncChange(
 	)
 }
 
-func parseSyncCollectionRequest(request *http.Request) (*syncCollectionRequest, error) {
-	raw, err := readLimitedXMLBody(request)
+// principalPropertySearchReport is answered on WebDAV resources as well as
+// below PrincipalRoot.
+var principalPropertySearchReport = xml.Name{Space: davNamespace, Local: "principal-property-search"}
+
+// reportName is the root element of a REPORT body.
+func reportName(raw []byte) xml.Name {
+	start, err := nextStartElement(xml.NewDecoder(bytes.NewReader(raw)))
 	if err != nil {
-		return nil, err
+		return xml.Name{}
 	}
+	return start.Name
+}
+
+func parseSyncCollectionRequest(raw []byte) (*syncCollectionRequest, error) {
 	if len(bytes.TrimSpace(raw)) == 0 {
 		return nil, errReportBodyRequired
 	}
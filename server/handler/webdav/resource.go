@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
@@ -20,7 +21,8 @@ import (
 func (h *WebdavHandler) handleOption(c *gin.Context) {
 	setPrivateDAVHeaders(c.Writer.Header())
 	c.Header("Allow", strings.Join(h.allowedMethods(c), ", "))
-	c.Header("DAV", "1, 2, sync-collection")
+	c.Header("DAV", "1, 2, access-control, bind, sync-collection")
+	c.Header("DASL", "<DAV:basicsearch>")
 	c.Header("MS-Author-Via", "DAV")
 	c.Status(http.StatusOK)
 }
@@ -38,10 +40,23 @@ func (h *WebdavHandler) handleRead(c *gin.Context, head bool) {
 	if !ok {
 		return
 	}
+	if item.IsDir && c.Request.URL.Query().Has(archiveQuery) {
+		h.serveArchive(c, item)
+		return
+	}
 	if item.IsDir {
 		h.writeError(c, http.StatusMethodNotAllowed, errDirectoryStream, "")
 		return
 	}
+	if !h.requirePrivilege(c, item, filemgr.WebDAVPrivilegeRead) {
+		return
+	}
+	h.serveFileContent(c, item, head)
+}
+
+// serveFileContent answers a GET or HEAD for a file whose access has already
+// been checked, honouring conditional and range headers.
+func (h *WebdavHandler) serveFileContent(c *gin.Context, item *entity.FileLinkMeta, head bool) {
 	h.setValidatorHeaders(c, item)
 	status, err := evaluateReadPreconditions(c.Request, item)
 	if err != nil {
@@ -77,6 +92,17 @@ func (h *WebdavHandler) handleRead(c *gin.Context, head bool) {
 }
 
 func (h *WebdavHandler) handlePut(c *gin.Context) {
+	switch {
+	case c.GetHeader("X-Update-Range") != "":
+		// Partial updates would rewrite the whole file, because blocks are
+		// not shared between versions; refusing them keeps a client from
+		// replacing the file with the fragment it meant to patch.
+		h.writeError(c, http.StatusNotImplemented, errPartialPutUnsupported, "")
+		return
+	case c.GetHeader("Content-Range") != "":
+		h.handleRangePut(c)
+		return
+	}
 	length := c.Request.ContentLength
 	if length < 0 {
 		h.writeError(c, http.StatusLengthRequired, errPUTLengthUnknown, "")
@@ -96,12 +122,27 @@ func (h *WebdavHandler) handlePut(c *gin.Context) {
 		h.writeMappedError(c, err)
 		return
 	}
-	ctx := c.Request.Context()
-	fileID, err := h.fmgr.CreateFile(ctx, length, c.Request.Body)
+	result, err := h.publishStream(c, length, c.Request.Body, condition)
 	if err != nil {
-		h.writeMappedError(c, fmt.Errorf("create WebDAV file: %w", err))
+		h.writeMappedError(c, err)
 		return
 	}
+	h.writePutResult(c, result)
+}
+
+// publishStream uploads length bytes from reader and publishes them at the
+// request path. The uploaded blocks are discarded when publication fails.
+func (h *WebdavHandler) publishStream(
+	c *gin.Context,
+	length int64,
+	reader io.Reader,
+	condition *filemgr.WebDAVCondition,
+) (*filemgr.WebDAVPublishResult, error) {
+	ctx := c.Request.Context()
+	fileID, err := h.fmgr.CreateFile(ctx, length, reader)
+	if err != nil {
+		return nil, fmt.Errorf("create WebDAV file: %w", err)
+	}
 	result, publishErr := h.fmgr.PublishWebDAVFile(
 		ctx,
 		h.buildSrcPath(c),
@@ -113,9 +154,12 @@ func (h *WebdavHandler) handlePut(c *gin.Context) {
 		cleanupContext, cancel := context.WithTimeout(context.WithoutCancel(ctx), 15*time.Second)
 		cleanupErr := h.fmgr.DiscardUnpublishedFile(cleanupContext, fileID)
 		cancel()
-		h.writeMappedError(c, errors.Join(publishErr, cleanupErr))
-		return
+		return nil, errors.Join(publishErr, cleanupErr)
 	}
+	return result, nil
+}
+
+func (h *WebdavHandler) writePutResult(c *gin.Context, result *filemgr.WebDAVPublishResult) {
 	h.setValidatorHeaders(c, result.Link)
 	if result.Created {
 		c.Status(http.StatusCreated)
@@ -277,7 +321,7 @@ func (h *WebdavHandler) handleMove(c *gin.Context) {
 }
 
 func (h *WebdavHandler) setRepresentationHeaders(c *gin.Context, item *entity.FileLinkMeta) {
-	c.Header("Content-Type", httpkit.DetermineMimeType(item.FileName))
+	c.Header("Content-Type", httpkit.ContentType(item))
 	c.Header("Content-Length", strconv.FormatInt(item.FileSize, 10))
 	c.Header("Accept-Ranges", "bytes")
 }
@@ -0,0 +1,354 @@
+package webdav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+const (
+	// maxSearchResults bounds a SEARCH response that sets no DAV:limit; more
+	// matches are reported with a 507 response for the scope (RFC 5323 §5.6).
+	maxSearchResults = 1000
+	// maxSearchNesting bounds the depth of a where clause.
+	maxSearchNesting = 16
+)
+
+var searchFields = map[string]filemgr.WebDAVSearchField{
+	"displayname":      filemgr.WebDAVSearchDisplayName,
+	"getcontentlength": filemgr.WebDAVSearchContentLength,
+	"getlastmodified":  filemgr.WebDAVSearchLastModified,
+}
+
+// searchNode is a generic XML element of a DAV:searchrequest body.
+type searchNode struct {
+	XMLName  xml.Name
+	Text     string       `xml:",chardata"`
+	Children []searchNode `xml:",any"`
+}
+
+func (n *searchNode) is(local string) bool {
+	return n.XMLName.Space == davNamespace && n.XMLName.Local == local
+}
+
+type searchRequest struct {
+	spec      *propertyFindRequest
+	scopeHref string
+	query     filemgr.WebDAVSearchQuery
+	limited   bool
+}
+
+func (h *WebdavHandler) handleSearch(c *gin.Context) {
+	request, err := h.parseSearchRequest(c)
+	if err != nil {
+		h.writeError(c, http.StatusBadRequest, err, "")
+		return
+	}
+	// Matches the principal cannot read are left out rather than reported
+	// with a 403 response that would disclose their names.
+	if principal := h.principal(c); !h.isAdmin(principal) {
+		request.query.Principal = principal
+		request.query.Groups = h.memberships[principal]
+	}
+	result, err := h.fmgr.SearchWebDAV(c.Request.Context(), &request.query)
+	if err != nil {
+		h.writeMappedError(c, fmt.Errorf("search WebDAV scope: %w", err))
+		return
+	}
+	c.Header("Content-Type", "application/xml; charset=utf-8")
+	setPrivateDAVHeaders(c.Writer.Header())
+	c.Status(http.StatusMultiStatus)
+	encoder := xml.NewEncoder(c.Writer)
+	root := xml.StartElement{Name: xml.Name{Space: davNamespace, Local: "multistatus"}}
+	if err := encoder.EncodeToken(root); err != nil {
+		return
+	}
+	for _, match := range result.Matches {
+		if err := h.writePropertyResponse(c.Request.Context(), encoder, match.Path, match.Item, request.spec); err != nil {
+			return
+		}
+	}
+	// With DAV:limit a full page is the answer; a short page that is still
+	// truncated stopped at the scan bound and is reported like no limit.
+	if result.Truncated && (!request.limited || len(result.Matches) < request.query.Limit) {
+		if err := h.writeDAVStatusResponse(encoder, request.scopeHref, http.StatusInsufficientStorage); err != nil {
+			return
+		}
+	}
+	_ = encoder.EncodeToken(root.End())
+	_ = encoder.Flush()
+}
+
+func (h *WebdavHandler) parseSearchRequest(c *gin.Context) (*searchRequest, error) {
+	raw, err := readLimitedXMLBody(c.Request)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, errSearchBodyRequired
+	}
+	var root searchNode
+	if err := xml.Unmarshal(raw, &root); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidSearch, err)
+	}
+	if !root.is("searchrequest") || len(root.Children) != 1 || !root.Children[0].is("basicsearch") {
+		return nil, errUnsupportedSearchGrammar
+	}
+	parts := make(map[string]*searchNode)
+	for index := range root.Children[0].Children {
+		part := &root.Children[0].Children[index]
+		if part.XMLName.Space != davNamespace || parts[part.XMLName.Local] != nil {
+			return nil, fmt.Errorf("%w: unexpected %s", errInvalidSearch, part.XMLName.Local)
+		}
+		parts[part.XMLName.Local] = part
+	}
+	if parts["select"] == nil || parts["from"] == nil {
+		return nil, fmt.Errorf("%w: DAV:select and DAV:from are required", errInvalidSearch)
+	}
+	request := &searchRequest{query: filemgr.WebDAVSearchQuery{Limit: maxSearchResults}}
+	if err := h.parseSearchParts(c, parts, request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func (h *WebdavHandler) parseSearchParts(c *gin.Context, parts map[string]*searchNode, request *searchRequest) error {
+	var err error
+	for local, part := range parts {
+		switch local {
+		case "select":
+			request.spec, err = parseSearchSelect(part)
+		case "from":
+			err = h.parseSearchScope(c, part, request)
+		case "where":
+			if len(part.Children) != 1 {
+				return fmt.Errorf("%w: DAV:where takes one expression", errInvalidSearch)
+			}
+			request.query.Where, err = parseSearchCondition(&part.Children[0], 1)
+		case "orderby":
+			request.query.OrderBy, err = parseSearchOrder(part)
+		case "limit":
+			err = parseSearchLimit(part, request)
+		default:
+			return fmt.Errorf("%w: unexpected %s", errInvalidSearch, local)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseSearchSelect(node *searchNode) (*propertyFindRequest, error) {
+	if len(node.Children) != 1 {
+		return nil, fmt.Errorf("%w: DAV:select takes DAV:prop or DAV:allprop", errInvalidSearch)
+	}
+	selection := &node.Children[0]
+	switch {
+	case selection.is("allprop"):
+		return &propertyFindRequest{Mode: propertyAll}, nil
+	case selection.is("prop"):
+		spec := &propertyFindRequest{Mode: propertyExplicit}
+		for _, property := range selection.Children {
+			spec.Properties = append(spec.Properties, filemgr.WebDAVPropertyName{
+				Namespace: property.XMLName.Space,
+				LocalName: property.XMLName.Local,
+			})
+		}
+		spec.Properties = deduplicatePropertyNames(spec.Properties)
+		return spec, nil
+	}
+	return nil, fmt.Errorf("%w: DAV:select takes DAV:prop or DAV:allprop", errInvalidSearch)
+}
+
+// parseSearchScope accepts exactly one DAV:scope. Its href is resolved against
+// the request URI and must stay inside the WebDAV root of this handler.
+func (h *WebdavHandler) parseSearchScope(c *gin.Context, node *searchNode, request *searchRequest) error {
+	if len(node.Children) != 1 || !node.Children[0].is("scope") {
+		return fmt.Errorf("%w: DAV:from takes one DAV:scope", errInvalidSearchScope)
+	}
+	request.query.Depth = filemgr.WebDAVSearchInfinite
+	href := ""
+	for _, child := range node.Children[0].Children {
+		switch {
+		case child.is("href"):
+			href = strings.TrimSpace(child.Text)
+		case child.is("depth"):
+			switch strings.TrimSpace(child.Text) {
+			case "0":
+				request.query.Depth = 0
+			case "1":
+				request.query.Depth = 1
+			case "infinity":
+			default:
+				return fmt.Errorf("%w: depth %q", errInvalidSearchScope, child.Text)
+			}
+		default:
+			return fmt.Errorf("%w: unexpected %s", errInvalidSearchScope, child.XMLName.Local)
+		}
+	}
+	scope, scopeHref, err := h.searchScopePath(c, href)
+	if err != nil {
+		return err
+	}
+	request.query.Scope = scope
+	request.scopeHref = scopeHref
+	return nil
+}
+
+func (h *WebdavHandler) searchScopePath(c *gin.Context, href string) (string, string, error) {
+	invalid := fmt.Errorf("%w: href %q", errInvalidSearchScope, href)
+	uri, err := url.Parse(href)
+	if err != nil || href == "" || uri.User != nil || uri.RawQuery != "" || uri.Fragment != "" ||
+		(uri.IsAbs() && !h.absoluteOriginAllowed(uri, c.Request)) {
+		return "", "", invalid
+	}
+	uri = c.Request.URL.ResolveReference(uri)
+	if err := h.validateRequestPath(uri); err != nil {
+		return "", "", err
+	}
+	if uri.Path != h.webRoot && !strings.HasPrefix(uri.Path, h.webRoot+"/") {
+		return "", "", invalid
+	}
+	scope := h.internalPath(strings.TrimPrefix(uri.Path, h.webRoot))
+	if !pathWithinRoot(h.davRoot, scope) {
+		return "", "", invalid
+	}
+	return scope, uri.EscapedPath(), nil
+}
+
+func parseSearchCondition(node *searchNode, nesting int) (*filemgr.WebDAVSearchCondition, error) {
+	if nesting > maxSearchNesting {
+		return nil, fmt.Errorf("%w: where clause is nested too deeply", errInvalidSearch)
+	}
+	if node.XMLName.Space != davNamespace {
+		return nil, fmt.Errorf("%w: unsupported operator %s", errInvalidSearch, node.XMLName.Local)
+	}
+	condition := &filemgr.WebDAVSearchCondition{Operator: filemgr.WebDAVSearchOperator(node.XMLName.Local)}
+	switch condition.Operator {
+	case filemgr.WebDAVSearchAnd, filemgr.WebDAVSearchOr, filemgr.WebDAVSearchNot:
+		for index := range node.Children {
+			operand, err := parseSearchCondition(&node.Children[index], nesting+1)
+			if err != nil {
+				return nil, err
+			}
+			condition.Operands = append(condition.Operands, operand)
+		}
+		return condition, nil
+	case filemgr.WebDAVSearchCollection:
+		if len(node.Children) != 0 {
+			return nil, fmt.Errorf("%w: DAV:is-collection takes no operands", errInvalidSearch)
+		}
+		return condition, nil
+	case filemgr.WebDAVSearchDefined:
+		if len(node.Children) != 1 {
+			return nil, fmt.Errorf("%w: DAV:is-defined takes one DAV:prop", errInvalidSearch)
+		}
+		field, err := parseSearchProperty(&node.Children[0])
+		condition.Field = field
+		return condition, err
+	}
+	return parseSearchComparison(node, condition)
+}
+
+// parseSearchComparison reads DAV:prop and DAV:literal of like, eq, lt, lte,
+// gt and gte. Whether the operator fits the property is checked when the
+// condition is compiled.
+func parseSearchComparison(
+	node *searchNode,
+	condition *filemgr.WebDAVSearchCondition,
+) (*filemgr.WebDAVSearchCondition, error) {
+	if len(node.Children) != 2 || !node.Children[1].is("literal") {
+		return nil, fmt.Errorf("%w: DAV:%s takes DAV:prop and DAV:literal", errInvalidSearch, node.XMLName.Local)
+	}
+	field, err := parseSearchProperty(&node.Children[0])
+	if err != nil {
+		return nil, err
+	}
+	condition.Field = field
+	literal := node.Children[1].Text
+	switch field {
+	case filemgr.WebDAVSearchDisplayName:
+		condition.Text = literal
+	case filemgr.WebDAVSearchContentLength:
+		condition.Number, err = strconv.ParseInt(strings.TrimSpace(literal), 10, 64)
+	case filemgr.WebDAVSearchLastModified:
+		var modified time.Time
+		modified, err = parseSearchTime(strings.TrimSpace(literal))
+		condition.Number = modified.UnixMilli()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: literal %q: %w", errInvalidSearch, literal, err)
+	}
+	return condition, nil
+}
+
+// parseSearchTime accepts the HTTP-date form of getlastmodified and RFC 3339.
+func parseSearchTime(value string) (time.Time, error) {
+	if modified, err := http.ParseTime(value); err == nil {
+		return modified, nil
+	}
+	modified, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse search date: %w", err)
+	}
+	return modified, nil
+}
+
+func parseSearchProperty(node *searchNode) (filemgr.WebDAVSearchField, error) {
+	if !node.is("prop") || len(node.Children) != 1 {
+		return 0, fmt.Errorf("%w: expected one DAV:prop", errInvalidSearch)
+	}
+	property := node.Children[0].XMLName
+	field, ok := searchFields[property.Local]
+	if property.Space != davNamespace || !ok {
+		return 0, fmt.Errorf("%w: property {%s}%s is not searchable", errInvalidSearch, property.Space, property.Local)
+	}
+	return field, nil
+}
+
+func parseSearchOrder(node *searchNode) ([]filemgr.WebDAVSearchOrder, error) {
+	orders := make([]filemgr.WebDAVSearchOrder, 0, len(node.Children))
+	for index := range node.Children {
+		order := &node.Children[index]
+		if !order.is("order") || len(order.Children) == 0 || len(order.Children) > 2 {
+			return nil, fmt.Errorf("%w: invalid DAV:order", errInvalidSearch)
+		}
+		field, err := parseSearchProperty(&order.Children[0])
+		if err != nil {
+			return nil, err
+		}
+		item := filemgr.WebDAVSearchOrder{Field: field}
+		if len(order.Children) == 2 {
+			direction := &order.Children[1]
+			if !direction.is("ascending") && !direction.is("descending") {
+				return nil, fmt.Errorf("%w: invalid DAV:order direction", errInvalidSearch)
+			}
+			item.Descending = direction.is("descending")
+		}
+		orders = append(orders, item)
+	}
+	return orders, nil
+}
+
+func parseSearchLimit(node *searchNode, request *searchRequest) error {
+	if len(node.Children) != 1 || !node.Children[0].is("nresults") {
+		return fmt.Errorf("%w: DAV:limit takes DAV:nresults", errInvalidSearch)
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(node.Children[0].Text))
+	if err != nil || limit <= 0 {
+		return fmt.Errorf("%w: invalid DAV:nresults", errInvalidSearch)
+	}
+	request.query.Limit = min(limit, maxSearchResults)
+	request.limited = limit <= maxSearchResults
+	return nil
+}
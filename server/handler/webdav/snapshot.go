@@ -0,0 +1,356 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+// SnapshotCollection is the hidden virtual collection below webRoot that
+// serves namespace snapshots read-only.
+const SnapshotCollection = ".snapshots"
+
+const snapshotPageSize = 500
+
+var snapshotPropertyNames = []filemgr.WebDAVPropertyName{
+	{Namespace: davNamespace, LocalName: "displayname"},
+	{Namespace: davNamespace, LocalName: "creationdate"},
+	{Namespace: davNamespace, LocalName: "getlastmodified"},
+	{Namespace: davNamespace, LocalName: "getcontentlength"},
+	{Namespace: davNamespace, LocalName: "getcontenttype"},
+	{Namespace: davNamespace, LocalName: "getetag"},
+	{Namespace: davNamespace, LocalName: "resourcetype"},
+}
+
+// snapshotRequest reports whether requestPath lies below the snapshot
+// collection and splits it into the snapshot name and the path inside the
+// snapshot. An empty name addresses the collection itself.
+func (h *WebdavHandler) snapshotRequest(requestPath string) (string, string, bool) {
+	if !h.fmgr.SnapshotsEnabled() {
+		return "", "", false
+	}
+	relative := strings.TrimPrefix(strings.TrimPrefix(requestPath, h.webRoot), "/")
+	first, rest, _ := strings.Cut(relative, "/")
+	if first != SnapshotCollection {
+		return "", "", false
+	}
+	name, rest, _ := strings.Cut(rest, "/")
+	return name, path.Clean("/" + rest), true
+}
+
+// handleSnapshot serves the snapshot collection. Snapshots never change, so
+// only discovery and downloads are allowed. Snapshots do not keep the
+// per-resource ACLs of the live tree, so only administrators may browse them;
+// for everyone else the collection does not exist.
+func (h *WebdavHandler) handleSnapshot(c *gin.Context, name, entryPath string) {
+	if !h.authorizer.Has(h.principal(c), authz.AdminRead) {
+		h.writeMappedError(c, fmt.Errorf("snapshot collection: %w", os.ErrNotExist))
+		return
+	}
+	switch c.Request.Method {
+	case http.MethodOptions:
+		setPrivateDAVHeaders(c.Writer.Header())
+		c.Header("Allow", strings.Join(SnapshotMethods, ", "))
+		c.Header("DAV", "1")
+		c.Status(http.StatusOK)
+	case "PROPFIND":
+		if name == "" {
+			h.writeSnapshotIndex(c)
+			return
+		}
+		h.writeSnapshotTree(c, name, entryPath)
+	case http.MethodGet, http.MethodHead:
+		h.readSnapshotFile(c, name, entryPath)
+	default:
+		writeSnapshotMethodNotAllowed(c)
+	}
+}
+
+func writeSnapshotMethodNotAllowed(c *gin.Context) {
+	writeVirtualMethodNotAllowed(c, SnapshotMethods)
+}
+
+// writeVirtualMethodNotAllowed advertises the methods of a virtual
+// collection rather than the principal's methods on the live tree.
+func writeVirtualMethodNotAllowed(c *gin.Context, methods []string) {
+	setPrivateDAVHeaders(c.Writer.Header())
+	c.Header("Allow", strings.Join(methods, ", "))
+	c.Status(http.StatusMethodNotAllowed)
+}
+
+// snapshotVisible limits the collection to snapshots whose scope the
+// handler could also serve live.
+func (h *WebdavHandler) snapshotVisible(scope string) bool {
+	if h.mounts == nil {
+		return pathWithinRoot(h.davRoot, scope)
+	}
+	for _, mount := range h.mounts.mounts {
+		if !strings.Contains(mount.Root, UserPlaceholder) && pathWithinRoot(mount.Root, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *WebdavHandler) statSnapshotEntry(
+	ctx context.Context,
+	name, entryPath string,
+) (*entity.FileLinkMeta, error) {
+	info, err := h.fmgr.GetSnapshot(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot: %w", err)
+	}
+	if !h.snapshotVisible(info.Scope) {
+		return nil, fmt.Errorf("snapshot %s: %w", name, os.ErrNotExist)
+	}
+	entry, err := h.fmgr.StatSnapshotEntry(ctx, name, entryPath)
+	if err != nil {
+		return nil, fmt.Errorf("stat snapshot entry: %w", err)
+	}
+	item := snapshotLinkMeta(entry)
+	if entryPath == "/" {
+		item.FileName = name
+	}
+	return item, nil
+}
+
+func (h *WebdavHandler) readSnapshotFile(c *gin.Context, name, entryPath string) {
+	if name == "" {
+		writeSnapshotMethodNotAllowed(c)
+		return
+	}
+	item, err := h.statSnapshotEntry(c.Request.Context(), name, entryPath)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	if item.IsDir {
+		writeSnapshotMethodNotAllowed(c)
+		return
+	}
+	h.serveFileContent(c, item, c.Request.Method == http.MethodHead)
+}
+
+// beginSnapshotPropfind validates a PROPFIND against the snapshot collection
+// and starts the multistatus body; a nil encoder means the error response
+// has already been written.
+func (h *WebdavHandler) beginSnapshotPropfind(c *gin.Context) (int, *propertyFindRequest, *xml.Encoder) {
+	depth, err := parsePropfindDepth(c.GetHeader("Depth"))
+	if errors.Is(err, errInfinitePropfind) {
+		h.writeError(c, http.StatusForbidden, err, "propfind-finite-depth")
+		return 0, nil, nil
+	}
+	if err != nil {
+		h.writeMappedError(c, err)
+		return 0, nil, nil
+	}
+	spec, err := parsePropfindRequest(c.Request)
+	if err != nil {
+		h.writeError(c, http.StatusBadRequest, err, "")
+		return 0, nil, nil
+	}
+	return depth, spec, xml.NewEncoder(c.Writer)
+}
+
+func startMultistatus(c *gin.Context, encoder *xml.Encoder) (xml.StartElement, error) {
+	c.Header("Content-Type", "application/xml; charset=utf-8")
+	setPrivateDAVHeaders(c.Writer.Header())
+	c.Status(http.StatusMultiStatus)
+	root := xml.StartElement{Name: xml.Name{Space: davNamespace, Local: "multistatus"}}
+	return root, encoder.EncodeToken(root)
+}
+
+func (h *WebdavHandler) writeSnapshotIndex(c *gin.Context) {
+	depth, spec, encoder := h.beginSnapshotPropfind(c)
+	if encoder == nil {
+		return
+	}
+	var snapshots []filemgr.SnapshotInfo
+	if depth == 1 {
+		var err error
+		if snapshots, err = h.fmgr.ListSnapshots(c.Request.Context()); err != nil {
+			h.writeMappedError(c, fmt.Errorf("list snapshots: %w", err))
+			return
+		}
+	}
+	root, err := startMultistatus(c, encoder)
+	if err != nil {
+		return
+	}
+	collection := &entity.FileLinkMeta{FileName: SnapshotCollection, IsDir: true}
+	if err := h.writeSnapshotResponse(encoder, h.snapshotHref("", "/", true), collection, spec); err != nil {
+		return
+	}
+	for _, info := range snapshots {
+		if !h.snapshotVisible(info.Scope) {
+			continue
+		}
+		item := &entity.FileLinkMeta{FileName: info.Name, IsDir: true, Ctime: info.CreatedAt, Mtime: info.CreatedAt}
+		if err := h.writeSnapshotResponse(encoder, h.snapshotHref(info.Name, "/", true), item, spec); err != nil {
+			return
+		}
+	}
+	_ = encoder.EncodeToken(root.End())
+	_ = encoder.Flush()
+}
+
+func (h *WebdavHandler) writeSnapshotTree(c *gin.Context, name, entryPath string) {
+	depth, spec, encoder := h.beginSnapshotPropfind(c)
+	if encoder == nil {
+		return
+	}
+	base, err := h.statSnapshotEntry(c.Request.Context(), name, entryPath)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	root, err := startMultistatus(c, encoder)
+	if err != nil {
+		return
+	}
+	if err := h.writeSnapshotResponse(encoder, h.snapshotHref(name, entryPath, base.IsDir), base, spec); err != nil {
+		return
+	}
+	if depth == 1 && base.IsDir {
+		if err := h.writeSnapshotChildren(c.Request.Context(), encoder, name, entryPath, spec); err != nil {
+			return
+		}
+	}
+	_ = encoder.EncodeToken(root.End())
+	_ = encoder.Flush()
+}
+
+func (h *WebdavHandler) writeSnapshotChildren(
+	ctx context.Context,
+	encoder *xml.Encoder,
+	name, dir string,
+	spec *propertyFindRequest,
+) error {
+	cursor := ""
+	for {
+		page, err := h.fmgr.ListSnapshotEntries(ctx, filemgr.SnapshotEntryListRequest{
+			Name:   name,
+			Dir:    dir,
+			Cursor: cursor,
+			Limit:  snapshotPageSize,
+		})
+		if err != nil {
+			return fmt.Errorf("list snapshot entries: %w", err)
+		}
+		for _, entry := range page.Entries {
+			item := snapshotLinkMeta(&entry)
+			if err := h.writeSnapshotResponse(encoder, h.snapshotHref(name, entry.Path, item.IsDir), item, spec); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func (h *WebdavHandler) snapshotHref(name, entryPath string, collection bool) string {
+	external := path.Join(h.webRoot, SnapshotCollection, name, entryPath)
+	if collection {
+		external += "/"
+	}
+	return (&url.URL{Path: external}).EscapedPath()
+}
+
+func (h *WebdavHandler) writeSnapshotResponse(
+	encoder *xml.Encoder,
+	href string,
+	item *entity.FileLinkMeta,
+	spec *propertyFindRequest,
+) error {
+	return h.writeFrozenResponse(encoder, href, spec, snapshotPropertyNames, func(value davPropertyValue) (
+		davPropertyValue, bool,
+	) {
+		return resolveSnapshotDAVProperty(item, value)
+	})
+}
+
+// writeFrozenResponse answers the live properties a frozen resource still
+// has. allprop and propname report the defaults that resolve, an explicit
+// request reports the ones that do not as missing.
+func (h *WebdavHandler) writeFrozenResponse(
+	encoder *xml.Encoder,
+	href string,
+	spec *propertyFindRequest,
+	defaults []filemgr.WebDAVPropertyName,
+	resolve func(davPropertyValue) (davPropertyValue, bool),
+) error {
+	names := spec.Properties
+	if spec.Mode != propertyExplicit {
+		names = defaults
+	}
+	found := make([]davPropertyValue, 0, len(names))
+	missing := make([]davPropertyValue, 0)
+	for _, name := range names {
+		value, ok := resolve(davPropertyValue{Name: name})
+		switch {
+		case ok && spec.Mode == propertyNames:
+			found = append(found, davPropertyValue{Name: name})
+		case ok:
+			found = append(found, value)
+		case spec.Mode == propertyExplicit:
+			missing = append(missing, davPropertyValue{Name: name})
+		}
+	}
+	return h.writeDAVResponseElement(encoder, href, groupPropstats(found, nil, missing))
+}
+
+func resolveSnapshotDAVProperty(item *entity.FileLinkMeta, value davPropertyValue) (davPropertyValue, bool) {
+	if value.Name.Namespace != davNamespace {
+		return value, false
+	}
+	switch value.Name.LocalName {
+	case "getcontentlength", "getcontenttype", "getetag":
+		resolved, found, _ := resolveFileDAVProperty(item, value)
+		return resolved, found
+	case "displayname":
+		value.Text = item.FileName
+	case "creationdate":
+		if item.Ctime == 0 {
+			return value, false
+		}
+		value.Text = time.UnixMilli(item.Ctime).UTC().Format(time.RFC3339)
+	case "getlastmodified":
+		if item.Mtime == 0 {
+			return value, false
+		}
+		value.Text = time.UnixMilli(item.Mtime).UTC().Format(http.TimeFormat)
+	case "resourcetype":
+		value.Collection = item.IsDir
+		value.Kind = "resourcetype"
+	default:
+		return value, false
+	}
+	return value, true
+}
+
+func snapshotLinkMeta(entry *filemgr.SnapshotEntry) *entity.FileLinkMeta {
+	return &entity.FileLinkMeta{
+		FileName: entry.Name,
+		FileId:   entry.FileID,
+		FileSize: entry.Size,
+		Mode:     entry.Mode,
+		Ctime:    entry.Ctime,
+		Mtime:    entry.Mtime,
+		IsDir:    entry.IsDir,
+	}
+}
@@ -0,0 +1,120 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+// ResolveTarget maps relative, a file path as a client addresses it below
+// the WebDAV root, to the namespace path and the scope a write by principal
+// is published with there. It fails when principal may not write the share.
+func (h *WebdavHandler) ResolveTarget(
+	ctx context.Context,
+	principal, relative string,
+) (string, filemgr.WebDAVScope, error) {
+	mount := ""
+	if h.mounts != nil {
+		var rest string
+		mount, rest, _ = strings.Cut(strings.TrimPrefix(relative, "/"), "/")
+		relative = "/" + rest
+	}
+	scoped, err := h.writableScope(ctx, principal, mount)
+	if err != nil {
+		return "", filemgr.WebDAVScope{}, err
+	}
+	resolved := scoped.internalPath(relative)
+	if relative != path.Clean(relative) || resolved == scoped.davRoot || !pathWithinRoot(scoped.davRoot, resolved) {
+		return "", filemgr.WebDAVScope{}, filemgr.ErrWebDAVTarget
+	}
+	return resolved, filemgr.WebDAVScope{Principal: principal, Mount: mount}, nil
+}
+
+// ResolveScope returns the options a write of scope is published with at
+// target, a namespace path, from the current groups, admin rights and mount
+// quota of the principal. It fails when the principal may no longer write
+// the mount or target has left it.
+func (h *WebdavHandler) ResolveScope(
+	ctx context.Context,
+	scope filemgr.WebDAVScope,
+	target string,
+) (filemgr.WebDAVMutationOptions, error) {
+	scoped, err := h.writableScope(ctx, scope.Principal, scope.Mount)
+	if err != nil {
+		return filemgr.WebDAVMutationOptions{}, err
+	}
+	if target == scoped.davRoot || !pathWithinRoot(scoped.davRoot, target) {
+		return filemgr.WebDAVMutationOptions{}, filemgr.ErrWebDAVTarget
+	}
+	return scoped.writeOptions(scope.Principal, nil), nil
+}
+
+// writableScope returns the handler of mount, or h without mounts, once
+// principal may write it.
+func (h *WebdavHandler) writableScope(ctx context.Context, principal, mount string) (*WebdavHandler, error) {
+	scoped := h
+	switch {
+	case h.mounts != nil:
+		var err error
+		scoped, err = h.scopeMount(ctx, mount, principal)
+		switch {
+		case errors.Is(err, errUnknownMount), errors.Is(err, errHomeUnavailable):
+			return nil, fmt.Errorf("%w: %w", filemgr.ErrWebDAVTarget, err)
+		case err != nil:
+			return nil, err
+		}
+	case mount != "":
+		return nil, fmt.Errorf("%w: %w: %s", filemgr.ErrWebDAVTarget, errUnknownMount, mount)
+	}
+	if scoped.level(principal) != authz.LevelReadWrite {
+		return nil, filemgr.ErrWebDAVTargetForbidden
+	}
+	return scoped, nil
+}
+
+// CanRead reports whether principal can read resourcePath, a namespace path,
+// over WebDAV: the path lies below the WebDAV root or a mount the principal
+// reaches, and the principal holds webdav:read and DAV:read on the entry.
+func (h *WebdavHandler) CanRead(ctx context.Context, principal, resourcePath string) (bool, error) {
+	if h.level(principal) == authz.LevelNone || !h.reachable(principal, resourcePath) {
+		return false, nil
+	}
+	info, err := h.fmgr.StatFileLink(ctx, resourcePath)
+	if err != nil {
+		return false, fmt.Errorf("stat WebDAV source: %w", err)
+	}
+	if h.isAdmin(principal) {
+		return true, nil
+	}
+	granted, err := h.fmgr.WebDAVPrivileges(ctx, info.EntryID, principal, h.memberships[principal])
+	if err != nil {
+		return false, fmt.Errorf("evaluate WebDAV ACL: %w", err)
+	}
+	return granted&filemgr.WebDAVPrivilegeRead != 0, nil
+}
+
+// reachable reports whether resourcePath lies below the WebDAV root or below
+// a mount, including the principal's own home, that principal can open.
+func (h *WebdavHandler) reachable(principal, resourcePath string) bool {
+	if h.mounts == nil {
+		return pathWithinRoot(h.davRoot, resourcePath)
+	}
+	for _, mount := range h.mounts.mounts {
+		root := mount.Root
+		if strings.Contains(root, UserPlaceholder) {
+			if !ValidHomeName(principal) {
+				continue
+			}
+			root = strings.ReplaceAll(root, UserPlaceholder, principal)
+		}
+		if pathWithinRoot(root, resourcePath) {
+			return true
+		}
+	}
+	return false
+}
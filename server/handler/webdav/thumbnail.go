@@ -0,0 +1,118 @@
+package webdav
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/server/httpkit"
+)
+
+// ThumbnailCollection is the hidden virtual collection below webRoot that
+// mirrors the tree with the JPEG previews of its image files.
+const ThumbnailCollection = ".thumbnails"
+
+// thumbnailProperty links an image file to its preview. It is only returned
+// when a PROPFIND names it, so listings do not pay for it.
+var thumbnailProperty = filemgr.WebDAVPropertyName{Namespace: "urn:tgfile:", LocalName: "thumbnail"}
+
+// thumbnailRequest reports whether requestPath lies below the thumbnail
+// collection and returns the path of the source file relative to webRoot.
+func (h *WebdavHandler) thumbnailRequest(requestPath string) (string, bool) {
+	if !h.fmgr.ThumbnailsEnabled() {
+		return "", false
+	}
+	relative := strings.Trim(strings.TrimPrefix(requestPath, h.webRoot), "/")
+	first, member, _ := strings.Cut(relative, "/")
+	if first != ThumbnailCollection {
+		return "", false
+	}
+	return member, true
+}
+
+// handleThumbnail serves the preview of the file at the same path outside
+// the thumbnail collection, rendering it on the first request.
+func (h *WebdavHandler) handleThumbnail(c *gin.Context, member string) {
+	switch c.Request.Method {
+	case http.MethodOptions:
+		setPrivateDAVHeaders(c.Writer.Header())
+		c.Header("Allow", strings.Join(ThumbnailMethods, ", "))
+		c.Header("DAV", "1")
+		c.Status(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		if member == "" {
+			writeVirtualMethodNotAllowed(c, ThumbnailMethods)
+			return
+		}
+		h.serveThumbnail(c, h.internalPath(member))
+	default:
+		writeVirtualMethodNotAllowed(c, ThumbnailMethods)
+	}
+}
+
+func (h *WebdavHandler) serveThumbnail(c *gin.Context, resourcePath string) {
+	item, err := h.fmgr.StatFileLink(c.Request.Context(), resourcePath)
+	if err == nil && item.IsDir {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		h.writeMappedError(c, fmt.Errorf("stat thumbnail source: %w", err))
+		return
+	}
+	if !h.requirePrivilege(c, item, filemgr.WebDAVPrivilegeRead) {
+		return
+	}
+	thumbnail, err := h.fmgr.GetThumbnail(c.Request.Context(), item.FileId)
+	if errors.Is(err, filemgr.ErrThumbnailUnsupported) {
+		h.writeError(c, http.StatusNotFound, err, "")
+		return
+	}
+	if err != nil {
+		h.writeMappedError(c, fmt.Errorf("read thumbnail: %w", err))
+		return
+	}
+	h.serveFileContent(c, &entity.FileLinkMeta{
+		FileName: item.FileName + ".jpg",
+		FileId:   thumbnail.FileID,
+		FileSize: thumbnail.Size,
+		Ctime:    thumbnail.CreatedAt,
+		Mtime:    thumbnail.CreatedAt,
+	}, c.Request.Method == http.MethodHead)
+}
+
+// resolveThumbnailDAVProperty links JPEG, PNG and GIF files to their preview.
+// Reading the property does not render the preview.
+func (h *WebdavHandler) resolveThumbnailDAVProperty(
+	resourcePath string,
+	item *entity.FileLinkMeta,
+	value davPropertyValue,
+) (davPropertyValue, bool, error) {
+	if !h.fmgr.ThumbnailsEnabled() || item.IsDir {
+		return value, false, nil
+	}
+	switch httpkit.DetermineMimeType(item.FileName) {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return value, false, nil
+	}
+	relative := resourcePath
+	if h.davRoot != "/" {
+		relative = strings.TrimPrefix(resourcePath, h.davRoot)
+	}
+	value.Kind = "href-set"
+	value.Hrefs = []string{(&url.URL{Path: path.Join(h.webRoot, ThumbnailCollection, relative)}).EscapedPath()}
+	return value, true, nil
+}
+
+// protectedProperty reports whether PROPPATCH may not set a property.
+func protectedProperty(name filemgr.WebDAVPropertyName) bool {
+	return name.Namespace == davNamespace || name == thumbnailProperty
+}
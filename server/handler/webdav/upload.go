@@ -0,0 +1,166 @@
+package webdav
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/uploadsession"
+)
+
+// contentRange is a parsed "bytes start-end/total" header; end is inclusive.
+// A "bytes */total" header only asks for the progress of the upload.
+type contentRange struct {
+	start int64
+	end   int64
+	total int64
+	query bool
+}
+
+func parseContentRange(value string) (contentRange, error) {
+	invalid := fmt.Errorf("%w: %q", errInvalidContentRange, value)
+	spec, ok := strings.CutPrefix(strings.TrimSpace(value), "bytes ")
+	if !ok {
+		return contentRange{}, invalid
+	}
+	span, totalText, ok := strings.Cut(spec, "/")
+	if !ok {
+		return contentRange{}, invalid
+	}
+	total, err := strconv.ParseInt(totalText, 10, 64)
+	if err != nil || total < 0 {
+		return contentRange{}, invalid
+	}
+	if span == "*" {
+		return contentRange{total: total, query: true}, nil
+	}
+	startText, endText, ok := strings.Cut(span, "-")
+	if !ok {
+		return contentRange{}, invalid
+	}
+	start, startErr := strconv.ParseInt(startText, 10, 64)
+	end, endErr := strconv.ParseInt(endText, 10, 64)
+	if startErr != nil || endErr != nil || start < 0 || start > end || end >= total {
+		return contentRange{}, invalid
+	}
+	return contentRange{start: start, end: end, total: total}, nil
+}
+
+// handleRangePut stores one Content-Range chunk of a resumable upload. The
+// session is keyed by principal and path and every block it fills goes to
+// the backend at once, so a client that reconnects asks for the progress and
+// sends the rest; the request that supplies the last byte publishes the file
+// like a plain PUT.
+func (h *WebdavHandler) handleRangePut(c *gin.Context) {
+	if h.uploads == nil {
+		h.writeError(c, http.StatusNotImplemented, errRangePutDisabled, "")
+		return
+	}
+	span, err := parseContentRange(c.GetHeader("Content-Range"))
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	if h.maxUploadSize > 0 && span.total > h.maxUploadSize {
+		h.writeError(c, http.StatusRequestEntityTooLarge, errPUTTooLarge, "")
+		return
+	}
+	if span.query {
+		h.writeUploadStatus(c, span.total)
+		return
+	}
+	if c.Request.ContentLength != span.end-span.start+1 {
+		h.writeMappedError(c, fmt.Errorf("%w: body length differs from the range", errInvalidContentRange))
+		return
+	}
+	condition, err := h.requestCondition(c)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	resourcePath := h.buildSrcPath(c)
+	session, err := h.uploads.Resume(c.Request.Context(), uploadsession.CreateRequest{
+		Owner:      h.principal(c),
+		FileName:   path.Base(resourcePath),
+		TargetPath: resourcePath,
+		WebDAV:     filemgr.WebDAVScope{Principal: h.principal(c), Mount: h.mount},
+		Size:       span.total,
+	})
+	if err != nil {
+		h.writeMappedError(c, fmt.Errorf("open WebDAV upload session: %w", err))
+		return
+	}
+	if span.start > session.Offset {
+		h.writeUploadProgress(c, http.StatusRequestedRangeNotSatisfiable, session.Offset)
+		return
+	}
+	session, err = h.receiveRange(c, session, span, condition)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	if !session.Completed {
+		h.writeUploadProgress(c, http.StatusAccepted, session.Offset)
+		return
+	}
+	h.writePutResult(c, session.Published)
+}
+
+// receiveRange appends the part of span after the bytes the session already
+// has. A range the session already holds is a write of no bytes, which
+// retries a publication that failed.
+func (h *WebdavHandler) receiveRange(
+	c *gin.Context,
+	session *uploadsession.Session,
+	span contentRange,
+	condition *filemgr.WebDAVCondition,
+) (*uploadsession.Session, error) {
+	length := span.end - span.start + 1
+	skip := min(session.Offset-span.start, length)
+	if skipped, err := io.CopyN(io.Discard, c.Request.Body, skip); err != nil || skipped != skip {
+		return nil, errShortRangeBody
+	}
+	session, err := h.uploads.WriteConditional(
+		c.Request.Context(),
+		session.Owner,
+		session.ID,
+		session.Offset,
+		io.LimitReader(c.Request.Body, length-skip),
+		condition,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("write WebDAV upload range: %w", err)
+	}
+	return session, nil
+}
+
+func (h *WebdavHandler) writeUploadStatus(c *gin.Context, total int64) {
+	session, err := h.uploads.Find(c.Request.Context(), h.principal(c), h.buildSrcPath(c))
+	if err != nil && !errors.Is(err, uploadsession.ErrNotFound) {
+		h.writeMappedError(c, fmt.Errorf("find WebDAV upload session: %w", err))
+		return
+	}
+	var received int64
+	if err == nil && session.Size == total {
+		received = session.Offset
+	}
+	h.writeUploadProgress(c, http.StatusAccepted, received)
+}
+
+// writeUploadProgress reports the bytes an upload holds as the inclusive
+// range X-Received-Ranges, e.g. "0-99", which is absent before the first
+// byte. Bytes are only accepted in order, so it is a single range.
+func (h *WebdavHandler) writeUploadProgress(c *gin.Context, status int, received int64) {
+	setPrivateDAVHeaders(c.Writer.Header())
+	if received > 0 {
+		c.Header("X-Received-Ranges", "0-"+strconv.FormatInt(received-1, 10))
+	}
+	c.Status(status)
+}
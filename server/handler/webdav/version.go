@@ -0,0 +1,284 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/directory"
+	"github.com/xxxsen/tgfile/entity"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+// VersionCollection is the hidden virtual collection below webRoot whose
+// members are the kept versions of overwritten files, named by version id.
+const VersionCollection = ".versions"
+
+const versionPageSize = 500
+
+var (
+	errInvalidVersionTree = errors.New("invalid DAV:version-tree body")
+	errVersionDestination = errors.New("destination is inside the version collection")
+	versionTreeReport     = xml.Name{Space: davNamespace, Local: "version-tree"}
+	versionNameProperty   = filemgr.WebDAVPropertyName{Namespace: davNamespace, LocalName: "version-name"}
+	versionPropertyNames  = append(slices.Clone(snapshotPropertyNames), versionNameProperty)
+)
+
+// versionRequest reports whether requestPath lies below the version
+// collection and returns the member it names, empty for the collection.
+func (h *WebdavHandler) versionRequest(requestPath string) (string, bool) {
+	if !h.fmgr.VersionsEnabled() {
+		return "", false
+	}
+	relative := strings.Trim(strings.TrimPrefix(requestPath, h.webRoot), "/")
+	first, member, _ := strings.Cut(relative, "/")
+	if first != VersionCollection {
+		return "", false
+	}
+	return member, true
+}
+
+// handleVersion serves the version collection. Versions are immutable:
+// they can be discovered, downloaded and copied back over a live path, which
+// restores them. Versions are listed through a DAV:version-tree REPORT on
+// the live path.
+func (h *WebdavHandler) handleVersion(c *gin.Context, member string) {
+	switch c.Request.Method {
+	case http.MethodOptions:
+		setPrivateDAVHeaders(c.Writer.Header())
+		c.Header("Allow", strings.Join(VersionMethods, ", "))
+		c.Header("DAV", "1")
+		c.Status(http.StatusOK)
+	case "PROPFIND":
+		h.writeVersionPropfind(c, member)
+	case http.MethodGet, http.MethodHead:
+		version, ok := h.statVersion(c, member)
+		if !ok {
+			return
+		}
+		h.serveFileContent(c, versionLinkMeta(version), c.Request.Method == http.MethodHead)
+	case "COPY":
+		h.restoreVersion(c, member)
+	default:
+		writeVirtualMethodNotAllowed(c, VersionMethods)
+	}
+}
+
+// statVersion resolves a version member. A version is visible when the path
+// it was taken from lies inside the handler root and the principal may read
+// that path or, once it is deleted, its nearest existing ancestor.
+func (h *WebdavHandler) statVersion(c *gin.Context, member string) (*filemgr.FileVersion, bool) {
+	if member == "" {
+		writeVirtualMethodNotAllowed(c, VersionMethods)
+		return nil, false
+	}
+	versionID, err := strconv.ParseUint(member, 10, 64)
+	if err != nil || strings.Contains(member, "/") {
+		h.writeError(c, http.StatusNotFound, os.ErrNotExist, "")
+		return nil, false
+	}
+	version, err := h.fmgr.GetFileVersion(c.Request.Context(), versionID)
+	if err == nil && !pathWithinRoot(h.davRoot, version.Path) {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		h.writeMappedError(c, fmt.Errorf("read file version: %w", err))
+		return nil, false
+	}
+	if !h.requireVersionAccess(c, version.Path) {
+		return nil, false
+	}
+	return version, true
+}
+
+// requireVersionAccess checks DAV:read on the path a version was taken
+// from. Once that path is gone the nearest existing ancestor decides, so a
+// deleted file's versions stay as private as the collection it lived in.
+func (h *WebdavHandler) requireVersionAccess(c *gin.Context, resourcePath string) bool {
+	for current := resourcePath; ; current = path.Dir(current) {
+		item, err := h.fmgr.StatFileLink(c.Request.Context(), current)
+		if errors.Is(err, os.ErrNotExist) && current != "/" {
+			continue
+		}
+		if err != nil {
+			h.writeMappedError(c, fmt.Errorf("stat versioned resource: %w", err))
+			return false
+		}
+		return h.requirePrivilege(c, item, filemgr.WebDAVPrivilegeRead)
+	}
+}
+
+func (h *WebdavHandler) writeVersionPropfind(c *gin.Context, member string) {
+	_, spec, encoder := h.beginSnapshotPropfind(c)
+	if encoder == nil {
+		return
+	}
+	item := &entity.FileLinkMeta{FileName: VersionCollection, IsDir: true}
+	if member != "" {
+		version, ok := h.statVersion(c, member)
+		if !ok {
+			return
+		}
+		item = versionLinkMeta(version)
+	}
+	root, err := startMultistatus(c, encoder)
+	if err != nil {
+		return
+	}
+	if err := h.writeVersionResponse(encoder, item, member, spec); err != nil {
+		return
+	}
+	_ = encoder.EncodeToken(root.End())
+	_ = encoder.Flush()
+}
+
+// restoreVersion publishes a version at the Destination of a COPY. The
+// content it replaces there is kept as a version in turn.
+func (h *WebdavHandler) restoreVersion(c *gin.Context, member string) {
+	version, ok := h.statVersion(c, member)
+	if !ok {
+		return
+	}
+	overwrite, err := parseOverwrite(c.GetHeader("Overwrite"))
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	destination, err := h.tryBuildDstPath(c)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	if pathWithinRoot(h.internalPath(VersionCollection), destination) {
+		h.writeError(c, http.StatusForbidden, errVersionDestination, "")
+		return
+	}
+	condition, err := h.requestCondition(c)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	if _, err := h.fmgr.StatFileLink(c.Request.Context(), destination); err == nil && !overwrite {
+		h.writeMappedError(c, directory.ErrDestinationExists)
+		return
+	}
+	result, err := h.fmgr.RestoreFileVersion(
+		c.Request.Context(),
+		version.VersionID,
+		destination,
+		h.mutationOptions(c, condition),
+	)
+	if err != nil {
+		h.writeMappedError(c, err)
+		return
+	}
+	setPrivateDAVHeaders(c.Writer.Header())
+	if result.Created {
+		c.Status(http.StatusCreated)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleVersionTree answers a DAV:version-tree REPORT on a path with its
+// kept versions, newest first. It also works after the path was deleted.
+func (h *WebdavHandler) handleVersionTree(c *gin.Context, raw []byte) {
+	spec, err := parseVersionTreeRequest(raw)
+	if err != nil {
+		h.writeError(c, http.StatusBadRequest, err, "")
+		return
+	}
+	resourcePath := h.buildSrcPath(c)
+	if !h.requireVersionAccess(c, resourcePath) {
+		return
+	}
+	versions := make([]filemgr.FileVersion, 0)
+	request := filemgr.FileVersionListRequest{Path: resourcePath, Limit: versionPageSize}
+	for {
+		page, err := h.fmgr.ListFileVersions(c.Request.Context(), request)
+		if err != nil {
+			h.writeMappedError(c, fmt.Errorf("list file versions: %w", err))
+			return
+		}
+		versions = append(versions, page.Versions...)
+		if page.NextCursor == 0 {
+			break
+		}
+		request.Cursor = page.NextCursor
+	}
+	encoder := xml.NewEncoder(c.Writer)
+	root, err := startMultistatus(c, encoder)
+	if err != nil {
+		return
+	}
+	for _, version := range versions {
+		member := strconv.FormatUint(version.VersionID, 10)
+		if err := h.writeVersionResponse(encoder, versionLinkMeta(&version), member, spec); err != nil {
+			return
+		}
+	}
+	_ = encoder.EncodeToken(root.End())
+	_ = encoder.Flush()
+}
+
+func parseVersionTreeRequest(raw []byte) (*propertyFindRequest, error) {
+	var envelope struct {
+		Prop    []propertyNameContainer `xml:"DAV: prop"`
+		Unknown []struct {
+			XMLName xml.Name
+		} `xml:",any"`
+	}
+	if err := xml.Unmarshal(raw, &envelope); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidVersionTree, err)
+	}
+	if len(envelope.Unknown) != 0 || len(envelope.Prop) > 1 {
+		return nil, errInvalidVersionTree
+	}
+	if len(envelope.Prop) == 0 {
+		return &propertyFindRequest{Mode: propertyAll}, nil
+	}
+	return &propertyFindRequest{Mode: propertyExplicit, Properties: envelope.Prop[0].Names}, nil
+}
+
+func (h *WebdavHandler) writeVersionResponse(
+	encoder *xml.Encoder,
+	item *entity.FileLinkMeta,
+	member string,
+	spec *propertyFindRequest,
+) error {
+	external := path.Join(h.webRoot, VersionCollection, member)
+	if member == "" {
+		external += "/"
+	}
+	href := (&url.URL{Path: external}).EscapedPath()
+	return h.writeFrozenResponse(encoder, href, spec, versionPropertyNames, func(value davPropertyValue) (
+		davPropertyValue, bool,
+	) {
+		if value.Name != versionNameProperty {
+			return resolveSnapshotDAVProperty(item, value)
+		}
+		value.Text = member
+		return value, member != ""
+	})
+}
+
+// versionLinkMeta presents a version as a file named like the path it was
+// taken from, created when it was replaced.
+func versionLinkMeta(version *filemgr.FileVersion) *entity.FileLinkMeta {
+	return &entity.FileLinkMeta{
+		FileName: path.Base(version.Path),
+		FileId:   version.FileID,
+		FileSize: version.Size,
+		Ctime:    version.CreatedAt,
+		Mtime:    version.Mtime,
+	}
+}
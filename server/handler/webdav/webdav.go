@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -19,55 +20,80 @@ import (
 	"github.com/xxxsen/common/webapi/proxyutil"
 	"go.uber.org/zap"
 
+	"github.com/xxxsen/tgfile/archive"
 	"github.com/xxxsen/tgfile/authz"
 	"github.com/xxxsen/tgfile/directory"
 	"github.com/xxxsen/tgfile/entity"
 	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/uploadsession"
 )
 
 const (
 	davNamespace            = "DAV:"
+	resourceIDURN           = "urn:tgfile:resource:"
 	maxDAVXMLBodySize int64 = 1 << 20
 )
 
 var (
-	errUnsupportedMethod       = errors.New("unsupported WebDAV method")
-	errDestinationWebRoot      = errors.New("destination is outside WebDAV root")
-	errDestinationOrigin       = errors.New("destination uses a different origin")
-	errDirectoryStream         = errors.New("cannot open a stream on a directory")
-	errMKCOLBody               = errors.New("MKCOL request body is not supported")
-	errInvalidDepth            = errors.New("invalid WebDAV Depth header")
-	errInfinitePropfind        = errors.New("infinite-depth PROPFIND is disabled")
-	errInvalidOverwrite        = errors.New("invalid WebDAV Overwrite header")
-	errSameResource            = errors.New("source and destination are the same resource")
-	errInvalidDestination      = errors.New("invalid WebDAV Destination header")
-	errInvalidEncodedSeparator = errors.New("encoded path separator is not allowed")
-	errReadOnly                = errors.New("WebDAV account is read-only")
-	errInvalidIfHeader         = errors.New("invalid WebDAV If header")
-	errInvalidLockToken        = errors.New("invalid WebDAV Lock-Token header")
-	errInvalidCondition        = errors.New("invalid HTTP conditional header")
-	errInvalidEntityTag        = errors.New("invalid entity-tag list")
-	errDAVXMLBodyTooLarge      = errors.New("WebDAV XML body exceeds the configured limit")
-	errInvalidSyncToken        = errors.New("invalid WebDAV sync token")
-	errUnsupportedLockTimeout  = errors.New("unsupported WebDAV lock timeout")
-	errInvalidLockInfo         = errors.New("invalid DAV:lockinfo body")
-	errUnsupportedLockScope    = errors.New("only exclusive write locks are supported")
-	errInvalidPropfind         = errors.New("invalid DAV:propfind body")
-	errInvalidPropfindElement  = errors.New("invalid PROPFIND instruction")
-	errMultiplePropfindModes   = errors.New("multiple PROPFIND selection modes")
-	errMissingPropfindMode     = errors.New("PROPFIND selection is missing")
-	errInvalidPropfindInclude  = errors.New("DAV:include requires DAV:allprop")
-	errPropPatchBodyRequired   = errors.New("PROPPATCH body is required")
-	errInvalidPropertyUpdate   = errors.New("invalid DAV:propertyupdate body")
-	errInvalidPropPatchOp      = errors.New("invalid PROPPATCH operation")
-	errEmptyPropPatch          = errors.New("PROPPATCH operation has no properties")
-	errSyncRootNotCollection   = errors.New("sync root is not a collection")
-	errReportBodyRequired      = errors.New("REPORT body is required")
-	errUnsupportedReport       = errors.New("unsupported REPORT body")
-	errInvalidSyncInstruction  = errors.New("invalid sync-collection instruction")
-	errUnsupportedSyncLevel    = errors.New("unsupported sync level")
-	errPUTLengthUnknown        = errors.New("WebDAV PUT length is unknown")
-	errPUTTooLarge             = errors.New("WebDAV PUT exceeds max_upload_size")
+	errUnsupportedMethod        = errors.New("unsupported WebDAV method")
+	errDestinationWebRoot       = errors.New("destination is outside WebDAV root")
+	errDestinationOrigin        = errors.New("destination uses a different origin")
+	errDirectoryStream          = errors.New("cannot open a stream on a directory")
+	errMKCOLBody                = errors.New("MKCOL request body is not supported")
+	errInvalidDepth             = errors.New("invalid WebDAV Depth header")
+	errInfinitePropfind         = errors.New("infinite-depth PROPFIND is disabled")
+	errInvalidOverwrite         = errors.New("invalid WebDAV Overwrite header")
+	errSameResource             = errors.New("source and destination are the same resource")
+	errInvalidDestination       = errors.New("invalid WebDAV Destination header")
+	errInvalidEncodedSeparator  = errors.New("encoded path separator is not allowed")
+	errReadOnly                 = errors.New("WebDAV account is read-only")
+	errInvalidIfHeader          = errors.New("invalid WebDAV If header")
+	errInvalidLockToken         = errors.New("invalid WebDAV Lock-Token header")
+	errInvalidCondition         = errors.New("invalid HTTP conditional header")
+	errInvalidEntityTag         = errors.New("invalid entity-tag list")
+	errDAVXMLBodyTooLarge       = errors.New("WebDAV XML body exceeds the configured limit")
+	errInvalidSyncToken         = errors.New("invalid WebDAV sync token")
+	errUnsupportedLockTimeout   = errors.New("unsupported WebDAV lock timeout")
+	errInvalidLockInfo          = errors.New("invalid DAV:lockinfo body")
+	errUnsupportedLockScope     = errors.New("only exclusive write locks are supported")
+	errInvalidPropfind          = errors.New("invalid DAV:propfind body")
+	errInvalidPropfindElement   = errors.New("invalid PROPFIND instruction")
+	errMultiplePropfindModes    = errors.New("multiple PROPFIND selection modes")
+	errMissingPropfindMode      = errors.New("PROPFIND selection is missing")
+	errInvalidPropfindInclude   = errors.New("DAV:include requires DAV:allprop")
+	errPropPatchBodyRequired    = errors.New("PROPPATCH body is required")
+	errInvalidPropertyUpdate    = errors.New("invalid DAV:propertyupdate body")
+	errInvalidPropPatchOp       = errors.New("invalid PROPPATCH operation")
+	errEmptyPropPatch           = errors.New("PROPPATCH operation has no properties")
+	errSyncRootNotCollection    = errors.New("sync root is not a collection")
+	errReportBodyRequired       = errors.New("REPORT body is required")
+	errUnsupportedReport        = errors.New("unsupported REPORT body")
+	errInvalidSyncInstruction   = errors.New("invalid sync-collection instruction")
+	errUnsupportedSyncLevel     = errors.New("unsupported sync level")
+	errPUTLengthUnknown         = errors.New("WebDAV PUT length is unknown")
+	errPUTTooLarge              = errors.New("WebDAV PUT exceeds max_upload_size")
+	errMountRoot                = errors.New("WebDAV mount root cannot be replaced or removed")
+	errInvalidContentRange      = errors.New("invalid WebDAV PUT Content-Range")
+	errPartialPutUnsupported    = errors.New("WebDAV PUT with X-Update-Range is not supported")
+	errShortRangeBody           = errors.New("WebDAV PUT body is shorter than its range")
+	errRangePutDisabled         = errors.New("resumable WebDAV PUT is not enabled")
+	errSearchBodyRequired       = errors.New("SEARCH body is required")
+	errUnsupportedSearchGrammar = errors.New("only DAV:basicsearch is supported")
+	errInvalidSearch            = errors.New("invalid DAV:basicsearch query")
+	errInvalidSearchScope       = errors.New("invalid DAV:basicsearch scope")
+	errBindingBodyRequired      = errors.New("binding request body is required")
+	errInvalidBinding           = errors.New("invalid binding request body")
+	errInvalidSegment           = errors.New("invalid binding segment")
+	errBindingCollection        = errors.New("binding request target is not a collection")
+	errACLBodyRequired          = errors.New("ACL body is required")
+	errInvalidACL               = errors.New("invalid DAV:acl body")
+	errACLInvert                = errors.New("DAV:invert is not supported")
+	errACLProtected             = errors.New("protected ACEs cannot be changed")
+	errACLInherited             = errors.New("inherited ACEs are changed on the collection that sets them")
+	errUnsupportedPrivilege     = errors.New("unsupported WebDAV privilege")
+	errUnrecognizedPrincipal    = errors.New("unrecognized WebDAV principal")
+	errUnknownPrincipal         = errors.New("unknown WebDAV principal resource")
+	errInvalidPrincipalSearch   = errors.New("invalid DAV:principal-property-search body")
 )
 
 type Options struct {
@@ -77,6 +103,20 @@ type Options struct {
 	QuotaBytes         int64
 	MaxMutationEntries int
 	SyncPageSize       int
+	// Mounts replaces the single davRoot with named shares served below
+	// webRoot. Without mounts webRoot maps directly onto davRoot.
+	Mounts []Mount
+	// Uploads keeps the sessions of resumable Content-Range PUTs; without
+	// it such requests are answered with 501.
+	Uploads *uploadsession.Store
+	// Users and Groups are the principals ACL entries can name; Groups maps
+	// a group to its members. PrincipalRoot is where PrincipalHandler is
+	// served.
+	Users         []string
+	Groups        map[string][]string
+	PrincipalRoot string
+	// ArchiveLimits bounds the ?archive= downloads of collections.
+	ArchiveLimits archive.Limits
 }
 
 type WebdavHandler struct {
@@ -89,6 +129,16 @@ type WebdavHandler struct {
 	quotaBytes         int64
 	maxMutationEntries int
 	syncPageSize       int
+	mounts             *mountTable
+	readOnly           bool
+	syncScope          string
+	mount              string
+	uploads            *uploadsession.Store
+	users              []string
+	groups             map[string][]string
+	memberships        map[string][]string
+	principalRoot      string
+	archiveLimits      archive.Limits
 }
 
 func NewWebdavHandler(
@@ -100,10 +150,11 @@ func NewWebdavHandler(
 		davRoot = "/"
 	}
 	handler := &WebdavHandler{
-		fmgr:         fmgr,
-		davRoot:      path.Clean(davRoot),
-		webRoot:      strings.TrimSuffix(webRoot, "/"),
-		syncPageSize: 1000,
+		fmgr:          fmgr,
+		davRoot:       path.Clean(davRoot),
+		webRoot:       strings.TrimSuffix(webRoot, "/"),
+		syncPageSize:  1000,
+		principalRoot: PrincipalRoot,
 	}
 	if len(options) != 0 {
 		handler.authorizer = options[0].Authorizer
@@ -120,6 +171,16 @@ func NewWebdavHandler(
 		if options[0].SyncPageSize > 0 {
 			handler.syncPageSize = options[0].SyncPageSize
 		}
+		handler.mounts = newMountTable(options[0].Mounts)
+		handler.uploads = options[0].Uploads
+		handler.setPrincipals(options[0])
+		handler.archiveLimits = options[0].ArchiveLimits
+	}
+	if handler.mounts != nil {
+		if err := handler.initMounts(); err != nil {
+			panic(err)
+		}
+		return handler
 	}
 	if err := handler.initWebdav(handler.davRoot); err != nil {
 		panic(err)
@@ -127,6 +188,23 @@ func NewWebdavHandler(
 	return handler
 }
 
+func (h *WebdavHandler) setPrincipals(options Options) {
+	h.users = slices.Sorted(slices.Values(options.Users))
+	h.groups = options.Groups
+	h.memberships = make(map[string][]string)
+	for group, members := range options.Groups {
+		for _, member := range members {
+			h.memberships[member] = append(h.memberships[member], group)
+		}
+	}
+	for _, groups := range h.memberships {
+		slices.Sort(groups)
+	}
+	if options.PrincipalRoot != "" {
+		h.principalRoot = strings.TrimSuffix(options.PrincipalRoot, "/")
+	}
+}
+
 func (h *WebdavHandler) Handler(c *gin.Context) {
 	if err := h.validateRequestPath(c.Request.URL); err != nil {
 		h.writeError(c, http.StatusBadRequest, err, "")
@@ -135,6 +213,41 @@ func (h *WebdavHandler) Handler(c *gin.Context) {
 	if !h.authorize(c) {
 		return
 	}
+	if name, entryPath, ok := h.snapshotRequest(c.Request.URL.Path); ok {
+		h.handleSnapshot(c, name, entryPath)
+		return
+	}
+	if h.mounts == nil {
+		h.serve(c)
+		return
+	}
+	scoped, ok := h.resolveMount(c)
+	if !ok {
+		return
+	}
+	if scoped == nil {
+		h.handleMountIndex(c)
+		return
+	}
+	if scoped.authorize(c) {
+		scoped.serve(c)
+	}
+}
+
+func (h *WebdavHandler) serve(c *gin.Context) {
+	if member, ok := h.versionRequest(c.Request.URL.Path); ok {
+		h.handleVersion(c, member)
+		return
+	}
+	if member, ok := h.thumbnailRequest(c.Request.URL.Path); ok {
+		h.handleThumbnail(c, member)
+		return
+	}
+	if h.syncScope != "" && (c.Request.Method == http.MethodDelete || c.Request.Method == "MOVE") &&
+		h.buildSrcPath(c) == h.davRoot {
+		h.writeMappedError(c, errMountRoot)
+		return
+	}
 	handlers := map[string]func(*gin.Context){
 		http.MethodGet:     h.handleGet,
 		http.MethodPut:     h.handlePut,
@@ -149,6 +262,11 @@ func (h *WebdavHandler) Handler(c *gin.Context) {
 		"LOCK":             h.handleLock,
 		"UNLOCK":           h.handleUnlock,
 		"REPORT":           h.handleReport,
+		"SEARCH":           h.handleSearch,
+		"ACL":              h.handleACL,
+		"BIND":             h.handleBind,
+		"UNBIND":           h.handleUnbind,
+		"REBIND":           h.handleRebind,
 	}
 	handler, supported := handlers[c.Request.Method]
 	if supported {
@@ -169,7 +287,7 @@ func (h *WebdavHandler) authorize(c *gin.Context) bool {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return false
 	}
-	level := h.authorizer.Level(user.Username, authz.WebDAVRead, authz.WebDAVWrite)
+	level := h.level(user.Username)
 	if level == authz.LevelNone {
 		h.writeError(c, http.StatusForbidden, errReadOnly, "")
 		return false
@@ -184,7 +302,8 @@ func (h *WebdavHandler) authorize(c *gin.Context) bool {
 
 func isWebDAVWriteMethod(method string) bool {
 	switch method {
-	case http.MethodPut, http.MethodDelete, "PROPPATCH", "COPY", "MOVE", "MKCOL", "LOCK", "UNLOCK":
+	case http.MethodPut, http.MethodDelete, "PROPPATCH", "COPY", "MOVE", "MKCOL", "LOCK", "UNLOCK", "ACL",
+		"BIND", "UNBIND", "REBIND":
 		return true
 	default:
 		return false
@@ -192,17 +311,35 @@ func isWebDAVWriteMethod(method string) bool {
 }
 
 func (h *WebdavHandler) principal(c *gin.Context) string {
-	user, ok := proxyutil.GetUserInfo(c.Request.Context())
+	return contextPrincipal(c.Request.Context())
+}
+
+func contextPrincipal(ctx context.Context) string {
+	user, ok := proxyutil.GetUserInfo(ctx)
 	if !ok {
 		return ""
 	}
 	return user.Username
 }
 
+// isAdmin reports whether username administers the service, which lets it
+// bypass WebDAV ACLs.
+func (h *WebdavHandler) isAdmin(username string) bool {
+	return username != "" && h.authorizer.Has(username, authz.AdminWrite)
+}
+
+// level is the principal's WebDAV permission capped by a read-only mount.
+func (h *WebdavHandler) level(username string) authz.Level {
+	level := h.authorizer.Level(username, authz.WebDAVRead, authz.WebDAVWrite)
+	if h.readOnly && level == authz.LevelReadWrite {
+		return authz.LevelRead
+	}
+	return level
+}
+
 func (h *WebdavHandler) allowedMethods(c *gin.Context) []string {
 	user, ok := proxyutil.GetUserInfo(c.Request.Context())
-	if ok &&
-		h.authorizer.Level(user.Username, authz.WebDAVRead, authz.WebDAVWrite) == authz.LevelRead {
+	if ok && h.level(user.Username) == authz.LevelRead {
 		return ReadOnlyMethods
 	}
 	return AllowMethods
@@ -211,9 +348,20 @@ func (h *WebdavHandler) allowedMethods(c *gin.Context) []string {
 func (h *WebdavHandler) mutationOptions(
 	c *gin.Context,
 	condition *filemgr.WebDAVCondition,
+) filemgr.WebDAVMutationOptions {
+	return h.writeOptions(h.principal(c), condition)
+}
+
+// writeOptions are the options a write by principal uses below the root
+// of h.
+func (h *WebdavHandler) writeOptions(
+	principal string,
+	condition *filemgr.WebDAVCondition,
 ) filemgr.WebDAVMutationOptions {
 	return filemgr.WebDAVMutationOptions{
-		Principal:  h.principal(c),
+		Principal:  principal,
+		Groups:     h.memberships[principal],
+		Admin:      h.isAdmin(principal),
 		Condition:  condition,
 		MaxEntries: h.maxMutationEntries,
 		QuotaRoot:  h.davRoot,
@@ -252,9 +400,41 @@ func (h *WebdavHandler) externalPath(internal string, collection bool) string {
 }
 
 func (h *WebdavHandler) tryBuildDstPath(c *gin.Context) (string, error) {
-	value := strings.TrimSpace(c.GetHeader("Destination"))
+	destination, err := h.resolveHref(c.Request, c.GetHeader("Destination"))
+	if err != nil {
+		return "", err
+	}
+	if h.syncScope != "" && destination == h.davRoot {
+		return "", errMountRoot
+	}
+	if destination == h.buildSrcPath(c) {
+		return "", errSameResource
+	}
+	return destination, nil
+}
+
+// resolveHref maps a Destination header or a DAV:href naming another
+// resource of this handler to its internal path.
+func (h *WebdavHandler) resolveHref(request *http.Request, value string) (string, error) {
+	uri, err := h.hrefURI(request, value)
+	if err != nil {
+		return "", err
+	}
+	if uri.Path != h.webRoot && !strings.HasPrefix(uri.Path, h.webRoot+"/") {
+		return "", fmt.Errorf("%w: %s", errDestinationWebRoot, uri.Path)
+	}
+	relative := strings.TrimPrefix(uri.Path, h.webRoot)
+	resolved := h.internalPath(relative)
+	if !pathWithinRoot(h.davRoot, resolved) {
+		return "", errDestinationWebRoot
+	}
+	return resolved, nil
+}
+
+func (h *WebdavHandler) hrefURI(request *http.Request, value string) (*url.URL, error) {
+	value = strings.TrimSpace(value)
 	if value == "" {
-		return "", errInvalidDestination
+		return nil, errInvalidDestination
 	}
 	uri, err := url.Parse(value)
 	if err != nil ||
@@ -263,28 +443,15 @@ func (h *WebdavHandler) tryBuildDstPath(c *gin.Context) (string, error) {
 		(uri.Scheme == "") != (uri.Host == "") ||
 		uri.RawQuery != "" ||
 		uri.Fragment != "" {
-		return "", fmt.Errorf("%w: %s", errInvalidDestination, value)
+		return nil, fmt.Errorf("%w: %s", errInvalidDestination, value)
 	}
 	if err := h.validateRequestPath(uri); err != nil {
-		return "", err
-	}
-	if uri.IsAbs() {
-		if !h.absoluteOriginAllowed(uri, c.Request) {
-			return "", errDestinationOrigin
-		}
-	}
-	if uri.Path != h.webRoot && !strings.HasPrefix(uri.Path, h.webRoot+"/") {
-		return "", fmt.Errorf("%w: %s", errDestinationWebRoot, value)
-	}
-	relative := strings.TrimPrefix(uri.Path, h.webRoot)
-	destination := h.internalPath(relative)
-	if !pathWithinRoot(h.davRoot, destination) {
-		return "", errDestinationWebRoot
+		return nil, err
 	}
-	if destination == h.buildSrcPath(c) {
-		return "", errSameResource
+	if uri.IsAbs() && !h.absoluteOriginAllowed(uri, request) {
+		return nil, errDestinationOrigin
 	}
-	return destination, nil
+	return uri, nil
 }
 
 func (h *WebdavHandler) absoluteOriginAllowed(
@@ -380,20 +547,33 @@ func (h *WebdavHandler) writeMappedError(c *gin.Context, err error) {
 	case errors.Is(err, os.ErrExist), errors.Is(err, directory.ErrEntryNotFile):
 		status = http.StatusMethodNotAllowed
 	case errors.Is(err, directory.ErrParentNotFound),
-		errors.Is(err, directory.ErrPathComponentNotDirectory):
+		errors.Is(err, directory.ErrPathComponentNotDirectory),
+		errors.Is(err, uploadsession.ErrBusy),
+		errors.Is(err, uploadsession.ErrOffsetMismatch),
+		errors.Is(err, uploadsession.ErrNotFound),
+		errors.Is(err, filemgr.ErrWebDAVTarget):
 		status = http.StatusConflict
+	case errors.Is(err, uploadsession.ErrTooLarge):
+		status = http.StatusRequestEntityTooLarge
 	case errors.Is(err, directory.ErrDestinationExists),
 		errors.Is(err, filemgr.ErrWebDAVPrecondition):
 		status = http.StatusPreconditionFailed
 	case errors.Is(err, filemgr.ErrWebDAVLocked), errors.Is(err, filemgr.ErrWebDAVLockToken):
 		status = http.StatusLocked
 		precondition = "lock-token-submitted"
-	case errors.Is(err, filemgr.ErrWebDAVQuota), errors.Is(err, filemgr.ErrWebDAVTooManyItems):
+	case errors.Is(err, filemgr.ErrWebDAVQuota), errors.Is(err, filemgr.ErrWebDAVTooManyItems),
+		errors.Is(err, filemgr.ErrQuotaExceeded):
 		status = http.StatusInsufficientStorage
 	case errors.Is(err, filemgr.ErrWebDAVSyncToken):
 		status = http.StatusForbidden
 		precondition = "valid-sync-token"
-	case errors.Is(err, directory.ErrDestinationInsideSource), errors.Is(err, errSameResource):
+	case errors.Is(err, filemgr.ErrWebDAVForbidden):
+		status = http.StatusForbidden
+		precondition = "need-privileges"
+	case errors.Is(err, directory.ErrDestinationInsideSource),
+		errors.Is(err, filemgr.ErrWebDAVTargetForbidden),
+		errors.Is(err, errSameResource),
+		errors.Is(err, errMountRoot):
 		status = http.StatusForbidden
 	case errors.Is(err, errDestinationOrigin):
 		status = http.StatusBadGateway
@@ -403,7 +583,12 @@ func (h *WebdavHandler) writeMappedError(c *gin.Context, err error) {
 		errors.Is(err, errInvalidDepth),
 		errors.Is(err, errInvalidOverwrite),
 		errors.Is(err, errInvalidIfHeader),
-		errors.Is(err, errInvalidCondition):
+		errors.Is(err, errInvalidCondition),
+		errors.Is(err, errInvalidContentRange),
+		errors.Is(err, errShortRangeBody),
+		errors.Is(err, uploadsession.ErrInvalidRequest),
+		errors.Is(err, filemgr.ErrWebDAVSearchQuery),
+		errors.Is(err, filemgr.ErrWebDAVACL):
 		status = http.StatusBadRequest
 	}
 	h.writeError(c, status, err, precondition)
@@ -721,8 +906,10 @@ func logCloseError(ctx context.Context, closer io.Closer, message string) {
 	}
 }
 
-func parseSyncToken(value string) (int64, error) {
-	const prefix = "urn:tgfile:webdav-sync:"
+// parseSyncToken accepts only tokens issued for the same mount, so a token
+// from one share cannot be replayed against another.
+func (h *WebdavHandler) parseSyncToken(value string) (int64, error) {
+	prefix := h.syncTokenPrefix()
 	if value == "" {
 		return 0, nil
 	}
@@ -736,6 +923,13 @@ func parseSyncToken(value string) (int64, error) {
 	return revision, nil
 }
 
-func formatSyncToken(revision int64) string {
-	return fmt.Sprintf("urn:tgfile:webdav-sync:%d", revision)
+func (h *WebdavHandler) formatSyncToken(revision int64) string {
+	return h.syncTokenPrefix() + strconv.FormatInt(revision, 10)
+}
+
+func (h *WebdavHandler) syncTokenPrefix() string {
+	if h.syncScope == "" {
+		return "urn:tgfile:webdav-sync:"
+	}
+	return "urn:tgfile:webdav-sync:" + h.syncScope + ":"
 }
@@ -16,8 +16,17 @@ func DetermineMimeType(filename string) string {
 	return mimeType
 }
 
+// ContentType returns the content type stored for finfo, falling back to the
+// extension for mappings created before content types were stored.
+func ContentType(finfo *entity.FileLinkMeta) string {
+	if finfo.ContentType != "" {
+		return finfo.ContentType
+	}
+	return DetermineMimeType(finfo.FileName)
+}
+
 func SetDefaultDownloadHeader(c *gin.Context, finfo *entity.FileLinkMeta) {
-	c.Writer.Header().Set("Content-Type", DetermineMimeType(finfo.FileName))
+	c.Writer.Header().Set("Content-Type", ContentType(finfo))
 	c.Writer.Header().Set("Cache-Control", "public, max-age=604800") // 默认可以缓存7d
 	if finfo.FileId != 0 {
 		c.Writer.Header().Set("ETag", fmt.Sprintf("W/\"%d\"", finfo.FileId))
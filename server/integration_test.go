@@ -31,6 +31,7 @@ import (
 	"github.com/xxxsen/tgfile/db"
 	"github.com/xxxsen/tgfile/filemgr"
 	"github.com/xxxsen/tgfile/s3checksum"
+	"github.com/xxxsen/tgfile/s3session"
 	"github.com/xxxsen/tgfile/server"
 )
 
@@ -41,6 +42,25 @@ type integrationEnvironment struct {
 }
 
 func newIntegrationEnvironment(t *testing.T) *integrationEnvironment {
+	t.Helper()
+	return newIntegrationEnvironmentWith(t, nil)
+}
+
+// newIntegrationEnvironmentWith lets a test add server options that need the
+// environment's database or file manager.
+func newIntegrationEnvironmentWith(
+	t *testing.T,
+	extra func(database.IDatabase, filemgr.IFileManager) []server.Option,
+) *integrationEnvironment {
+	t.Helper()
+	return newIntegrationEnvironmentWithStorage(t, nil, extra)
+}
+
+func newIntegrationEnvironmentWithStorage(
+	t *testing.T,
+	storage []filemgr.Option,
+	extra func(database.IDatabase, filemgr.IFileManager) []server.Option,
+) *integrationEnvironment {
 	t.Helper()
 	logger.Init("", "debug", 0, 0, 0, true)
 	database, err := db.Open(filepath.Join(t.TempDir(), "data.db"))
@@ -56,9 +76,8 @@ func newIntegrationEnvironment(t *testing.T) *integrationEnvironment {
 	})
 	require.NoError(t, err)
 	registerIntegrationCacheCleanup(t, cache)
-	manager := filemgr.NewFileManager(database, block, cache)
-	handler, err := server.New(
-		"127.0.0.1:0",
+	manager := filemgr.NewFileManager(database, block, cache, storage...)
+	options := []server.Option{
 		server.WithS3(server.S3Options{
 			Enabled: true,
 			Buckets: []server.S3BucketOptions{{
@@ -68,6 +87,7 @@ func newIntegrationEnvironment(t *testing.T) *integrationEnvironment {
 				Name: "private-data",
 				ACL:  server.BucketACLPrivate,
 			}},
+			BaseDomain: "s3.example.test",
 		}),
 		server.WithUser(map[string]string{
 			"access":   "secret",
@@ -81,7 +101,12 @@ func newIntegrationEnvironment(t *testing.T) *integrationEnvironment {
 		})),
 		server.WithEnableWebdav(true, "/"),
 		server.WithFileManager(manager),
-	)
+		server.WithS3Sessions(s3session.New(database)),
+	}
+	if extra != nil {
+		options = append(options, extra(database, manager)...)
+	}
+	handler, err := server.New("127.0.0.1:0", options...)
 	require.NoError(t, err)
 	testServer := httptest.NewServer(handler)
 	t.Cleanup(testServer.Close)
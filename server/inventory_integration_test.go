@@ -0,0 +1,272 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/inventory"
+	"github.com/xxxsen/tgfile/server"
+)
+
+func newInventoryEnvironment(t *testing.T) *integrationEnvironment {
+	t.Helper()
+	var worker *inventory.Manager
+	environment := newIntegrationEnvironmentWith(t, func(
+		db database.IDatabase,
+		manager filemgr.IFileManager,
+	) []server.Option {
+		worker = inventory.New(db, manager, inventory.Options{
+			Buckets:      []string{"hackmd", "private-data"},
+			WorkDir:      t.TempDir(),
+			PollInterval: 20 * time.Millisecond,
+		})
+		return []server.Option{server.WithInventory(worker)}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- worker.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		require.ErrorIs(t, <-done, context.Canceled)
+	})
+	return environment
+}
+
+func inventoryConfigurationBody(id, prefix, format string, fields ...string) string {
+	var builder strings.Builder
+	builder.WriteString(`<InventoryConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+	builder.WriteString(`<Id>` + id + `</Id><IsEnabled>true</IsEnabled>`)
+	builder.WriteString(`<Filter><Prefix>` + prefix + `</Prefix></Filter>`)
+	builder.WriteString(`<Destination><S3BucketDestination><Bucket>arn:aws:s3:::private-data</Bucket>`)
+	builder.WriteString(`<Format>` + format + `</Format><Prefix>reports</Prefix></S3BucketDestination></Destination>`)
+	builder.WriteString(`<Schedule><Frequency>Daily</Frequency></Schedule>`)
+	builder.WriteString(`<IncludedObjectVersions>Current</IncludedObjectVersions><OptionalFields>`)
+	for _, field := range fields {
+		builder.WriteString(`<Field>` + field + `</Field>`)
+	}
+	builder.WriteString(`</OptionalFields></InventoryConfiguration>`)
+	return builder.String()
+}
+
+func putInventoryConfiguration(t *testing.T, client *http.Client, target, body string) (int, string) {
+	t.Helper()
+	response, err := client.Do(authenticatedRequest(t, http.MethodPut, target, strings.NewReader(body)))
+	require.NoError(t, err)
+	return response.StatusCode, string(readResponse(t, response))
+}
+
+type inventoryManifest struct {
+	SourceBucket string `json:"sourceBucket"`
+	FileFormat   string `json:"fileFormat"`
+	FileSchema   string `json:"fileSchema"`
+	Files        []struct {
+		Key         string `json:"key"`
+		Size        int64  `json:"size"`
+		MD5Checksum string `json:"MD5checksum"`
+	} `json:"files"`
+}
+
+// waitInventoryReport waits for the manifest.checksum of the first report of
+// a configuration and returns the manifest with its data files.
+func waitInventoryReport(t *testing.T, client *http.Client, baseURL, id string) (*inventoryManifest, [][]byte) {
+	t.Helper()
+	prefix := "reports/hackmd/" + id + "/"
+	var checksumKey string
+	require.Eventually(t, func() bool {
+		response, err := client.Do(authenticatedRequest(
+			t, http.MethodGet, baseURL+"/private-data?list-type=2&prefix="+prefix, nil,
+		))
+		require.NoError(t, err)
+		var result struct {
+			Keys []string `xml:"Contents>Key"`
+		}
+		require.NoError(t, xml.Unmarshal(readResponse(t, response), &result))
+		for _, key := range result.Keys {
+			if strings.HasSuffix(key, "/manifest.checksum") {
+				checksumKey = key
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 20*time.Millisecond)
+	manifestKey := strings.TrimSuffix(checksumKey, ".checksum") + ".json"
+	raw := getPrivateObject(t, client, baseURL, manifestKey)
+	sum := md5.Sum(raw)
+	require.Equal(t, hex.EncodeToString(sum[:]), string(getPrivateObject(t, client, baseURL, checksumKey)))
+	var manifest inventoryManifest
+	require.NoError(t, json.Unmarshal(raw, &manifest))
+	data := make([][]byte, 0, len(manifest.Files))
+	for _, file := range manifest.Files {
+		compressed := getPrivateObject(t, client, baseURL, file.Key)
+		require.Len(t, compressed, int(file.Size))
+		fileSum := md5.Sum(compressed)
+		require.Equal(t, hex.EncodeToString(fileSum[:]), file.MD5Checksum)
+		reader, err := gzip.NewReader(bytes.NewReader(compressed))
+		require.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		data = append(data, content)
+	}
+	return &manifest, data
+}
+
+func getPrivateObject(t *testing.T, client *http.Client, baseURL, key string) []byte {
+	t.Helper()
+	response, err := client.Do(authenticatedRequest(t, http.MethodGet, baseURL+"/private-data/"+key, nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	return readResponse(t, response)
+}
+
+func TestS3InventoryConfigurationLifecycle(t *testing.T) {
+	environment := newInventoryEnvironment(t)
+	client := environment.server.Client()
+	bucketURL := environment.server.URL + "/hackmd"
+
+	status, body := putInventoryConfiguration(t, client, bucketURL+"?inventory&id=daily",
+		inventoryConfigurationBody("daily", "docs/", "CSV", "Size", "ETag"))
+	require.Equal(t, http.StatusOK, status, body)
+	status, body = putInventoryConfiguration(t, client, bucketURL+"?inventory&id=other",
+		inventoryConfigurationBody("daily", "", "CSV"))
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Contains(t, body, "InvalidArgument")
+	status, body = putInventoryConfiguration(t, client, bucketURL+"?inventory&id=orc",
+		inventoryConfigurationBody("orc", "", "ORC"))
+	require.Equal(t, http.StatusBadRequest, status)
+	require.Contains(t, body, "InvalidArgument")
+	request := authenticatedRequest(t, http.MethodPut, bucketURL+"?inventory&id=reader",
+		strings.NewReader(inventoryConfigurationBody("reader", "", "CSV")))
+	request.SetBasicAuth("reader", "reader-secret")
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	require.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	response, err = client.Do(authenticatedRequest(t, http.MethodGet, bucketURL+"?inventory&id=daily", nil))
+	require.NoError(t, err)
+	configuration := string(readResponse(t, response))
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Contains(t, configuration, "<Bucket>arn:aws:s3:::private-data</Bucket>")
+	require.Contains(t, configuration, "<Field>Size</Field><Field>ETag</Field>")
+	response, err = client.Do(authenticatedRequest(t, http.MethodGet, bucketURL+"?inventory", nil))
+	require.NoError(t, err)
+	listing := string(readResponse(t, response))
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Contains(t, listing, "<Id>daily</Id>")
+	require.Contains(t, listing, "<IsTruncated>false</IsTruncated>")
+
+	response, err = client.Do(authenticatedRequest(t, http.MethodDelete, bucketURL+"?inventory&id=daily", nil))
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+	response, err = client.Do(authenticatedRequest(t, http.MethodGet, bucketURL+"?inventory&id=daily", nil))
+	require.NoError(t, err)
+	require.Contains(t, string(readResponse(t, response)), "NoSuchConfiguration")
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+func TestS3InventoryConfigurationsRespectSessionScope(t *testing.T) {
+	environment := newInventoryEnvironment(t)
+	client := environment.server.Client()
+	bucketURL := environment.server.URL + "/hackmd"
+	status, body := putInventoryConfiguration(t, client, bucketURL+"?inventory&id=all",
+		inventoryConfigurationBody("all", "", "CSV"))
+	require.Equal(t, http.StatusOK, status, body)
+	credential := issueSessionCredential(t, client, environment.server.URL, "access", "secret",
+		`{"bucket":"hackmd","prefix":"ci/"}`)
+	scoped := func(method, query string) (int, string) {
+		response, err := client.Do(sessionSignedRequest(t, credential, method, bucketURL+query, nil))
+		require.NoError(t, err)
+		return response.StatusCode, string(readResponse(t, response))
+	}
+
+	status, _ = scoped(http.MethodGet, "?inventory&id=all&prefix=ci/")
+	require.Equal(t, http.StatusForbidden, status)
+	status, listing := scoped(http.MethodGet, "?inventory&prefix=ci/")
+	require.Equal(t, http.StatusOK, status)
+	require.NotContains(t, listing, "<Id>all</Id>")
+	status, _ = scoped(http.MethodDelete, "?inventory&id=all&prefix=ci/")
+	require.Equal(t, http.StatusForbidden, status)
+
+	response, err := client.Do(authenticatedRequest(t, http.MethodGet, bucketURL+"?inventory&id=all", nil))
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+}
+
+func TestS3InventoryWritesCSVReport(t *testing.T) {
+	environment := newInventoryEnvironment(t)
+	client := environment.server.Client()
+	bucketURL := environment.server.URL + "/hackmd"
+	for key, content := range map[string]string{
+		"docs/a.txt":         "alpha",
+		"docs/sub/b c.txt":   "bravo!",
+		"docs-archive/c.txt": "charlie",
+		"other.txt":          "delta",
+	} {
+		require.Equal(t, http.StatusOK, putWithStorageClass(t, client, bucketURL+"/"+key, "", []byte(content)))
+	}
+
+	status, body := putInventoryConfiguration(t, client, bucketURL+"?inventory&id=daily",
+		inventoryConfigurationBody("daily", "docs/", "CSV", "ETag", "Size", "StorageClass", "IsMultipartUploaded"))
+	require.Equal(t, http.StatusOK, status, body)
+	manifest, data := waitInventoryReport(t, client, environment.server.URL, "daily")
+	require.Equal(t, "hackmd", manifest.SourceBucket)
+	require.Equal(t, "CSV", manifest.FileFormat)
+	require.Equal(t, "Bucket, Key, Size, ETag, StorageClass, IsMultipartUploaded", manifest.FileSchema)
+	require.Len(t, data, 1)
+	rows, err := csv.NewReader(bytes.NewReader(data[0])).ReadAll()
+	require.NoError(t, err)
+	slices.SortFunc(rows, func(left, right []string) int { return strings.Compare(left[1], right[1]) })
+	alpha := md5.Sum([]byte("alpha"))
+	bravo := md5.Sum([]byte("bravo!"))
+	require.Equal(t, [][]string{
+		{"hackmd", "docs%2Fa.txt", "5", hex.EncodeToString(alpha[:]), "STANDARD", "false"},
+		{"hackmd", "docs%2Fsub%2Fb+c.txt", "6", hex.EncodeToString(bravo[:]), "STANDARD", "false"},
+	}, rows)
+}
+
+func TestS3InventoryWritesNDJSONReport(t *testing.T) {
+	environment := newInventoryEnvironment(t)
+	client := environment.server.Client()
+	bucketURL := environment.server.URL + "/hackmd"
+	require.Equal(t, http.StatusOK, putWithStorageClass(t, client, bucketURL+"/one.txt", "", []byte("one")))
+	require.Equal(t, http.StatusOK, putWithStorageClass(t, client, bucketURL+"/dir/two.txt", "", []byte("two")))
+
+	status, body := putInventoryConfiguration(t, client, bucketURL+"?inventory&id=json",
+		inventoryConfigurationBody("json", "", "NDJSON", "Size", "ChecksumAlgorithm", "Checksum"))
+	require.Equal(t, http.StatusOK, status, body)
+	manifest, data := waitInventoryReport(t, client, environment.server.URL, "json")
+	require.Equal(t, "NDJSON", manifest.FileFormat)
+	require.Len(t, data, 1)
+	records := make(map[string]map[string]any)
+	scanner := bufio.NewScanner(bytes.NewReader(data[0]))
+	for scanner.Scan() {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records[record["Key"].(string)] = record
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, records, 2)
+	require.Equal(t, "hackmd", records["dir/two.txt"]["Bucket"])
+	require.InEpsilon(t, 3, records["dir/two.txt"]["Size"], 0)
+	require.Equal(t, "SHA256", records["one.txt"]["ChecksumAlgorithm"])
+	require.NotEmpty(t, records["one.txt"]["Checksum"])
+}
@@ -13,7 +13,10 @@ type UploadFileRequest struct {
 }
 
 type UploadFileResponse struct {
-	Key string `json:"key"`
+	Key      string `json:"key"`
+	FileSize int64  `json:"file_size"`
+	Md5      string `json:"md5"`
+	Sha256   string `json:"sha256"`
 }
 
 type GetFileInfoRequest struct {
@@ -28,8 +31,33 @@ type FileInfoItem struct {
 	Mtime         int64  `json:"mtime"`
 	FilePartCount int32  `json:"file_part_count"`
 	Md5           string `json:"md5"`
+	ContentType   string `json:"content_type"`
 }
 
 type GetFileInfoResponse struct {
 	Item *FileInfoItem `json:"item"`
 }
+
+type RevokeFileKeyRequest struct {
+	Key string `json:"key" form:"key" binding:"required"`
+}
+
+type DeleteFileLinkRequest struct {
+	Link string `json:"link" form:"link" binding:"required"`
+}
+
+type FileListItem struct {
+	Link        string `json:"link"`
+	Name        string `json:"name"`
+	Owner       string `json:"owner"`
+	Revoked     bool   `json:"revoked"`
+	FileSize    int64  `json:"file_size"`
+	ContentType string `json:"content_type"`
+	Ctime       int64  `json:"ctime"`
+	Mtime       int64  `json:"mtime"`
+}
+
+type ListFileResponse struct {
+	Items      []*FileListItem `json:"items"`
+	NextCursor string          `json:"next_cursor"`
+}
@@ -0,0 +1,199 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/backupfmt"
+	"github.com/xxxsen/tgfile/backupmgr"
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/presign"
+	"github.com/xxxsen/tgfile/server"
+)
+
+func presignForTest(t *testing.T, baseURL string, request presign.Request) *presign.Result {
+	t.Helper()
+	request.Endpoint = baseURL
+	request.Bucket = "private-data"
+	if request.AccessKey == "" {
+		request.AccessKey, request.SecretKey = "access", "secret"
+	}
+	if request.Expires == 0 {
+		request.Expires = time.Minute
+	}
+	result, err := presign.Sign(t.Context(), &request)
+	require.NoError(t, err)
+	return result
+}
+
+func doPresigned(
+	t *testing.T,
+	client *http.Client,
+	result *presign.Result,
+	body []byte,
+	header http.Header,
+) (int, []byte, http.Header) {
+	t.Helper()
+	request, err := http.NewRequestWithContext(t.Context(), result.Method, result.URL, bytes.NewReader(body))
+	require.NoError(t, err)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	return response.StatusCode, readResponse(t, response), response.Header
+}
+
+func TestPresignedObjectURLs(t *testing.T) {
+	environment := newIntegrationEnvironment(t)
+	client := environment.server.Client()
+	baseURL := environment.server.URL
+
+	put := presignForTest(t, baseURL, presign.Request{
+		Method: http.MethodPut, Key: "shared/q 1.txt", ContentType: "text/plain",
+	})
+	require.Equal(t, map[string]string{"Content-Type": "text/plain"}, put.Headers)
+	status, body, _ := doPresigned(t, client, put, []byte("quarter"), http.Header{"Content-Type": {"text/html"}})
+	require.Equal(t, http.StatusForbidden, status, string(body))
+	status, body, _ = doPresigned(t, client, put, []byte("quarter"), http.Header{"Content-Type": {"text/plain"}})
+	require.Equal(t, http.StatusOK, status, string(body))
+	require.Equal(t, []byte("quarter"), getPrivateObject(t, client, baseURL, "shared/q%201.txt"))
+
+	get := presignForTest(t, baseURL, presign.Request{
+		Method: http.MethodGet, Key: "shared/q 1.txt",
+		Overrides: map[string]string{
+			"response-content-type":        "application/octet-stream",
+			"response-content-disposition": `attachment; filename="q 1.txt"`,
+		},
+	})
+	status, body, header := doPresigned(t, client, get, nil, nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	require.Equal(t, []byte("quarter"), body)
+	require.Equal(t, "application/octet-stream", header.Get("Content-Type"))
+	require.Equal(t, `attachment; filename="q 1.txt"`, header.Get("Content-Disposition"))
+	tampered := *get
+	tampered.URL = strings.Replace(get.URL, "X-Amz-Expires=60", "X-Amz-Expires=3600", 1)
+	status, _, _ = doPresigned(t, client, &tampered, nil, nil)
+	require.Equal(t, http.StatusForbidden, status)
+
+	expired := presignForTest(t, baseURL, presign.Request{
+		Method: http.MethodGet, Key: "shared/q 1.txt", Now: time.Now().Add(-2 * time.Minute),
+	})
+	status, body, _ = doPresigned(t, client, expired, nil, nil)
+	require.Equal(t, http.StatusForbidden, status)
+	require.Contains(t, string(body), "AccessDenied")
+
+	readerPut := presignForTest(t, baseURL, presign.Request{
+		Method: http.MethodPut, Key: "shared/reader.txt", AccessKey: "reader", SecretKey: "reader-secret",
+	})
+	status, _, _ = doPresigned(t, client, readerPut, []byte("denied"), nil)
+	require.Equal(t, http.StatusForbidden, status)
+}
+
+func TestPresignedUploadPart(t *testing.T) {
+	environment := newIntegrationEnvironment(t)
+	client := environment.server.Client()
+	objectURL := environment.server.URL + "/private-data/multipart/presigned.bin"
+	uploadID := initiateCacheMultipart(t, client, objectURL)
+
+	part := presignForTest(t, environment.server.URL, presign.Request{
+		Method: http.MethodPut, Key: "multipart/presigned.bin", PartNumber: 1, UploadID: uploadID,
+	})
+	status, body, header := doPresigned(t, client, part, []byte("presigned part"), nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	etag := header.Get("ETag")
+	require.NotEmpty(t, etag)
+	complete := "<CompleteMultipartUpload><Part><PartNumber>1</PartNumber><ETag>" + etag +
+		"</ETag></Part></CompleteMultipartUpload>"
+	response, err := client.Do(authenticatedRequest(
+		t, http.MethodPost, objectURL+"?uploadId="+url.QueryEscape(uploadID), strings.NewReader(complete),
+	))
+	require.NoError(t, err)
+	var result struct {
+		ETag string `xml:"ETag"`
+	}
+	require.NoError(t, xml.Unmarshal(readResponse(t, response), &result))
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	assertObjectContent(t, client, objectURL, []byte("presigned part"))
+}
+
+func TestAdminPresignObject(t *testing.T) {
+	environment := newIntegrationEnvironmentWith(t, func(
+		db database.IDatabase,
+		manager filemgr.IFileManager,
+	) []server.Option {
+		backups, err := backupmgr.New(db, manager, backupmgr.Options{
+			WorkDir:           filepath.Join(t.TempDir(), "backup-work"),
+			Limits:            backupfmt.DefaultLimits(),
+			SchemaVersion:     13,
+			MaxPartSize:       manager.BackupMaxPartSize(),
+			ArtifactRetention: time.Hour,
+			JobRetention:      24 * time.Hour,
+		})
+		require.NoError(t, err)
+		return []server.Option{
+			server.WithBackup(server.BackupOptions{Enabled: false}, backups),
+			server.WithAdmin(server.AdminOptions{
+				Enabled:         true,
+				ExternalOrigins: []string{adminTestOrigin},
+				SessionIdle:     30 * time.Minute, SessionMaximum: 12 * time.Hour,
+				MaxUploadSize: 1024, MaxPathBytes: 1024, MaxMutationEntries: 1000,
+			}),
+		}
+	})
+	client := environment.server.Client()
+	baseURL := environment.server.URL
+	status := putWithStorageClass(t, client, baseURL+"/private-data/docs/a.txt", "", []byte("alpha"))
+	require.Equal(t, http.StatusOK, status)
+
+	adminClient := adminHTTPClient(t)
+	reader := loginAdmin(t, adminClient, baseURL, "reader", "reader-secret")
+	presignAdmin := func(body string) *http.Response {
+		return doAdminRequest(t, adminClient, http.MethodPost, baseURL+"/_admin/api/v1/presign",
+			strings.NewReader(body), reader, map[string]string{"Content-Type": "application/json"})
+	}
+	response := presignAdmin(`{"path":"/private-data/docs/a.txt","expires_seconds":300}`)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	result := decodeAdminData[presign.Result](t, response)
+	require.NoError(t, response.Body.Close())
+	require.True(t, strings.HasPrefix(result.URL, adminTestOrigin+"/private-data/docs/a.txt?"), result.URL)
+
+	// The link is signed for the external origin, which the test server
+	// answers on a different port.
+	request, err := http.NewRequestWithContext(
+		t.Context(), http.MethodGet, baseURL+strings.TrimPrefix(result.URL, adminTestOrigin), nil,
+	)
+	require.NoError(t, err)
+	request.Host = strings.TrimPrefix(adminTestOrigin, "http://")
+	response, err = client.Do(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, []byte("alpha"), readResponse(t, response))
+
+	for body, status := range map[string]int{
+		`{"path":"/private-data/docs/a.txt","method":"PUT"}`:         http.StatusForbidden,
+		`{"path":"/private-data/docs"}`:                              http.StatusConflict,
+		`{"path":"/elsewhere/a.txt"}`:                                http.StatusBadRequest,
+		`{"path":"/private-data/docs/a.txt","expires_seconds":-1}`:   http.StatusBadRequest,
+		`{"path":"/private-data/docs/a.txt","response":{"x":"y"}}`:   http.StatusBadRequest,
+		`{"path":"/private-data/docs/missing.txt","method":"GET"}`:   http.StatusNotFound,
+		`{"path":"/private-data/docs/a.txt","content_type":"a/b"}`:   http.StatusBadRequest,
+		`{"path":"/private-data/docs/a.txt","method":"DELETE"}`:      http.StatusBadRequest,
+		`{"path":"/private-data/docs/a.txt","expires_seconds":1e10}`: http.StatusBadRequest,
+	} {
+		response := presignAdmin(body)
+		_, _ = io.Copy(io.Discard, response.Body)
+		require.NoError(t, response.Body.Close())
+		require.Equal(t, status, response.StatusCode, body)
+	}
+}
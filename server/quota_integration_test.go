@@ -0,0 +1,74 @@
+package server_test
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+func quotaMetrics(t *testing.T, environment *integrationEnvironment, username, password string) (int, string) {
+	t.Helper()
+	request := authenticatedRequest(t, http.MethodGet, environment.server.URL+"/quota/v1/metrics", nil)
+	request.SetBasicAuth(username, password)
+	response, err := environment.server.Client().Do(request)
+	require.NoError(t, err)
+	return response.StatusCode, string(readResponse(t, response))
+}
+
+func TestQuotasApplyToEveryProtocolOfAPrincipal(t *testing.T) {
+	environment := newIntegrationEnvironmentWithStorage(t, []filemgr.Option{filemgr.WithQuotas(filemgr.QuotaPolicy{
+		Principals: map[string]filemgr.QuotaLimits{"access": {HardBytes: 10, SoftBytes: 6}},
+	})}, nil)
+	client := environment.server.Client()
+
+	response, err := client.Do(authenticatedRequest(
+		t, http.MethodPut, environment.server.URL+"/hackmd/quota/a.bin", strings.NewReader("12345678"),
+	))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	_ = readResponse(t, response)
+	response, err = client.Do(authenticatedRequest(
+		t, http.MethodPut, environment.server.URL+"/hackmd/quota/b.bin", strings.NewReader("1234"),
+	))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, response.StatusCode)
+	require.Contains(t, string(readResponse(t, response)), "QuotaExceeded")
+
+	requireWebDAVStatus(t, doWebDAVRequest(t, client, "access", "secret", "MKCOL",
+		environment.server.URL+"/webdav/quota", nil, nil,
+	), http.StatusCreated)
+	requireWebDAVStatus(t, doWebDAVRequest(t, client, "access", "secret", http.MethodPut,
+		environment.server.URL+"/webdav/quota/c.bin", strings.NewReader("123"), nil,
+	), http.StatusInsufficientStorage)
+	raw := requireWebDAVStatus(t, doWebDAVRequest(t, client, "access", "secret", "PROPFIND",
+		environment.server.URL+"/webdav/quota", nil, map[string]string{"Depth": "0"},
+	), http.StatusMultiStatus)
+	var properties struct {
+		Used      string `xml:"response>propstat>prop>quota-used-bytes"`
+		Available string `xml:"response>propstat>prop>quota-available-bytes"`
+	}
+	require.NoError(t, xml.Unmarshal(raw, &properties))
+	require.Equal(t, "8", properties.Used)
+	require.Equal(t, "2", properties.Available)
+
+	// Other principals are not limited, and their uploads are theirs.
+	uploadDirectFileAs(t, environment, "fileonly", "file-secret", "free.txt", []byte("0123456789"))
+	usage, err := environment.manager.QuotaUsage(t.Context(), "fileonly")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), usage.Bytes)
+
+	status, _ := quotaMetrics(t, environment, "fileonly", "file-secret")
+	require.Equal(t, http.StatusForbidden, status)
+	status, metrics := quotaMetrics(t, environment, "reader", "reader-secret")
+	require.Equal(t, http.StatusOK, status)
+	require.Contains(t, metrics, `tgfile_quota_used_bytes{principal="access"} 8`+"\n")
+	require.Contains(t, metrics, `tgfile_quota_limit_bytes{principal="access",kind="hard"} 10`+"\n")
+	require.Contains(t, metrics, `tgfile_quota_exceeded{principal="access",kind="soft"} 1`+"\n")
+	require.Contains(t, metrics, `tgfile_quota_used_bytes{principal="fileonly"} 10`+"\n")
+	require.NotContains(t, metrics, `tgfile_quota_limit_bytes{principal="fileonly"`)
+}
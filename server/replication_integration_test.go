@@ -0,0 +1,234 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/replication"
+	"github.com/xxxsen/tgfile/s3checksum"
+	"github.com/xxxsen/tgfile/server"
+)
+
+const replicationRulesXML = `<ReplicationConfiguration>
+  <Rule>
+    <ID>dr</ID>
+    <Status>Enabled</Status>
+    <Filter><Prefix>docs/</Prefix></Filter>
+    <Destination><Bucket>arn:aws:s3:::hackmd</Bucket><Account>dr</Account></Destination>
+    <DeleteMarkerReplication><Status>Enabled</Status></DeleteMarkerReplication>
+  </Rule>
+  <Rule>
+    <ID>misconfigured</ID>
+    <Status>Enabled</Status>
+    <Filter><Prefix>broken/</Prefix></Filter>
+    <Destination><Bucket>arn:aws:s3:::hackmd</Bucket><Account>broken</Account></Destination>
+  </Rule>
+</ReplicationConfiguration>`
+
+func newReplicationSource(t *testing.T, targetURL string) *integrationEnvironment {
+	t.Helper()
+	var replicator *replication.Manager
+	source := newIntegrationEnvironmentWithStorage(t, []filemgr.Option{
+		filemgr.WithChangeObserver(replication.EnqueueChange),
+	}, func(
+		database database.IDatabase,
+		manager filemgr.IFileManager,
+	) []server.Option {
+		var err error
+		replicator, err = replication.New(t.Context(), database, manager, replication.Options{
+			Targets: map[string]replication.Target{
+				"dr": {
+					Endpoint:  targetURL,
+					Region:    "us-east-1",
+					AccessKey: "access",
+					SecretKey: "secret",
+				},
+				"broken": {
+					Endpoint:  targetURL,
+					Region:    "us-east-1",
+					AccessKey: "access",
+					SecretKey: "not-the-secret",
+				},
+			},
+			PollInterval: 20 * time.Millisecond,
+			MaxAttempts:  2,
+			MinBackoff:   20 * time.Millisecond,
+			MaxBackoff:   40 * time.Millisecond,
+		})
+		require.NoError(t, err)
+		return []server.Option{server.WithReplication(replicator)}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- replicator.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		require.ErrorIs(t, <-done, context.Canceled)
+	})
+	request := authenticatedRequest(
+		t,
+		http.MethodPut,
+		source.server.URL+"/private-data?replication",
+		strings.NewReader(replicationRulesXML),
+	)
+	require.Equal(t, http.StatusOK, doStatus(t, source.server.Client(), request))
+	return source
+}
+
+func replicationStatus(t *testing.T, client *http.Client, objectURL string) (int, string) {
+	t.Helper()
+	response, err := client.Do(authenticatedRequest(t, http.MethodHead, objectURL, nil))
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	return response.StatusCode, response.Header.Get("x-amz-replication-status")
+}
+
+func TestS3ReplicationToSecondInstance(t *testing.T) {
+	target := newIntegrationEnvironment(t)
+	source := newReplicationSource(t, target.server.URL)
+	client := source.server.Client()
+	content := []byte("replicated across regions")
+	checksum, err := s3checksum.NewHash(s3checksum.AlgorithmCRC32)
+	require.NoError(t, err)
+	_, _ = checksum.Write(content)
+	sourceURL := source.server.URL + "/private-data/docs/q3%20report+final.txt"
+	targetURL := target.server.URL + "/hackmd/docs/q3%20report+final.txt"
+
+	request := authenticatedRequest(t, http.MethodPut, sourceURL, bytes.NewReader(content))
+	request.Header.Set("Content-Type", "text/plain")
+	request.Header.Set("Cache-Control", "max-age=60")
+	request.Header.Set("x-amz-meta-team", "storage")
+	request.Header.Set("x-amz-checksum-crc32", s3checksum.SumBase64(checksum))
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	sourceETag := response.Header.Get("ETag")
+
+	require.Eventually(t, func() bool {
+		_, status := replicationStatus(t, client, sourceURL)
+		return status == string(replication.StatusCompleted)
+	}, 5*time.Second, 20*time.Millisecond)
+
+	request = authenticatedRequest(t, http.MethodGet, targetURL, nil)
+	request.Header.Set("x-amz-checksum-mode", "ENABLED")
+	response, err = target.server.Client().Do(request)
+	require.NoError(t, err)
+	require.Equal(t, content, readResponse(t, response))
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, sourceETag, response.Header.Get("ETag"))
+	require.Equal(t, "text/plain", response.Header.Get("Content-Type"))
+	require.Equal(t, "max-age=60", response.Header.Get("Cache-Control"))
+	require.Equal(t, "storage", response.Header.Get("x-amz-meta-team"))
+	require.Equal(t, s3checksum.SumBase64(checksum), response.Header.Get("x-amz-checksum-crc32"))
+	require.Empty(t, response.Header.Get("x-amz-replication-status"))
+
+	outsideURL := source.server.URL + "/private-data/notes/outside.txt"
+	request = authenticatedRequest(t, http.MethodPut, outsideURL, strings.NewReader("local only"))
+	require.Equal(t, http.StatusOK, doStatus(t, client, request))
+	statusCode, status := replicationStatus(t, client, outsideURL)
+	require.Equal(t, http.StatusOK, statusCode)
+	require.Empty(t, status)
+
+	request = authenticatedRequest(t, http.MethodDelete, sourceURL, nil)
+	require.Equal(t, http.StatusNoContent, doStatus(t, client, request))
+	require.Eventually(t, func() bool {
+		request := authenticatedRequest(t, http.MethodHead, targetURL, nil)
+		return doStatus(t, target.server.Client(), request) == http.StatusNotFound
+	}, 5*time.Second, 20*time.Millisecond)
+	request = authenticatedRequest(t, http.MethodHead, target.server.URL+"/hackmd/notes/outside.txt", nil)
+	require.Equal(t, http.StatusNotFound, doStatus(t, target.server.Client(), request))
+}
+
+func TestS3ReplicationReportsFailureAfterRetries(t *testing.T) {
+	target := newIntegrationEnvironment(t)
+	source := newReplicationSource(t, target.server.URL)
+	client := source.server.Client()
+	sourceURL := source.server.URL + "/private-data/broken/object.txt"
+
+	request := authenticatedRequest(t, http.MethodPut, sourceURL, strings.NewReader("rejected"))
+	require.Equal(t, http.StatusOK, doStatus(t, client, request))
+	require.Eventually(t, func() bool {
+		_, status := replicationStatus(t, client, sourceURL)
+		return status == string(replication.StatusFailed)
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// A new version of the object is queued again.
+	request = authenticatedRequest(t, http.MethodPut, sourceURL, strings.NewReader("still rejected"))
+	require.Equal(t, http.StatusOK, doStatus(t, client, request))
+	_, status := replicationStatus(t, client, sourceURL)
+	require.NotEqual(t, string(replication.StatusCompleted), status)
+}
+
+func TestS3GetBucketReplication(t *testing.T) {
+	target := newIntegrationEnvironment(t)
+	source := newReplicationSource(t, target.server.URL)
+	client := source.server.Client()
+
+	request := authenticatedRequest(t, http.MethodGet, source.server.URL+"/private-data?replication", nil)
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	body := string(readResponse(t, response))
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Contains(t, body, "<ID>dr</ID>")
+	require.Contains(t, body, "<Prefix>docs/</Prefix>")
+	require.Contains(t, body, "<Bucket>arn:aws:s3:::hackmd</Bucket>")
+	require.NotContains(t, body, "not-the-secret")
+	require.NotContains(t, body, target.server.URL)
+
+	request = authenticatedRequest(t, http.MethodGet, source.server.URL+"/hackmd?replication", nil)
+	response, err = client.Do(request)
+	require.NoError(t, err)
+	body = string(readResponse(t, response))
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+	require.Contains(t, body, "ReplicationConfigurationNotFoundError")
+}
+
+func TestS3PutAndDeleteBucketReplication(t *testing.T) {
+	target := newIntegrationEnvironment(t)
+	source := newReplicationSource(t, target.server.URL)
+	client := source.server.Client()
+	configURL := source.server.URL + "/private-data?replication"
+
+	request := authenticatedRequest(t, http.MethodPut, configURL, strings.NewReader(replicationRulesXML))
+	request.SetBasicAuth("reader", "reader-secret")
+	require.Equal(t, http.StatusForbidden, doStatus(t, client, request))
+
+	unknown := strings.ReplaceAll(replicationRulesXML, "<Account>broken</Account>", "<Account>missing</Account>")
+	request = authenticatedRequest(t, http.MethodPut, configURL, strings.NewReader(unknown))
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	require.Contains(t, string(readResponse(t, response)), "InvalidArgument")
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	disabled := strings.Replace(replicationRulesXML, "<Status>Enabled</Status>", "<Status>Disabled</Status>", 1)
+	request = authenticatedRequest(t, http.MethodPut, configURL, strings.NewReader(disabled))
+	require.Equal(t, http.StatusOK, doStatus(t, client, request))
+	request = authenticatedRequest(t, http.MethodGet, configURL, nil)
+	response, err = client.Do(request)
+	require.NoError(t, err)
+	body := string(readResponse(t, response))
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Contains(t, body, "<Status>Disabled</Status>")
+	require.Contains(t, body, "<Account>broken</Account>")
+
+	// Disabled rules do not queue the change made in the same transaction.
+	objectURL := source.server.URL + "/private-data/docs/paused.txt"
+	request = authenticatedRequest(t, http.MethodPut, objectURL, strings.NewReader("paused"))
+	require.Equal(t, http.StatusOK, doStatus(t, client, request))
+	_, status := replicationStatus(t, client, objectURL)
+	require.Empty(t, status)
+
+	request = authenticatedRequest(t, http.MethodDelete, configURL, nil)
+	require.Equal(t, http.StatusNoContent, doStatus(t, client, request))
+	request = authenticatedRequest(t, http.MethodGet, configURL, nil)
+	require.Equal(t, http.StatusNotFound, doStatus(t, client, request))
+}
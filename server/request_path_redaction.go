@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/xxxsen/tgfile/sharelink"
 )
 
 const redactedPathComponent = "_redacted_"
@@ -44,7 +46,7 @@ func (s *Server) redactedRequestPath(requestPath string) (string, bool) {
 	if strings.HasPrefix(requestPath, "/_admin/api/") {
 		return "/_admin/api/" + redactedPathComponent, true
 	}
-	for _, prefix := range []string{"/file/download/", "/file/meta/"} {
+	for _, prefix := range []string{"/file/download/", "/file/meta/", "/file/upload/", sharelink.RoutePrefix} {
 		if strings.HasPrefix(requestPath, prefix) && len(requestPath) > len(prefix) {
 			return prefix + redactedPathComponent, true
 		}
@@ -62,7 +64,7 @@ func (s *Server) redactedRequestPath(requestPath string) (string, bool) {
 		return requestPath, false
 	}
 	switch bucketName {
-	case "", "_admin", "backup", "file", "webdav":
+	case "", "_admin", "_principals", "backup", "fetch", "file", "quota", "s", "share", "sts", "webdav":
 		return requestPath, false
 	}
 	return "/" + bucketName + "/" + redactedPathComponent, true
@@ -101,6 +103,10 @@ func restoreOriginalPathParameters(c *gin.Context, requestPath string) {
 		setPathParameter(c, "key", strings.TrimPrefix(requestPath, "/file/download/"))
 	case strings.HasPrefix(requestPath, "/file/meta/"):
 		setPathParameter(c, "key", strings.TrimPrefix(requestPath, "/file/meta/"))
+	case strings.HasPrefix(requestPath, "/file/upload/"):
+		setPathParameter(c, "name", strings.TrimPrefix(requestPath, "/file/upload/"))
+	case strings.HasPrefix(requestPath, sharelink.RoutePrefix):
+		setPathParameter(c, "share", strings.TrimPrefix(requestPath, "/s"))
 	case strings.HasPrefix(requestPath, "/webdav/"):
 		setPathParameter(c, "all", strings.TrimPrefix(requestPath, "/webdav"))
 	default:
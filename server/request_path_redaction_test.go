@@ -31,6 +31,10 @@ func TestSensitiveRequestPathsAreRedactedWithoutChangingRequestTarget(t *testing
 			target:   "http://example.test/file/meta/0123456789abcdef-secret.txt",
 			expected: "/file/meta/_redacted_",
 		},
+		{
+			target:   "http://example.test/file/upload/private-report.pdf",
+			expected: "/file/upload/_redacted_",
+		},
 		{
 			target:   "http://example.test/webdav/hackmd/private/path.bin",
 			expected: "/webdav/_redacted_",
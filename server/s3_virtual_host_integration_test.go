@@ -0,0 +1,129 @@
+package server_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsv4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func virtualHostedRequest(t *testing.T, target, host, method, path string, body []byte) *http.Request {
+	t.Helper()
+	request, err := http.NewRequestWithContext(t.Context(), method, target+path, bytes.NewReader(body))
+	require.NoError(t, err)
+	request.Host = host
+	return request
+}
+
+func signVirtualHostedRequest(t *testing.T, request *http.Request, body []byte) {
+	t.Helper()
+	payloadDigest := sha256.Sum256(body)
+	digest := hex.EncodeToString(payloadDigest[:])
+	request.Header.Set("X-Amz-Content-Sha256", digest)
+	require.NoError(t, awsv4.NewSigner().SignHTTP(
+		t.Context(),
+		aws.Credentials{AccessKeyID: "access", SecretAccessKey: "secret"},
+		request,
+		digest,
+		"s3",
+		"us-east-1",
+		time.Now(),
+	))
+}
+
+func TestS3VirtualHostedStyleObjectLifecycle(t *testing.T) {
+	testServer := newIntegrationServer(t)
+	client := testServer.Client()
+	content := []byte("virtual hosted content")
+
+	upload := virtualHostedRequest(
+		t, testServer.URL, "private-data.s3.example.test", http.MethodPut, "/notes/a.txt", content,
+	)
+	signVirtualHostedRequest(t, upload, content)
+	response, err := client.Do(upload)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode, string(readResponse(t, response)))
+
+	pathStyle := authenticatedRequest(t, http.MethodGet, testServer.URL+"/private-data/notes/a.txt", nil)
+	response, err = client.Do(pathStyle)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, content, readResponse(t, response))
+
+	anonymous := virtualHostedRequest(
+		t, testServer.URL, "private-data.s3.example.test:443", http.MethodGet, "/notes/a.txt", nil,
+	)
+	response, err = client.Do(anonymous)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, response.StatusCode)
+	_ = readResponse(t, response)
+
+	request := virtualHostedRequest(
+		t, testServer.URL, "PRIVATE-DATA.S3.Example.Test", http.MethodGet, "/notes/a.txt?X-Amz-Expires=300", nil,
+	)
+	presignedURL, signedHeaders, err := awsv4.NewSigner().PresignHTTP(
+		t.Context(),
+		aws.Credentials{AccessKeyID: "access", SecretAccessKey: "secret"},
+		request,
+		"UNSIGNED-PAYLOAD",
+		"s3",
+		"us-east-1",
+		time.Now(),
+	)
+	require.NoError(t, err)
+	presigned, err := http.NewRequestWithContext(t.Context(), http.MethodGet, presignedURL, nil)
+	require.NoError(t, err)
+	presigned.Header = signedHeaders
+	presigned.Host = request.Host
+	response, err = client.Do(presigned)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, content, readResponse(t, response))
+
+	list := virtualHostedRequest(
+		t, testServer.URL, "private-data.s3.example.test", http.MethodGet, "/?list-type=2&prefix=notes/", nil,
+	)
+	signVirtualHostedRequest(t, list, nil)
+	response, err = client.Do(list)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Contains(t, string(readResponse(t, response)), "<Key>notes/a.txt</Key>")
+
+	public := virtualHostedRequest(t, testServer.URL, "hackmd.s3.example.test", http.MethodGet, "/missing.txt", nil)
+	response, err = client.Do(public)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+	require.Contains(t, string(readResponse(t, response)), "NoSuchKey")
+}
+
+func TestS3VirtualHostedStyleUnknownBucket(t *testing.T) {
+	testServer := newIntegrationServer(t)
+	client := testServer.Client()
+
+	for _, host := range []string{"missing.s3.example.test", "webdav.s3.example.test", "file.s3.example.test"} {
+		anonymous := virtualHostedRequest(t, testServer.URL, host, http.MethodGet, "/", nil)
+		response, err := client.Do(anonymous)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusForbidden, response.StatusCode, host)
+		require.Contains(t, string(readResponse(t, response)), "AccessDenied")
+
+		signed := virtualHostedRequest(t, testServer.URL, host, http.MethodGet, "/", nil)
+		signVirtualHostedRequest(t, signed, nil)
+		response, err = client.Do(signed)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound, response.StatusCode, host)
+		require.Contains(t, string(readResponse(t, response)), "NoSuchBucket")
+	}
+
+	apex := virtualHostedRequest(t, testServer.URL, "s3.example.test", http.MethodGet, "/hackmd/missing.txt", nil)
+	response, err := client.Do(apex)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+	require.Contains(t, string(readResponse(t, response)), "NoSuchKey")
+}
@@ -6,10 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -20,13 +22,20 @@ import (
 	"github.com/xxxsen/common/webapi/proxyutil"
 
 	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/filemgr"
 	"github.com/xxxsen/tgfile/server/handler/admin"
 	"github.com/xxxsen/tgfile/server/handler/backup"
+	"github.com/xxxsen/tgfile/server/handler/fetch"
 	"github.com/xxxsen/tgfile/server/handler/file"
+	"github.com/xxxsen/tgfile/server/handler/quota"
 	"github.com/xxxsen/tgfile/server/handler/s3"
 	"github.com/xxxsen/tgfile/server/handler/s3/s3base"
+	"github.com/xxxsen/tgfile/server/handler/share"
+	"github.com/xxxsen/tgfile/server/handler/sts"
+	"github.com/xxxsen/tgfile/server/handler/tus"
 	"github.com/xxxsen/tgfile/server/handler/webdav"
 	"github.com/xxxsen/tgfile/server/model"
+	"github.com/xxxsen/tgfile/uploadsession"
 
 	"github.com/gin-gonic/gin"
 )
@@ -55,6 +64,9 @@ func New(bind string, opts ...Option) (*Server, error) {
 			return nil, err
 		}
 	}
+	if err := file.CleanupStaleUploads(c.fileUpload.TempDir, 24*time.Hour); err != nil {
+		return nil, fmt.Errorf("clean up direct upload spools: %w", err)
+	}
 	if c.s3.Enabled {
 		buckets := make([]s3.Bucket, 0, len(c.s3.Buckets))
 		for _, bucket := range c.s3.Buckets {
@@ -69,12 +81,24 @@ func New(bind string, opts ...Option) (*Server, error) {
 			MultipartExpireHours: c.s3.MultipartExpireHours,
 			Users:                c.userMap,
 			Authorizer:           c.authorizer,
+			BaseDomain:           c.s3.BaseDomain,
+			Sessions:             c.sessions,
+			Replication:          c.replication,
+			Lifecycle:            c.lifecycle,
+			Inventory:            c.inventory,
+			AccessLog:            c.accessLog,
 		})
 	}
 	if c.admin.Enabled {
 		if c.backupManager == nil {
 			return nil, errAdminBackupManagerRequired
 		}
+		var s3Buckets []string
+		if c.s3.Enabled {
+			for _, bucket := range c.s3.Buckets {
+				s3Buckets = append(s3Buckets, bucket.Name)
+			}
+		}
 		svr.adminHandler, err = admin.New(admin.Options{
 			FileManager:      c.fmgr,
 			BackupManager:    c.backupManager,
@@ -86,6 +110,10 @@ func New(bind string, opts ...Option) (*Server, error) {
 			MaxUploadSize:    c.admin.MaxUploadSize,
 			MaxPathBytes:     c.admin.MaxPathBytes,
 			MutationMaxItems: c.admin.MaxMutationEntries,
+			S3Buckets:        s3Buckets,
+			ArchiveLimits:    c.archive,
+			ShareLinks:       c.shareLinks,
+			FetchManager:     c.fetches,
 		})
 		if err != nil {
 			return nil, fmt.Errorf("initialize admin handler: %w", err)
@@ -96,7 +124,10 @@ func New(bind string, opts ...Option) (*Server, error) {
 		bind,
 		webapi.WithAuth(auth.MapUserMatch(c.userMap)),
 		webapi.WithAuthenticators(auth.NewBasic()),
-		webapi.WithExtraMiddlewares(restoreOriginalRequestPath),
+		webapi.WithExtraMiddlewares(
+			restoreOriginalRequestPath,
+			svr.rejectUnknownVirtualHostedBucket,
+		),
 		webapi.WithRegister(svr.initAPI),
 		webapi.WithNoRoute(svr.noRoute),
 	)
@@ -108,21 +139,74 @@ func New(bind string, opts ...Option) (*Server, error) {
 
 func (s *Server) initAPI(router *gin.RouterGroup) {
 	mustAuthMiddleware := middleware.MustAuthMiddleware()
+	router.Use(recordOwner)
 
 	if s.adminHandler != nil {
 		s.adminHandler.Register(router)
 	}
 	s.registerFileAPI(router, mustAuthMiddleware)
 	s.registerBackupAPI(router, mustAuthMiddleware)
+	s.registerQuotaAPI(router, mustAuthMiddleware)
+	s.registerSTSAPI(router, mustAuthMiddleware)
 	s.registerS3API(router)
 	s.registerWebDAVAPI(router, mustAuthMiddleware)
+	// Share, fetch and tus authorize paths through the WebDAV handler.
+	s.registerShareAPI(router, mustAuthMiddleware)
+	s.registerFetchAPI(router, mustAuthMiddleware)
+	s.registerTusAPI(router, mustAuthMiddleware)
+}
+
+func (s *Server) registerSTSAPI(
+	router *gin.RouterGroup,
+	mustAuthMiddleware gin.HandlerFunc,
+) {
+	if !s.c.s3.Enabled || s.c.sessions == nil {
+		return
+	}
+	buckets := make([]string, 0, len(s.c.s3.Buckets))
+	for _, bucket := range s.c.s3.Buckets {
+		buckets = append(buckets, bucket.Name)
+	}
+	stsHandler := sts.New(s.c.sessions, s.c.authorizer, buckets)
+	stsRouter := router.Group("/sts/v1", mustAuthMiddleware)
+	stsRouter.POST("/credentials", stsHandler.Issue)
+	stsRouter.GET("/credentials", stsHandler.List)
+	stsRouter.DELETE("/credentials/:access_key_id", stsHandler.Revoke)
+}
+
+func (s *Server) registerShareAPI(
+	router *gin.RouterGroup,
+	mustAuthMiddleware gin.HandlerFunc,
+) {
+	if s.c.shareLinks == nil {
+		return
+	}
+	sources := share.Sources{Buckets: make([]string, 0, len(s.c.s3.Buckets))}
+	if s.c.s3.Enabled {
+		for _, bucket := range s.c.s3.Buckets {
+			sources.Buckets = append(sources.Buckets, bucket.Name)
+		}
+	}
+	if s.webdavHandler != nil {
+		sources.WebDAV = s.webdavHandler
+	}
+	shareHandler := share.New(s.c.shareLinks, s.c.fmgr, s.c.authorizer, sources)
+	router.GET("/s/*share", shareHandler.Serve)
+	router.HEAD("/s/*share", shareHandler.Serve)
+	shareRouter := router.Group("/share/v1", mustAuthMiddleware)
+	shareRouter.POST("/links", shareHandler.Create)
+	shareRouter.GET("/links", shareHandler.List)
+	shareRouter.DELETE("/links/:share_id", shareHandler.Revoke)
 }
 
 func (s *Server) registerFileAPI(
 	router *gin.RouterGroup,
 	mustAuthMiddleware gin.HandlerFunc,
 ) {
-	fileHandler := file.NewFileHandler(s.c.fmgr)
+	fileHandler := file.NewFileHandler(s.c.fmgr, s.c.archive, file.UploadOptions{
+		MaxUploadSize: s.c.fileUpload.MaxUploadSize,
+		TempDir:       s.c.fileUpload.TempDir,
+	}, s.c.authorizer)
 	fileRouter := router.Group("/file")
 	upload := proxyutil.WrapBizFunc(
 		func(c *gin.Context, ctx context.Context, request any) {
@@ -136,14 +220,84 @@ func (s *Server) registerFileAPI(
 		s.permissionMiddleware(authz.FileWrite),
 		upload,
 	)
+	for _, method := range []string{http.MethodPut, http.MethodPost} {
+		fileRouter.Handle(
+			method,
+			"/upload/:name",
+			mustAuthMiddleware,
+			s.permissionMiddleware(authz.FileWrite),
+			fileHandler.FileRawUpload,
+		)
+	}
 	fileRouter.GET("/download/:key", fileHandler.FileDownload)
 	fileRouter.GET("/meta/:key", fileHandler.GetMetaInfo)
+	fileRouter.GET("/thumb/:key", fileHandler.FileThumbnail)
+	fileRouter.GET("/archive", fileHandler.FileArchive)
+	fileRouter.POST(
+		"/revoke",
+		mustAuthMiddleware,
+		s.permissionMiddleware(authz.FileWrite),
+		proxyutil.WrapBizFunc(
+			func(c *gin.Context, ctx context.Context, request any) {
+				fileHandler.FileRevoke(ctx, c, request)
+			},
+			&model.RevokeFileKeyRequest{},
+		),
+	)
 	fileRouter.POST(
 		"/purge",
 		mustAuthMiddleware,
 		s.permissionMiddleware(authz.FileWrite),
 		fileHandler.FilePurge,
 	)
+	fileRouter.GET(
+		"/list",
+		mustAuthMiddleware,
+		s.permissionMiddleware(authz.FileWrite),
+		fileHandler.FileList,
+	)
+	fileRouter.POST(
+		"/delete",
+		mustAuthMiddleware,
+		s.permissionMiddleware(authz.FileWrite),
+		proxyutil.WrapBizFunc(
+			func(c *gin.Context, ctx context.Context, request any) {
+				fileHandler.FileDeleteLink(ctx, c, request)
+			},
+			&model.DeleteFileLinkRequest{},
+		),
+	)
+	fileRouter.DELETE(
+		"/:key",
+		mustAuthMiddleware,
+		s.permissionMiddleware(authz.FileWrite),
+		fileHandler.FileDelete,
+	)
+}
+
+// registerTusAPI runs after registerWebDAVAPI, whose handler resolves
+// upload target paths.
+func (s *Server) registerTusAPI(
+	router *gin.RouterGroup,
+	mustAuthMiddleware gin.HandlerFunc,
+) {
+	if s.c.uploads == nil {
+		return
+	}
+	var resolver filemgr.IWebDAVTargetResolver
+	if s.webdavHandler != nil {
+		resolver = s.webdavHandler
+	}
+	tusRouter := router.Group("/file/tus")
+	tusHandler := tus.New(s.c.uploads, resolver, tusRouter.BasePath())
+	tusRouter.Use(tusHandler.Protocol)
+	tusRouter.OPTIONS("/", tusHandler.Options)
+	tusRouter.OPTIONS("/:upload_id", tusHandler.Options)
+	writeRouter := tusRouter.Group("", mustAuthMiddleware, s.permissionMiddleware(authz.FileWrite))
+	writeRouter.POST("/", tusHandler.Create)
+	writeRouter.HEAD("/:upload_id", tusHandler.Head)
+	writeRouter.PATCH("/:upload_id", tusHandler.Patch)
+	writeRouter.DELETE("/:upload_id", tusHandler.Delete)
 }
 
 func (s *Server) registerBackupAPI(
@@ -164,6 +318,40 @@ func (s *Server) registerBackupAPI(
 	backupRouter.GET("/metrics", backupHandler.Metrics)
 }
 
+// registerFetchAPI runs after registerWebDAVAPI, whose handler resolves
+// WebDAV targets.
+func (s *Server) registerFetchAPI(
+	router *gin.RouterGroup,
+	mustAuthMiddleware gin.HandlerFunc,
+) {
+	if s.c.fetches == nil {
+		return
+	}
+	var resolver filemgr.IWebDAVTargetResolver
+	if s.webdavHandler != nil {
+		resolver = s.webdavHandler
+	}
+	fetchHandler := fetch.New(s.c.fetches, s.c.authorizer, resolver)
+	fetchRouter := router.Group("/fetch/v1", mustAuthMiddleware, s.permissionMiddleware(authz.FileWrite))
+	fetchRouter.POST("/jobs", fetchHandler.Create)
+	fetchRouter.GET("/jobs", fetchHandler.List)
+	fetchRouter.GET("/jobs/:job_id", fetchHandler.GetJob)
+	fetchRouter.POST("/jobs/:job_id/cancel", fetchHandler.Cancel)
+}
+
+func (s *Server) registerQuotaAPI(
+	router *gin.RouterGroup,
+	mustAuthMiddleware gin.HandlerFunc,
+) {
+	quotaHandler := quota.New(s.c.fmgr)
+	router.GET(
+		"/quota/v1/metrics",
+		mustAuthMiddleware,
+		s.permissionMiddleware(authz.AdminRead),
+		quotaHandler.Metrics,
+	)
+}
+
 func (s *Server) registerS3API(router *gin.RouterGroup) {
 	if !s.c.s3.Enabled {
 		return
@@ -171,11 +359,11 @@ func (s *Server) registerS3API(router *gin.RouterGroup) {
 	router.GET("", s.s3.RequestID, s.s3.ListBuckets)
 	for _, bucket := range s.c.s3.Buckets {
 		bucketRouter := router.Group(fmt.Sprintf("/%s", bucket.Name))
-		bucketRouter.Use(s.s3.RequestID)
+		bucketRouter.Use(s.s3.RequestID, s.s3.AccessLog)
 		bucketRouter.GET("", s.s3.GetBucket)
 		bucketRouter.HEAD("", s.s3.HeadBucket)
-		bucketRouter.PUT("", s.s3.NotImplemented)
-		bucketRouter.DELETE("", s.s3.NotImplemented)
+		bucketRouter.PUT("", s.s3.PutBucket)
+		bucketRouter.DELETE("", s.s3.DeleteBucket)
 		bucketRouter.POST("", s.s3.PostBucketOrObject)
 		bucketRouter.GET("/*object", s.s3.GetBucketOrObject)
 		bucketRouter.HEAD("/*object", s.s3.HeadBucketOrObject)
@@ -193,6 +381,11 @@ func (s *Server) registerWebDAVAPI(
 		return
 	}
 	webdavRouter := router.Group("/webdav", mustAuthMiddleware)
+	principalRouter := router.Group(webdav.PrincipalRoot, mustAuthMiddleware)
+	mounts := make([]webdav.Mount, 0, len(s.c.webdav.Mounts))
+	for _, mount := range s.c.webdav.Mounts {
+		mounts = append(mounts, webdav.Mount(mount))
+	}
 	s.webdavHandler = webdav.NewWebdavHandler(
 		s.c.fmgr,
 		s.c.webdav.Root,
@@ -204,11 +397,30 @@ func (s *Server) registerWebDAVAPI(
 			QuotaBytes:         s.c.webdav.QuotaBytes,
 			MaxMutationEntries: s.c.webdav.MaxMutationEntries,
 			SyncPageSize:       s.c.webdav.SyncPageSize,
+			Mounts:             mounts,
+			Uploads:            s.c.webdavUploads,
+			Users:              slices.Collect(maps.Keys(s.c.userMap)),
+			Groups:             s.c.webdav.Groups,
+			PrincipalRoot:      principalRouter.BasePath(),
+			ArchiveLimits:      s.c.archive,
 		},
 	)
+	// Deferred writes resolve the privileges they publish with when they
+	// publish, through the handler that checks them for WebDAV requests.
+	for _, store := range []*uploadsession.Store{s.c.uploads, s.c.webdavUploads} {
+		if store != nil {
+			store.SetWebDAVScopeResolver(s.webdavHandler)
+		}
+	}
+	if s.c.fetches != nil {
+		s.c.fetches.SetWebDAVScopeResolver(s.webdavHandler)
+	}
 	for _, method := range webdav.AllowMethods {
 		webdavRouter.Handle(method, "/*all", s.webdavHandler.Handler)
 	}
+	for _, method := range webdav.PrincipalMethods {
+		principalRouter.Handle(method, "/*all", s.webdavHandler.PrincipalHandler)
+	}
 }
 
 func (s *Server) noRoute(c *gin.Context) {
@@ -222,7 +434,7 @@ func (s *Server) noRoute(c *gin.Context) {
 	}
 	first, _, _ := strings.Cut(strings.TrimPrefix(c.Request.URL.Path, "/"), "/")
 	switch first {
-	case "", "_admin", "file", "backup", "webdav":
+	case "", "_admin", "_principals", "file", "backup", "fetch", "webdav", "sts", "s", "share", "quota":
 		c.Status(http.StatusNotFound)
 		return
 	}
@@ -230,6 +442,27 @@ func (s *Server) noRoute(c *gin.Context) {
 		s.s3.NotImplemented(c)
 		return
 	}
+	s.writeNoSuchBucket(c, first)
+}
+
+// rejectUnknownVirtualHostedBucket stops virtual-hosted-style requests for
+// buckets that are not configured before the rewritten path can reach a
+// reserved route such as /webdav or /file.
+func (s *Server) rejectUnknownVirtualHostedBucket(c *gin.Context) {
+	bucket, ok := s3.VirtualHostedBucket(c.Request)
+	if !ok {
+		c.Next()
+		return
+	}
+	if _, exists := s.s3.Bucket(bucket); exists {
+		c.Next()
+		return
+	}
+	s.writeNoSuchBucket(c, bucket)
+	c.Abort()
+}
+
+func (s *Server) writeNoSuchBucket(c *gin.Context, bucket string) {
 	permission := authz.S3Write
 	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
 		permission = authz.S3Read
@@ -244,10 +477,20 @@ func (s *Server) noRoute(c *gin.Context) {
 		"The specified bucket does not exist.",
 		nil,
 	)
-	apiError.Bucket = first
+	apiError.Bucket = bucket
 	s3base.WriteError(c, apiError)
 }
 
+// recordOwner makes the Basic authenticated principal the owner of what the
+// request writes. S3 and the admin UI authenticate on their own and record
+// their principal themselves.
+func recordOwner(c *gin.Context) {
+	if user, ok := proxyutil.GetUserInfo(c.Request.Context()); ok {
+		c.Request = c.Request.WithContext(filemgr.ContextWithOwner(c.Request.Context(), user.Username))
+	}
+	c.Next()
+}
+
 func (s *Server) permissionMiddleware(permission authz.Permission) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user, ok := proxyutil.GetUserInfo(c.Request.Context())
@@ -264,6 +507,12 @@ func (s *Server) permissionMiddleware(permission authz.Permission) gin.HandlerFu
 }
 
 func (s *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if s.s3 != nil {
+		if rewritten, ok := s.s3.RewriteVirtualHostedRequest(request); ok {
+			s.engine.ServeHTTP(writer, s.requestWithRedactedLogPath(rewritten))
+			return
+		}
+	}
 	if s.c != nil && s.c.webdav.Enabled && isWebDAVRequestPath(request.URL.Path) {
 		writer.Header().Set("Cache-Control", "private, no-cache")
 		writer.Header().Set("Vary", "Authorization")
@@ -271,6 +520,7 @@ func (s *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	if s.adminHandler != nil {
 		request = admin.PreserveUnknownContentLength(request)
 	}
+	request = file.PreserveUnknownContentLength(request)
 	prepared, closePrepared, ok := s.prepareWebDAVPut(writer, request)
 	if !ok {
 		return
@@ -343,7 +593,8 @@ func (s *Server) webDAVPutAuthorizationStatus(request *http.Request) int {
 		subtle.ConstantTimeCompare([]byte(password), []byte(expected)) != 1 {
 		return http.StatusUnauthorized
 	}
-	if !s.c.authorizer.Has(username, authz.WebDAVWrite) {
+	if !s.c.authorizer.Has(username, authz.WebDAVWrite) ||
+		(s.webdavHandler != nil && s.webdavHandler.MountReadOnly(request.URL.Path)) {
 		return http.StatusForbidden
 	}
 	return 0
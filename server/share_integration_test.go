@@ -0,0 +1,403 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/server"
+	"github.com/xxxsen/tgfile/sharelink"
+)
+
+func newShareIntegrationEnvironment(t *testing.T) *integrationEnvironment {
+	t.Helper()
+	environment := newIntegrationEnvironmentWithStorage(t, nil,
+		func(db database.IDatabase, manager filemgr.IFileManager) []server.Option {
+			return []server.Option{server.WithShareLinks(sharelink.New(db, manager))}
+		})
+	for name, content := range map[string]string{
+		"/docs/report.txt":     "0123456789",
+		"/docs/sub/nested.txt": "nested",
+	} {
+		fileID, err := environment.manager.CreateFile(t.Context(), int64(len(content)), strings.NewReader(content))
+		require.NoError(t, err)
+		require.NoError(t, environment.manager.CreateFileLink(t.Context(), name, fileID, int64(len(content)), false))
+	}
+	return environment
+}
+
+func createShareLink(
+	t *testing.T,
+	environment *integrationEnvironment,
+	request map[string]any,
+) (int, *sharelink.Created) {
+	t.Helper()
+	body, err := json.Marshal(request)
+	require.NoError(t, err)
+	httpRequest := authenticatedRequest(t, http.MethodPost, environment.server.URL+"/share/v1/links",
+		bytes.NewReader(body))
+	httpRequest.Header.Set("Content-Type", "application/json")
+	response, err := environment.server.Client().Do(httpRequest)
+	require.NoError(t, err)
+	raw := readResponse(t, response)
+	if response.StatusCode != http.StatusCreated {
+		return response.StatusCode, nil
+	}
+	var created sharelink.Created
+	require.NoError(t, json.Unmarshal(raw, &created))
+	return response.StatusCode, &created
+}
+
+func getShare(
+	t *testing.T,
+	environment *integrationEnvironment,
+	target string,
+	header http.Header,
+) (*http.Response, string) {
+	t.Helper()
+	request, err := http.NewRequestWithContext(t.Context(), http.MethodGet, environment.server.URL+target, nil)
+	require.NoError(t, err)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	client := *environment.server.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	return response, string(readResponse(t, response))
+}
+
+func TestShareLinkServesFileWithRangeAndLimit(t *testing.T) {
+	environment := newShareIntegrationEnvironment(t)
+	status, created := createShareLink(t, environment, map[string]any{
+		"path": "/docs/report.txt", "max_downloads": 2, "expires_seconds": 3600,
+	})
+	require.Equal(t, http.StatusCreated, status)
+	require.NotNil(t, created.ExpiresAt)
+
+	response, body := getShare(t, environment, created.URLPath, nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "0123456789", body)
+	require.Contains(t, response.Header.Get("Content-Disposition"), `filename=report.txt`)
+	require.Equal(t, "no-referrer", response.Header.Get("Referrer-Policy"))
+
+	// A later range of the same download does not use another download.
+	response, body = getShare(t, environment, created.URLPath, http.Header{"Range": {"bytes=4-6"}})
+	require.Equal(t, http.StatusPartialContent, response.StatusCode)
+	require.Equal(t, "456", body)
+
+	response, _ = getShare(t, environment, created.URLPath, nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	response, body = getShare(t, environment, created.URLPath, nil)
+	require.Equal(t, http.StatusGone, response.StatusCode)
+	require.Contains(t, body, "download limit")
+
+	links, err := sharelink.New(environment.database, environment.manager).List(t.Context(), "access")
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	require.EqualValues(t, 2, links[0].Downloads)
+}
+
+func TestShareLinkCountsEveryResponseWithTheFirstByte(t *testing.T) {
+	environment := newShareIntegrationEnvironment(t)
+	status, created := createShareLink(t, environment, map[string]any{
+		"path": "/docs/report.txt", "max_downloads": 3,
+	})
+	require.Equal(t, http.StatusCreated, status)
+
+	response, body := getShare(t, environment, created.URLPath, http.Header{"If-None-Match": {`"stale"`}})
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "0123456789", body)
+	etag := response.Header.Get("ETag")
+	response, _ = getShare(t, environment, created.URLPath, http.Header{"If-None-Match": {etag}})
+	require.Equal(t, http.StatusNotModified, response.StatusCode)
+	response, body = getShare(t, environment, created.URLPath, http.Header{"Range": {"bytes=-3"}})
+	require.Equal(t, http.StatusPartialContent, response.StatusCode)
+	require.Equal(t, "789", body)
+	response, body = getShare(t, environment, created.URLPath, http.Header{"Range": {"bytes=-10"}})
+	require.Equal(t, http.StatusPartialContent, response.StatusCode)
+	require.Equal(t, "0123456789", body)
+	response, body = getShare(t, environment, created.URLPath, http.Header{"Range": {"bytes=5-6,0-1"}})
+	require.Equal(t, http.StatusPartialContent, response.StatusCode)
+	require.Contains(t, body, "56")
+
+	response, body = getShare(t, environment, created.URLPath, http.Header{"If-None-Match": {`"stale"`}})
+	require.Equal(t, http.StatusGone, response.StatusCode)
+	require.Contains(t, body, "download limit")
+	require.Empty(t, response.Header.Get("Content-Range"))
+	links, err := sharelink.New(environment.database, environment.manager).List(t.Context(), "access")
+	require.NoError(t, err)
+	require.EqualValues(t, 3, links[0].Downloads)
+}
+
+func TestShareLinkRequiresPassword(t *testing.T) {
+	environment := newShareIntegrationEnvironment(t)
+	status, created := createShareLink(t, environment, map[string]any{
+		"path": "/docs/report.txt", "password": "open sesame",
+	})
+	require.Equal(t, http.StatusCreated, status)
+
+	response, _ := getShare(t, environment, created.URLPath, nil)
+	require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	require.Contains(t, response.Header.Get("WWW-Authenticate"), "Basic")
+
+	request, err := http.NewRequestWithContext(t.Context(), http.MethodGet, environment.server.URL+created.URLPath, nil)
+	require.NoError(t, err)
+	request.SetBasicAuth("", "wrong")
+	response, err = environment.server.Client().Do(request)
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	request.SetBasicAuth("", "open sesame")
+	response, err = environment.server.Client().Do(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "0123456789", string(readResponse(t, response)))
+}
+
+func TestShareLinkThrottlesPasswordAttemptsAndRemembersVisitors(t *testing.T) {
+	environment := newShareIntegrationEnvironment(t)
+	status, created := createShareLink(t, environment, map[string]any{
+		"path": "/docs/report.txt", "password": "open sesame",
+	})
+	require.Equal(t, http.StatusCreated, status)
+	withPassword := func(password string) http.Header {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.SetBasicAuth("", password)
+		return request.Header
+	}
+
+	response, body := getShare(t, environment, created.URLPath, withPassword("open sesame"))
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "0123456789", body)
+	cookies := response.Cookies()
+	require.Len(t, cookies, 1)
+	require.True(t, cookies[0].HttpOnly)
+	remembered := http.Header{"Cookie": {cookies[0].String()}}
+	response, body = getShare(t, environment, created.URLPath, remembered)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "0123456789", body)
+	forged := http.Header{"Cookie": {cookies[0].Name + "=9999999999.AAAA"}}
+	response, _ = getShare(t, environment, created.URLPath, forged)
+	require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	for range 5 {
+		response, _ = getShare(t, environment, created.URLPath, withPassword("wrong"))
+		require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	}
+	response, _ = getShare(t, environment, created.URLPath, withPassword("open sesame"))
+	require.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+	require.NotEmpty(t, response.Header.Get("Retry-After"))
+	response, _ = getShare(t, environment, created.URLPath, remembered)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+}
+
+func TestShareLinkListsDirectory(t *testing.T) {
+	environment := newShareIntegrationEnvironment(t)
+	status, hidden := createShareLink(t, environment, map[string]any{"path": "/docs"})
+	require.Equal(t, http.StatusCreated, status)
+	response, _ := getShare(t, environment, hidden.URLPath+"/", nil)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+	response, body := getShare(t, environment, hidden.URLPath+"/sub/nested.txt", nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "nested", body)
+
+	status, created := createShareLink(t, environment, map[string]any{"path": "/docs", "listing": true})
+	require.Equal(t, http.StatusCreated, status)
+	response, _ = getShare(t, environment, created.URLPath, nil)
+	require.Equal(t, http.StatusMovedPermanently, response.StatusCode)
+	require.Equal(t, created.URLPath+"/", response.Header.Get("Location"))
+
+	response, body = getShare(t, environment, created.URLPath+"/", nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Contains(t, body, `href="./report.txt"`)
+	require.Contains(t, body, `href="./sub/"`)
+	require.NotContains(t, body, `href="../"`)
+
+	response, body = getShare(t, environment, created.URLPath+"/sub/", nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Contains(t, body, `href="./nested.txt"`)
+	require.Contains(t, body, `href="../"`)
+
+	// Dot segments never leave the shared directory.
+	response, _ = getShare(t, environment, created.URLPath+"/..%2f..%2freport.txt", nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	response, _ = getShare(t, environment, created.URLPath+"/missing.txt", nil)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	status, _ = createShareLink(t, environment, map[string]any{"path": "/docs/report.txt", "listing": true})
+	require.Equal(t, http.StatusBadRequest, status)
+	status, _ = createShareLink(t, environment, map[string]any{"path": "/missing"})
+	require.Equal(t, http.StatusNotFound, status)
+}
+
+func TestShareLinkListAndRevoke(t *testing.T) {
+	environment := newShareIntegrationEnvironment(t)
+	status, created := createShareLink(t, environment, map[string]any{"path": "/docs/report.txt"})
+	require.Equal(t, http.StatusCreated, status)
+
+	response, err := environment.server.Client().Do(
+		authenticatedRequest(t, http.MethodGet, environment.server.URL+"/share/v1/links", nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	raw := string(readResponse(t, response))
+	require.Contains(t, raw, created.ID)
+	require.NotContains(t, raw, created.Token)
+
+	request, err := http.NewRequestWithContext(t.Context(), http.MethodGet,
+		environment.server.URL+"/share/v1/links", nil)
+	require.NoError(t, err)
+	request.SetBasicAuth("reader", "reader-secret")
+	response, err = environment.server.Client().Do(request)
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	require.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	response, err = environment.server.Client().Do(authenticatedRequest(t, http.MethodDelete,
+		environment.server.URL+"/share/v1/links/"+created.ID, nil))
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+
+	response, _ = getShare(t, environment, created.URLPath, nil)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+	response, _ = getShare(t, environment, "/s/unknown-token", nil)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	response, err = environment.server.Client().Do(authenticatedRequest(t, http.MethodDelete,
+		environment.server.URL+"/share/v1/links/TGSLMISSING", io.NopCloser(strings.NewReader(""))))
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+func TestAdminShareLinksCreateListAndRevoke(t *testing.T) {
+	environment := newAdminTestEnvironment(t)
+	testServer := httptest.NewServer(environment.handler)
+	defer testServer.Close()
+	viewerClient := adminHTTPClient(t)
+	viewer := loginAdmin(t, viewerClient, testServer.URL, "viewer", "view-secret")
+	operatorClient := adminHTTPClient(t)
+	operator := loginAdmin(t, operatorClient, testServer.URL, "operator", "write-secret")
+	jsonHeaders := map[string]string{"Content-Type": "application/json"}
+	uploadAdminFile(
+		t, operatorClient, testServer.URL, operator,
+		"/uploads/plan.txt", []byte("shared plan"), "*", http.StatusCreated,
+	)
+
+	sharesAPI := testServer.URL + "/_admin/api/v1/shares"
+	createBody := `{"path":"/uploads/plan.txt","expires_seconds":3600,"max_downloads":1}`
+	response := doAdminRequest(
+		t, viewerClient, http.MethodPost, sharesAPI, bytes.NewBufferString(createBody), viewer, jsonHeaders,
+	)
+	require.Equal(t, http.StatusForbidden, response.StatusCode)
+	closeResponse(t, response)
+	response = doAdminRequest(
+		t, operatorClient, http.MethodPost, sharesAPI,
+		bytes.NewBufferString(`{"path":"/uploads/plan.txt","listing":true}`), operator, jsonHeaders,
+	)
+	require.Equal(t, http.StatusConflict, response.StatusCode)
+	closeResponse(t, response)
+	response = doAdminRequest(
+		t, operatorClient, http.MethodPost, sharesAPI, bytes.NewBufferString(createBody), operator, jsonHeaders,
+	)
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	created := decodeAdminData[sharelink.Created](t, response)
+	require.Equal(t, "operator", created.Owner)
+
+	response, err := getResponse(t, testServer.Client(), testServer.URL+created.URLPath)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "shared plan", string(readResponse(t, response)))
+
+	type shareList struct {
+		Enabled bool              `json:"enabled"`
+		Items   []*sharelink.Link `json:"items"`
+	}
+	response = doAdminRequest(t, viewerClient, http.MethodGet, sharesAPI, nil, viewer, nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	list := decodeAdminData[shareList](t, response)
+	require.True(t, list.Enabled)
+	require.Len(t, list.Items, 1)
+	require.EqualValues(t, 1, list.Items[0].Downloads)
+
+	response = doAdminRequest(t, viewerClient, http.MethodDelete, sharesAPI+"/"+created.ID, nil, viewer, nil)
+	require.Equal(t, http.StatusForbidden, response.StatusCode)
+	closeResponse(t, response)
+	response = doAdminRequest(t, operatorClient, http.MethodDelete, sharesAPI+"/"+created.ID, nil, operator, nil)
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+	closeResponse(t, response)
+	response = doAdminRequest(t, operatorClient, http.MethodDelete, sharesAPI+"/TGSLMISSING", nil, operator, nil)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+	closeResponse(t, response)
+	response, err = getResponse(t, testServer.Client(), testServer.URL+created.URLPath)
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+func TestShareLinkCreateRequiresReadAccess(t *testing.T) {
+	environment := newIntegrationEnvironmentWithStorage(t, nil,
+		func(db database.IDatabase, manager filemgr.IFileManager) []server.Option {
+			return []server.Option{
+				server.WithShareLinks(sharelink.New(db, manager)),
+				server.WithUser(map[string]string{
+					"access": "secret", "dav-sharer": "dav-secret", "s3-sharer": "s3-secret",
+					"uploader": "upload-secret",
+				}),
+				server.WithAuthorizer(testAuthorizer(t, map[string][]string{
+					"access":     {string(authz.AllWrite)},
+					"dav-sharer": {string(authz.ShareWrite), string(authz.WebDAVRead)},
+					"s3-sharer":  {string(authz.ShareWrite), string(authz.S3Read)},
+					"uploader":   {string(authz.ShareWrite), string(authz.FileWrite)},
+				})),
+			}
+		})
+	for _, name := range []string{"/docs/public.txt", "/docs/secret.txt", "/hackmd/object.txt"} {
+		fileID, err := environment.manager.CreateFile(t.Context(), 4, strings.NewReader("data"))
+		require.NoError(t, err)
+		require.NoError(t, environment.manager.CreateFileLink(t.Context(), name, fileID, 4, false))
+	}
+	require.NoError(t, environment.manager.SetWebDAVACL(t.Context(), "/docs/secret.txt", []filemgr.WebDAVACE{{
+		Principal:  filemgr.WebDAVUserPrincipal("dav-sharer"),
+		Deny:       true,
+		Privileges: filemgr.WebDAVPrivilegeRead,
+	}}, filemgr.WebDAVMutationOptions{}))
+	create := func(username, password, sharedPath string) int {
+		request := authenticatedRequest(t, http.MethodPost, environment.server.URL+"/share/v1/links",
+			strings.NewReader(`{"path":"`+sharedPath+`"}`))
+		request.SetBasicAuth(username, password)
+		request.Header.Set("Content-Type", "application/json")
+		return doStatus(t, environment.server.Client(), request)
+	}
+
+	require.Equal(t, http.StatusCreated, create("dav-sharer", "dav-secret", "/docs/public.txt"))
+	require.Equal(t, http.StatusForbidden, create("dav-sharer", "dav-secret", "/docs/secret.txt"))
+	require.Equal(t, http.StatusCreated, create("access", "secret", "/docs/secret.txt"))
+	require.Equal(t, http.StatusCreated, create("s3-sharer", "s3-secret", "/hackmd/object.txt"))
+	require.Equal(t, http.StatusForbidden, create("s3-sharer", "s3-secret", "/docs/public.txt"))
+	require.Equal(t, http.StatusBadRequest, create("s3-sharer", "s3-secret", "/hackmd/../docs/public.txt"))
+
+	// The first upload below a direct-upload directory does not make the
+	// uploader its owner, so the directory with everyone's uploads cannot be
+	// shared through it.
+	fileID, err := environment.manager.CreateFile(t.Context(), 4, strings.NewReader("data"))
+	require.NoError(t, err)
+	require.NoError(t, environment.manager.CreateFileLink(filemgr.ContextWithOwner(t.Context(), "uploader"),
+		"/defaults/ab/upload.bin", fileID, 4, false))
+	parent, err := environment.manager.StatFileLink(t.Context(), "/defaults/ab")
+	require.NoError(t, err)
+	require.Empty(t, parent.Owner)
+	require.Equal(t, http.StatusCreated, create("uploader", "upload-secret", "/defaults/ab/upload.bin"))
+	require.Equal(t, http.StatusForbidden, create("uploader", "upload-secret", "/defaults/ab"))
+}
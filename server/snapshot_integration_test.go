@@ -0,0 +1,198 @@
+package server_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/authz"
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/server"
+)
+
+func TestSnapshotsAreServedReadOnlyOverWebDAV(t *testing.T) {
+	environment := newIntegrationEnvironmentWithStorage(
+		t,
+		[]filemgr.Option{filemgr.WithSnapshots(filemgr.SnapshotOptions{})},
+		func(database.IDatabase, filemgr.IFileManager) []server.Option {
+			return []server.Option{
+				server.WithUser(map[string]string{"access": "secret", "viewer": "viewer-secret"}),
+				server.WithAuthorizer(testAuthorizer(t, map[string][]string{
+					"access": {string(authz.AllWrite)},
+					"viewer": {string(authz.WebDAVWrite), string(authz.S3Read)},
+				})),
+			}
+		},
+	)
+	client := environment.server.Client()
+	objectURL := environment.server.URL + "/hackmd/reports/q1.txt"
+	original := []byte("first quarter")
+
+	response, err := client.Do(authenticatedRequest(t, http.MethodPut, objectURL, bytes.NewReader(original)))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	_ = readResponse(t, response)
+	_, err = environment.manager.CreateSnapshot(t.Context(), filemgr.SnapshotCreateRequest{
+		Name: "nightly", Scope: "/hackmd", Origin: filemgr.SnapshotOriginAPI,
+	})
+	require.NoError(t, err)
+	response, err = client.Do(authenticatedRequest(t, http.MethodPut, objectURL, bytes.NewReader([]byte("rewritten"))))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	_ = readResponse(t, response)
+
+	propfind := func(target string) string {
+		request := authenticatedRequest(t, "PROPFIND", environment.server.URL+target, nil)
+		request.Header.Set("Depth", "1")
+		response, err := client.Do(request)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusMultiStatus, response.StatusCode)
+		return string(readResponse(t, response))
+	}
+	require.Contains(t, propfind("/webdav/.snapshots/"), ">/webdav/.snapshots/nightly/</href>")
+	listing := propfind("/webdav/.snapshots/nightly/reports/")
+	require.Contains(t, listing, ">/webdav/.snapshots/nightly/reports/q1.txt</href>")
+	require.Contains(t, listing, ">13</getcontentlength>")
+	require.NotContains(t, propfind("/webdav/"), ".snapshots")
+
+	snapshotURL := environment.server.URL + "/webdav/.snapshots/nightly/reports/q1.txt"
+	response, err = client.Do(authenticatedRequest(t, http.MethodGet, snapshotURL, nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, original, readResponse(t, response))
+	response, err = client.Do(authenticatedRequest(
+		t, http.MethodGet, environment.server.URL+"/webdav/hackmd/reports/q1.txt", nil,
+	))
+	require.NoError(t, err)
+	require.Equal(t, []byte("rewritten"), readResponse(t, response))
+
+	response, err = client.Do(authenticatedRequest(t, http.MethodPut, snapshotURL, bytes.NewReader([]byte("x"))))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, response.StatusCode)
+	require.Equal(t, "OPTIONS, GET, HEAD, PROPFIND", response.Header.Get("Allow"))
+	_ = readResponse(t, response)
+	response, err = client.Do(authenticatedRequest(
+		t, http.MethodGet, environment.server.URL+"/webdav/.snapshots/missing/", nil,
+	))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+	_ = readResponse(t, response)
+
+	// Snapshots do not keep ACLs, so principals that are not administrators
+	// cannot see them at all.
+	for _, target := range []string{"/webdav/.snapshots/", "/webdav/.snapshots/nightly/reports/q1.txt"} {
+		for _, method := range []string{"PROPFIND", http.MethodGet} {
+			request := authenticatedRequest(t, method, environment.server.URL+target, nil)
+			request.SetBasicAuth("viewer", "viewer-secret")
+			request.Header.Set("Depth", "1")
+			require.Equal(t, http.StatusNotFound, doStatus(t, client, request), method+" "+target)
+		}
+	}
+}
+
+func TestAdminSnapshotCreateBrowseRestoreAndDelete(t *testing.T) {
+	environment := newAdminTestEnvironmentWithStorage(
+		t,
+		[]filemgr.Option{filemgr.WithSnapshots(filemgr.SnapshotOptions{})},
+	)
+	testServer := httptest.NewServer(environment.handler)
+	defer testServer.Close()
+	viewerClient := adminHTTPClient(t)
+	viewer := loginAdmin(t, viewerClient, testServer.URL, "viewer", "view-secret")
+	operatorClient := adminHTTPClient(t)
+	operator := loginAdmin(t, operatorClient, testServer.URL, "operator", "write-secret")
+	jsonHeaders := map[string]string{"Content-Type": "application/json"}
+	uploadAdminFile(
+		t, operatorClient, testServer.URL, operator,
+		"/uploads/plan.txt", []byte("version one"), "*", http.StatusCreated,
+	)
+
+	createBody := `{"name":"before-edit","scope":"/uploads"}`
+	response := doAdminRequest(
+		t, viewerClient, http.MethodPost, testServer.URL+"/_admin/api/v1/snapshots",
+		bytes.NewBufferString(createBody), viewer, jsonHeaders,
+	)
+	require.Equal(t, http.StatusForbidden, response.StatusCode)
+	closeResponse(t, response)
+	response = doAdminRequest(
+		t, operatorClient, http.MethodPost, testServer.URL+"/_admin/api/v1/snapshots",
+		bytes.NewBufferString(createBody), operator, jsonHeaders,
+	)
+	require.Equal(t, http.StatusCreated, response.StatusCode)
+	closeResponse(t, response)
+	response = doAdminRequest(
+		t, operatorClient, http.MethodPost, testServer.URL+"/_admin/api/v1/snapshots",
+		bytes.NewBufferString(createBody), operator, jsonHeaders,
+	)
+	require.Equal(t, http.StatusConflict, response.StatusCode)
+	closeResponse(t, response)
+
+	type snapshotList struct {
+		Enabled bool `json:"enabled"`
+		Items   []struct {
+			Name      string `json:"name"`
+			Principal string `json:"principal"`
+			Origin    string `json:"origin"`
+			FileCount int64  `json:"file_count"`
+		} `json:"items"`
+	}
+	response = doAdminRequest(t, viewerClient, http.MethodGet, testServer.URL+"/_admin/api/v1/snapshots", nil, viewer, nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	list := decodeAdminData[snapshotList](t, response)
+	require.True(t, list.Enabled)
+	require.Len(t, list.Items, 1)
+	require.Equal(t, "operator", list.Items[0].Principal)
+	require.Equal(t, filemgr.SnapshotOriginAPI, list.Items[0].Origin)
+	require.Equal(t, int64(1), list.Items[0].FileCount)
+
+	type entryPage struct {
+		Items []struct {
+			Name string `json:"name"`
+			Path string `json:"path"`
+		} `json:"items"`
+	}
+	snapshotAPI := testServer.URL + "/_admin/api/v1/snapshots/before-edit"
+	response = doAdminRequest(t, viewerClient, http.MethodGet, snapshotAPI+"/entries?path=/", nil, viewer, nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	page := decodeAdminData[entryPage](t, response)
+	require.Len(t, page.Items, 1)
+	require.Equal(t, "/plan.txt", page.Items[0].Path)
+
+	uploadAdminFile(
+		t, operatorClient, testServer.URL, operator,
+		"/uploads/plan.txt", []byte("version two"), statAdminEntry(
+			t, operatorClient, testServer.URL, "/uploads/plan.txt",
+		).ETag, http.StatusOK,
+	)
+	response = doAdminRequest(t, viewerClient, http.MethodGet, snapshotAPI+"/content?path=/plan.txt", nil, viewer, nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, []byte("version one"), readResponse(t, response))
+
+	restoreBody := `{"path":"/plan.txt"}`
+	response = doAdminRequest(
+		t, operatorClient, http.MethodPost, snapshotAPI+"/restore",
+		bytes.NewBufferString(restoreBody), operator, jsonHeaders,
+	)
+	require.Equal(t, http.StatusConflict, response.StatusCode)
+	closeResponse(t, response)
+	response = doAdminRequest(
+		t, operatorClient, http.MethodPost, snapshotAPI+"/restore",
+		bytes.NewBufferString(`{"path":"/plan.txt","overwrite":true}`), operator, jsonHeaders,
+	)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	closeResponse(t, response)
+	require.Equal(t, []byte("version one"), downloadAdminFile(
+		t, operatorClient, testServer.URL, operator, "/uploads/plan.txt",
+	))
+
+	response = doAdminRequest(t, operatorClient, http.MethodDelete, snapshotAPI, nil, operator, nil)
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+	closeResponse(t, response)
+	response = doAdminRequest(t, operatorClient, http.MethodDelete, snapshotAPI, nil, operator, nil)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+	closeResponse(t, response)
+}
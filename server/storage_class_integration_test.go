@@ -0,0 +1,186 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/blockio/localfile"
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/lifecycle"
+	"github.com/xxxsen/tgfile/server"
+)
+
+// newTieredEnvironment keeps GLACIER on the primary in-memory backend and
+// serves STANDARD from a local directory, so new objects default to the tier.
+func newTieredEnvironment(t *testing.T) *integrationEnvironment {
+	t.Helper()
+	hot, err := localfile.New(t.TempDir(), 1024*1024)
+	require.NoError(t, err)
+	var worker *lifecycle.Manager
+	environment := newIntegrationEnvironmentWithStorage(t, []filemgr.Option{
+		filemgr.WithPrimaryStorageClass("GLACIER"),
+		filemgr.WithStorageTier("STANDARD", hot),
+	}, func(_ database.IDatabase, manager filemgr.IFileManager) []server.Option {
+		worker, err = lifecycle.New(manager, lifecycle.Options{
+			Rules: []lifecycle.Rule{{
+				ID: "archive", Bucket: "hackmd", Prefix: "archive/", StorageClass: "GLACIER",
+			}},
+			ScanInterval: 20 * time.Millisecond,
+		})
+		require.NoError(t, err)
+		return []server.Option{server.WithLifecycle(worker)}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- worker.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		require.ErrorIs(t, <-done, context.Canceled)
+	})
+	return environment
+}
+
+func putWithStorageClass(t *testing.T, client *http.Client, objectURL, class string, content []byte) int {
+	t.Helper()
+	request := authenticatedRequest(t, http.MethodPut, objectURL, bytes.NewReader(content))
+	if class != "" {
+		request.Header.Set("X-Amz-Storage-Class", class)
+	}
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	return response.StatusCode
+}
+
+func headStorageClass(t *testing.T, client *http.Client, objectURL string) string {
+	t.Helper()
+	response, err := client.Do(authenticatedRequest(t, http.MethodHead, objectURL, nil))
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	return response.Header.Get("X-Amz-Storage-Class")
+}
+
+func assertObjectContent(t *testing.T, client *http.Client, objectURL string, content []byte) {
+	t.Helper()
+	response, err := client.Do(authenticatedRequest(t, http.MethodGet, objectURL, nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, content, readResponse(t, response))
+}
+
+func listedStorageClasses(t *testing.T, client *http.Client, bucketURL string) map[string]string {
+	t.Helper()
+	response, err := client.Do(authenticatedRequest(t, http.MethodGet, bucketURL+"?list-type=2", nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	var result struct {
+		Contents []struct {
+			Key          string `xml:"Key"`
+			StorageClass string `xml:"StorageClass"`
+		} `xml:"Contents"`
+	}
+	require.NoError(t, xml.Unmarshal(readResponse(t, response), &result))
+	classes := make(map[string]string, len(result.Contents))
+	for _, item := range result.Contents {
+		classes[item.Key] = item.StorageClass
+	}
+	return classes
+}
+
+func TestS3StorageClassSelectsBackend(t *testing.T) {
+	environment := newTieredEnvironment(t)
+	client := environment.server.Client()
+	bucketURL := environment.server.URL + "/hackmd"
+	hotContent := []byte("frequently read")
+	coldContent := bytes.Repeat([]byte("cold"), 1024)
+
+	require.Equal(t, http.StatusOK, putWithStorageClass(t, client, bucketURL+"/hot.txt", "", hotContent))
+	require.Equal(t, http.StatusOK, putWithStorageClass(t, client, bucketURL+"/cold.txt", "GLACIER", coldContent))
+	require.Equal(t, http.StatusBadRequest, putWithStorageClass(t, client, bucketURL+"/bad.txt", "DEEP_ARCHIVE", nil))
+
+	require.Empty(t, headStorageClass(t, client, bucketURL+"/hot.txt"))
+	require.Equal(t, "GLACIER", headStorageClass(t, client, bucketURL+"/cold.txt"))
+	require.Equal(t, map[string]string{
+		"hot.txt":  "STANDARD",
+		"cold.txt": "GLACIER",
+	}, listedStorageClasses(t, client, bucketURL))
+	attributes := getIntegrationObjectAttributes(t, client, bucketURL+"/cold.txt", "StorageClass", 0, 1000)
+	require.Equal(t, "GLACIER", attributes.StorageClass)
+	assertObjectContent(t, client, bucketURL+"/hot.txt", hotContent)
+	assertObjectContent(t, client, bucketURL+"/cold.txt", coldContent)
+
+	copyRequest := authenticatedRequest(t, http.MethodPut, bucketURL+"/cold.txt", nil)
+	copyRequest.Header.Set("X-Amz-Copy-Source", "/hackmd/cold.txt")
+	copyRequest.Header.Set("X-Amz-Storage-Class", "STANDARD")
+	response, err := client.Do(copyRequest)
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Empty(t, headStorageClass(t, client, bucketURL+"/cold.txt"))
+	assertObjectContent(t, client, bucketURL+"/cold.txt", coldContent)
+}
+
+func TestS3MultipartStorageClass(t *testing.T) {
+	environment := newTieredEnvironment(t)
+	client := environment.server.Client()
+	objectURL := environment.server.URL + "/hackmd/multipart/cold.bin"
+
+	createRequest := authenticatedRequest(t, http.MethodPost, objectURL+"?uploads", nil)
+	createRequest.Header.Set("X-Amz-Storage-Class", "GLACIER")
+	response, err := client.Do(createRequest)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	require.NoError(t, xml.Unmarshal(readResponse(t, response), &initiated))
+
+	response, err = client.Do(authenticatedRequest(t, http.MethodGet, environment.server.URL+"/hackmd?uploads", nil))
+	require.NoError(t, err)
+	require.Contains(t, string(readResponse(t, response)), "<StorageClass>GLACIER</StorageClass>")
+
+	firstContent := bytes.Repeat([]byte("a"), 5*1024*1024)
+	secondContent := []byte("multipart-tail")
+	firstETag := uploadIntegrationPart(t, client, objectURL, initiated.UploadID, 1, firstContent)
+	secondETag := uploadIntegrationPart(t, client, objectURL, initiated.UploadID, 2, secondContent)
+	completeCacheMultipart(t, client, objectURL, initiated.UploadID, firstETag, secondETag)
+
+	require.Equal(t, "GLACIER", headStorageClass(t, client, objectURL))
+	assertObjectContent(t, client, objectURL, append(firstContent, secondContent...))
+}
+
+func TestS3LifecycleTransitionsObjects(t *testing.T) {
+	environment := newTieredEnvironment(t)
+	client := environment.server.Client()
+	bucketURL := environment.server.URL + "/hackmd"
+	content := []byte("rarely read report")
+
+	response, err := client.Do(authenticatedRequest(t, http.MethodGet, bucketURL+"?lifecycle", nil))
+	require.NoError(t, err)
+	configuration := string(readResponse(t, response))
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Contains(t, configuration, "<ID>archive</ID>")
+	require.Contains(t, configuration, "<StorageClass>GLACIER</StorageClass>")
+	response, err = client.Do(authenticatedRequest(
+		t, http.MethodGet, environment.server.URL+"/private-data?lifecycle", nil,
+	))
+	require.NoError(t, err)
+	require.Contains(t, string(readResponse(t, response)), "NoSuchLifecycleConfiguration")
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	require.Equal(t, http.StatusOK, putWithStorageClass(t, client, bucketURL+"/archive/report.txt", "", content))
+	require.Equal(t, http.StatusOK, putWithStorageClass(t, client, bucketURL+"/current.txt", "", content))
+	require.Eventually(t, func() bool {
+		return headStorageClass(t, client, bucketURL+"/archive/report.txt") == "GLACIER"
+	}, 5*time.Second, 20*time.Millisecond)
+	require.Empty(t, headStorageClass(t, client, bucketURL+"/current.txt"))
+	assertObjectContent(t, client, bucketURL+"/archive/report.txt", content)
+}
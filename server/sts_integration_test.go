@@ -0,0 +1,186 @@
+package server_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsv4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/s3session"
+)
+
+func issueSessionCredential(
+	t *testing.T,
+	client *http.Client,
+	baseURL, username, password, body string,
+) s3session.Credential {
+	t.Helper()
+	request, err := http.NewRequestWithContext(
+		t.Context(), http.MethodPost, baseURL+"/sts/v1/credentials", strings.NewReader(body),
+	)
+	require.NoError(t, err)
+	request.SetBasicAuth(username, password)
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	raw := readResponse(t, response)
+	require.Equal(t, http.StatusCreated, response.StatusCode, string(raw))
+	var credential s3session.Credential
+	require.NoError(t, json.Unmarshal(raw, &credential))
+	return credential
+}
+
+func sessionSignedRequest(
+	t *testing.T,
+	credential s3session.Credential,
+	method, target string,
+	body []byte,
+) *http.Request {
+	t.Helper()
+	request, err := http.NewRequestWithContext(t.Context(), method, target, bytes.NewReader(body))
+	require.NoError(t, err)
+	payloadDigest := sha256.Sum256(body)
+	digest := hex.EncodeToString(payloadDigest[:])
+	request.Header.Set("X-Amz-Content-Sha256", digest)
+	require.NoError(t, awsv4.NewSigner().SignHTTP(
+		t.Context(),
+		aws.Credentials{
+			AccessKeyID:     credential.AccessKeyID,
+			SecretAccessKey: credential.SecretAccessKey,
+			SessionToken:    credential.SessionToken,
+		},
+		request,
+		digest,
+		"s3",
+		"us-east-1",
+		time.Now(),
+	))
+	return request
+}
+
+func doStatus(t *testing.T, client *http.Client, request *http.Request) int {
+	t.Helper()
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	_ = readResponse(t, response)
+	return response.StatusCode
+}
+
+func TestSessionCredentialScopeAndRevocation(t *testing.T) {
+	testServer := newIntegrationServer(t)
+	client := testServer.Client()
+	credential := issueSessionCredential(
+		t, client, testServer.URL, "access", "secret",
+		`{"bucket":"private-data","prefix":"ci/","duration_seconds":900}`,
+	)
+	require.True(t, strings.HasPrefix(credential.AccessKeyID, "TGSA"))
+	require.WithinDuration(t, time.Now().Add(15*time.Minute), credential.Expiration, time.Minute)
+
+	content := []byte("build output")
+	put := func(target string) int {
+		return doStatus(t, client, sessionSignedRequest(t, credential, http.MethodPut, target, content))
+	}
+	require.Equal(t, http.StatusOK, put(testServer.URL+"/private-data/ci/build.log"))
+	require.Equal(t, http.StatusForbidden, put(testServer.URL+"/private-data/release/build.log"))
+	require.Equal(t, http.StatusForbidden, put(testServer.URL+"/hackmd/ci/build.log"))
+
+	get := sessionSignedRequest(t, credential, http.MethodGet, testServer.URL+"/private-data/ci/build.log", nil)
+	response, err := client.Do(get)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, content, readResponse(t, response))
+
+	listInside := sessionSignedRequest(
+		t, credential, http.MethodGet, testServer.URL+"/private-data?list-type=2&prefix=ci/", nil,
+	)
+	require.Equal(t, http.StatusOK, doStatus(t, client, listInside))
+	listAll := sessionSignedRequest(t, credential, http.MethodGet, testServer.URL+"/private-data?list-type=2", nil)
+	require.Equal(t, http.StatusForbidden, doStatus(t, client, listAll))
+	listBuckets := sessionSignedRequest(t, credential, http.MethodGet, testServer.URL+"/", nil)
+	require.Equal(t, http.StatusForbidden, doStatus(t, client, listBuckets))
+
+	copyOut := sessionSignedRequest(t, credential, http.MethodPut, testServer.URL+"/private-data/ci/copy.log", nil)
+	copyOut.Header.Set("X-Amz-Copy-Source", "/hackmd/anything.txt")
+	require.NoError(t, awsv4.NewSigner().SignHTTP(
+		t.Context(),
+		aws.Credentials{
+			AccessKeyID:     credential.AccessKeyID,
+			SecretAccessKey: credential.SecretAccessKey,
+			SessionToken:    credential.SessionToken,
+		},
+		copyOut,
+		copyOut.Header.Get("X-Amz-Content-Sha256"),
+		"s3",
+		"us-east-1",
+		time.Now(),
+	))
+	require.Equal(t, http.StatusForbidden, doStatus(t, client, copyOut))
+
+	forged := credential
+	forged.SessionToken += "x"
+	require.Equal(t, http.StatusForbidden, doStatus(t, client, sessionSignedRequest(
+		t, forged, http.MethodGet, testServer.URL+"/private-data/ci/build.log", nil,
+	)))
+
+	list := authenticatedRequest(t, http.MethodGet, testServer.URL+"/sts/v1/credentials", nil)
+	response, err = client.Do(list)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Contains(t, string(readResponse(t, response)), credential.AccessKeyID)
+
+	otherRevoke, err := http.NewRequestWithContext(
+		t.Context(), http.MethodDelete, testServer.URL+"/sts/v1/credentials/"+credential.AccessKeyID, nil,
+	)
+	require.NoError(t, err)
+	otherRevoke.SetBasicAuth("reader", "reader-secret")
+	require.Equal(t, http.StatusNotFound, doStatus(t, client, otherRevoke))
+
+	revoke := authenticatedRequest(
+		t, http.MethodDelete, testServer.URL+"/sts/v1/credentials/"+credential.AccessKeyID, nil,
+	)
+	require.Equal(t, http.StatusNoContent, doStatus(t, client, revoke))
+	require.Equal(t, http.StatusForbidden, doStatus(t, client, sessionSignedRequest(
+		t, credential, http.MethodGet, testServer.URL+"/private-data/ci/build.log", nil,
+	)))
+}
+
+func TestSessionCredentialNeverExceedsParent(t *testing.T) {
+	testServer := newIntegrationServer(t)
+	client := testServer.Client()
+
+	readerCredential := issueSessionCredential(t, client, testServer.URL, "reader", "reader-secret", `{}`)
+	require.True(t, readerCredential.ReadOnly)
+	require.Equal(t, http.StatusForbidden, doStatus(t, client, sessionSignedRequest(
+		t, readerCredential, http.MethodPut, testServer.URL+"/private-data/a.txt", []byte("x"),
+	)))
+
+	writerCredential := issueSessionCredential(t, client, testServer.URL, "access", "secret", `{"read_only":true}`)
+	require.Equal(t, http.StatusForbidden, doStatus(t, client, sessionSignedRequest(
+		t, writerCredential, http.MethodPut, testServer.URL+"/private-data/a.txt", []byte("x"),
+	)))
+
+	fileOnly, err := http.NewRequestWithContext(
+		t.Context(), http.MethodPost, testServer.URL+"/sts/v1/credentials", strings.NewReader(`{}`),
+	)
+	require.NoError(t, err)
+	fileOnly.SetBasicAuth("fileonly", "file-secret")
+	require.Equal(t, http.StatusForbidden, doStatus(t, client, fileOnly))
+
+	anonymous, err := http.NewRequestWithContext(
+		t.Context(), http.MethodPost, testServer.URL+"/sts/v1/credentials", strings.NewReader(`{}`),
+	)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, doStatus(t, client, anonymous))
+
+	invalid := authenticatedRequest(
+		t, http.MethodPost, testServer.URL+"/sts/v1/credentials", strings.NewReader(`{"prefix":"ci/"}`),
+	)
+	require.Equal(t, http.StatusBadRequest, doStatus(t, client, invalid))
+}
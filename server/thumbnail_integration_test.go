@@ -0,0 +1,163 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	source := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := range width {
+		for y := range height {
+			source.SetNRGBA(x, y, color.NRGBA{B: 0xff, A: 0xff})
+		}
+	}
+	var encoded bytes.Buffer
+	require.NoError(t, png.Encode(&encoded, source))
+	return encoded.Bytes()
+}
+
+func TestThumbnailsAreServedForDirectUploadsAndWebDAV(t *testing.T) {
+	environment := newIntegrationEnvironmentWithStorage(
+		t,
+		[]filemgr.Option{filemgr.WithThumbnails(filemgr.ThumbnailOptions{
+			MaxEdge: 32, Quality: 80, MaxSourceBytes: 1 << 20, MaxSourcePixels: 1 << 20,
+		})},
+		nil,
+	)
+	client := environment.server.Client()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "photo.png")
+	require.NoError(t, err)
+	_, err = part.Write(testPNG(t, 128, 64))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	request := authenticatedRequest(t, http.MethodPost, environment.server.URL+"/file/upload", &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	var uploadResponse struct {
+		Data struct {
+			Key string `json:"key"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(readResponse(t, response), &uploadResponse))
+
+	thumbURL := environment.server.URL + "/file/thumb/" + uploadResponse.Data.Key
+	response, err = getResponse(t, client, thumbURL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "image/jpeg", response.Header.Get("Content-Type"))
+	etag := response.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+	preview, err := jpeg.Decode(bytes.NewReader(readResponse(t, response)))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 32, 16), preview.Bounds())
+	conditional, err := http.NewRequestWithContext(t.Context(), http.MethodGet, thumbURL, nil)
+	require.NoError(t, err)
+	conditional.Header.Set("If-None-Match", etag)
+	response, err = client.Do(conditional)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotModified, response.StatusCode)
+	_ = readResponse(t, response)
+
+	objectURL := environment.server.URL + "/hackmd/pics/"
+	for name, content := range map[string][]byte{"wide.png": testPNG(t, 40, 80), "notes.txt": []byte("text")} {
+		response, err = client.Do(authenticatedRequest(t, http.MethodPut, objectURL+name, bytes.NewReader(content)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+		_ = readResponse(t, response)
+	}
+	propfind := authenticatedRequest(t, "PROPFIND", environment.server.URL+"/webdav/hackmd/pics/", bytes.NewBufferString(
+		`<D:propfind xmlns:D="DAV:" xmlns:T="urn:tgfile:"><D:prop><T:thumbnail/></D:prop></D:propfind>`,
+	))
+	propfind.Header.Set("Depth", "1")
+	response, err = client.Do(propfind)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMultiStatus, response.StatusCode)
+	listing := string(readResponse(t, response))
+	require.Contains(t, listing, ">/webdav/.thumbnails/hackmd/pics/wide.png</href>")
+	require.NotContains(t, listing, ".thumbnails/hackmd/pics/notes.txt")
+
+	response, err = client.Do(authenticatedRequest(
+		t, http.MethodGet, environment.server.URL+"/webdav/.thumbnails/hackmd/pics/wide.png", nil,
+	))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	preview, err = jpeg.Decode(bytes.NewReader(readResponse(t, response)))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 16, 32), preview.Bounds())
+	for _, target := range []string{"notes.txt", "missing.png"} {
+		response, err = client.Do(authenticatedRequest(
+			t, http.MethodGet, environment.server.URL+"/webdav/.thumbnails/hackmd/pics/"+target, nil,
+		))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound, response.StatusCode)
+		_ = readResponse(t, response)
+	}
+	response, err = client.Do(authenticatedRequest(
+		t, http.MethodPut, environment.server.URL+"/webdav/.thumbnails/hackmd/pics/wide.png", bytes.NewReader(nil),
+	))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, response.StatusCode)
+	require.Equal(t, "OPTIONS, GET, HEAD", response.Header.Get("Allow"))
+	_ = readResponse(t, response)
+}
+
+func TestAdminThumbnails(t *testing.T) {
+	environment := newAdminTestEnvironmentWithStorage(
+		t,
+		[]filemgr.Option{filemgr.WithThumbnails(filemgr.ThumbnailOptions{
+			MaxEdge: 32, Quality: 80, MaxSourceBytes: 1 << 20, MaxSourcePixels: 1 << 20,
+		})},
+	)
+	testServer := httptest.NewServer(environment.handler)
+	defer testServer.Close()
+	viewerClient := adminHTTPClient(t)
+	viewer := loginAdmin(t, viewerClient, testServer.URL, "viewer", "view-secret")
+	operatorClient := adminHTTPClient(t)
+	operator := loginAdmin(t, operatorClient, testServer.URL, "operator", "write-secret")
+	uploadAdminFile(
+		t, operatorClient, testServer.URL, operator,
+		"/uploads/cover.png", testPNG(t, 64, 64), "*", http.StatusCreated,
+	)
+	uploadAdminFile(
+		t, operatorClient, testServer.URL, operator,
+		"/uploads/readme.txt", []byte("plain"), "*", http.StatusCreated,
+	)
+
+	response := doAdminRequest(t, viewerClient, http.MethodGet, testServer.URL+"/_admin/api/v1/session", nil, viewer, nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.True(t, decodeAdminData[struct {
+		Thumbnails bool `json:"thumbnails"`
+	}](t, response).Thumbnails)
+
+	thumbnailAPI := testServer.URL + "/_admin/api/v1/thumbnail?path="
+	response = doAdminRequest(t, viewerClient, http.MethodGet, thumbnailAPI+"/uploads/cover.png", nil, viewer, nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "image/jpeg", response.Header.Get("Content-Type"))
+	preview, err := jpeg.Decode(bytes.NewReader(readResponse(t, response)))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 32, 32), preview.Bounds())
+	for _, target := range []string{"/uploads/readme.txt", "/uploads", "/uploads/missing.png"} {
+		response = doAdminRequest(t, viewerClient, http.MethodGet, thumbnailAPI+target, nil, viewer, nil)
+		require.Equal(t, http.StatusNotFound, response.StatusCode, target)
+		closeResponse(t, response)
+	}
+}
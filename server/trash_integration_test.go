@@ -0,0 +1,183 @@
+package server_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+const pendingPartCountQuery = "SELECT COUNT(*) FROM tg_file_part_delete_state_tab WHERE delete_state = 'pending'"
+
+func TestTrashKeepsDeletedS3AndWebDAVEntriesUntilPurge(t *testing.T) {
+	environment := newIntegrationEnvironmentWithStorage(
+		t,
+		[]filemgr.Option{filemgr.WithTrash(time.Hour)},
+		nil,
+	)
+	client := environment.server.Client()
+	objectURL := environment.server.URL + "/hackmd/reports/trash.txt"
+	content := []byte("kept in the recycle bin")
+
+	request := authenticatedRequest(t, http.MethodPut, objectURL, bytes.NewReader(content))
+	request.Header.Set("X-Amz-Meta-Team", "storage")
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	_ = readResponse(t, response)
+
+	response, err = client.Do(authenticatedRequest(t, http.MethodDelete, objectURL, nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+	_ = readResponse(t, response)
+	response, err = getResponse(t, client, objectURL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+	_ = readResponse(t, response)
+	require.Zero(t, queryIntegrationCount(t, environment.database, pendingPartCountQuery))
+
+	page, err := environment.manager.ListTrash(t.Context(), filemgr.TrashListRequest{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	object := page.Items[0]
+	require.Equal(t, "/hackmd/reports/trash.txt", object.OriginalPath)
+	require.Equal(t, "access", object.Principal)
+	require.Equal(t, filemgr.TrashProtocolS3, object.Protocol)
+	require.False(t, object.IsDir)
+	require.Equal(t, int64(len(content)), object.Size)
+	require.Greater(t, object.ExpiresAt, object.DeletedAt)
+
+	restored, err := environment.manager.RestoreTrash(t.Context(), object.TrashID, "")
+	require.NoError(t, err)
+	require.Equal(t, object.OriginalPath, restored.OriginalPath)
+	response, err = getResponse(t, client, objectURL)
+	require.NoError(t, err)
+	require.Equal(t, "storage", response.Header.Get("X-Amz-Meta-Team"))
+	require.Equal(t, content, readResponse(t, response))
+
+	davURL := environment.server.URL + "/webdav/hackmd/reports"
+	response, err = client.Do(authenticatedRequest(t, http.MethodDelete, davURL, nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+	_ = readResponse(t, response)
+
+	page, err = environment.manager.ListTrash(t.Context(), filemgr.TrashListRequest{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	directory := page.Items[0]
+	require.Equal(t, "/hackmd/reports", directory.OriginalPath)
+	require.Equal(t, filemgr.TrashProtocolWebDAV, directory.Protocol)
+	require.True(t, directory.IsDir)
+	require.Equal(t, int64(2), directory.EntryCount)
+	require.Zero(t, queryIntegrationCount(t, environment.database, pendingPartCountQuery))
+
+	_, err = environment.manager.PurgeTrash(t.Context(), directory.TrashID)
+	require.NoError(t, err)
+	require.Positive(t, queryIntegrationCount(t, environment.database, pendingPartCountQuery))
+	_, err = environment.manager.PurgeTrash(t.Context(), directory.TrashID)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	page, err = environment.manager.ListTrash(t.Context(), filemgr.TrashListRequest{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, page.Items)
+}
+
+func TestAdminTrashRestoreAndPurge(t *testing.T) {
+	environment := newAdminTestEnvironmentWithStorage(t, []filemgr.Option{filemgr.WithTrash(time.Hour)})
+	testServer := httptest.NewServer(environment.handler)
+	defer testServer.Close()
+	viewerClient := adminHTTPClient(t)
+	viewer := loginAdmin(t, viewerClient, testServer.URL, "viewer", "view-secret")
+	operatorClient := adminHTTPClient(t)
+	operator := loginAdmin(t, operatorClient, testServer.URL, "operator", "write-secret")
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		uploadAdminFile(
+			t, operatorClient, testServer.URL, operator,
+			"/uploads/"+name, []byte(name), "*", http.StatusCreated,
+		)
+		require.NoError(t, environment.files.DeleteWebDAVResource(
+			t.Context(), "/uploads/"+name, filemgr.WebDAVMutationOptions{Principal: "operator"},
+		))
+	}
+
+	type trashPage struct {
+		Enabled bool `json:"enabled"`
+		Items   []struct {
+			TrashID      string `json:"trash_id"`
+			OriginalPath string `json:"original_path"`
+			Kind         string `json:"kind"`
+			Principal    string `json:"principal"`
+		} `json:"items"`
+		NextCursor string `json:"next_cursor"`
+	}
+	response := doAdminRequest(
+		t, viewerClient, http.MethodGet,
+		testServer.URL+"/_admin/api/v1/trash?limit=1", nil, viewer, nil,
+	)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	first := decodeAdminData[trashPage](t, response)
+	require.True(t, first.Enabled)
+	require.Len(t, first.Items, 1)
+	require.Equal(t, "/uploads/b.txt", first.Items[0].OriginalPath)
+	require.Equal(t, "file", first.Items[0].Kind)
+	require.Equal(t, "operator", first.Items[0].Principal)
+	require.NotEmpty(t, first.NextCursor)
+
+	response = doAdminRequest(
+		t, viewerClient, http.MethodGet,
+		testServer.URL+"/_admin/api/v1/trash?limit=1&cursor="+first.NextCursor, nil, viewer, nil,
+	)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	second := decodeAdminData[trashPage](t, response)
+	require.Len(t, second.Items, 1)
+	require.Equal(t, "/uploads/a.txt", second.Items[0].OriginalPath)
+	require.Empty(t, second.NextCursor)
+
+	response = doAdminRequest(
+		t, viewerClient, http.MethodDelete,
+		testServer.URL+"/_admin/api/v1/trash/"+first.Items[0].TrashID, nil, viewer, nil,
+	)
+	require.Equal(t, http.StatusForbidden, response.StatusCode)
+	closeResponse(t, response)
+
+	uploadAdminFile(
+		t, operatorClient, testServer.URL, operator,
+		"/uploads/a.txt", []byte("replacement"), "*", http.StatusCreated,
+	)
+	restoreA := testServer.URL + "/_admin/api/v1/trash/" + second.Items[0].TrashID + "/restore"
+	response = doAdminRequest(
+		t, operatorClient, http.MethodPost, restoreA,
+		bytes.NewBufferString(`{}`), operator, map[string]string{"Content-Type": "application/json"},
+	)
+	require.Equal(t, http.StatusConflict, response.StatusCode)
+	closeResponse(t, response)
+	response = doAdminRequest(
+		t, operatorClient, http.MethodPost, restoreA,
+		bytes.NewBufferString(`{"path":"/uploads/a-restored.txt"}`), operator,
+		map[string]string{"Content-Type": "application/json"},
+	)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	closeResponse(t, response)
+	require.Equal(t, int64(len("a.txt")), statAdminEntry(
+		t, operatorClient, testServer.URL, "/uploads/a-restored.txt",
+	).Size)
+
+	response = doAdminRequest(
+		t, operatorClient, http.MethodDelete,
+		testServer.URL+"/_admin/api/v1/trash/"+first.Items[0].TrashID, nil, operator, nil,
+	)
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+	closeResponse(t, response)
+	response = doAdminRequest(
+		t, operatorClient, http.MethodDelete,
+		testServer.URL+"/_admin/api/v1/trash/"+first.Items[0].TrashID, nil, operator, nil,
+	)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+	closeResponse(t, response)
+}
@@ -0,0 +1,244 @@
+package server_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/filemgr"
+	"github.com/xxxsen/tgfile/server"
+	"github.com/xxxsen/tgfile/uploadsession"
+)
+
+func newTusIntegrationEnvironment(t *testing.T) *integrationEnvironment {
+	t.Helper()
+	return newIntegrationEnvironmentWithStorage(t, nil,
+		func(db database.IDatabase, manager filemgr.IFileManager) []server.Option {
+			store, err := uploadsession.New(db, manager, uploadsession.Options{
+				Dir: t.TempDir(), Expire: time.Hour, MaxSize: 1024,
+			})
+			require.NoError(t, err)
+			return []server.Option{server.WithUploadSessions(store)}
+		})
+}
+
+func tusRequest(
+	t *testing.T,
+	environment *integrationEnvironment,
+	method, target string,
+	body io.Reader,
+	header map[string]string,
+) *http.Response {
+	t.Helper()
+	if !strings.HasPrefix(target, "http") {
+		target = environment.server.URL + target
+	}
+	request := authenticatedRequest(t, method, target, body)
+	request.Header.Set("Tus-Resumable", "1.0.0")
+	for name, value := range header {
+		request.Header.Set(name, value)
+	}
+	if method == http.MethodPatch {
+		request.Header.Set("Content-Type", "application/offset+octet-stream")
+	}
+	response, err := environment.server.Client().Do(request)
+	require.NoError(t, err)
+	readResponse(t, response)
+	return response
+}
+
+func tusMetadata(pairs ...string) string {
+	encoded := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		encoded = append(encoded, pairs[i]+" "+base64.StdEncoding.EncodeToString([]byte(pairs[i+1])))
+	}
+	return strings.Join(encoded, ",")
+}
+
+func TestTusUploadResumesAndIssuesKey(t *testing.T) {
+	environment := newTusIntegrationEnvironment(t)
+	options := tusRequest(t, environment, http.MethodOptions, "/file/tus/", nil, nil)
+	require.Equal(t, http.StatusNoContent, options.StatusCode)
+	require.Equal(t, "1.0.0", options.Header.Get("Tus-Version"))
+	require.Equal(t, "1024", options.Header.Get("Tus-Max-Size"))
+	require.Contains(t, options.Header.Get("Tus-Extension"), "checksum")
+
+	created := tusRequest(t, environment, http.MethodPost, "/file/tus/", nil, map[string]string{
+		"Upload-Length":   "11",
+		"Upload-Metadata": tusMetadata("filename", "hello.txt"),
+	})
+	require.Equal(t, http.StatusCreated, created.StatusCode)
+	location := created.Header.Get("Location")
+	require.True(t, strings.HasPrefix(location, "/file/tus/"), location)
+	require.NotEmpty(t, created.Header.Get("Upload-Expires"))
+
+	patched := tusRequest(t, environment, http.MethodPatch, location, strings.NewReader("hello"),
+		map[string]string{"Upload-Offset": "0"})
+	require.Equal(t, http.StatusNoContent, patched.StatusCode)
+	require.Equal(t, "5", patched.Header.Get("Upload-Offset"))
+
+	head := tusRequest(t, environment, http.MethodHead, location, nil, nil)
+	require.Equal(t, http.StatusOK, head.StatusCode)
+	require.Equal(t, "5", head.Header.Get("Upload-Offset"))
+	require.Equal(t, "11", head.Header.Get("Upload-Length"))
+	require.Equal(t, "no-store", head.Header.Get("Cache-Control"))
+
+	conflict := tusRequest(t, environment, http.MethodPatch, location, strings.NewReader(" world"),
+		map[string]string{"Upload-Offset": "0"})
+	require.Equal(t, http.StatusConflict, conflict.StatusCode)
+	mismatch := tusRequest(t, environment, http.MethodPatch, location, strings.NewReader(" world"),
+		map[string]string{"Upload-Offset": "5", "Upload-Checksum": "sha256 " + checksumOf("other")})
+	require.Equal(t, 460, mismatch.StatusCode)
+
+	finished := tusRequest(t, environment, http.MethodPatch, location, strings.NewReader(" world"),
+		map[string]string{"Upload-Offset": "5", "Upload-Checksum": "sha256 " + checksumOf(" world")})
+	require.Equal(t, http.StatusNoContent, finished.StatusCode)
+	require.Equal(t, "11", finished.Header.Get("Upload-Offset"))
+	key := finished.Header.Get("X-Tgfile-File-Key")
+	require.True(t, strings.HasSuffix(key, "-hello.txt"), key)
+
+	download, err := getResponse(t, environment.server.Client(), environment.server.URL+"/file/download/"+key)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, download.StatusCode)
+	require.Equal(t, "hello world", string(readResponse(t, download)))
+}
+
+func TestTusUploadPublishesPath(t *testing.T) {
+	environment := newTusIntegrationEnvironment(t)
+	require.NoError(t, environment.manager.CreateFileLink(t.Context(), "/docs", 0, 0, true))
+	header := map[string]string{"Upload-Length": "4", "Upload-Metadata": tusMetadata("path", "/docs/data.bin")}
+
+	request := authenticatedRequest(t, http.MethodPost, environment.server.URL+"/file/tus/", nil)
+	request.SetBasicAuth("fileonly", "file-secret")
+	request.Header.Set("Tus-Resumable", "1.0.0")
+	for name, value := range header {
+		request.Header.Set(name, value)
+	}
+	response, err := environment.server.Client().Do(request)
+	require.NoError(t, err)
+	readResponse(t, response)
+	require.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	created := tusRequest(t, environment, http.MethodPost, "/file/tus/", nil, header)
+	require.Equal(t, http.StatusCreated, created.StatusCode)
+	finished := tusRequest(t, environment, http.MethodPatch, created.Header.Get("Location"),
+		strings.NewReader("data"), map[string]string{"Upload-Offset": "0"})
+	require.Equal(t, http.StatusNoContent, finished.StatusCode)
+	require.Empty(t, finished.Header.Get("X-Tgfile-File-Key"))
+	meta, err := environment.manager.StatFileLink(t.Context(), "/docs/data.bin")
+	require.NoError(t, err)
+	require.EqualValues(t, 4, meta.FileSize)
+}
+
+func TestTusResolvesPathThroughWebDAVMounts(t *testing.T) {
+	environment := newIntegrationEnvironmentWithStorage(t, nil,
+		func(db database.IDatabase, manager filemgr.IFileManager) []server.Option {
+			store, err := uploadsession.New(db, manager, uploadsession.Options{
+				Dir: t.TempDir(), Expire: time.Hour, MaxSize: 1024,
+			})
+			require.NoError(t, err)
+			return []server.Option{
+				server.WithUploadSessions(store),
+				server.WithWebDAV(server.WebDAVOptions{
+					Enabled:            true,
+					MaxUploadSize:      1024,
+					UploadTempDir:      t.TempDir(),
+					MaxMutationEntries: 100,
+					SyncPageSize:       100,
+					Mounts: []server.WebDAVMountOptions{
+						{Name: "team", Root: "/shares/team", QuotaBytes: 4},
+						{Name: "docs", Root: "/shares/docs", ReadOnly: true},
+					},
+				}),
+			}
+		})
+	create := func(target string, size int) *http.Response {
+		return tusRequest(t, environment, http.MethodPost, "/file/tus/", nil, map[string]string{
+			"Upload-Length": strconv.Itoa(size), "Upload-Metadata": tusMetadata("path", target),
+		})
+	}
+
+	require.Equal(t, http.StatusForbidden, create("/docs/denied.bin", 1).StatusCode)
+	require.Equal(t, http.StatusBadRequest, create("/missing/a.bin", 1).StatusCode)
+	require.Equal(t, http.StatusBadRequest, create("/team/", 1).StatusCode)
+
+	created := create("/team/data.bin", 4)
+	require.Equal(t, http.StatusCreated, created.StatusCode)
+	finished := tusRequest(t, environment, http.MethodPatch, created.Header.Get("Location"),
+		strings.NewReader("data"), map[string]string{"Upload-Offset": "0"})
+	require.Equal(t, http.StatusNoContent, finished.StatusCode)
+	meta, err := environment.manager.StatFileLink(t.Context(), "/shares/team/data.bin")
+	require.NoError(t, err)
+	require.EqualValues(t, 4, meta.FileSize)
+
+	// The session keeps the principal and mount only; the quota of the share
+	// is looked up again when the upload is published.
+	created = create("/team/more.bin", 1)
+	require.Equal(t, 2, queryIntegrationCount(t, environment.database,
+		`SELECT COUNT(*) FROM tg_upload_session_tab WHERE webdav_scope = '{"principal":"access","mount":"team"}'`))
+	require.Equal(t, http.StatusCreated, created.StatusCode)
+	overQuota := tusRequest(t, environment, http.MethodPatch, created.Header.Get("Location"),
+		strings.NewReader("x"), map[string]string{"Upload-Offset": "0"})
+	require.Equal(t, http.StatusInsufficientStorage, overQuota.StatusCode)
+	_, err = environment.manager.StatFileLink(t.Context(), "/shares/team/more.bin")
+	require.Error(t, err)
+}
+
+func TestTusRejectsInvalidRequests(t *testing.T) {
+	environment := newTusIntegrationEnvironment(t)
+	request := authenticatedRequest(t, http.MethodPost, environment.server.URL+"/file/tus/", nil)
+	request.Header.Set("Upload-Length", "1")
+	response, err := environment.server.Client().Do(request)
+	require.NoError(t, err)
+	readResponse(t, response)
+	require.Equal(t, http.StatusPreconditionFailed, response.StatusCode)
+	require.Equal(t, "1.0.0", response.Header.Get("Tus-Version"))
+
+	for _, header := range []map[string]string{
+		{},
+		{"Upload-Length": "-1"},
+		{"Upload-Defer-Length": "1"},
+		{"Upload-Length": "1", "Upload-Metadata": "filename !!!"},
+	} {
+		response := tusRequest(t, environment, http.MethodPost, "/file/tus/", nil, header)
+		require.Equal(t, http.StatusBadRequest, response.StatusCode, header)
+	}
+	tooLarge := tusRequest(t, environment, http.MethodPost, "/file/tus/", nil,
+		map[string]string{"Upload-Length": "1025"})
+	require.Equal(t, http.StatusRequestEntityTooLarge, tooLarge.StatusCode)
+
+	created := tusRequest(t, environment, http.MethodPost, "/file/tus/", nil, map[string]string{"Upload-Length": "3"})
+	require.Equal(t, http.StatusCreated, created.StatusCode)
+	location := created.Header.Get("Location")
+	overflow := tusRequest(t, environment, http.MethodPatch, location, strings.NewReader("abcd"),
+		map[string]string{"Upload-Offset": "0", "Upload-Checksum": "sha256 " + checksumOf("abcd")})
+	require.Equal(t, http.StatusRequestEntityTooLarge, overflow.StatusCode)
+
+	plain := authenticatedRequest(t, http.MethodPatch, environment.server.URL+location, strings.NewReader("a"))
+	plain.Header.Set("Tus-Resumable", "1.0.0")
+	plain.Header.Set("Upload-Offset", "0")
+	response, err = environment.server.Client().Do(plain)
+	require.NoError(t, err)
+	readResponse(t, response)
+	require.Equal(t, http.StatusUnsupportedMediaType, response.StatusCode)
+
+	deleted := tusRequest(t, environment, http.MethodDelete, location, nil, nil)
+	require.Equal(t, http.StatusNoContent, deleted.StatusCode)
+	gone := tusRequest(t, environment, http.MethodHead, location, nil, nil)
+	require.Equal(t, http.StatusNotFound, gone.StatusCode)
+	require.Equal(t, http.StatusNotFound,
+		tusRequest(t, environment, http.MethodHead, "/file/tus/unknown", nil, nil).StatusCode)
+}
+
+func checksumOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,204 @@
+package server_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+func TestFileVersionsAreServedOverWebDAV(t *testing.T) {
+	environment := newIntegrationEnvironmentWithStorage(
+		t,
+		[]filemgr.Option{filemgr.WithVersions(filemgr.VersionOptions{KeepCount: 5})},
+		nil,
+	)
+	client := environment.server.Client()
+	objectURL := environment.server.URL + "/hackmd/notes/todo.txt"
+	webdavURL := environment.server.URL + "/webdav/hackmd/notes/todo.txt"
+	response, err := client.Do(authenticatedRequest(t, http.MethodPut, objectURL, bytes.NewReader([]byte("s3 first"))))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	_ = readResponse(t, response)
+	response, err = client.Do(authenticatedRequest(t, http.MethodPut, webdavURL, bytes.NewReader([]byte("dav second"))))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+	_ = readResponse(t, response)
+	response, err = client.Do(authenticatedRequest(t, http.MethodPut, objectURL, bytes.NewReader([]byte("s3 third"))))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	_ = readResponse(t, response)
+
+	report := authenticatedRequest(t, "REPORT", webdavURL, bytes.NewBufferString(
+		`<D:version-tree xmlns:D="DAV:"><D:prop><D:version-name/><D:getcontentlength/></D:prop></D:version-tree>`,
+	))
+	report.Header.Set("Depth", "0")
+	response, err = client.Do(report)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMultiStatus, response.StatusCode)
+	tree := string(readResponse(t, response))
+	hrefs := regexp.MustCompile(`>(/webdav/\.versions/\d+)</href>`).FindAllStringSubmatch(tree, -1)
+	require.Len(t, hrefs, 2)
+	require.Contains(t, tree, ">10</getcontentlength>")
+	require.Contains(t, tree, ">8</getcontentlength>")
+
+	newest := environment.server.URL + hrefs[0][1]
+	response, err = client.Do(authenticatedRequest(t, http.MethodGet, newest, nil))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, []byte("dav second"), readResponse(t, response))
+
+	restore := authenticatedRequest(t, "COPY", environment.server.URL+hrefs[1][1], nil)
+	restore.Header.Set("Destination", webdavURL)
+	restore.Header.Set("Overwrite", "F")
+	response, err = client.Do(restore)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusPreconditionFailed, response.StatusCode)
+	_ = readResponse(t, response)
+	restore.Header.Del("Overwrite")
+	response, err = client.Do(restore)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+	_ = readResponse(t, response)
+	response, err = client.Do(authenticatedRequest(t, http.MethodGet, webdavURL, nil))
+	require.NoError(t, err)
+	require.Equal(t, []byte("s3 first"), readResponse(t, response))
+
+	response, err = client.Do(authenticatedRequest(t, http.MethodPut, newest, bytes.NewReader([]byte("x"))))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, response.StatusCode)
+	require.Equal(t, "OPTIONS, GET, HEAD, PROPFIND, COPY", response.Header.Get("Allow"))
+	_ = readResponse(t, response)
+	response, err = client.Do(authenticatedRequest(
+		t, http.MethodGet, environment.server.URL+"/webdav/.versions/999", nil,
+	))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+	_ = readResponse(t, response)
+}
+
+func TestAdminFileVersionsListRestoreAndDelete(t *testing.T) {
+	environment := newAdminTestEnvironmentWithStorage(
+		t,
+		[]filemgr.Option{filemgr.WithVersions(filemgr.VersionOptions{KeepCount: 5})},
+	)
+	testServer := httptest.NewServer(environment.handler)
+	defer testServer.Close()
+	viewerClient := adminHTTPClient(t)
+	viewer := loginAdmin(t, viewerClient, testServer.URL, "viewer", "view-secret")
+	operatorClient := adminHTTPClient(t)
+	operator := loginAdmin(t, operatorClient, testServer.URL, "operator", "write-secret")
+	jsonHeaders := map[string]string{"Content-Type": "application/json"}
+	uploadAdminFile(
+		t, operatorClient, testServer.URL, operator,
+		"/uploads/plan.txt", []byte("draft one"), "*", http.StatusCreated,
+	)
+	uploadAdminFile(
+		t, operatorClient, testServer.URL, operator,
+		"/uploads/plan.txt", []byte("draft two"), statAdminEntry(
+			t, operatorClient, testServer.URL, "/uploads/plan.txt",
+		).ETag, http.StatusOK,
+	)
+
+	type versionList struct {
+		Enabled bool `json:"enabled"`
+		Items   []struct {
+			VersionID string `json:"version_id"`
+			Path      string `json:"path"`
+			Size      int64  `json:"size"`
+		} `json:"items"`
+	}
+	listURL := testServer.URL + "/_admin/api/v1/versions?path=/uploads/plan.txt"
+	response := doAdminRequest(t, viewerClient, http.MethodGet, listURL, nil, viewer, nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	list := decodeAdminData[versionList](t, response)
+	require.True(t, list.Enabled)
+	require.Len(t, list.Items, 1)
+	require.Equal(t, "/uploads/plan.txt", list.Items[0].Path)
+	require.Equal(t, int64(len("draft one")), list.Items[0].Size)
+	versionAPI := testServer.URL + "/_admin/api/v1/versions/" + list.Items[0].VersionID
+
+	response = doAdminRequest(t, viewerClient, http.MethodGet, versionAPI+"/content", nil, viewer, nil)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, []byte("draft one"), readResponse(t, response))
+	response = doAdminRequest(
+		t, viewerClient, http.MethodPost, versionAPI+"/restore",
+		bytes.NewBufferString(`{}`), viewer, jsonHeaders,
+	)
+	require.Equal(t, http.StatusForbidden, response.StatusCode)
+	closeResponse(t, response)
+	response = doAdminRequest(
+		t, operatorClient, http.MethodPost, versionAPI+"/restore",
+		bytes.NewBufferString(`{}`), operator, jsonHeaders,
+	)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	closeResponse(t, response)
+	require.Equal(t, []byte("draft one"), downloadAdminFile(
+		t, operatorClient, testServer.URL, operator, "/uploads/plan.txt",
+	))
+	response = doAdminRequest(t, viewerClient, http.MethodGet, listURL, nil, viewer, nil)
+	require.Len(t, decodeAdminData[versionList](t, response).Items, 2)
+
+	response = doAdminRequest(t, operatorClient, http.MethodDelete, versionAPI, nil, operator, nil)
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+	closeResponse(t, response)
+	response = doAdminRequest(t, operatorClient, http.MethodDelete, versionAPI, nil, operator, nil)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+	closeResponse(t, response)
+}
+
+func TestFileVersionsOfDeletedPathsFollowTheParentACL(t *testing.T) {
+	environment := newIntegrationEnvironmentWithStorage(
+		t,
+		[]filemgr.Option{filemgr.WithVersions(filemgr.VersionOptions{KeepCount: 5})},
+		nil,
+	)
+	client := environment.server.Client()
+	base := environment.server.URL + "/webdav"
+	request := func(method, target, body string) *http.Request {
+		return authenticatedRequest(t, method, target, bytes.NewBufferString(body))
+	}
+	asReader := func(target string) int {
+		readerRequest := request(http.MethodGet, target, "")
+		readerRequest.SetBasicAuth("reader", "reader-secret")
+		return doStatus(t, client, readerRequest)
+	}
+	require.Equal(t, http.StatusCreated, doStatus(t, client, request("MKCOL", base+"/private", "")))
+	require.Equal(t, http.StatusCreated,
+		doStatus(t, client, request(http.MethodPut, base+"/private/secret.txt", "first")))
+	require.Equal(t, http.StatusNoContent,
+		doStatus(t, client, request(http.MethodPut, base+"/private/secret.txt", "second")))
+	reportRequest := request("REPORT", base+"/private/secret.txt",
+		`<D:version-tree xmlns:D="DAV:"><D:prop><D:version-name/></D:prop></D:version-tree>`)
+	reportRequest.Header.Set("Depth", "0")
+	report, err := client.Do(reportRequest)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMultiStatus, report.StatusCode)
+	hrefs := regexp.MustCompile(`>(/webdav/\.versions/\d+)</href>`).FindAllStringSubmatch(
+		string(readResponse(t, report)), -1)
+	require.Len(t, hrefs, 1)
+	version := environment.server.URL + hrefs[0][1]
+	require.Equal(t, http.StatusOK, asReader(version))
+
+	denyReader := `<D:acl xmlns:D="DAV:"><D:ace><D:principal><D:href>/_principals/users/reader</D:href>` +
+		`</D:principal><D:deny><D:privilege><D:read/></D:privilege></D:deny></D:ace></D:acl>`
+	require.Equal(t, http.StatusOK, doStatus(t, client, request("ACL", base+"/private", denyReader)))
+	require.Equal(t, http.StatusForbidden, asReader(version))
+	require.Equal(t, http.StatusNoContent, doStatus(t, client,
+		request(http.MethodDelete, base+"/private/secret.txt", "")))
+	require.Equal(t, http.StatusForbidden, asReader(version))
+	reportRequest.SetBasicAuth("reader", "reader-secret")
+	reportRequest.Body = io.NopCloser(strings.NewReader(`<D:version-tree xmlns:D="DAV:"/>`))
+	require.Equal(t, http.StatusForbidden, doStatus(t, client, reportRequest))
+	response, err := client.Do(request(http.MethodGet, version, ""))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, []byte("first"), readResponse(t, response))
+}
@@ -0,0 +1,192 @@
+package server_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/server"
+)
+
+func TestWebDAVACLInheritanceAndEnforcement(t *testing.T) {
+	passwords := map[string]string{"editor": "secret", "other": "other-secret", "reader": "read-secret"}
+	environment := newWebDAVIntegrationEnvironment(
+		t,
+		passwords,
+		server.WebDAVOptions{
+			MaxUploadSize:      1024,
+			UploadTempDir:      t.TempDir(),
+			MaxMutationEntries: 100,
+			SyncPageSize:       100,
+			Groups:             map[string][]string{"staff": {"editor", "other"}},
+		},
+		1024,
+	)
+	client := environment.server.Client()
+	base := environment.server.URL + "/webdav"
+	do := func(username, method, target, body string, headers map[string]string) *webDAVTestResponse {
+		return doWebDAVRequest(t, client, username, passwords[username], method, target,
+			strings.NewReader(body), headers)
+	}
+	acl := func(username, target, aces string) *webDAVTestResponse {
+		return do(username, "ACL", base+target, `<D:acl xmlns:D="DAV:">`+aces+`</D:acl>`, nil)
+	}
+	ace := func(principal, kind, privileges string) string {
+		return `<D:ace><D:principal>` + principal + `</D:principal><D:` + kind + `>` + privileges +
+			`</D:` + kind + `></D:ace>`
+	}
+	userHref := func(name string) string {
+		return `<D:href>/_principals/users/` + name + `</D:href>`
+	}
+
+	requireWebDAVStatus(t, do("editor", "MKCOL", base+"/docs", "", nil), http.StatusCreated)
+	requireWebDAVStatus(t, do("editor", http.MethodPut, base+"/docs/a.txt", "alpha", nil), http.StatusCreated)
+	requireWebDAVStatus(t, do("editor", http.MethodPut, base+"/public.txt", "public", nil), http.StatusCreated)
+
+	options := do("editor", http.MethodOptions, base+"/docs/", "", nil)
+	requireWebDAVStatus(t, options, http.StatusOK)
+	require.Contains(t, options.Header.Get("DAV"), "access-control")
+	require.Contains(t, options.Header.Get("Allow"), "ACL")
+
+	writeDeny := ace(userHref("other"), "deny", `<D:privilege><D:write/></D:privilege>`)
+	require.Contains(t, string(requireWebDAVStatus(t, acl("reader", "/docs", writeDeny), http.StatusForbidden)),
+		"need-privileges")
+	requireWebDAVStatus(t, acl("editor", "/docs", writeDeny), http.StatusOK)
+
+	requireWebDAVStatus(t, do("other", http.MethodPut, base+"/docs/b.txt", "beta", nil), http.StatusForbidden)
+	requireWebDAVStatus(t, do("other", http.MethodPut, base+"/docs/a.txt", "beta", nil), http.StatusForbidden)
+	requireWebDAVStatus(t, do("other", "MKCOL", base+"/docs/sub", "", nil), http.StatusForbidden)
+	requireWebDAVStatus(t, do("other", http.MethodDelete, base+"/docs/a.txt", "", nil), http.StatusForbidden)
+	requireWebDAVStatus(t, do("other", http.MethodPut, base+"/other.txt", "ok", nil), http.StatusCreated)
+	requireWebDAVStatus(t, do("editor", http.MethodPut, base+"/docs/b.txt", "beta", nil), http.StatusCreated)
+	require.Equal(t, "alpha", string(requireWebDAVStatus(t,
+		do("other", http.MethodGet, base+"/docs/a.txt", "", nil), http.StatusOK)))
+
+	propfind := func(username, target, depth, properties string) string {
+		body := `<D:propfind xmlns:D="DAV:"><D:prop>` + properties + `</D:prop></D:propfind>`
+		return string(requireWebDAVStatus(t,
+			do(username, "PROPFIND", base+target, body, map[string]string{"Depth": depth}), http.StatusMultiStatus))
+	}
+	inherited := propfind("editor", "/docs/a.txt", "0", `<D:acl/>`)
+	require.Contains(t, inherited, "/_principals/users/other")
+	require.Contains(t, inherited, "<inherited")
+	require.Contains(t, inherited, "/webdav/docs/")
+	privileges := propfind("other", "/docs/a.txt", "0", `<D:current-user-privilege-set/>`)
+	require.Contains(t, privileges, "<read ")
+	require.NotContains(t, privileges, "<write-content ")
+	require.Contains(t, propfind("editor", "/docs/a.txt", "0", `<D:current-user-privilege-set/>`),
+		"<write-content ")
+	require.Contains(t, propfind("reader", "/docs/a.txt", "0", `<D:current-user-privilege-set/>`), "<read ")
+
+	readDeny := ace(`<D:href>/_principals/groups/staff</D:href>`, "deny", `<D:privilege><D:read/></D:privilege>`)
+	grantEditor := ace(userHref("editor"), "grant", `<D:privilege><D:all/></D:privilege>`)
+	requireWebDAVStatus(t, acl("editor", "/docs/b.txt", grantEditor+readDeny), http.StatusOK)
+	requireWebDAVStatus(t, do("other", http.MethodGet, base+"/docs/b.txt", "", nil), http.StatusForbidden)
+	require.Equal(t, "beta", string(requireWebDAVStatus(t,
+		do("editor", http.MethodGet, base+"/docs/b.txt", "", nil), http.StatusOK)))
+	listing := propfind("other", "/docs/", "1", `<D:getcontentlength/>`)
+	require.Contains(t, listing, "403 Forbidden")
+	require.Contains(t, listing, "/webdav/docs/a.txt")
+
+	invert := `<D:ace><D:invert><D:principal><D:all/></D:principal></D:invert>` +
+		`<D:grant><D:privilege><D:read/></D:privilege></D:grant></D:ace>`
+	require.Contains(t, string(requireWebDAVStatus(t, acl("editor", "/docs", invert), http.StatusForbidden)),
+		"no-invert")
+	unknown := ace(userHref("nobody"), "grant", `<D:privilege><D:read/></D:privilege>`)
+	require.Contains(t, string(requireWebDAVStatus(t, acl("editor", "/docs", unknown), http.StatusForbidden)),
+		"recognized-principal")
+	requireWebDAVStatus(t, acl("editor", "/missing", writeDeny), http.StatusNotFound)
+
+	requireWebDAVStatus(t, acl("editor", "/docs", ""), http.StatusOK)
+	requireWebDAVStatus(t, do("other", http.MethodPut, base+"/docs/c.txt", "gamma", nil), http.StatusCreated)
+	require.NotContains(t, propfind("editor", "/public.txt", "0", `<D:acl/>`), "<ace")
+}
+
+func TestWebDAVWriteACLDefaultsToOwnerAndAdmins(t *testing.T) {
+	passwords := map[string]string{"editor": "secret", "other": "other-secret", "admin": "admin-secret"}
+	environment := newWebDAVIntegrationEnvironment(
+		t,
+		passwords,
+		server.WebDAVOptions{Groups: map[string][]string{"staff": {"editor", "other"}}},
+		1024,
+	)
+	client := environment.server.Client()
+	base := environment.server.URL + "/webdav"
+	do := func(username, method, target, body string) *webDAVTestResponse {
+		return doWebDAVRequest(t, client, username, passwords[username], method, target,
+			strings.NewReader(body), map[string]string{"Depth": "0"})
+	}
+	acl := func(username, target, aces string) *webDAVTestResponse {
+		return do(username, "ACL", base+target, `<D:acl xmlns:D="DAV:">`+aces+`</D:acl>`)
+	}
+	privileges := func(username, target string) string {
+		body := `<D:propfind xmlns:D="DAV:"><D:prop><D:current-user-privilege-set/></D:prop></D:propfind>`
+		return string(requireWebDAVStatus(t, do(username, "PROPFIND", base+target, body), http.StatusMultiStatus))
+	}
+
+	requireWebDAVStatus(t, do("editor", "MKCOL", base+"/docs", ""), http.StatusCreated)
+	requireWebDAVStatus(t, do("editor", http.MethodPut, base+"/docs/a.txt", "alpha"), http.StatusCreated)
+	require.Contains(t, privileges("editor", "/docs"), "<write-acl ")
+	require.NotContains(t, privileges("other", "/docs"), "<write-acl ")
+	require.Contains(t, privileges("admin", "/docs"), "<write-acl ")
+	require.Contains(t, string(requireWebDAVStatus(t, acl("other", "/docs", ""), http.StatusForbidden)),
+		"need-privileges")
+	requireWebDAVStatus(t, do("other", http.MethodPut, base+"/docs/a.txt", "taken"), http.StatusNoContent)
+	require.NotContains(t, privileges("other", "/docs/a.txt"), "<write-acl ")
+	requireWebDAVStatus(t, acl("other", "/docs/a.txt", ""), http.StatusForbidden)
+	require.Contains(t, privileges("editor", "/docs/a.txt"), "<write-acl ")
+	requireWebDAVStatus(t, do("editor", http.MethodPut, base+"/docs/a.txt", "alpha"), http.StatusNoContent)
+
+	denyStaff := `<D:ace><D:principal><D:href>/_principals/groups/staff</D:href></D:principal>` +
+		`<D:deny><D:privilege><D:all/></D:privilege></D:deny></D:ace>`
+	requireWebDAVStatus(t, acl("editor", "/docs", denyStaff), http.StatusOK)
+	requireWebDAVStatus(t, acl("editor", "/docs", ""), http.StatusForbidden)
+	requireWebDAVStatus(t, do("editor", http.MethodGet, base+"/docs/a.txt", ""), http.StatusForbidden)
+	require.Equal(t, "alpha", string(requireWebDAVStatus(t,
+		do("admin", http.MethodGet, base+"/docs/a.txt", ""), http.StatusOK)))
+	requireWebDAVStatus(t, acl("admin", "/docs", ""), http.StatusOK)
+	require.Equal(t, "alpha", string(requireWebDAVStatus(t,
+		do("editor", http.MethodGet, base+"/docs/a.txt", ""), http.StatusOK)))
+}
+
+func TestWebDAVPrincipalPropertySearch(t *testing.T) {
+	passwords := map[string]string{"editor": "secret", "other": "other-secret"}
+	environment := newWebDAVIntegrationEnvironment(
+		t,
+		passwords,
+		server.WebDAVOptions{Groups: map[string][]string{"staff": {"editor"}}},
+		1024,
+	)
+	client := environment.server.Client()
+	search := `<D:principal-property-search xmlns:D="DAV:"><D:property-search><D:prop><D:displayname/></D:prop>` +
+		`<D:match>EDIT</D:match></D:property-search><D:prop><D:displayname/></D:prop>` +
+		`</D:principal-property-search>`
+
+	body := string(requireWebDAVStatus(t, doWebDAVRequest(t, client, "other", passwords["other"], "REPORT",
+		environment.server.URL+"/_principals/", strings.NewReader(search), map[string]string{"Depth": "0"}),
+		http.StatusMultiStatus))
+	require.Contains(t, body, "/_principals/users/editor")
+	require.NotContains(t, body, "/_principals/users/other")
+
+	body = string(requireWebDAVStatus(t, doWebDAVRequest(t, client, "other", passwords["other"], "REPORT",
+		environment.server.URL+"/webdav/", strings.NewReader(search), map[string]string{"Depth": "0"}),
+		http.StatusMultiStatus))
+	require.NotContains(t, body, "/_principals/users/editor")
+	applied := strings.Replace(search, `</D:principal-property-search>`,
+		`<D:apply-to-principal-collection-set/></D:principal-property-search>`, 1)
+	body = string(requireWebDAVStatus(t, doWebDAVRequest(t, client, "other", passwords["other"], "REPORT",
+		environment.server.URL+"/webdav/", strings.NewReader(applied), map[string]string{"Depth": "0"}),
+		http.StatusMultiStatus))
+	require.Contains(t, body, "/_principals/users/editor")
+
+	group := `<D:propfind xmlns:D="DAV:"><D:prop><D:group-member-set/></D:prop></D:propfind>`
+	body = string(requireWebDAVStatus(t, doWebDAVRequest(t, client, "other", passwords["other"], "PROPFIND",
+		environment.server.URL+"/_principals/groups/staff", strings.NewReader(group),
+		map[string]string{"Depth": "0"}), http.StatusMultiStatus))
+	require.Contains(t, body, "/_principals/users/editor")
+
+	requireWebDAVStatus(t, doWebDAVRequest(t, client, "", "", "PROPFIND",
+		environment.server.URL+"/_principals/", nil, map[string]string{"Depth": "0"}), http.StatusUnauthorized)
+}
@@ -0,0 +1,100 @@
+package server_test
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/server"
+)
+
+func TestWebDAVBindSharesOneResource(t *testing.T) {
+	passwords := map[string]string{"editor": "secret", "reader": "read-secret"}
+	environment := newWebDAVIntegrationEnvironment(
+		t,
+		passwords,
+		server.WebDAVOptions{
+			MaxUploadSize:      1024,
+			UploadTempDir:      t.TempDir(),
+			QuotaBytes:         10,
+			MaxMutationEntries: 100,
+			SyncPageSize:       100,
+		},
+		1024,
+	)
+	client := environment.server.Client()
+	base := environment.server.URL + "/webdav"
+	do := func(username, method, target, body string, headers map[string]string) *webDAVTestResponse {
+		return doWebDAVRequest(t, client, username, passwords[username], method, target,
+			strings.NewReader(body), headers)
+	}
+	bind := func(collection, segment, href string, headers map[string]string) *webDAVTestResponse {
+		body := `<D:bind xmlns:D="DAV:"><D:segment>` + segment + `</D:segment><D:href>` + href +
+			`</D:href></D:bind>`
+		return do("editor", "BIND", base+collection, body, headers)
+	}
+	propfind := func(target, properties string) string {
+		body := `<D:propfind xmlns:D="DAV:"><D:prop>` + properties + `</D:prop></D:propfind>`
+		return string(requireWebDAVStatus(t,
+			do("editor", "PROPFIND", base+target, body, map[string]string{"Depth": "0"}), http.StatusMultiStatus))
+	}
+	resourceID := regexp.MustCompile(`urn:tgfile:resource:\d+`)
+
+	requireWebDAVStatus(t, do("editor", "MKCOL", base+"/a", "", nil), http.StatusCreated)
+	requireWebDAVStatus(t, do("editor", "MKCOL", base+"/b", "", nil), http.StatusCreated)
+	requireWebDAVStatus(t, do("editor", http.MethodPut, base+"/a/doc.txt", "alpha1", nil), http.StatusCreated)
+	options := do("editor", http.MethodOptions, base+"/a/", "", nil)
+	require.Contains(t, options.Header.Get("DAV"), "bind")
+	require.Contains(t, options.Header.Get("Allow"), "REBIND")
+
+	requireWebDAVStatus(t, bind("/b/", "linked.txt", "/webdav/a/doc.txt", nil), http.StatusCreated)
+	require.Equal(t, "alpha1", string(requireWebDAVStatus(t,
+		do("reader", http.MethodGet, base+"/b/linked.txt", "", nil), http.StatusOK)))
+	parents := propfind("/a/doc.txt", `<D:resource-id/><D:parent-set/>`)
+	require.Contains(t, parents, "<segment xmlns=\"DAV:\">doc.txt</segment>")
+	require.Contains(t, parents, "<segment xmlns=\"DAV:\">linked.txt</segment>")
+	require.Contains(t, parents, "/webdav/b/")
+	require.Equal(t, resourceID.FindString(parents),
+		resourceID.FindString(propfind("/b/linked.txt", `<D:resource-id/>`)))
+	require.NotContains(t, propfind("/a/doc.txt", ""), "resource-id")
+
+	requireWebDAVStatus(t, do("editor", http.MethodPut, base+"/b/linked.txt", "beta", nil), http.StatusNoContent)
+	require.Equal(t, "beta", string(requireWebDAVStatus(t,
+		do("reader", http.MethodGet, base+"/a/doc.txt", "", nil), http.StatusOK)))
+	first := do("reader", http.MethodHead, base+"/a/doc.txt", "", nil)
+	second := do("reader", http.MethodHead, base+"/b/linked.txt", "", nil)
+	require.Equal(t, first.Header.Get("ETag"), second.Header.Get("ETag"))
+	patch := `<D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:test"><D:set><D:prop><Z:color>blue</Z:color>` +
+		`</D:prop></D:set></D:propertyupdate>`
+	requireWebDAVStatus(t, do("editor", "PROPPATCH", base+"/a/doc.txt", patch, nil), http.StatusMultiStatus)
+	require.Contains(t, propfind("/b/linked.txt", `<Z:color xmlns:Z="urn:test"/>`), "blue")
+
+	requireWebDAVStatus(t, bind("/b/", "linked.txt", "/webdav/a/doc.txt", map[string]string{"Overwrite": "F"}),
+		http.StatusPreconditionFailed)
+	require.Contains(t, string(requireWebDAVStatus(t, bind("/b/", "dir", "/webdav/a/", nil),
+		http.StatusForbidden)), "binding-allowed")
+	require.Contains(t, string(requireWebDAVStatus(t, bind("/b/", "gone.txt", "/webdav/a/missing.txt", nil),
+		http.StatusConflict)), "bind-source-exists")
+	require.Contains(t, string(requireWebDAVStatus(t, bind("/a/doc.txt", "x.txt", "/webdav/b/linked.txt", nil),
+		http.StatusForbidden)), "bind-into-collection")
+	requireWebDAVStatus(t, bind("/b/", "a%2Fb", "/webdav/a/doc.txt", nil), http.StatusBadRequest)
+
+	rebind := `<D:rebind xmlns:D="DAV:"><D:segment>moved.txt</D:segment>` +
+		`<D:href>/webdav/b/linked.txt</D:href></D:rebind>`
+	requireWebDAVStatus(t, do("editor", "REBIND", base+"/a/", rebind, nil), http.StatusCreated)
+	requireWebDAVStatus(t, do("reader", http.MethodGet, base+"/b/linked.txt", "", nil), http.StatusNotFound)
+	require.Contains(t, propfind("/a/doc.txt", `<D:parent-set/>`), "moved.txt")
+
+	unbind := `<D:unbind xmlns:D="DAV:"><D:segment>doc.txt</D:segment></D:unbind>`
+	requireWebDAVStatus(t, do("editor", "UNBIND", base+"/a/", unbind, nil), http.StatusOK)
+	require.Contains(t, string(requireWebDAVStatus(t, do("editor", "UNBIND", base+"/a/", unbind, nil),
+		http.StatusConflict)), "unbind-source-exists")
+	require.Equal(t, "beta", string(requireWebDAVStatus(t,
+		do("reader", http.MethodGet, base+"/a/moved.txt", "", nil), http.StatusOK)))
+	requireWebDAVStatus(t, bind("/", "root.txt", "/webdav/a/moved.txt", nil), http.StatusCreated)
+	requireWebDAVStatus(t, bind("/b/", "other.txt", "/webdav/a/moved.txt", nil), http.StatusCreated)
+	requireWebDAVStatus(t, do("reader", "BIND", base+"/b/", "", nil), http.StatusForbidden)
+}
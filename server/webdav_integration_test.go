@@ -19,6 +19,7 @@ import (
 	"github.com/xxxsen/tgfile/db"
 	"github.com/xxxsen/tgfile/filemgr"
 	"github.com/xxxsen/tgfile/server"
+	"github.com/xxxsen/tgfile/uploadsession"
 )
 
 func newWebDAVIntegrationEnvironment(
@@ -53,12 +54,26 @@ func newWebDAVIntegrationEnvironment(
 			webDAVPermission = authz.WebDAVRead
 		}
 		permissions[username] = []string{string(webDAVPermission), string(authz.S3Write)}
+		if username == "admin" {
+			permissions[username] = append(permissions[username], string(authz.AdminWrite))
+		}
 	}
 	authorizer := testAuthorizer(t, permissions)
 	options.Enabled = true
 	if options.Root == "" {
 		options.Root = "/"
 	}
+	maxUploadSize := options.MaxUploadSize
+	if maxUploadSize <= 0 {
+		maxUploadSize = 1 << 30
+	}
+	uploads, err := uploadsession.New(databaseClient, manager, uploadsession.Options{
+		Dir:     t.TempDir(),
+		Expire:  24 * time.Hour,
+		MaxSize: maxUploadSize,
+		Origin:  uploadsession.OriginWebDAV,
+	})
+	require.NoError(t, err)
 	handler, err := server.New(
 		"127.0.0.1:0",
 		server.WithUser(users),
@@ -71,6 +86,7 @@ func newWebDAVIntegrationEnvironment(
 			}},
 		}),
 		server.WithWebDAV(options),
+		server.WithWebDAVUploadSessions(uploads),
 		server.WithFileManager(manager),
 	)
 	require.NoError(t, err)
@@ -160,7 +176,7 @@ func TestWebDAVClassOnePropertiesAndCrossProtocolLifecycle(t *testing.T) {
 		nil,
 	)
 	requireWebDAVStatus(t, response, http.StatusOK)
-	require.Equal(t, "1, 2, sync-collection", response.Header.Get("DAV"))
+	require.Equal(t, "1, 2, access-control, bind, sync-collection", response.Header.Get("DAV"))
 	require.Contains(t, response.Header.Get("Allow"), "PROPPATCH")
 	require.Contains(t, response.Header.Get("Allow"), "LOCK")
 
@@ -0,0 +1,86 @@
+package server_test
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/server"
+)
+
+func TestWebDAVHomesAndSharesStayWithinMount(t *testing.T) {
+	environment := newWebDAVIntegrationEnvironment(
+		t,
+		map[string]string{"editor": "secret", "other": "other-secret", "reader": "read-secret"},
+		server.WebDAVOptions{
+			MaxUploadSize:      1024,
+			UploadTempDir:      t.TempDir(),
+			MaxMutationEntries: 100,
+			SyncPageSize:       100,
+			Mounts: []server.WebDAVMountOptions{
+				{Name: "home", Root: "/home/{user}"},
+				{Name: "team", Root: "/shares/team", QuotaBytes: 16},
+				{Name: "docs", Root: "/shares/docs", ReadOnly: true},
+			},
+		},
+		1024,
+	)
+	client := environment.server.Client()
+	base := environment.server.URL + "/webdav"
+	do := func(username, method, target, body string, headers map[string]string) *webDAVTestResponse {
+		passwords := map[string]string{"editor": "secret", "other": "other-secret", "reader": "read-secret"}
+		return doWebDAVRequest(t, client, username, passwords[username], method, target,
+			strings.NewReader(body), headers)
+	}
+
+	requireWebDAVStatus(t, do("editor", http.MethodPut, base+"/home/private.txt", "mine", nil), http.StatusCreated)
+	require.Equal(t, []byte("mine"),
+		requireWebDAVStatus(t, do("editor", http.MethodGet, base+"/home/private.txt", "", nil), http.StatusOK))
+	requireWebDAVStatus(t, do("other", http.MethodGet, base+"/home/private.txt", "", nil), http.StatusNotFound)
+	_, err := environment.manager.StatFileLink(t.Context(), "/home/editor/private.txt")
+	require.NoError(t, err)
+
+	for _, method := range []string{"COPY", "MOVE"} {
+		response := do("editor", method, base+"/home/private.txt", "", map[string]string{
+			"Destination": environment.server.URL + "/webdav/team/escaped.txt",
+		})
+		requireWebDAVStatus(t, response, http.StatusBadRequest)
+	}
+	requireWebDAVStatus(t, do("editor", "COPY", base+"/home/private.txt", "", map[string]string{
+		"Destination": "/webdav/home/",
+	}), http.StatusForbidden)
+	requireWebDAVStatus(t, do("editor", http.MethodDelete, base+"/team/", "", nil), http.StatusForbidden)
+	_, err = environment.manager.StatFileLink(t.Context(), "/shares/team/escaped.txt")
+	require.Error(t, err)
+
+	requireWebDAVStatus(t, do("editor", http.MethodPut, base+"/docs/denied.txt", "x", nil), http.StatusForbidden)
+	requireWebDAVStatus(t, do("editor", "MKCOL", base+"/docs/denied", "", nil), http.StatusForbidden)
+	requireWebDAVStatus(t, do("reader", "PROPFIND", base+"/docs/", "", map[string]string{"Depth": "1"}),
+		http.StatusMultiStatus)
+	requireWebDAVStatus(t, do("editor", http.MethodPut, base+"/team/big.bin", strings.Repeat("x", 32), nil),
+		http.StatusInsufficientStorage)
+	requireWebDAVStatus(t, do("editor", http.MethodGet, base+"/missing/a.txt", "", nil), http.StatusNotFound)
+
+	index := string(requireWebDAVStatus(t, do("reader", "PROPFIND", base+"/", "", map[string]string{"Depth": "1"}),
+		http.StatusMultiStatus))
+	for _, href := range []string{"/webdav/home/", "/webdav/team/", "/webdav/docs/"} {
+		require.Contains(t, index, `<href xmlns="DAV:">`+href+"</href>")
+	}
+	requireWebDAVStatus(t, do("editor", "MKCOL", base+"/new", "", nil), http.StatusNotFound)
+	requireWebDAVStatus(t, do("editor", http.MethodDelete, base+"/", "", nil), http.StatusMethodNotAllowed)
+
+	reportBody := `<D:sync-collection xmlns:D="DAV:"><D:sync-token/>` +
+		`<D:sync-level>1</D:sync-level><D:prop><D:getetag/></D:prop></D:sync-collection>`
+	report := string(requireWebDAVStatus(t, do("editor", "REPORT", base+"/team/", reportBody,
+		map[string]string{"Depth": "0"}), http.StatusMultiStatus))
+	token := regexp.MustCompile(`urn:tgfile:webdav-sync:team:\d+`).FindString(report)
+	require.NotEmpty(t, token, report)
+	replayed := strings.Replace(reportBody, "<D:sync-token/>", "<D:sync-token>"+token+"</D:sync-token>", 1)
+	requireWebDAVStatus(t, do("editor", "REPORT", base+"/team/", replayed,
+		map[string]string{"Depth": "0"}), http.StatusMultiStatus)
+	require.Contains(t, string(requireWebDAVStatus(t, do("editor", "REPORT", base+"/home/", replayed,
+		map[string]string{"Depth": "0"}), http.StatusForbidden)), "valid-sync-token")
+}
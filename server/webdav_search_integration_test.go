@@ -0,0 +1,149 @@
+package server_test
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/server"
+)
+
+func TestWebDAVSearchBasicsearch(t *testing.T) {
+	environment := newWebDAVIntegrationEnvironment(
+		t,
+		map[string]string{"editor": "secret", "reader": "read-secret"},
+		server.WebDAVOptions{
+			MaxUploadSize:      1024,
+			UploadTempDir:      t.TempDir(),
+			MaxMutationEntries: 100,
+			SyncPageSize:       100,
+		},
+		1024,
+	)
+	client := environment.server.Client()
+	base := environment.server.URL + "/webdav"
+	do := func(username, method, target, body string, headers map[string]string) *webDAVTestResponse {
+		passwords := map[string]string{"editor": "secret", "reader": "read-secret"}
+		return doWebDAVRequest(t, client, username, passwords[username], method, target,
+			strings.NewReader(body), headers)
+	}
+	for _, collection := range []string{"/docs", "/docs/sub", "/other"} {
+		requireWebDAVStatus(t, do("editor", "MKCOL", base+collection, "", nil), http.StatusCreated)
+	}
+	for name, size := range map[string]int{
+		"/docs/a.pdf": 10, "/docs/sub/b.PDF": 100, "/docs/sub/c.txt": 50, "/other/d.pdf": 5,
+	} {
+		requireWebDAVStatus(t, do("editor", http.MethodPut, base+name, strings.Repeat("x", size), nil),
+			http.StatusCreated)
+	}
+
+	options := do("reader", http.MethodOptions, base+"/docs/", "", nil)
+	requireWebDAVStatus(t, options, http.StatusOK)
+	require.Equal(t, "<DAV:basicsearch>", options.Header.Get("DASL"))
+	require.Contains(t, options.Header.Get("Allow"), "SEARCH")
+
+	search := func(scope, depth, where, extra string) string {
+		body := `<D:searchrequest xmlns:D="DAV:"><D:basicsearch>` +
+			`<D:select><D:prop><D:getcontentlength/><D:displayname/></D:prop></D:select>` +
+			`<D:from><D:scope><D:href>` + scope + `</D:href><D:depth>` + depth + `</D:depth></D:scope></D:from>` +
+			where + extra + `</D:basicsearch></D:searchrequest>`
+		return string(requireWebDAVStatus(t, do("reader", "SEARCH", base+"/", body, nil), http.StatusMultiStatus))
+	}
+	hrefs := func(body string) []string {
+		var result []string
+		for _, match := range regexp.MustCompile(`<href xmlns="DAV:">([^<]*)</href>`).FindAllStringSubmatch(body, -1) {
+			result = append(result, match[1])
+		}
+		return result
+	}
+	sizeOrder := `<D:orderby><D:order><D:prop><D:getcontentlength/></D:prop><D:descending/></D:order></D:orderby>`
+
+	pdf := `<D:where><D:like><D:prop><D:displayname/></D:prop><D:literal>%.pdf</D:literal></D:like></D:where>`
+	require.Equal(t, []string{"/webdav/docs/sub/b.PDF", "/webdav/docs/a.pdf"},
+		hrefs(search("/webdav/docs/", "infinity", pdf, sizeOrder)))
+	require.Equal(t, []string{"/webdav/docs/a.pdf"}, hrefs(search("/webdav/docs/", "1", pdf, "")))
+	require.Equal(t, []string{"/webdav/docs/sub/b.PDF"},
+		hrefs(search("docs/", "infinity", pdf, sizeOrder+`<D:limit><D:nresults>1</D:nresults></D:limit>`)))
+
+	large := `<D:where><D:and><D:not><D:is-collection/></D:not>` +
+		`<D:gt><D:prop><D:getcontentlength/></D:prop><D:literal>20</D:literal></D:gt></D:and></D:where>`
+	require.Equal(t, []string{"/webdav/docs/sub/b.PDF", "/webdav/docs/sub/c.txt"},
+		hrefs(search("/webdav/", "infinity", large, sizeOrder)))
+
+	collections := `<D:where><D:is-collection/></D:where>`
+	require.Equal(t, []string{"/webdav/docs/", "/webdav/docs/sub/"},
+		hrefs(search("/webdav/docs", "infinity", collections, "")))
+	require.Equal(t, []string{"/webdav/docs/sub/"},
+		hrefs(search("/webdav/docs/sub", "0", collections, "")))
+
+	recent := `<D:where><D:gte><D:prop><D:getlastmodified/></D:prop>` +
+		`<D:literal>Mon, 01 Jan 2024 00:00:00 GMT</D:literal></D:gte></D:where>`
+	require.Len(t, hrefs(search("/webdav/other/", "infinity", recent, "")), 2)
+	future := strings.Replace(recent, "gte>", "gt>", 2)
+	future = strings.Replace(future, "2024", "2999", 1)
+	require.Empty(t, hrefs(search("/webdav/other/", "infinity", future, "")))
+
+	invalid := func(body string) {
+		requireWebDAVStatus(t, do("reader", "SEARCH", base+"/", body, nil), http.StatusBadRequest)
+	}
+	invalid("")
+	invalid(`<D:searchrequest xmlns:D="DAV:"><D:basicsearch><D:select><D:allprop/></D:select>` +
+		`<D:from><D:scope><D:href>/elsewhere/</D:href></D:scope></D:from></D:basicsearch></D:searchrequest>`)
+	invalid(`<D:searchrequest xmlns:D="DAV:"><D:basicsearch><D:select><D:allprop/></D:select>` +
+		`<D:from><D:scope><D:href>/webdav/</D:href></D:scope></D:from><D:where><D:like><D:prop>` +
+		`<D:getetag/></D:prop><D:literal>x</D:literal></D:like></D:where></D:basicsearch></D:searchrequest>`)
+	invalid(`<D:searchrequest xmlns:D="DAV:"><D:basicsearch><D:select><D:allprop/></D:select>` +
+		`<D:from><D:scope><D:href>/webdav/</D:href></D:scope></D:from><D:where><D:lt><D:prop>` +
+		`<D:displayname/></D:prop><D:literal>x</D:literal></D:lt></D:where></D:basicsearch></D:searchrequest>`)
+}
+
+func TestWebDAVSearchFillsTheLimitPastHiddenMatches(t *testing.T) {
+	environment := newWebDAVIntegrationEnvironment(
+		t,
+		map[string]string{"editor": "secret", "reader": "read-secret"},
+		server.WebDAVOptions{
+			MaxUploadSize:      1024,
+			UploadTempDir:      t.TempDir(),
+			MaxMutationEntries: 100,
+			SyncPageSize:       100,
+		},
+		1024,
+	)
+	client := environment.server.Client()
+	base := environment.server.URL + "/webdav"
+	do := func(username, method, target, body string) *webDAVTestResponse {
+		passwords := map[string]string{"editor": "secret", "reader": "read-secret"}
+		return doWebDAVRequest(t, client, username, passwords[username], method, target,
+			strings.NewReader(body), nil)
+	}
+	for _, collection := range []string{"/docs", "/docs/private"} {
+		requireWebDAVStatus(t, do("editor", "MKCOL", base+collection, ""), http.StatusCreated)
+	}
+	for name, size := range map[string]int{
+		"/docs/private/a.pdf": 40, "/docs/private/b.pdf": 30, "/docs/private/c.pdf": 20, "/docs/d.pdf": 10,
+	} {
+		requireWebDAVStatus(t, do("editor", http.MethodPut, base+name, strings.Repeat("x", size)),
+			http.StatusCreated)
+	}
+	requireWebDAVStatus(t, do("editor", "ACL", base+"/docs/private",
+		`<D:acl xmlns:D="DAV:"><D:ace><D:principal><D:href>/_principals/users/reader</D:href></D:principal>`+
+			`<D:deny><D:privilege><D:read/></D:privilege></D:deny></D:ace></D:acl>`), http.StatusOK)
+
+	search := func(username, extra string) string {
+		body := `<D:searchrequest xmlns:D="DAV:"><D:basicsearch>` +
+			`<D:select><D:prop><D:displayname/></D:prop></D:select>` +
+			`<D:from><D:scope><D:href>/webdav/docs/</D:href><D:depth>infinity</D:depth></D:scope></D:from>` +
+			`<D:where><D:not><D:is-collection/></D:not></D:where>` +
+			`<D:orderby><D:order><D:prop><D:getcontentlength/></D:prop><D:descending/></D:order></D:orderby>` +
+			extra + `</D:basicsearch></D:searchrequest>`
+		return string(requireWebDAVStatus(t, do(username, "SEARCH", base+"/", body), http.StatusMultiStatus))
+	}
+	limitOne := `<D:limit><D:nresults>1</D:nresults></D:limit>`
+	require.Contains(t, search("reader", limitOne), "/webdav/docs/d.pdf")
+	require.NotContains(t, search("reader", ""), "/webdav/docs/private/")
+	require.NotContains(t, search("reader", ""), "507")
+	require.Contains(t, search("editor", limitOne), "/webdav/docs/private/a.pdf")
+}
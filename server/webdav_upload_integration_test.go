@@ -0,0 +1,97 @@
+package server_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/server"
+)
+
+func TestWebDAVResumablePut(t *testing.T) {
+	environment := newWebDAVIntegrationEnvironment(
+		t,
+		map[string]string{"editor": "secret", "other": "other-secret"},
+		server.WebDAVOptions{
+			MaxUploadSize:      64,
+			UploadTempDir:      t.TempDir(),
+			MaxMutationEntries: 100,
+			SyncPageSize:       100,
+		},
+		4,
+	)
+	client := environment.server.Client()
+	target := environment.server.URL + "/webdav/resumable.txt"
+	do := func(username, method, body string, headers map[string]string) *webDAVTestResponse {
+		passwords := map[string]string{"editor": "secret", "other": "other-secret"}
+		return doWebDAVRequest(t, client, username, passwords[username], method, target,
+			strings.NewReader(body), headers)
+	}
+	chunk := func(username, body, contentRange string) *webDAVTestResponse {
+		return do(username, http.MethodPut, body, map[string]string{"Content-Range": contentRange})
+	}
+
+	unpublishedParts := func() int {
+		return queryIntegrationCount(t, environment.database, `SELECT COUNT(*) FROM tg_file_part_tab
+WHERE file_id IN (SELECT file_id FROM tg_upload_session_tab WHERE completed_at = 0)`)
+	}
+
+	response := chunk("editor", "hello ", "bytes 0-5/12")
+	requireWebDAVStatus(t, response, http.StatusAccepted)
+	require.Equal(t, "0-5", response.Header.Get("X-Received-Ranges"))
+	require.Equal(t, 1, unpublishedParts(), "a full block is uploaded before the last range arrives")
+	requireWebDAVStatus(t, do("editor", http.MethodGet, "", nil), http.StatusNotFound)
+
+	// Ranges are taken in order: a gap is refused with the progress so far.
+	response = chunk("editor", "d!", "bytes 10-11/12")
+	requireWebDAVStatus(t, response, http.StatusRequestedRangeNotSatisfiable)
+	require.Equal(t, "0-5", response.Header.Get("X-Received-Ranges"))
+	status := chunk("editor", "", "bytes */12")
+	requireWebDAVStatus(t, status, http.StatusAccepted)
+	require.Equal(t, "0-5", status.Header.Get("X-Received-Ranges"))
+	otherStatus := chunk("other", "", "bytes */12")
+	requireWebDAVStatus(t, otherStatus, http.StatusAccepted)
+	require.Empty(t, otherStatus.Header.Get("X-Received-Ranges"))
+
+	requireWebDAVStatus(t, chunk("editor", "short", "bytes 6-11/12"), http.StatusBadRequest)
+	requireWebDAVStatus(t, chunk("editor", "x", "bytes 12-12/12"), http.StatusBadRequest)
+	requireWebDAVStatus(t, chunk("editor", "x", "bytes 0-0/65"), http.StatusRequestEntityTooLarge)
+
+	// A range overlapping the received bytes only contributes the rest.
+	requireWebDAVStatus(t, chunk("editor", "o world!", "bytes 4-11/12"), http.StatusCreated)
+	require.Equal(t, []byte("hello world!"),
+		requireWebDAVStatus(t, do("editor", http.MethodGet, "", nil), http.StatusOK))
+	require.Zero(t, unpublishedParts())
+
+	// A different total restarts the session instead of mixing two uploads.
+	requireWebDAVStatus(t, chunk("editor", "abcd", "bytes 0-3/6"), http.StatusAccepted)
+	require.Equal(t, 1, unpublishedParts())
+	response = chunk("editor", "xyz", "bytes 0-2/8")
+	requireWebDAVStatus(t, response, http.StatusAccepted)
+	require.Equal(t, "0-2", response.Header.Get("X-Received-Ranges"))
+	require.Zero(t, unpublishedParts())
+	require.Empty(t, chunk("editor", "", "bytes */6").Header.Get("X-Received-Ranges"))
+
+	// The request that supplies the last byte publishes with its own
+	// preconditions; resending the last range retries a failed publication.
+	conditional := environment.server.URL + "/webdav/conditional.txt"
+	requireWebDAVStatus(t, doWebDAVRequest(t, client, "editor", "secret", http.MethodPut, conditional,
+		strings.NewReader("old"), nil), http.StatusCreated)
+	last := func(headers map[string]string) *webDAVTestResponse {
+		headers["Content-Range"] = "bytes 0-4/5"
+		return doWebDAVRequest(t, client, "editor", "secret", http.MethodPut, conditional,
+			strings.NewReader("fresh"), headers)
+	}
+	requireWebDAVStatus(t, last(map[string]string{"If-None-Match": "*"}), http.StatusPreconditionFailed)
+	requireWebDAVStatus(t, last(map[string]string{}), http.StatusNoContent)
+	require.Equal(t, []byte("fresh"), requireWebDAVStatus(t, doWebDAVRequest(t, client, "editor", "secret",
+		http.MethodGet, conditional, nil, nil), http.StatusOK))
+
+	// Partial updates are refused rather than applied as a whole-file PUT.
+	requireWebDAVStatus(t, do("editor", http.MethodPut, "HELLO",
+		map[string]string{"X-Update-Range": "bytes=0-4"}), http.StatusNotImplemented)
+	require.Equal(t, []byte("hello world!"),
+		requireWebDAVStatus(t, do("editor", http.MethodGet, "", nil), http.StatusOK))
+}
@@ -0,0 +1,453 @@
+// Package sharelink stores the public share links of namespace paths. A link
+// is reached at /s/<token>; only a SHA-256 digest of the token and a PBKDF2
+// hash of the optional password are stored, so a database copy alone cannot
+// open a link.
+package sharelink
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xxxsen/common/database"
+
+	"github.com/xxxsen/tgfile/entity"
+)
+
+var (
+	ErrInvalidRequest = errors.New("invalid share link request")
+	ErrNotFound       = errors.New("share link does not exist")
+	ErrExpired        = errors.New("share link has expired")
+	ErrExhausted      = errors.New("share link download limit reached")
+	ErrNotDirectory   = errors.New("share link listing requires a directory")
+)
+
+const (
+	// RoutePrefix is the path the links are served under.
+	RoutePrefix = "/s/"
+
+	// MaxExpires bounds the lifetime of an expiring link.
+	MaxExpires       = 10 * 365 * 24 * time.Hour
+	MaxPasswordBytes = 256
+	MaxPathBytes     = 4096
+
+	idPrefix           = "TGSL"
+	randomIDBytes      = 10
+	randomTokenBytes   = 24
+	passwordScheme     = "pbkdf2-sha256"
+	passwordIterations = 600_000
+	passwordSaltBytes  = 16
+	passwordKeyBytes   = 32
+)
+
+type CreateRequest struct {
+	Owner string
+	Path  string
+	// Expires is the lifetime of the link; zero never expires.
+	Expires  time.Duration
+	Password string
+	// MaxDownloads bounds the file downloads through the link; zero is
+	// unlimited.
+	MaxDownloads int64
+	// Listing serves an index page when Path is a directory.
+	Listing bool
+}
+
+type Link struct {
+	ID           string     `json:"id"`
+	Owner        string     `json:"owner"`
+	Path         string     `json:"path"`
+	Listing      bool       `json:"listing"`
+	HasPassword  bool       `json:"has_password"`
+	MaxDownloads int64      `json:"max_downloads"`
+	Downloads    int64      `json:"downloads"`
+	Views        int64      `json:"views"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	LastAccessAt *time.Time `json:"last_access_at,omitempty"`
+	Revoked      bool       `json:"revoked"`
+
+	passwordHash string
+}
+
+// Created is returned exactly once, when the link is created. The token
+// cannot be recovered from the database afterwards.
+type Created struct {
+	*Link
+
+	Token string `json:"token"`
+	// URLPath is the path of the link below the service origin.
+	URLPath string `json:"url_path"`
+}
+
+// PathStater looks up the shared paths.
+type PathStater interface {
+	StatFileLink(ctx context.Context, link string) (*entity.FileLinkMeta, error)
+}
+
+type Store struct {
+	db    database.IDatabase
+	files PathStater
+	now   func() time.Time
+}
+
+func New(db database.IDatabase, files PathStater) *Store {
+	return &Store{db: db, files: files, now: time.Now}
+}
+
+// Create shares an existing path. A missing path reports os.ErrNotExist.
+func (s *Store) Create(ctx context.Context, request CreateRequest) (*Created, error) {
+	now := s.now()
+	if err := validateCreateRequest(&request); err != nil {
+		return nil, err
+	}
+	info, err := s.files.StatFileLink(ctx, request.Path)
+	if err != nil {
+		return nil, fmt.Errorf("stat shared path: %w", err)
+	}
+	if request.Listing && !info.IsDir {
+		return nil, ErrNotDirectory
+	}
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	passwordHash := ""
+	if request.Password != "" {
+		if passwordHash, err = hashPassword(request.Password); err != nil {
+			return nil, err
+		}
+	}
+	var expiresAt int64
+	if request.Expires != 0 {
+		expiresAt = now.Add(request.Expires).UnixMilli()
+	}
+	if _, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO tg_share_link_tab (
+    share_id, token_sha256, owner, link_path, password_hash, allow_listing, max_downloads, created_at, expires_at
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id,
+		tokenDigest(token),
+		request.Owner,
+		request.Path,
+		passwordHash,
+		boolInt(request.Listing),
+		request.MaxDownloads,
+		now.UnixMilli(),
+		expiresAt,
+	); err != nil {
+		return nil, fmt.Errorf("insert share link: %w", err)
+	}
+	link := &Link{
+		ID:           id,
+		Owner:        request.Owner,
+		Path:         request.Path,
+		Listing:      request.Listing,
+		HasPassword:  passwordHash != "",
+		MaxDownloads: request.MaxDownloads,
+		CreatedAt:    time.UnixMilli(now.UnixMilli()).UTC(),
+		ExpiresAt:    millisTime(expiresAt),
+	}
+	return &Created{Link: link, Token: token, URLPath: RoutePrefix + token}, nil
+}
+
+// Resolve returns the link of token. Unknown and revoked links report
+// ErrNotFound; ErrExpired and ErrExhausted tell a visitor why a link they
+// were given stopped working.
+func (s *Store) Resolve(ctx context.Context, token string) (*Link, error) {
+	if token == "" || len(token) > base64.RawURLEncoding.EncodedLen(randomTokenBytes) {
+		return nil, ErrNotFound
+	}
+	link, err := s.read(ctx, `token_sha256 = ?`, tokenDigest(token))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case link.Revoked:
+		return nil, ErrNotFound
+	case link.ExpiresAt != nil && !s.now().Before(*link.ExpiresAt):
+		return nil, ErrExpired
+	case link.MaxDownloads != 0 && link.Downloads >= link.MaxDownloads:
+		return nil, ErrExhausted
+	}
+	return link, nil
+}
+
+// CheckPassword reports whether password opens the link. Links without a
+// password accept any value.
+func (l *Link) CheckPassword(password string) bool {
+	if l.passwordHash == "" {
+		return true
+	}
+	return verifyPassword(l.passwordHash, password)
+}
+
+// RecordDownload counts one file download. It fails with ErrExhausted when
+// the link ran out of downloads, expired or was revoked since it was
+// resolved, so concurrent downloads never exceed the limit.
+func (s *Store) RecordDownload(ctx context.Context, id string) error {
+	now := s.now().UnixMilli()
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE tg_share_link_tab SET download_count = download_count + 1, last_access_at = ?
+WHERE share_id = ? AND revoked_at = 0 AND (expires_at = 0 OR expires_at > ?)
+AND (max_downloads = 0 OR download_count < max_downloads)`,
+		now,
+		id,
+		now,
+	)
+	if err != nil {
+		return fmt.Errorf("record share link download: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("read share link download count: %w", err)
+	}
+	if affected == 0 {
+		return ErrExhausted
+	}
+	return nil
+}
+
+// RecordView counts one listing page view.
+func (s *Store) RecordView(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(
+		ctx,
+		`UPDATE tg_share_link_tab SET view_count = view_count + 1, last_access_at = ? WHERE share_id = ?`,
+		s.now().UnixMilli(),
+		id,
+	); err != nil {
+		return fmt.Errorf("record share link view: %w", err)
+	}
+	return nil
+}
+
+// List returns the links of owner, newest first. An empty owner lists the
+// links of every principal.
+func (s *Store) List(ctx context.Context, owner string) ([]*Link, error) {
+	query := `SELECT ` + linkColumns + ` FROM tg_share_link_tab`
+	args := []any{}
+	if owner != "" {
+		query += ` WHERE owner = ?`
+		args = append(args, owner)
+	}
+	query += ` ORDER BY created_at DESC, share_id`
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query share links: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	links := make([]*Link, 0)
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate share links: %w", err)
+	}
+	return links, nil
+}
+
+// Revoke disables a link immediately; revoking it again succeeds. An empty
+// owner revokes a link of any principal and is reserved for administrators.
+func (s *Store) Revoke(ctx context.Context, owner, id string) error {
+	query := `UPDATE tg_share_link_tab SET revoked_at = ? WHERE share_id = ? AND revoked_at = 0`
+	args := []any{s.now().UnixMilli(), id}
+	if owner != "" {
+		query += ` AND owner = ?`
+		args = append(args, owner)
+	}
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("revoke share link: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("read revoked share link count: %w", err)
+	}
+	if affected != 0 {
+		return nil
+	}
+	link, err := s.read(ctx, `share_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if owner != "" && link.Owner != owner {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const linkColumns = `share_id, owner, link_path, password_hash, allow_listing, max_downloads, download_count,
+view_count, created_at, expires_at, last_access_at, revoked_at`
+
+func (s *Store) read(ctx context.Context, condition string, value string) (*Link, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+linkColumns+` FROM tg_share_link_tab WHERE `+condition,
+		value,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query share link: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("read share link: %w", err)
+		}
+		return nil, ErrNotFound
+	}
+	return scanLink(rows)
+}
+
+func scanLink(rows *sql.Rows) (*Link, error) {
+	var (
+		link                                          Link
+		listing                                       int
+		createdAt, expiresAt, lastAccessAt, revokedAt int64
+	)
+	if err := rows.Scan(
+		&link.ID,
+		&link.Owner,
+		&link.Path,
+		&link.passwordHash,
+		&listing,
+		&link.MaxDownloads,
+		&link.Downloads,
+		&link.Views,
+		&createdAt,
+		&expiresAt,
+		&lastAccessAt,
+		&revokedAt,
+	); err != nil {
+		return nil, fmt.Errorf("scan share link: %w", err)
+	}
+	link.Listing = listing != 0
+	link.HasPassword = link.passwordHash != ""
+	link.CreatedAt = time.UnixMilli(createdAt).UTC()
+	link.ExpiresAt = millisTime(expiresAt)
+	link.LastAccessAt = millisTime(lastAccessAt)
+	link.Revoked = revokedAt != 0
+	return &link, nil
+}
+
+func validateCreateRequest(request *CreateRequest) error {
+	if request.Owner == "" {
+		return fmt.Errorf("%w: owner is required", ErrInvalidRequest)
+	}
+	if !strings.HasPrefix(request.Path, "/") || path.Clean(request.Path) != request.Path ||
+		len(request.Path) > MaxPathBytes || strings.ContainsRune(request.Path, 0) {
+		return fmt.Errorf("%w: path must be a clean absolute path", ErrInvalidRequest)
+	}
+	if request.Expires < 0 || request.Expires > MaxExpires || (request.Expires != 0 && request.Expires < time.Second) {
+		return fmt.Errorf("%w: lifetime must be between 1s and %s", ErrInvalidRequest, MaxExpires)
+	}
+	if request.MaxDownloads < 0 {
+		return fmt.Errorf("%w: max downloads must not be negative", ErrInvalidRequest)
+	}
+	if len(request.Password) > MaxPasswordBytes {
+		return fmt.Errorf("%w: password is longer than %d bytes", ErrInvalidRequest, MaxPasswordBytes)
+	}
+	return nil
+}
+
+func randomID() (string, error) {
+	raw := make([]byte, randomIDBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate share link id: %w", err)
+	}
+	return idPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw), nil
+}
+
+func randomToken() (string, error) {
+	raw := make([]byte, randomTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate share link token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func tokenDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generate share link password salt: %w", err)
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, passwordKeyBytes)
+	if err != nil {
+		return "", fmt.Errorf("hash share link password: %w", err)
+	}
+	return strings.Join([]string{
+		passwordScheme,
+		strconv.Itoa(passwordIterations),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	}, "$"), nil
+}
+
+func verifyPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(want) == 0 {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+func millisTime(value int64) *time.Time {
+	if value == 0 {
+		return nil
+	}
+	result := time.UnixMilli(value).UTC()
+	return &result
+}
+
+func boolInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,185 @@
+package sharelink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/db"
+	"github.com/xxxsen/tgfile/entity"
+)
+
+type fakePaths map[string]bool
+
+func (f fakePaths) StatFileLink(_ context.Context, link string) (*entity.FileLinkMeta, error) {
+	isDir, ok := f[link]
+	if !ok {
+		return nil, fmt.Errorf("stat %s: %w", link, os.ErrNotExist)
+	}
+	return &entity.FileLinkMeta{IsDir: isDir}, nil
+}
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	database, err := db.Open(filepath.Join(t.TempDir(), "data.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, database.Close())
+	})
+	return New(database, fakePaths{"/docs": true, "/docs/a.txt": false})
+}
+
+func TestCreateResolveAndRevoke(t *testing.T) {
+	store := newTestStore(t)
+	created, err := store.Create(t.Context(), CreateRequest{Owner: "sharer", Path: "/docs", Listing: true})
+	require.NoError(t, err)
+	require.Equal(t, RoutePrefix+created.Token, created.URLPath)
+	require.True(t, strings.HasPrefix(created.ID, idPrefix))
+	require.Nil(t, created.ExpiresAt)
+
+	rows, err := store.db.QueryContext(t.Context(), `SELECT share_id FROM tg_share_link_tab WHERE token_sha256 = ?`,
+		created.Token)
+	require.NoError(t, err)
+	require.False(t, rows.Next())
+	require.NoError(t, rows.Close())
+
+	link, err := store.Resolve(t.Context(), created.Token)
+	require.NoError(t, err)
+	require.Equal(t, "/docs", link.Path)
+	require.True(t, link.Listing)
+	require.True(t, link.CheckPassword(""))
+	_, err = store.Resolve(t.Context(), created.Token+"x")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.ErrorIs(t, store.Revoke(t.Context(), "other", created.ID), ErrNotFound)
+	require.NoError(t, store.Revoke(t.Context(), "sharer", created.ID))
+	require.NoError(t, store.Revoke(t.Context(), "sharer", created.ID))
+	require.ErrorIs(t, store.Revoke(t.Context(), "", "TGSLMISSING"), ErrNotFound)
+	_, err = store.Resolve(t.Context(), created.Token)
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestCreateRejectsInvalidRequests(t *testing.T) {
+	store := newTestStore(t)
+	for _, request := range []CreateRequest{
+		{Path: "/docs"},
+		{Owner: "sharer", Path: "docs"},
+		{Owner: "sharer", Path: "/docs/../docs"},
+		{Owner: "sharer", Path: "/docs", Expires: -time.Second},
+		{Owner: "sharer", Path: "/docs", Expires: MaxExpires + time.Second},
+		{Owner: "sharer", Path: "/docs", MaxDownloads: -1},
+		{Owner: "sharer", Path: "/docs", Password: strings.Repeat("p", MaxPasswordBytes+1)},
+	} {
+		_, err := store.Create(t.Context(), request)
+		require.ErrorIs(t, err, ErrInvalidRequest, request)
+	}
+	_, err := store.Create(t.Context(), CreateRequest{Owner: "sharer", Path: "/missing"})
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = store.Create(t.Context(), CreateRequest{Owner: "sharer", Path: "/docs/a.txt", Listing: true})
+	require.ErrorIs(t, err, ErrNotDirectory)
+}
+
+func TestPasswordIsHashed(t *testing.T) {
+	store := newTestStore(t)
+	created, err := store.Create(t.Context(), CreateRequest{Owner: "sharer", Path: "/docs/a.txt", Password: "s3cret"})
+	require.NoError(t, err)
+	require.True(t, created.HasPassword)
+
+	var stored string
+	rows, err := store.db.QueryContext(t.Context(), `SELECT password_hash FROM tg_share_link_tab`)
+	require.NoError(t, err)
+	require.True(t, rows.Next())
+	require.NoError(t, rows.Scan(&stored))
+	require.NoError(t, rows.Close())
+	require.NotContains(t, stored, "s3cret")
+	require.True(t, strings.HasPrefix(stored, passwordScheme+"$"))
+
+	link, err := store.Resolve(t.Context(), created.Token)
+	require.NoError(t, err)
+	require.True(t, link.CheckPassword("s3cret"))
+	require.False(t, link.CheckPassword("S3cret"))
+	require.False(t, link.CheckPassword(""))
+}
+
+func TestExpiryAndDownloadLimit(t *testing.T) {
+	store := newTestStore(t)
+	now := time.UnixMilli(1_700_000_000_000)
+	store.now = func() time.Time { return now }
+	created, err := store.Create(t.Context(), CreateRequest{
+		Owner:        "sharer",
+		Path:         "/docs/a.txt",
+		Expires:      time.Hour,
+		MaxDownloads: 2,
+	})
+	require.NoError(t, err)
+	require.Equal(t, now.Add(time.Hour).UTC(), *created.ExpiresAt)
+
+	require.NoError(t, store.RecordDownload(t.Context(), created.ID))
+	require.NoError(t, store.RecordView(t.Context(), created.ID))
+	link, err := store.Resolve(t.Context(), created.Token)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, link.Downloads)
+	require.EqualValues(t, 1, link.Views)
+	require.NotNil(t, link.LastAccessAt)
+
+	require.NoError(t, store.RecordDownload(t.Context(), created.ID))
+	require.ErrorIs(t, store.RecordDownload(t.Context(), created.ID), ErrExhausted)
+	_, err = store.Resolve(t.Context(), created.Token)
+	require.ErrorIs(t, err, ErrExhausted)
+
+	other, err := store.Create(t.Context(), CreateRequest{Owner: "sharer", Path: "/docs", Expires: time.Hour})
+	require.NoError(t, err)
+	now = now.Add(time.Hour)
+	_, err = store.Resolve(t.Context(), other.Token)
+	require.ErrorIs(t, err, ErrExpired)
+	require.ErrorIs(t, store.RecordDownload(t.Context(), other.ID), ErrExhausted)
+}
+
+func TestConcurrentDownloadsRespectLimit(t *testing.T) {
+	store := newTestStore(t)
+	created, err := store.Create(t.Context(), CreateRequest{Owner: "sharer", Path: "/docs/a.txt", MaxDownloads: 3})
+	require.NoError(t, err)
+	var (
+		wait      sync.WaitGroup
+		lock      sync.Mutex
+		succeeded int
+	)
+	for range 10 {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			if store.RecordDownload(context.Background(), created.ID) == nil {
+				lock.Lock()
+				succeeded++
+				lock.Unlock()
+			}
+		}()
+	}
+	wait.Wait()
+	require.Equal(t, 3, succeeded)
+}
+
+func TestListFiltersByOwner(t *testing.T) {
+	store := newTestStore(t)
+	first, err := store.Create(t.Context(), CreateRequest{Owner: "alice", Path: "/docs"})
+	require.NoError(t, err)
+	second, err := store.Create(t.Context(), CreateRequest{Owner: "bob", Path: "/docs/a.txt"})
+	require.NoError(t, err)
+
+	links, err := store.List(t.Context(), "alice")
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	require.Equal(t, first.ID, links[0].ID)
+
+	links, err = store.List(t.Context(), "")
+	require.NoError(t, err)
+	ids := []string{links[0].ID, links[1].ID}
+	require.ElementsMatch(t, []string{first.ID, second.ID}, ids)
+}
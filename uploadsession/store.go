@@ -0,0 +1,782 @@
+// Package uploadsession keeps resumable uploads across requests and restarts.
+// An upload is an unpublished file draft: every block it fills is written to
+// the backend at once, and the bytes after the last full block wait in a
+// file below the session directory. The session row records how far both
+// got, so an upload resumes from the last byte that reached disk.
+package uploadsession
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xxxsen/common/database"
+	"github.com/xxxsen/common/logutil"
+	"go.uber.org/zap"
+
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+var (
+	ErrInvalidRequest = errors.New("invalid upload session request")
+	ErrNotFound       = errors.New("upload session not found")
+	ErrOffsetMismatch = errors.New("upload offset does not match")
+	ErrBusy           = errors.New("upload session is being written")
+	ErrTooLarge       = errors.New("upload exceeds its length")
+)
+
+const (
+	// MaxMetadataBytes bounds the raw metadata kept with a session.
+	MaxMetadataBytes = 4096
+	// MaxFileNameBytes bounds the file name of a session.
+	MaxFileNameBytes = 255
+
+	idBytes        = 16
+	workerInterval = 10 * time.Minute
+	expireBatch    = 100
+	cleanupTimeout = 30 * time.Second
+	// chunkPrefix names the request bodies that handlers stage in the session
+	// directory; the worker removes the ones a crash left behind.
+	chunkPrefix = "chunk-"
+)
+
+const (
+	// OriginTUS sessions are created and addressed by id.
+	OriginTUS = "tus"
+	// OriginWebDAV sessions are resumable WebDAV PUTs, found by owner and
+	// target path; an owner has one unfinished session per path.
+	OriginWebDAV = "webdav"
+)
+
+type Options struct {
+	// Dir holds the bytes of each session that have not filled a block.
+	Dir string
+	// Expire is how long a session lives after its last write. A finished
+	// session keeps its row, and its key, for the same time.
+	Expire time.Duration
+	// MaxSize bounds the length of one upload.
+	MaxSize int64
+	// Origin is the kind of sessions the store keeps, OriginTUS when empty.
+	// Stores of different origins share the table but not their sessions.
+	Origin string
+}
+
+type CreateRequest struct {
+	Owner    string
+	FileName string
+	// TargetPath publishes the finished upload at this namespace path; empty
+	// issues a direct-download key instead.
+	TargetPath string
+	// WebDAV is the scope the upload is published at TargetPath with.
+	WebDAV filemgr.WebDAVScope
+	// Metadata is kept verbatim and returned with the session.
+	Metadata string
+	Size     int64
+}
+
+type Session struct {
+	ID         string
+	Owner      string
+	FileName   string
+	TargetPath string
+	Metadata   string
+	Size       int64
+	Offset     int64
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	Completed  bool
+	// FileKey is the direct-download key of an upload without a target
+	// path. Like every key it is only stored as a digest, so it is set on
+	// the session returned by the write that finishes the upload only.
+	FileKey string
+	// Published is the publication of an upload with a target path, set like
+	// FileKey.
+	Published *filemgr.WebDAVPublishResult
+
+	fileID      uint64
+	blockSize   int64
+	blockCount  int64
+	webdavScope string
+}
+
+type Store struct {
+	db      database.IDatabase
+	files   filemgr.IFileManager
+	webdav  filemgr.IWebDAVScopeResolver
+	options Options
+	now     func() time.Time
+	busy    sync.Map
+}
+
+func New(db database.IDatabase, files filemgr.IFileManager, options Options) (*Store, error) {
+	if options.Dir == "" || options.Expire <= 0 || options.MaxSize <= 0 {
+		return nil, fmt.Errorf("%w: directory, expiry and size limit are required", ErrInvalidRequest)
+	}
+	if options.Origin == "" {
+		options.Origin = OriginTUS
+	}
+	if options.Origin != OriginTUS && options.Origin != OriginWebDAV {
+		return nil, fmt.Errorf("%w: unknown origin %q", ErrInvalidRequest, options.Origin)
+	}
+	if err := os.MkdirAll(options.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create upload session directory: %w", err)
+	}
+	return &Store{db: db, files: files, options: options, now: time.Now}, nil
+}
+
+// SetWebDAVScopeResolver lets the store accept uploads with a target path and
+// resolves their scope through resolver when they are published. It must be
+// called before the store serves requests or runs.
+func (s *Store) SetWebDAVScopeResolver(resolver filemgr.IWebDAVScopeResolver) {
+	s.webdav = resolver
+}
+
+// MaxSize is the largest upload a session accepts.
+func (s *Store) MaxSize() int64 {
+	return s.options.MaxSize
+}
+
+// CreateChunkFile creates a temporary file for one request body in the
+// session directory, so staged bodies share its disk and its cleanup.
+func (s *Store) CreateChunkFile() (*os.File, error) {
+	file, err := os.CreateTemp(s.options.Dir, chunkPrefix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("create upload chunk file: %w", err)
+	}
+	return file, nil
+}
+
+// Create starts an upload. An empty upload is finished at once.
+func (s *Store) Create(ctx context.Context, request CreateRequest) (*Session, error) {
+	if err := s.validateCreateRequest(request); err != nil {
+		return nil, err
+	}
+	scope := "{}"
+	if request.TargetPath != "" {
+		var err error
+		if scope, err = request.WebDAV.Encode(); err != nil {
+			return nil, fmt.Errorf("encode upload scope: %w", err)
+		}
+	}
+	fileID, blockSize, err := s.files.CreateFileDraft(ctx, request.Size)
+	if err != nil {
+		return nil, fmt.Errorf("create upload draft: %w", err)
+	}
+	id, err := randomID()
+	if err != nil {
+		return nil, errors.Join(err, s.discard(ctx, fileID))
+	}
+	now := s.now()
+	session := &Session{
+		ID:          id,
+		Owner:       request.Owner,
+		FileName:    request.FileName,
+		TargetPath:  request.TargetPath,
+		Metadata:    request.Metadata,
+		Size:        request.Size,
+		CreatedAt:   time.UnixMilli(now.UnixMilli()).UTC(),
+		ExpiresAt:   time.UnixMilli(now.Add(s.options.Expire).UnixMilli()).UTC(),
+		fileID:      fileID,
+		blockSize:   blockSize,
+		webdavScope: scope,
+	}
+	if _, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO tg_upload_session_tab (
+    upload_id, origin, owner, file_id, file_name, target_path, webdav_scope, metadata, upload_size,
+    block_size, created_at, updated_at, expires_at
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		session.ID,
+		s.options.Origin,
+		session.Owner,
+		session.fileID,
+		session.FileName,
+		session.TargetPath,
+		session.webdavScope,
+		session.Metadata,
+		session.Size,
+		session.blockSize,
+		now.UnixMilli(),
+		now.UnixMilli(),
+		session.ExpiresAt.UnixMilli(),
+	); err != nil {
+		// Two first requests for the same WebDAV path race for its session.
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			err = ErrBusy
+		}
+		return nil, errors.Join(fmt.Errorf("insert upload session: %w", err), s.discard(ctx, fileID))
+	}
+	if session.Size == 0 {
+		if err := s.complete(ctx, session, nil); err != nil {
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// Get returns the session id of owner. Unknown sessions, sessions of another
+// owner and unfinished sessions past their expiry report ErrNotFound.
+func (s *Store) Get(ctx context.Context, owner, id string) (*Session, error) {
+	session, err := s.read(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if session.Owner != owner || (!session.Completed && !s.now().Before(session.ExpiresAt)) {
+		return nil, ErrNotFound
+	}
+	return session, nil
+}
+
+// Find returns the unfinished session of owner at targetPath. Sessions past
+// their expiry report ErrNotFound.
+func (s *Store) Find(ctx context.Context, owner, targetPath string) (*Session, error) {
+	session, err := s.findUnfinished(ctx, owner, targetPath)
+	if err != nil {
+		return nil, err
+	}
+	if !s.now().Before(session.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return session, nil
+}
+
+// Resume returns the unfinished session of request.Owner at
+// request.TargetPath, or creates one. A session of another length is
+// discarded first, so a client that starts over with a different file does
+// not mix the two.
+func (s *Store) Resume(ctx context.Context, request CreateRequest) (*Session, error) {
+	if request.TargetPath == "" {
+		return nil, fmt.Errorf("%w: resumed uploads need a target path", ErrInvalidRequest)
+	}
+	session, err := s.findUnfinished(ctx, request.Owner, request.TargetPath)
+	if errors.Is(err, ErrNotFound) {
+		return s.Create(ctx, request)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.Size == request.Size && s.now().Before(session.ExpiresAt) {
+		return session, nil
+	}
+	unlock, err := s.lock(session.ID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.remove(ctx, session)
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+	return s.Create(ctx, request)
+}
+
+// Write appends reader to the session at offset, which has to be the
+// current offset. The bytes that arrive before reader fails are kept, so a
+// client resumes after a dropped connection by asking for the offset again.
+// The write that supplies the last byte publishes the upload; when that
+// fails, a write of no bytes at the final offset retries it.
+func (s *Store) Write(ctx context.Context, owner, id string, offset int64, reader io.Reader) (*Session, error) {
+	return s.WriteConditional(ctx, owner, id, offset, reader, nil)
+}
+
+// WriteConditional is Write with the preconditions and lock tokens of a
+// WebDAV request, checked when the write publishes the upload at its target
+// path.
+func (s *Store) WriteConditional(
+	ctx context.Context,
+	owner, id string,
+	offset int64,
+	reader io.Reader,
+	condition *filemgr.WebDAVCondition,
+) (*Session, error) {
+	unlock, err := s.lock(id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	session, err := s.Get(ctx, owner, id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != session.Offset {
+		return nil, ErrOffsetMismatch
+	}
+	if session.Offset < session.Size {
+		if err := s.append(ctx, session, reader); err != nil {
+			return nil, err
+		}
+	}
+	// The bytes up to the length are kept; the upload is only published
+	// once a request ends exactly there.
+	if n, _ := reader.Read(make([]byte, 1)); n != 0 {
+		return nil, ErrTooLarge
+	}
+	if session.Offset == session.Size && !session.Completed {
+		if err := s.complete(ctx, session, condition); err != nil {
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// Delete ends a session. The draft of an unfinished upload is discarded; a
+// finished upload stays published.
+func (s *Store) Delete(ctx context.Context, owner, id string) error {
+	unlock, err := s.lock(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	session, err := s.Get(ctx, owner, id)
+	if err != nil {
+		return err
+	}
+	return s.remove(ctx, session)
+}
+
+// Run expires idle sessions until ctx ends.
+func (s *Store) Run(ctx context.Context) error {
+	s.runPass(ctx)
+	ticker := time.NewTicker(workerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.runPass(ctx)
+		}
+	}
+}
+
+func (s *Store) runPass(ctx context.Context) {
+	if _, err := s.expire(ctx); err != nil {
+		logutil.GetLogger(ctx).Error("expire upload sessions failed", zap.Error(err))
+	}
+	if err := s.removeOrphanFiles(ctx); err != nil {
+		logutil.GetLogger(ctx).Error("remove orphan upload files failed", zap.Error(err))
+	}
+}
+
+// append copies reader into the spool file and uploads each block it fills.
+// The offset is committed after every block and once more when reader ends.
+func (s *Store) append(ctx context.Context, session *Session, reader io.Reader) error {
+	spool, err := os.OpenFile(s.spoolPath(session.ID), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("open upload spool: %w", err)
+	}
+	defer func() {
+		if err := spool.Close(); err != nil {
+			logutil.GetLogger(ctx).Error("close upload spool failed", zap.Error(err))
+		}
+	}()
+	// A crash after writing the spool but before committing the offset
+	// leaves bytes the client will send again.
+	if err := resetSpool(spool, session.Offset-session.blockCount*session.blockSize); err != nil {
+		return err
+	}
+	for session.Offset < session.Size {
+		blockStart := session.blockCount * session.blockSize
+		blockEnd := min(blockStart+session.blockSize, session.Size)
+		copied, copyErr := io.CopyN(spool, reader, blockEnd-session.Offset)
+		session.Offset += copied
+		if copyErr != nil {
+			return s.commitSpool(ctx, session, spool, copyErr)
+		}
+		if err := s.uploadBlock(ctx, session, spool, blockEnd-blockStart); err != nil {
+			return errors.Join(err, s.commitSpool(ctx, session, spool, nil))
+		}
+	}
+	return nil
+}
+
+// commitSpool records the bytes written to the spool after the last block.
+// readErr is the reason the copy stopped; io.EOF ends a request normally.
+func (s *Store) commitSpool(ctx context.Context, session *Session, spool *os.File, readErr error) error {
+	if err := spool.Sync(); err != nil {
+		return errors.Join(readErr, fmt.Errorf("sync upload spool: %w", err))
+	}
+	if err := s.commit(ctx, session); err != nil {
+		return errors.Join(readErr, err)
+	}
+	if errors.Is(readErr, io.EOF) {
+		return nil
+	}
+	if readErr != nil {
+		return fmt.Errorf("read upload body: %w", readErr)
+	}
+	return nil
+}
+
+func (s *Store) uploadBlock(ctx context.Context, session *Session, spool *os.File, size int64) error {
+	stored, err := s.blockStored(ctx, session)
+	if err != nil {
+		return err
+	}
+	// A crash between storing a block and committing the offset makes the
+	// client send the block again; the copy already stored is kept.
+	if !stored {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("rewind upload spool: %w", err)
+		}
+		if err := s.files.CreateFilePart(ctx, session.fileID, session.blockCount, io.LimitReader(spool, size)); err != nil {
+			return fmt.Errorf("upload block %d: %w", session.blockCount, err)
+		}
+	}
+	session.blockCount++
+	if err := s.commit(ctx, session); err != nil {
+		return err
+	}
+	return resetSpool(spool, 0)
+}
+
+func (s *Store) blockStored(ctx context.Context, session *Session) (bool, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT 1 FROM tg_file_part_tab WHERE file_id = ? AND file_part_id = ?`,
+		session.fileID,
+		session.blockCount,
+	)
+	if err != nil {
+		return false, fmt.Errorf("query upload block: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	stored := rows.Next()
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("iterate upload block: %w", err)
+	}
+	return stored, nil
+}
+
+func (s *Store) commit(ctx context.Context, session *Session) error {
+	now := s.now()
+	expiresAt := time.UnixMilli(now.Add(s.options.Expire).UnixMilli()).UTC()
+	if _, err := s.db.ExecContext(
+		ctx,
+		`UPDATE tg_upload_session_tab
+SET upload_offset = ?, block_count = ?, updated_at = ?, expires_at = ?
+WHERE upload_id = ?`,
+		session.Offset,
+		session.blockCount,
+		now.UnixMilli(),
+		expiresAt.UnixMilli(),
+		session.ID,
+	); err != nil {
+		return fmt.Errorf("update upload session offset: %w", err)
+	}
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
+// complete publishes a fully written upload and keeps the row until it
+// expires.
+func (s *Store) complete(ctx context.Context, session *Session, condition *filemgr.WebDAVCondition) error {
+	if err := s.files.FinishFileCreate(ctx, session.fileID); err != nil {
+		return fmt.Errorf("finish upload file: %w", err)
+	}
+	if err := s.publish(ctx, session, condition); err != nil {
+		return err
+	}
+	now := s.now()
+	expiresAt := time.UnixMilli(now.Add(s.options.Expire).UnixMilli()).UTC()
+	if _, err := s.db.ExecContext(
+		ctx,
+		`UPDATE tg_upload_session_tab
+SET completed_at = ?, updated_at = ?, expires_at = ?
+WHERE upload_id = ?`,
+		now.UnixMilli(),
+		now.UnixMilli(),
+		expiresAt.UnixMilli(),
+		session.ID,
+	); err != nil {
+		return fmt.Errorf("mark upload session complete: %w", err)
+	}
+	session.Completed = true
+	session.ExpiresAt = expiresAt
+	s.removeSpool(ctx, session.ID)
+	return nil
+}
+
+// publish links the finished file at the target path, with the options its
+// scope resolves to now, or issues a direct-download key.
+func (s *Store) publish(ctx context.Context, session *Session, condition *filemgr.WebDAVCondition) error {
+	if session.TargetPath == "" {
+		key, err := s.files.CreateFileKey(ctx, session.Owner, session.FileName, session.fileID, session.Size)
+		if err != nil {
+			return fmt.Errorf("create upload key: %w", err)
+		}
+		session.FileKey = key
+		return nil
+	}
+	if s.webdav == nil {
+		return fmt.Errorf("%w: WebDAV is not enabled", ErrInvalidRequest)
+	}
+	scope, err := filemgr.DecodeWebDAVScope(session.webdavScope)
+	if err != nil {
+		return fmt.Errorf("read upload scope: %w", err)
+	}
+	options, err := s.webdav.ResolveScope(ctx, scope, session.TargetPath)
+	if err != nil {
+		return fmt.Errorf("resolve upload scope: %w", err)
+	}
+	options.Condition = condition
+	result, err := s.files.PublishWebDAVFile(ctx, session.TargetPath, session.fileID, session.Size, options)
+	if err != nil {
+		return fmt.Errorf("publish upload: %w", err)
+	}
+	session.Published = result
+	return nil
+}
+
+// expire removes sessions past their expiry and returns how many it
+// removed. Sessions being written are left for the next pass.
+func (s *Store) expire(ctx context.Context) (int, error) {
+	ids, err := s.expiredIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, id := range ids {
+		unlock, err := s.lock(id)
+		if err != nil {
+			continue
+		}
+		session, err := s.read(ctx, id)
+		if err == nil && !s.now().Before(session.ExpiresAt) {
+			err = s.remove(ctx, session)
+			if err == nil {
+				removed++
+			}
+		}
+		unlock()
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+func (s *Store) expiredIDs(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT upload_id FROM tg_upload_session_tab
+WHERE origin = ? AND expires_at <= ? ORDER BY expires_at LIMIT ?`,
+		s.options.Origin,
+		s.now().UnixMilli(),
+		expireBatch,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query expired upload sessions: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan expired upload session: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate expired upload sessions: %w", err)
+	}
+	return ids, nil
+}
+
+func (s *Store) remove(ctx context.Context, session *Session) error {
+	if !session.Completed {
+		if err := s.discard(ctx, session.fileID); err != nil {
+			return err
+		}
+	}
+	if _, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM tg_upload_session_tab WHERE upload_id = ?`,
+		session.ID,
+	); err != nil {
+		return fmt.Errorf("delete upload session: %w", err)
+	}
+	s.removeSpool(ctx, session.ID)
+	return nil
+}
+
+// removeOrphanFiles deletes spool files without a session and chunk files
+// older than the session lifetime, which only a crash leaves behind.
+func (s *Store) removeOrphanFiles(ctx context.Context) error {
+	entries, err := os.ReadDir(s.options.Dir)
+	if err != nil {
+		return fmt.Errorf("read upload session directory: %w", err)
+	}
+	cutoff := s.now().Add(-s.options.Expire)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || info.ModTime().After(cutoff) {
+			continue
+		}
+		if !strings.HasPrefix(entry.Name(), chunkPrefix) {
+			if _, err := s.read(ctx, entry.Name()); !errors.Is(err, ErrNotFound) {
+				continue
+			}
+		}
+		if err := os.Remove(filepath.Join(s.options.Dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove orphan upload file: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) discard(ctx context.Context, fileID uint64) error {
+	cleanupContext, cancel := context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+	defer cancel()
+	if err := s.files.DiscardUnpublishedFile(cleanupContext, fileID); err != nil {
+		return fmt.Errorf("discard upload draft: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) removeSpool(ctx context.Context, id string) {
+	if err := os.Remove(s.spoolPath(id)); err != nil && !os.IsNotExist(err) {
+		logutil.GetLogger(ctx).Error("remove upload spool failed", zap.Error(err))
+	}
+}
+
+func (s *Store) spoolPath(id string) string {
+	return filepath.Join(s.options.Dir, id)
+}
+
+// lock keeps one request at a time on a session; a second one fails with
+// ErrBusy instead of waiting behind a long upload.
+func (s *Store) lock(id string) (func(), error) {
+	if _, held := s.busy.LoadOrStore(id, struct{}{}); held {
+		return nil, ErrBusy
+	}
+	return func() { s.busy.Delete(id) }, nil
+}
+
+func (s *Store) read(ctx context.Context, id string) (*Session, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	return s.query(ctx, `upload_id = ?`, id)
+}
+
+// findUnfinished returns the unfinished session of owner at targetPath,
+// expired or not.
+func (s *Store) findUnfinished(ctx context.Context, owner, targetPath string) (*Session, error) {
+	return s.query(ctx, `owner = ? AND target_path = ? AND completed_at = 0
+ORDER BY created_at DESC LIMIT 1`, owner, targetPath)
+}
+
+// query reads the first session of the store's origin matching condition.
+func (s *Store) query(ctx context.Context, condition string, args ...any) (*Session, error) {
+	var (
+		session                         Session
+		createdAt, expiresAt, completed int64
+	)
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT upload_id, owner, file_id, file_name, target_path, webdav_scope, metadata, upload_size,
+    block_size, upload_offset, block_count, created_at, expires_at, completed_at
+FROM tg_upload_session_tab WHERE origin = ? AND `+condition,
+		append([]any{s.options.Origin}, args...)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query upload session: %w", err)
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("iterate upload session: %w", err)
+		}
+		return nil, ErrNotFound
+	}
+	if err := rows.Scan(
+		&session.ID,
+		&session.Owner,
+		&session.fileID,
+		&session.FileName,
+		&session.TargetPath,
+		&session.webdavScope,
+		&session.Metadata,
+		&session.Size,
+		&session.blockSize,
+		&session.Offset,
+		&session.blockCount,
+		&createdAt,
+		&expiresAt,
+		&completed,
+	); err != nil {
+		return nil, fmt.Errorf("scan upload session: %w", err)
+	}
+	session.CreatedAt = time.UnixMilli(createdAt).UTC()
+	session.ExpiresAt = time.UnixMilli(expiresAt).UTC()
+	session.Completed = completed != 0
+	return &session, nil
+}
+
+func (s *Store) validateCreateRequest(request CreateRequest) error {
+	if request.Owner == "" {
+		return fmt.Errorf("%w: owner is required", ErrInvalidRequest)
+	}
+	if request.Size < 0 {
+		return fmt.Errorf("%w: length must not be negative", ErrInvalidRequest)
+	}
+	if request.Size > s.options.MaxSize {
+		return fmt.Errorf("%w: %d exceeds %d bytes", ErrTooLarge, request.Size, s.options.MaxSize)
+	}
+	if request.FileName == "" || len(request.FileName) > MaxFileNameBytes {
+		return fmt.Errorf("%w: file name must be 1 to %d bytes", ErrInvalidRequest, MaxFileNameBytes)
+	}
+	if len(request.Metadata) > MaxMetadataBytes {
+		return fmt.Errorf("%w: metadata exceeds %d bytes", ErrInvalidRequest, MaxMetadataBytes)
+	}
+	if request.TargetPath != "" && (request.TargetPath == "/" || !strings.HasPrefix(request.TargetPath, "/") ||
+		path.Clean(request.TargetPath) != request.TargetPath || strings.ContainsRune(request.TargetPath, 0)) {
+		return fmt.Errorf("%w: target path must be a clean absolute file path", ErrInvalidRequest)
+	}
+	if request.TargetPath != "" && (s.webdav == nil || request.WebDAV.Principal == "") {
+		return fmt.Errorf("%w: a target path needs WebDAV and a scope principal", ErrInvalidRequest)
+	}
+	return nil
+}
+
+func resetSpool(spool *os.File, size int64) error {
+	if err := spool.Truncate(size); err != nil {
+		return fmt.Errorf("truncate upload spool: %w", err)
+	}
+	if _, err := spool.Seek(size, io.SeekStart); err != nil {
+		return fmt.Errorf("seek upload spool: %w", err)
+	}
+	return nil
+}
+
+func randomID() (string, error) {
+	raw := make([]byte, idBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate upload session id: %w", err)
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func validID(id string) bool {
+	if len(id) != hex.EncodedLen(idBytes) {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
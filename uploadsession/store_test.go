@@ -0,0 +1,303 @@
+package uploadsession
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xxxsen/tgfile/blockio/mem"
+	"github.com/xxxsen/tgfile/db"
+	"github.com/xxxsen/tgfile/filemgr"
+)
+
+const testBlockSize = 4
+
+var errBrokenBody = errors.New("connection reset")
+
+type brokenReader struct {
+	data []byte
+}
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errBrokenBody
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func newTestStore(t *testing.T) (*Store, filemgr.IFileManager) {
+	t.Helper()
+	database, err := db.Open(filepath.Join(t.TempDir(), "data.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, database.Close())
+	})
+	block, err := mem.New(testBlockSize)
+	require.NoError(t, err)
+	cache, err := filemgr.NewFileIOCache(&filemgr.FileIOCacheConfig{DisableL1Cache: true, DisableL2Cache: true})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, cache.Close(context.Background()))
+	})
+	files := filemgr.NewFileManager(database, block, cache)
+	store, err := New(database, files, Options{Dir: t.TempDir(), Expire: time.Hour, MaxSize: 64})
+	require.NoError(t, err)
+	store.SetWebDAVScopeResolver(webdavGrants{"alice": true})
+	return store, files
+}
+
+// webdavGrants lets the principals it maps to true write anywhere, so a test
+// can take the grant away from a session before it publishes.
+type webdavGrants map[string]bool
+
+func (g webdavGrants) ResolveScope(
+	_ context.Context,
+	scope filemgr.WebDAVScope,
+	_ string,
+) (filemgr.WebDAVMutationOptions, error) {
+	if !g[scope.Principal] {
+		return filemgr.WebDAVMutationOptions{}, filemgr.ErrWebDAVTargetForbidden
+	}
+	return filemgr.WebDAVMutationOptions{Principal: scope.Principal}, nil
+}
+
+func readLink(t *testing.T, files filemgr.IFileManager, link string) string {
+	t.Helper()
+	meta, err := files.StatFileLink(t.Context(), link)
+	require.NoError(t, err)
+	reader, err := files.OpenFile(t.Context(), meta.FileId)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, reader.Close())
+	}()
+	raw, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(raw)
+}
+
+func TestWriteResumesAndPublishesKey(t *testing.T) {
+	store, files := newTestStore(t)
+	session, err := store.Create(t.Context(), CreateRequest{Owner: "alice", FileName: "a.txt", Size: 10})
+	require.NoError(t, err)
+
+	_, err = store.Write(t.Context(), "alice", session.ID, 0, &brokenReader{data: []byte("hello")})
+	require.ErrorIs(t, err, errBrokenBody)
+	session, err = store.Get(t.Context(), "alice", session.ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 5, session.Offset)
+	require.EqualValues(t, 1, session.blockCount)
+
+	_, err = store.Write(t.Context(), "alice", session.ID, 4, strings.NewReader("x"))
+	require.ErrorIs(t, err, ErrOffsetMismatch)
+	_, err = store.Write(t.Context(), "bob", session.ID, 5, strings.NewReader("x"))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	session, err = store.Write(t.Context(), "alice", session.ID, 5, strings.NewReader("world"))
+	require.NoError(t, err)
+	require.True(t, session.Completed)
+	require.NotEmpty(t, session.FileKey)
+	_, err = os.Stat(store.spoolPath(session.ID))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	link, err := files.ResolveFileKey(t.Context(), session.FileKey)
+	require.NoError(t, err)
+	require.Equal(t, "helloworld", readLink(t, files, link))
+	page, err := files.ListFileKeysPage(t.Context(), filemgr.FileKeyPageRequest{Owner: "alice", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	require.Equal(t, link, page.Items[0].Link)
+
+	again, err := store.Get(t.Context(), "alice", session.ID)
+	require.NoError(t, err)
+	require.True(t, again.Completed)
+	require.Empty(t, again.FileKey)
+}
+
+func TestWritePublishesTargetPath(t *testing.T) {
+	store, files := newTestStore(t)
+	require.NoError(t, files.CreateFileLink(t.Context(), "/docs", 0, 0, true))
+	session, err := store.Create(t.Context(), CreateRequest{
+		Owner: "alice", FileName: "b.txt", TargetPath: "/docs/b.txt", Size: 6,
+		WebDAV: filemgr.WebDAVScope{Principal: "alice"},
+	})
+	require.NoError(t, err)
+	session, err = store.Write(t.Context(), "alice", session.ID, 0, strings.NewReader("abcdef"))
+	require.NoError(t, err)
+	require.True(t, session.Completed)
+	require.Empty(t, session.FileKey)
+	require.Equal(t, "abcdef", readLink(t, files, "/docs/b.txt"))
+}
+
+func TestPublishResolvesWebDAVScopeAgain(t *testing.T) {
+	store, files := newTestStore(t)
+	grants := webdavGrants{"alice": true}
+	store.SetWebDAVScopeResolver(grants)
+	require.NoError(t, files.CreateFileLink(t.Context(), "/docs", 0, 0, true))
+	session, err := store.Create(t.Context(), CreateRequest{
+		Owner: "alice", FileName: "d.txt", TargetPath: "/docs/d.txt", Size: 4,
+		WebDAV: filemgr.WebDAVScope{Principal: "alice", Mount: "docs"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, `{"principal":"alice","mount":"docs"}`, session.webdavScope)
+
+	grants["alice"] = false
+	_, err = store.Write(t.Context(), "alice", session.ID, 0, strings.NewReader("abcd"))
+	require.ErrorIs(t, err, filemgr.ErrWebDAVTargetForbidden)
+	_, err = files.StatFileLink(t.Context(), "/docs/d.txt")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	grants["alice"] = true
+	session, err = store.Write(t.Context(), "alice", session.ID, 4, strings.NewReader(""))
+	require.NoError(t, err)
+	require.True(t, session.Completed)
+	require.Equal(t, "abcd", readLink(t, files, "/docs/d.txt"))
+}
+
+func TestResumeKeepsOneSessionPerTarget(t *testing.T) {
+	tus, files := newTestStore(t)
+	store, err := New(tus.db, files, Options{Dir: t.TempDir(), Expire: time.Hour, MaxSize: 64, Origin: OriginWebDAV})
+	require.NoError(t, err)
+	store.SetWebDAVScopeResolver(webdavGrants{"alice": true})
+	require.NoError(t, files.CreateFileLink(t.Context(), "/docs", 0, 0, true))
+	request := CreateRequest{
+		Owner: "alice", FileName: "c.txt", TargetPath: "/docs/c.txt", Size: 6,
+		WebDAV: filemgr.WebDAVScope{Principal: "alice"},
+	}
+	first, err := store.Resume(t.Context(), request)
+	require.NoError(t, err)
+	_, err = store.Write(t.Context(), "alice", first.ID, 0, strings.NewReader("abcde"))
+	require.NoError(t, err)
+
+	resumed, err := store.Resume(t.Context(), request)
+	require.NoError(t, err)
+	require.Equal(t, first.ID, resumed.ID)
+	require.EqualValues(t, 5, resumed.Offset)
+	_, err = tus.Get(t.Context(), "alice", first.ID)
+	require.ErrorIs(t, err, ErrNotFound, "stores of another origin do not share sessions")
+	_, err = store.Find(t.Context(), "bob", "/docs/c.txt")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	request.Size = 4
+	restarted, err := store.Resume(t.Context(), request)
+	require.NoError(t, err)
+	require.NotEqual(t, first.ID, restarted.ID)
+	_, err = store.read(t.Context(), first.ID)
+	require.ErrorIs(t, err, ErrNotFound)
+	restarted, err = store.Write(t.Context(), "alice", restarted.ID, 0, strings.NewReader("wxyz"))
+	require.NoError(t, err)
+	require.True(t, restarted.Completed)
+	require.True(t, restarted.Published.Created)
+	require.Equal(t, "wxyz", readLink(t, files, "/docs/c.txt"))
+	_, err = store.Find(t.Context(), "alice", "/docs/c.txt")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestWriteRejectsBytesBeyondLength(t *testing.T) {
+	store, _ := newTestStore(t)
+	session, err := store.Create(t.Context(), CreateRequest{Owner: "alice", FileName: "c.txt", Size: 3})
+	require.NoError(t, err)
+	_, err = store.Write(t.Context(), "alice", session.ID, 0, strings.NewReader("abcd"))
+	require.ErrorIs(t, err, ErrTooLarge)
+	session, err = store.Get(t.Context(), "alice", session.ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, session.Offset)
+	require.False(t, session.Completed)
+
+	session, err = store.Write(t.Context(), "alice", session.ID, 3, bytes.NewReader(nil))
+	require.NoError(t, err)
+	require.True(t, session.Completed)
+}
+
+func TestCreateValidatesRequests(t *testing.T) {
+	store, _ := newTestStore(t)
+	for _, request := range []CreateRequest{
+		{FileName: "a", Size: 1},
+		{Owner: "alice", Size: 1},
+		{Owner: "alice", FileName: "a", Size: -1},
+		{Owner: "alice", FileName: "a", TargetPath: "docs/a"},
+		{Owner: "alice", FileName: "a", TargetPath: "/docs/../a"},
+		{Owner: "alice", FileName: "a", TargetPath: "/docs/a"},
+		{Owner: "alice", FileName: "a", Metadata: strings.Repeat("m", MaxMetadataBytes+1)},
+	} {
+		_, err := store.Create(t.Context(), request)
+		require.ErrorIs(t, err, ErrInvalidRequest, request)
+	}
+	_, err := store.Create(t.Context(), CreateRequest{Owner: "alice", FileName: "a", Size: 65})
+	require.ErrorIs(t, err, ErrTooLarge)
+
+	empty, err := store.Create(t.Context(), CreateRequest{Owner: "alice", FileName: "empty", Size: 0})
+	require.NoError(t, err)
+	require.True(t, empty.Completed)
+	require.NotEmpty(t, empty.FileKey)
+}
+
+func TestExpireDiscardsIdleSessions(t *testing.T) {
+	store, _ := newTestStore(t)
+	now := time.UnixMilli(1_700_000_000_000)
+	store.now = func() time.Time { return now }
+	idle, err := store.Create(t.Context(), CreateRequest{Owner: "alice", FileName: "idle", Size: 8})
+	require.NoError(t, err)
+	_, err = store.Write(t.Context(), "alice", idle.ID, 0, strings.NewReader("ab"))
+	require.NoError(t, err)
+	done, err := store.Create(t.Context(), CreateRequest{Owner: "alice", FileName: "done", Size: 2})
+	require.NoError(t, err)
+	_, err = store.Write(t.Context(), "alice", done.ID, 0, strings.NewReader("ok"))
+	require.NoError(t, err)
+
+	now = now.Add(time.Hour)
+	_, err = store.Get(t.Context(), "alice", idle.ID)
+	require.ErrorIs(t, err, ErrNotFound)
+	removed, err := store.expire(t.Context())
+	require.NoError(t, err)
+	require.Equal(t, 2, removed)
+	_, err = os.Stat(store.spoolPath(idle.ID))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = store.read(t.Context(), done.ID)
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestDeleteAndBusySessions(t *testing.T) {
+	store, _ := newTestStore(t)
+	session, err := store.Create(t.Context(), CreateRequest{Owner: "alice", FileName: "d", Size: 8})
+	require.NoError(t, err)
+	unlock, err := store.lock(session.ID)
+	require.NoError(t, err)
+	_, err = store.Write(t.Context(), "alice", session.ID, 0, strings.NewReader("a"))
+	require.ErrorIs(t, err, ErrBusy)
+	require.ErrorIs(t, store.Delete(t.Context(), "alice", session.ID), ErrBusy)
+	unlock()
+
+	require.ErrorIs(t, store.Delete(t.Context(), "bob", session.ID), ErrNotFound)
+	require.NoError(t, store.Delete(t.Context(), "alice", session.ID))
+	_, err = store.Get(t.Context(), "alice", session.ID)
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestWriteKeepsBlockStoredBeforeCrash(t *testing.T) {
+	store, files := newTestStore(t)
+	session, err := store.Create(t.Context(), CreateRequest{Owner: "alice", FileName: "e.txt", Size: 6})
+	require.NoError(t, err)
+	_, err = store.Write(t.Context(), "alice", session.ID, 0, strings.NewReader("ab"))
+	require.NoError(t, err)
+	// The first block reached the backend, but the offset was not committed.
+	session, err = store.Get(t.Context(), "alice", session.ID)
+	require.NoError(t, err)
+	require.NoError(t, files.CreateFilePart(t.Context(), session.fileID, 0, strings.NewReader("abcd")))
+
+	session, err = store.Write(t.Context(), "alice", session.ID, 2, strings.NewReader("cdef"))
+	require.NoError(t, err)
+	require.True(t, session.Completed)
+	link, err := files.ResolveFileKey(t.Context(), session.FileKey)
+	require.NoError(t, err)
+	require.Equal(t, "abcdef", readLink(t, files, link))
+}